@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/internal/app"
+	"github.com/agalitsyn/goapi/pkg/backup"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/module"
+)
+
+// backupCommand dumps data of database configured with the service options. Archive is written to
+// stdout by default, so it can be streamed to object storage without a local copy, e.g.
+//
+//	goapi --postgres-url postgres://... backup | aws s3 cp - s3://backups/goapi.tar.gz
+type backupCommand struct {
+	Output string `long:"output" short:"o" default:"-" description:"Path to archive, - writes to stdout."`
+
+	// cfg is set before parsing, database options are shared with the service
+	cfg *cliFlags
+}
+
+func (c *backupCommand) Execute(args []string) error {
+	logger := log.New(c.cfg.Log.Format, c.cfg.Log.Level, os.Stderr)
+	db, err := app.ConnectDatabase(&c.cfg.Config, logger, module.All())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if c.Output != "-" {
+		f, err := os.Create(c.Output)
+		if err != nil {
+			return errors.Wrap(err, "could not create archive")
+		}
+		defer f.Close()
+		w = f
+	}
+
+	m, err := backup.Dump(db.DB, w)
+	if err != nil {
+		return err
+	}
+	for _, t := range m.Tables {
+		fmt.Fprintf(os.Stderr, "%s: %d rows\n", t.Name, t.Rows)
+	}
+	return nil
+}
+
+// restoreCommand replaces data of database with archive made by backup, e.g.
+//
+//	aws s3 cp s3://backups/goapi.tar.gz - | goapi --postgres-url postgres://... restore
+type restoreCommand struct {
+	Input string `long:"input" short:"i" default:"-" description:"Path to archive, - reads from stdin."`
+	Force bool   `long:"force" description:"Replace existing data, restore fails if any table is not empty otherwise."`
+
+	// cfg is set before parsing, database options are shared with the service
+	cfg *cliFlags
+}
+
+func (c *restoreCommand) Execute(args []string) error {
+	logger := log.New(c.cfg.Log.Format, c.cfg.Log.Level, os.Stderr)
+	db, err := app.ConnectDatabase(&c.cfg.Config, logger, module.All())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var r io.Reader = os.Stdin
+	if c.Input != "-" {
+		f, err := os.Open(c.Input)
+		if err != nil {
+			return errors.Wrap(err, "could not open archive")
+		}
+		defer f.Close()
+		r = f
+	}
+
+	m, err := backup.Restore(db.DB, r, c.Force)
+	if err != nil {
+		return err
+	}
+	for _, t := range m.Tables {
+		fmt.Fprintf(os.Stderr, "%s: %d rows\n", t.Name, t.Rows)
+	}
+	fmt.Fprintf(os.Stderr, "restored backup made at %s\n", m.CreatedAt.Format("2006-01-02 15:04:05"))
+	return nil
+}
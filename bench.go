@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/bench"
+)
+
+// benchCommand drives a request mix against a running instance, e.g.
+//
+//	goapi bench --url http://localhost:5000 --request "3*GET /1.0/articles" --request "GET /1.0/articles/stats"
+type benchCommand struct {
+	URL          string        `long:"url" default:"http://localhost:5000" description:"Base URL of the instance under test."`
+	Requests     []string      `long:"request" description:"Request of the mix in form [weight*]METHOD path, e.g. 3*GET /1.0/articles."`
+	RequestsFile string        `long:"requests" description:"Path to requests of the mix in JSON lines format, e.g. {\"method\": \"POST\", \"path\": \"/1.0/articles\", \"body\": {...}, \"weight\": 2}."`
+	Headers      []string      `long:"header" description:"Header sent with every request in form name: value."`
+	Concurrency  int           `long:"concurrency" default:"10" description:"Number of concurrent clients."`
+	Duration     time.Duration `long:"duration" default:"30s" description:"Duration of the run."`
+	Count        int           `long:"count" description:"Stop after this many requests, 0 means no limit."`
+	Timeout      time.Duration `long:"timeout" default:"10s" description:"Timeout of a single request."`
+}
+
+func (c *benchCommand) Execute(args []string) error {
+	cfg := bench.Config{
+		URL:         c.URL,
+		Header:      http.Header{},
+		Concurrency: c.Concurrency,
+		Duration:    c.Duration,
+		Count:       c.Count,
+		Timeout:     c.Timeout,
+	}
+	for _, s := range c.Requests {
+		req, err := bench.ParseRequest(s)
+		if err != nil {
+			return err
+		}
+		cfg.Requests = append(cfg.Requests, req)
+	}
+	if c.RequestsFile != "" {
+		f, err := os.Open(c.RequestsFile)
+		if err != nil {
+			return errors.Wrap(err, "could not open requests file")
+		}
+		defer f.Close()
+		reqs, err := bench.ReadRequests(f)
+		if err != nil {
+			return err
+		}
+		cfg.Requests = append(cfg.Requests, reqs...)
+	}
+	for _, h := range c.Headers {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 {
+			return errors.Errorf("invalid header %q, expected name: value", h)
+		}
+		cfg.Header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	report, err := bench.Run(cfg)
+	if err != nil {
+		return err
+	}
+	return report.Write(os.Stdout)
+}
@@ -0,0 +1,224 @@
+// Command perfcompare replays a recorded request set against two deployments
+// and prints a latency and allocation comparison report.
+//
+//	perfcompare --requests requests.jsonl \
+//	    --base http://old:5000 --base-debug http://old:6060 \
+//	    --target http://new:5000 --target-debug http://new:6060
+//
+// Each line of the requests file is a JSON object: {"method": "GET", "path": "/1.0/articles", "body": {...}}.
+// Allocations are taken from expvar memstats, so debug endpoints (see --debug-addr) must be enabled.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/pkg/errors"
+)
+
+type cliFlags struct {
+	Requests    string        `long:"requests" required:"true" description:"Path to recorded requests in JSON lines format."`
+	Base        string        `long:"base" required:"true" description:"Base URL of the reference deployment."`
+	BaseDebug   string        `long:"base-debug" description:"Debug URL of the reference deployment for allocation stats."`
+	Target      string        `long:"target" required:"true" description:"Base URL of the deployment under test."`
+	TargetDebug string        `long:"target-debug" description:"Debug URL of the deployment under test for allocation stats."`
+	Iterations  int           `long:"iterations" default:"20" description:"How many times every request is replayed."`
+	Timeout     time.Duration `long:"timeout" default:"10s" description:"Timeout of a single request."`
+}
+
+type recordedRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+func (r recordedRequest) String() string {
+	return r.Method + " " + r.Path
+}
+
+type environment struct {
+	url      string
+	debugURL string
+}
+
+type result struct {
+	latencies []time.Duration
+	errors    int
+	mallocs   uint64
+	bytes     uint64
+}
+
+func main() {
+	var cfg cliFlags
+	if _, err := flags.Parse(&cfg); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+	// allocations are averaged over iterations
+	if cfg.Iterations < 1 {
+		fmt.Fprintln(os.Stderr, "--iterations must be positive")
+		os.Exit(1)
+	}
+
+	reqs, err := readRequests(cfg.Requests)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	client := &http.Client{Timeout: cfg.Timeout}
+	base := environment{url: cfg.Base, debugURL: cfg.BaseDebug}
+	target := environment{url: cfg.Target, debugURL: cfg.TargetDebug}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REQUEST\tP50 BASE\tP50 TARGET\tP99 BASE\tP99 TARGET\tDELTA P50\tALLOCS/OP BASE\tALLOCS/OP TARGET\tB/OP BASE\tB/OP TARGET\tERRORS")
+	for _, req := range reqs {
+		// interleave environments, so both are affected by the same background noise
+		var br, tr result
+		for i := 0; i < cfg.Iterations; i++ {
+			if err := replay(client, base, req, &br); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if err := replay(client, target, req, &tr); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+
+		bp50, tp50 := percentile(br.latencies, 50), percentile(tr.latencies, 50)
+		fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%v\t%+.1f%%\t%s\t%s\t%s\t%s\t%d/%d\n",
+			req,
+			bp50, tp50,
+			percentile(br.latencies, 99), percentile(tr.latencies, 99),
+			delta(bp50, tp50),
+			perOp(br.mallocs, cfg.Iterations, base.debugURL), perOp(tr.mallocs, cfg.Iterations, target.debugURL),
+			perOp(br.bytes, cfg.Iterations, base.debugURL), perOp(tr.bytes, cfg.Iterations, target.debugURL),
+			br.errors, tr.errors,
+		)
+	}
+	w.Flush()
+}
+
+func readRequests(path string) ([]recordedRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open requests file")
+	}
+	defer f.Close()
+
+	var reqs []recordedRequest
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		var r recordedRequest
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			return nil, errors.Wrapf(err, "could not parse request on line %d", line)
+		}
+		reqs = append(reqs, r)
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read requests file")
+	}
+	return reqs, nil
+}
+
+func replay(client *http.Client, env environment, req recordedRequest, res *result) error {
+	before, err := memStats(client, env.debugURL)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if len(req.Body) > 0 {
+		body = bytes.NewReader(req.Body)
+	}
+	r, err := http.NewRequest(req.Method, env.url+req.Path, body)
+	if err != nil {
+		return errors.Wrapf(err, "could not build request %s", req)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(r)
+	if err != nil {
+		res.errors++
+		return nil
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	res.latencies = append(res.latencies, time.Since(start))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		res.errors++
+	}
+
+	after, err := memStats(client, env.debugURL)
+	if err != nil {
+		return err
+	}
+	res.mallocs += after.Mallocs - before.Mallocs
+	res.bytes += after.TotalAlloc - before.TotalAlloc
+	return nil
+}
+
+type memstats struct {
+	Mallocs    uint64
+	TotalAlloc uint64
+}
+
+// memStats reads runtime allocation counters exposed by expvar.
+// Counters include the cost of serving expvar itself, which is the same for both environments.
+func memStats(client *http.Client, debugURL string) (memstats, error) {
+	if debugURL == "" {
+		return memstats{}, nil
+	}
+	resp, err := client.Get(debugURL + "/debug/vars")
+	if err != nil {
+		return memstats{}, errors.Wrap(err, "could not get memstats")
+	}
+	defer resp.Body.Close()
+
+	var vars struct {
+		Memstats memstats `json:"memstats"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		return memstats{}, errors.Wrap(err, "could not decode memstats")
+	}
+	return vars.Memstats, nil
+}
+
+func percentile(latencies []time.Duration, p int) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+func delta(base, target time.Duration) float64 {
+	if base == 0 {
+		return 0
+	}
+	return float64(target-base) / float64(base) * 100
+}
+
+func perOp(total uint64, iterations int, debugURL string) string {
+	if debugURL == "" {
+		return "-"
+	}
+	return fmt.Sprintf("%d", total/uint64(iterations))
+}
@@ -0,0 +1,11 @@
+//go:build !go1.16
+// +build !go1.16
+
+package main
+
+import "net/http"
+
+// embeddedDocs serves docs folder relative to working directory, toolchains before go1.16 can not embed it.
+func embeddedDocs() http.FileSystem {
+	return http.Dir("docs")
+}
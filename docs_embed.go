@@ -0,0 +1,22 @@
+//go:build go1.16
+// +build go1.16
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed docs
+var docsFS embed.FS
+
+// embeddedDocs returns docs folder compiled into the binary.
+func embeddedDocs() http.FileSystem {
+	sub, err := fs.Sub(docsFS, "docs")
+	if err != nil {
+		panic(err)
+	}
+	return http.FS(sub)
+}
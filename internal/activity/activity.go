@@ -0,0 +1,155 @@
+// Package activity records what users do, e.g. create and publish articles, and serves activity feeds
+// of users and of articles.
+package activity
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+// Verbs of activities.
+const (
+	VerbCreated   = "created"
+	VerbUpdated   = "updated"
+	VerbPublished = "published"
+	VerbDeleted   = "deleted"
+	VerbCommented = "commented"
+)
+
+// Verbs lists all verbs.
+var Verbs = []string{VerbCreated, VerbUpdated, VerbPublished, VerbDeleted, VerbCommented}
+
+func validVerb(v string) bool {
+	for _, verb := range Verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+// ObjectArticle is a type of article objects.
+const ObjectArticle = "article"
+
+// Activity is a verb actor did to object, e.g. user published article.
+type Activity struct {
+	ID string `json:"id"`
+	// ActorID is empty for anonymous actors.
+	ActorID    string `json:"actor_id"`
+	Verb       string `json:"verb"`
+	ObjectType string `json:"object_type"`
+	ObjectID   string `json:"object_id"`
+	// Title is a title of object at the time of activity, so feeds need no objects, which may be deleted.
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Query selects feed, activities are filtered by actor or by object, and by verbs unless empty.
+type Query struct {
+	ActorID    string
+	ObjectType string
+	ObjectID   string
+	Verbs      []string
+	// Before is a cursor, activities older than it are returned.
+	Before int64
+	Limit  int
+}
+
+type Manager struct {
+	db postgres.Querier
+}
+
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{db: db}
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db)}
+}
+
+func (m *Manager) Record(a *Activity) error {
+	err := m.db.QueryRow(
+		"INSERT INTO activity(actor_id, verb, object_type, object_id, title) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at;",
+		a.ActorID, a.Verb, a.ObjectType, a.ObjectID, a.Title,
+	).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return errors.Wrap(err, "could not record activity")
+	}
+	return nil
+}
+
+// Feed returns activities matching query, the latest first.
+func (m *Manager) Feed(q Query) ([]*Activity, error) {
+	query := "SELECT id, actor_id, verb, object_type, object_id, title, created_at FROM activity WHERE "
+	var args []interface{}
+	if q.ActorID != "" {
+		args = append(args, q.ActorID)
+		query += "actor_id = $1"
+	} else {
+		args = append(args, q.ObjectType, q.ObjectID)
+		query += "object_type = $1 AND object_id = $2"
+	}
+	if len(q.Verbs) > 0 {
+		args = append(args, pq.Array(q.Verbs))
+		query += " AND verb = ANY($" + strconv.Itoa(len(args)) + ")"
+	}
+	if q.Before > 0 {
+		args = append(args, q.Before)
+		query += " AND id < $" + strconv.Itoa(len(args))
+	}
+	args = append(args, q.Limit)
+	query += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args)) + ";"
+
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get activities")
+	}
+	defer rows.Close()
+
+	return scanAll(rows)
+}
+
+// ByActor returns all activities of user.
+func (m *Manager) ByActor(actorID string) ([]*Activity, error) {
+	rows, err := m.db.Query(
+		"SELECT id, actor_id, verb, object_type, object_id, title, created_at FROM activity WHERE actor_id = $1 ORDER BY id;",
+		actorID,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get activities of user")
+	}
+	defer rows.Close()
+
+	return scanAll(rows)
+}
+
+// Anonymize removes actor of activities, so feeds of objects keep what happened, but not who did it.
+func (m *Manager) Anonymize(actorID string) error {
+	if _, err := m.db.Exec("UPDATE activity SET actor_id = '' WHERE actor_id = $1;", actorID); err != nil {
+		return errors.Wrap(err, "could not anonymize activities of user")
+	}
+	return nil
+}
+
+func scanAll(rows *sql.Rows) ([]*Activity, error) {
+	activities := []*Activity{}
+	for rows.Next() {
+		var a Activity
+		if err := rows.Scan(&a.ID, &a.ActorID, &a.Verb, &a.ObjectType, &a.ObjectID, &a.Title, &a.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan row to activity model")
+		}
+		activities = append(activities, &a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get activities")
+	}
+	return activities, nil
+}
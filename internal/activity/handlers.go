@@ -0,0 +1,135 @@
+package activity
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// Routes serve activity feeds of users and articles, feeds accept ?type=created,published to filter
+// by verbs, ?limit= and ?cursor= of the next page.
+func Routes(m *Manager) chi.Router {
+	r := chi.NewRouter()
+	r.Get("/users/{userID}", makeHandler(m, userHandler))
+	r.Get("/articles/{articleID}", makeHandler(m, articleHandler))
+	return r
+}
+
+type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m.WithContext(r.Context()), w, r)
+	}
+}
+
+// userHandler serves activity of user, "me" is the signed-in user. Admins may see activity of any user,
+// others see their own.
+func userHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "activity")
+
+	u := reqctx.GetUser(r.Context())
+	if u == nil {
+		err := i18n.Errorf("activity.user_required")
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrUnauthorized(err))
+		return
+	}
+	userID := chi.URLParam(r, "userID")
+	if userID == "me" {
+		userID = u.ID
+	}
+	if userID != u.ID && !u.HasRole(reqctx.AdminRole) {
+		err := i18n.Errorf("activity.forbidden")
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrForbidden(err))
+		return
+	}
+
+	q, err := parseQuery(r)
+	if err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	q.ActorID = userID
+	feed(m, q, w, r)
+}
+
+// articleHandler serves activity of article, it is kept after article is deleted.
+func articleHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "activity")
+
+	q, err := parseQuery(r)
+	if err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	q.ObjectType, q.ObjectID = ObjectArticle, chi.URLParam(r, "articleID")
+	feed(m, q, w, r)
+}
+
+func feed(m *Manager, q Query, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "activity")
+
+	activities, err := m.Feed(q)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	resp := &feedResponse{Activities: activities}
+	// a full page may be followed by another one
+	if len(activities) == q.Limit {
+		resp.Cursor = activities[len(activities)-1].ID
+	}
+	render.Render(w, r, resp)
+}
+
+// parseQuery reads ?type=, ?limit= and ?cursor=.
+func parseQuery(r *http.Request) (Query, error) {
+	q := Query{Limit: 20}
+	if v := r.URL.Query().Get("type"); v != "" {
+		for _, verb := range strings.Split(v, ",") {
+			verb = strings.TrimSpace(verb)
+			if !validVerb(verb) {
+				return q, i18n.Errorf("activity.invalid_type", verb, strings.Join(Verbs, ", "))
+			}
+			q.Verbs = append(q.Verbs, verb)
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return q, i18n.Errorf("request.invalid_param", "limit", 1, 100)
+		}
+		q.Limit = n
+	}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return q, i18n.Errorf("activity.invalid_cursor", v)
+		}
+		q.Before = n
+	}
+	return q, nil
+}
+
+type feedResponse struct {
+	Activities []*Activity `json:"activities"`
+	// Cursor is passed as ?cursor= to get the next page, it is empty on the last page.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+func (fr *feedResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,97 @@
+package activity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var activityColumns = []string{"id", "actor_id", "verb", "object_type", "object_id", "title", "created_at"}
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	newRouter := func(u *reqctx.User) http.Handler {
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(u))
+		r.Mount("/activity", Routes(NewManager(db)))
+		return r
+	}
+	r := newRouter(&reqctx.User{ID: "u1"})
+
+	// a full page has cursor of the next one
+	mock.ExpectQuery("SELECT (.+) FROM activity WHERE object_type = \\$1 AND object_id = \\$2 AND verb = ANY\\(\\$3\\) AND id < \\$4 ORDER BY id DESC LIMIT \\$5;").
+		WithArgs(ObjectArticle, "1", `{"created","published"}`, 100, 2).
+		WillReturnRows(sqlmock.NewRows(activityColumns).
+			AddRow(12, "u1", "published", "article", "1", "Новая", time.Now()).
+			AddRow(10, "u1", "created", "article", "1", "Новая", time.Now()))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/activity/articles/1?type=created,published&limit=2&cursor=100", nil))
+	var resp feedResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(resp.Activities) != 2 || resp.Cursor != "10" {
+		t.Errorf("unexpected response: %v %+v", w.Code, resp)
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM activity WHERE actor_id = \\$1 ORDER BY id DESC LIMIT \\$2;").
+		WithArgs("u1", 20).
+		WillReturnRows(sqlmock.NewRows(activityColumns).AddRow(12, "u1", "published", "article", "1", "Новая", time.Now()))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/activity/users/me", nil))
+	resp = feedResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(resp.Activities) != 1 || resp.Cursor != "" {
+		t.Errorf("unexpected response: %v %+v", w.Code, resp)
+	}
+
+	tests := []struct {
+		router http.Handler
+		url    string
+		status int
+	}{
+		{r, "/activity/users/u2", http.StatusForbidden},
+		{newRouter(nil), "/activity/users/me", http.StatusUnauthorized},
+		{r, "/activity/articles/1?type=liked", http.StatusBadRequest},
+		{r, "/activity/articles/1?cursor=abc", http.StatusBadRequest},
+		{r, "/activity/articles/1?limit=1000", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com"+tt.url, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: unexpected status: %v", tt.url, w.Code)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
@@ -0,0 +1,18 @@
+package activity
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"activity.user_required":  "sign in to see activity",
+		"activity.forbidden":      "activity of other users is available to admins only",
+		"activity.invalid_type":   "unknown type of activity %s, types are: %s",
+		"activity.invalid_cursor": "invalid cursor %q of activity",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"activity.user_required":  "войдите, чтобы видеть активность",
+		"activity.forbidden":      "активность других пользователей доступна только администраторам",
+		"activity.invalid_type":   "неизвестный тип активности %s, доступные типы: %s",
+		"activity.invalid_cursor": "неверный курсор активности %q",
+	})
+}
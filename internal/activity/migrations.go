@@ -0,0 +1,26 @@
+package activity
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "0023_activity",
+			Up: []string{
+				`CREATE TABLE activity (
+					id           BIGSERIAL                   NOT NULL,
+					actor_id     character varying(128)      NOT NULL DEFAULT '',
+					verb         character varying(32)       NOT NULL,
+					object_type  character varying(32)       NOT NULL,
+					object_id    character varying(64)       NOT NULL,
+					title        text                        NOT NULL DEFAULT '',
+					created_at   timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id)
+				);`,
+				`CREATE INDEX activity_actor_idx ON activity (actor_id, id DESC) WHERE actor_id <> '';`,
+				`CREATE INDEX activity_object_idx ON activity (object_type, object_id, id DESC);`,
+				`CREATE INDEX activity_created_at_idx ON activity (created_at);`,
+			},
+		},
+	}
+}
@@ -0,0 +1,53 @@
+package activity
+
+import (
+	"net/http"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/internal/article"
+	"github.com/agalitsyn/goapi/internal/privacy"
+	"github.com/agalitsyn/goapi/pkg/module"
+	"github.com/agalitsyn/goapi/pkg/retention"
+)
+
+func init() {
+	module.Register(&activityModule{})
+}
+
+type activityModule struct {
+	module.Base
+
+	manager *Manager
+}
+
+func (mod *activityModule) Name() string                     { return "activity" }
+func (mod *activityModule) Migrations() []*migrate.Migration { return Migrations() }
+
+// Init provides manager as activity.manager for modules recording their activities, event handler of
+// articles as article.event_handler.activity and privacy handler as privacy.handler.activity.
+func (mod *activityModule) Init(env *module.Env) error {
+	mod.manager = NewManager(env.DB)
+	env.Provide("activity.manager", mod.manager)
+	env.Provide(article.EventHandlerPrefix+"activity", article.EventHandler(func(e article.Event) error {
+		return mod.manager.Record(&Activity{
+			ActorID:    e.ActorID,
+			Verb:       e.Action,
+			ObjectType: ObjectArticle,
+			ObjectID:   e.Article.ID,
+			Title:      e.Article.Title,
+		})
+	}))
+	env.Provide(privacy.HandlerPrefix+"activity", &privacyHandler{m: mod.manager})
+	return nil
+}
+
+// RetentionRules keep activity for a year.
+func (mod *activityModule) RetentionRules() []retention.Rule {
+	return []retention.Rule{{Name: "days", Table: "activity", Column: "created_at", MaxAge: 365 * 24 * time.Hour}}
+}
+
+func (mod *activityModule) Routes() map[string]http.Handler {
+	return map[string]http.Handler{"/activity": Routes(mod.manager)}
+}
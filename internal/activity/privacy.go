@@ -0,0 +1,22 @@
+package activity
+
+import "github.com/agalitsyn/goapi/internal/privacy"
+
+// privacyHandler exports activity of users, erasure leaves activity in feeds of objects without actor.
+type privacyHandler struct {
+	m *Manager
+}
+
+func (h *privacyHandler) Export(s privacy.Subject) (interface{}, error) {
+	if s.User == "" {
+		return []*Activity{}, nil
+	}
+	return h.m.ByActor(s.User)
+}
+
+func (h *privacyHandler) Erase(s privacy.Subject) error {
+	if s.User == "" {
+		return nil
+	}
+	return h.m.Anonymize(s.User)
+}
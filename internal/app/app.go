@@ -0,0 +1,966 @@
+// Package app is the composition root of the service.
+//
+// New builds components from config in dependency order: reporter, database, modules, background
+// workers and router. Dependencies passed in Deps are used as is instead, so tests can compose the
+// app with a mocked database or a subset of modules. Components register lifecycle hooks, Start runs
+// workers and HTTP servers, and Stop stops servers, then workers, then database.
+package app
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"database/sql"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/internal/dbpool"
+	"github.com/agalitsyn/goapi/internal/health"
+	"github.com/agalitsyn/goapi/internal/leader"
+	"github.com/agalitsyn/goapi/internal/links"
+	"github.com/agalitsyn/goapi/internal/maintenance"
+	"github.com/agalitsyn/goapi/internal/purge"
+	"github.com/agalitsyn/goapi/internal/sitemap"
+	"github.com/agalitsyn/goapi/pkg/authz"
+	"github.com/agalitsyn/goapi/pkg/budget"
+	"github.com/agalitsyn/goapi/pkg/chaos"
+	"github.com/agalitsyn/goapi/pkg/crypto"
+	"github.com/agalitsyn/goapi/pkg/diagnostics"
+	"github.com/agalitsyn/goapi/pkg/dlock"
+	"github.com/agalitsyn/goapi/pkg/egress"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/ipfilter"
+	"github.com/agalitsyn/goapi/pkg/jwtauth"
+	"github.com/agalitsyn/goapi/pkg/lifecycle"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/module"
+	"github.com/agalitsyn/goapi/pkg/mtls"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+	"github.com/agalitsyn/goapi/pkg/ratelimit"
+	"github.com/agalitsyn/goapi/pkg/realip"
+	"github.com/agalitsyn/goapi/pkg/redact"
+	"github.com/agalitsyn/goapi/pkg/redis"
+	"github.com/agalitsyn/goapi/pkg/report"
+	"github.com/agalitsyn/goapi/pkg/retention"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+	"github.com/agalitsyn/goapi/pkg/shadow"
+	"github.com/agalitsyn/goapi/pkg/signedurl"
+	"github.com/agalitsyn/goapi/pkg/surrogate"
+	"github.com/agalitsyn/goapi/pkg/workerpool"
+)
+
+// PurgeChannel is Redis channel purges of response cache are broadcast to.
+const PurgeChannel = "goapi.surrogate.purge"
+
+// Names of lifecycle hooks.
+const (
+	HookReporter    = "reporter"
+	HookDatabase    = "database"
+	HookProbe       = "database-probe"
+	HookSitemap     = "sitemap"
+	HookMaintenance = "maintenance"
+	HookShadow      = "shadow"
+	HookLeader      = "leader"
+	HookRedis       = "redis"
+	HookPurges      = "purges"
+	HookDiagnostics = "diagnostics"
+	HookHTTP        = "http"
+	HookDebugHTTP   = "debug-http"
+)
+
+// Deps override components which are built from config otherwise.
+type Deps struct {
+	Reporter report.Reporter
+	// DB is not migrated, it is up to caller.
+	DB *sql.DB
+	// Modules are registered modules by default.
+	Modules []module.Module
+	// Docs are served at /docs unless DocsPath is configured, none are served if nil.
+	Docs http.FileSystem
+}
+
+// App holds components of the service.
+type App struct {
+	Config   *Config
+	Logger   *log.StructuredLogger
+	Reporter report.Reporter
+	DB       *sql.DB
+	Modules  []module.Module
+	// Handler serves public API.
+	Handler http.Handler
+
+	env         *module.Env
+	sitemap     *sitemap.Sitemap
+	maintenance *maintenance.Mode
+	shadow      *shadow.Mirror
+	diagnostics *diagnostics.Trigger
+	// pool is nil when database is passed in deps
+	pool *postgres.Pool
+	// locker is nil when database is passed in deps, singleton jobs run on every replica then
+	locker *dlock.Locker
+	// elector is nil without locker
+	elector *leader.Elector
+	// redis is nil unless configured
+	redis *redis.Client
+	// responses is nil when response cache is disabled
+	responses *surrogate.Cache
+	purger    surrogate.Purgers
+
+	docs http.FileSystem
+	lc   *lifecycle.Manager
+	errc chan error
+}
+
+// New builds app, components built before a failure are released.
+func New(cfg *Config, logger *log.StructuredLogger, deps Deps) (*App, error) {
+	a := &App{
+		Config:   cfg,
+		Logger:   logger,
+		Reporter: deps.Reporter,
+		DB:       deps.DB,
+		Modules:  deps.Modules,
+		docs:     deps.Docs,
+		lc:       lifecycle.New(cfg.Lifecycle.Timeout, logger),
+		errc:     make(chan error, 2),
+	}
+	if err := a.build(); err != nil {
+		a.lc.Stop(context.Background())
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *App) build() error {
+	if a.Reporter == nil {
+		reporter, err := NewReporter(a.Config, a.Logger)
+		if err != nil {
+			return err
+		}
+		a.Reporter = reporter
+		a.lc.Append(lifecycle.Hook{Name: HookReporter, Stop: func(ctx context.Context) error { return reporter.Close() }})
+	}
+
+	var routeRules []log.RouteRule
+	for _, rr := range a.Config.Log.Routes {
+		rule, err := log.ParseRouteRule(rr)
+		if err != nil {
+			return err
+		}
+		routeRules = append(routeRules, rule)
+	}
+	a.Logger.SetFields(a.Config.PodFields())
+	a.Logger.SetRouteRules(routeRules)
+
+	if a.Modules == nil {
+		a.Modules = module.All()
+	}
+	// database passed in deps is closed by caller
+	dbHook := lifecycle.Hook{Name: HookDatabase}
+	var db *postgres.Database
+	if a.DB == nil {
+		var err error
+		db, err = ConnectDatabase(a.Config, a.Logger, a.Modules)
+		if err != nil {
+			return err
+		}
+		a.DB = db.DB
+		a.pool = db.Pool
+		a.locker = db.Locker(dlock.Config{})
+		dbHook.Stop = func(ctx context.Context) error { return db.Close() }
+	}
+	a.lc.Append(dbHook)
+	// HTTP servers are stopped before workers, so requests in flight can still use them
+	var workers []string
+	if db != nil && a.Config.Postgres.ProbeInterval > 0 {
+		prober := db.Prober(a.Config.Postgres.ProbeInterval, a.Config.Postgres.ProbeFailures)
+		a.lc.Append(jobsHook(HookProbe, []module.Job{prober}, a.Logger))
+		workers = append(workers, HookProbe)
+	}
+
+	keys, err := NewKeyring(a.Config)
+	if err != nil {
+		return err
+	}
+	policy, err := egress.New(egress.Config{
+		AllowHosts:    a.Config.Egress.AllowHosts,
+		DenyHosts:     a.Config.Egress.DenyHosts,
+		AllowNetworks: a.Config.Egress.AllowNetworks,
+		DenyNetworks:  a.Config.Egress.DenyNetworks,
+	})
+	if err != nil {
+		return err
+	}
+	a.env = &module.Env{
+		DB:       a.DB,
+		Logger:   a.Logger,
+		BaseURL:  a.Config.Sitemap.BaseURL,
+		Keys:     keys,
+		Egress:   policy,
+		Redactor: redact.New(a.Config.Log.Redact...),
+	}
+	if a.Config.JWT.Secret != "" {
+		a.env.Provide(jwtauth.IssuerService, jwtauth.NewIssuer(jwtauth.IssuerConfig{
+			Alg:      jwtauth.HS256,
+			Key:      []byte(a.Config.JWT.Secret),
+			Issuer:   a.Config.JWT.Issuer,
+			Audience: a.Config.JWT.Audience,
+			TTL:      a.Config.JWT.AccessTTL,
+		}))
+	}
+	signingKeys, err := NewKeySet(a.Config)
+	if err != nil {
+		return err
+	}
+	if signingKeys != nil {
+		a.env.Provide(jwtauth.KeySetService, signingKeys)
+	}
+	if a.Config.Redis.URL != "" {
+		a.redis, err = redis.New(a.Config.Redis.URL, redis.Config{PoolSize: a.Config.Redis.PoolSize, Timeout: a.Config.Redis.Timeout})
+		if err != nil {
+			return err
+		}
+		a.lc.Append(lifecycle.Hook{Name: HookRedis, Stop: func(ctx context.Context) error { return a.redis.Close() }})
+		a.env.Provide("redis.client", a.redis)
+	}
+	if a.redis == nil && (a.Config.HTTP.RateLimitStore == "redis" || a.Config.Cache.Purge == "redis") {
+		return errors.New("redis rate limit store and response cache purge require --redis-url")
+	}
+	if c := a.Config.Cache; c.Size > 0 {
+		a.responses = surrogate.New(surrogate.Config{Size: c.Size, MaxAge: c.MaxAge, MaxBodySize: c.MaxBodySize})
+		a.purger = append(a.purger, a.responses)
+		if c.Purge == "redis" {
+			// every replica purges its own cache on broadcast, this one included
+			a.purger = append(a.purger, surrogate.Broadcast(func(ctx context.Context, payload string) error {
+				_, err := a.redis.Do(ctx, "PUBLISH", PurgeChannel, payload)
+				return err
+			}))
+			a.lc.Append(jobsHook(HookPurges, []module.Job{a.redis.NewSubscriber(PurgeChannel, a.responses.Receive)}, a.Logger))
+			workers = append(workers, HookPurges)
+		}
+	}
+	if c := a.Config.Cache; c.FastlyServiceID != "" {
+		a.purger = append(a.purger, &surrogate.Fastly{ServiceID: c.FastlyServiceID, Token: c.FastlyToken})
+	}
+	a.env.Provide("surrogate.purger", a.purger)
+	if a.locker != nil {
+		a.env.Provide("dlock.locker", a.locker)
+	}
+	if err := module.Init(a.Modules, a.env); err != nil {
+		return err
+	}
+
+	workers = append(workers, HookSitemap, HookMaintenance)
+	for _, m := range a.Modules {
+		if jobs := m.Jobs(); len(jobs) > 0 {
+			name := "module." + m.Name()
+			a.lc.Append(jobsHook(name, jobs, a.Logger))
+			workers = append(workers, name)
+		}
+	}
+
+	// tickers of sitemap, maintenance and leader election panic on non-positive intervals
+	if a.Config.Sitemap.Interval <= 0 {
+		return errors.New("--sitemap-interval must be positive")
+	}
+	if a.Config.Maintenance.Interval <= 0 {
+		return errors.New("--maintenance-interval must be positive")
+	}
+	if a.locker != nil && a.Config.Leader.Interval <= 0 {
+		return errors.New("--leader-interval must be positive")
+	}
+	a.sitemap = sitemap.New(a.Config.Sitemap.BaseURL, a.Config.Sitemap.PageSize, sitemapSources(a.env)...)
+	a.lc.Append(lifecycle.Hook{
+		Name:      HookSitemap,
+		DependsOn: []string{HookDatabase},
+		Start: func(ctx context.Context) error {
+			if err := a.sitemap.Generate(); err != nil {
+				a.Logger.WithError(err).Error("could not generate sitemap")
+			}
+			go a.sitemap.Run(a.Config.Sitemap.Interval, a.Logger)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			a.sitemap.Close()
+			return nil
+		},
+	})
+
+	a.maintenance = maintenance.New(a.Config.Maintenance.File, a.Config.Maintenance.RetryAfter)
+	a.lc.Append(lifecycle.Hook{
+		Name: HookMaintenance,
+		Start: func(ctx context.Context) error {
+			if err := a.maintenance.CheckFile(); err != nil {
+				a.Logger.WithError(err).Error("could not check maintenance flag file")
+			}
+			go a.maintenance.Run(a.Config.Maintenance.Interval, a.Logger)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			a.maintenance.Close()
+			return nil
+		},
+	})
+
+	singletons := module.SingletonJobs(a.Modules)
+	if a.Config.Retention.Interval > 0 {
+		// batch of 0 rows would never purge everything and purger would spin
+		if a.Config.Retention.Batch <= 0 {
+			return errors.New("--retention-batch must be positive")
+		}
+		var overrides []retention.Override
+		for _, o := range a.Config.Retention.Overrides {
+			override, err := retention.ParseOverride(o)
+			if err != nil {
+				return err
+			}
+			overrides = append(overrides, override)
+		}
+		rules, err := retention.Apply(module.RetentionRules(a.Modules), overrides)
+		if err != nil {
+			return err
+		}
+		singletons = append(singletons, func() module.Job {
+			return retention.NewPurger(a.DB, rules, a.Config.Retention.Batch, a.Config.Retention.Interval)
+		})
+	}
+	if a.locker != nil {
+		identity := a.Config.Leader.Identity
+		if identity == "" {
+			identity, _ = os.Hostname()
+		}
+		a.elector = leader.New(a.locker, identity, a.Config.Leader.Interval, singletons...)
+		a.lc.Append(jobsHook(HookLeader, []module.Job{a.elector}, a.Logger))
+		workers = append(workers, HookLeader)
+	} else if len(singletons) > 0 {
+		// database passed in deps has no locks to elect leader, so this replica runs singletons
+		jobs := make([]module.Job, len(singletons))
+		for i, newJob := range singletons {
+			jobs[i] = newJob()
+		}
+		a.lc.Append(jobsHook(HookLeader, jobs, a.Logger))
+		workers = append(workers, HookLeader)
+	}
+
+	if a.Config.Diagnostics.Threshold > 0 {
+		a.diagnostics = diagnostics.New(
+			diagnostics.Config{
+				Threshold: a.Config.Diagnostics.Threshold,
+				Window:    a.Config.Diagnostics.Window,
+				Cooldown:  a.Config.Diagnostics.Cooldown,
+			},
+			diagnostics.NewDirStore(a.Config.Diagnostics.Path),
+			a.Reporter,
+			a.Logger,
+			diagnostics.Goroutines(),
+			diagnostics.Heap(),
+			diagnostics.SlowQueries(a.DB, a.Config.Diagnostics.SlowQuery),
+			diagnostics.JSON("config.json", a.Config.Snapshot()),
+			diagnostics.JSON("modules.json", ModuleOptions(a.Modules)),
+		)
+		// captures in progress are finished before database is closed
+		a.lc.Append(lifecycle.Hook{
+			Name:      HookDiagnostics,
+			DependsOn: []string{HookDatabase},
+			Start:     func(ctx context.Context) error { return nil },
+			Stop: func(ctx context.Context) error {
+				a.diagnostics.Wait()
+				return nil
+			},
+		})
+		workers = append(workers, HookDiagnostics)
+	}
+
+	if a.Config.HTTP.Shadow.URL != "" {
+		mirror, err := shadow.New(shadow.Config{
+			Target:      a.Config.HTTP.Shadow.URL,
+			Rate:        a.Config.HTTP.Shadow.Percent / 100,
+			Timeout:     a.Config.HTTP.Shadow.Timeout,
+			QueueSize:   a.Config.HTTP.Shadow.Queue,
+			Workers:     a.Config.HTTP.Shadow.Workers,
+			MaxBodySize: a.Config.HTTP.Shadow.MaxBodySize,
+		})
+		if err != nil {
+			return err
+		}
+		a.shadow = mirror
+		a.lc.Append(lifecycle.Hook{
+			Name: HookShadow,
+			Start: func(ctx context.Context) error {
+				go a.shadow.Run(a.Logger)
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				a.shadow.Close()
+				return nil
+			},
+		})
+		workers = append(workers, HookShadow)
+	}
+
+	h, err := a.router()
+	if err != nil {
+		return err
+	}
+	a.Handler = h
+	srv := &http.Server{Addr: a.Config.HTTP.Addr, Handler: h}
+	if a.Config.HTTP.TLS.CertFile != "" {
+		tc, err := mtls.TLSConfig(mtls.Config{
+			CertFile:   a.Config.HTTP.TLS.CertFile,
+			KeyFile:    a.Config.HTTP.TLS.KeyFile,
+			ClientAuth: a.Config.HTTP.TLS.ClientAuth,
+			ClientCA:   a.Config.HTTP.TLS.ClientCA,
+			ClientCRL:  a.Config.HTTP.TLS.ClientCRL,
+		})
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = tc
+	}
+	a.lc.Append(a.serverHook(HookHTTP, srv, workers))
+
+	// debug endpoints are served on a separate address, so they are never exposed with public api
+	if a.Config.HTTP.DebugAddr != "" {
+		dr := chi.NewRouter()
+		dr.Mount("/debug", middleware.Profiler())
+		a.lc.Append(a.serverHook(HookDebugHTTP, &http.Server{Addr: a.Config.HTTP.DebugAddr, Handler: dr}, nil))
+	}
+	return nil
+}
+
+// Start starts components, on failure already started ones are stopped.
+func (a *App) Start(ctx context.Context) error {
+	if err := a.lc.Start(ctx); err != nil {
+		return err
+	}
+	health.SetReadinessStatus(http.StatusOK)
+	return nil
+}
+
+// Stop fails readiness probes and waits for ShutdownDelay, so load balancers stop routing requests to
+// the instance while it still serves them, then stops HTTP servers, background workers and database.
+func (a *App) Stop(ctx context.Context) error {
+	health.SetReadinessStatus(http.StatusServiceUnavailable)
+	if delay := a.Config.HTTP.ShutdownDelay; delay > 0 {
+		a.Logger.Infof("waiting %v for load balancers to stop routing requests", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+	return a.lc.Stop(ctx)
+}
+
+// Err receives errors of HTTP servers which stopped serving on their own.
+func (a *App) Err() <-chan error {
+	return a.errc
+}
+
+// serverHook listens on start, so a busy address fails startup, and shuts server down gracefully on stop.
+func (a *App) serverHook(name string, srv *http.Server, dependsOn []string) lifecycle.Hook {
+	return lifecycle.Hook{
+		Name:      name,
+		DependsOn: dependsOn,
+		Start: func(ctx context.Context) error {
+			ln, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+			if srv.TLSConfig != nil {
+				ln = tls.NewListener(ln, srv.TLSConfig)
+			}
+			a.Logger.Infof("%s listening on %s", name, ln.Addr())
+			go func() {
+				if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+					a.errc <- errors.Wrapf(err, "%s server error", name)
+				}
+			}()
+			return nil
+		},
+		Stop: srv.Shutdown,
+	}
+}
+
+// jobsHook runs jobs of module, they are closed in reverse order.
+// Jobs run in a worker pool, so a panicking job is logged instead of crashing the service.
+func jobsHook(name string, jobs []module.Job, logger log.Logger) lifecycle.Hook {
+	pool := workerpool.New(name, len(jobs), len(jobs))
+	return lifecycle.Hook{
+		Name:      name,
+		DependsOn: []string{HookDatabase},
+		Start: func(ctx context.Context) error {
+			go pool.Run(logger)
+			for _, job := range jobs {
+				run := job.Run
+				if err := pool.TrySubmit(func(ctx context.Context) { run(logger) }); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			var first error
+			for i := len(jobs) - 1; i >= 0; i-- {
+				if err := jobs[i].Close(); err != nil && first == nil {
+					first = err
+				}
+			}
+			pool.Close()
+			return first
+		},
+	}
+}
+
+func (a *App) router() (http.Handler, error) {
+	cfg := a.Config
+
+	var rateLimits []ratelimit.Rule
+	for _, rl := range cfg.HTTP.RateLimits {
+		rule, err := ratelimit.ParseRule(rl)
+		if err != nil {
+			return nil, err
+		}
+		rateLimits = append(rateLimits, rule)
+	}
+
+	var budgetRules []budget.Rule
+	for _, br := range cfg.HTTP.RequestBudgetRules {
+		rule, err := budget.ParseRule(br)
+		if err != nil {
+			return nil, err
+		}
+		budgetRules = append(budgetRules, rule)
+	}
+	// changes feed holds requests with ?wait on purpose, it is unbounded unless configured otherwise
+	changes := budget.Rule{Method: http.MethodGet, Prefix: "/1.0/articles/changes"}
+	exempt := true
+	for _, rule := range budgetRules {
+		exempt = exempt && rule.Prefix != changes.Prefix
+	}
+	if exempt {
+		budgetRules = append(budgetRules, changes)
+	}
+
+	proxies, err := realip.ParseProxies(cfg.HTTP.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	var ipRules []ipfilter.Rule
+	for _, ir := range cfg.HTTP.IPRules {
+		rule, err := ipfilter.ParseRule(ir)
+		if err != nil {
+			return nil, err
+		}
+		ipRules = append(ipRules, rule)
+	}
+
+	// compiled when all routes are registered
+	routes := &handler.RouteTable{}
+
+	authzRules, err := AuthzRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var policy *authz.Engine
+	if len(authzRules) > 0 {
+		resources := make(map[string]authz.Resource)
+		for name, res := range a.env.LookupPrefix(authz.ResourcePrefix) {
+			resources[name] = res.(authz.Resource)
+		}
+		if policy, err = authz.New(authzRules, resources, routes); err != nil {
+			return nil, err
+		}
+	}
+
+	auth, err := NewAuthenticator(cfg, a.env)
+	if err != nil {
+		return nil, err
+	}
+
+	var chaosRules []chaos.Rule
+	if cfg.Chaos.Enabled {
+		for _, cr := range cfg.Chaos.Rules {
+			rule, err := chaos.ParseRule(cr)
+			if err != nil {
+				return nil, err
+			}
+			chaosRules = append(chaosRules, rule)
+		}
+		a.Logger.Warnf("chaos is enabled, faults are injected by rules: %+v", chaosRules)
+	}
+
+	// v1 keeps snake_case and RFC 3339 timestamps, clients may opt in to other policy with Accept profile
+	serializer.SetVersionPolicy("1.0", serializer.Default)
+	render.Respond = serializer.Respond
+
+	// captures artifacts for on-call when server errors pile up
+	captureDiagnostics := func(next http.Handler) http.Handler { return next }
+	if a.diagnostics != nil {
+		captureDiagnostics = a.diagnostics.Middleware
+	}
+
+	security := handler.SecurityHeaders{
+		StrictTransportSecurity: cfg.Security.HSTS,
+		ContentTypeOptions:      cfg.Security.ContentTypeOptions,
+		FrameOptions:            cfg.Security.FrameOptions,
+		ContentSecurityPolicy:   cfg.Security.ContentSecurityPolicy,
+		ReferrerPolicy:          cfg.Security.ReferrerPolicy,
+	}
+	docsSecurity, spaSecurity := security, security
+	docsSecurity.ContentSecurityPolicy = cfg.Security.DocsCSP
+	spaSecurity.ContentSecurityPolicy = cfg.Security.SPACSP
+
+	// shared links are served as users who shared them, so they are verified before anything keyed by user
+	var signer *signedurl.Signer
+	if cfg.SignedURL.Secret != "" {
+		signer = signedurl.New([]byte(cfg.SignedURL.Secret))
+	}
+
+	corsGroups := []handler.CORSGroup{{
+		Prefix:           "/",
+		AllowedOrigins:   cfg.HTTP.AllowedOrigins,
+		AllowedHeaders:   cfg.HTTP.AllowedHeaders,
+		ExposedHeaders:   cfg.HTTP.ExposedHeaders,
+		AllowCredentials: true,
+		MaxAge:           cfg.HTTP.CORSMaxAge,
+	}}
+	if len(cfg.HTTP.AdminAllowedOrigins) > 0 {
+		admin := corsGroups[0]
+		admin.Prefix = "/1.0/admin/"
+		admin.AllowedOrigins = cfg.HTTP.AdminAllowedOrigins
+		corsGroups = append(corsGroups, admin)
+	}
+
+	// note: order of middlewares is important
+	r := chi.NewRouter()
+	r.Use(
+		middleware.RequestID,
+		realip.Middleware(proxies),
+		handler.RequestLogger(a.Logger),
+		captureDiagnostics,
+		handler.Secure(security),
+		handler.Recoverer(a.Reporter),
+		handler.CORS(routes, corsGroups...),
+		handler.AutoMethods(routes),
+		i18n.Middleware(cfg.HTTP.DefaultLocale),
+		// probes must see real state, and admins must be able to turn maintenance off
+		maintenance.Middleware(a.maintenance, "/readiness", "/liveness", "/1.0/admin/"),
+	)
+	if cfg.HTTP.MethodOverride {
+		r.Use(handler.MethodOverride)
+	}
+	if cfg.HTTP.TLS.ClientCA != "" {
+		r.Use(mtls.Middleware)
+	}
+	if signer != nil {
+		r.Use(signer.Middleware)
+	}
+	if auth != nil {
+		r.Use(jwtauth.Middleware(auth))
+	}
+	if len(ipRules) > 0 {
+		r.Use(ipfilter.Middleware(ipfilter.New(ipRules)))
+	}
+	if len(rateLimits) > 0 {
+		var store ratelimit.Store
+		if cfg.HTTP.RateLimitStore == "redis" {
+			store = ratelimit.NewRedisStore(a.redis)
+		}
+		r.Use(ratelimit.Middleware(ratelimit.New(rateLimits, store)))
+	}
+	if cfg.HTTP.RequestBudget > 0 || len(cfg.HTTP.RequestBudgetRules) > 0 {
+		r.Use(budget.Middleware(budget.New(cfg.HTTP.RequestBudget, budgetRules), cfg.HTTP.RequestBudgetRetry))
+	}
+	if a.shadow != nil {
+		r.Use(a.shadow.Middleware)
+	}
+	if len(chaosRules) > 0 {
+		r.Use(chaos.Middleware(chaos.New(chaosRules)))
+	}
+	checks := module.HealthChecks(a.Modules)
+	checks["postgres"] = a.DB.Ping
+	r.Mount("/readiness", health.Routes(checks))
+	r.Get("/liveness", health.Liveness)
+	sitemap.Register(r, a.sitemap)
+	if keys, ok := a.env.Lookup(jwtauth.KeySetService); ok {
+		r.Method(http.MethodGet, "/.well-known/jwks.json", jwtauth.JWKSHandler(keys.(*jwtauth.KeySet)))
+	}
+	r.Route("/1.0", func(r chi.Router) {
+		r.Use(handler.ApiVersion("1.0"))
+		for _, m := range a.Modules {
+			if mw, ok := m.(module.Middleware); ok {
+				r.Use(mw.Middleware())
+			}
+		}
+		// after modules, so rules see impersonated users
+		if policy != nil {
+			r.Use(authz.Middleware(policy))
+		}
+		if a.responses != nil {
+			r.Use(a.responses.Middleware)
+		}
+		for _, m := range a.Modules {
+			for pattern, h := range m.Routes() {
+				r.Mount(pattern, h)
+			}
+		}
+		r.Mount("/admin/maintenance", maintenance.Routes(a.maintenance))
+		if a.pool != nil {
+			r.Mount("/admin/database/pool", dbpool.Routes(a.pool))
+		}
+		r.Mount("/admin/cache/purge", purge.Routes(a.purger))
+		if signer != nil {
+			r.Mount("/links", links.Routes(signer, links.Config{
+				Prefixes: cfg.SignedURL.Prefixes,
+				MaxTTL:   cfg.SignedURL.MaxTTL,
+			}))
+		}
+		if a.elector != nil {
+			r.Mount("/admin/leader", leader.Routes(a.elector))
+		}
+	})
+	docs := a.docs
+	if cfg.DocsPath != "" {
+		docs = http.Dir(cfg.DocsPath)
+	}
+	if docs != nil {
+		handler.FileServer(r, "/docs", docs, handler.StaticConfig{
+			CacheControl: cfg.DocsCacheControl,
+			Security:     &docsSecurity,
+		})
+	}
+	if cfg.SPAPath != "" {
+		// index.html is revalidated, so a new deploy is picked up, assets it links are hashed
+		handler.FileServer(r, "/", http.Dir(cfg.SPAPath), handler.StaticConfig{
+			CacheControl: "no-cache",
+			Fallback:     "/index.html",
+			Immutable:    handler.HashedAsset,
+			Security:     &spaSecurity,
+		})
+	}
+
+	routes.Compile(r)
+	if policy != nil {
+		if err := policy.Check(); err != nil {
+			return nil, err
+		}
+	}
+	r.NotFound(handler.NotFound(routes, cfg.HTTP.RouteSuggestions))
+	r.MethodNotAllowed(handler.MethodNotAllowed(routes))
+	return r, nil
+}
+
+// ConnectDatabase connects to database and applies migrations of modules.
+func ConnectDatabase(cfg *Config, logger log.Logger, modules []module.Module) (*postgres.Database, error) {
+	ms := &migrate.MemoryMigrationSource{Migrations: module.Migrations(modules)}
+
+	db, err := postgres.New(cfg.Postgres.URL, logger, postgres.Config{
+		MaxConnLifetime: cfg.Postgres.MaxConnLifetimeSec,
+		MaxOpenConns:    cfg.Postgres.MaxOpenConns,
+		MaxIdleConns:    cfg.Postgres.MaxIdleConns,
+		StatementCache:  cfg.Postgres.StatementCache,
+		Driver:          cfg.Postgres.Driver,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Connect(); err != nil {
+		return nil, err
+	}
+	if err := db.Migrate(ms); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// AuthzRules parses rules of flags and policy file.
+func AuthzRules(cfg *Config) ([]authz.Rule, error) {
+	lines := cfg.Authz.Rules
+	if cfg.Authz.File != "" {
+		data, err := ioutil.ReadFile(cfg.Authz.File)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read authz policy file")
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+	}
+	var rules []authz.Rule
+	for _, line := range lines {
+		rule, err := authz.ParseRule(line)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// NewAuthenticator returns authenticator of bearer tokens, nil if neither keys nor introspection are configured.
+// Signing keys and revocation list are the ones provided to modules, if any.
+func NewAuthenticator(cfg *Config, env *module.Env) (*jwtauth.Authenticator, error) {
+	c := cfg.JWT
+	if c.Introspect == "" {
+		c.Introspect = jwtauth.IntrospectNever
+	}
+	signingKeys, _ := env.Lookup(jwtauth.KeySetService)
+	if c.Secret == "" && len(c.PublicKeys) == 0 && signingKeys == nil && c.Introspect == jwtauth.IntrospectNever {
+		return nil, nil
+	}
+	var acfg jwtauth.Config
+	if c.Secret != "" || len(c.PublicKeys) > 0 || signingKeys != nil {
+		vcfg := jwtauth.VerifierConfig{Issuer: c.Issuer, Audience: c.Audience, Leeway: c.Leeway, AllowNoExpiry: c.AllowNoExpiry}
+		if signingKeys != nil {
+			vcfg.Keys = signingKeys.(*jwtauth.KeySet)
+		}
+		if c.Secret != "" {
+			vcfg.Secret = []byte(c.Secret)
+		}
+		for _, pk := range c.PublicKeys {
+			var kid string
+			path := pk
+			if i := strings.Index(pk, ":"); i >= 0 {
+				kid, path = pk[:i], pk[i+1:]
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, errors.Wrap(err, "could not read jwt public key")
+			}
+			key, err := jwtauth.ParsePublicKey(data)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid jwt public key %s", path)
+			}
+			if vcfg.PublicKeys == nil {
+				vcfg.PublicKeys = make(map[string]*rsa.PublicKey)
+			}
+			vcfg.PublicKeys[kid] = key
+		}
+		acfg.Verifier = jwtauth.NewVerifier(vcfg)
+	}
+	acfg.Introspect = c.Introspect
+	if c.Introspect != jwtauth.IntrospectNever {
+		if c.IntrospectionURL == "" {
+			return nil, errors.Errorf("--jwt-introspect=%s requires --jwt-introspection-url", c.Introspect)
+		}
+		roles, err := jwtauth.ParseRoles(c.IntrospectionRoles)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid --jwt-introspection-role")
+		}
+		acfg.Introspector = jwtauth.NewIntrospector(jwtauth.IntrospectorConfig{
+			URL:           c.IntrospectionURL,
+			ClientID:      c.ClientID,
+			ClientSecret:  c.ClientSecret,
+			Timeout:       c.IntrospectionTimeout,
+			CacheSize:     c.CacheSize,
+			CacheTTL:      c.CacheTTL,
+			AllowNoExpiry: c.AllowNoExpiry,
+			Roles:         roles,
+			Egress:        env.Egress,
+		})
+	}
+	if rev, ok := env.Lookup(jwtauth.RevocationsService); ok {
+		acfg.Revocations = rev.(jwtauth.Revocations)
+	}
+	return jwtauth.New(acfg)
+}
+
+// NewKeyring returns keyring of configured encryption keys, nil if there are none.
+func NewKeyring(cfg *Config) (*crypto.Keyring, error) {
+	if len(cfg.Encryption.Keys) == 0 {
+		return nil, nil
+	}
+	var keys []crypto.Key
+	for _, k := range cfg.Encryption.Keys {
+		key, err := crypto.ParseKey(k)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return crypto.NewKeyring(keys, cfg.Encryption.PrimaryKey)
+}
+
+// NewKeySet returns configured signing keys of tokens, nil if there are none.
+func NewKeySet(cfg *Config) (*jwtauth.KeySet, error) {
+	if len(cfg.JWT.SigningKeys) == 0 {
+		return nil, nil
+	}
+	var keys []jwtauth.SigningKey
+	for _, sk := range cfg.JWT.SigningKeys {
+		i := strings.Index(sk, ":")
+		if i <= 0 {
+			return nil, errors.Errorf("invalid jwt signing key %q, expected kid:path", sk)
+		}
+		kid, path := sk[:i], sk[i+1:]
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read jwt signing key")
+		}
+		key, err := jwtauth.ParsePrivateKey(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid jwt signing key %s", path)
+		}
+		keys = append(keys, jwtauth.SigningKey{ID: kid, Key: key})
+	}
+	return jwtauth.NewKeySet(keys...)
+}
+
+// NewReporter returns Sentry reporter which also receives logged errors, or Nop if it is not configured.
+func NewReporter(cfg *Config, logger *log.StructuredLogger) (report.Reporter, error) {
+	if cfg.Report.SentryDSN == "" {
+		return report.Nop{}, nil
+	}
+	sentry, err := report.NewSentry(cfg.Report.SentryDSN, cfg.Report.Environment, cfg.Version, logger)
+	if err != nil {
+		return nil, err
+	}
+	reporter := report.WithOptions(sentry, report.Options{
+		SampleRate:  cfg.Report.SampleRate,
+		ScrubFields: cfg.Report.ScrubFields,
+		SendUser:    cfg.Report.SendUser,
+		Tags:        cfg.PodFields(),
+	})
+	logger.Hooks.Add(&report.Hook{Reporter: reporter})
+	return reporter, nil
+}
+
+// ModuleOptions returns options of modules by name, modules never keep secrets in options.
+func ModuleOptions(modules []module.Module) map[string]interface{} {
+	opts := make(map[string]interface{})
+	for _, m := range modules {
+		if o := m.Options(); o != nil {
+			opts[m.Name()] = o
+		}
+	}
+	return opts
+}
+
+// sitemapSources returns sources provided by modules ordered by name, so sitemap files are stable.
+func sitemapSources(env *module.Env) []sitemap.Source {
+	provided := env.LookupPrefix(sitemap.SourcePrefix)
+	names := make([]string, 0, len(provided))
+	for name := range provided {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sources []sitemap.Source
+	for _, name := range names {
+		sources = append(sources, provided[name].(sitemap.Source))
+	}
+	return sources
+}
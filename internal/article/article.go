@@ -1,30 +1,127 @@
 package article
 
 import (
+	"context"
 	"database/sql"
+	"strings"
+	"time"
 
 	"github.com/lib/pq"
 	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+	"github.com/agalitsyn/goapi/pkg/sanitize"
+	"github.com/agalitsyn/goapi/pkg/singleflight"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrUnknownAuthor is returned when article refers to author which does not exist.
+	ErrUnknownAuthor = i18n.Errorf("article.unknown_author")
+	// ErrHidden is returned on update of article hidden by moderation.
+	ErrHidden = i18n.Errorf("article.hidden")
+	// ErrSlugTaken is returned when slug is taken meanwhile by article saved concurrently.
+	ErrSlugTaken = i18n.Errorf("article.slug_taken")
 )
 
-var ErrNotFound = errors.New("not found")
+// Status is a publication status of article.
+type Status string
+
+const (
+	StatusDraft     Status = "draft"
+	StatusPublished Status = "published"
+	StatusArchived  Status = "archived"
+)
+
+// Statuses lists all statuses in display order.
+var Statuses = []Status{StatusDraft, StatusPublished, StatusArchived}
+
+func (s Status) valid() bool {
+	for _, v := range Statuses {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
 
 type Article struct {
-	ID    string `json:"id"`
-	Title string `json:"title"`
-	Slug  string `json:"slug"`
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Slug      string    `json:"slug"`
+	Status    Status    `json:"status"`
+	Tags      []string  `json:"tags"`
+	Views     int64     `json:"views"`
+	CreatedAt time.Time `json:"created_at"`
+	// Body is Markdown source.
+	Body string `json:"body"`
+	// Revision is incremented on every update.
+	Revision int `json:"revision"`
+	// AuthorID is nil for articles without author.
+	AuthorID *string `json:"author_id"`
+	// Likes and Bookmarks count users who liked and bookmarked article.
+	Likes     int64 `json:"likes"`
+	Bookmarks int64 `json:"bookmarks"`
+	// OwnerID is id of user who created article, it is empty when nobody is signed in, e.g. for seeds.
+	// It is not selected with articles, only authorization rules see it.
+	OwnerID string `json:"-"`
+	// Language is a language of title and body when they are translated, it is empty for the original.
+	Language string `json:"language,omitempty"`
+	// translation is a revision of translation title and body are taken from.
+	translation int
 }
 
 type Manager struct {
-	db *sql.DB
+	db postgres.Querier
+
+	// html is a policy submitted bodies and their rendered HTML are sanitized with, titles and tags are stripped of HTML.
+	html sanitize.Policy
+	// fields are selected by queries returning articles.
+	fields Fields
+	// flight coalesces identical reads in flight, it is nil in transactions, which must see their own writes.
+	flight *singleflight.Group
+	// ctx queries are cancelled with, nil unless set with WithContext.
+	ctx context.Context
 }
 
-func NewManager(db *sql.DB) *Manager {
-	return &Manager{db: db}
+func NewManager(db *sql.DB, html sanitize.Policy) *Manager {
+	return &Manager{db: db, html: html, flight: singleflight.New("article")}
+}
+
+// Tx runs fn with manager bound to a transaction, which is rolled back when fn fails or on dry run.
+func (m *Manager) Tx(dryRun bool, fn func(m *Manager) error) error {
+	return postgres.Tx(m.db, dryRun, func(tx postgres.Querier) error {
+		return fn(&Manager{db: tx, html: m.html, fields: m.fields})
+	})
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+// Coalesced reads are cancelled with context of the caller which made the query, callers waiting
+// for it query again then.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db), html: m.html, fields: m.fields, flight: m.flight, ctx: ctx}
+}
+
+// Select returns manager which queries only fields of articles, the rest are left zero.
+func (m *Manager) Select(f Fields) *Manager {
+	return &Manager{db: m.db, html: m.html, fields: f, flight: m.flight, ctx: m.ctx}
 }
 
 func (m *Manager) Save(a *Article) error {
-	_, err := m.db.Exec("INSERT INTO article(title, slug) VALUES ($1, $2);", a.Title, a.Slug)
+	if a.Tags == nil {
+		a.Tags = []string{}
+	}
+	err := m.db.QueryRow(
+		"INSERT INTO article(title, slug, status, tags, body, author_id, owner_id) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')) RETURNING id, created_at, revision;",
+		a.Title, a.Slug, a.Status, pq.Array(a.Tags), a.Body, a.AuthorID, a.OwnerID,
+	).Scan(&a.ID, &a.CreatedAt, &a.Revision)
+	if isForeignKeyViolation(err) {
+		return ErrUnknownAuthor
+	}
+	if isUniqueViolation(err) {
+		return ErrSlugTaken
+	}
 	if err != nil {
 		return errors.Wrap(err, "could not save article")
 	}
@@ -32,13 +129,64 @@ func (m *Manager) Save(a *Article) error {
 }
 
 func (m *Manager) Update(a *Article) error {
-	_, err := m.db.Exec("UPDATE article SET title = $2, slug = $3 WHERE id = $1;", a.ID, a.Title, a.Slug)
+	if a.Tags == nil {
+		a.Tags = []string{}
+	}
+	err := m.db.QueryRow(
+		"UPDATE article SET title = $2, slug = $3, status = $4, tags = $5, body = $6, author_id = $7, revision = revision + 1 WHERE id = $1 AND NOT hidden RETURNING revision;",
+		a.ID, a.Title, a.Slug, a.Status, pq.Array(a.Tags), a.Body, a.AuthorID,
+	).Scan(&a.Revision)
+	if err == sql.ErrNoRows {
+		return ErrHidden
+	}
+	if isForeignKeyViolation(err) {
+		return ErrUnknownAuthor
+	}
+	if isUniqueViolation(err) {
+		return ErrSlugTaken
+	}
 	if err != nil {
 		return errors.Wrap(err, "could not update article")
 	}
 	return nil
 }
 
+// Upsert saves article or updates the one with the same slug, unchanged articles are left as is.
+// It reports whether article was saved or updated. Author of article is not changed, nor are hidden articles.
+func (m *Manager) Upsert(a *Article) (bool, error) {
+	if a.Tags == nil {
+		a.Tags = []string{}
+	}
+	err := m.db.QueryRow(
+		`INSERT INTO article(title, slug, status, tags, body) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (slug) DO UPDATE SET title = EXCLUDED.title, status = EXCLUDED.status, tags = EXCLUDED.tags,
+			body = EXCLUDED.body, revision = article.revision + 1
+		WHERE (article.title, article.status, article.tags, article.body) IS DISTINCT FROM
+			(EXCLUDED.title, EXCLUDED.status, EXCLUDED.tags, EXCLUDED.body) AND NOT article.hidden
+		RETURNING id, created_at, revision;`,
+		a.Title, a.Slug, a.Status, pq.Array(a.Tags), a.Body,
+	).Scan(&a.ID, &a.CreatedAt, &a.Revision)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "could not upsert article")
+	}
+	return true, nil
+}
+
+// SetHidden hides article from reads and updates, e.g. pending moderation, or brings it back.
+func (m *Manager) SetHidden(id string, hidden bool) error {
+	res, err := m.db.Exec("UPDATE article SET hidden = $2 WHERE id = $1;", id, hidden)
+	if err != nil {
+		return errors.Wrap(err, "could not hide article")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 func (m *Manager) Delete(a *Article) error {
 	_, err := m.db.Exec("DELETE FROM article WHERE id = $1;", a.ID)
 	if err != nil {
@@ -58,8 +206,43 @@ func (m *Manager) ByID(id string) (*Article, error) {
 	return articles[0], nil
 }
 
+// byID returns article by id without coalescing, e.g. to writers. Hidden article is ErrHidden when
+// includeHidden is set, so writers do not take it for a missing one, and ErrNotFound otherwise.
+func (m *Manager) byID(id string, includeHidden bool) (*Article, error) {
+	rows, err := m.db.Query("SELECT "+m.fields.columns()+", hidden FROM article WHERE id = $1;", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get article by id")
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, errors.Wrap(err, "could not get article by id")
+		}
+		return nil, ErrNotFound
+	}
+	var a Article
+	var hidden bool
+	if err := rows.Scan(append(m.fields.dest(&a), &hidden)...); err != nil {
+		return nil, errors.Wrap(err, "could not scan row to article model")
+	}
+	if hidden && includeHidden {
+		return nil, ErrHidden
+	}
+	if hidden {
+		return nil, ErrNotFound
+	}
+	return &a, nil
+}
+
 func (m *Manager) ByIDs(ids []string) ([]*Article, error) {
-	rows, err := m.db.Query("SELECT id, title, slug FROM article WHERE id = ANY($1);", pq.Array(ids))
+	return m.coalesce("ids:"+strings.Join(ids, ","), func() ([]*Article, error) {
+		return m.byIDs(ids)
+	})
+}
+
+func (m *Manager) byIDs(ids []string) ([]*Article, error) {
+	rows, err := m.db.Query("SELECT "+m.fields.columns()+" FROM article WHERE id = ANY($1) AND NOT hidden;", pq.Array(ids))
 	if err != nil {
 		return nil, errors.Wrap(err, "could not get articles by ids")
 	}
@@ -67,7 +250,7 @@ func (m *Manager) ByIDs(ids []string) ([]*Article, error) {
 
 	var articles []*Article
 	for rows.Next() {
-		device, err := scan(rows)
+		device, err := m.scan(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -80,8 +263,24 @@ func (m *Manager) ByIDs(ids []string) ([]*Article, error) {
 	return articles, nil
 }
 
+// LastModified returns when articles were last created, changed or deleted, views are not changes.
+func (m *Manager) LastModified() (time.Time, error) {
+	var modified time.Time
+	err := m.db.QueryRow(
+		"SELECT GREATEST((SELECT max(updated_at) FROM article), (SELECT max(deleted_at) FROM article_deletion));",
+	).Scan(&modified)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "could not get last modification of articles")
+	}
+	return modified, nil
+}
+
 func (m *Manager) All() ([]*Article, error) {
-	rows, err := m.db.Query("SELECT id, title, slug FROM article;")
+	return m.coalesce("all", m.all)
+}
+
+func (m *Manager) all() ([]*Article, error) {
+	rows, err := m.db.Query("SELECT " + m.fields.columns() + " FROM article WHERE NOT hidden;")
 	if err != nil {
 		return nil, errors.Wrap(err, "could not get articles")
 	}
@@ -89,7 +288,7 @@ func (m *Manager) All() ([]*Article, error) {
 
 	var articles []*Article
 	for rows.Next() {
-		device, err := scan(rows)
+		device, err := m.scan(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -102,11 +301,163 @@ func (m *Manager) All() ([]*Article, error) {
 	return articles, nil
 }
 
-func scan(rows *sql.Rows) (*Article, error) {
+// Published returns latest published articles.
+func (m *Manager) Published(limit int) ([]*Article, error) {
+	rows, err := m.db.Query("SELECT "+m.fields.columns()+" FROM article WHERE status = $1 AND NOT hidden ORDER BY created_at DESC, id DESC LIMIT $2;", StatusPublished, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get published articles")
+	}
+	defer rows.Close()
+
+	var articles []*Article
+	for rows.Next() {
+		a, err := m.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get published articles")
+	}
+	return articles, nil
+}
+
+// AllPublished returns all published articles in creation order.
+func (m *Manager) AllPublished() ([]*Article, error) {
+	rows, err := m.db.Query("SELECT "+m.fields.columns()+" FROM article WHERE status = $1 AND NOT hidden ORDER BY id;", StatusPublished)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get published articles")
+	}
+	defer rows.Close()
+
+	var articles []*Article
+	for rows.Next() {
+		a, err := m.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get published articles")
+	}
+	return articles, nil
+}
+
+// MostViewed returns articles ordered by views, views not flushed yet are not taken into account.
+func (m *Manager) MostViewed(limit int) ([]*Article, error) {
+	rows, err := m.db.Query("SELECT "+m.fields.columns()+" FROM article WHERE NOT hidden ORDER BY views DESC, id LIMIT $1;", limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get most viewed articles")
+	}
+	defer rows.Close()
+
+	var articles []*Article
+	for rows.Next() {
+		a, err := m.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get most viewed articles")
+	}
+	return articles, nil
+}
+
+// ByAuthor returns articles of author, the latest first.
+func (m *Manager) ByAuthor(authorID string) ([]*Article, error) {
+	rows, err := m.db.Query("SELECT "+m.fields.columns()+" FROM article WHERE author_id = $1 AND NOT hidden ORDER BY created_at DESC, id DESC;", authorID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get articles of author")
+	}
+	defer rows.Close()
+
+	articles := []*Article{}
+	for rows.Next() {
+		a, err := m.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get articles of author")
+	}
+	return articles, nil
+}
+
+// cancelledError is error of coalesced read which context of the caller making it is done.
+type cancelledError struct {
+	err error
+}
+
+func (e *cancelledError) Error() string { return e.err.Error() }
+
+// coalesce runs fn once for concurrent calls with the same key and fields, callers get
+// their own copies of articles, so they can change them. Callers run fn themselves when
+// the call they waited for is cancelled, e.g. because its client went away.
+func (m *Manager) coalesce(key string, fn func() ([]*Article, error)) ([]*Article, error) {
+	if m.flight == nil {
+		return fn()
+	}
+	v, err, shared := m.flight.Do(m.fields.columns()+"\x00"+key, func() (interface{}, error) {
+		articles, err := fn()
+		if err != nil && m.ctx != nil && m.ctx.Err() != nil {
+			return nil, &cancelledError{err}
+		}
+		return articles, err
+	})
+	if c, ok := err.(*cancelledError); ok {
+		if m.ctx != nil && m.ctx.Err() != nil {
+			return nil, c.err
+		}
+		return fn()
+	}
+	if err != nil {
+		return nil, err
+	}
+	articles := v.([]*Article)
+	if !shared {
+		return articles, nil
+	}
+	copies := make([]*Article, len(articles))
+	for i, a := range articles {
+		c := *a
+		if a.Tags != nil {
+			c.Tags = make([]string, len(a.Tags))
+			copy(c.Tags, a.Tags)
+		}
+		if a.AuthorID != nil {
+			id := *a.AuthorID
+			c.AuthorID = &id
+		}
+		copies[i] = &c
+	}
+	return copies, nil
+}
+
+func (m *Manager) scan(rows *sql.Rows) (*Article, error) {
 	var a Article
-	err := rows.Scan(&a.ID, &a.Title, &a.Slug)
+	err := rows.Scan(m.fields.dest(&a)...)
 	if err != nil {
 		return nil, errors.Wrapf(err, "could not scan row to article model")
 	}
 	return &a, nil
 }
+
+// isForeignKeyViolation reports whether err is a violation of foreign key constraint, e.g. of article author.
+func isForeignKeyViolation(err error) bool {
+	return postgres.ErrorCode(err) == "23503"
+}
+
+// isUniqueViolation reports whether err is a violation of unique constraint, e.g. of article slug.
+func isUniqueViolation(err error) bool {
+	return postgres.ErrorCode(err) == "23505"
+}
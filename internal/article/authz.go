@@ -0,0 +1,37 @@
+package article
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/authz"
+)
+
+// AuthzResource loads article by {articleID} parameter of request path for authorization rules. Its owner_id is
+// id of user who created article, to compare with user.id, author_id is not exposed as authors are not users.
+func AuthzResource(m *Manager) authz.Resource {
+	return func(ctx context.Context, params map[string]string) (map[string]interface{}, error) {
+		var (
+			id, ownerID, status string
+			tags                []string
+		)
+		err := m.WithContext(ctx).db.QueryRow(
+			"SELECT id, coalesce(owner_id, ''), status, tags FROM article WHERE id = $1;", params["articleID"],
+		).Scan(&id, &ownerID, &status, pq.Array(&tags))
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "could not get article for authorization")
+		}
+		return map[string]interface{}{
+			"id":       id,
+			"owner_id": ownerID,
+			"status":   status,
+			"tags":     authz.Value(tags),
+		}, nil
+	}
+}
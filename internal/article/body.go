@@ -0,0 +1,48 @@
+package article
+
+import (
+	"net/http"
+
+	"github.com/agalitsyn/goapi/pkg/cache"
+	"github.com/agalitsyn/goapi/pkg/markdown"
+	"github.com/agalitsyn/goapi/pkg/sanitize"
+)
+
+// bodyCache keeps bodies rendered to HTML per article and translation revision, so they are rendered once per update.
+// Rendered HTML is sanitized again, as Markdown policy may produce elements html policy does not allow.
+type bodyCache struct {
+	policy    markdown.Policy
+	sanitizer sanitize.Policy
+	cache     *cache.Cache
+}
+
+type bodyKey struct {
+	id       string
+	revision int
+	// language and translation are set for translated bodies.
+	language    string
+	translation int
+}
+
+func newBodyCache(policy markdown.Policy, html sanitize.Policy, size int) *bodyCache {
+	return &bodyCache{policy: policy, sanitizer: html, cache: cache.New("article.bodies", cache.Config{Size: size})}
+}
+
+func (c *bodyCache) html(a *Article) string {
+	key := bodyKey{a.ID, a.Revision, a.Language, a.translation}
+	if res, ok := c.cache.Get(key); ok {
+		return res.(string)
+	}
+
+	res := c.sanitizer.Sanitize(markdown.Render(a.Body, c.policy))
+	// revision is not known when it is not selected
+	if a.Revision != 0 {
+		c.cache.Set(key, res)
+	}
+	return res
+}
+
+// wantHTML reports whether client asked for rendered body with ?render=html.
+func wantHTML(r *http.Request) bool {
+	return r.URL.Query().Get("render") == "html"
+}
@@ -0,0 +1,163 @@
+package article
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// Actions of changes.
+const (
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionDeleted = "deleted"
+)
+
+// Change is an entry of article change log, it is written by database triggers, so changes made
+// bypassing the API are logged too. Views are not changes.
+type Change struct {
+	// Cursor orders changes, clients pass the last one they have seen to get the next.
+	Cursor    int64     `json:"cursor,string"`
+	ArticleID string    `json:"article_id"`
+	Action    string    `json:"action"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// committed hides changes of transactions which are running or were started after the oldest running one,
+// so a change committed late with a lower cursor is not skipped by clients which already passed it.
+const committed = "xid < txid_snapshot_xmin(txid_current_snapshot())"
+
+// Changes returns up to limit committed changes after cursor in order.
+func (m *Manager) Changes(cursor int64, limit int) ([]*Change, error) {
+	rows, err := m.db.Query(
+		"SELECT id, article_id, action, created_at FROM article_change WHERE id > $1 AND "+committed+" ORDER BY id LIMIT $2;",
+		cursor, limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get article changes")
+	}
+	defer rows.Close()
+
+	var changes []*Change
+	for rows.Next() {
+		c := &Change{}
+		if err := rows.Scan(&c.Cursor, &c.ArticleID, &c.Action, &c.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan article change")
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get article changes")
+	}
+	return changes, nil
+}
+
+// LastChange returns cursor of the last committed change, 0 if there are none.
+func (m *Manager) LastChange() (int64, error) {
+	var cursor int64
+	err := m.db.QueryRow("SELECT coalesce(max(id), 0) FROM article_change WHERE " + committed + ";").Scan(&cursor)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not get last article change")
+	}
+	return cursor, nil
+}
+
+// ChangeFeed lets clients wait for changes. A single poller per process checks change log every interval
+// and wakes up waiters when it grows, so waiting clients do not query database on their own.
+type ChangeFeed struct {
+	m        *Manager
+	interval time.Duration
+	clock    clock.Clock
+
+	mu   sync.Mutex
+	last int64
+	// changed is closed and replaced when change log grows
+	changed chan struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewChangeFeed(m *Manager, interval time.Duration) *ChangeFeed {
+	return &ChangeFeed{
+		m:        m,
+		interval: interval,
+		clock:    clock.Real,
+		changed:  make(chan struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Wait returns changes after cursor as soon as there are any, or none when ctx is done.
+func (f *ChangeFeed) Wait(ctx context.Context, m *Manager, cursor int64, limit int) ([]*Change, error) {
+	for {
+		// taken before query, so growth between query and wait is not missed
+		f.mu.Lock()
+		changed := f.changed
+		f.mu.Unlock()
+
+		changes, err := m.Changes(cursor, limit)
+		if err != nil || len(changes) > 0 {
+			return changes, err
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+// Poll checks change log and wakes up waiters if it grew.
+func (f *ChangeFeed) Poll() error {
+	last, err := f.m.LastChange()
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if last != f.last {
+		f.last = last
+		close(f.changed)
+		f.changed = make(chan struct{})
+	}
+	return nil
+}
+
+// Run polls change log every interval until Close is called.
+func (f *ChangeFeed) Run(logger log.Logger) {
+	defer close(f.done)
+
+	ticker := f.clock.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := f.Poll(); err != nil {
+				logger.WithError(err).Error()
+			}
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// Close stops Run.
+func (f *ChangeFeed) Close() error {
+	close(f.stop)
+	<-f.done
+	return nil
+}
+
+// parseCursor parses cursor of change, negative ones are invalid.
+func parseCursor(s string) (int64, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil && n >= 0
+}
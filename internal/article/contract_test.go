@@ -0,0 +1,137 @@
+package article
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/contract"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/markdown"
+	"github.com/agalitsyn/goapi/pkg/sanitize"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestContract(t *testing.T) {
+	created := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	modified := created.Add(time.Hour)
+	row := func(rows *sqlmock.Rows) *sqlmock.Rows {
+		return rows.AddRow(1, "Новая", "new", "published", "{news}", 5, created, "**Hello**", 2, nil, 0, 0)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name: "list", method: http.MethodGet, url: "/1.0/articles",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT GREATEST(.+)").WillReturnRows(sqlmock.NewRows([]string{"greatest"}).AddRow(modified))
+				mock.ExpectQuery("SELECT (.+) FROM article WHERE NOT hidden;").WillReturnRows(row(sqlmock.NewRows(articleColumns)))
+			},
+		},
+		{
+			name: "list_fields", method: http.MethodGet, url: "/1.0/articles?fields=title,status",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT GREATEST(.+)").WillReturnRows(sqlmock.NewRows([]string{"greatest"}).AddRow(modified))
+				mock.ExpectQuery("SELECT id, title, status FROM article WHERE NOT hidden;").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "title", "status"}).AddRow(1, "Новая", "published"))
+			},
+		},
+		{
+			name: "list_invalid_fields", method: http.MethodGet, url: "/1.0/articles?fields=secret",
+		},
+		{
+			name: "get", method: http.MethodGet, url: "/1.0/articles/1?render=html",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").WillReturnRows(row(sqlmock.NewRows(articleColumns)))
+			},
+		},
+		{
+			name: "get_not_found", method: http.MethodGet, url: "/1.0/articles/2",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").WillReturnRows(sqlmock.NewRows(articleColumns))
+			},
+		},
+		{
+			name: "slug", method: http.MethodGet, url: "/1.0/articles/slug/new",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM article WHERE slug = \\$1 AND NOT hidden;").WillReturnRows(row(sqlmock.NewRows(articleColumns)))
+			},
+		},
+		{
+			name: "slug_redirect", method: http.MethodGet, url: "/1.0/articles/slug/old",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM article WHERE slug = \\$1 AND NOT hidden;").WillReturnRows(sqlmock.NewRows(articleColumns))
+				mock.ExpectQuery("SELECT a.slug FROM article_slug_history").WillReturnRows(sqlmock.NewRows([]string{"slug"}).AddRow("new"))
+			},
+		},
+		{
+			name: "statuses", method: http.MethodGet, url: "/1.0/articles/statuses",
+		},
+		{
+			name: "create", method: http.MethodPut, url: "/1.0/articles/1",
+			body: `{"title": "Новая", "tags": ["news"], "body": "**Hello**"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+), hidden FROM article WHERE id = \\$1;").WillReturnRows(sqlmock.NewRows(putColumns))
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT slug FROM article").WillReturnRows(sqlmock.NewRows([]string{"slug"}))
+				mock.ExpectQuery("INSERT INTO article").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "revision"}).AddRow("1", created, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "create_invalid", method: http.MethodPut, url: "/1.0/articles/1",
+			body: `{"title": "", "status": "hidden"}`,
+		},
+		{
+			name: "delete", method: http.MethodDelete, url: "/1.0/articles/1",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").WillReturnRows(row(sqlmock.NewRows(articleColumns)))
+				mock.ExpectBegin()
+				mock.ExpectExec("DELETE FROM article WHERE id = \\$1;").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+
+			r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+			r.Use(i18n.Middleware("en"))
+			r.Mount("/1.0/articles", Routes(NewManager(db, sanitize.DefaultPolicy), Options{
+				Views:           NewViewCounter(db, time.Hour),
+				Markdown:        markdown.DefaultPolicy,
+				RenderCacheSize: 10,
+			}))
+
+			req := httptest.NewRequest(tt.method, "http://example.com"+tt.url, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			contract.Check(t, r, "article/"+tt.name, req)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expections: %s", err)
+			}
+		})
+	}
+}
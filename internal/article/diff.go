@@ -0,0 +1,26 @@
+package article
+
+import "reflect"
+
+// FieldChange is a change of a single article field, named as in JSON.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// diff lists changes of fields which clients can edit.
+func diff(from, to *Article) []FieldChange {
+	changes := []FieldChange{}
+	add := func(field string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			changes = append(changes, FieldChange{Field: field, From: a, To: b})
+		}
+	}
+	add("title", from.Title, to.Title)
+	add("slug", from.Slug, to.Slug)
+	add("status", from.Status, to.Status)
+	add("tags", from.Tags, to.Tags)
+	add("body", from.Body, to.Body)
+	return changes
+}
@@ -0,0 +1,107 @@
+package article
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Duplicates configures duplicate detection on article creation.
+type Duplicates struct {
+	// Check enables detection by default, clients override it with ?check_duplicates=true|false.
+	Check bool
+	// Threshold is a title similarity from 0 to 1 starting from which articles are duplicates.
+	Threshold float64
+}
+
+// Duplicate is an existing article which new one duplicates.
+type Duplicate struct {
+	*Article
+	// SameContent is true when title and body match up to case and whitespace, otherwise titles are similar.
+	SameContent bool
+	Similarity  float64
+}
+
+// contentExpr normalizes title and body the same way contentHash does, it is indexed by migration.
+const contentExpr = `md5(btrim(regexp_replace(lower(title || ' ' || body), '\s+', ' ', 'g')))`
+
+// maxDuplicateCandidates limits how many articles with common title words are compared.
+const maxDuplicateCandidates = 20
+
+// FindDuplicate returns an existing article with the same content or the most similar title, nil when there is none.
+func (m *Manager) FindDuplicate(a *Article, threshold float64) (*Duplicate, error) {
+	rows, err := m.db.Query(
+		`SELECT id, title, slug, status, tags, views, created_at, body, revision, author_id, likes, bookmarks, `+contentExpr+` = $1 AS same_content
+		FROM article
+		WHERE `+contentExpr+` = $1 OR ($2 <> '' AND to_tsvector('simple', title) @@ to_tsquery('simple', $2))
+		ORDER BY same_content DESC, id LIMIT $3;`,
+		contentHash(a), orQuery(a.Title), maxDuplicateCandidates,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get duplicate candidates")
+	}
+	defer rows.Close()
+
+	var res *Duplicate
+	for rows.Next() {
+		var c Article
+		d := &Duplicate{Article: &c}
+		err := rows.Scan(&c.ID, &c.Title, &c.Slug, &c.Status, pq.Array(&c.Tags), &c.Views, &c.CreatedAt, &c.Body, &c.Revision, &c.AuthorID, &c.Likes, &c.Bookmarks, &d.SameContent)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not scan row to duplicate candidate")
+		}
+		if c.ID == a.ID {
+			continue
+		}
+		d.Similarity = similarity(a.Title, c.Title)
+		if d.SameContent {
+			d.Similarity = 1
+		}
+		if d.Similarity >= threshold && (res == nil || d.Similarity > res.Similarity) {
+			res = d
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get duplicate candidates")
+	}
+	return res, nil
+}
+
+// contentHash is md5 of lowercase title and body with whitespace collapsed.
+func contentHash(a *Article) string {
+	sum := md5.Sum([]byte(strings.Join(strings.Fields(strings.ToLower(a.Title+" "+a.Body)), " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// similarity is Jaccard index of word trigrams of two texts, as in pg_trgm.
+func similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+func trigrams(s string) map[string]bool {
+	res := map[string]bool{}
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		r := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			res[string(r[i:i+3])] = true
+		}
+	}
+	return res
+}
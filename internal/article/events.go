@@ -0,0 +1,51 @@
+package article
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// ActionPublished is an event of article which status becomes published, change log has it as update.
+const ActionPublished = "published"
+
+// EventHandlerPrefix is a prefix of names modules provide EventHandler with, e.g. article.event_handler.activity.
+const EventHandlerPrefix = "article.event_handler."
+
+// Event is an action on article made by the API, unlike change log it knows who made it.
+type Event struct {
+	// Action is one of ActionCreated, ActionUpdated, ActionPublished and ActionDeleted.
+	Action  string
+	Article *Article
+	// ActorID is the user who made the action, empty for anonymous requests.
+	ActorID string
+}
+
+// EventHandler is called after action is committed.
+type EventHandler func(e Event) error
+
+// events are handlers by name, they are called in order of names.
+type events map[string]EventHandler
+
+// emit passes event to handlers, failures are logged since action is done anyway.
+func (ev events) emit(r *http.Request, action string, a *Article) {
+	if len(ev) == 0 {
+		return
+	}
+	e := Event{Action: action, Article: a}
+	if u := reqctx.GetUser(r.Context()); u != nil {
+		e.ActorID = u.ID
+	}
+	names := make([]string, 0, len(ev))
+	for name := range ev {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := ev[name](e); err != nil {
+			log.GetLogEntry(r).WithField("context", "article").WithField("handler", name).WithError(err).Errorf("could not handle %s event", action)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package article
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+)
+
+// Relation loads up to limit resources related to article, so they can be embedded in its response with ?expand.
+// Relations returning []*Article can be expanded further. Article has only fields selected with ?fields.
+type Relation func(a *Article, limit int) (interface{}, error)
+
+const (
+	// maxExpandDepth limits nesting of expanded relations, e.g. related.attachments is 2 levels deep.
+	maxExpandDepth = 2
+	// defaultExpandLimit and maxExpandLimit bound how many resources of each relation are embedded.
+	defaultExpandLimit = 10
+	maxExpandLimit     = 50
+)
+
+// expansion is a relation to embed with its own nested expansions.
+type expansion struct {
+	name   string
+	limit  int
+	nested []*expansion
+}
+
+// parseExpand parses comma-separated relation paths, e.g. related:3.attachments,attachments,
+// where the optional number after colon is a limit of the relation.
+func parseExpand(s string, relations map[string]Relation) ([]*expansion, error) {
+	var res []*expansion
+	if strings.TrimSpace(s) == "" {
+		return res, nil
+	}
+	for _, path := range strings.Split(s, ",") {
+		segments := strings.Split(strings.TrimSpace(path), ".")
+		if len(segments) > maxExpandDepth {
+			return nil, i18n.Errorf("article.expand_too_deep", path, maxExpandDepth)
+		}
+		level := &res
+		for _, seg := range segments {
+			name, limit := seg, defaultExpandLimit
+			if i := strings.IndexByte(seg, ':'); i >= 0 {
+				n, err := strconv.Atoi(seg[i+1:])
+				if err != nil || n < 1 || n > maxExpandLimit {
+					return nil, i18n.Errorf("request.invalid_param", seg, 1, maxExpandLimit)
+				}
+				name, limit = seg[:i], n
+			}
+			if _, ok := relations[name]; !ok {
+				return nil, i18n.Errorf("article.invalid_expand", name, strings.Join(relationNames(relations), ", "))
+			}
+
+			var e *expansion
+			for _, existing := range *level {
+				if existing.name == name {
+					e = existing
+				}
+			}
+			if e == nil {
+				e = &expansion{name: name, limit: limit}
+				*level = append(*level, e)
+			} else if limit != defaultExpandLimit {
+				e.limit = limit
+			}
+			level = &e.nested
+		}
+	}
+	return res, nil
+}
+
+// expand loads relations of article into its response.
+func expand(w http.ResponseWriter, r *http.Request, resp *articleResponse, exps []*expansion, relations map[string]Relation) error {
+	if len(exps) == 0 {
+		return nil
+	}
+	resp.Expanded = make(map[string]interface{}, len(exps))
+	for _, e := range exps {
+		v, err := relations[e.name](resp.Article, e.limit)
+		if err != nil {
+			return err
+		}
+		articles, ok := v.([]*Article)
+		if !ok {
+			resp.Expanded[e.name] = v
+			continue
+		}
+		list := make([]*articleResponse, 0, len(articles))
+		for _, a := range articles {
+			ar := newArticleResponse(a)
+			if err := ar.Render(w, r); err != nil {
+				return err
+			}
+			if err := expand(w, r, ar, e.nested, relations); err != nil {
+				return err
+			}
+			list = append(list, ar)
+		}
+		resp.Expanded[e.name] = list
+	}
+	return nil
+}
+
+// relatedRelation embeds articles found by scorer.
+func relatedRelation(scorer Scorer) Relation {
+	return func(a *Article, limit int) (interface{}, error) {
+		related, err := scorer.Related(a, limit)
+		if err != nil {
+			return nil, err
+		}
+		res := make([]*Article, 0, len(related))
+		for _, rel := range related {
+			res = append(res, rel.Article)
+		}
+		return res, nil
+	}
+}
+
+func relationNames(relations map[string]Relation) []string {
+	var res []string
+	for name := range relations {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,171 @@
+package article
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FeedConfig configures RSS and Atom feeds of published articles.
+type FeedConfig struct {
+	Title string
+	// ArticleURL is a template of article page URL, {id} and {slug} are replaced.
+	// API URL of article is used if empty.
+	ArticleURL string
+	// Size is how many latest articles feed contains.
+	Size int
+	// MaxAge is how long clients and proxies may cache feed.
+	MaxAge time.Duration
+}
+
+func (c FeedConfig) link(r *http.Request, a *Article) string {
+	if c.ArticleURL != "" {
+		return articleURL(c.ArticleURL, a)
+	}
+	return baseURL(r) + strings.TrimSuffix(r.URL.Path, "/"+feedName(r)) + "/" + a.ID
+}
+
+// articleURL replaces {id} and {slug} in URL template.
+func articleURL(tmpl string, a *Article) string {
+	return strings.NewReplacer("{id}", a.ID, "{slug}", a.Slug).Replace(tmpl)
+}
+
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func feedName(r *http.Request) string {
+	return r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title    string   `xml:"title"`
+	Link     string   `xml:"link"`
+	GUID     string   `xml:"guid"`
+	PubDate  string   `xml:"pubDate"`
+	Category []string `xml:"category"`
+}
+
+type atom struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title    string         `xml:"title"`
+	ID       string         `xml:"id"`
+	Link     atomLink       `xml:"link"`
+	Updated  string         `xml:"updated"`
+	Category []atomCategory `xml:"category"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// updated is the time of the latest article, feed is considered modified when it changes.
+func updated(articles []*Article) time.Time {
+	var t time.Time
+	for _, a := range articles {
+		if a.CreatedAt.After(t) {
+			t = a.CreatedAt
+		}
+	}
+	return t
+}
+
+func renderRSS(r *http.Request, c FeedConfig, articles []*Article) ([]byte, error) {
+	feed := rss{Version: "2.0", Channel: rssChannel{
+		Title:       c.Title,
+		Link:        baseURL(r) + r.URL.Path,
+		Description: c.Title,
+		Items:       []rssItem{},
+	}}
+	if t := updated(articles); !t.IsZero() {
+		feed.Channel.LastBuildDate = t.UTC().Format(time.RFC1123Z)
+	}
+	for _, a := range articles {
+		link := c.link(r, a)
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:    a.Title,
+			Link:     link,
+			GUID:     link,
+			PubDate:  a.CreatedAt.UTC().Format(time.RFC1123Z),
+			Category: a.Tags,
+		})
+	}
+	return marshalFeed(feed)
+}
+
+func renderAtom(r *http.Request, c FeedConfig, articles []*Article) ([]byte, error) {
+	self := baseURL(r) + r.URL.Path
+	feed := atom{
+		Title:   c.Title,
+		ID:      self,
+		Link:    atomLink{Href: self, Rel: "self"},
+		Updated: updated(articles).UTC().Format(time.RFC3339),
+		Entries: []atomEntry{},
+	}
+	for _, a := range articles {
+		link := c.link(r, a)
+		e := atomEntry{
+			Title:   a.Title,
+			ID:      link,
+			Link:    atomLink{Href: link},
+			Updated: a.CreatedAt.UTC().Format(time.RFC3339),
+		}
+		for _, tag := range a.Tags {
+			e.Category = append(e.Category, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	return marshalFeed(feed)
+}
+
+func marshalFeed(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, errors.Wrap(err, "could not encode feed")
+	}
+	return buf.Bytes(), nil
+}
+
+// feedETag is a strong validator of feed content.
+func feedETag(content []byte) string {
+	sum := sha1.Sum(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
@@ -0,0 +1,157 @@
+package article
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+)
+
+// Fields is a subset of article fields to select, nil selects all of them.
+type Fields []string
+
+// allFields are selectable fields in column order, they are named as columns.
+var allFields = []string{"id", "title", "slug", "status", "tags", "views", "created_at", "body", "revision", "author_id", "likes", "bookmarks"}
+
+// ParseFields parses comma-separated field names, id is always selected. Empty string selects all fields.
+func ParseFields(s string) (Fields, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	requested := map[string]bool{"id": true}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if !contains(allFields, name) {
+			return nil, i18n.Errorf("article.invalid_field", name, strings.Join(allFields, ", "))
+		}
+		requested[name] = true
+	}
+	var res Fields
+	for _, name := range allFields {
+		if requested[name] {
+			res = append(res, name)
+		}
+	}
+	return res, nil
+}
+
+func (f Fields) names() []string {
+	if f == nil {
+		return allFields
+	}
+	return f
+}
+
+func (f Fields) columns() string {
+	return strings.Join(f.names(), ", ")
+}
+
+// dest returns pointers to article fields in column order.
+func (f Fields) dest(a *Article) []interface{} {
+	res := make([]interface{}, 0, len(f.names()))
+	for _, name := range f.names() {
+		switch name {
+		case "id":
+			res = append(res, &a.ID)
+		case "title":
+			res = append(res, &a.Title)
+		case "slug":
+			res = append(res, &a.Slug)
+		case "status":
+			res = append(res, &a.Status)
+		case "tags":
+			res = append(res, pq.Array(&a.Tags))
+		case "views":
+			res = append(res, &a.Views)
+		case "created_at":
+			res = append(res, &a.CreatedAt)
+		case "body":
+			res = append(res, &a.Body)
+		case "revision":
+			res = append(res, &a.Revision)
+		case "author_id":
+			res = append(res, &a.AuthorID)
+		case "likes":
+			res = append(res, &a.Likes)
+		case "bookmarks":
+			res = append(res, &a.Bookmarks)
+		}
+	}
+	return res
+}
+
+// derivedFields are response fields which are present when field they are derived from is selected.
+var derivedFields = map[string]string{
+	"status_label": "status",
+	"body_html":    "body",
+	"expanded":     "id",
+	"language":     "title",
+}
+
+// fieldsResponse is article response with only selected fields.
+type fieldsResponse map[string]interface{}
+
+func (fr fieldsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// projectList is project for a list of articles.
+func projectList(w http.ResponseWriter, r *http.Request, articles []*Article, f Fields) ([]render.Renderer, error) {
+	list := newArticleListResponse(articles)
+	if f == nil {
+		return list, nil
+	}
+	for i, a := range articles {
+		v, err := project(w, r, newArticleResponse(a), f)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+// project renders article response and leaves only selected fields of it.
+func project(w http.ResponseWriter, r *http.Request, resp *articleResponse, f Fields) (render.Renderer, error) {
+	if f == nil {
+		return resp, nil
+	}
+	if err := resp.Render(w, r); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal article")
+	}
+	var doc fieldsResponse
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "could not unmarshal article")
+	}
+	for name := range doc {
+		field := name
+		if from, ok := derivedFields[name]; ok {
+			field = from
+		}
+		if !contains(f, field) {
+			delete(doc, name)
+		}
+	}
+	return doc, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
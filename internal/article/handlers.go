@@ -1,24 +1,127 @@
 package article
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"fmt"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 
 	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
 	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/markdown"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/sanitize"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+	"github.com/agalitsyn/goapi/pkg/surrogate"
+	"github.com/agalitsyn/goapi/pkg/unfurl"
 )
 
-func Routes(m *Manager) chi.Router {
+// Options configures article routes.
+type Options struct {
+	// Views counts article views.
+	Views *ViewCounter
+	// Scorer finds related articles.
+	Scorer Scorer
+	// StatsTTL is how long stats are cached.
+	StatsTTL time.Duration
+	Feed     FeedConfig
+	// Duplicates configures duplicate detection on creation.
+	Duplicates Duplicates
+	// Markdown is an allowlist of HTML elements article bodies are rendered to.
+	Markdown markdown.Policy
+	// RenderCacheSize is how many rendered bodies are cached.
+	RenderCacheSize int
+	// Relations can be embedded in article responses with ?expand, related articles are always available.
+	Relations map[string]Relation
+	// Purger purges cached responses of changed articles, nothing is purged if nil.
+	Purger surrogate.Purger
+	// SurrogateMaxAge is how long caches may keep lists and feeds until they are purged, 0 disables caching.
+	SurrogateMaxAge time.Duration
+	// Changes lets clients wait for changes of articles.
+	Changes *ChangeFeed
+	// MaxChangesWait limits how long clients may wait for changes.
+	MaxChangesWait time.Duration
+	// Language is a language articles are written in, they are translated to other languages
+	// client prefers in Accept-Language.
+	Language string
+	// Mentions handles users mentioned in article bodies, mentions are ignored if nil.
+	Mentions MentionHandler
+	// EventHandlers are notified of actions on articles by name.
+	EventHandlers map[string]EventHandler
+	// Preview configures previews of articles for link unfurlers.
+	Preview PreviewConfig
+	// Unfurler fetches metadata of pages articles link to, links can not be expanded if nil.
+	Unfurler *unfurl.Fetcher
+}
+
+// ListSurrogateKey tags responses which include any article, e.g. lists and feeds.
+const ListSurrogateKey = "articles"
+
+// SurrogateKey tags responses of a single article.
+func SurrogateKey(id string) string {
+	return "article:" + id
+}
+
+func Routes(m *Manager, opts Options) chi.Router {
 	r := chi.NewRouter()
+	relations := map[string]Relation{"related": relatedRelation(opts.Scorer), "preview": previewRelation(opts.Preview)}
+	if opts.Unfurler != nil {
+		relations["links"] = linksRelation(opts.Unfurler)
+	}
+	for name, rel := range opts.Relations {
+		relations[name] = rel
+	}
+	v := &viewer{
+		views:     opts.Views,
+		bodies:    newBodyCache(opts.Markdown, m.html, opts.RenderCacheSize),
+		relations: relations,
+		language:  opts.Language,
+	}
+	purger := opts.Purger
+	if purger == nil {
+		purger = surrogate.Purgers(nil)
+	}
+	// waiters are woken up by timeout only unless feed runs
+	changes := opts.Changes
+	if changes == nil {
+		changes = NewChangeFeed(m, time.Second)
+	}
 
-	r.Get("/", makeHandler(m, listHandler))
+	r.Get("/", makeHandler(m, listHandler(opts.SurrogateMaxAge)))
+	r.Post("/batch-get", makeHandler(m, batchGetHandler))
+	r.Get("/statuses", statusesHandler)
+	r.Get("/changes", makeHandler(m, changesHandler(changes, opts.MaxChangesWait)))
+	r.Get("/stats", makeHandler(m, statsHandler(newStatsCache(opts.StatsTTL))))
+	r.Get("/most-viewed", makeHandler(m, mostViewedHandler(opts.Views)))
+	r.Get("/slug/{slug}", makeHandler(m, slugHandler(v)))
+	r.Get("/bookmarks", makeHandler(m, bookmarksHandler))
+	r.Get("/feed.rss", makeHandler(m, feedHandler(opts.Feed, opts.SurrogateMaxAge, "application/rss+xml; charset=utf-8", renderRSS)))
+	r.Get("/feed.atom", makeHandler(m, feedHandler(opts.Feed, opts.SurrogateMaxAge, "application/atom+xml; charset=utf-8", renderAtom)))
 
-	r.Route("/:articleID", func(r chi.Router) {
-		r.Put("/", makeHandler(m, putHandler))
-		r.Delete("/", makeHandler(m, deleteHandler))
+	r.Route("/{articleID}", func(r chi.Router) {
+		r.Get("/", makeHandler(m, getHandler(v)))
+		r.Get("/related", makeHandler(m, relatedHandler(opts.Scorer)))
+		r.Get("/preview", makeHandler(m, previewHandler(opts.Preview, opts.SurrogateMaxAge)))
+		r.Put("/", makeHandler(m, putHandler(opts.Duplicates, purger, &mentions{handler: opts.Mentions, urlTemplate: opts.Feed.ArticleURL}, events(opts.EventHandlers))))
+		r.Post("/preview-update", makeHandler(m, previewUpdateHandler))
+		r.Delete("/", makeHandler(m, deleteHandler(purger, events(opts.EventHandlers))))
+		r.Get("/translations", makeHandler(m, translationsHandler))
+		r.Get("/translations/{lang}", makeHandler(m, translationHandler))
+		r.Put("/translations/{lang}", makeHandler(m, putTranslationHandler(opts.Language, purger)))
+		r.Delete("/translations/{lang}", makeHandler(m, deleteTranslationHandler(purger)))
+		for path, kind := range map[string]Reaction{"/like": ReactionLike, "/bookmark": ReactionBookmark} {
+			r.Get(path, makeHandler(m, reactionHandler(kind)))
+			r.Put(path, makeHandler(m, reactHandler(kind, true)))
+			r.Delete(path, makeHandler(m, reactHandler(kind, false)))
+		}
 	})
 
 	return r
@@ -28,91 +131,1067 @@ type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
 
 func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		handler(m, w, r)
+		handler(m.WithContext(r.Context()), w, r)
 	}
 }
 
-func listHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+// listHandler responds with all articles, or with requested ones when ?ids=1,2,3 is passed,
+// or with articles of author when ?author_id= is passed.
+// All articles are not sent again to clients which pass If-Modified-Since unless articles are changed since.
+// Responses are tagged with ListSurrogateKey, so caches may keep them for maxAge until purged.
+func listHandler(maxAge time.Duration) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+		surrogate.Tag(w, ListSurrogateKey)
+		surrogate.Control(w, maxAge)
+
+		if ids := r.URL.Query().Get("ids"); ids != "" {
+			renderBatch(m, w, r, strings.Split(ids, ","))
+			return
+		}
+
+		fields, err := ParseFields(r.URL.Query().Get("fields"))
+		if err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		authorID := r.URL.Query().Get("author_id")
+		if n, err := strconv.ParseInt(authorID, 10, 32); authorID != "" && (err != nil || n <= 0) {
+			err := i18n.Errorf("article.invalid_author", authorID)
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		modified, err := m.LastModified()
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		if handler.NotModified(w, r, modified) {
+			return
+		}
+		var articles []*Article
+		if authorID != "" {
+			articles, err = m.Select(fields).ByAuthor(authorID)
+		} else {
+			articles, err = m.Select(fields).All()
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		list, err := projectList(w, r, articles, fields)
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		if err := render.RenderList(w, r, list); err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+	}
+}
+
+// batchGetHandler is like listHandler with ?ids, for lists of ids which do not fit in URL.
+func batchGetHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
 	logger := log.GetLogEntry(r).WithField("context", "article")
 
-	articles, err := m.All()
+	var data struct {
+		IDs []string `json:"ids"`
+	}
+	if err := serializer.Decode(r, &data); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	renderBatch(m, w, r, data.IDs)
+}
+
+// maxBatchIDs limits how many articles are fetched by a single batch request.
+const maxBatchIDs = 100
+
+// renderBatch responds with found or missing status of every requested article in the requested order.
+func renderBatch(m *Manager, w http.ResponseWriter, r *http.Request, ids []string) {
+	logger := log.GetLogEntry(r).WithField("context", "article")
+
+	unique, err := batchIDs(ids)
+	if err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	fields, err := ParseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+
+	articles, err := m.Select(fields).ByIDs(unique)
 	if err != nil {
 		logger.WithError(err).Error()
 		render.Render(w, r, handler.ErrUnknown(err))
 		return
 	}
-	if err := render.RenderList(w, r, newArticleListResponse(articles)); err != nil {
+	byID := make(map[string]*Article, len(articles))
+	for _, a := range articles {
+		byID[a.ID] = a
+	}
+
+	list := make([]render.Renderer, 0, len(unique))
+	for _, id := range unique {
+		item := &batchItem{ID: id}
+		if a, ok := byID[id]; ok {
+			item.Found = true
+			if item.Article, err = project(w, r, newArticleResponse(a), fields); err != nil {
+				logger.WithError(err).Error()
+				render.Render(w, r, handler.ErrUnknown(err))
+				return
+			}
+		}
+		list = append(list, item)
+	}
+	if err := render.RenderList(w, r, list); err != nil {
 		logger.WithError(err).Error()
 		render.Render(w, r, handler.ErrUnknown(err))
 		return
 	}
 }
 
-func putHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+// batchIDs validates and deduplicates ids keeping their order.
+func batchIDs(ids []string) ([]string, error) {
+	var res []string
+	seen := map[string]bool{}
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if n, err := strconv.ParseInt(id, 10, 32); err != nil || n <= 0 {
+			return nil, i18n.Errorf("article.invalid_ids", maxBatchIDs)
+		}
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	if len(res) == 0 || len(res) > maxBatchIDs {
+		return nil, i18n.Errorf("article.invalid_ids", maxBatchIDs)
+	}
+	return res, nil
+}
+
+// getHandler counts a view of article, body is rendered to HTML with ?render=html.
+// Title and body are translated to the language client prefers when there is a translation.
+func getHandler(v *viewer) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+
+		fields, err := ParseFields(r.URL.Query().Get("fields"))
+		if err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		exps, err := parseExpand(r.URL.Query().Get("expand"), v.relations)
+		if err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		article, err := m.Select(fields).ByID(chi.URLParam(r, "articleID"))
+		if err != nil {
+			if err == ErrNotFound {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrNotFound(err))
+				return
+			}
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		if err := v.localize(m, w, r, article, fields); err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+
+		v.view(w, r, article, fields, exps)
+	}
+}
+
+// viewer renders single articles.
+type viewer struct {
+	views     *ViewCounter
+	bodies    *bodyCache
+	relations map[string]Relation
+	// language is the original language of articles.
+	language string
+}
+
+// localize translates title and body of article to the language client prefers and sets Content-Language
+// of response to the language of article, it does nothing when neither title nor body is selected.
+func (v *viewer) localize(m *Manager, w http.ResponseWriter, r *http.Request, a *Article, fields Fields) error {
+	if fields != nil && !contains(fields, "title") && !contains(fields, "body") {
+		return nil
+	}
+	if err := m.Localize(a, v.language, i18n.Preferences(r.Header.Get("Accept-Language"))); err != nil {
+		return err
+	}
+	lang := a.Language
+	if lang == "" {
+		lang = v.language
+	}
+	if lang != "" {
+		w.Header().Set("Content-Language", lang)
+	}
+	return nil
+}
+
+// view counts a view of article, so views in response include this one, and renders its fields with expanded relations.
+func (v *viewer) view(w http.ResponseWriter, r *http.Request, a *Article, fields Fields, exps []*expansion) {
+	logger := log.GetLogEntry(r).WithField("context", "article")
+
+	v.views.Inc(a.ID)
+	a.Views += v.views.Pending(a.ID)
+	surrogate.Tag(w, SurrogateKey(a.ID))
+
+	resp := newArticleResponse(a)
+	if wantHTML(r) {
+		resp.BodyHTML = v.bodies.html(a)
+	}
+	if err := expand(w, r, resp, exps, v.relations); err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	res, err := project(w, r, resp, fields)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.Render(w, r, res)
+}
+
+// slugHandler responds like getHandler, old slugs are redirected to the current one.
+func slugHandler(v *viewer) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+
+		fields, err := ParseFields(r.URL.Query().Get("fields"))
+		if err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		exps, err := parseExpand(r.URL.Query().Get("expand"), v.relations)
+		if err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		slug := chi.URLParam(r, "slug")
+		article, err := m.Select(fields).BySlug(slug)
+		if err == ErrNotFound {
+			current, err := m.CurrentSlug(slug)
+			if err != nil {
+				if err == ErrNotFound {
+					logger.WithError(err).Warn()
+					render.Render(w, r, handler.ErrNotFound(err))
+					return
+				}
+				logger.WithError(err).Error()
+				render.Render(w, r, handler.ErrUnknown(err))
+				return
+			}
+			u := *r.URL
+			u.Path = strings.TrimSuffix(u.Path, slug) + current
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		if err := v.localize(m, w, r, article, fields); err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+
+		v.view(w, r, article, fields, exps)
+	}
+}
+
+// relatedHandler accepts ?limit= of articles to return.
+func relatedHandler(scorer Scorer) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+
+		limit, err := intParam(r, "limit", 5, 1, 50)
+		if err != nil {
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		article, err := m.ByID(chi.URLParam(r, "articleID"))
+		if err != nil {
+			if err == ErrNotFound {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrNotFound(err))
+				return
+			}
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+
+		related, err := scorer.Related(article, limit)
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		list := make([]render.Renderer, 0, len(related))
+		for _, a := range related {
+			list = append(list, &relatedResponse{Article: a.Article, Score: a.Score})
+		}
+		if err := render.RenderList(w, r, list); err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+	}
+}
+
+// mostViewedHandler accepts ?limit= of articles to return.
+func mostViewedHandler(views *ViewCounter) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+
+		limit, err := intParam(r, "limit", 10, 1, 100)
+		if err != nil {
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		articles, err := m.MostViewed(limit)
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		for _, a := range articles {
+			a.Views += views.Pending(a.ID)
+		}
+		if err := render.RenderList(w, r, newArticleListResponse(articles)); err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+	}
+}
+
+// feedHandler serves feed of latest published articles, conditional requests are handled
+// with Last-Modified of the latest article and ETag of content.
+func feedHandler(c FeedConfig, maxAge time.Duration, contentType string, renderFeed func(*http.Request, FeedConfig, []*Article) ([]byte, error)) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+
+		articles, err := m.Published(c.Size)
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		content, err := renderFeed(r, c, articles)
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(c.MaxAge.Seconds())))
+		w.Header().Set("ETag", feedETag(content))
+		surrogate.Tag(w, ListSurrogateKey)
+		surrogate.Control(w, maxAge)
+		http.ServeContent(w, r, "", updated(articles), bytes.NewReader(content))
+	}
+}
+
+// changesHandler responds with changes after ?since cursor, or after the last one if it is absent.
+// With ?wait=30s it holds request until there are changes or wait passes, for clients which poll.
+func changesHandler(feed *ChangeFeed, maxWait time.Duration) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+
+		limit, err := intParam(r, "limit", 100, 1, 1000)
+		if err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		var wait time.Duration
+		if v := r.URL.Query().Get("wait"); v != "" {
+			if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+				err := i18n.Errorf("article.invalid_wait", v)
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrBadRequest(err))
+				return
+			}
+		}
+		if wait > maxWait {
+			wait = maxWait
+		}
+		var cursor int64
+		if v := r.URL.Query().Get("since"); v != "" {
+			var ok bool
+			if cursor, ok = parseCursor(v); !ok {
+				err := i18n.Errorf("article.invalid_cursor", v)
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrBadRequest(err))
+				return
+			}
+		} else if cursor, err = m.LastChange(); err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		changes, err := feed.Wait(ctx, m, cursor, limit)
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		resp := &changesResponse{Changes: changes, Cursor: cursor}
+		if len(changes) > 0 {
+			resp.Cursor = changes[len(changes)-1].Cursor
+		} else {
+			resp.Changes = []*Change{}
+		}
+		render.Render(w, r, resp)
+	}
+}
+
+// statusesHandler lists statuses with labels in the request locale, so clients don't hardcode translations.
+func statusesHandler(w http.ResponseWriter, r *http.Request) {
+	values := make([]string, 0, len(Statuses))
+	for _, s := range Statuses {
+		values = append(values, string(s))
+	}
+	render.JSON(w, r, i18n.Labels(reqctx.GetLocale(r.Context()), statusEnum, values...))
+}
+
+// statsHandler accepts ?tz= to count days in, ?days= histogram length and ?tags= top tags limit.
+func statsHandler(cache *statsCache) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+
+		loc, err := serializer.Location(r)
+		if err != nil {
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		q := StatsQuery{Location: loc}
+		if q.Days, err = intParam(r, "days", 30, 1, 366); err != nil {
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if q.Tags, err = intParam(r, "tags", 10, 0, 100); err != nil {
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+
+		stats, err := cache.get(m, q)
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		render.JSON(w, r, stats)
+	}
+}
+
+// intParam reads integer query parameter in [min, max] range, def is returned when it is absent.
+func intParam(r *http.Request, name string, def, min, max int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		return 0, i18n.Errorf("request.invalid_param", name, min, max)
+	}
+	return n, nil
+}
+
+// putHandler creates or updates article, on dry run the resulting article is rendered but not persisted.
+// New articles duplicating existing ones are rejected with 409 when duplicate detection is enabled,
+// so are articles hidden by moderation. Users newly mentioned in body are passed to mention handler.
+func putHandler(dup Duplicates, p surrogate.Purger, ms *mentions, ev events) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+		dryRun := handler.DryRun(w, r)
+
+		var data articleRequest
+		if err := serializer.Decode(r, &data); err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if err := data.validate(m.html); err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+
+		articleID := chi.URLParam(r, "articleID")
+		// hidden article must not be replaced with a new one with another id
+		article, err := m.byID(articleID, true)
+		if err == ErrHidden {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrConflict(err))
+			return
+		}
+		if err != nil && err != ErrNotFound {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		if article == nil {
+			d := &Article{
+				ID:     articleID,
+				Title:  data.Title,
+				Slug:   data.Slug,
+				Status: data.Status,
+				Tags:   data.Tags,
+			}
+			if data.Body != nil {
+				d.Body = *data.Body
+			}
+			if data.AuthorID != nil && *data.AuthorID != "" {
+				d.AuthorID = data.AuthorID
+			}
+			if u := reqctx.GetUser(r.Context()); u != nil {
+				d.OwnerID = u.ID
+			}
+			if d.Status == "" {
+				d.Status = StatusDraft
+			}
+			if d.Slug == "" {
+				d.Slug = Slugify(d.Title)
+			}
+			if d.Slug == "" {
+				d.Slug = "article"
+			}
+			if checkDuplicates(r, dup.Check) {
+				existing, err := m.FindDuplicate(d, dup.Threshold)
+				if err != nil {
+					logger.WithError(err).Error()
+					render.Render(w, r, handler.ErrUnknown(err))
+					return
+				}
+				if existing != nil {
+					err := i18n.Errorf("article.duplicate", existing.ID)
+					logger.WithError(err).Warn()
+					w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="duplicate"`, path.Join(path.Dir(r.URL.Path), existing.ID)))
+					render.Render(w, r, handler.ErrConflict(err))
+					return
+				}
+			}
+			base := d.Slug
+			err := m.slugTx(dryRun, func(m *Manager) (err error) {
+				if d.Slug, err = m.UniqueSlug(base, ""); err != nil {
+					return err
+				}
+				return m.Save(d)
+			})
+			if err == ErrUnknownAuthor {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrBadRequest(err))
+				return
+			}
+			if err == ErrSlugTaken {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrConflict(err))
+				return
+			}
+			if err != nil {
+				logger.WithError(err).Error()
+				render.Render(w, r, handler.ErrUnknown(err))
+				return
+			}
+
+			if !dryRun {
+				purge(r, p, ListSurrogateKey)
+				ms.notify(r, d, "")
+				ev.emit(r, ActionCreated, d)
+				if d.Status == StatusPublished {
+					ev.emit(r, ActionPublished, d)
+				}
+			}
+			render.Status(r, http.StatusCreated)
+			render.Render(w, r, newArticleResponse(d))
+		} else {
+			oldSlug, oldBody, oldStatus := article.Slug, article.Body, article.Status
+			article.Title = data.Title
+			if data.Slug != "" {
+				article.Slug = data.Slug
+			}
+			if data.Status != "" {
+				article.Status = data.Status
+			}
+			if data.Tags != nil {
+				article.Tags = data.Tags
+			}
+			if data.Body != nil {
+				article.Body = *data.Body
+			}
+			if data.AuthorID != nil {
+				article.AuthorID = data.AuthorID
+				if *data.AuthorID == "" {
+					article.AuthorID = nil
+				}
+			}
+			base := article.Slug
+			err := m.slugTx(dryRun, func(m *Manager) (err error) {
+				if article.Slug, err = m.UniqueSlug(base, article.ID); err != nil {
+					return err
+				}
+				if err := m.Update(article); err != nil {
+					return err
+				}
+				if article.Slug != oldSlug {
+					return m.RenameSlug(article, oldSlug)
+				}
+				return nil
+			})
+			if err == ErrUnknownAuthor {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrBadRequest(err))
+				return
+			}
+			// article is hidden since it is read, or its slug is taken by article saved concurrently
+			if err == ErrHidden || err == ErrSlugTaken {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrConflict(err))
+				return
+			}
+			if err != nil {
+				logger.WithError(err).Error()
+				render.Render(w, r, handler.ErrUnknown(err))
+				return
+			}
+
+			if !dryRun {
+				purge(r, p, ListSurrogateKey, SurrogateKey(article.ID))
+				ms.notify(r, article, oldBody)
+				ev.emit(r, ActionUpdated, article)
+				if oldStatus != StatusPublished && article.Status == StatusPublished {
+					ev.emit(r, ActionPublished, article)
+				}
+			}
+			render.Render(w, r, newArticleResponse(article))
+		}
+	}
+}
+
+// purge purges cached responses after article is changed, failure is logged since change is saved anyway,
+// and cached responses expire eventually.
+func purge(r *http.Request, p surrogate.Purger, keys ...string) {
+	if err := p.Purge(r.Context(), keys...); err != nil {
+		log.GetLogEntry(r).WithField("context", "article").WithError(err).Error("could not purge cached responses")
+	}
+}
+
+// checkDuplicates reads ?check_duplicates, def is returned when it is absent or invalid.
+func checkDuplicates(r *http.Request, def bool) bool {
+	check, err := strconv.ParseBool(r.URL.Query().Get("check_duplicates"))
+	if err != nil {
+		return def
+	}
+	return check
+}
+
+// previewUpdateHandler applies patch to article and renders the result with field-level diff, nothing is persisted.
+func previewUpdateHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
 	logger := log.GetLogEntry(r).WithField("context", "article")
 
-	var data articleRequest
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+	var patch articlePatch
+	if err := serializer.Decode(r, &patch); err != nil {
 		logger.WithError(err).Warn()
 		render.Render(w, r, handler.ErrBadRequest(err))
 		return
 	}
 
-	articleID := chi.URLParam(r, "articleID")
-	article, err := m.ByID(articleID)
-	if err != nil && err != ErrNotFound {
+	article, err := m.ByID(chi.URLParam(r, "articleID"))
+	if err != nil {
+		if err == ErrNotFound {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
 		logger.WithError(err).Error()
 		render.Render(w, r, handler.ErrUnknown(err))
 		return
 	}
-	if article == nil {
-		d := &Article{
-			ID:    articleID,
-			Title: data.Title,
-			Slug:  data.Slug,
-		}
-		if err := m.Save(d); err != nil {
+
+	updated := patch.apply(article)
+	req := articleRequest{Title: updated.Title, Slug: updated.Slug, Status: updated.Status, Tags: updated.Tags, Body: &updated.Body}
+	if err := req.validate(m.html); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	updated.Title, updated.Tags = req.Title, req.Tags
+
+	render.Render(w, r, &previewResponse{
+		Article: newArticleResponse(updated),
+		Changes: diff(article, updated),
+	})
+}
+
+// deleteHandler on dry run renders article which would be deleted.
+func deleteHandler(p surrogate.Purger, ev events) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+		dryRun := handler.DryRun(w, r)
+
+		articleID := chi.URLParam(r, "articleID")
+		article, err := m.ByID(articleID)
+		if err != nil {
+			if err == ErrNotFound {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrNotFound(err))
+				return
+			}
 			logger.WithError(err).Error()
 			render.Render(w, r, handler.ErrUnknown(err))
 			return
 		}
-
-		render.Status(r, http.StatusCreated)
-		render.Render(w, r, newArticleResponse(d))
-	} else {
-		article.Title = data.Title
-		article.Slug = data.Slug
-		if err := m.Update(article); err != nil {
+		err = m.Tx(dryRun, func(m *Manager) error {
+			return m.Delete(article)
+		})
+		if err != nil {
 			logger.WithError(err).Error()
 			render.Render(w, r, handler.ErrUnknown(err))
 			return
 		}
+		if dryRun {
+			render.Render(w, r, newArticleResponse(article))
+			return
+		}
+		purge(r, p, ListSurrogateKey, SurrogateKey(article.ID))
+		ev.emit(r, ActionDeleted, article)
+		render.NoContent(w, r)
+	}
+}
+
+// translationsHandler lists translations of article.
+func translationsHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "article")
 
-		render.Render(w, r, newArticleResponse(article))
+	article, err := m.Select(Fields{"id"}).ByID(chi.URLParam(r, "articleID"))
+	if err != nil {
+		if err == ErrNotFound {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
 	}
+	translations, err := m.Translations(article.ID)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	list := []render.Renderer{}
+	for _, t := range translations {
+		list = append(list, &translationResponse{t})
+	}
+	render.RenderList(w, r, list)
 }
 
-func deleteHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+// translationHandler responds with translation of article to {lang}.
+func translationHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
 	logger := log.GetLogEntry(r).WithField("context", "article")
 
-	articleID := chi.URLParam(r, "articleID")
-	article, err := m.ByID(articleID)
+	t, err := translationParam(m, r)
 	if err != nil {
 		if err == ErrNotFound {
 			logger.WithError(err).Warn()
 			render.Render(w, r, handler.ErrNotFound(err))
 			return
 		}
+		if _, ok := err.(*i18n.Error); ok {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
 		logger.WithError(err).Error()
 		render.Render(w, r, handler.ErrUnknown(err))
 		return
 	}
-	if err := m.Delete(article); err != nil {
+	render.Render(w, r, &translationResponse{t})
+}
+
+// translationParam returns translation of {articleID} to {lang}.
+func translationParam(m *Manager, r *http.Request) (*Translation, error) {
+	lang, err := ParseLanguage(chi.URLParam(r, "lang"))
+	if err != nil {
+		return nil, err
+	}
+	translations, err := m.Translations(chi.URLParam(r, "articleID"), lang)
+	if err != nil {
+		return nil, err
+	}
+	if len(translations) == 0 {
+		return nil, ErrNotFound
+	}
+	return translations[0], nil
+}
+
+// putTranslationHandler creates or replaces translation of article to {lang}, which must differ from
+// the original language. On dry run the resulting translation is rendered but not persisted.
+func putTranslationHandler(original string, p surrogate.Purger) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+		dryRun := handler.DryRun(w, r)
+
+		lang, err := ParseLanguage(chi.URLParam(r, "lang"))
+		if err == nil && lang == original {
+			err = i18n.Errorf("article.original_lang", lang)
+		}
+		if err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		var data translationRequest
+		if err := serializer.Decode(r, &data); err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if err := data.validate(m.html); err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+
+		article, err := m.Select(Fields{"id"}).ByID(chi.URLParam(r, "articleID"))
+		if err != nil {
+			if err == ErrNotFound {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrNotFound(err))
+				return
+			}
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		t := &Translation{ArticleID: article.ID, Language: lang, Title: data.Title, Body: data.Body}
+		var created bool
+		err = m.Tx(dryRun, func(m *Manager) (err error) {
+			created, err = m.SaveTranslation(t)
+			return err
+		})
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+
+		if !dryRun {
+			purge(r, p, SurrogateKey(article.ID))
+		}
+		if created {
+			render.Status(r, http.StatusCreated)
+		}
+		render.Render(w, r, &translationResponse{t})
+	}
+}
+
+// deleteTranslationHandler on dry run renders translation which would be deleted.
+func deleteTranslationHandler(p surrogate.Purger) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+		dryRun := handler.DryRun(w, r)
+
+		t, err := translationParam(m, r)
+		if err == nil {
+			err = m.Tx(dryRun, func(m *Manager) error {
+				return m.DeleteTranslation(t.ArticleID, t.Language)
+			})
+		}
+		if err != nil {
+			if err == ErrNotFound {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrNotFound(err))
+				return
+			}
+			if _, ok := err.(*i18n.Error); ok {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrBadRequest(err))
+				return
+			}
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		if dryRun {
+			render.Render(w, r, &translationResponse{t})
+			return
+		}
+		purge(r, p, SurrogateKey(t.ArticleID))
+		render.NoContent(w, r)
+	}
+}
+
+// reactionHandler responds whether user has reaction of the kind to article and how many users have.
+func reactionHandler(kind Reaction) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+
+		u := reqctx.GetUser(r.Context())
+		if u == nil {
+			err := i18n.Errorf("article.user_required")
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrUnauthorized(err))
+			return
+		}
+		article, err := m.Select(Fields{"id", kind.counter()}).ByID(chi.URLParam(r, "articleID"))
+		if err != nil {
+			if err == ErrNotFound {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrNotFound(err))
+				return
+			}
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		active, err := m.Reacted(article.ID, u.ID, kind)
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		count := article.Likes
+		if kind == ReactionBookmark {
+			count = article.Bookmarks
+		}
+		render.Render(w, r, &reactionResponse{Kind: kind, Active: active, Count: count})
+	}
+}
+
+// reactHandler puts reaction of user on article or takes it off, repeated requests change nothing,
+// so clients may retry them and toggle by state they show.
+func reactHandler(kind Reaction, on bool) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+
+		u := reqctx.GetUser(r.Context())
+		if u == nil {
+			err := i18n.Errorf("article.user_required")
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrUnauthorized(err))
+			return
+		}
+		count, err := m.React(chi.URLParam(r, "articleID"), u.ID, kind, on)
+		if err != nil {
+			if err == ErrNotFound {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrNotFound(err))
+				return
+			}
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		render.Render(w, r, &reactionResponse{Kind: kind, Active: on, Count: count})
+	}
+}
+
+// bookmarksHandler lists articles bookmarked by user, it accepts ?limit= and ?fields=.
+func bookmarksHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "article")
+
+	u := reqctx.GetUser(r.Context())
+	if u == nil {
+		err := i18n.Errorf("article.user_required")
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrUnauthorized(err))
+		return
+	}
+	limit, err := intParam(r, "limit", 20, 1, 100)
+	if err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	fields, err := ParseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	articles, err := m.Select(fields).Bookmarked(u.ID, limit)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	list, err := projectList(w, r, articles, fields)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	if err := render.RenderList(w, r, list); err != nil {
 		logger.WithError(err).Error()
 		render.Render(w, r, handler.ErrUnknown(err))
 		return
 	}
-	render.NoContent(w, r)
+}
+
+type reactionResponse struct {
+	Kind Reaction `json:"kind"`
+	// Active reports whether user has reaction.
+	Active bool `json:"active"`
+	// Count is how many users have reaction.
+	Count int64 `json:"count"`
+}
+
+func (rr *reactionResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type translationResponse struct {
+	*Translation
+}
+
+func (tr *translationResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type changesResponse struct {
+	Changes []*Change `json:"changes"`
+	// Cursor is passed as ?since to get the next changes.
+	Cursor int64 `json:"cursor,string"`
+}
+
+func (cr *changesResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
 }
 
 func newArticleListResponse(articles []*Article) []render.Renderer {
@@ -124,18 +1203,133 @@ func newArticleListResponse(articles []*Article) []render.Renderer {
 }
 
 func newArticleResponse(article *Article) *articleResponse {
-	return &articleResponse{article}
+	return &articleResponse{Article: article}
 }
 
+// statusEnum is a name of status enum in i18n catalogs.
+const statusEnum = "article_status"
+
 type articleResponse struct {
 	*Article
+
+	StatusLabel string `json:"status_label"`
+	// BodyHTML is set when client asked for rendered body.
+	BodyHTML string `json:"body_html,omitempty"`
+	// Expanded are relations client asked to embed.
+	Expanded map[string]interface{} `json:"expanded,omitempty"`
 }
 
 func (dr *articleResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	dr.StatusLabel = i18n.EnumLabel(reqctx.GetLocale(r.Context()), statusEnum, string(dr.Status))
+	return nil
+}
+
+type relatedResponse struct {
+	*Article
+
+	StatusLabel string  `json:"status_label"`
+	Score       float64 `json:"score"`
+}
+
+func (rr *relatedResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	rr.StatusLabel = i18n.EnumLabel(reqctx.GetLocale(r.Context()), statusEnum, string(rr.Status))
+	return nil
+}
+
+type previewResponse struct {
+	Article *articleResponse `json:"article"`
+	Changes []FieldChange    `json:"changes"`
+}
+
+// Render is no-op, article is rendered by go-chi/render as a nested renderer.
+func (pr *previewResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// articlePatch is a merge patch, absent fields are left as is.
+type articlePatch struct {
+	Title  *string   `json:"title"`
+	Slug   *string   `json:"slug"`
+	Status *Status   `json:"status"`
+	Tags   *[]string `json:"tags"`
+	Body   *string   `json:"body"`
+}
+
+// apply returns patched copy of article.
+func (p *articlePatch) apply(a *Article) *Article {
+	res := *a
+	if p.Title != nil {
+		res.Title = *p.Title
+	}
+	if p.Slug != nil {
+		res.Slug = *p.Slug
+	}
+	if p.Status != nil {
+		res.Status = *p.Status
+	}
+	if p.Tags != nil {
+		res.Tags = *p.Tags
+	}
+	if p.Body != nil {
+		res.Body = *p.Body
+	}
+	return &res
+}
+
+type batchItem struct {
+	ID      string          `json:"id"`
+	Found   bool            `json:"found"`
+	Article render.Renderer `json:"article,omitempty"`
+}
+
+func (bi *batchItem) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
 type articleRequest struct {
-	Title string `json:"title"`
-	Slug  string `json:"slug"`
+	Title  string   `json:"title"`
+	Slug   string   `json:"slug"`
+	Status Status   `json:"status"`
+	Tags   []string `json:"tags"`
+	// Body is left as is on update when absent.
+	Body *string `json:"body"`
+	// AuthorID is left as is on update when absent, empty string removes author.
+	AuthorID *string `json:"author_id"`
+}
+
+// validate sanitizes request with html policy and checks it. HTML is stripped from title and tags,
+// body is sanitized, so it can be embedded as is, Markdown in it is kept.
+func (ar *articleRequest) validate(html sanitize.Policy) error {
+	ar.Title = sanitize.TextPolicy.Sanitize(ar.Title)
+	if ar.Tags != nil {
+		tags := make([]string, 0, len(ar.Tags))
+		for _, tag := range ar.Tags {
+			tags = append(tags, sanitize.TextPolicy.Sanitize(tag))
+		}
+		ar.Tags = tags
+	}
+	if ar.Body != nil {
+		*ar.Body = html.Sanitize(*ar.Body)
+	}
+
+	if strings.TrimSpace(ar.Title) == "" {
+		return i18n.Errorf("article.title_required")
+	}
+	// slug is generated from title when absent
+	if ar.Slug != "" {
+		slug := Slugify(ar.Slug)
+		if slug == "" {
+			return i18n.Errorf("article.invalid_slug", ar.Slug)
+		}
+		ar.Slug = slug
+	}
+	if ar.Status != "" && !ar.Status.valid() {
+		return i18n.Errorf("article.invalid_status", ar.Status)
+	}
+	if ar.AuthorID != nil && *ar.AuthorID != "" {
+		if n, err := strconv.ParseInt(*ar.AuthorID, 10, 32); err != nil || n <= 0 {
+			return i18n.Errorf("article.invalid_author", *ar.AuthorID)
+		}
+	}
+	return nil
 }
@@ -2,17 +2,39 @@ package article
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/lib/pq"
+
+	"github.com/agalitsyn/goapi/internal/privacy"
+	"github.com/agalitsyn/goapi/pkg/clock"
 	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
 	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/markdown"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/sanitize"
+	"github.com/agalitsyn/goapi/pkg/surrogate"
+	"github.com/agalitsyn/goapi/pkg/unfurl"
 
 	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
 
+var articleColumns = []string{"id", "title", "slug", "status", "tags", "views", "created_at", "body", "revision", "author_id", "likes", "bookmarks"}
+
+// putColumns are read by putHandler, which tells hidden articles from missing ones.
+var putColumns = append(append([]string(nil), articleColumns...), "hidden")
+
 func TestListHandler(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -21,33 +43,192 @@ func TestListHandler(t *testing.T) {
 	defer db.Close()
 
 	m := &Manager{db: db}
+	modified := time.Date(2018, 6, 15, 12, 0, 0, 500, time.UTC)
 
-	mock.ExpectQuery("SELECT id, title, slug FROM article;").
-		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "slug"}).
-			AddRow(1, "Новая", "new"))
+	mock.ExpectQuery("SELECT GREATEST(.+) FROM article_deletion(.+)").
+		WillReturnRows(sqlmock.NewRows([]string{"greatest"}).AddRow(modified))
+	mock.ExpectQuery("SELECT id, title, slug, status, tags, views, created_at, body, revision, author_id, likes, bookmarks FROM article WHERE NOT hidden;").
+		WillReturnRows(sqlmock.NewRows(articleColumns).
+			AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "", 1, nil, 0, 0))
 
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
 
 	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
-	r.Get("/", makeHandler(m, listHandler))
+	r.Get("/", makeHandler(m, listHandler(0)))
 	r.ServeHTTP(w, req)
 
 	resp := w.Result()
 	if resp.StatusCode != http.StatusOK {
 		t.Errorf("unexpected status: %v", resp.StatusCode)
 	}
+	lastModified := resp.Header.Get("Last-Modified")
+	if lastModified != "Fri, 15 Jun 2018 12:00:00 GMT" {
+		t.Errorf("unexpected Last-Modified: %v", lastModified)
+	}
 
 	body, _ := ioutil.ReadAll(resp.Body)
 	if string(body) == "" {
 		t.Errorf("unexpected body: %v", string(body))
 	}
 
+	// unchanged list is not queried and sent again
+	mock.ExpectQuery("SELECT GREATEST(.+) FROM article_deletion(.+)").
+		WillReturnRows(sqlmock.NewRows([]string{"greatest"}).AddRow(modified))
+	req.Header.Set("If-Modified-Since", lastModified)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("unexpected response: %v %s", w.Code, w.Body.String())
+	}
+
+	// change within the same second is told by ETag
+	etag := resp.Header.Get("ETag")
+	mock.ExpectQuery("SELECT GREATEST(.+) FROM article_deletion(.+)").
+		WillReturnRows(sqlmock.NewRows([]string{"greatest"}).AddRow(modified.Add(time.Millisecond)))
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE NOT hidden;").
+		WillReturnRows(sqlmock.NewRows(articleColumns))
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("unexpected response: %v %v", w.Code, w.Header())
+	}
+	req.Header.Del("If-None-Match")
+
+	// changed list is sent
+	mock.ExpectQuery("SELECT GREATEST(.+) FROM article_deletion(.+)").
+		WillReturnRows(sqlmock.NewRows([]string{"greatest"}).AddRow(modified.Add(time.Second)))
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE NOT hidden;").
+		WillReturnRows(sqlmock.NewRows(articleColumns))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestListHandler_Fields(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db}
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/", makeHandler(m, listHandler(0)))
+
+	mock.ExpectQuery("SELECT GREATEST(.+)").
+		WillReturnRows(sqlmock.NewRows([]string{"greatest"}).AddRow(time.Now()))
+	mock.ExpectQuery("SELECT id, status, created_at FROM article WHERE NOT hidden;").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "created_at"}).
+			AddRow(1, "published", time.Now()))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/?fields=created_at,status", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+
+	var items []map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("unexpected items: %v", items)
+	}
+	var keys []string
+	for k := range items[0] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "created_at,id,status,status_label" {
+		t.Errorf("unexpected fields: %v", keys)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/?fields=title,password", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestListHandler_Batch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db}
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/", makeHandler(m, listHandler(0)))
+	r.Post("/batch-get", makeHandler(m, batchGetHandler))
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "http://example.com/?ids=3,1,3", nil),
+		httptest.NewRequest(http.MethodPost, "http://example.com/batch-get", bytes.NewBufferString(`{"ids": ["3", "1"]}`)),
+	} {
+		mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").
+			WithArgs(`{"3","1"}`).
+			WillReturnRows(sqlmock.NewRows(articleColumns).
+				AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "", 1, nil, 0, 0))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("unexpected status: %v", w.Code)
+		}
+
+		var items []struct {
+			ID      string   `json:"id"`
+			Found   bool     `json:"found"`
+			Article *Article `json:"article"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&items); err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 2 || items[0].ID != "3" || items[0].Found || items[0].Article != nil ||
+			items[1].ID != "1" || !items[1].Found || items[1].Article.Title != "Новая" {
+			t.Errorf("unexpected items: %+v", items)
+		}
+	}
+
+	tooMany := "1"
+	for i := 2; i <= maxBatchIDs+1; i++ {
+		tooMany += "," + strconv.Itoa(i)
+	}
+	for _, ids := range []string{"1,x", "1,-2", tooMany} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/?ids="+ids, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status: %v", ids, w.Code)
+		}
+	}
+
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expections: %s", err)
 	}
 }
 
+type purger struct{ keys []string }
+
+func (p *purger) Purge(ctx context.Context, keys ...string) error {
+	p.keys = append(p.keys, keys...)
+	return nil
+}
+
 func TestDeleteHandler(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -58,23 +239,29 @@ func TestDeleteHandler(t *testing.T) {
 	m := &Manager{db: db}
 
 	// delete first time
-	mock.ExpectQuery("SELECT id, title, slug FROM article WHERE id = ANY(.+);").
+	mock.ExpectQuery("SELECT id, title, slug, status, tags, views, created_at, body, revision, author_id, likes, bookmarks FROM article WHERE id = ANY(.+);").
 		WithArgs(`{"1"}`).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "slug"}).
-			AddRow(1, "Новая", "new"))
+		WillReturnRows(sqlmock.NewRows(articleColumns).
+			AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "", 1, nil, 0, 0))
+	mock.ExpectBegin()
 	mock.ExpectExec("DELETE FROM article WHERE id = \\$1;").WithArgs("1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
 
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodDelete, "http://example.com/1", nil)
 
+	p := &purger{}
 	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
-	r.Delete("/:articleID", makeHandler(m, deleteHandler))
+	r.Delete("/{articleID}", makeHandler(m, deleteHandler(p, nil)))
 	r.ServeHTTP(w, req)
 
 	resp := w.Result()
 	if resp.StatusCode != http.StatusNoContent {
 		t.Errorf("unexpected status: %v", resp.StatusCode)
 	}
+	if !reflect.DeepEqual(p.keys, []string{ListSurrogateKey, "article:1"}) {
+		t.Errorf("unexpected purged keys: %v", p.keys)
+	}
 
 	body, _ := ioutil.ReadAll(resp.Body)
 	if string(body) != "" {
@@ -82,7 +269,7 @@ func TestDeleteHandler(t *testing.T) {
 	}
 
 	// check that article was deleted and not found now
-	mock.ExpectQuery("SELECT id, title, slug  FROM article WHERE id = ANY(.+);").
+	mock.ExpectQuery("SELECT id, title, slug, status, tags, views, created_at, body, revision, author_id, likes, bookmarks FROM article WHERE id = ANY(.+);").
 		WithArgs(`{"1"}`).
 		WillReturnRows(sqlmock.NewRows([]string{}))
 
@@ -118,19 +305,30 @@ func TestPutHandler_Update(t *testing.T) {
 	}
 	defer db.Close()
 
-	mock.ExpectQuery("SELECT id, title, slug FROM article WHERE id = ANY(.+);").
-		WithArgs(`{"1"}`).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "slug"}).
-			AddRow(1, "Новая", "new"))
+	mock.ExpectQuery("SELECT (.+), hidden FROM article WHERE id = \\$1;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(putColumns).
+			AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "", 1, nil, 0, 0, false))
 
-	mock.ExpectExec("UPDATE article SET title = \\$2, slug = \\$3 WHERE id = \\$1;").
-		WithArgs("1", "Не новая", "not-new").
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT slug FROM article").
+		WithArgs("not-new", "not-new-%", "1").
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}))
+	mock.ExpectQuery("UPDATE article SET title = \\$2, slug = \\$3, status = \\$4, tags = \\$5, body = \\$6, author_id = \\$7, revision = revision \\+ 1 WHERE id = \\$1 AND NOT hidden RETURNING revision;").
+		WithArgs("1", "Не новая", "not-new", StatusPublished, `{"news"}`, "", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"revision"}).AddRow(2))
+	mock.ExpectExec("INSERT INTO article_slug_history").
+		WithArgs("new", "1").
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM article_slug_history").
+		WithArgs("not-new").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
 
 	m := &Manager{db: db}
 
 	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
-	r.Put("/:articleID", makeHandler(m, putHandler))
+	r.Put("/{articleID}", makeHandler(m, putHandler(Duplicates{}, surrogate.Purgers(nil), nil, nil)))
 	r.ServeHTTP(w, req)
 
 	resp := w.Result()
@@ -147,6 +345,58 @@ func TestPutHandler_Update(t *testing.T) {
 	}
 }
 
+func TestPutHandler_Hidden(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "http://example.com/1", bytes.NewBufferString(`{"title": "Новая", "slug": "new"}`))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT (.+), hidden FROM article WHERE id = \\$1;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(putColumns).
+			AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "", 1, nil, 0, 0, false))
+	// article is hidden by moderation after it is read
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT slug FROM article").
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}))
+	mock.ExpectQuery("UPDATE article SET (.+) WHERE id = \\$1 AND NOT hidden RETURNING revision;").
+		WillReturnRows(sqlmock.NewRows([]string{"revision"}))
+	mock.ExpectRollback()
+
+	m := &Manager{db: db}
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Put("/{articleID}", makeHandler(m, putHandler(Duplicates{}, surrogate.Purgers(nil), nil, nil)))
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("unexpected status: %v %s", w.Code, w.Body)
+	}
+
+	// article hidden before it is read is not replaced with a new one
+	mock.ExpectQuery("SELECT (.+), hidden FROM article WHERE id = \\$1;").
+		WithArgs("2").
+		WillReturnRows(sqlmock.NewRows(putColumns).
+			AddRow(2, "Скрытая", "hidden", "published", "{news}", 5, time.Now(), "", 1, nil, 0, 0, true))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/2", bytes.NewBufferString(`{"title": "Новая", "slug": "new"}`)))
+	var resp handler.ErrResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusConflict || resp.ErrorText != ErrHidden.Error() {
+		t.Errorf("unexpected response of hidden article: %v %+v", w.Code, resp)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
 func TestPutHandler_Create(t *testing.T) {
 	toCreate := `{
 		"title": "Новая",
@@ -156,6 +406,8 @@ func TestPutHandler_Create(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodPut, "http://example.com/1", buf)
+	// user who creates article owns it
+	req = req.WithContext(reqctx.WithUser(req.Context(), &reqctx.User{ID: "42"}))
 
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -163,24 +415,38 @@ func TestPutHandler_Create(t *testing.T) {
 	}
 	defer db.Close()
 
-	mock.ExpectQuery("SELECT id, title, slug FROM article WHERE id = ANY(.+);").
-		WithArgs(`{"1"}`).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "slug"}))
+	mock.ExpectQuery("SELECT (.+), hidden FROM article WHERE id = \\$1;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(putColumns))
 
-	mock.ExpectExec("INSERT INTO article").
-		WithArgs("Новая", "new").
-		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT slug FROM article").
+		WithArgs("new", "new-%", "").
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}).AddRow("new").AddRow("new-3"))
+	mock.ExpectQuery("INSERT INTO article").
+		WithArgs("Новая", "new-2", StatusDraft, "{}", "", nil, "42").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "revision"}).AddRow("1", time.Now(), 1))
+	mock.ExpectCommit()
 
 	m := &Manager{db: db}
 
+	// drafts are created, but not published
+	var actions []string
+	ev := events{"test": func(e Event) error {
+		actions = append(actions, e.Action+" "+e.Article.ID)
+		return nil
+	}}
 	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
-	r.Put("/:articleID", makeHandler(m, putHandler))
+	r.Put("/{articleID}", makeHandler(m, putHandler(Duplicates{}, surrogate.Purgers(nil), nil, ev)))
 	r.ServeHTTP(w, req)
 
 	resp := w.Result()
 	if resp.StatusCode != http.StatusCreated {
 		t.Errorf("unexpected status: %v", resp.StatusCode)
 	}
+	if !reflect.DeepEqual(actions, []string{"created 1"}) {
+		t.Errorf("unexpected events: %v", actions)
+	}
 
 	body, _ := ioutil.ReadAll(resp.Body)
 	if string(body) == "" {
@@ -191,3 +457,1336 @@ func TestPutHandler_Create(t *testing.T) {
 		t.Errorf("there were unfulfilled expections: %s", err)
 	}
 }
+
+func TestPutHandler_CreateSlugTaken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// slug is taken by article created concurrently, the next transaction picks another one
+	mock.ExpectQuery("SELECT (.+), hidden FROM article WHERE id = \\$1;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(putColumns))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT slug FROM article").
+		WithArgs("new", "new-%", "").
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}))
+	mock.ExpectQuery("INSERT INTO article").
+		WithArgs("Новая", "new", StatusDraft, "{}", "", nil, "").
+		WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT slug FROM article").
+		WithArgs("new", "new-%", "").
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}).AddRow("new"))
+	mock.ExpectQuery("INSERT INTO article").
+		WithArgs("Новая", "new-2", StatusDraft, "{}", "", nil, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "revision"}).AddRow("1", time.Now(), 1))
+	mock.ExpectCommit()
+
+	// slug is taken every time
+	mock.ExpectQuery("SELECT (.+), hidden FROM article WHERE id = \\$1;").
+		WithArgs("2").
+		WillReturnRows(sqlmock.NewRows(putColumns))
+	for i := 0; i < slugAttempts; i++ {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT slug FROM article").
+			WillReturnRows(sqlmock.NewRows([]string{"slug"}))
+		mock.ExpectQuery("INSERT INTO article").
+			WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+	}
+
+	m := &Manager{db: db}
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Put("/{articleID}", makeHandler(m, putHandler(Duplicates{}, surrogate.Purgers(nil), nil, nil)))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/1", bytes.NewBufferString(`{"title": "Новая", "slug": "new"}`)))
+	var a Article
+	if err := json.NewDecoder(w.Body).Decode(&a); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || a.Slug != "new-2" {
+		t.Errorf("unexpected response: %v %+v", w.Code, a)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/2", bytes.NewBufferString(`{"title": "Новая", "slug": "new"}`)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("unexpected status: %v %s", w.Code, w.Body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestPutHandler_Sanitize(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db, html: sanitize.DefaultPolicy}
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Put("/{articleID}", makeHandler(m, putHandler(Duplicates{}, surrogate.Purgers(nil), nil, nil)))
+
+	mock.ExpectQuery("SELECT (.+), hidden FROM article WHERE id = \\$1;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(putColumns))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT slug FROM article").
+		WithArgs("new", "new-%", "").
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}))
+	mock.ExpectQuery("INSERT INTO article").
+		WithArgs("Новая", "new", StatusDraft, `{"news"}`, `<p>hi <a>there</a></p>`, nil, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "revision"}).AddRow("1", time.Now(), 1))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/1", bytes.NewBufferString(`{
+		"title": "<b>Новая</b><script>alert(1)</script>",
+		"slug": "new",
+		"tags": ["<i>news</i>"],
+		"body": "<p onclick=alert(1)>hi <a href=javascript:alert(1)>there</a></p><img src=x onerror=alert(1)"
+	}`)))
+	if w.Code != http.StatusCreated {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+
+	// title is empty once sanitized
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/1", bytes.NewBufferString(`{"title": "<script>alert(1)</script>"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+// Bodies are Markdown, sanitizing them on write keeps Markdown and strips HTML in it like in HTML bodies.
+func TestPutHandler_MarkdownBody(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db, html: sanitize.DefaultPolicy}
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Put("/{articleID}", makeHandler(m, putHandler(Duplicates{}, surrogate.Purgers(nil), nil, nil)))
+
+	body := "Use **bold** when a < b & c > d:\n\n```\n<div onclick=x>\n  if a < b {}\n</div>\n```\n\n> [link](http://example.com/?a=1&b=2)"
+	// Markdown is kept, HTML in it is sanitized like everywhere else in body
+	stored := "Use **bold** when a < b & c > d:\n\n```\n\n  if a < b {}\n\n```\n\n> [link](http://example.com/?a=1&b=2)"
+	mock.ExpectQuery("SELECT (.+), hidden FROM article WHERE id = \\$1;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(putColumns))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT slug FROM article").
+		WithArgs("new", "new-%", "").
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}))
+	mock.ExpectQuery("INSERT INTO article").
+		WithArgs("New", "new", StatusDraft, "{}", stored, nil, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "revision"}).AddRow("1", time.Now(), 1))
+	mock.ExpectCommit()
+
+	data, _ := json.Marshal(map[string]string{"title": "New", "slug": "new", "body": body})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/1", bytes.NewBuffer(data)))
+	if w.Code != http.StatusCreated {
+		t.Errorf("unexpected status: %v %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+
+	html := newBodyCache(markdown.DefaultPolicy, sanitize.DefaultPolicy, 10).html(&Article{ID: "1", Body: stored})
+	want := "<p>Use <strong>bold</strong> when a &lt; b &amp; c &gt; d:</p>\n" +
+		"<pre><code>\n  if a &lt; b {}\n</code></pre>\n" +
+		"<blockquote>\n<p><a href=\"http://example.com/?a=1&amp;b=2\" rel=\"nofollow\">link</a></p>\n</blockquote>\n"
+	if html != want {
+		t.Errorf("unexpected body html:\n%q\nexpected\n%q", html, want)
+	}
+}
+
+func TestPutHandler_Duplicate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db}
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Put("/articles/{articleID}", makeHandler(m, putHandler(Duplicates{Check: true, Threshold: 0.8}, surrogate.Purgers(nil), nil, nil)))
+
+	mock.ExpectQuery("SELECT (.+), hidden FROM article WHERE id = \\$1;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(putColumns))
+	mock.ExpectQuery("SELECT (.+) AS same_content FROM article").
+		WithArgs(contentHash(&Article{Title: "Новая статья!"}), "Новая | статья", maxDuplicateCandidates).
+		WillReturnRows(sqlmock.NewRows(append(articleColumns, "same_content")).
+			AddRow(7, "новая  статья", "new", "published", "{}", 5, time.Now(), "", 1, nil, 0, 0, false))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/articles/1", bytes.NewBufferString(`{"title": "Новая статья!"}`)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+	if link := w.Header().Get("Link"); link != `</articles/7>; rel="duplicate"` {
+		t.Errorf("unexpected link: %v", link)
+	}
+
+	// detection is disabled by client
+	mock.ExpectQuery("SELECT (.+), hidden FROM article WHERE id = \\$1;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(putColumns))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT slug FROM article").
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}))
+	mock.ExpectQuery("INSERT INTO article").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "revision"}).AddRow("1", time.Now(), 1))
+	mock.ExpectCommit()
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/articles/1?check_duplicates=false", bytes.NewBufferString(`{"title": "Новая статья!"}`)))
+	if w.Code != http.StatusCreated {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		min  float64
+		max  float64
+	}{
+		{"Go 1.10 released", "go 1.10  Released!", 1, 1},
+		{"Go 1.10 released", "Go 1.11 released", 0.5, 0.9},
+		{"Go 1.10 released", "Погода на завтра", 0, 0},
+		{"", "anything", 0, 0},
+	}
+	for _, tt := range tests {
+		if s := similarity(tt.a, tt.b); s < tt.min || s > tt.max {
+			t.Errorf("%q, %q: unexpected similarity %v", tt.a, tt.b, s)
+		}
+	}
+}
+
+func TestStatusesHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/statuses", nil)
+	req = req.WithContext(reqctx.WithLocale(req.Context(), "ru"))
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/statuses", statusesHandler)
+	r.ServeHTTP(w, req)
+
+	var labels []i18n.Label
+	if err := json.NewDecoder(w.Body).Decode(&labels); err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != len(Statuses) || labels[0] != (i18n.Label{Value: "draft", Label: "Черновик"}) {
+		t.Errorf("unexpected labels: %v", labels)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT status, count(.+) FROM article GROUP BY status").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).
+			AddRow("draft", 2).
+			AddRow("published", 3))
+	mock.ExpectQuery("SELECT to_char(.+) FROM article WHERE created_at >= \\$2").
+		WithArgs("Europe/Moscow", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "count"}).
+			AddRow("2018-01-02", 5))
+	mock.ExpectQuery("SELECT tag, count(.+) FROM article, unnest(.+) LIMIT \\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"tag", "count"}).
+			AddRow("go", 4).
+			AddRow("news", 1))
+
+	m := &Manager{db: db}
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/stats", makeHandler(m, statsHandler(newStatsCache(time.Minute))))
+
+	// second request is served from cache
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/stats?tz=Europe/Moscow&tags=2", nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %v", w.Code)
+		}
+		var stats Stats
+		if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+			t.Fatal(err)
+		}
+		if stats.Total != 5 || len(stats.ByDay) != 1 || len(stats.TopTags) != 2 || stats.TopTags[0].Tag != "go" {
+			t.Errorf("unexpected stats: %+v", stats)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/stats?days=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+
+	// time zone of server is not a time zone of client
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/stats?tz=Local", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestGetHandler_Views(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db}
+	views := NewViewCounter(db, time.Hour)
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/{articleID}", makeHandler(m, getHandler(&viewer{views: views, bodies: newBodyCache(markdown.DefaultPolicy, sanitize.DefaultPolicy, 10)})))
+
+	for i := 1; i <= 2; i++ {
+		mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").
+			WithArgs(`{"1"}`).
+			WillReturnRows(sqlmock.NewRows(articleColumns).
+				AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "", 1, nil, 0, 0))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/1", nil))
+
+		var a Article
+		if err := json.NewDecoder(w.Body).Decode(&a); err != nil {
+			t.Fatal(err)
+		}
+		if a.Views != int64(5+i) {
+			t.Errorf("unexpected views: %v", a.Views)
+		}
+	}
+
+	// both views are written with a single statement
+	mock.ExpectExec("UPDATE article SET views = views \\+ v.n").
+		WithArgs(`{"1"}`, "{2}").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := views.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := views.Pending("1"); n != 0 {
+		t.Errorf("unexpected pending views: %v", n)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestViewCounter_Run(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	clk := clock.NewFake(time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC))
+	views := NewViewCounter(db, time.Minute)
+	views.clock = clk
+	go views.Run(log.New("", "", ioutil.Discard))
+	for clk.Tickers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	views.Inc("1")
+	mock.ExpectExec("UPDATE article SET views = views \\+ v.n").
+		WithArgs(`{"1"}`, "{1}").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	clk.Add(time.Minute)
+	for deadline := time.Now().Add(time.Second); mock.ExpectationsWereMet() != nil; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("views are not flushed on tick")
+		}
+	}
+
+	if err := views.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetHandler_RenderHTML(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db}
+	bodies := newBodyCache(markdown.DefaultPolicy, sanitize.DefaultPolicy, 10)
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/{articleID}", makeHandler(m, getHandler(&viewer{views: NewViewCounter(db, time.Hour), bodies: bodies})))
+
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").
+		WithArgs(`{"1"}`).
+		WillReturnRows(sqlmock.NewRows(articleColumns).
+			AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "**bold** <script>", 3, nil, 0, 0))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/1?render=html", nil))
+
+	var resp struct {
+		Body     string `json:"body"`
+		BodyHTML string `json:"body_html"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Body != "**bold** <script>" {
+		t.Errorf("unexpected body: %v", resp.Body)
+	}
+	if resp.BodyHTML != "<p><strong>bold</strong> &lt;script&gt;</p>\n" {
+		t.Errorf("unexpected body html: %q", resp.BodyHTML)
+	}
+	if _, ok := bodies.cache.Get(bodyKey{id: "1", revision: 3}); !ok {
+		t.Error("rendered body is not cached")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestGetHandler_Expand(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db}
+	v := &viewer{
+		views:  NewViewCounter(db, time.Hour),
+		bodies: newBodyCache(markdown.DefaultPolicy, sanitize.DefaultPolicy, 10),
+		relations: map[string]Relation{
+			"related": func(a *Article, limit int) (interface{}, error) {
+				if limit != 2 {
+					t.Errorf("unexpected limit: %v", limit)
+				}
+				return []*Article{{ID: "2", Status: StatusDraft}}, nil
+			},
+			"notes": func(a *Article, limit int) (interface{}, error) {
+				return []string{"note of " + a.ID}, nil
+			},
+		},
+	}
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/{articleID}", makeHandler(m, getHandler(v)))
+
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").
+		WithArgs(`{"1"}`).
+		WillReturnRows(sqlmock.NewRows(articleColumns).
+			AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "", 1, nil, 0, 0))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/1?expand=related:2.notes,notes", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+
+	var resp struct {
+		Expanded struct {
+			Notes   []string `json:"notes"`
+			Related []struct {
+				ID          string `json:"id"`
+				StatusLabel string `json:"status_label"`
+				Expanded    struct {
+					Notes []string `json:"notes"`
+				} `json:"expanded"`
+			} `json:"related"`
+		} `json:"expanded"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Expanded.Notes) != 1 || resp.Expanded.Notes[0] != "note of 1" {
+		t.Errorf("unexpected notes: %v", resp.Expanded.Notes)
+	}
+	if len(resp.Expanded.Related) != 1 || resp.Expanded.Related[0].StatusLabel != "Draft" ||
+		len(resp.Expanded.Related[0].Expanded.Notes) != 1 || resp.Expanded.Related[0].Expanded.Notes[0] != "note of 2" {
+		t.Errorf("unexpected related: %+v", resp.Expanded.Related)
+	}
+
+	for _, e := range []string{"comments", "related.related.notes", "related:0"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/1?expand="+e, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status: %v", e, w.Code)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestPreviewUpdateHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").
+		WithArgs(`{"1"}`).
+		WillReturnRows(sqlmock.NewRows(articleColumns).
+			AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "", 1, nil, 0, 0))
+
+	m := &Manager{db: db}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/1/preview-update", bytes.NewBufferString(`{"title": "Не новая", "slug": "new"}`))
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Post("/{articleID}/preview-update", makeHandler(m, previewUpdateHandler))
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %v", w.Code)
+	}
+	var preview struct {
+		Article Article       `json:"article"`
+		Changes []FieldChange `json:"changes"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&preview); err != nil {
+		t.Fatal(err)
+	}
+	if preview.Article.Title != "Не новая" || preview.Article.Status != StatusPublished {
+		t.Errorf("unexpected article: %+v", preview.Article)
+	}
+	if len(preview.Changes) != 1 || preview.Changes[0] != (FieldChange{Field: "title", From: "Новая", To: "Не новая"}) {
+		t.Errorf("unexpected changes: %+v", preview.Changes)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestRelatedHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").
+		WithArgs(`{"1"}`).
+		WillReturnRows(sqlmock.NewRows(articleColumns).
+			AddRow(1, "Новая", "new", "published", "{news,go}", 5, time.Now(), "", 1, nil, 0, 0))
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE tags && (.+) LIMIT \\$4;").
+		WithArgs("1", `{"news","go"}`, 2, 5).
+		WillReturnRows(sqlmock.NewRows(append(articleColumns, "score")).
+			AddRow(2, "Другая", "other", "published", "{go}", 1, time.Now(), "", 1, nil, 0, 0, 0.5))
+
+	m := &Manager{db: db}
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/{articleID}/related", makeHandler(m, relatedHandler(NewTagScorer(db))))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/1/related", nil))
+
+	var related []Related
+	if err := json.NewDecoder(w.Body).Decode(&related); err != nil {
+		t.Fatal(err)
+	}
+	if len(related) != 1 || related[0].ID != "2" || related[0].Score != 0.5 {
+		t.Errorf("unexpected related: %+v", related)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestPreviewHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	body := "Text with <b>\"quotes\"</b>\n\n![cover](/images/cover.png)"
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").
+		WithArgs(`{"1"}`).
+		WillReturnRows(sqlmock.NewRows(articleColumns).
+			AddRow(1, "Новая & важная", "new", "published", "{news}", 5, time.Now(), body, 1, nil, 0, 0))
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").
+		WithArgs(`{"2"}`).
+		WillReturnRows(sqlmock.NewRows(articleColumns).
+			AddRow(2, "Черновик", "draft", "draft", "{}", 0, time.Now(), "", 1, nil, 0, 0))
+
+	m := &Manager{db: db}
+	c := PreviewConfig{SiteName: "Blog", ArticleURL: "https://blog.example.com/{slug}", BaseURL: "https://example.com"}
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/{articleID}/preview", makeHandler(m, previewHandler(c, time.Minute)))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/1/preview", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %v", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+	if key := w.Header().Get(surrogate.KeyHeader); key != SurrogateKey("1") {
+		t.Errorf("unexpected surrogate key: %s", key)
+	}
+	for _, tag := range []string{
+		`<meta property="og:title" content="Новая &amp; важная">`,
+		`<meta property="og:description" content="Text with &#34;quotes&#34; cover">`,
+		`<meta property="og:url" content="https://blog.example.com/new">`,
+		`<meta property="og:image" content="https://example.com/images/cover.png">`,
+		`<meta name="twitter:card" content="summary_large_image">`,
+	} {
+		if !strings.Contains(w.Body.String(), tag) {
+			t.Errorf("preview has no %s:\n%s", tag, w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/2/preview", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status of draft: %v", w.Code)
+	}
+
+	// API URL of article is built from base URL, not from Host of request
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").
+		WithArgs(`{"1"}`).
+		WillReturnRows(sqlmock.NewRows(articleColumns).
+			AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "", 1, nil, 0, 0))
+	c.ArticleURL = ""
+	api := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	api.Get("/{articleID}/preview", makeHandler(m, previewHandler(c, time.Minute)))
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://evil.example.org/1/preview", nil))
+	if !strings.Contains(w.Body.String(), `<link rel="canonical" href="https://example.com/1">`) {
+		t.Errorf("unexpected canonical URL:\n%s", w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	long := strings.Repeat("word ", 50)
+	for _, tc := range []struct{ body, want string }{
+		{"# Title\n\nSome *text*.", "Title Some text."},
+		{long, strings.TrimSpace(long[:maxDescription]) + "…"},
+	} {
+		if got := describe(tc.body); got != tc.want {
+			t.Errorf("describe(%q) = %q, want %q", tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestLinksRelation(t *testing.T) {
+	body := "See [docs](https://example.com/docs), https://example.com/docs and http://127.0.0.1/admin."
+	if urls := externalURLs(body); !reflect.DeepEqual(urls, []string{"https://example.com/docs", "http://127.0.0.1/admin"}) {
+		t.Errorf("unexpected urls: %v", urls)
+	}
+
+	// private address is never fetched, link has only url
+	rel := linksRelation(unfurl.New(unfurl.Config{Timeout: time.Second, MaxBytes: 1024}))
+	links, err := rel(&Article{Body: "http://127.0.0.1/admin http://10.0.0.1/"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(links, []*unfurl.Meta{{URL: "http://127.0.0.1/admin"}}) {
+		t.Errorf("unexpected links: %+v", links)
+	}
+}
+
+func TestFeedHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	createdAt := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT (.+) FROM article WHERE status = \\$1 (.+) LIMIT \\$2;").
+			WithArgs(StatusPublished, 20).
+			WillReturnRows(sqlmock.NewRows(articleColumns).
+				AddRow(1, "Новая", "new", "published", "{news}", 5, createdAt, "", 1, nil, 0, 0))
+	}
+
+	m := &Manager{db: db}
+	c := FeedConfig{Title: "Articles", ArticleURL: "https://example.com/{slug}", Size: 20, MaxAge: time.Minute}
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/feed.atom", makeHandler(m, feedHandler(c, 0, "application/atom+xml", renderAtom)))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/feed.atom", nil))
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %v", resp.StatusCode)
+	}
+	if lm := resp.Header.Get("Last-Modified"); lm != createdAt.Format(http.TimeFormat) {
+		t.Errorf("unexpected last modified: %v", lm)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`<link href="https://example.com/new"></link>`)) {
+		t.Errorf("unexpected body: %v", w.Body.String())
+	}
+
+	// not modified since the latest article
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/feed.atom", nil)
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"Новая статья", "novaya-statya"},
+		{"  --Go 1.10--  ", "go-1-10"},
+		{"!!!", ""},
+	}
+	for _, tt := range tests {
+		if out := Slugify(tt.in); out != tt.out {
+			t.Errorf("%q: unexpected slug: %q", tt.in, out)
+		}
+	}
+}
+
+func TestSlugHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db}
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/slug/{slug}", makeHandler(m, slugHandler(&viewer{views: NewViewCounter(db, time.Hour), bodies: newBodyCache(markdown.DefaultPolicy, sanitize.DefaultPolicy, 10)})))
+
+	// current slug
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE slug = \\$1 AND NOT hidden;").
+		WithArgs("new").
+		WillReturnRows(sqlmock.NewRows(articleColumns).
+			AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "", 1, nil, 0, 0))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/slug/new", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+
+	// old slug
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE slug = \\$1 AND NOT hidden;").
+		WithArgs("old").
+		WillReturnRows(sqlmock.NewRows(articleColumns))
+	mock.ExpectQuery("SELECT a.slug FROM article_slug_history").
+		WithArgs("old").
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}).AddRow("new"))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/slug/old?lang=ru", nil))
+	if w.Code != http.StatusMovedPermanently {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "http://example.com/slug/new?lang=ru" {
+		t.Errorf("unexpected location: %v", loc)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestManager_CoalescesReads(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := NewManager(db, sanitize.DefaultPolicy)
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").
+		WithArgs(`{"1"}`).
+		WillDelayFor(50 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows(articleColumns).
+			AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "body", 3, nil, 0, 0))
+
+	articles := make(chan *Article, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			a, err := m.ByID("1")
+			if err != nil {
+				t.Error(err)
+			}
+			articles <- a
+		}()
+	}
+	a, b := <-articles, <-articles
+	if a == nil || b == nil || a == b || a.Title != b.Title {
+		t.Errorf("callers do not get their own copies: %+v %+v", a, b)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestManager_CoalescedReadCancelled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := NewManager(db, sanitize.DefaultPolicy)
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").
+		WithArgs(`{"1"}`).
+		WillDelayFor(100 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows(articleColumns))
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").
+		WithArgs(`{"1"}`).
+		WillReturnRows(sqlmock.NewRows(articleColumns).
+			AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "body", 3, nil, 0, 0))
+
+	// client of the first caller goes away, the caller waiting for its query is not cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	cancelled := make(chan error, 1)
+	go func() {
+		_, err := m.WithContext(ctx).ByID("1")
+		cancelled <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	a, err := m.WithContext(context.Background()).ByID("1")
+	if err != nil || a.Title != "Новая" {
+		t.Errorf("unexpected article %+v, %v", a, err)
+	}
+	if err := <-cancelled; err == nil {
+		t.Error("cancelled read succeeded")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestChangesHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db}
+	feed := NewChangeFeed(m, time.Second)
+	changeColumns := []string{"id", "article_id", "action", "created_at"}
+	created := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/changes", makeHandler(m, changesHandler(feed, time.Minute)))
+
+	// pending changes are returned at once
+	mock.ExpectQuery("SELECT id, article_id, action, created_at FROM article_change WHERE id > \\$1 (.+)").
+		WithArgs(5, 100).
+		WillReturnRows(sqlmock.NewRows(changeColumns).AddRow(6, 1, ActionUpdated, created).AddRow(8, 2, ActionDeleted, created))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/changes?since=5&wait=30s", nil))
+	var resp struct {
+		Changes []*Change `json:"changes"`
+		Cursor  string    `json:"cursor"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(resp.Changes) != 2 || resp.Cursor != "8" || resp.Changes[1].ArticleID != "2" || resp.Changes[1].Action != ActionDeleted {
+		t.Errorf("unexpected response: %v %+v", w.Code, resp)
+	}
+
+	// request waits until feed finds new changes
+	mock.ExpectQuery("SELECT coalesce(.+) FROM article_change").
+		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(8))
+	mock.ExpectQuery("SELECT id, article_id, action, created_at FROM article_change WHERE id > \\$1 (.+)").
+		WithArgs(8, 100).
+		WillReturnRows(sqlmock.NewRows(changeColumns))
+	mock.ExpectQuery("SELECT coalesce(.+) FROM article_change").
+		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(9))
+	mock.ExpectQuery("SELECT id, article_id, action, created_at FROM article_change WHERE id > \\$1 (.+)").
+		WithArgs(8, 100).
+		WillReturnRows(sqlmock.NewRows(changeColumns).AddRow(9, 3, ActionCreated, created))
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/changes?wait=30s", nil))
+		done <- w
+	}()
+	// let request find no changes and wait
+	time.Sleep(20 * time.Millisecond)
+	if err := feed.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case w := <-done:
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"cursor":"9"`) {
+			t.Errorf("unexpected response: %v %s", w.Code, w.Body.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting request is not woken up")
+	}
+
+	// nothing changes until wait passes
+	mock.ExpectQuery("SELECT id, article_id, action, created_at FROM article_change WHERE id > \\$1 (.+)").
+		WithArgs(9, 100).
+		WillReturnRows(sqlmock.NewRows(changeColumns))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/changes?since=9&wait=10ms", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `{"changes":[],"cursor":"9"}`) {
+		t.Errorf("unexpected response: %v %s", w.Code, w.Body.String())
+	}
+
+	for _, q := range []string{"since=-1", "since=abc", "wait=soon", "wait=-1s"} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/changes?"+q, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status: %v", q, w.Code)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestPutTranslationHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db, html: sanitize.DefaultPolicy}
+	p := &purger{}
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Put("/{articleID}/translations/{lang}", makeHandler(m, putTranslationHandler("en", p)))
+	r.Delete("/{articleID}/translations/{lang}", makeHandler(m, deleteTranslationHandler(p)))
+
+	// body is Markdown, it is kept and HTML in it is sanitized like in article bodies
+	body := "texto **<b onclick=x>b</b>** se a < b\n\n<script>alert(1)</script>"
+	mock.ExpectQuery("SELECT id FROM article WHERE id = ANY(.+);").
+		WithArgs(`{"1"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO article_translation(.+) ON CONFLICT").
+		WithArgs("1", "pt-br", "Nova", "texto **<b>b</b>** se a < b\n\n").
+		WillReturnRows(sqlmock.NewRows([]string{"revision", "updated_at", "created"}).AddRow(1, time.Now(), true))
+	mock.ExpectCommit()
+
+	data, _ := json.Marshal(map[string]string{"title": "Nova", "body": body})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/1/translations/pt-BR", bytes.NewBuffer(data)))
+	var tr Translation
+	if err := json.NewDecoder(w.Body).Decode(&tr); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || tr.Language != "pt-br" || tr.Revision != 1 {
+		t.Errorf("unexpected response: %v %+v", w.Code, tr)
+	}
+	if !reflect.DeepEqual(p.keys, []string{"article:1"}) {
+		t.Errorf("unexpected purged keys: %v", p.keys)
+	}
+
+	// original language and invalid tags have no translations
+	for _, lang := range []string{"en", "pt_BR", "english"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/1/translations/"+lang, strings.NewReader(`{"title": "Nova"}`)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status: %v", lang, w.Code)
+		}
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM article_translation WHERE article_id = \\$1 AND language = ANY\\(\\$2\\)").
+		WithArgs("1", `{"de"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"article_id", "language", "title", "body", "revision", "updated_at"}))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "http://example.com/1/translations/de", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status of missing translation: %v", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestGetHandler_Localize(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db}
+	bodies := newBodyCache(markdown.DefaultPolicy, sanitize.DefaultPolicy, 10)
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/{articleID}", makeHandler(m, getHandler(&viewer{views: NewViewCounter(db, time.Hour), bodies: bodies, language: "en"})))
+
+	tests := []struct {
+		acceptLanguage string
+		// wanted are languages of translations which are looked up
+		wanted   []string
+		title    string
+		language string
+	}{
+		{"", nil, "New", "en"},
+		{"en-US, ru", []string{"en-us"}, "New", "en"},
+		{"uk, ru-RU;q=0.9, en;q=0.5", []string{"uk", "ru-ru", "ru"}, "Новая", "ru"},
+		{"de", []string{"de"}, "New", "en"},
+	}
+	for _, tt := range tests {
+		mock.ExpectQuery("SELECT (.+) FROM article WHERE id = ANY(.+);").
+			WithArgs(`{"1"}`).
+			WillReturnRows(sqlmock.NewRows(articleColumns).
+				AddRow(1, "New", "new", "published", "{news}", 5, time.Now(), "*new*", 1, nil, 0, 0))
+		if tt.wanted != nil {
+			rows := sqlmock.NewRows([]string{"article_id", "language", "title", "body", "revision", "updated_at"})
+			if tt.language != "en" {
+				rows.AddRow(1, tt.language, tt.title, "*новая*", 2, time.Now())
+			}
+			mock.ExpectQuery("SELECT (.+) FROM article_translation WHERE article_id = \\$1 AND language = ANY\\(\\$2\\)").
+				WithArgs("1", `{"`+strings.Join(tt.wanted, `","`)+`"}`).
+				WillReturnRows(rows)
+		}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/1?render=html", nil)
+		req.Header.Set("Accept-Language", tt.acceptLanguage)
+		r.ServeHTTP(w, req)
+
+		var resp struct {
+			Title    string `json:"title"`
+			BodyHTML string `json:"body_html"`
+			Language string `json:"language"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		// language is present in translated articles only
+		translated := resp.Language == tt.language || resp.Language == "" && tt.language == "en"
+		if resp.Title != tt.title || !translated || w.Header().Get("Content-Language") != tt.language {
+			t.Errorf("%q: unexpected response: %v %+v", tt.acceptLanguage, w.Header(), resp)
+		}
+	}
+	if _, ok := bodies.cache.Get(bodyKey{id: "1", revision: 1, language: "ru", translation: 2}); !ok {
+		t.Error("rendered translation is not cached")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestListHandler_Author(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db}
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/", makeHandler(m, listHandler(0)))
+	r.Put("/{articleID}", makeHandler(m, putHandler(Duplicates{}, surrogate.Purgers(nil), nil, nil)))
+
+	mock.ExpectQuery("SELECT GREATEST(.+) FROM article_deletion(.+)").
+		WillReturnRows(sqlmock.NewRows([]string{"greatest"}).AddRow(time.Now()))
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE author_id = \\$1 AND NOT hidden ORDER BY created_at DESC, id DESC;").
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows(articleColumns).
+			AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "", 1, 3, 0, 0))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/?author_id=3", nil))
+	var articles []Article
+	if err := json.NewDecoder(w.Body).Decode(&articles); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(articles) != 1 || articles[0].AuthorID == nil || *articles[0].AuthorID != "3" {
+		t.Errorf("unexpected response: %v %+v", w.Code, articles)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/?author_id=ann", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status of invalid author: %v", w.Code)
+	}
+
+	// unknown author is rejected by foreign key
+	mock.ExpectQuery("SELECT (.+), hidden FROM article WHERE id = \\$1;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(putColumns).
+			AddRow(1, "Новая", "new", "published", "{news}", 5, time.Now(), "", 1, nil, 0, 0, false))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT slug FROM article").
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}))
+	mock.ExpectQuery("UPDATE article SET (.+) author_id = \\$7").
+		WithArgs("1", "Новая", "new", StatusPublished, `{"news"}`, "", "9").
+		WillReturnError(&pq.Error{Code: "23503"})
+	mock.ExpectRollback()
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/1", strings.NewReader(`{"title": "Новая", "author_id": "9"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status of unknown author: %v %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestReactHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db}
+	newRouter := func(u *reqctx.User) http.Handler {
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(u))
+		r.Put("/{articleID}/like", makeHandler(m, reactHandler(ReactionLike, true)))
+		r.Delete("/{articleID}/bookmark", makeHandler(m, reactHandler(ReactionBookmark, false)))
+		return r
+	}
+	r := newRouter(&reqctx.User{ID: "u1"})
+
+	// repeated like is counted once, database reports the same count
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("WITH changed AS \\(INSERT INTO article_reaction(.+) ON CONFLICT DO NOTHING RETURNING 1\\) UPDATE article SET likes = likes \\+ (.+) RETURNING likes;").
+			WithArgs("1", "u1", ReactionLike).
+			WillReturnRows(sqlmock.NewRows([]string{"likes"}).AddRow(6))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/1/like", nil))
+		var resp reactionResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || resp != (reactionResponse{Kind: ReactionLike, Active: true, Count: 6}) {
+			t.Errorf("unexpected response: %v %+v", w.Code, resp)
+		}
+	}
+
+	mock.ExpectQuery("WITH changed AS \\(DELETE FROM article_reaction(.+)\\) UPDATE article SET bookmarks = bookmarks - (.+) RETURNING bookmarks;").
+		WithArgs("2", "u1", ReactionBookmark).
+		WillReturnRows(sqlmock.NewRows([]string{"bookmarks"}))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "http://example.com/2/bookmark", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status of missing article: %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	newRouter(nil).ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/1/like", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected status of anonymous user: %v", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestBookmarksHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db}
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Use(withUser(&reqctx.User{ID: "u1"}))
+	r.Get("/bookmarks", makeHandler(m, bookmarksHandler))
+
+	mock.ExpectQuery("SELECT id, title FROM article JOIN \\(SELECT article_id, created_at AS bookmarked_at FROM article_reaction WHERE user_id = \\$1 AND kind = \\$2\\)(.+) LIMIT \\$3;").
+		WithArgs("u1", ReactionBookmark, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title"}).AddRow(2, "Вторая").AddRow(1, "Новая"))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/bookmarks?limit=5&fields=title", nil))
+	var articles []map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&articles); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(articles) != 2 || articles[0]["id"] != "2" {
+		t.Errorf("unexpected response: %v %v", w.Code, articles)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestPrivacyHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	h := &privacyHandler{m: &Manager{db: db}}
+	mock.ExpectQuery("SELECT article_id, kind, created_at FROM article_reaction WHERE user_id = \\$1").
+		WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"article_id", "kind", "created_at"}).AddRow(1, "like", time.Now()))
+	v, err := h.Export(privacy.Subject{User: "u1"})
+	if reactions, ok := v.([]*UserReaction); err != nil || !ok || len(reactions) != 1 || reactions[0].Kind != ReactionLike {
+		t.Errorf("unexpected export: %v %v", v, err)
+	}
+
+	// reactions are taken out of counts of articles
+	mock.ExpectExec("WITH removed AS \\(DELETE FROM article_reaction WHERE user_id = \\$1 (.+)\\) UPDATE article SET likes = likes - r.likes, bookmarks = bookmarks - r.bookmarks").
+		WithArgs("u1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	if err := h.Erase(privacy.Subject{User: "u1"}); err != nil {
+		t.Error(err)
+	}
+	// tenants have no reactions
+	if err := h.Erase(privacy.Subject{Tenant: "acme"}); err != nil {
+		t.Error(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"<p>@ann and @bob.smith, thanks @ann!</p>", []string{"ann", "bob.smith"}},
+		{"write to ann@example.com or @@ann", nil},
+		{"@ann.", []string{"ann"}},
+	}
+	for _, tt := range tests {
+		if got := Mentions(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.text, got, tt.want)
+		}
+	}
+
+	var got []string
+	ms := &mentions{handler: func(a *Article, link, actorID string, userIDs []string) error {
+		got = append(got, link, actorID)
+		got = append(got, userIDs...)
+		return nil
+	}}
+	r := httptest.NewRequest(http.MethodPut, "http://example.com/1.0/articles/1", nil)
+	r = r.WithContext(reqctx.WithUser(r.Context(), &reqctx.User{ID: "ann"}))
+	// only users mentioned since the previous revision are passed
+	ms.notify(r, &Article{ID: "1", Body: "@ann @bob @eve"}, "@bob")
+	if want := []string{"http://example.com/1.0/articles/1", "ann", "ann", "eve"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected mentions: %v", got)
+	}
+}
+
+func TestAuthzResource(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	resource := AuthzResource(&Manager{db: db})
+	mock.ExpectQuery("SELECT id, coalesce\\(owner_id, ''\\), status, tags FROM article WHERE id = \\$1;").
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "status", "tags"}).AddRow("5", "42", StatusDraft, "{news}"))
+	mock.ExpectQuery("SELECT (.+) FROM article WHERE id = \\$1;").
+		WithArgs("6").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "status", "tags"}))
+
+	attrs, err := resource(context.Background(), map[string]string{"articleID": "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"id": "5", "owner_id": "42", "status": "draft", "tags": []interface{}{"news"}}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+	if attrs, err := resource(context.Background(), map[string]string{"articleID": "6"}); attrs != nil || err != nil {
+		t.Errorf("unexpected attributes of missing article %v, %v", attrs, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
@@ -0,0 +1,81 @@
+//go:build integration
+// +build integration
+
+package article
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/markdown"
+	"github.com/agalitsyn/goapi/pkg/sanitize"
+	"github.com/agalitsyn/goapi/pkg/testutil"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(testutil.Main(m))
+}
+
+func TestIntegration_Lifecycle(t *testing.T) {
+	db, cleanup := testutil.DB(t, Migrations())
+	defer cleanup()
+
+	views := NewViewCounter(db, time.Minute)
+	srv := testutil.NewServer(map[string]http.Handler{
+		"/articles": Routes(NewManager(db, sanitize.DefaultPolicy), Options{
+			Views:           views,
+			Markdown:        markdown.DefaultPolicy,
+			RenderCacheSize: 10,
+		}),
+	})
+	defer srv.Close()
+
+	var created struct {
+		ID    string `json:"id"`
+		Slug  string `json:"slug"`
+		Title string `json:"title"`
+	}
+	resp := testutil.Do(t, srv, "PUT", "/1.0/articles/0", map[string]interface{}{
+		"title": "Hello <b>world</b>",
+		"body":  "**hi**<script>alert(1)</script>",
+	}, &created)
+	if resp.StatusCode != http.StatusCreated || created.Slug != "hello-world" || created.Title != "Hello world" {
+		t.Fatalf("unexpected create response: %d %+v", resp.StatusCode, created)
+	}
+
+	var got struct {
+		ID       string `json:"id"`
+		BodyHTML string `json:"body_html"`
+		Views    int64  `json:"views"`
+	}
+	resp = testutil.Do(t, srv, "GET", "/1.0/articles/"+created.ID+"?render=html", nil, &got)
+	if resp.StatusCode != http.StatusOK || got.BodyHTML != "<p><strong>hi</strong></p>\n" || got.Views != 1 {
+		t.Errorf("unexpected get response: %d %+v", resp.StatusCode, got)
+	}
+
+	resp = testutil.Do(t, srv, "PUT", "/1.0/articles/"+created.ID, map[string]interface{}{
+		"title": "Hello again",
+		"slug":  "hello-again",
+	}, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected update status: %d", resp.StatusCode)
+	}
+	srv.Client().CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp = testutil.Do(t, srv, "GET", "/1.0/articles/slug/hello-world", nil, nil)
+	if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") == "" {
+		t.Errorf("old slug is not redirected: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	resp = testutil.Do(t, srv, "DELETE", "/1.0/articles/"+created.ID, nil, nil)
+	if resp.StatusCode >= http.StatusBadRequest {
+		t.Fatalf("unexpected delete status: %d", resp.StatusCode)
+	}
+	resp = testutil.Do(t, srv, "GET", "/1.0/articles/"+created.ID, nil, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("deleted article is found: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,52 @@
+package article
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/agalitsyn/goapi/pkg/unfurl"
+)
+
+var externalURLRe = regexp.MustCompile(`https?://[^\s<>"'()\[\]]+`)
+
+// externalURLs returns distinct absolute URLs body references in order of appearance.
+func externalURLs(body string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, u := range externalURLRe.FindAllString(body, -1) {
+		u = strings.TrimRight(u, ".,;:!?")
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// linksRelation embeds metadata of up to limit pages article references with ?expand=links, it needs
+// body field. Pages are fetched concurrently, pages which can not be fetched have only url.
+func linksRelation(f *unfurl.Fetcher) Relation {
+	return func(a *Article, limit int) (interface{}, error) {
+		urls := externalURLs(a.Body)
+		if len(urls) > limit {
+			urls = urls[:limit]
+		}
+		links := make([]*unfurl.Meta, len(urls))
+		var wg sync.WaitGroup
+		for i, u := range urls {
+			wg.Add(1)
+			go func(i int, u string) {
+				defer wg.Done()
+				m, err := f.Fetch(context.Background(), u)
+				if err != nil {
+					m = &unfurl.Meta{URL: u}
+				}
+				links[i] = m
+			}(i, u)
+		}
+		wg.Wait()
+		return links, nil
+	}
+}
@@ -0,0 +1,72 @@
+package article
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// MentionHandlerName is a name other modules provide MentionHandler with.
+const MentionHandlerName = "article.mention_handler"
+
+// MentionHandler is called after article is saved with users newly mentioned in its body, e.g. to notify them.
+// link is a page of article, actorID is the user who saved it or empty.
+type MentionHandler func(a *Article, link, actorID string, userIDs []string) error
+
+// mentionRe matches @id which does not follow a word, so emails are not mentions.
+var mentionRe = regexp.MustCompile(`(?:^|[^\w@.])@(\w+(?:[.-]\w+)*)`)
+
+// Mentions returns ids of users mentioned in text as @id in order of first mention.
+func Mentions(text string) []string {
+	var res []string
+	seen := make(map[string]bool)
+	for _, m := range mentionRe.FindAllStringSubmatch(text, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			res = append(res, m[1])
+		}
+	}
+	return res
+}
+
+type mentions struct {
+	handler MentionHandler
+	// urlTemplate is a template of article page, API URL is used if empty.
+	urlTemplate string
+}
+
+// notify passes users mentioned in body of saved article but not in its old body to handler, failure is logged
+// since article is saved anyway. Nothing is done when there is no handler.
+func (ms *mentions) notify(r *http.Request, a *Article, oldBody string) {
+	if ms == nil || ms.handler == nil {
+		return
+	}
+	old := make(map[string]bool)
+	for _, id := range Mentions(oldBody) {
+		old[id] = true
+	}
+	var mentioned []string
+	for _, id := range Mentions(a.Body) {
+		if !old[id] {
+			mentioned = append(mentioned, id)
+		}
+	}
+	if len(mentioned) == 0 {
+		return
+	}
+
+	link := baseURL(r) + strings.TrimSuffix(r.URL.Path, "/")
+	if ms.urlTemplate != "" {
+		link = articleURL(ms.urlTemplate, a)
+	}
+	var actorID string
+	if u := reqctx.GetUser(r.Context()); u != nil {
+		actorID = u.ID
+	}
+	if err := ms.handler(a, link, actorID, mentioned); err != nil {
+		log.GetLogEntry(r).WithField("context", "article").WithError(err).Error("could not notify mentioned users")
+	}
+}
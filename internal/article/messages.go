@@ -0,0 +1,52 @@
+package article
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"article.title_required":  "title is required",
+		"article.invalid_slug":    "slug %q has no letters or digits",
+		"article.invalid_status":  "unknown status %s",
+		"article.duplicate":       "article duplicates existing article %s",
+		"article.invalid_ids":     "ids must be from 1 to %d comma-separated positive integers",
+		"article.invalid_field":   "unknown field %s, fields are: %s",
+		"article.invalid_expand":  "unknown relation %s, relations are: %s",
+		"article.expand_too_deep": "relation %s is nested deeper than %d levels",
+		"article.invalid_cursor":  "invalid cursor %q of changes",
+		"article.invalid_wait":    "invalid wait %q, expected duration like 30s",
+		"article.invalid_lang":    "invalid language %q, expected language tag like en or pt-BR",
+		"article.original_lang":   "articles are written in %s, it has no translation",
+		"article.invalid_author":  "author_id must be a positive integer, got %q",
+		"article.unknown_author":  "author does not exist",
+		"article.hidden":          "article is hidden by moderation and can not be changed",
+		"article.slug_taken":      "slug is taken by article saved at the same time, please retry",
+		"article.user_required":   "sign in to like and bookmark articles",
+
+		"enum.article_status.draft":     "Draft",
+		"enum.article_status.published": "Published",
+		"enum.article_status.archived":  "Archived",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"article.title_required":  "необходимо указать заголовок",
+		"article.invalid_slug":    "slug %q не содержит букв или цифр",
+		"article.invalid_status":  "неизвестный статус %s",
+		"article.duplicate":       "статья повторяет существующую статью %s",
+		"article.invalid_ids":     "ids должен содержать от 1 до %d положительных целых чисел через запятую",
+		"article.invalid_field":   "неизвестное поле %s, доступные поля: %s",
+		"article.invalid_expand":  "неизвестная связь %s, доступные связи: %s",
+		"article.expand_too_deep": "связь %s вложена глубже %d уровней",
+		"article.invalid_cursor":  "неверный курсор изменений %q",
+		"article.invalid_wait":    "неверное время ожидания %q, ожидается длительность вида 30s",
+		"article.invalid_lang":    "неверный язык %q, ожидается тег языка вида en или pt-BR",
+		"article.original_lang":   "статьи написаны на языке %s, у него нет перевода",
+		"article.invalid_author":  "author_id должен быть положительным целым числом, получено %q",
+		"article.unknown_author":  "автор не существует",
+		"article.hidden":          "статья скрыта модерацией и не может быть изменена",
+		"article.slug_taken":      "slug занят статьёй, сохранённой одновременно, повторите запрос",
+		"article.user_required":   "войдите, чтобы отмечать статьи и добавлять их в закладки",
+
+		"enum.article_status.draft":     "Черновик",
+		"enum.article_status.published": "Опубликована",
+		"enum.article_status.archived":  "В архиве",
+	})
+}
@@ -1,6 +1,10 @@
 package article
 
-import migrate "github.com/rubenv/sql-migrate"
+import (
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/internal/cdc"
+)
 
 func Migrations() []*migrate.Migration {
 	return []*migrate.Migration{
@@ -15,5 +19,196 @@ func Migrations() []*migrate.Migration {
 				);`,
 			},
 		},
+		{
+			Id: "0004_article_status",
+			Up: []string{
+				// existing articles were publicly visible
+				`ALTER TABLE article
+					ADD COLUMN status character varying(16) NOT NULL DEFAULT 'published';`,
+			},
+		},
+		{
+			Id: "0005_article_tags",
+			Up: []string{
+				`ALTER TABLE article
+					ADD COLUMN tags         character varying(64)[]     NOT NULL DEFAULT '{}',
+					ADD COLUMN created_at   timestamp with time zone    NOT NULL DEFAULT now();`,
+				`CREATE INDEX article_created_at_idx ON article (created_at);`,
+				`CREATE INDEX article_tags_idx ON article USING GIN (tags);`,
+			},
+		},
+		{
+			Id: "0006_article_views",
+			Up: []string{
+				`ALTER TABLE article ADD COLUMN views bigint NOT NULL DEFAULT 0;`,
+				`CREATE INDEX article_views_idx ON article (views DESC);`,
+			},
+		},
+		{
+			Id: "0007_article_slug",
+			Up: []string{
+				// slugs were not unique before, duplicates get article id as suffix
+				`UPDATE article SET slug = slug || '-' || id
+					WHERE id NOT IN (SELECT min(id) FROM article GROUP BY slug);`,
+				`CREATE UNIQUE INDEX article_slug_idx ON article (slug);`,
+				`CREATE TABLE article_slug_history (
+					slug        character varying(128)      NOT NULL,
+					article_id  integer                     NOT NULL REFERENCES article(id) ON DELETE CASCADE,
+					PRIMARY KEY (slug)
+				);`,
+			},
+		},
+		{
+			Id: "0008_article_body",
+			Up: []string{
+				`ALTER TABLE article
+					ADD COLUMN body         text        NOT NULL DEFAULT '',
+					ADD COLUMN revision     integer     NOT NULL DEFAULT 1;`,
+			},
+		},
+		{
+			Id: "0009_article_content_hash",
+			Up: []string{
+				// expression must match contentExpr to be used by duplicate detection
+				`CREATE INDEX article_content_hash_idx ON article (md5(btrim(regexp_replace(lower(title || ' ' || body), '\s+', ' ', 'g'))));`,
+			},
+		},
+		{
+			Id: "0013_article_updated_at",
+			Up: []string{
+				`ALTER TABLE article ADD COLUMN updated_at timestamp with time zone NOT NULL DEFAULT now();`,
+				`UPDATE article SET updated_at = created_at;`,
+				`CREATE INDEX article_updated_at_idx ON article (updated_at);`,
+				// views are counted separately and do not change articles
+				`CREATE FUNCTION article_touch() RETURNS trigger AS $$
+				BEGIN
+					NEW.updated_at := now();
+					RETURN NEW;
+				END;
+				$$ LANGUAGE plpgsql;`,
+				`CREATE TRIGGER article_touch BEFORE UPDATE OF title, slug, status, tags, body ON article
+					FOR EACH ROW
+					WHEN ((OLD.title, OLD.slug, OLD.status, OLD.tags, OLD.body) IS DISTINCT FROM (NEW.title, NEW.slug, NEW.status, NEW.tags, NEW.body))
+					EXECUTE PROCEDURE article_touch();`,
+				// deleted articles leave no updated_at behind, so time of the last deletion is kept aside
+				`CREATE TABLE article_deletion (deleted_at timestamp with time zone NOT NULL);`,
+				`INSERT INTO article_deletion VALUES (now());`,
+				`CREATE FUNCTION article_deleted() RETURNS trigger AS $$
+				BEGIN
+					UPDATE article_deletion SET deleted_at = now();
+					RETURN NULL;
+				END;
+				$$ LANGUAGE plpgsql;`,
+				// truncate covers restores from backup, which may bring older updated_at back
+				`CREATE TRIGGER article_deleted AFTER DELETE OR TRUNCATE ON article
+					FOR EACH STATEMENT EXECUTE PROCEDURE article_deleted();`,
+			},
+		},
+		{
+			Id: "0014_article_change",
+			Up: []string{
+				`CREATE TABLE article_change (
+					id          bigserial                   NOT NULL,
+					article_id  integer                     NOT NULL,
+					action      character varying(16)       NOT NULL,
+					xid         bigint                      NOT NULL DEFAULT txid_current(),
+					created_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id)
+				);`,
+				`CREATE INDEX article_change_created_at_idx ON article_change (created_at);`,
+				`CREATE FUNCTION article_log_change() RETURNS trigger AS $$
+				BEGIN
+					IF TG_OP = 'DELETE' THEN
+						INSERT INTO article_change(article_id, action) VALUES (OLD.id, 'deleted');
+					ELSIF TG_OP = 'INSERT' THEN
+						INSERT INTO article_change(article_id, action) VALUES (NEW.id, 'created');
+					ELSE
+						INSERT INTO article_change(article_id, action) VALUES (NEW.id, 'updated');
+					END IF;
+					RETURN NULL;
+				END;
+				$$ LANGUAGE plpgsql;`,
+				`CREATE TRIGGER article_log_insert AFTER INSERT OR DELETE ON article
+					FOR EACH ROW EXECUTE PROCEDURE article_log_change();`,
+				// views are not changes, like for updated_at
+				`CREATE TRIGGER article_log_update AFTER UPDATE OF title, slug, status, tags, body ON article
+					FOR EACH ROW
+					WHEN ((OLD.title, OLD.slug, OLD.status, OLD.tags, OLD.body) IS DISTINCT FROM (NEW.title, NEW.slug, NEW.status, NEW.tags, NEW.body))
+					EXECUTE PROCEDURE article_log_change();`,
+			},
+		},
+		{
+			Id: "0016_article_cdc",
+			Up: cdc.Capture("article"),
+		},
+		{
+			Id: "0017_article_translation",
+			Up: []string{
+				`CREATE TABLE article_translation (
+					article_id  integer                     NOT NULL REFERENCES article(id) ON DELETE CASCADE,
+					language    character varying(35)       NOT NULL,
+					title       character varying(256)      NOT NULL,
+					body        text                        NOT NULL DEFAULT '',
+					revision    integer                     NOT NULL DEFAULT 1,
+					updated_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (article_id, language)
+				);`,
+			},
+		},
+		{
+			// foreign key is added by authors module with author table
+			Id: "0018_article_author",
+			Up: []string{
+				`ALTER TABLE article ADD COLUMN author_id integer;`,
+				`CREATE INDEX article_author_id_idx ON article (author_id, created_at DESC);`,
+				// change of author is a change of article like in 0013 and 0014
+				`DROP TRIGGER article_touch ON article;`,
+				`CREATE TRIGGER article_touch BEFORE UPDATE OF title, slug, status, tags, body, author_id ON article
+					FOR EACH ROW
+					WHEN ((OLD.title, OLD.slug, OLD.status, OLD.tags, OLD.body, OLD.author_id) IS DISTINCT FROM (NEW.title, NEW.slug, NEW.status, NEW.tags, NEW.body, NEW.author_id))
+					EXECUTE PROCEDURE article_touch();`,
+				`DROP TRIGGER article_log_update ON article;`,
+				`CREATE TRIGGER article_log_update AFTER UPDATE OF title, slug, status, tags, body, author_id ON article
+					FOR EACH ROW
+					WHEN ((OLD.title, OLD.slug, OLD.status, OLD.tags, OLD.body, OLD.author_id) IS DISTINCT FROM (NEW.title, NEW.slug, NEW.status, NEW.tags, NEW.body, NEW.author_id))
+					EXECUTE PROCEDURE article_log_change();`,
+			},
+		},
+		{
+			Id: "0020_article_reaction",
+			Up: []string{
+				`CREATE TABLE article_reaction (
+					article_id  integer                     NOT NULL REFERENCES article(id) ON DELETE CASCADE,
+					user_id     character varying(128)      NOT NULL,
+					kind        character varying(16)       NOT NULL,
+					created_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (article_id, user_id, kind)
+				);`,
+				`CREATE INDEX article_reaction_user_id_idx ON article_reaction (user_id, kind, created_at DESC);`,
+				// counts are kept with articles like views, they are not changes of articles
+				`ALTER TABLE article
+					ADD COLUMN likes        bigint      NOT NULL DEFAULT 0,
+					ADD COLUMN bookmarks    bigint      NOT NULL DEFAULT 0;`,
+			},
+		},
+		{
+			Id: "0037_article_owner",
+			Up: []string{
+				// owner is a user who created article, unlike author it is not shown and only authorizes
+				`ALTER TABLE article ADD COLUMN owner_id character varying(128);`,
+			},
+		},
+		{
+			// views, likes and bookmarks are not changes, like for article_log_update
+			Id: "0038_article_cdc_columns",
+			Up: cdc.CaptureColumns("article", "title", "slug", "status", "tags", "body", "author_id"),
+		},
+		{
+			// hidden articles are kept out of reads and updates, e.g. pending moderation
+			Id: "0040_article_hidden",
+			Up: append([]string{
+				`ALTER TABLE article ADD COLUMN hidden boolean NOT NULL DEFAULT false;`,
+			}, cdc.CaptureColumns("article", "title", "slug", "status", "tags", "body", "author_id", "hidden")...),
+		},
 	}
 }
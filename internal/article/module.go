@@ -0,0 +1,183 @@
+package article
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/internal/privacy"
+	"github.com/agalitsyn/goapi/internal/sitemap"
+	"github.com/agalitsyn/goapi/pkg/authz"
+	"github.com/agalitsyn/goapi/pkg/markdown"
+	"github.com/agalitsyn/goapi/pkg/module"
+	"github.com/agalitsyn/goapi/pkg/retention"
+	"github.com/agalitsyn/goapi/pkg/sanitize"
+	"github.com/agalitsyn/goapi/pkg/surrogate"
+	"github.com/agalitsyn/goapi/pkg/unfurl"
+)
+
+func init() {
+	module.Register(&articleModule{})
+}
+
+// RelationPrefix is a prefix of names other modules provide article relations with, e.g. article.relation.attachments.
+const RelationPrefix = "article.relation."
+
+type articleModule struct {
+	module.Base
+
+	opts struct {
+		StatsCacheTTL      time.Duration `long:"articles-stats-cache-ttl" env:"GAPI_ARTICLES_STATS_CACHE_TTL" default:"1m" description:"How long to cache article stats, 0 disables."`
+		ViewsFlushInterval time.Duration `long:"articles-views-flush-interval" env:"GAPI_ARTICLES_VIEWS_FLUSH_INTERVAL" default:"10s" description:"How often to write accumulated article views to database."`
+		HTMLAllow          []string      `long:"articles-html-allow" env:"GAPI_ARTICLES_HTML_ALLOW" env-delim:"," default:"h1" default:"h2" default:"h3" default:"h4" default:"h5" default:"h6" default:"p" default:"br" default:"hr" default:"b" default:"i" default:"strong" default:"em" default:"code" default:"pre" default:"blockquote" default:"ul" default:"ol" default:"li" default:"a[href|title|rel]" default:"img[src|alt|title]" description:"HTML elements with attributes allowed in article bodies in form name or name[attr|attr], everything else is stripped on write and from HTML rendered from Markdown."`
+		SurrogateMaxAge    time.Duration `long:"articles-surrogate-max-age" env:"GAPI_ARTICLES_SURROGATE_MAX_AGE" default:"0" description:"How long response cache and CDN may keep article lists and feeds, they are purged when articles change. 0 disables caching."`
+		ChangesInterval    time.Duration `long:"articles-changes-interval" env:"GAPI_ARTICLES_CHANGES_INTERVAL" default:"1s" description:"How often to check for article changes clients wait for."`
+		ChangesMaxWait     time.Duration `long:"articles-changes-max-wait" env:"GAPI_ARTICLES_CHANGES_MAX_WAIT" default:"30s" description:"How long clients may wait for article changes in a single request."`
+		RelatedScorer      string        `long:"articles-related-scorer" env:"GAPI_ARTICLES_RELATED_SCORER" default:"tags" choice:"tags" choice:"text" description:"How to find related articles: by shared tags or by full-text similarity of titles."`
+		Language           string        `long:"articles-language" env:"GAPI_ARTICLES_LANGUAGE" default:"en" description:"Language articles are written in, clients preferring other languages in Accept-Language get translations when there are any."`
+
+		Feed struct {
+			Title      string        `long:"articles-feed-title" env:"GAPI_ARTICLES_FEED_TITLE" default:"Articles" description:"Title of RSS and Atom feeds."`
+			ArticleURL string        `long:"articles-feed-article-url" env:"GAPI_ARTICLES_FEED_ARTICLE_URL" description:"Template of article page URL in feeds, {id} and {slug} are replaced. API URL is used if empty."`
+			Size       int           `long:"articles-feed-size" env:"GAPI_ARTICLES_FEED_SIZE" default:"20" description:"How many latest articles feeds contain."`
+			MaxAge     time.Duration `long:"articles-feed-max-age" env:"GAPI_ARTICLES_FEED_MAX_AGE" default:"5m" description:"How long clients may cache feeds."`
+		}
+
+		PreviewImage string `long:"articles-preview-image" env:"GAPI_ARTICLES_PREVIEW_IMAGE" description:"URL of image in previews of articles without images in body."`
+
+		Links struct {
+			Timeout   time.Duration `long:"articles-links-timeout" env:"GAPI_ARTICLES_LINKS_TIMEOUT" default:"5s" description:"Timeout of fetching metadata of pages articles link to."`
+			MaxBytes  int64         `long:"articles-links-max-bytes" env:"GAPI_ARTICLES_LINKS_MAX_BYTES" default:"262144" description:"How much of linked page to read looking for metadata."`
+			CacheSize int           `long:"articles-links-cache-size" env:"GAPI_ARTICLES_LINKS_CACHE_SIZE" default:"1000" description:"How many linked pages to keep metadata of."`
+			CacheTTL  time.Duration `long:"articles-links-cache-ttl" env:"GAPI_ARTICLES_LINKS_CACHE_TTL" default:"1h" description:"How long to keep metadata of linked pages."`
+		}
+
+		Duplicates struct {
+			Check     bool    `long:"articles-duplicates-check" env:"GAPI_ARTICLES_DUPLICATES_CHECK" description:"Reject new articles duplicating existing ones with 409 unless client passes ?check_duplicates=false."`
+			Threshold float64 `long:"articles-duplicates-threshold" env:"GAPI_ARTICLES_DUPLICATES_THRESHOLD" default:"0.8" description:"Title similarity from 0 to 1 starting from which articles are duplicates, articles with the same content always are."`
+		}
+
+		Markdown struct {
+			Allow     []string `long:"articles-markdown-allow" env:"GAPI_ARTICLES_MARKDOWN_ALLOW" env-delim:"," default:"h1" default:"h2" default:"h3" default:"h4" default:"h5" default:"h6" default:"p" default:"pre" default:"code" default:"blockquote" default:"ul" default:"ol" default:"li" default:"hr" default:"strong" default:"em" default:"a" default:"img" description:"HTML elements article bodies may be rendered to, others are rendered as text."`
+			CacheSize int      `long:"articles-markdown-cache-size" env:"GAPI_ARTICLES_MARKDOWN_CACHE_SIZE" default:"1000" description:"How many rendered article bodies to cache."`
+		}
+	}
+
+	env     *module.Env
+	manager *Manager
+	// articleURL is a canonical URL template of articles
+	articleURL string
+	views      *ViewCounter
+	changes    *ChangeFeed
+	unfurler   *unfurl.Fetcher
+}
+
+func (mod *articleModule) Name() string                     { return "articles" }
+func (mod *articleModule) Options() interface{}             { return &mod.opts }
+func (mod *articleModule) Migrations() []*migrate.Migration { return Migrations() }
+func (mod *articleModule) Jobs() []module.Job               { return []module.Job{mod.views, mod.changes} }
+
+// RetentionRules keep change log for a week, clients which are away longer reload articles.
+func (mod *articleModule) RetentionRules() []retention.Rule {
+	return []retention.Rule{{Name: "changes", Table: "article_change", Column: "created_at", MaxAge: 7 * 24 * time.Hour}}
+}
+
+// Init provides manager as article.manager, sitemap source as sitemap.source.articles and privacy handler
+// of reactions as privacy.handler.articles.
+func (mod *articleModule) Init(env *module.Env) error {
+	html, err := sanitize.ParsePolicy(mod.opts.HTMLAllow...)
+	if err != nil {
+		return err
+	}
+	if mod.opts.Language, err = ParseLanguage(mod.opts.Language); err != nil {
+		return err
+	}
+	if mod.opts.ViewsFlushInterval <= 0 {
+		return errors.New("--articles-views-flush-interval must be positive")
+	}
+	if mod.opts.ChangesInterval <= 0 {
+		return errors.New("--articles-changes-interval must be positive")
+	}
+	mod.env = env
+	mod.manager = NewManager(env.DB, html)
+	mod.views = NewViewCounter(env.DB, mod.opts.ViewsFlushInterval)
+	mod.changes = NewChangeFeed(mod.manager, mod.opts.ChangesInterval)
+	mod.unfurler = unfurl.New(unfurl.Config{
+		Timeout:   mod.opts.Links.Timeout,
+		MaxBytes:  mod.opts.Links.MaxBytes,
+		CacheSize: mod.opts.Links.CacheSize,
+		CacheTTL:  mod.opts.Links.CacheTTL,
+		UserAgent: "goapi",
+		Egress:    env.Egress,
+	})
+	env.Provide("article.manager", mod.manager)
+	env.Provide(privacy.HandlerPrefix+"articles", &privacyHandler{m: mod.manager})
+
+	mod.articleURL = mod.opts.Feed.ArticleURL
+	if mod.articleURL == "" {
+		mod.articleURL = env.BaseURL + "/1.0/articles/{id}"
+	}
+	env.Provide(sitemap.SourcePrefix+"articles", SitemapSource(mod.manager, mod.articleURL))
+	env.Provide(authz.ResourcePrefix+"article", AuthzResource(mod.manager))
+	return nil
+}
+
+// Routes embed relations provided by other modules, so they are built after all modules are initialized.
+func (mod *articleModule) Routes() map[string]http.Handler {
+	relations := make(map[string]Relation)
+	for name, rel := range mod.env.LookupPrefix(RelationPrefix) {
+		relations[name] = rel.(Relation)
+	}
+	var purger surrogate.Purger
+	if p, ok := mod.env.Lookup("surrogate.purger"); ok {
+		purger = p.(surrogate.Purger)
+	}
+	var mentions MentionHandler
+	if h, ok := mod.env.Lookup(MentionHandlerName); ok {
+		mentions = h.(MentionHandler)
+	}
+	eventHandlers := make(map[string]EventHandler)
+	for name, h := range mod.env.LookupPrefix(EventHandlerPrefix) {
+		eventHandlers[name] = h.(EventHandler)
+	}
+	var scorer Scorer = NewTagScorer(mod.env.DB)
+	if mod.opts.RelatedScorer == "text" {
+		scorer = NewTextScorer(mod.env.DB)
+	}
+	return map[string]http.Handler{
+		"/articles": Routes(mod.manager, Options{
+			Views:    mod.views,
+			Scorer:   scorer,
+			StatsTTL: mod.opts.StatsCacheTTL,
+			Feed: FeedConfig{
+				Title:      mod.opts.Feed.Title,
+				ArticleURL: mod.opts.Feed.ArticleURL,
+				Size:       mod.opts.Feed.Size,
+				MaxAge:     mod.opts.Feed.MaxAge,
+			},
+			Duplicates: Duplicates{
+				Check:     mod.opts.Duplicates.Check,
+				Threshold: mod.opts.Duplicates.Threshold,
+			},
+			Relations:       relations,
+			Markdown:        markdown.NewPolicy(mod.opts.Markdown.Allow...),
+			RenderCacheSize: mod.opts.Markdown.CacheSize,
+			Purger:          purger,
+			SurrogateMaxAge: mod.opts.SurrogateMaxAge,
+			Changes:         mod.changes,
+			MaxChangesWait:  mod.opts.ChangesMaxWait,
+			Language:        mod.opts.Language,
+			Mentions:        mentions,
+			EventHandlers:   eventHandlers,
+			Preview: PreviewConfig{
+				SiteName:   mod.opts.Feed.Title,
+				ArticleURL: mod.articleURL,
+				BaseURL:    mod.env.BaseURL,
+				Image:      mod.opts.PreviewImage,
+			},
+			Unfurler: mod.unfurler,
+		}),
+	}
+}
@@ -0,0 +1,185 @@
+package article
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/markdown"
+	"github.com/agalitsyn/goapi/pkg/sanitize"
+	"github.com/agalitsyn/goapi/pkg/surrogate"
+)
+
+// PreviewConfig configures Open Graph and Twitter card previews of articles.
+type PreviewConfig struct {
+	SiteName string
+	// ArticleURL is a template of canonical article URL, {id} and {slug} are replaced.
+	// API URL of article under BaseURL is used if empty.
+	ArticleURL string
+	// BaseURL is public URL of service, it resolves relative images of bodies, they are skipped if empty.
+	BaseURL string
+	// Image is shown for articles without images in body.
+	Image string
+}
+
+// maxDescription is how many characters of body are in preview description.
+const maxDescription = 200
+
+// Preview is metadata link unfurlers show for article.
+type Preview struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image,omitempty"`
+	URL         string `json:"url,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+	// Card is a Twitter card type, summary_large_image when there is an image.
+	Card string `json:"card"`
+}
+
+var imageRe = regexp.MustCompile(`!\[[^\]]*\]\(([^)\s]+)`)
+
+func newPreview(a *Article, c PreviewConfig) *Preview {
+	p := &Preview{
+		Title:       a.Title,
+		Description: describe(a.Body),
+		Image:       c.Image,
+		SiteName:    c.SiteName,
+		Card:        "summary",
+	}
+	if c.ArticleURL != "" {
+		p.URL = articleURL(c.ArticleURL, a)
+	}
+	for _, m := range imageRe.FindAllStringSubmatch(a.Body, -1) {
+		if src := absoluteURL(c.BaseURL, m[1]); src != "" {
+			p.Image = src
+			break
+		}
+	}
+	if p.Image != "" {
+		p.Card = "summary_large_image"
+	}
+	return p
+}
+
+// describe returns the beginning of body as plain text, markup and raw HTML are removed.
+func describe(body string) string {
+	text := sanitize.TextPolicy.Sanitize(markdown.Render(sanitize.TextPolicy.Sanitize(body), markdown.NewPolicy()))
+	text = html.UnescapeString(text)
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= maxDescription {
+		return text
+	}
+	runes := []rune(text)[:maxDescription]
+	// cut at word boundary unless the word is the whole description
+	if i := strings.LastIndexByte(string(runes), ' '); i > 0 {
+		return string(runes)[:i] + "…"
+	}
+	return string(runes) + "…"
+}
+
+// absoluteURL resolves src against base, unsafe URLs and relative ones without base are skipped.
+func absoluteURL(base, src string) string {
+	u, err := url.Parse(src)
+	if err != nil || !markdown.SafeURL(src) {
+		return ""
+	}
+	if u.IsAbs() {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return ""
+		}
+		return u.String()
+	}
+	b, err := url.Parse(base)
+	if base == "" || err != nil {
+		return ""
+	}
+	return b.ResolveReference(u).String()
+}
+
+// previewRelation embeds preview of article with ?expand=preview, it needs title and body fields.
+func previewRelation(c PreviewConfig) Relation {
+	return func(a *Article, limit int) (interface{}, error) {
+		return newPreview(a, c), nil
+	}
+}
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta name="description" content="{{.Description}}">
+<meta property="og:type" content="article">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.URL}}">
+{{- if .SiteName}}
+<meta property="og:site_name" content="{{.SiteName}}">
+{{- end}}
+{{- if .Image}}
+<meta property="og:image" content="{{.Image}}">
+<meta name="twitter:image" content="{{.Image}}">
+{{- end}}
+<meta name="twitter:card" content="{{.Card}}">
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Description}}">
+<link rel="canonical" href="{{.URL}}">
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Description}}</p>
+<p><a href="{{.URL}}">{{.URL}}</a></p>
+</body>
+</html>
+`))
+
+// previewHandler renders HTML page with Open Graph and Twitter card tags for link unfurlers,
+// which do not run scripts. Only published articles have previews, views are not counted.
+func previewHandler(c PreviewConfig, maxAge time.Duration) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "article")
+
+		a, err := m.ByID(chi.URLParam(r, "articleID"))
+		if err == nil && a.Status != StatusPublished {
+			err = ErrNotFound
+		}
+		if err != nil {
+			if err == ErrNotFound {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrNotFound(err))
+				return
+			}
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+
+		p := newPreview(a, c)
+		// previews are cached, so URL is never taken from Host of request
+		if p.URL == "" && c.BaseURL != "" {
+			p.URL = c.BaseURL + strings.TrimSuffix(r.URL.Path, "/preview")
+		}
+		var buf bytes.Buffer
+		if err := previewTemplate.Execute(&buf, p); err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+
+		surrogate.Tag(w, SurrogateKey(a.ID))
+		surrogate.Control(w, maxAge)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(buf.Bytes())
+	}
+}
@@ -0,0 +1,150 @@
+package article
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/internal/privacy"
+)
+
+// Reaction is a kind of mark users put on articles, every user has at most one of each kind per article.
+type Reaction string
+
+const (
+	ReactionLike     Reaction = "like"
+	ReactionBookmark Reaction = "bookmark"
+)
+
+// counter is a column of article which counts reactions of the kind.
+func (r Reaction) counter() string {
+	if r == ReactionBookmark {
+		return "bookmarks"
+	}
+	return "likes"
+}
+
+// React adds reaction of user to article, or removes it when on is false, and returns the resulting
+// count of reactions of the kind. It is idempotent, reacting twice is counted once.
+func (m *Manager) React(articleID, userID string, kind Reaction, on bool) (int64, error) {
+	change := `INSERT INTO article_reaction(article_id, user_id, kind) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING RETURNING 1`
+	op := "+"
+	if !on {
+		change = `DELETE FROM article_reaction WHERE article_id = $1 AND user_id = $2 AND kind = $3 RETURNING 1`
+		op = "-"
+	}
+	var count int64
+	err := m.db.QueryRow(
+		`WITH changed AS (`+change+`)
+		UPDATE article SET `+kind.counter()+` = `+kind.counter()+` `+op+` (SELECT count(*) FROM changed) WHERE id = $1
+		RETURNING `+kind.counter()+`;`,
+		articleID, userID, kind,
+	).Scan(&count)
+	if err == sql.ErrNoRows || isForeignKeyViolation(err) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "could not change reaction to article")
+	}
+	return count, nil
+}
+
+// Reacted reports whether user has reaction of the kind to article.
+func (m *Manager) Reacted(articleID, userID string, kind Reaction) (bool, error) {
+	var reacted bool
+	err := m.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM article_reaction WHERE article_id = $1 AND user_id = $2 AND kind = $3);",
+		articleID, userID, kind,
+	).Scan(&reacted)
+	if err != nil {
+		return false, errors.Wrap(err, "could not get reaction to article")
+	}
+	return reacted, nil
+}
+
+// Bookmarked returns articles bookmarked by user, the latest bookmarked first.
+func (m *Manager) Bookmarked(userID string, limit int) ([]*Article, error) {
+	rows, err := m.db.Query(
+		"SELECT "+m.fields.columns()+` FROM article
+		JOIN (SELECT article_id, created_at AS bookmarked_at FROM article_reaction WHERE user_id = $1 AND kind = $2) AS r
+			ON r.article_id = id
+		WHERE NOT hidden
+		ORDER BY r.bookmarked_at DESC, id DESC LIMIT $3;`,
+		userID, ReactionBookmark, limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get bookmarked articles")
+	}
+	defer rows.Close()
+
+	articles := []*Article{}
+	for rows.Next() {
+		a, err := m.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get bookmarked articles")
+	}
+	return articles, nil
+}
+
+// UserReaction is a reaction of user exported on privacy requests.
+type UserReaction struct {
+	ArticleID string    `json:"article_id"`
+	Kind      Reaction  `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// privacyHandler exports and erases reactions of users, tenants have none.
+type privacyHandler struct {
+	m *Manager
+}
+
+func (h *privacyHandler) Export(s privacy.Subject) (interface{}, error) {
+	res := []*UserReaction{}
+	if s.User == "" {
+		return res, nil
+	}
+	rows, err := h.m.db.Query("SELECT article_id, kind, created_at FROM article_reaction WHERE user_id = $1 ORDER BY created_at;", s.User)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get reactions of user")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r UserReaction
+		if err := rows.Scan(&r.ArticleID, &r.Kind, &r.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan row to reaction model")
+		}
+		res = append(res, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get reactions of user")
+	}
+	return res, nil
+}
+
+// Erase removes reactions of user and takes them out of counts of articles.
+func (h *privacyHandler) Erase(s privacy.Subject) error {
+	if s.User == "" {
+		return nil
+	}
+	_, err := h.m.db.Exec(
+		`WITH removed AS (DELETE FROM article_reaction WHERE user_id = $1 RETURNING article_id, kind)
+		UPDATE article SET likes = likes - r.likes, bookmarks = bookmarks - r.bookmarks
+		FROM (
+			SELECT article_id, count(*) FILTER (WHERE kind = 'like') AS likes, count(*) FILTER (WHERE kind = 'bookmark') AS bookmarks
+			FROM removed GROUP BY article_id
+		) AS r
+		WHERE article.id = r.article_id;`,
+		s.User,
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not erase reactions of user")
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package article
+
+import (
+	"database/sql"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+// Related is an article with its similarity score, higher is more similar.
+type Related struct {
+	*Article
+	Score float64 `json:"score"`
+}
+
+// Scorer finds articles related to the given one, best first.
+// It is an extension point for other backends, e.g. ML-based recommendations.
+type Scorer interface {
+	Related(a *Article, limit int) ([]*Related, error)
+}
+
+// TagScorer scores articles by Jaccard similarity of their tags.
+type TagScorer struct {
+	db postgres.Querier
+}
+
+func NewTagScorer(db postgres.Querier) *TagScorer {
+	return &TagScorer{db: db}
+}
+
+func (s *TagScorer) Related(a *Article, limit int) ([]*Related, error) {
+	if len(a.Tags) == 0 {
+		return []*Related{}, nil
+	}
+	rows, err := s.db.Query(
+		`SELECT id, title, slug, status, tags, views, created_at, body, revision, author_id, likes, bookmarks, shared::float8 / (cardinality(tags) + $3 - shared) AS score
+		FROM (
+			SELECT *, cardinality(ARRAY(SELECT unnest(tags) INTERSECT SELECT unnest($2::varchar[]))) AS shared
+			FROM article
+			WHERE tags && $2::varchar[] AND id <> $1 AND NOT hidden
+		) AS candidate
+		ORDER BY score DESC, views DESC, id LIMIT $4;`,
+		a.ID, pq.Array(a.Tags), len(a.Tags), limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get related articles by tags")
+	}
+	defer rows.Close()
+	return scanRelated(rows)
+}
+
+// TextScorer scores articles by full-text rank of their titles against the title of the given one.
+type TextScorer struct {
+	db postgres.Querier
+}
+
+func NewTextScorer(db postgres.Querier) *TextScorer {
+	return &TextScorer{db: db}
+}
+
+func (s *TextScorer) Related(a *Article, limit int) ([]*Related, error) {
+	query := orQuery(a.Title)
+	if query == "" {
+		return []*Related{}, nil
+	}
+	rows, err := s.db.Query(
+		`SELECT id, title, slug, status, tags, views, created_at, body, revision, author_id, likes, bookmarks, ts_rank(to_tsvector('simple', title), q) AS score
+		FROM article, to_tsquery('simple', $2) AS q
+		WHERE to_tsvector('simple', title) @@ q AND id <> $1 AND NOT hidden
+		ORDER BY score DESC, views DESC, id LIMIT $3;`,
+		a.ID, query, limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get related articles by text")
+	}
+	defer rows.Close()
+	return scanRelated(rows)
+}
+
+// orQuery makes tsquery matching any word of text, other characters are dropped so it is always valid.
+func orQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " | ")
+}
+
+func scanRelated(rows *sql.Rows) ([]*Related, error) {
+	res := []*Related{}
+	for rows.Next() {
+		var a Article
+		r := &Related{Article: &a}
+		err := rows.Scan(&a.ID, &a.Title, &a.Slug, &a.Status, pq.Array(&a.Tags), &a.Views, &a.CreatedAt, &a.Body, &a.Revision, &a.AuthorID, &a.Likes, &a.Bookmarks, &r.Score)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not scan row to related article")
+		}
+		res = append(res, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get related articles")
+	}
+	return res, nil
+}
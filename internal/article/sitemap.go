@@ -0,0 +1,18 @@
+package article
+
+import "github.com/agalitsyn/goapi/internal/sitemap"
+
+// SitemapSource lists published articles, urlTemplate is an article page URL where {id} and {slug} are replaced.
+func SitemapSource(m *Manager, urlTemplate string) sitemap.Source {
+	return func() ([]sitemap.URL, error) {
+		articles, err := m.AllPublished()
+		if err != nil {
+			return nil, err
+		}
+		urls := make([]sitemap.URL, 0, len(articles))
+		for _, a := range articles {
+			urls = append(urls, sitemap.URL{Loc: articleURL(urlTemplate, a), LastMod: a.CreatedAt})
+		}
+		return urls, nil
+	}
+}
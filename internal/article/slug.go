@@ -0,0 +1,141 @@
+package article
+
+import (
+	"bytes"
+	"database/sql"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// maxSlugLen leaves room for collision suffix within slug column size.
+const maxSlugLen = 120
+
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "h", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "sch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya",
+}
+
+// Slugify makes URL-safe slug of lowercase latin letters, digits and dashes, Cyrillic is transliterated.
+func Slugify(s string) string {
+	var b bytes.Buffer
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+			dash = false
+		case translit[r] != "" || r == 'ъ' || r == 'ь':
+			b.WriteString(translit[r])
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+	if len(slug) > maxSlugLen {
+		slug = strings.TrimSuffix(slug[:maxSlugLen], "-")
+	}
+	return slug
+}
+
+// slugAttempts is how many times transaction picking slug runs when slugs are taken concurrently.
+const slugAttempts = 3
+
+// slugTx runs fn in transaction again when slug fn picked with UniqueSlug is taken meanwhile
+// by article saved concurrently, the next run picks another one.
+func (m *Manager) slugTx(dryRun bool, fn func(m *Manager) error) error {
+	var err error
+	for i := 0; i < slugAttempts; i++ {
+		if err = m.Tx(dryRun, fn); err != ErrSlugTaken {
+			return err
+		}
+	}
+	return err
+}
+
+// UniqueSlug returns base or base with the smallest free numeric suffix, e.g. news-2.
+// Slug of article with exceptID is not considered taken.
+func (m *Manager) UniqueSlug(base, exceptID string) (string, error) {
+	rows, err := m.db.Query(
+		"SELECT slug FROM article WHERE (slug = $1 OR slug LIKE $2) AND id::text <> $3;",
+		base, strings.Replace(base, "_", `\_`, -1)+"-%", exceptID,
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "could not get taken slugs")
+	}
+	defer rows.Close()
+
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return "", errors.Wrap(err, "could not scan slug")
+		}
+		taken[slug] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", errors.Wrap(err, "could not get taken slugs")
+	}
+
+	slug := base
+	for n := 2; taken[slug]; n++ {
+		slug = base + "-" + strconv.Itoa(n)
+	}
+	return slug, nil
+}
+
+// BySlug returns article by slug.
+func (m *Manager) BySlug(slug string) (*Article, error) {
+	rows, err := m.db.Query("SELECT "+m.fields.columns()+" FROM article WHERE slug = $1 AND NOT hidden;", slug)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get article by slug")
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, errors.Wrap(err, "could not get article by slug")
+		}
+		return nil, ErrNotFound
+	}
+	return m.scan(rows)
+}
+
+// CurrentSlug returns the current slug of article which had the old one.
+func (m *Manager) CurrentSlug(old string) (string, error) {
+	var slug string
+	err := m.db.QueryRow(
+		"SELECT a.slug FROM article_slug_history h JOIN article a ON a.id = h.article_id WHERE h.slug = $1 AND NOT a.hidden;", old,
+	).Scan(&slug)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "could not get current slug")
+	}
+	return slug, nil
+}
+
+// RenameSlug keeps old slug of article in history, so links to it can be redirected.
+func (m *Manager) RenameSlug(a *Article, old string) error {
+	_, err := m.db.Exec(
+		`INSERT INTO article_slug_history(slug, article_id) VALUES ($1, $2)
+		ON CONFLICT (slug) DO UPDATE SET article_id = EXCLUDED.article_id;`,
+		old, a.ID,
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not save slug history")
+	}
+	// slug may be taken back
+	if _, err := m.db.Exec("DELETE FROM article_slug_history WHERE slug = $1;", a.Slug); err != nil {
+		return errors.Wrap(err, "could not save slug history")
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package article
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+)
+
+// Stats is an aggregated view of articles for the dashboard.
+type Stats struct {
+	Total    int          `json:"total"`
+	ByStatus []StatusStat `json:"by_status"`
+	ByDay    []DayStat    `json:"by_day"`
+	TopTags  []TagStat    `json:"top_tags"`
+}
+
+type StatusStat struct {
+	Status Status `json:"status"`
+	Count  int    `json:"count"`
+}
+
+// DayStat is a count of articles created on a calendar day, in time zone of the request.
+type DayStat struct {
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+}
+
+type TagStat struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// StatsQuery describes stats to compute.
+type StatsQuery struct {
+	// Location is a time zone days are counted in.
+	Location *time.Location
+	// Days is how many last days histogram covers, including today.
+	Days int
+	// Tags is how many top tags to return.
+	Tags int
+	// Now is the time days are counted back from, current time when zero.
+	Now time.Time
+}
+
+// Stats computes stats in database, every part is a single aggregate query.
+func (m *Manager) Stats(q StatsQuery) (*Stats, error) {
+	s := &Stats{ByStatus: []StatusStat{}, ByDay: []DayStat{}, TopTags: []TagStat{}}
+
+	rows, err := m.db.Query("SELECT status, count(*) FROM article GROUP BY status ORDER BY status;")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not count articles by status")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var st StatusStat
+		if err := rows.Scan(&st.Status, &st.Count); err != nil {
+			return nil, errors.Wrap(err, "could not scan status stats")
+		}
+		s.Total += st.Count
+		s.ByStatus = append(s.ByStatus, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not count articles by status")
+	}
+
+	now := q.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	now = now.In(q.Location)
+	since := time.Date(now.Year(), now.Month(), now.Day()-q.Days+1, 0, 0, 0, 0, q.Location)
+	rows, err = m.db.Query(
+		"SELECT to_char(created_at AT TIME ZONE $1, 'YYYY-MM-DD') AS day, count(*) FROM article WHERE created_at >= $2 GROUP BY day ORDER BY day;",
+		q.Location.String(), since,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not count articles by day")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d DayStat
+		if err := rows.Scan(&d.Day, &d.Count); err != nil {
+			return nil, errors.Wrap(err, "could not scan day stats")
+		}
+		s.ByDay = append(s.ByDay, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not count articles by day")
+	}
+
+	rows, err = m.db.Query(
+		"SELECT tag, count(*) AS n FROM article, unnest(tags) AS tag GROUP BY tag ORDER BY n DESC, tag LIMIT $1;",
+		q.Tags,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not count top tags")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t TagStat
+		if err := rows.Scan(&t.Tag, &t.Count); err != nil {
+			return nil, errors.Wrap(err, "could not scan tag stats")
+		}
+		s.TopTags = append(s.TopTags, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not count top tags")
+	}
+	return s, nil
+}
+
+// statsCache keeps computed stats per query for ttl, zero ttl disables caching.
+type statsCache struct {
+	ttl   time.Duration
+	clock clock.Clock
+
+	mu      sync.Mutex
+	entries map[statsKey]statsEntry
+}
+
+// statsKey identifies query, locations are compared by name as they are loaded per request.
+type statsKey struct {
+	tz         string
+	days, tags int
+}
+
+type statsEntry struct {
+	stats   *Stats
+	expires time.Time
+}
+
+func newStatsCache(ttl time.Duration) *statsCache {
+	return &statsCache{ttl: ttl, clock: clock.Real, entries: make(map[statsKey]statsEntry)}
+}
+
+func (c *statsCache) get(m *Manager, q StatsQuery) (*Stats, error) {
+	now := c.clock.Now()
+	q.Now = now
+	if c.ttl <= 0 {
+		return m.Stats(q)
+	}
+
+	key := statsKey{tz: q.Location.String(), days: q.Days, tags: q.Tags}
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.stats, nil
+	}
+
+	s, err := m.Stats(q)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = statsEntry{stats: s, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return s, nil
+}
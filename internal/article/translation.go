@@ -0,0 +1,135 @@
+package article
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/sanitize"
+)
+
+// Translation is a title and body of article in another language than the original.
+type Translation struct {
+	ArticleID string `json:"article_id"`
+	// Language is a lowercase language tag, e.g. ru or pt-br.
+	Language  string    `json:"language"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	Revision  int       `json:"revision"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// languageRe matches language tags of a language with optional region, script or variant subtags.
+var languageRe = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// ParseLanguage returns lowercase language tag, e.g. pt-BR is pt-br.
+func ParseLanguage(s string) (string, error) {
+	lang := strings.ToLower(s)
+	if !languageRe.MatchString(lang) {
+		return "", i18n.Errorf("article.invalid_lang", s)
+	}
+	return lang, nil
+}
+
+// SaveTranslation creates or replaces translation, it reports whether translation is created.
+func (m *Manager) SaveTranslation(t *Translation) (bool, error) {
+	var created bool
+	err := m.db.QueryRow(
+		`INSERT INTO article_translation(article_id, language, title, body) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (article_id, language) DO UPDATE SET title = EXCLUDED.title, body = EXCLUDED.body,
+			revision = article_translation.revision + 1, updated_at = now()
+		RETURNING revision, updated_at, xmax = 0;`,
+		t.ArticleID, t.Language, t.Title, t.Body,
+	).Scan(&t.Revision, &t.UpdatedAt, &created)
+	if err != nil {
+		return false, errors.Wrap(err, "could not save article translation")
+	}
+	return created, nil
+}
+
+// DeleteTranslation returns ErrNotFound when article has no translation to language.
+func (m *Manager) DeleteTranslation(articleID, lang string) error {
+	res, err := m.db.Exec("DELETE FROM article_translation WHERE article_id = $1 AND language = $2;", articleID, lang)
+	if err != nil {
+		return errors.Wrap(err, "could not delete article translation")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Translations returns translations of article ordered by language, langs limit them unless empty.
+func (m *Manager) Translations(articleID string, langs ...string) ([]*Translation, error) {
+	query := "SELECT article_id, language, title, body, revision, updated_at FROM article_translation WHERE article_id = $1"
+	args := []interface{}{articleID}
+	if len(langs) > 0 {
+		query += " AND language = ANY($2)"
+		args = append(args, pq.Array(langs))
+	}
+	rows, err := m.db.Query(query+" ORDER BY language;", args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get article translations")
+	}
+	defer rows.Close()
+
+	res := []*Translation{}
+	for rows.Next() {
+		var t Translation
+		if err := rows.Scan(&t.ArticleID, &t.Language, &t.Title, &t.Body, &t.Revision, &t.UpdatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan row to article translation model")
+		}
+		res = append(res, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get article translations")
+	}
+	return res, nil
+}
+
+// Localize replaces title and body of article with the first translation in langs, which are in order of
+// preference. Article is left as is when there is no translation or when original language comes first.
+func (m *Manager) Localize(a *Article, original string, langs []string) error {
+	var wanted []string
+	for _, lang := range langs {
+		if lang == original {
+			break
+		}
+		wanted = append(wanted, lang)
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+	translations, err := m.Translations(a.ID, wanted...)
+	if err != nil {
+		return err
+	}
+	for _, lang := range wanted {
+		for _, t := range translations {
+			if t.Language == lang {
+				a.Title, a.Body, a.Language, a.translation = t.Title, t.Body, t.Language, t.Revision
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+type translationRequest struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// validate sanitizes request with html policy like articleRequest and checks it.
+func (tr *translationRequest) validate(html sanitize.Policy) error {
+	tr.Title = sanitize.TextPolicy.Sanitize(tr.Title)
+	tr.Body = html.Sanitize(tr.Body)
+	if strings.TrimSpace(tr.Title) == "" {
+		return i18n.Errorf("article.title_required")
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package article
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+// ViewCounter accumulates article views in memory and flushes them to database in batches,
+// so reading an article doesn't cost a write. Views not flushed yet are lost on crash.
+type ViewCounter struct {
+	db       postgres.Querier
+	interval time.Duration
+	clock    clock.Clock
+
+	mu      sync.Mutex
+	pending map[string]int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewViewCounter(db postgres.Querier, interval time.Duration) *ViewCounter {
+	return &ViewCounter{
+		db:       db,
+		interval: interval,
+		clock:    clock.Real,
+		pending:  make(map[string]int64),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Inc counts a view of article.
+func (c *ViewCounter) Inc(id string) {
+	c.mu.Lock()
+	c.pending[id]++
+	c.mu.Unlock()
+}
+
+// Pending returns views of article which are not flushed yet.
+func (c *ViewCounter) Pending(id string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pending[id]
+}
+
+// Flush writes pending views with a single statement, they are kept for the next flush on failure.
+func (c *ViewCounter) Flush() error {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[string]int64, len(pending))
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(pending))
+	counts := make([]int64, 0, len(pending))
+	for id, n := range pending {
+		ids = append(ids, id)
+		counts = append(counts, n)
+	}
+	_, err := c.db.Exec(
+		"UPDATE article SET views = views + v.n FROM unnest($1::integer[], $2::bigint[]) AS v(id, n) WHERE article.id = v.id;",
+		pq.Array(ids), pq.Array(counts),
+	)
+	if err != nil {
+		c.mu.Lock()
+		for id, n := range pending {
+			c.pending[id] += n
+		}
+		c.mu.Unlock()
+		return errors.Wrap(err, "could not flush article views")
+	}
+	return nil
+}
+
+// Run flushes views every interval until Close is called.
+func (c *ViewCounter) Run(logger log.Logger) {
+	defer close(c.done)
+
+	ticker := c.clock.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := c.Flush(); err != nil {
+				logger.WithError(err).Error()
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Close stops Run and flushes the rest of views.
+func (c *ViewCounter) Close() error {
+	close(c.stop)
+	<-c.done
+	return c.Flush()
+}
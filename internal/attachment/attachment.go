@@ -0,0 +1,176 @@
+package attachment
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/ids"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+// Statuses of attachments, content is served only when it is clean. Attachments which scanner failed
+// to scan several times in a row are scan_failed and are not retried.
+const (
+	StatusPending    = "pending"
+	StatusClean      = "clean"
+	StatusInfected   = "infected"
+	StatusScanFailed = "scan_failed"
+)
+
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrChecksumMismatch = i18n.Errorf("attachment.checksum_mismatch")
+)
+
+type Attachment struct {
+	ID          string    `json:"id"`
+	ArticleID   string    `json:"article_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	MD5         string    `json:"md5"`
+	SHA256      string    `json:"sha256"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type Manager struct {
+	db      postgres.Querier
+	storage *Storage
+	// ids generate identifiers of resumable uploads, they are unguessable as upload URLs are not authorized
+	ids ids.Generator
+	// scan keeps new attachments pending until scanner checks them
+	scan bool
+
+	// dryRun leaves storage untouched, database changes are rolled back by Tx.
+	dryRun bool
+}
+
+func NewManager(db *sql.DB, storage *Storage) *Manager {
+	return &Manager{db: db, storage: storage, ids: ids.Random}
+}
+
+// Tx runs fn with manager bound to a transaction, which is rolled back when fn fails or on dry run.
+func (m *Manager) Tx(dryRun bool, fn func(m *Manager) error) error {
+	return postgres.Tx(m.db, dryRun, func(tx postgres.Querier) error {
+		return fn(&Manager{db: tx, storage: m.storage, ids: m.ids, scan: m.scan, dryRun: dryRun})
+	})
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db), storage: m.storage, ids: m.ids, scan: m.scan, dryRun: m.dryRun}
+}
+
+// Save stores content and fills generated fields of attachment.
+// Non-empty expected checksums are verified before content is stored, ErrChecksumMismatch is returned on failure.
+func (m *Manager) Save(a *Attachment, content io.Reader, expected Checksums) error {
+	tmp, size, sums, err := m.storage.Create(content)
+	if err != nil {
+		return err
+	}
+	if (expected.MD5 != "" && expected.MD5 != sums.MD5) || (expected.SHA256 != "" && expected.SHA256 != sums.SHA256) {
+		m.storage.Discard(tmp)
+		return ErrChecksumMismatch
+	}
+	a.Size, a.MD5, a.SHA256 = size, sums.MD5, sums.SHA256
+
+	if err := m.insert(a); err != nil {
+		m.storage.Discard(tmp)
+		return err
+	}
+	if m.dryRun {
+		m.storage.Discard(tmp)
+		return nil
+	}
+
+	if err := m.storage.Commit(tmp, a.ID); err != nil {
+		m.storage.Discard(tmp)
+		if _, derr := m.db.Exec("DELETE FROM attachment WHERE id = $1;", a.ID); derr != nil {
+			return errors.Wrap(derr, "could not rollback attachment")
+		}
+		return err
+	}
+	return nil
+}
+
+// insert saves metadata of attachment, which is pending when scanning is enabled.
+func (m *Manager) insert(a *Attachment) error {
+	a.Status = StatusClean
+	if m.scan {
+		a.Status = StatusPending
+	}
+	err := m.db.QueryRow(
+		"INSERT INTO attachment(article_id, filename, content_type, size, md5, sha256, status) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at;",
+		a.ArticleID, a.Filename, a.ContentType, a.Size, a.MD5, a.SHA256, a.Status,
+	).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return errors.Wrap(err, "could not save attachment")
+	}
+	return nil
+}
+
+func (m *Manager) Delete(a *Attachment) error {
+	_, err := m.db.Exec("DELETE FROM attachment WHERE id = $1;", a.ID)
+	if err != nil {
+		return errors.Wrap(err, "could not delete attachment")
+	}
+	if m.dryRun {
+		return nil
+	}
+	return m.storage.Remove(a.ID)
+}
+
+// Open returns attachment content, caller must close it.
+func (m *Manager) Open(a *Attachment) (*os.File, error) {
+	return m.storage.Open(a.ID)
+}
+
+func (m *Manager) ByID(id string) (*Attachment, error) {
+	rows, err := m.db.Query("SELECT id, article_id, filename, content_type, size, md5, sha256, status, created_at FROM attachment WHERE id = $1;", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get attachment by id")
+	}
+	defer rows.Close()
+
+	attachments, err := scanAll(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(attachments) == 0 {
+		return nil, ErrNotFound
+	}
+	return attachments[0], nil
+}
+
+func (m *Manager) ByArticleID(articleID string) ([]*Attachment, error) {
+	rows, err := m.db.Query("SELECT id, article_id, filename, content_type, size, md5, sha256, status, created_at FROM attachment WHERE article_id = $1 ORDER BY id;", articleID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get attachments by article id")
+	}
+	defer rows.Close()
+
+	return scanAll(rows)
+}
+
+func scanAll(rows *sql.Rows) ([]*Attachment, error) {
+	var attachments []*Attachment
+	for rows.Next() {
+		var a Attachment
+		err := rows.Scan(&a.ID, &a.ArticleID, &a.Filename, &a.ContentType, &a.Size, &a.MD5, &a.SHA256, &a.Status, &a.CreatedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not scan row to attachment model")
+		}
+		attachments = append(attachments, &a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get attachments")
+	}
+	return attachments, nil
+}
@@ -0,0 +1,115 @@
+package attachment
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/contract"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestContract(t *testing.T) {
+	created := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	row := func(rows *sqlmock.Rows) *sqlmock.Rows {
+		return rows.AddRow("7", "1", "book.txt", "text/plain", 11, helloMD5, helloSHA256, StatusClean, created)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		body    string
+		headers map[string]string
+		expect  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name: "list", method: http.MethodGet, url: "/1.0/attachments?article_id=1",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM attachment WHERE article_id = \\$1").WillReturnRows(row(sqlmock.NewRows(attachmentColumns)))
+			},
+		},
+		{
+			name: "list_article_required", method: http.MethodGet, url: "/1.0/attachments",
+		},
+		{
+			name: "upload", method: http.MethodPost, url: "/1.0/attachments?article_id=1&filename=book.txt",
+			body:    "hello world",
+			headers: map[string]string{"Content-Type": "text/plain", "X-Content-SHA256": helloSHA256},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("INSERT INTO attachment").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow("7", created))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "upload_checksum_mismatch", method: http.MethodPost, url: "/1.0/attachments?article_id=1&filename=book.txt",
+			body:    "hello world",
+			headers: map[string]string{"Content-Type": "text/plain", "X-Content-SHA256": strings.Repeat("0", 64)},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name: "download", method: http.MethodGet, url: "/1.0/attachments/7",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM attachment WHERE id = \\$1;").WillReturnRows(row(sqlmock.NewRows(attachmentColumns)))
+			},
+		},
+		{
+			name: "download_not_found", method: http.MethodGet, url: "/1.0/attachments/8",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM attachment WHERE id = \\$1;").WillReturnRows(sqlmock.NewRows(attachmentColumns))
+			},
+		},
+		{
+			name: "delete", method: http.MethodDelete, url: "/1.0/attachments/7",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM attachment WHERE id = \\$1;").WillReturnRows(row(sqlmock.NewRows(attachmentColumns)))
+				mock.ExpectBegin()
+				mock.ExpectExec("DELETE FROM attachment WHERE id = \\$1;").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+			storage, cleanup := newTestStorage(t)
+			defer cleanup()
+			if err := ioutil.WriteFile(filepath.Join(storage.dir, "7"), []byte("hello world"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+
+			r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+			r.Use(i18n.Middleware("en"))
+			r.Mount("/1.0/attachments", Routes(NewManager(db, storage), 1024, time.Hour))
+
+			req := httptest.NewRequest(tt.method, "http://example.com"+tt.url, strings.NewReader(tt.body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			contract.Check(t, r, "attachment/"+tt.name, req)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expections: %s", err)
+			}
+		})
+	}
+}
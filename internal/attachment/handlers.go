@@ -0,0 +1,253 @@
+package attachment
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// Routes serve attachments, large ones may be uploaded in parts with tus protocol at /uploads,
+// sessions which make no progress for uploadTTL expire.
+func Routes(m *Manager, maxSize int64, uploadTTL time.Duration) chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", makeHandler(m, listHandler))
+	r.Post("/", makeHandler(m, uploadHandler(maxSize)))
+	r.Mount("/uploads", uploadRoutes(m, maxSize, uploadTTL))
+
+	r.Route("/{attachmentID}", func(r chi.Router) {
+		r.Get("/", makeHandler(m, downloadHandler))
+		r.Delete("/", makeHandler(m, deleteHandler))
+	})
+
+	return r
+}
+
+type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m.WithContext(r.Context()), w, r)
+	}
+}
+
+func listHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "attachment")
+
+	articleID := r.URL.Query().Get("article_id")
+	if articleID == "" {
+		render.Render(w, r, handler.ErrBadRequest(i18n.Errorf("attachment.article_required")))
+		return
+	}
+	attachments, err := m.ByArticleID(articleID)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	if err := render.RenderList(w, r, newAttachmentListResponse(attachments)); err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+}
+
+// uploadHandler stores request body as is, metadata is passed in query and Content-Type header.
+// On dry run content is verified and discarded.
+func uploadHandler(maxSize int64) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "attachment")
+		dryRun := handler.DryRun(w, r)
+
+		q := r.URL.Query()
+		a := &Attachment{
+			ArticleID:   q.Get("article_id"),
+			Filename:    q.Get("filename"),
+			ContentType: r.Header.Get("Content-Type"),
+		}
+		if a.ArticleID == "" || a.Filename == "" {
+			render.Render(w, r, handler.ErrBadRequest(i18n.Errorf("attachment.params_required")))
+			return
+		}
+		if a.ContentType == "" {
+			a.ContentType = "application/octet-stream"
+		}
+		if r.ContentLength > maxSize {
+			render.Render(w, r, handler.ErrRequestEntityTooLarge(i18n.Errorf("attachment.too_large", maxSize)))
+			return
+		}
+
+		expected, err := expectedChecksums(r)
+		if err != nil {
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+
+		body := http.MaxBytesReader(w, r.Body, maxSize)
+		err = m.Tx(dryRun, func(m *Manager) error {
+			return m.Save(a, body, expected)
+		})
+		if err != nil {
+			if err == ErrChecksumMismatch {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrBadRequest(err))
+				return
+			}
+			// chunked body has no Content-Length to check upfront
+			if _, ok := errors.Cause(err).(*http.MaxBytesError); ok {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrRequestEntityTooLarge(i18n.Errorf("attachment.too_large", maxSize)))
+				return
+			}
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+
+		render.Status(r, http.StatusCreated)
+		render.Render(w, r, newAttachmentResponse(a))
+	}
+}
+
+// downloadHandler supports single and multipart Range requests and If-Range, so clients can resume downloads.
+// Content is served only when it is scanned and clean.
+func downloadHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "attachment")
+
+	a, err := m.ByID(chi.URLParam(r, "attachmentID"))
+	if err != nil {
+		if err == ErrNotFound {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	switch a.Status {
+	case StatusPending:
+		w.Header().Set("Retry-After", "10")
+		render.Render(w, r, handler.ErrConflict(i18n.Errorf("attachment.pending")))
+		return
+	case StatusInfected:
+		render.Render(w, r, handler.ErrForbidden(i18n.Errorf("attachment.infected")))
+		return
+	case StatusScanFailed:
+		render.Render(w, r, handler.ErrForbidden(i18n.Errorf("attachment.scan_failed")))
+		return
+	}
+
+	f, err := m.Open(a)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", a.ContentType)
+	setIntegrityHeaders(w, a)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	http.ServeContent(w, r, a.Filename, a.CreatedAt, f)
+}
+
+// expectedChecksums reads Content-MD5 (base64) and X-Content-SHA256 (hex) headers.
+func expectedChecksums(r *http.Request) (Checksums, error) {
+	var sums Checksums
+	if v := r.Header.Get("Content-MD5"); v != "" {
+		sum, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(sum) != md5.Size {
+			return sums, i18n.Errorf("attachment.invalid_header", "Content-MD5")
+		}
+		sums.MD5 = hex.EncodeToString(sum)
+	}
+	if v := r.Header.Get("X-Content-SHA256"); v != "" {
+		sum, err := hex.DecodeString(v)
+		if err != nil || len(sum) != sha256.Size {
+			return sums, i18n.Errorf("attachment.invalid_header", "X-Content-SHA256")
+		}
+		sums.SHA256 = hex.EncodeToString(sum)
+	}
+	return sums, nil
+}
+
+// setIntegrityHeaders sets strong ETag and RFC 3230 Digest of the whole content,
+// attachments uploaded before checksums were introduced have none.
+func setIntegrityHeaders(w http.ResponseWriter, a *Attachment) {
+	var digests []string
+	if sum, err := hex.DecodeString(a.MD5); err == nil && len(sum) == md5.Size {
+		digests = append(digests, "MD5="+base64.StdEncoding.EncodeToString(sum))
+	}
+	if sum, err := hex.DecodeString(a.SHA256); err == nil && len(sum) == sha256.Size {
+		digests = append(digests, "SHA-256="+base64.StdEncoding.EncodeToString(sum))
+		w.Header().Set("ETag", `"`+a.SHA256+`"`)
+	}
+	if len(digests) > 0 {
+		w.Header().Set("Digest", strings.Join(digests, ", "))
+	}
+}
+
+// deleteHandler on dry run renders attachment which would be deleted.
+func deleteHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "attachment")
+	dryRun := handler.DryRun(w, r)
+
+	a, err := m.ByID(chi.URLParam(r, "attachmentID"))
+	if err != nil {
+		if err == ErrNotFound {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	err = m.Tx(dryRun, func(m *Manager) error {
+		return m.Delete(a)
+	})
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	if dryRun {
+		render.Render(w, r, newAttachmentResponse(a))
+		return
+	}
+	render.NoContent(w, r)
+}
+
+func newAttachmentListResponse(attachments []*Attachment) []render.Renderer {
+	list := []render.Renderer{}
+	for _, a := range attachments {
+		list = append(list, newAttachmentResponse(a))
+	}
+	return list
+}
+
+func newAttachmentResponse(a *Attachment) *attachmentResponse {
+	return &attachmentResponse{a}
+}
+
+type attachmentResponse struct {
+	*Attachment
+}
+
+func (ar *attachmentResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,565 @@
+package attachment
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/ids"
+	"github.com/agalitsyn/goapi/pkg/log"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var attachmentColumns = []string{"id", "article_id", "filename", "content_type", "size", "md5", "sha256", "status", "created_at"}
+
+const (
+	helloMD5    = "5eb63bbbe01eeed093cb22bb8f5acdc3"
+	helloSHA256 = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+)
+
+func newTestStorage(t *testing.T) (*Storage, func()) {
+	dir, err := ioutil.TempDir("", "goapi-attachments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewStorage(dir), func() { os.RemoveAll(dir) }
+}
+
+func TestUploadHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO attachment").
+		WithArgs("1", "book.txt", "text/plain", 11, helloMD5, helloSHA256, StatusClean).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow("7", time.Now()))
+	mock.ExpectCommit()
+
+	m := NewManager(db, storage)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/?article_id=1&filename=book.txt", bytes.NewBufferString("hello world"))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Content-SHA256", helloSHA256)
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Post("/", makeHandler(m, uploadHandler(1024)))
+	r.ServeHTTP(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("unexpected status: %v", resp.StatusCode)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(storage.dir, "7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello world" {
+		t.Errorf("unexpected content: %v", string(content))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestDownloadHandler_Range(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(filepath.Join(storage.dir, "7"), []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(db, storage)
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/{attachmentID}", makeHandler(m, downloadHandler))
+
+	tests := []struct {
+		rangeHeader string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"", http.StatusOK, "text/plain", "hello world"},
+		{"bytes=6-", http.StatusPartialContent, "text/plain", "world"},
+		{"bytes=0-1,6-7", http.StatusPartialContent, "multipart/byteranges", ""},
+		{"bytes=20-30", http.StatusRequestedRangeNotSatisfiable, "", ""},
+	}
+	for _, tt := range tests {
+		mock.ExpectQuery("SELECT (.+) FROM attachment WHERE id = \\$1;").
+			WithArgs("7").
+			WillReturnRows(sqlmock.NewRows(attachmentColumns).
+				AddRow("7", "1", "book.txt", "text/plain", 11, helloMD5, helloSHA256, StatusClean, time.Now()))
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/7", nil)
+		if tt.rangeHeader != "" {
+			req.Header.Set("Range", tt.rangeHeader)
+		}
+		r.ServeHTTP(w, req)
+
+		resp := w.Result()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%q: unexpected status: %v", tt.rangeHeader, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, tt.contentType) {
+			t.Errorf("%q: unexpected content type: %v", tt.rangeHeader, ct)
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%q: unexpected body: %v", tt.rangeHeader, w.Body.String())
+		}
+		if etag := resp.Header.Get("ETag"); etag != `"`+helloSHA256+`"` {
+			t.Errorf("%q: unexpected etag: %v", tt.rangeHeader, etag)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestUploadHandler_ChecksumMismatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	m := NewManager(db, storage)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/?article_id=1&filename=book.txt", bytes.NewBufferString("hello world!"))
+	req.Header.Set("Content-MD5", "XrY7u+Ae7tCTyyK7j1rNww==")
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Post("/", makeHandler(m, uploadHandler(1024)))
+	r.ServeHTTP(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status: %v", resp.StatusCode)
+	}
+
+	files, _ := ioutil.ReadDir(storage.dir)
+	if len(files) != 0 {
+		t.Errorf("expected no stored files, got %v", len(files))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestUploadHandler_TooLarge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	m := NewManager(db, storage)
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Post("/", makeHandler(m, uploadHandler(8)))
+
+	// chunked body is cut by reader, the rest by Content-Length
+	for _, contentLength := range []int64{-1, 12} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/?article_id=1&filename=book.txt", bytes.NewBufferString("hello world!"))
+		req.ContentLength = contentLength
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("unexpected status of content length %d: %v", contentLength, w.Code)
+		}
+	}
+
+	files, _ := ioutil.ReadDir(storage.dir)
+	if len(files) != 0 {
+		t.Errorf("expected no stored files, got %v", len(files))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestUploadHandler_DryRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO attachment").
+		WithArgs("1", "book.txt", "text/plain", 11, helloMD5, helloSHA256, StatusClean).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow("7", time.Now()))
+	mock.ExpectRollback()
+
+	m := NewManager(db, storage)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/?article_id=1&filename=book.txt&dry_run=true", bytes.NewBufferString("hello world"))
+	req.Header.Set("Content-Type", "text/plain")
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Post("/", makeHandler(m, uploadHandler(1024)))
+	r.ServeHTTP(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("unexpected status: %v", resp.StatusCode)
+	}
+	if resp.Header.Get(handler.DryRunHeader) != "true" {
+		t.Errorf("expected dry run header")
+	}
+
+	files, _ := ioutil.ReadDir(storage.dir)
+	if len(files) != 0 {
+		t.Errorf("expected no stored files, got %v", len(files))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestUploadRoutes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	m := NewManager(db, storage)
+	m.ids = &ids.Sequence{Prefix: "up"}
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Mount("/uploads", uploadRoutes(m, 1024, time.Hour))
+
+	tus := func(method, url, offset, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com"+url, strings.NewReader(body))
+		req.Header.Set(HeaderTusResumable, TusVersion)
+		if offset != "" {
+			req.Header.Set(HeaderUploadOffset, offset)
+			req.Header.Set("Content-Type", offsetContentType)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	uploadColumns := []string{"id", "article_id", "filename", "content_type", "length", "received", "attachment_id", "expires_at", "created_at"}
+	expires := time.Now().Add(time.Hour)
+
+	mock.ExpectQuery("INSERT INTO attachment_upload").
+		WithArgs("up1", "1", "book.txt", "text/plain", 11, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/uploads", nil)
+	req.Header.Set(HeaderTusResumable, TusVersion)
+	req.Header.Set(HeaderUploadLength, "11")
+	req.Header.Set(HeaderUploadMeta, "article_id MQ==,filename Ym9vay50eHQ=,content_type dGV4dC9wbGFpbg==")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/uploads/up1" {
+		t.Errorf("unexpected response: %v %v", w.Code, w.Header())
+	}
+
+	lock := func(received int) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM attachment_upload WHERE id = \\$1 AND expires_at > now\\(\\) FOR UPDATE;").
+			WithArgs("up1").
+			WillReturnRows(sqlmock.NewRows(uploadColumns).AddRow("up1", "1", "book.txt", "text/plain", 11, received, nil, expires, time.Now()))
+		mock.ExpectCommit()
+	}
+
+	// offset is checked and content is streamed outside of transaction which records it
+	lock(0)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE attachment_upload SET received = \\$3, expires_at = \\$4 WHERE id = \\$1 AND received = \\$2 (.+);").
+		WithArgs("up1", 0, 6, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	w = tus(http.MethodPatch, "/uploads/up1", "0", "hello ")
+	if w.Code != http.StatusNoContent || w.Header().Get(HeaderUploadOffset) != "6" {
+		t.Errorf("unexpected response: %v %v", w.Code, w.Header())
+	}
+
+	// client resumes from offset it did not know is already received
+	lock(6)
+	w = tus(http.MethodPatch, "/uploads/up1", "0", "hello ")
+	if w.Code != http.StatusConflict {
+		t.Errorf("unexpected status of offset mismatch: %v", w.Code)
+	}
+
+	// concurrent write at the same offset is recorded first
+	lock(6)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE attachment_upload SET received = \\$3").
+		WithArgs("up1", 6, 11, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectQuery("SELECT (.+) FROM attachment_upload WHERE id = \\$1 AND expires_at > now\\(\\);").
+		WithArgs("up1").
+		WillReturnRows(sqlmock.NewRows(uploadColumns).AddRow("up1", "1", "book.txt", "text/plain", 11, 11, "7", expires, time.Now()))
+	w = tus(http.MethodPatch, "/uploads/up1", "6", "wrong")
+	if w.Code != http.StatusConflict {
+		t.Errorf("unexpected status of concurrent write: %v", w.Code)
+	}
+	content, err := ioutil.ReadFile(storage.PartPath("up1"))
+	if err != nil || string(content) != "hello " {
+		t.Errorf("content of concurrent write is appended: %q %v", content, err)
+	}
+
+	lock(6)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE attachment_upload SET received = \\$3").
+		WithArgs("up1", 6, 11, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO attachment").
+		WithArgs("1", "book.txt", "text/plain", 11, helloMD5, helloSHA256, StatusClean).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow("7", time.Now()))
+	mock.ExpectExec("UPDATE attachment_upload SET attachment_id = \\$2 WHERE id = \\$1;").
+		WithArgs("up1", "7").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	w = tus(http.MethodPatch, "/uploads/up1", "6", "world")
+	if w.Code != http.StatusNoContent || w.Header().Get(HeaderAttachmentID) != "7" {
+		t.Errorf("unexpected response of the last part: %v %v", w.Code, w.Header())
+	}
+	content, err = ioutil.ReadFile(filepath.Join(storage.dir, "7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello world" {
+		t.Errorf("unexpected content: %v", string(content))
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM attachment_upload WHERE id = \\$1 AND expires_at > now\\(\\);").
+		WithArgs("up2").
+		WillReturnRows(sqlmock.NewRows(uploadColumns))
+	w = tus(http.MethodHead, "/uploads/up2", "", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status of expired upload: %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "http://example.com/uploads/up1", nil))
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get(HeaderTusVersion) != TusVersion {
+		t.Errorf("unexpected response without Tus-Resumable: %v %v", w.Code, w.Header())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestSweeper_RunPending(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	if _, err := storage.Append("up1", 0, strings.NewReader("hello"), 5); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := NewSweeper(NewManager(db, storage), time.Minute)
+	s.clock = clock.NewFake(now)
+
+	mock.ExpectQuery("DELETE FROM attachment_upload WHERE expires_at <= \\$1 RETURNING id;").
+		WithArgs(now).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("up1"))
+	if err := s.RunPending(log.New("", "", ioutil.Discard)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(storage.PartPath("up1")); !os.IsNotExist(err) {
+		t.Errorf("content of expired upload is kept: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+type testScanner map[string]string
+
+func (s testScanner) Scan(ctx context.Context, content io.Reader) (string, error) {
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return "", err
+	}
+	return s[string(b)], nil
+}
+
+func TestScanWorker_RunPending(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	for id, content := range map[string]string{"7": "hello world", "8": "X5O!P%@AP"} {
+		if err := ioutil.WriteFile(filepath.Join(storage.dir, id), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := NewManager(db, storage)
+	wk := NewScanWorker(m, testScanner{"X5O!P%@AP": "Eicar-Signature"}, "clamd://localhost:3310", time.Second, time.Second, ScanRetry{Attempts: 3, Backoff: time.Minute})
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	wk.clock = clock.NewFake(now)
+
+	mock.ExpectQuery("SELECT (.+) FROM attachment WHERE status = \\$1 AND \\(scan_next_at IS NULL OR scan_next_at <= \\$3\\) ORDER BY id LIMIT \\$2;").
+		WithArgs(StatusPending, 10, now).
+		WillReturnRows(sqlmock.NewRows(attachmentColumns).
+			AddRow("5", "1", "lost.txt", "text/plain", 4, "", "", StatusPending, time.Now()).
+			AddRow("6", "1", "lost.txt", "text/plain", 4, "", "", StatusPending, time.Now()).
+			AddRow("7", "1", "book.txt", "text/plain", 11, helloMD5, helloSHA256, StatusPending, time.Now()).
+			AddRow("8", "1", "eicar.com", "text/plain", 9, "", "", StatusPending, time.Now()))
+	// content of 5 and 6 is missing, they fail to scan without holding others up
+	mock.ExpectQuery("UPDATE attachment SET scan_attempts = scan_attempts \\+ 1 WHERE id = \\$1 RETURNING scan_attempts;").
+		WithArgs("5").
+		WillReturnRows(sqlmock.NewRows([]string{"scan_attempts"}).AddRow(3))
+	mock.ExpectExec("UPDATE attachment SET status = \\$2 WHERE id = \\$1;").
+		WithArgs("5", StatusScanFailed).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("UPDATE attachment SET scan_attempts = scan_attempts \\+ 1 WHERE id = \\$1 RETURNING scan_attempts;").
+		WithArgs("6").
+		WillReturnRows(sqlmock.NewRows([]string{"scan_attempts"}).AddRow(2))
+	mock.ExpectExec("UPDATE attachment SET scan_next_at = \\$2 WHERE id = \\$1;").
+		WithArgs("6", now.Add(2*time.Minute)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE attachment SET status = \\$2 WHERE id = \\$1;").
+		WithArgs("7", StatusClean).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO attachment_scan").
+		WithArgs("7", helloSHA256, "clamd://localhost:3310", StatusClean, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE attachment SET status = \\$2 WHERE id = \\$1;").
+		WithArgs("8", StatusInfected).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO attachment_scan").
+		WithArgs("8", "", "clamd://localhost:3310", StatusInfected, "Eicar-Signature").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	if err := wk.RunPending(log.New("", "", ioutil.Discard)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(storage.dir, "8")); !os.IsNotExist(err) {
+		t.Errorf("infected content is not quarantined: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(storage.dir, ".quarantine", "8")); err != nil {
+		t.Errorf("quarantined content is not kept: %v", err)
+	}
+
+	// content is served only when it is clean
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Get("/{attachmentID}", makeHandler(m, downloadHandler))
+	for status, code := range map[string]int{StatusPending: http.StatusConflict, StatusInfected: http.StatusForbidden, StatusScanFailed: http.StatusForbidden} {
+		mock.ExpectQuery("SELECT (.+) FROM attachment WHERE id = \\$1;").
+			WithArgs("8").
+			WillReturnRows(sqlmock.NewRows(attachmentColumns).AddRow("8", "1", "eicar.com", "text/plain", 9, "", "", status, time.Now()))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/8", nil))
+		if w.Code != code {
+			t.Errorf("%s: unexpected status: %v", status, w.Code)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestClamdScanner(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// clamd stub finds the EICAR signature in streamed chunks
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			r.ReadString(0)
+			var content []byte
+			for {
+				var size uint32
+				if err := binary.Read(r, binary.BigEndian, &size); err != nil || size == 0 {
+					break
+				}
+				chunk := make([]byte, size)
+				io.ReadFull(r, chunk)
+				content = append(content, chunk...)
+			}
+			if bytes.Contains(content, []byte("EICAR")) {
+				conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+			} else {
+				conn.Write([]byte("stream: OK\x00"))
+			}
+			conn.Close()
+		}
+	}()
+
+	s, err := NewScanner("clamd://"+l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for content, expected := range map[string]string{"hello world": "", "X5O!P%@AP EICAR": "Eicar-Signature"} {
+		threat, err := s.Scan(context.Background(), strings.NewReader(content))
+		if err != nil || threat != expected {
+			t.Errorf("%s: unexpected result: %q %v", content, threat, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package attachment
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"attachment.article_required":  "article_id is required",
+		"attachment.params_required":   "article_id and filename are required",
+		"attachment.too_large":         "attachment is larger than %d bytes",
+		"attachment.invalid_header":    "invalid %s header",
+		"attachment.checksum_mismatch": "checksum mismatch",
+		"attachment.tus_version":       "Tus-Resumable header must be %s",
+		"attachment.content_type":      "Content-Type must be %s",
+		"attachment.offset_mismatch":   "upload is at offset %d, resume from there",
+		"attachment.pending":           "attachment is not scanned for viruses yet, retry later",
+		"attachment.infected":          "attachment is infected and quarantined",
+		"attachment.scan_failed":       "attachment could not be scanned for viruses and is not served",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"attachment.article_required":  "необходимо указать article_id",
+		"attachment.params_required":   "необходимо указать article_id и filename",
+		"attachment.too_large":         "вложение больше %d байт",
+		"attachment.invalid_header":    "некорректный заголовок %s",
+		"attachment.checksum_mismatch": "контрольная сумма не совпадает",
+		"attachment.tus_version":       "заголовок Tus-Resumable должен быть %s",
+		"attachment.content_type":      "Content-Type должен быть %s",
+		"attachment.offset_mismatch":   "загрузка на смещении %d, продолжите с него",
+		"attachment.pending":           "вложение ещё не проверено на вирусы, повторите позже",
+		"attachment.infected":          "вложение заражено и помещено в карантин",
+		"attachment.scan_failed":       "вложение не удалось проверить на вирусы, оно не выдаётся",
+	})
+}
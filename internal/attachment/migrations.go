@@ -0,0 +1,77 @@
+package attachment
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "0002_attachment_initial",
+			Up: []string{
+				`CREATE TABLE attachment (
+					id              SERIAL                      NOT NULL,
+					article_id      integer                     NOT NULL REFERENCES article(id) ON DELETE CASCADE,
+					filename        character varying(256)      NOT NULL,
+					content_type    character varying(128)      NOT NULL,
+					size            bigint                      NOT NULL,
+					created_at      timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id)
+				);`,
+				`CREATE INDEX attachment_article_id_idx ON attachment (article_id);`,
+			},
+		},
+		{
+			Id: "0003_attachment_checksum",
+			Up: []string{
+				`ALTER TABLE attachment
+					ADD COLUMN md5      character(32)   NOT NULL DEFAULT '',
+					ADD COLUMN sha256   character(64)   NOT NULL DEFAULT '';`,
+			},
+		},
+		{
+			// article is not referenced, so content of sessions is removed by expiry even when article is deleted
+			Id: "0024_attachment_upload",
+			Up: []string{
+				`CREATE TABLE attachment_upload (
+					id              character(32)               NOT NULL,
+					article_id      integer                     NOT NULL,
+					filename        character varying(256)      NOT NULL,
+					content_type    character varying(128)      NOT NULL,
+					length          bigint                      NOT NULL,
+					received        bigint                      NOT NULL DEFAULT 0,
+					attachment_id   integer                     REFERENCES attachment(id) ON DELETE SET NULL,
+					expires_at      timestamp with time zone    NOT NULL,
+					created_at      timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id)
+				);`,
+				`CREATE INDEX attachment_upload_expires_at_idx ON attachment_upload (expires_at);`,
+			},
+		},
+		{
+			// existing attachments are considered clean, scans are audited even after attachment is deleted
+			Id: "0025_attachment_scan",
+			Up: []string{
+				`ALTER TABLE attachment ADD COLUMN status character varying(16) NOT NULL DEFAULT 'clean';`,
+				`CREATE INDEX attachment_pending_idx ON attachment (id) WHERE status = 'pending';`,
+				`CREATE TABLE attachment_scan (
+					id              SERIAL                      NOT NULL,
+					attachment_id   integer                     NOT NULL,
+					sha256          character(64)               NOT NULL,
+					scanner         character varying(256)      NOT NULL,
+					status          character varying(16)       NOT NULL,
+					threat          character varying(256)      NOT NULL DEFAULT '',
+					scanned_at      timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id)
+				);`,
+				`CREATE INDEX attachment_scan_attachment_id_idx ON attachment_scan (attachment_id);`,
+			},
+		},
+		{
+			Id: "0039_attachment_scan_retry",
+			Up: []string{
+				`ALTER TABLE attachment
+					ADD COLUMN scan_attempts    integer                     NOT NULL DEFAULT 0,
+					ADD COLUMN scan_next_at     timestamp with time zone;`,
+			},
+		},
+	}
+}
@@ -0,0 +1,131 @@
+package attachment
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/internal/article"
+	"github.com/agalitsyn/goapi/pkg/module"
+)
+
+func init() {
+	module.Register(&attachmentModule{})
+}
+
+type attachmentModule struct {
+	module.Base
+
+	opts struct {
+		Path    string `long:"attachments-path" env:"GAPI_ATTACHMENTS_PATH" default:"attachments" description:"Path to attachments storage folder."`
+		MaxSize int64  `long:"attachments-max-size" env:"GAPI_ATTACHMENTS_MAX_SIZE" default:"104857600" description:"Max size of uploaded attachment in bytes."`
+
+		UploadTTL      time.Duration `long:"attachments-upload-ttl" env:"GAPI_ATTACHMENTS_UPLOAD_TTL" default:"24h" description:"How long a resumable upload may make no progress before it expires and its content is removed."`
+		UploadInterval time.Duration `long:"attachments-upload-interval" env:"GAPI_ATTACHMENTS_UPLOAD_INTERVAL" default:"10m" description:"How often to remove expired resumable uploads."`
+
+		Scanner      string        `long:"attachments-scanner" env:"GAPI_ATTACHMENTS_SCANNER" description:"Virus scanner of uploaded attachments, clamd://host:3310 or unix:///path/to/clamd.sock for ClamAV daemon or http(s) URL of external API. Attachments are served only when scanned clean. Disabled if empty."`
+		ScanInterval time.Duration `long:"attachments-scan-interval" env:"GAPI_ATTACHMENTS_SCAN_INTERVAL" default:"10s" description:"How often to scan pending attachments."`
+		ScanTimeout  time.Duration `long:"attachments-scan-timeout" env:"GAPI_ATTACHMENTS_SCAN_TIMEOUT" default:"1m" description:"How long a scan of attachment may take."`
+		ScanAttempts int           `long:"attachments-scan-attempts" env:"GAPI_ATTACHMENTS_SCAN_ATTEMPTS" default:"5" description:"How many times to try scan of attachment before it is given up as scan_failed and never served."`
+		ScanBackoff  time.Duration `long:"attachments-scan-backoff" env:"GAPI_ATTACHMENTS_SCAN_BACKOFF" default:"1m" description:"Delay before retrying failed scan of attachment, it doubles with every attempt."`
+	}
+
+	manager *Manager
+	scanner Scanner
+}
+
+func (mod *attachmentModule) Name() string                     { return "attachments" }
+func (mod *attachmentModule) Options() interface{}             { return &mod.opts }
+func (mod *attachmentModule) Migrations() []*migrate.Migration { return Migrations() }
+
+// Init provides attachments relation of articles.
+func (mod *attachmentModule) Init(env *module.Env) error {
+	if err := os.MkdirAll(mod.opts.Path, 0755); err != nil {
+		return errors.Wrap(err, "could not create storage folder")
+	}
+	if mod.opts.UploadInterval <= 0 {
+		return errors.New("--attachments-upload-interval must be positive")
+	}
+	mod.manager = NewManager(env.DB, NewStorage(mod.opts.Path))
+	if mod.opts.Scanner != "" {
+		if mod.opts.ScanAttempts < 1 {
+			return errors.New("attachments scan attempts must be positive")
+		}
+		if mod.opts.ScanInterval <= 0 {
+			return errors.New("--attachments-scan-interval must be positive")
+		}
+		scanner, err := NewScanner(mod.opts.Scanner, mod.opts.ScanTimeout)
+		if err != nil {
+			return err
+		}
+		mod.scanner = scanner
+		mod.manager.scan = true
+	}
+	env.Provide(article.RelationPrefix+"attachments", articleRelation(mod.manager))
+	return nil
+}
+
+func (mod *attachmentModule) Routes() map[string]http.Handler {
+	return map[string]http.Handler{"/attachments": Routes(mod.manager, mod.opts.MaxSize, mod.opts.UploadTTL)}
+}
+
+// SingletonJobs run sweeper of expired uploads and virus scanner on a single replica.
+func (mod *attachmentModule) SingletonJobs() []func() module.Job {
+	jobs := []func() module.Job{func() module.Job {
+		return NewSweeper(mod.manager, mod.opts.UploadInterval)
+	}}
+	if mod.scanner != nil {
+		jobs = append(jobs, func() module.Job {
+			return NewScanWorker(mod.manager, mod.scanner, scannerName(mod.opts.Scanner), mod.opts.ScanInterval, mod.opts.ScanTimeout,
+				ScanRetry{Attempts: mod.opts.ScanAttempts, Backoff: mod.opts.ScanBackoff})
+		})
+	}
+	return jobs
+}
+
+// scannerName is URL of scanner without credentials and query, which may keep API keys.
+func scannerName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	u.User, u.RawQuery = nil, ""
+	return u.String()
+}
+
+// HealthChecks fail when storage folder is gone, e.g. volume is not mounted.
+func (mod *attachmentModule) HealthChecks() map[string]func() error {
+	return map[string]func() error{
+		"storage": func() error {
+			fi, err := os.Stat(mod.opts.Path)
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() {
+				return errors.Errorf("%s is not a directory", mod.opts.Path)
+			}
+			return nil
+		},
+	}
+}
+
+// articleRelation embeds attachments of article with ?expand=attachments.
+func articleRelation(m *Manager) article.Relation {
+	return func(a *article.Article, limit int) (interface{}, error) {
+		attachments, err := m.ByArticleID(a.ID)
+		if err != nil {
+			return nil, err
+		}
+		if attachments == nil {
+			attachments = []*Attachment{}
+		}
+		if len(attachments) > limit {
+			attachments = attachments[:limit]
+		}
+		return attachments, nil
+	}
+}
@@ -0,0 +1,203 @@
+package attachment
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// pending returns the oldest attachments waiting to be scanned, ones which failed to scan wait
+// until their retry is due.
+func (m *Manager) pending(now time.Time, limit int) ([]*Attachment, error) {
+	rows, err := m.db.Query(
+		"SELECT id, article_id, filename, content_type, size, md5, sha256, status, created_at FROM attachment WHERE status = $1 AND (scan_next_at IS NULL OR scan_next_at <= $3) ORDER BY id LIMIT $2;",
+		StatusPending, limit, now,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get pending attachments")
+	}
+	defer rows.Close()
+
+	return scanAll(rows)
+}
+
+// recordScan sets status of attachment by scan result and keeps audit record of the scan,
+// infected content is quarantined. It must run in Tx, so status is not changed when quarantine fails.
+func (m *Manager) recordScan(a *Attachment, scanner, threat string) error {
+	a.Status = StatusClean
+	if threat != "" {
+		a.Status = StatusInfected
+	}
+	if _, err := m.db.Exec("UPDATE attachment SET status = $2 WHERE id = $1;", a.ID, a.Status); err != nil {
+		return errors.Wrap(err, "could not update status of attachment")
+	}
+	_, err := m.db.Exec(
+		"INSERT INTO attachment_scan(attachment_id, sha256, scanner, status, threat) VALUES ($1, $2, $3, $4, $5);",
+		a.ID, a.SHA256, scanner, a.Status, threat,
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not record scan of attachment")
+	}
+	if threat != "" {
+		return m.storage.Quarantine(a.ID)
+	}
+	return nil
+}
+
+// failScan counts failed scan of attachment and returns how many scans of it have failed.
+func (m *Manager) failScan(id string) (int, error) {
+	var attempts int
+	err := m.db.QueryRow("UPDATE attachment SET scan_attempts = scan_attempts + 1 WHERE id = $1 RETURNING scan_attempts;", id).Scan(&attempts)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not count failed scan of attachment")
+	}
+	return attempts, nil
+}
+
+// retryScan schedules scan of attachment at next, or gives it up when next is nil, so attachment is
+// scan_failed.
+func (m *Manager) retryScan(id string, next *time.Time) error {
+	var err error
+	if next == nil {
+		_, err = m.db.Exec("UPDATE attachment SET status = $2 WHERE id = $1;", id, StatusScanFailed)
+	} else {
+		_, err = m.db.Exec("UPDATE attachment SET scan_next_at = $2 WHERE id = $1;", id, *next)
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not reschedule scan of attachment")
+	}
+	return nil
+}
+
+// ScanRetry is how many times scan of attachment is tried, e.g. while scanner is down, and how long
+// to wait before retrying it, the delay doubles with every attempt.
+type ScanRetry struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+// ScanWorker scans pending attachments, it runs on a single replica. Attachments which can not be
+// scanned stay pending and are retried with backoff, until they are scan_failed after several attempts.
+// Failure of one attachment does not hold others up.
+type ScanWorker struct {
+	m       *Manager
+	scanner Scanner
+	// name of scanner in audit records, e.g. clamd
+	name     string
+	interval time.Duration
+	timeout  time.Duration
+	retry    ScanRetry
+	batch    int
+	clock    clock.Clock
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewScanWorker(m *Manager, scanner Scanner, name string, interval, timeout time.Duration, retry ScanRetry) *ScanWorker {
+	return &ScanWorker{
+		m:        m,
+		scanner:  scanner,
+		name:     name,
+		interval: interval,
+		timeout:  timeout,
+		retry:    retry,
+		batch:    10,
+		clock:    clock.Real,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// RunPending scans a batch of pending attachments which are due, failures are logged and the rest
+// of the batch is scanned anyway.
+func (wk *ScanWorker) RunPending(logger log.Logger) error {
+	attachments, err := wk.m.pending(wk.clock.Now(), wk.batch)
+	if err != nil {
+		return err
+	}
+	logger = logger.WithField("context", "attachment")
+	for _, a := range attachments {
+		select {
+		case <-wk.stop:
+			return nil
+		default:
+		}
+
+		logger := logger.WithField("attachment", a.ID)
+		threat, err := wk.scan(a)
+		if err != nil {
+			if err := wk.fail(logger, a, err); err != nil {
+				logger.WithError(err).Error()
+			}
+			continue
+		}
+		err = wk.m.Tx(false, func(m *Manager) error {
+			return m.recordScan(a, wk.name, threat)
+		})
+		if err != nil {
+			logger.WithError(err).Error()
+			continue
+		}
+		if threat != "" {
+			logger.Warnf("attachment is infected with %s and quarantined", threat)
+		}
+	}
+	return nil
+}
+
+// fail retries scan of attachment later, or gives it up after the last attempt.
+func (wk *ScanWorker) fail(logger log.Logger, a *Attachment, scanErr error) error {
+	attempts, err := wk.m.failScan(a.ID)
+	if err != nil {
+		return err
+	}
+	if attempts >= wk.retry.Attempts {
+		logger.WithError(scanErr).Errorf("scan is given up after %d attempts", attempts)
+		return wk.m.retryScan(a.ID, nil)
+	}
+	next := wk.clock.Now().Add(wk.retry.Backoff << uint(attempts-1))
+	logger.WithError(scanErr).Warnf("scan is retried at %s", next.Format(time.RFC3339))
+	return wk.m.retryScan(a.ID, &next)
+}
+
+func (wk *ScanWorker) scan(a *Attachment) (string, error) {
+	f, err := wk.m.Open(a)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), wk.timeout)
+	defer cancel()
+	return wk.scanner.Scan(ctx, f)
+}
+
+// Run scans pending attachments every interval until Close is called.
+func (wk *ScanWorker) Run(logger log.Logger) {
+	defer close(wk.done)
+
+	ticker := wk.clock.NewTicker(wk.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := wk.RunPending(logger); err != nil {
+				logger.WithError(err).Error()
+			}
+		case <-wk.stop:
+			return
+		}
+	}
+}
+
+// Close stops Run, scan in progress is finished first.
+func (wk *ScanWorker) Close() error {
+	close(wk.stop)
+	<-wk.done
+	return nil
+}
@@ -0,0 +1,141 @@
+package attachment
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Scanner checks content of attachments for malware.
+type Scanner interface {
+	// Scan returns name of found threat, it is empty for clean content.
+	Scan(ctx context.Context, content io.Reader) (threat string, err error)
+}
+
+// NewScanner returns scanner by URL: clamd://host:port or unix:///path/to/clamd.sock for ClamAV daemon,
+// http(s):// for external API.
+func NewScanner(rawURL string, timeout time.Duration) (Scanner, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse scanner url")
+	}
+	switch u.Scheme {
+	case "clamd":
+		return &ClamdScanner{Network: "tcp", Addr: u.Host, Timeout: timeout}, nil
+	case "unix":
+		return &ClamdScanner{Network: "unix", Addr: u.Path, Timeout: timeout}, nil
+	case "http", "https":
+		return &HTTPScanner{URL: rawURL, Client: &http.Client{Timeout: timeout}}, nil
+	}
+	return nil, errors.Errorf("unknown scanner %q, expected clamd, unix, http or https url", rawURL)
+}
+
+// clamdChunk is size of INSTREAM chunks, it must be below StreamMaxLength of clamd.
+const clamdChunk = 64 * 1024
+
+// ClamdScanner streams content to ClamAV daemon with INSTREAM command.
+type ClamdScanner struct {
+	Network string
+	Addr    string
+	Timeout time.Duration
+}
+
+func (s *ClamdScanner) Scan(ctx context.Context, content io.Reader) (string, error) {
+	d := net.Dialer{Timeout: s.Timeout}
+	conn, err := d.DialContext(ctx, s.Network, s.Addr)
+	if err != nil {
+		return "", errors.Wrap(err, "could not connect to clamd")
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", errors.Wrap(err, "could not send command to clamd")
+	}
+	buf := make([]byte, 4+clamdChunk)
+	for {
+		n, rerr := content.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return "", errors.Wrap(err, "could not stream content to clamd")
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return "", errors.Wrap(rerr, "could not read content")
+		}
+	}
+	// zero length chunk ends the stream
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", errors.Wrap(err, "could not stream content to clamd")
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", errors.Wrap(err, "could not read reply of clamd")
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply parses "stream: OK", "stream: Eicar-Signature FOUND" or "... ERROR".
+func parseClamdReply(reply string) (string, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+	return "", errors.Errorf("clamd replied %q", reply)
+}
+
+// HTTPScanner posts content to external API, which responds with {"infected": true, "threat": "name"}.
+type HTTPScanner struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *HTTPScanner) Scan(ctx context.Context, content io.Reader) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, s.URL, content)
+	if err != nil {
+		return "", errors.Wrap(err, "could not build scan request")
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", errors.Wrap(err, "could not send content to scanner")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("scanner responded with %s", resp.Status)
+	}
+	var result struct {
+		Infected bool   `json:"infected"`
+		Threat   string `json:"threat"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return "", errors.Wrap(err, "could not decode scanner response")
+	}
+	if !result.Infected {
+		return "", nil
+	}
+	if result.Threat == "" {
+		return "unknown", nil
+	}
+	return result.Threat, nil
+}
@@ -0,0 +1,140 @@
+package attachment
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Storage keeps attachment contents on the filesystem, metadata lives in the database.
+type Storage struct {
+	dir string
+}
+
+func NewStorage(dir string) *Storage {
+	return &Storage{dir: dir}
+}
+
+// Checksums of attachment content, hex encoded.
+type Checksums struct {
+	MD5    string
+	SHA256 string
+}
+
+// Create writes content to a temporary file, it becomes visible after Commit.
+func (s *Storage) Create(content io.Reader) (tmpPath string, size int64, sums Checksums, err error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", 0, sums, errors.Wrap(err, "could not create storage directory")
+	}
+	f, err := ioutil.TempFile(s.dir, ".upload-")
+	if err != nil {
+		return "", 0, sums, errors.Wrap(err, "could not create temporary file")
+	}
+	defer f.Close()
+
+	md5sum, sha256sum := md5.New(), sha256.New()
+	size, err = io.Copy(io.MultiWriter(f, md5sum, sha256sum), content)
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, sums, errors.Wrap(err, "could not write attachment content")
+	}
+	sums.MD5 = hex.EncodeToString(md5sum.Sum(nil))
+	sums.SHA256 = hex.EncodeToString(sha256sum.Sum(nil))
+	return f.Name(), size, sums, nil
+}
+
+// Append writes content of resumable upload at offset, up to limit bytes, and returns how many bytes
+// are written. Bytes past offset left by interrupted writes are overwritten.
+func (s *Storage) Append(uploadID string, offset int64, content io.Reader, limit int64) (int64, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return 0, errors.Wrap(err, "could not create storage directory")
+	}
+	f, err := os.OpenFile(s.PartPath(uploadID), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not open upload")
+	}
+	defer f.Close()
+
+	if err := f.Truncate(offset); err != nil {
+		return 0, errors.Wrap(err, "could not truncate upload")
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, errors.Wrap(err, "could not seek upload")
+	}
+	n, err := io.Copy(f, io.LimitReader(content, limit))
+	if err != nil {
+		return n, errors.Wrap(err, "could not write upload content")
+	}
+	return n, nil
+}
+
+// Sum returns checksums of file, e.g. of completed upload.
+func (s *Storage) Sum(path string) (Checksums, error) {
+	var sums Checksums
+	f, err := os.Open(path)
+	if err != nil {
+		return sums, errors.Wrap(err, "could not open upload")
+	}
+	defer f.Close()
+
+	md5sum, sha256sum := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5sum, sha256sum), f); err != nil {
+		return sums, errors.Wrap(err, "could not read upload")
+	}
+	sums.MD5 = hex.EncodeToString(md5sum.Sum(nil))
+	sums.SHA256 = hex.EncodeToString(sha256sum.Sum(nil))
+	return sums, nil
+}
+
+// PartPath is a path of resumable upload content, it is hidden like temporary files until Commit.
+func (s *Storage) PartPath(uploadID string) string {
+	return filepath.Join(s.dir, ".part-"+filepath.Base(uploadID))
+}
+
+func (s *Storage) Commit(tmpPath, id string) error {
+	if err := os.Rename(tmpPath, s.path(id)); err != nil {
+		return errors.Wrap(err, "could not store attachment content")
+	}
+	return nil
+}
+
+func (s *Storage) Discard(tmpPath string) {
+	os.Remove(tmpPath)
+}
+
+func (s *Storage) Open(id string) (*os.File, error) {
+	f, err := os.Open(s.path(id))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open attachment content")
+	}
+	return f, nil
+}
+
+func (s *Storage) Remove(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "could not remove attachment content")
+	}
+	return nil
+}
+
+// Quarantine moves content of infected attachment out of reach of downloads, it is kept for investigation.
+func (s *Storage) Quarantine(id string) error {
+	dir := filepath.Join(s.dir, ".quarantine")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "could not create quarantine directory")
+	}
+	if err := os.Rename(s.path(id), filepath.Join(dir, filepath.Base(id))); err != nil {
+		return errors.Wrap(err, "could not quarantine attachment content")
+	}
+	return nil
+}
+
+func (s *Storage) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id))
+}
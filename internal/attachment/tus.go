@@ -0,0 +1,239 @@
+package attachment
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// Headers of tus protocol.
+const (
+	HeaderTusResumable = "Tus-Resumable"
+	HeaderTusVersion   = "Tus-Version"
+	HeaderTusExtension = "Tus-Extension"
+	HeaderTusMaxSize   = "Tus-Max-Size"
+	HeaderUploadLength = "Upload-Length"
+	HeaderUploadOffset = "Upload-Offset"
+	HeaderUploadMeta   = "Upload-Metadata"
+	HeaderUploadExpiry = "Upload-Expires"
+	// HeaderAttachmentID is set when upload is complete.
+	HeaderAttachmentID = "X-Attachment-ID"
+)
+
+// TusVersion is the only supported version of tus protocol.
+const TusVersion = "1.0.0"
+
+const offsetContentType = "application/offset+octet-stream"
+
+// uploadRoutes implement tus 1.0 protocol with creation, expiration and termination extensions,
+// see https://tus.io/protocols/resumable-upload.html. Attachment is described by article_id, filename
+// and optional content_type in Upload-Metadata.
+func uploadRoutes(m *Manager, maxSize int64, ttl time.Duration) chi.Router {
+	r := chi.NewRouter()
+	r.Use(tusResumable)
+
+	r.Options("/", optionsHandler(maxSize))
+	r.Post("/", makeHandler(m, createUploadHandler(maxSize, ttl)))
+
+	r.Route("/{uploadID}", func(r chi.Router) {
+		r.Head("/", makeHandler(m, headUploadHandler))
+		r.Patch("/", makeHandler(m, patchUploadHandler(ttl)))
+		r.Delete("/", makeHandler(m, deleteUploadHandler))
+	})
+
+	return r
+}
+
+// tusResumable rejects requests of other protocol versions, OPTIONS is used to discover them.
+func tusResumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderTusResumable, TusVersion)
+		if r.Method != http.MethodOptions && r.Header.Get(HeaderTusResumable) != TusVersion {
+			w.Header().Set(HeaderTusVersion, TusVersion)
+			render.Render(w, r, errStatus(http.StatusPreconditionFailed, i18n.Errorf("attachment.tus_version", TusVersion)))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func optionsHandler(maxSize int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderTusVersion, TusVersion)
+		w.Header().Set(HeaderTusExtension, "creation,expiration,termination")
+		w.Header().Set(HeaderTusMaxSize, strconv.FormatInt(maxSize, 10))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// createUploadHandler starts upload and responds with its URL in Location.
+func createUploadHandler(maxSize int64, ttl time.Duration) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "attachment")
+
+		length, err := strconv.ParseInt(r.Header.Get(HeaderUploadLength), 10, 64)
+		if err != nil || length < 1 {
+			render.Render(w, r, handler.ErrBadRequest(i18n.Errorf("attachment.invalid_header", HeaderUploadLength)))
+			return
+		}
+		if length > maxSize {
+			render.Render(w, r, handler.ErrRequestEntityTooLarge(i18n.Errorf("attachment.too_large", maxSize)))
+			return
+		}
+		meta, err := parseMetadata(r.Header.Get(HeaderUploadMeta))
+		if err != nil {
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		u := &Upload{
+			ArticleID:   meta["article_id"],
+			Filename:    meta["filename"],
+			ContentType: meta["content_type"],
+			Length:      length,
+			ExpiresAt:   time.Now().Add(ttl),
+		}
+		if u.ArticleID == "" || u.Filename == "" {
+			render.Render(w, r, handler.ErrBadRequest(i18n.Errorf("attachment.params_required")))
+			return
+		}
+		if u.ContentType == "" {
+			u.ContentType = "application/octet-stream"
+		}
+
+		if err := m.CreateUpload(u); err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+
+		w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+u.ID)
+		w.Header().Set(HeaderUploadExpiry, u.ExpiresAt.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// headUploadHandler tells how many bytes are received, so client resumes from there.
+func headUploadHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "attachment")
+
+	u, err := m.UploadByID(chi.URLParam(r, "uploadID"))
+	if err != nil {
+		if err == ErrNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logger.WithError(err).Error()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	setUploadHeaders(w, u)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// patchUploadHandler appends request body at Upload-Offset, it must match bytes received so far.
+func patchUploadHandler(ttl time.Duration) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "attachment")
+
+		if ct := r.Header.Get("Content-Type"); ct != offsetContentType {
+			render.Render(w, r, errStatus(http.StatusUnsupportedMediaType, i18n.Errorf("attachment.content_type", offsetContentType)))
+			return
+		}
+		offset, err := strconv.ParseInt(r.Header.Get(HeaderUploadOffset), 10, 64)
+		if err != nil || offset < 0 {
+			render.Render(w, r, handler.ErrBadRequest(i18n.Errorf("attachment.invalid_header", HeaderUploadOffset)))
+			return
+		}
+
+		u, err := m.WriteUpload(chi.URLParam(r, "uploadID"), offset, r.Body, time.Now().Add(ttl))
+		if err != nil {
+			switch err {
+			case ErrNotFound:
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrNotFound(err))
+			case ErrOffsetMismatch:
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrConflict(i18n.Errorf("attachment.offset_mismatch", u.Offset)))
+			default:
+				logger.WithError(err).Error()
+				render.Render(w, r, handler.ErrUnknown(err))
+			}
+			return
+		}
+		if u.AttachmentID != "" {
+			logger.WithField("upload", u.ID).Infof("upload is complete as attachment %s", u.AttachmentID)
+		}
+		setUploadHeaders(w, u)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// deleteUploadHandler terminates upload, so its content is removed without waiting for expiry.
+func deleteUploadHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "attachment")
+
+	if err := m.DeleteUpload(chi.URLParam(r, "uploadID")); err != nil {
+		if err == ErrNotFound {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setUploadHeaders(w http.ResponseWriter, u *Upload) {
+	w.Header().Set(HeaderUploadOffset, strconv.FormatInt(u.Offset, 10))
+	w.Header().Set(HeaderUploadLength, strconv.FormatInt(u.Length, 10))
+	if u.AttachmentID != "" {
+		w.Header().Set(HeaderAttachmentID, u.AttachmentID)
+		return
+	}
+	w.Header().Set(HeaderUploadExpiry, u.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// parseMetadata parses Upload-Metadata, comma separated pairs of key and base64 encoded value.
+func parseMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	if header == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		kv := strings.Fields(pair)
+		if len(kv) == 0 || len(kv) > 2 {
+			return nil, i18n.Errorf("attachment.invalid_header", HeaderUploadMeta)
+		}
+		var value []byte
+		if len(kv) == 2 {
+			var err error
+			if value, err = base64.StdEncoding.DecodeString(kv[1]); err != nil {
+				return nil, i18n.Errorf("attachment.invalid_header", HeaderUploadMeta)
+			}
+		}
+		meta[kv[0]] = string(value)
+	}
+	return meta, nil
+}
+
+// errStatus renders error with status which has no helper in handler package.
+func errStatus(status int, err error) render.Renderer {
+	return &handler.ErrResponse{
+		Err:            err,
+		HTTPStatusCode: status,
+		StatusText:     http.StatusText(status),
+		ErrorText:      err.Error(),
+	}
+}
@@ -0,0 +1,268 @@
+package attachment
+
+import (
+	"database/sql"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// ErrOffsetMismatch is returned when upload is written at other offset than it has received so far.
+var ErrOffsetMismatch = errors.New("offset mismatch")
+
+// Upload is a session of resumable upload, it becomes attachment when all of Length bytes are received.
+// Sessions expire when they make no progress for a while, their content is removed then.
+type Upload struct {
+	ID          string
+	ArticleID   string
+	Filename    string
+	ContentType string
+	Length      int64
+	Offset      int64
+	// AttachmentID is set when upload is complete.
+	AttachmentID string
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
+
+// CreateUpload starts upload session and fills generated fields.
+func (m *Manager) CreateUpload(u *Upload) error {
+	id, err := m.ids.NewID()
+	if err != nil {
+		return err
+	}
+	u.ID = id
+	err = m.db.QueryRow(
+		"INSERT INTO attachment_upload(id, article_id, filename, content_type, length, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at;",
+		u.ID, u.ArticleID, u.Filename, u.ContentType, u.Length, u.ExpiresAt,
+	).Scan(&u.CreatedAt)
+	if err != nil {
+		return errors.Wrap(err, "could not create upload")
+	}
+	return nil
+}
+
+// UploadByID returns upload which is not expired.
+func (m *Manager) UploadByID(id string) (*Upload, error) {
+	return m.upload(id, "")
+}
+
+// upload returns upload which is not expired, lock is a locking clause, e.g. FOR UPDATE.
+func (m *Manager) upload(id, lock string) (*Upload, error) {
+	var u Upload
+	var attachmentID *string
+	err := m.db.QueryRow(
+		"SELECT id, article_id, filename, content_type, length, received, attachment_id, expires_at, created_at FROM attachment_upload WHERE id = $1 AND expires_at > now()"+lock+";",
+		id,
+	).Scan(&u.ID, &u.ArticleID, &u.Filename, &u.ContentType, &u.Length, &u.Offset, &attachmentID, &u.ExpiresAt, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get upload by id")
+	}
+	if attachmentID != nil {
+		u.AttachmentID = *attachmentID
+	}
+	return &u, nil
+}
+
+// WriteUpload writes content at offset and extends expiry of upload, the last write turns upload into
+// attachment. Offset is checked in a short transaction and content is streamed to storage without
+// holding upload locked, so slow clients do not keep database connections. Content is staged and
+// appended once write at offset is committed, concurrent writes of the same offset do not interleave
+// and all but one of them get ErrOffsetMismatch.
+func (m *Manager) WriteUpload(id string, offset int64, content io.Reader, expires time.Time) (*Upload, error) {
+	var u *Upload
+	err := m.Tx(false, func(m *Manager) error {
+		var err error
+		u, err = m.upload(id, " FOR UPDATE")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if offset != u.Offset || u.AttachmentID != "" {
+		return u, ErrOffsetMismatch
+	}
+
+	// bytes of failed writes are not recorded, next write at the same offset overwrites them
+	staged, n, _, err := m.storage.Create(io.LimitReader(content, u.Length-offset))
+	if err != nil {
+		return nil, err
+	}
+	defer m.storage.Discard(staged)
+
+	err = m.Tx(false, func(m *Manager) error {
+		res, err := m.db.Exec(
+			"UPDATE attachment_upload SET received = $3, expires_at = $4 WHERE id = $1 AND received = $2 AND attachment_id IS NULL AND expires_at > now();",
+			u.ID, offset, offset+n, expires,
+		)
+		if err != nil {
+			return errors.Wrap(err, "could not update upload")
+		}
+		if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+			return ErrOffsetMismatch
+		}
+		// row is locked by update until commit, so nobody else appends at the same offset
+		if err := m.appendStaged(u.ID, offset, staged); err != nil {
+			return err
+		}
+		u.Offset, u.ExpiresAt = offset+n, expires
+		if u.Offset == u.Length {
+			return m.complete(u)
+		}
+		return nil
+	})
+	if err == ErrOffsetMismatch {
+		// another write has moved offset, or upload is expired meanwhile
+		cur, err := m.upload(id, "")
+		if err != nil {
+			return nil, err
+		}
+		return cur, ErrOffsetMismatch
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// appendStaged appends staged content of upload at offset.
+func (m *Manager) appendStaged(uploadID string, offset int64, staged string) error {
+	f, err := os.Open(staged)
+	if err != nil {
+		return errors.Wrap(err, "could not open staged upload")
+	}
+	defer f.Close()
+	_, err = m.storage.Append(uploadID, offset, f, math.MaxInt64)
+	return err
+}
+
+// complete saves content of upload as attachment.
+func (m *Manager) complete(u *Upload) error {
+	part := m.storage.PartPath(u.ID)
+	sums, err := m.storage.Sum(part)
+	if err != nil {
+		return err
+	}
+	a := &Attachment{
+		ArticleID:   u.ArticleID,
+		Filename:    u.Filename,
+		ContentType: u.ContentType,
+		Size:        u.Length,
+		MD5:         sums.MD5,
+		SHA256:      sums.SHA256,
+	}
+	if err := m.insert(a); err != nil {
+		return err
+	}
+	if _, err := m.db.Exec("UPDATE attachment_upload SET attachment_id = $2 WHERE id = $1;", u.ID, a.ID); err != nil {
+		return errors.Wrap(err, "could not complete upload")
+	}
+	if err := m.storage.Commit(part, a.ID); err != nil {
+		return err
+	}
+	u.AttachmentID = a.ID
+	return nil
+}
+
+// DeleteUpload terminates upload and removes its content, attachment of complete upload is kept.
+func (m *Manager) DeleteUpload(id string) error {
+	res, err := m.db.Exec("DELETE FROM attachment_upload WHERE id = $1 AND expires_at > now();", id)
+	if err != nil {
+		return errors.Wrap(err, "could not delete upload")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	m.storage.Discard(m.storage.PartPath(id))
+	return nil
+}
+
+// ExpireUploads deletes uploads expired before now with their content and returns how many are deleted.
+func (m *Manager) ExpireUploads(now time.Time) (int, error) {
+	rows, err := m.db.Query("DELETE FROM attachment_upload WHERE expires_at <= $1 RETURNING id;", now)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not expire uploads")
+	}
+	defer rows.Close()
+
+	var expired []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, errors.Wrap(err, "could not scan expired upload")
+		}
+		expired = append(expired, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, errors.Wrap(err, "could not expire uploads")
+	}
+	for _, id := range expired {
+		m.storage.Discard(m.storage.PartPath(id))
+	}
+	return len(expired), nil
+}
+
+// Sweeper expires abandoned uploads, it runs on a single replica.
+type Sweeper struct {
+	m        *Manager
+	interval time.Duration
+	clock    clock.Clock
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewSweeper(m *Manager, interval time.Duration) *Sweeper {
+	return &Sweeper{
+		m:        m,
+		interval: interval,
+		clock:    clock.Real,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// RunPending expires uploads which are due.
+func (s *Sweeper) RunPending(logger log.Logger) error {
+	n, err := s.m.ExpireUploads(s.clock.Now())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		logger.WithField("context", "attachment").Infof("expired %d abandoned uploads", n)
+	}
+	return nil
+}
+
+// Run expires uploads every interval until Close is called.
+func (s *Sweeper) Run(logger log.Logger) {
+	defer close(s.done)
+
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := s.RunPending(logger); err != nil {
+				logger.WithError(err).Error()
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Sweeper) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
@@ -0,0 +1,104 @@
+// Package audit records actions admins make on behalf of users, i.e. while they impersonate users
+// for support, and security events of accounts, e.g. failed logins and lockouts, and serves them to admins.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+// Entry is a request real user made on behalf of user.
+type Entry struct {
+	ID         string `json:"id"`
+	RealUserID string `json:"real_user_id"`
+	UserID     string `json:"user_id"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	// Status is 0 until request is complete, it stays 0 if service stops in the middle of request.
+	Status    int       `json:"status"`
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Query selects entries of user, either real or impersonated one, or all entries if UserID is empty.
+type Query struct {
+	UserID string
+	// Before is a cursor, entries older than it are returned.
+	Before int64
+	Limit  int
+}
+
+type Manager struct {
+	db postgres.Querier
+}
+
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{db: db}
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db)}
+}
+
+// Record inserts entry before request is served, so no action is made without a trace.
+func (m *Manager) Record(e *Entry) error {
+	err := m.db.QueryRow(
+		"INSERT INTO audit_log(real_user_id, user_id, method, path, request_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at;",
+		e.RealUserID, e.UserID, e.Method, e.Path, e.RequestID,
+	).Scan(&e.ID, &e.CreatedAt)
+	if err != nil {
+		return errors.Wrap(err, "could not record audit entry")
+	}
+	return nil
+}
+
+// Complete sets status of entry when request is served.
+func (m *Manager) Complete(e *Entry, status int) error {
+	e.Status = status
+	if _, err := m.db.Exec("UPDATE audit_log SET status = $2 WHERE id = $1;", e.ID, e.Status); err != nil {
+		return errors.Wrap(err, "could not complete audit entry")
+	}
+	return nil
+}
+
+// List returns entries matching query, the latest first.
+func (m *Manager) List(q Query) ([]*Entry, error) {
+	query := "SELECT id, real_user_id, user_id, method, path, status, request_id, created_at FROM audit_log WHERE true"
+	var args []interface{}
+	if q.UserID != "" {
+		args = append(args, q.UserID)
+		query += " AND (real_user_id = $1 OR user_id = $1)"
+	}
+	if q.Before > 0 {
+		args = append(args, q.Before)
+		query += " AND id < $" + strconv.Itoa(len(args))
+	}
+	args = append(args, q.Limit)
+	query += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args)) + ";"
+
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get audit entries")
+	}
+	defer rows.Close()
+
+	entries := []*Entry{}
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.ID, &e.RealUserID, &e.UserID, &e.Method, &e.Path, &e.Status, &e.RequestID, &e.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan row to audit entry")
+		}
+		entries = append(entries, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get audit entries")
+	}
+	return entries, nil
+}
@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Event is a security-relevant action on account, e.g. failed login or lockout, see user.Event.
+type Event struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	// UserID is empty when event is about email which is not registered.
+	UserID string `json:"user_id,omitempty"`
+	Email  string `json:"email,omitempty"`
+	// ActorID is the user who made the action, empty for anonymous requests.
+	ActorID   string    `json:"actor_id,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (m *Manager) RecordEvent(e *Event) error {
+	err := m.db.QueryRow(
+		`INSERT INTO audit_event(action, user_id, email, actor_id, ip, request_id, detail) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at;`,
+		e.Action, e.UserID, e.Email, e.ActorID, e.IP, e.RequestID, e.Detail,
+	).Scan(&e.ID, &e.CreatedAt)
+	if err != nil {
+		return errors.Wrap(err, "could not record audit event")
+	}
+	return nil
+}
+
+// ListEvents returns events of user, either the subject or the actor, the latest first.
+func (m *Manager) ListEvents(q Query) ([]*Event, error) {
+	query := "SELECT id, action, user_id, email, actor_id, ip, request_id, detail, created_at FROM audit_event WHERE true"
+	var args []interface{}
+	if q.UserID != "" {
+		args = append(args, q.UserID)
+		query += " AND (user_id = $1 OR actor_id = $1)"
+	}
+	if q.Before > 0 {
+		args = append(args, q.Before)
+		query += " AND id < $" + strconv.Itoa(len(args))
+	}
+	args = append(args, q.Limit)
+	query += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args)) + ";"
+
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get audit events")
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.Action, &e.UserID, &e.Email, &e.ActorID, &e.IP, &e.RequestID, &e.Detail, &e.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan row to audit event")
+		}
+		events = append(events, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get audit events")
+	}
+	return events, nil
+}
+
+// EventsAbout returns events which subject is user, the latest first. Events user made as an actor
+// are about other users and are not returned.
+func (m *Manager) EventsAbout(userID string) ([]*Event, error) {
+	rows, err := m.db.Query(
+		"SELECT id, action, user_id, email, actor_id, ip, request_id, detail, created_at FROM audit_event WHERE user_id = $1 ORDER BY id DESC;",
+		userID,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get audit events")
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.Action, &e.UserID, &e.Email, &e.ActorID, &e.IP, &e.RequestID, &e.Detail, &e.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan row to audit event")
+		}
+		events = append(events, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get audit events")
+	}
+	return events, nil
+}
+
+// AnonymizeEvents clears email and address of events about user, including events about its email
+// recorded before it was registered, e.g. failed logins. Events keep what happened and when.
+func (m *Manager) AnonymizeEvents(userID string) error {
+	_, err := m.db.Exec(
+		`UPDATE audit_event SET email = '', ip = ''
+		WHERE user_id = $1 OR email IN (SELECT email FROM audit_event WHERE user_id = $1 AND email <> '');`,
+		userID,
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not anonymize audit events of user")
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// Routes serve audit log and events to admins, they accept ?user= to get entries of user, either real
+// or impersonated one, and events of user, either the subject or the actor, ?limit= and ?cursor= of
+// the next page.
+func Routes(m *Manager) chi.Router {
+	r := chi.NewRouter()
+	r.Use(handler.RequireRole(reqctx.AdminRole))
+	r.Get("/", makeHandler(m, listHandler))
+	r.Get("/events", makeHandler(m, listEventsHandler))
+	return r
+}
+
+type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m.WithContext(r.Context()), w, r)
+	}
+}
+
+// listHandler serves audit log, impersonated users have no roles, so it can not be read while impersonating.
+func listHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "audit")
+
+	q, err := parseQuery(r)
+	if err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	entries, err := m.List(q)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	resp := &listResponse{Entries: entries}
+	// a full page may be followed by another one
+	if len(entries) == q.Limit {
+		resp.Cursor = entries[len(entries)-1].ID
+	}
+	render.Render(w, r, resp)
+}
+
+func listEventsHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "audit")
+
+	q, err := parseQuery(r)
+	if err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	events, err := m.ListEvents(q)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	resp := &eventsResponse{Events: events}
+	if len(events) == q.Limit {
+		resp.Cursor = events[len(events)-1].ID
+	}
+	render.Render(w, r, resp)
+}
+
+// parseQuery reads ?user=, ?limit= and ?cursor=.
+func parseQuery(r *http.Request) (Query, error) {
+	q := Query{UserID: r.URL.Query().Get("user"), Limit: 20}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return q, i18n.Errorf("request.invalid_param", "limit", 1, 100)
+		}
+		q.Limit = n
+	}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return q, i18n.Errorf("audit.invalid_cursor", v)
+		}
+		q.Before = n
+	}
+	return q, nil
+}
+
+type listResponse struct {
+	Entries []*Entry `json:"entries"`
+	// Cursor is passed as ?cursor= to get the next page, it is empty on the last page.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+func (lr *listResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type eventsResponse struct {
+	Events []*Event `json:"events"`
+	// Cursor is passed as ?cursor= to get the next page, it is empty on the last page.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+func (er *eventsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
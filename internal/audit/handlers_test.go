@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/redact"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var (
+	entryColumns = []string{"id", "real_user_id", "user_id", "method", "path", "status", "request_id", "created_at"}
+	eventColumns = []string{"id", "action", "user_id", "email", "actor_id", "ip", "request_id", "detail", "created_at"}
+)
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestImpersonate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	var user, realUser *reqctx.User
+	newRouter := func(u *reqctx.User, enabled bool) http.Handler {
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(u))
+		r.Use(Impersonate(NewManager(db), enabled, redact.New("token")))
+		r.Delete("/articles/1", func(w http.ResponseWriter, r *http.Request) {
+			user, realUser = reqctx.GetUser(r.Context()), reqctx.GetRealUser(r.Context())
+			w.WriteHeader(http.StatusNoContent)
+		})
+		return r
+	}
+	admin := &reqctx.User{ID: "a1", Roles: []string{reqctx.AdminRole}}
+
+	mock.ExpectQuery("INSERT INTO audit_log(.+) RETURNING id, created_at;").
+		WithArgs("a1", "u1", http.MethodDelete, "/articles/1?force=true&token=%5BFiltered%5D", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, time.Now()))
+	mock.ExpectExec("UPDATE audit_log SET status = \\$2 WHERE id = \\$1;").
+		WithArgs("7", http.StatusNoContent).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "http://example.com/articles/1?force=true&token=secret", nil)
+	req.Header.Set(HeaderImpersonate, "u1")
+	newRouter(admin, true).ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status: %v", w.Code)
+	}
+	if user == nil || user.ID != "u1" || len(user.Roles) != 0 || realUser != admin {
+		t.Errorf("unexpected identities: %+v %+v", user, realUser)
+	}
+
+	// not impersonating requests are not recorded
+	w = httptest.NewRecorder()
+	newRouter(admin, true).ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "http://example.com/articles/1", nil))
+	if w.Code != http.StatusNoContent || user != admin || realUser != admin {
+		t.Errorf("unexpected response: %v %+v %+v", w.Code, user, realUser)
+	}
+
+	tests := []struct {
+		user    *reqctx.User
+		enabled bool
+		status  int
+	}{
+		{nil, true, http.StatusUnauthorized},
+		{&reqctx.User{ID: "u2"}, true, http.StatusForbidden},
+		{admin, false, http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, "http://example.com/articles/1", nil)
+		req.Header.Set(HeaderImpersonate, "u1")
+		newRouter(tc.user, tc.enabled).ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("unexpected status of %+v: %v", tc.user, w.Code)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	newRouter := func(u *reqctx.User) http.Handler {
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(u))
+		r.Mount("/audit", Routes(NewManager(db)))
+		return r
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM audit_log WHERE true AND \\(real_user_id = \\$1 OR user_id = \\$1\\) AND id < \\$2 ORDER BY id DESC LIMIT \\$3;").
+		WithArgs("u1", 100, 1).
+		WillReturnRows(sqlmock.NewRows(entryColumns).AddRow(7, "a1", "u1", "DELETE", "/1.0/articles/1", 204, "req-1", time.Now()))
+	w := httptest.NewRecorder()
+	newRouter(&reqctx.User{ID: "a1", Roles: []string{reqctx.AdminRole}}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/audit?user=u1&limit=1&cursor=100", nil))
+	var resp listResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(resp.Entries) != 1 || resp.Entries[0].RealUserID != "a1" || resp.Cursor != "7" {
+		t.Errorf("unexpected response: %v %+v", w.Code, resp)
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM audit_event WHERE true AND \\(user_id = \\$1 OR actor_id = \\$1\\) ORDER BY id DESC LIMIT \\$2;").
+		WithArgs("u1", 20).
+		WillReturnRows(sqlmock.NewRows(eventColumns).AddRow(3, "locked", "", "ann@example.com", "", "192.0.2.1", "req-2", "", time.Now()))
+	w = httptest.NewRecorder()
+	newRouter(&reqctx.User{ID: "a1", Roles: []string{reqctx.AdminRole}}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/audit/events?user=u1", nil))
+	var events eventsResponse
+	if err := json.NewDecoder(w.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(events.Events) != 1 || events.Events[0].Action != "locked" || events.Cursor != "" {
+		t.Errorf("unexpected response: %v %+v", w.Code, events)
+	}
+
+	tests := []struct {
+		user   *reqctx.User
+		url    string
+		status int
+	}{
+		{nil, "/audit", http.StatusUnauthorized},
+		{&reqctx.User{ID: "u1"}, "/audit", http.StatusForbidden},
+		{&reqctx.User{ID: "a1", Roles: []string{reqctx.AdminRole}}, "/audit?cursor=x", http.StatusBadRequest},
+		{&reqctx.User{ID: "u1"}, "/audit/events", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		newRouter(tc.user).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com"+tc.url, nil))
+		if w.Code != tc.status {
+			t.Errorf("unexpected status of %s: %v", tc.url, w.Code)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
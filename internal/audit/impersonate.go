@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/redact"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// HeaderImpersonate is ID of user admin makes request on behalf of.
+const HeaderImpersonate = "X-Impersonate-User"
+
+// Impersonate serves requests with X-Impersonate-User on behalf of that user when admin makes them:
+// the user becomes effective user of request and admin stays its real user. Impersonated user has
+// no roles, so admin can not gain more than the user has. Every such request is recorded before it
+// is served and is not served if it can not be recorded, sensitive query parameters of its path are
+// masked with redactor unless it is nil. Requests are rejected if enabled is false.
+func Impersonate(m *Manager, enabled bool, redactor *redact.Redactor) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := r.Header.Get(HeaderImpersonate)
+			if userID == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			logger := log.GetLogEntry(r).WithField("context", "audit")
+
+			admin := reqctx.GetUser(r.Context())
+			switch {
+			case !enabled:
+				err := i18n.Errorf("audit.impersonation_disabled")
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrForbidden(err))
+				return
+			case admin == nil:
+				err := i18n.Errorf("audit.user_required")
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrUnauthorized(err))
+				return
+			case !admin.HasRole(reqctx.AdminRole):
+				err := i18n.Errorf("audit.impersonation_denied")
+				logger.WithError(err).WithField("user", admin.ID).Warnf("user tried to impersonate %s", userID)
+				render.Render(w, r, handler.ErrForbidden(err))
+				return
+			}
+
+			path := r.URL.RequestURI()
+			if redactor != nil {
+				path = redactor.URL(path)
+			}
+			e := &Entry{
+				RealUserID: admin.ID,
+				UserID:     userID,
+				Method:     r.Method,
+				Path:       path,
+				RequestID:  reqctx.GetRequestID(r.Context()),
+			}
+			if err := m.WithContext(r.Context()).Record(e); err != nil {
+				logger.WithError(err).Error()
+				render.Render(w, r, handler.ErrUnknown(err))
+				return
+			}
+
+			ctx := reqctx.WithRealUser(r.Context(), admin)
+			ctx = reqctx.WithUser(ctx, &reqctx.User{ID: userID})
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			served := false
+			// request may be cancelled or panic, but its entry is completed anyway
+			defer func() {
+				status := ww.Status()
+				switch {
+				case status == 0 && served:
+					status = http.StatusOK
+				case status == 0:
+					// recoverer responds to panic with 500 after this
+					status = http.StatusInternalServerError
+				}
+				if err := m.Complete(e, status); err != nil {
+					logger.WithError(err).Error()
+				}
+			}()
+			next.ServeHTTP(ww, r.WithContext(ctx))
+			served = true
+		})
+	}
+}
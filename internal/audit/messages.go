@@ -0,0 +1,18 @@
+package audit
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"audit.user_required":          "sign in to see audit log or impersonate users",
+		"audit.impersonation_disabled": "impersonation of users is disabled",
+		"audit.impersonation_denied":   "only admins may impersonate users",
+		"audit.invalid_cursor":         "cursor %s is invalid",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"audit.user_required":          "войдите, чтобы просматривать журнал аудита или действовать от имени пользователей",
+		"audit.impersonation_disabled": "действия от имени пользователей отключены",
+		"audit.impersonation_denied":   "действовать от имени пользователей могут только администраторы",
+		"audit.invalid_cursor":         "курсор %s некорректен",
+	})
+}
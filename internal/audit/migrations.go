@@ -0,0 +1,52 @@
+package audit
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "0026_audit_log",
+			Up: []string{
+				`CREATE TABLE audit_log (
+					id            BIGSERIAL                   NOT NULL,
+					real_user_id  character varying(128)      NOT NULL,
+					user_id       character varying(128)      NOT NULL,
+					method        character varying(16)       NOT NULL,
+					path          text                        NOT NULL,
+					status        smallint                    NOT NULL DEFAULT 0,
+					request_id    character varying(128)      NOT NULL DEFAULT '',
+					created_at    timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id)
+				);`,
+				`CREATE INDEX audit_log_real_user_idx ON audit_log (real_user_id, id DESC);`,
+				`CREATE INDEX audit_log_user_idx ON audit_log (user_id, id DESC);`,
+				`CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);`,
+			},
+		},
+		{
+			Id: "0031_audit_event",
+			Up: []string{
+				`CREATE TABLE audit_event (
+					id          BIGSERIAL                   NOT NULL,
+					action      character varying(64)       NOT NULL,
+					user_id     character varying(128)      NOT NULL DEFAULT '',
+					email       character varying(254)      NOT NULL DEFAULT '',
+					actor_id    character varying(128)      NOT NULL DEFAULT '',
+					ip          character varying(64)       NOT NULL DEFAULT '',
+					request_id  character varying(128)      NOT NULL DEFAULT '',
+					created_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id)
+				);`,
+				`CREATE INDEX audit_event_user_idx ON audit_event (user_id, id DESC);`,
+				`CREATE INDEX audit_event_actor_idx ON audit_event (actor_id, id DESC);`,
+				`CREATE INDEX audit_event_created_at_idx ON audit_event (created_at);`,
+			},
+		},
+		{
+			Id: "0034_audit_event_detail",
+			Up: []string{
+				`ALTER TABLE audit_event ADD COLUMN detail character varying(256) NOT NULL DEFAULT '';`,
+			},
+		},
+	}
+}
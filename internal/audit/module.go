@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"net/http"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/internal/privacy"
+	"github.com/agalitsyn/goapi/internal/user"
+	"github.com/agalitsyn/goapi/pkg/module"
+	"github.com/agalitsyn/goapi/pkg/redact"
+	"github.com/agalitsyn/goapi/pkg/retention"
+)
+
+func init() {
+	module.Register(&auditModule{})
+}
+
+type auditModule struct {
+	module.Base
+
+	opts struct {
+		Impersonation bool `long:"audit-impersonation" env:"GAPI_AUDIT_IMPERSONATION" description:"Allow admins to make requests on behalf of users with X-Impersonate-User header for support, every such request is recorded in audit log."`
+	}
+
+	manager  *Manager
+	redactor *redact.Redactor
+}
+
+func (mod *auditModule) Name() string                     { return "audit" }
+func (mod *auditModule) Options() interface{}             { return &mod.opts }
+func (mod *auditModule) Migrations() []*migrate.Migration { return Migrations() }
+
+// Init provides handler of account events as user.event_handler.audit and privacy handler as
+// privacy.handler.audit.
+func (mod *auditModule) Init(env *module.Env) error {
+	mod.manager = NewManager(env.DB)
+	mod.redactor = env.Redactor
+	env.Provide(user.EventHandlerPrefix+"audit", user.EventHandler(func(e user.Event) error {
+		return mod.manager.RecordEvent(&Event{
+			Action:    e.Action,
+			UserID:    e.UserID,
+			Email:     e.Email,
+			ActorID:   e.ActorID,
+			IP:        e.IP,
+			RequestID: e.RequestID,
+			Detail:    e.Detail,
+		})
+	}))
+	env.Provide(privacy.HandlerPrefix+"audit", &privacyHandler{m: mod.manager})
+	return nil
+}
+
+func (mod *auditModule) Routes() map[string]http.Handler {
+	return map[string]http.Handler{"/audit": Routes(mod.manager)}
+}
+
+// RetentionRules keep audit log and events for two years.
+func (mod *auditModule) RetentionRules() []retention.Rule {
+	return []retention.Rule{
+		{Name: "entries", Table: "audit_log", Column: "created_at", MaxAge: 2 * 365 * 24 * time.Hour},
+		{Name: "events", Table: "audit_event", Column: "created_at", MaxAge: 2 * 365 * 24 * time.Hour},
+	}
+}
+
+// Middleware serves requests of admins on behalf of users.
+func (mod *auditModule) Middleware() func(next http.Handler) http.Handler {
+	return Impersonate(mod.manager, mod.opts.Impersonation, mod.redactor)
+}
@@ -0,0 +1,23 @@
+package audit
+
+import "github.com/agalitsyn/goapi/internal/privacy"
+
+// privacyHandler exports events about users, erasure clears their emails and addresses. Audit log
+// of requests has no personal data beyond ids and is left as is.
+type privacyHandler struct {
+	m *Manager
+}
+
+func (h *privacyHandler) Export(s privacy.Subject) (interface{}, error) {
+	if s.User == "" {
+		return []*Event{}, nil
+	}
+	return h.m.EventsAbout(s.User)
+}
+
+func (h *privacyHandler) Erase(s privacy.Subject) error {
+	if s.User == "" {
+		return nil
+	}
+	return h.m.AnonymizeEvents(s.User)
+}
@@ -0,0 +1,111 @@
+package author
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type Author struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Bio       string    `json:"bio"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Manager struct {
+	db postgres.Querier
+}
+
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{db: db}
+}
+
+// Tx runs fn with manager bound to a transaction, which is rolled back when fn fails or on dry run.
+func (m *Manager) Tx(dryRun bool, fn func(m *Manager) error) error {
+	return postgres.Tx(m.db, dryRun, func(tx postgres.Querier) error {
+		return fn(&Manager{db: tx})
+	})
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db)}
+}
+
+func (m *Manager) Save(a *Author) error {
+	err := m.db.QueryRow(
+		"INSERT INTO author(name, bio) VALUES ($1, $2) RETURNING id, created_at;",
+		a.Name, a.Bio,
+	).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return errors.Wrap(err, "could not save author")
+	}
+	return nil
+}
+
+func (m *Manager) Update(a *Author) error {
+	_, err := m.db.Exec("UPDATE author SET name = $2, bio = $3 WHERE id = $1;", a.ID, a.Name, a.Bio)
+	if err != nil {
+		return errors.Wrap(err, "could not update author")
+	}
+	return nil
+}
+
+// Delete leaves articles of author without author.
+func (m *Manager) Delete(a *Author) error {
+	_, err := m.db.Exec("DELETE FROM author WHERE id = $1;", a.ID)
+	if err != nil {
+		return errors.Wrap(err, "could not delete author")
+	}
+	return nil
+}
+
+func (m *Manager) ByID(id string) (*Author, error) {
+	rows, err := m.db.Query("SELECT id, name, bio, created_at FROM author WHERE id = $1;", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get author by id")
+	}
+	defer rows.Close()
+
+	authors, err := scanAll(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(authors) == 0 {
+		return nil, ErrNotFound
+	}
+	return authors[0], nil
+}
+
+// All returns authors ordered by name.
+func (m *Manager) All() ([]*Author, error) {
+	rows, err := m.db.Query("SELECT id, name, bio, created_at FROM author ORDER BY name, id;")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get authors")
+	}
+	defer rows.Close()
+
+	return scanAll(rows)
+}
+
+func scanAll(rows *sql.Rows) ([]*Author, error) {
+	authors := []*Author{}
+	for rows.Next() {
+		var a Author
+		if err := rows.Scan(&a.ID, &a.Name, &a.Bio, &a.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan row to author model")
+		}
+		authors = append(authors, &a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get authors")
+	}
+	return authors, nil
+}
@@ -0,0 +1,208 @@
+package author
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/sanitize"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+)
+
+// Routes serve authors, their articles are listed by /articles?author_id=.
+func Routes(m *Manager) chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", makeHandler(m, listHandler))
+	r.Post("/", makeHandler(m, createHandler))
+
+	r.Route("/{authorID}", func(r chi.Router) {
+		r.Get("/", makeHandler(m, getHandler))
+		r.Put("/", makeHandler(m, updateHandler))
+		r.Delete("/", makeHandler(m, deleteHandler))
+	})
+
+	return r
+}
+
+type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m.WithContext(r.Context()), w, r)
+	}
+}
+
+func listHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "author")
+
+	authors, err := m.All()
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	if err := render.RenderList(w, r, newAuthorListResponse(authors)); err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+}
+
+func getHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "author")
+
+	author, err := m.ByID(chi.URLParam(r, "authorID"))
+	if err != nil {
+		if err == ErrNotFound {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.Render(w, r, newAuthorResponse(author))
+}
+
+// createHandler on dry run renders author which would be created.
+func createHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "author")
+	dryRun := handler.DryRun(w, r)
+
+	var data authorRequest
+	if err := serializer.Decode(r, &data); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	if err := data.validate(); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+
+	author := &Author{Name: data.Name, Bio: data.Bio}
+	err := m.Tx(dryRun, func(m *Manager) error {
+		return m.Save(author)
+	})
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, newAuthorResponse(author))
+}
+
+// updateHandler replaces name and bio of author, on dry run the result is rendered but not persisted.
+func updateHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "author")
+	dryRun := handler.DryRun(w, r)
+
+	var data authorRequest
+	if err := serializer.Decode(r, &data); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	if err := data.validate(); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+
+	author, err := m.ByID(chi.URLParam(r, "authorID"))
+	if err != nil {
+		if err == ErrNotFound {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	author.Name, author.Bio = data.Name, data.Bio
+	err = m.Tx(dryRun, func(m *Manager) error {
+		return m.Update(author)
+	})
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.Render(w, r, newAuthorResponse(author))
+}
+
+// deleteHandler on dry run renders author which would be deleted.
+func deleteHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "author")
+	dryRun := handler.DryRun(w, r)
+
+	author, err := m.ByID(chi.URLParam(r, "authorID"))
+	if err != nil {
+		if err == ErrNotFound {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	err = m.Tx(dryRun, func(m *Manager) error {
+		return m.Delete(author)
+	})
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	if dryRun {
+		render.Render(w, r, newAuthorResponse(author))
+		return
+	}
+	render.NoContent(w, r)
+}
+
+type authorRequest struct {
+	Name string `json:"name"`
+	Bio  string `json:"bio"`
+}
+
+// validate strips HTML of request and checks it.
+func (ar *authorRequest) validate() error {
+	ar.Name = sanitize.TextPolicy.Sanitize(ar.Name)
+	ar.Bio = sanitize.TextPolicy.Sanitize(ar.Bio)
+	if strings.TrimSpace(ar.Name) == "" {
+		return i18n.Errorf("author.name_required")
+	}
+	return nil
+}
+
+func newAuthorListResponse(authors []*Author) []render.Renderer {
+	list := []render.Renderer{}
+	for _, a := range authors {
+		list = append(list, newAuthorResponse(a))
+	}
+	return list
+}
+
+func newAuthorResponse(author *Author) *authorResponse {
+	return &authorResponse{Author: author}
+}
+
+type authorResponse struct {
+	*Author
+}
+
+func (ar *authorResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
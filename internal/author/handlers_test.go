@@ -0,0 +1,110 @@
+package author
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/internal/article"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var authorColumns = []string{"id", "name", "bio", "created_at"}
+
+func TestRoutes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Mount("/authors", Routes(NewManager(db)))
+
+	// html is stripped from names
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO author").
+		WithArgs("Ann", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow("3", time.Now()))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://example.com/authors", strings.NewReader(`{"name": "<b>Ann</b>"}`)))
+	var a Author
+	if err := json.NewDecoder(w.Body).Decode(&a); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || a.ID != "3" || a.Name != "Ann" {
+		t.Errorf("unexpected response: %v %+v", w.Code, a)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://example.com/authors", strings.NewReader(`{"name": " "}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status of author without name: %v", w.Code)
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM author WHERE id = \\$1;").
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows(authorColumns).AddRow(3, "Ann", "", time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE author SET name = \\$2, bio = \\$3 WHERE id = \\$1;").
+		WithArgs("3", "Ann", "Writes about Go").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/authors/3", strings.NewReader(`{"name": "Ann", "bio": "Writes about Go"}`)))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status of update: %v", w.Code)
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM author WHERE id = \\$1;").
+		WithArgs("4").
+		WillReturnRows(sqlmock.NewRows(authorColumns))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "http://example.com/authors/4", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status of missing author: %v", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestArticleRelation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	rel := articleRelation(NewManager(db))
+
+	// articles without author are not queried
+	if v, err := rel(&article.Article{ID: "1"}, 10); err != nil || v != nil {
+		t.Errorf("unexpected author of article without author: %v %v", v, err)
+	}
+
+	authorID := "3"
+	mock.ExpectQuery("SELECT (.+) FROM author WHERE id = \\$1;").
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows(authorColumns).AddRow(3, "Ann", "", time.Now()))
+	v, err := rel(&article.Article{ID: "1", AuthorID: &authorID}, 10)
+	if a, ok := v.(*Author); err != nil || !ok || a.Name != "Ann" {
+		t.Errorf("unexpected author: %v %v", v, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
@@ -0,0 +1,12 @@
+package author
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"author.name_required": "name is required",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"author.name_required": "необходимо указать имя",
+	})
+}
@@ -0,0 +1,23 @@
+package author
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			// goes after 0018_article_author, which adds author_id column to articles
+			Id: "0019_author_initial",
+			Up: []string{
+				`CREATE TABLE author (
+					id          SERIAL                      NOT NULL,
+					name        character varying(256)      NOT NULL,
+					bio         text                        NOT NULL DEFAULT '',
+					created_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id)
+				);`,
+				`ALTER TABLE article ADD CONSTRAINT article_author_id_fkey
+					FOREIGN KEY (author_id) REFERENCES author(id) ON DELETE SET NULL;`,
+			},
+		},
+	}
+}
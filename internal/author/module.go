@@ -0,0 +1,52 @@
+package author
+
+import (
+	"net/http"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/internal/article"
+	"github.com/agalitsyn/goapi/pkg/module"
+)
+
+func init() {
+	module.Register(&authorModule{})
+}
+
+type authorModule struct {
+	module.Base
+
+	manager *Manager
+}
+
+func (mod *authorModule) Name() string                     { return "authors" }
+func (mod *authorModule) Migrations() []*migrate.Migration { return Migrations() }
+
+// Init provides author relation of articles.
+func (mod *authorModule) Init(env *module.Env) error {
+	mod.manager = NewManager(env.DB)
+	env.Provide(article.RelationPrefix+"author", articleRelation(mod.manager))
+	return nil
+}
+
+func (mod *authorModule) Routes() map[string]http.Handler {
+	return map[string]http.Handler{"/authors": Routes(mod.manager)}
+}
+
+// articleRelation embeds author of article with ?expand=author, it is null for articles without author
+// and when author_id is not selected with ?fields.
+func articleRelation(m *Manager) article.Relation {
+	return func(a *article.Article, limit int) (interface{}, error) {
+		if a.AuthorID == nil {
+			return nil, nil
+		}
+		author, err := m.ByID(*a.AuthorID)
+		if err == ErrNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return author, nil
+	}
+}
@@ -0,0 +1,140 @@
+// Package cdc is a durable ordered feed of data changes, so downstream systems can replicate data.
+//
+// Database triggers capture rows of tables which modules pass to Capture in their migrations, each
+// change gets a sequence number. Consumers read events after their checkpoint and move it forward
+// once events are applied, events are kept until every consumer passed them.
+package cdc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Actions of events.
+const (
+	ActionCreated = "created"
+	ActionUpdated = "updated"
+	ActionDeleted = "deleted"
+)
+
+// Event is a captured change of row.
+type Event struct {
+	Seq int64 `json:"seq,string"`
+	// Source is table of row, e.g. article.
+	Source   string `json:"source"`
+	EntityID string `json:"entity_id"`
+	Action   string `json:"action"`
+	// Data is row after change, null for deletions.
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Consumer is a downstream system with checkpoint, the last event it has applied.
+type Consumer struct {
+	Name      string    `json:"name"`
+	Seq       int64     `json:"seq,string"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Manager struct {
+	db postgres.Querier
+}
+
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{db: db}
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db)}
+}
+
+// committed hides events of transactions which are running or were started after the oldest running one,
+// so an event committed late with a lower sequence number is not skipped by consumers which already passed it.
+const committed = "xid < txid_snapshot_xmin(txid_current_snapshot())"
+
+// Events returns up to limit committed events after seq in order, of sources if any are passed.
+func (m *Manager) Events(seq int64, limit int, sources []string) ([]*Event, error) {
+	query := "SELECT seq, source, entity_id, action, data, created_at FROM change_event WHERE seq > $1 AND " + committed
+	args := []interface{}{seq, limit}
+	if len(sources) > 0 {
+		query += " AND source = ANY($3)"
+		args = append(args, pq.Array(sources))
+	}
+	rows, err := m.db.Query(query+" ORDER BY seq LIMIT $2;", args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get change events")
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+	for rows.Next() {
+		e := &Event{}
+		var data []byte
+		if err := rows.Scan(&e.Seq, &e.Source, &e.EntityID, &e.Action, &data, &e.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan change event")
+		}
+		if data != nil {
+			e.Data = json.RawMessage(data)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get change events")
+	}
+	return events, nil
+}
+
+const consumerColumns = "name, seq, created_at, updated_at"
+
+// Consumer returns consumer by name.
+func (m *Manager) Consumer(name string) (*Consumer, error) {
+	c := &Consumer{}
+	err := m.db.QueryRow("SELECT "+consumerColumns+" FROM change_consumer WHERE name = $1;", name).
+		Scan(&c.Name, &c.Seq, &c.CreatedAt, &c.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get change consumer")
+	}
+	return c, nil
+}
+
+// Checkpoint sets checkpoint of consumer, consumer is created if it does not exist.
+// Events up to the checkpoint may be purged once every consumer passed them.
+func (m *Manager) Checkpoint(name string, seq int64) (*Consumer, error) {
+	c := &Consumer{}
+	err := m.db.QueryRow(
+		`INSERT INTO change_consumer(name, seq) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET seq = EXCLUDED.seq, updated_at = now()
+		RETURNING `+consumerColumns+`;`,
+		name, seq,
+	).Scan(&c.Name, &c.Seq, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not set checkpoint of change consumer")
+	}
+	return c, nil
+}
+
+// DeleteConsumer removes consumer, so events it has not passed are no longer kept for it.
+func (m *Manager) DeleteConsumer(name string) error {
+	res, err := m.db.Exec("DELETE FROM change_consumer WHERE name = $1;", name)
+	if err != nil {
+		return errors.Wrap(err, "could not delete change consumer")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
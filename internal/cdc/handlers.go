@@ -0,0 +1,199 @@
+package cdc
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+)
+
+// ReplicationRole is required to read events and manage consumers.
+const ReplicationRole = "replication"
+
+// maxEvents limits how many events are returned by a single request.
+const maxEvents = 1000
+
+func Routes(m *Manager) chi.Router {
+	r := chi.NewRouter()
+	r.Use(handler.RequireRole(ReplicationRole))
+	r.Get("/", makeHandler(m, eventsHandler))
+	r.Route("/consumers/{name}", func(r chi.Router) {
+		r.Get("/", makeHandler(m, getConsumerHandler))
+		r.Put("/", makeHandler(m, checkpointHandler))
+		r.Delete("/", makeHandler(m, deleteConsumerHandler))
+	})
+	return r
+}
+
+type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m.WithContext(r.Context()), w, r)
+	}
+}
+
+// eventsHandler responds with events after ?after sequence number, or after checkpoint of ?consumer,
+// optionally of ?source=article,attachment only. Next is passed as ?after to get the next events.
+func eventsHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "cdc")
+	q := r.URL.Query()
+
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxEvents {
+			err := i18n.Errorf("request.invalid_param", "limit", 1, maxEvents)
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		limit = n
+	}
+	var after int64
+	if v := q.Get("after"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			err := i18n.Errorf("cdc.invalid_seq", v)
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		after = n
+	} else if name := q.Get("consumer"); name != "" {
+		c, ok := consumerOf(m, w, r, name)
+		if !ok {
+			return
+		}
+		after = c.Seq
+	}
+	var sources []string
+	if v := q.Get("source"); v != "" {
+		sources = strings.Split(v, ",")
+	}
+
+	events, err := m.Events(after, limit, sources)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	resp := &eventsResponse{Events: events, Next: after}
+	if len(events) > 0 {
+		resp.Next = events[len(events)-1].Seq
+	}
+	render.Render(w, r, resp)
+}
+
+func getConsumerHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	c, ok := consumerOf(m, w, r, chi.URLParam(r, "name"))
+	if !ok {
+		return
+	}
+	render.Render(w, r, &consumerResponse{c})
+}
+
+// checkpointHandler sets checkpoint of consumer as {"seq": "42"}, consumer is registered on the first one,
+// so events it has not passed are kept for it since then.
+func checkpointHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "cdc")
+
+	name := chi.URLParam(r, "name")
+	if !validName(name) {
+		err := i18n.Errorf("cdc.invalid_consumer")
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	var data struct {
+		Seq string `json:"seq"`
+	}
+	if err := serializer.Decode(r, &data); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	seq, err := strconv.ParseInt(data.Seq, 10, 64)
+	if err != nil || seq < 0 {
+		err := i18n.Errorf("cdc.invalid_seq", data.Seq)
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	c, err := m.Checkpoint(name, seq)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.Render(w, r, &consumerResponse{c})
+}
+
+func deleteConsumerHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "cdc")
+
+	name := chi.URLParam(r, "name")
+	if err := m.DeleteConsumer(name); err != nil {
+		if err == ErrNotFound {
+			err := i18n.Errorf("cdc.consumer_not_found", name)
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	logger.WithField("consumer", name).Warn("consumer is deleted")
+	render.NoContent(w, r)
+}
+
+// consumerOf returns consumer by name, or responds with error.
+func consumerOf(m *Manager, w http.ResponseWriter, r *http.Request, name string) (*Consumer, bool) {
+	logger := log.GetLogEntry(r).WithField("context", "cdc")
+
+	c, err := m.Consumer(name)
+	if err != nil {
+		if err == ErrNotFound {
+			err := i18n.Errorf("cdc.consumer_not_found", name)
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return nil, false
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return nil, false
+	}
+	return c, true
+}
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func validName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+type eventsResponse struct {
+	Events []*Event `json:"events"`
+	Next   int64    `json:"next,string"`
+}
+
+func (er *eventsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type consumerResponse struct {
+	*Consumer
+}
+
+func (cr *consumerResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,175 @@
+package cdc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+var (
+	eventRows    = []string{"seq", "source", "entity_id", "action", "data", "created_at"}
+	consumerRows = []string{"name", "seq", "created_at", "updated_at"}
+)
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRoutes_Role(t *testing.T) {
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Mount("/anonymous", Routes(&Manager{}))
+	r.With(withUser(&reqctx.User{ID: "1"})).Mount("/user", Routes(&Manager{}))
+
+	for path, status := range map[string]int{"/anonymous": http.StatusUnauthorized, "/user": http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != status {
+			t.Errorf("%s: unexpected status: %v", path, w.Code)
+		}
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.With(withUser(&reqctx.User{ID: "1", Roles: []string{ReplicationRole}})).Mount("/changes", Routes(&Manager{db: db}))
+	now := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	// consumer reads from its checkpoint
+	mock.ExpectQuery("SELECT (.+) FROM change_consumer WHERE name = \\$1").WithArgs("search").
+		WillReturnRows(sqlmock.NewRows(consumerRows).AddRow("search", 41, now, now))
+	mock.ExpectQuery("SELECT (.+) FROM change_event WHERE seq > \\$1 AND (.+) AND source = ANY\\(\\$3\\) ORDER BY seq LIMIT \\$2").
+		WithArgs(41, 2, `{"article"}`).
+		WillReturnRows(sqlmock.NewRows(eventRows).
+			AddRow(42, "article", "1", ActionUpdated, []byte(`{"id": 1, "title": "New"}`), now).
+			AddRow(44, "article", "2", ActionDeleted, nil, now))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/changes?consumer=search&source=article&limit=2", nil))
+	var resp struct {
+		Events []*Event `json:"events"`
+		Next   string   `json:"next"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(resp.Events) != 2 || resp.Next != "44" {
+		t.Fatalf("unexpected response: %v %+v", w.Code, resp)
+	}
+	if string(resp.Events[0].Data) != `{"id":1,"title":"New"}` || string(resp.Events[1].Data) != "null" {
+		t.Errorf("unexpected data: %s %s", resp.Events[0].Data, resp.Events[1].Data)
+	}
+
+	// the end of feed keeps position
+	mock.ExpectQuery("SELECT (.+) FROM change_event WHERE seq > \\$1").WithArgs(44, 100).
+		WillReturnRows(sqlmock.NewRows(eventRows))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/changes?after=44", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"events":[],"next":"44"}`+"\n" {
+		t.Errorf("unexpected response: %v %s", w.Code, w.Body.String())
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM change_consumer").WithArgs("unknown").
+		WillReturnRows(sqlmock.NewRows(consumerRows))
+	for url, status := range map[string]int{
+		"/changes?after=-1":         http.StatusBadRequest,
+		"/changes?limit=5000":       http.StatusBadRequest,
+		"/changes?consumer=unknown": http.StatusNotFound,
+	} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != status {
+			t.Errorf("%s: unexpected status: %v", url, w.Code)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestCheckpointHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.With(withUser(&reqctx.User{ID: "1", Roles: []string{ReplicationRole}})).Mount("/changes", Routes(&Manager{db: db}))
+	now := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("INSERT INTO change_consumer").WithArgs("search", 44).
+		WillReturnRows(sqlmock.NewRows(consumerRows).AddRow("search", 44, now, now))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/changes/consumers/search", bytes.NewBufferString(`{"seq": "44"}`)))
+	var c Consumer
+	if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || c.Name != "search" || c.Seq != 44 {
+		t.Errorf("unexpected response: %v %+v", w.Code, c)
+	}
+
+	for path, body := range map[string]string{
+		"/changes/consumers/search":   `{"seq": "x"}`,
+		"/changes/consumers/a%20b":    `{"seq": "1"}`,
+		"/changes/consumers/search?x": `{"seq": "-1"}`,
+	} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, path, bytes.NewBufferString(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: unexpected status: %v", path, body, w.Code)
+		}
+	}
+
+	mock.ExpectExec("DELETE FROM change_consumer").WithArgs("search").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM change_consumer").WithArgs("search").WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, status := range []int{http.StatusNoContent, http.StatusNotFound} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/changes/consumers/search", nil))
+		if w.Code != status {
+			t.Errorf("unexpected status: %v", w.Code)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestCaptureColumns(t *testing.T) {
+	stmts := CaptureColumns("article", "title", "body")
+	if len(stmts) != 2 || stmts[0] != "DROP TRIGGER cdc_capture_update ON article;" {
+		t.Fatalf("unexpected statements %q", stmts)
+	}
+	for _, want := range []string{"AFTER UPDATE OF title, body ON article", "WHEN ((OLD.title, OLD.body) IS DISTINCT FROM (NEW.title, NEW.body))"} {
+		if !strings.Contains(stmts[1], want) {
+			t.Errorf("trigger has no %q: %s", want, stmts[1])
+		}
+	}
+	if stmts := Capture("article"); len(stmts) != 2 || !strings.Contains(stmts[1], "OLD.* IS DISTINCT FROM NEW.*") {
+		t.Errorf("unexpected statements %q", stmts)
+	}
+}
@@ -0,0 +1,16 @@
+package cdc
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"cdc.invalid_seq":        "invalid sequence number %q",
+		"cdc.invalid_consumer":   "consumer name must be 1 to 64 letters, digits, dots, dashes or underscores",
+		"cdc.consumer_not_found": "consumer %s is not found",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"cdc.invalid_seq":        "неверный порядковый номер %q",
+		"cdc.invalid_consumer":   "имя потребителя должно содержать от 1 до 64 букв, цифр, точек, дефисов или подчёркиваний",
+		"cdc.consumer_not_found": "потребитель %s не найден",
+	})
+}
@@ -0,0 +1,81 @@
+package cdc
+
+import (
+	"strings"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "0015_cdc_initial",
+			Up: []string{
+				`CREATE TABLE change_event (
+					seq         bigserial                   NOT NULL,
+					source      character varying(64)       NOT NULL,
+					entity_id   text                        NOT NULL,
+					action      character varying(16)       NOT NULL,
+					data        jsonb,
+					xid         bigint                      NOT NULL DEFAULT txid_current(),
+					created_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (seq)
+				);`,
+				`CREATE INDEX change_event_created_at_idx ON change_event (created_at);`,
+				`CREATE TABLE change_consumer (
+					name        character varying(64)       NOT NULL,
+					seq         bigint                      NOT NULL DEFAULT 0,
+					created_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					updated_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (name)
+				);`,
+				// rows are captured whole, tables must have id column
+				`CREATE FUNCTION cdc_capture() RETURNS trigger AS $$
+				BEGIN
+					IF TG_OP = 'DELETE' THEN
+						INSERT INTO change_event(source, entity_id, action) VALUES (TG_TABLE_NAME, to_jsonb(OLD)->>'id', 'deleted');
+					ELSIF TG_OP = 'INSERT' THEN
+						INSERT INTO change_event(source, entity_id, action, data) VALUES (TG_TABLE_NAME, to_jsonb(NEW)->>'id', 'created', to_jsonb(NEW));
+					ELSE
+						INSERT INTO change_event(source, entity_id, action, data) VALUES (TG_TABLE_NAME, to_jsonb(NEW)->>'id', 'updated', to_jsonb(NEW));
+					END IF;
+					RETURN NULL;
+				END;
+				$$ LANGUAGE plpgsql;`,
+			},
+		},
+	}
+}
+
+// Capture returns statements of migration which captures changes of table, its migration must
+// go after 0015_cdc_initial, so modules capturing tables import this package. Updates are captured
+// when any of columns changes, or any column at all when none are given. Columns of counters,
+// e.g. views, must be left out, otherwise every view is a change.
+func Capture(table string, columns ...string) []string {
+	return append([]string{
+		`CREATE TRIGGER cdc_capture AFTER INSERT OR DELETE ON ` + table + `
+			FOR EACH ROW EXECUTE PROCEDURE cdc_capture();`,
+	}, captureUpdate(table, columns)...)
+}
+
+// CaptureColumns returns statements of migration which restricts captured updates of table to changes
+// of columns, e.g. when table captured whole gets counters.
+func CaptureColumns(table string, columns ...string) []string {
+	return append([]string{`DROP TRIGGER cdc_capture_update ON ` + table + `;`}, captureUpdate(table, columns)...)
+}
+
+func captureUpdate(table string, columns []string) []string {
+	if len(columns) == 0 {
+		return []string{
+			`CREATE TRIGGER cdc_capture_update AFTER UPDATE ON ` + table + `
+			FOR EACH ROW WHEN (OLD.* IS DISTINCT FROM NEW.*) EXECUTE PROCEDURE cdc_capture();`,
+		}
+	}
+	list := strings.Join(columns, ", ")
+	return []string{
+		`CREATE TRIGGER cdc_capture_update AFTER UPDATE OF ` + list + ` ON ` + table + `
+			FOR EACH ROW
+			WHEN ((OLD.` + strings.Join(columns, ", OLD.") + `) IS DISTINCT FROM (NEW.` + strings.Join(columns, ", NEW.") + `))
+			EXECUTE PROCEDURE cdc_capture();`,
+	}
+}
@@ -0,0 +1,44 @@
+package cdc
+
+import (
+	"net/http"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/pkg/module"
+	"github.com/agalitsyn/goapi/pkg/retention"
+)
+
+func init() {
+	module.Register(&cdcModule{})
+}
+
+type cdcModule struct {
+	module.Base
+
+	manager *Manager
+}
+
+func (mod *cdcModule) Name() string                     { return "changes" }
+func (mod *cdcModule) Migrations() []*migrate.Migration { return Migrations() }
+
+func (mod *cdcModule) Init(env *module.Env) error {
+	mod.manager = NewManager(env.DB)
+	return nil
+}
+
+func (mod *cdcModule) Routes() map[string]http.Handler {
+	return map[string]http.Handler{"/changes": Routes(mod.manager)}
+}
+
+// RetentionRules keep events for 30 days, and longer until every registered consumer has passed them.
+func (mod *cdcModule) RetentionRules() []retention.Rule {
+	return []retention.Rule{{
+		Name:   "events",
+		Table:  "change_event",
+		Column: "created_at",
+		Where:  "seq <= (SELECT coalesce(min(seq), 9223372036854775807) FROM change_consumer)",
+		MaxAge: 30 * 24 * time.Hour,
+	}}
+}
@@ -0,0 +1,75 @@
+// Package dbpool serves admin endpoints to inspect database connection pool and tune its limits
+// under load without restart.
+package dbpool
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+)
+
+// Routes are admin endpoints to get stats and set limits of pool.
+func Routes(p *postgres.Pool) chi.Router {
+	r := chi.NewRouter()
+	r.Use(handler.RequireRole(reqctx.AdminRole))
+	r.Get("/", makeHandler(p, getHandler))
+	r.Put("/", makeHandler(p, putHandler))
+	return r
+}
+
+type handlerFunc func(p *postgres.Pool, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(p *postgres.Pool, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(p, w, r)
+	}
+}
+
+func getHandler(p *postgres.Pool, w http.ResponseWriter, r *http.Request) {
+	render.Render(w, r, newStatsResponse(p.Stats()))
+}
+
+// putHandler replaces limits, omitted ones are kept.
+func putHandler(p *postgres.Pool, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "dbpool")
+
+	limits := p.Limits()
+	if err := serializer.Decode(r, &limits); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	if err := p.SetLimits(limits); err != nil {
+		logger.WithError(err).Warn()
+		switch err {
+		case postgres.ErrNegativeLimits:
+			err = i18n.Errorf("dbpool.negative_limits")
+		case postgres.ErrIdleExceedsOpen:
+			err = i18n.Errorf("dbpool.idle_exceeds_open")
+		}
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	logger.WithField("user", reqctx.GetUser(r.Context()).ID).Warnf("pool limits are set to %+v", limits)
+	render.Render(w, r, newStatsResponse(p.Stats()))
+}
+
+func newStatsResponse(s postgres.Stats) *statsResponse {
+	return &statsResponse{s}
+}
+
+type statsResponse struct {
+	postgres.Stats
+}
+
+func (sr *statsResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package dbpool
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name   string
+		user   *reqctx.User
+		body   string
+		status int
+		limits postgres.Limits
+	}{
+		{"anonymous", nil, `{"max_open_conns": 20}`, http.StatusUnauthorized, postgres.Limits{MaxOpenConns: 10, MaxIdleConns: 2}},
+		{"user", &reqctx.User{ID: "1"}, `{"max_open_conns": 20}`, http.StatusForbidden, postgres.Limits{MaxOpenConns: 10, MaxIdleConns: 2}},
+		{"admin", &reqctx.User{ID: "2", Roles: []string{reqctx.AdminRole}}, `{"max_open_conns": 20}`, http.StatusOK, postgres.Limits{MaxOpenConns: 20, MaxIdleConns: 2}},
+		{"negative", &reqctx.User{ID: "2", Roles: []string{reqctx.AdminRole}}, `{"max_idle_conns": -1}`, http.StatusBadRequest, postgres.Limits{MaxOpenConns: 10, MaxIdleConns: 2}},
+		{"idle_exceeds_open", &reqctx.User{ID: "2", Roles: []string{reqctx.AdminRole}}, `{"max_open_conns": 1}`, http.StatusBadRequest, postgres.Limits{MaxOpenConns: 10, MaxIdleConns: 2}},
+	}
+	for _, tt := range tests {
+		p := postgres.NewPool(db, postgres.Limits{MaxOpenConns: 10, MaxIdleConns: 2})
+
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(tt.user))
+		r.Mount("/pool", Routes(p))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/pool", bytes.NewBufferString(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s: unexpected status: %v %s", tt.name, w.Code, w.Body.String())
+		}
+		if l := p.Limits(); l != tt.limits {
+			t.Errorf("%s: unexpected limits: %+v", tt.name, l)
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/pool", nil))
+		var stats postgres.Stats
+		if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || stats.Limits != tt.limits {
+			t.Errorf("%s: unexpected stats: %v %+v", tt.name, w.Code, stats)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package dbpool
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"dbpool.negative_limits":   "pool limits must not be negative",
+		"dbpool.idle_exceeds_open": "max idle connections must not exceed max open connections",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"dbpool.negative_limits":   "размеры пула не могут быть отрицательными",
+		"dbpool.idle_exceeds_open": "простаивающих соединений не может быть больше, чем открытых",
+	})
+}
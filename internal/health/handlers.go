@@ -1,17 +1,46 @@
 package health
 
 import (
+	"encoding/json"
 	"net/http"
 
 	"github.com/go-chi/chi"
 )
 
-func Routes() chi.Router {
+// Routes serves readiness probe, service is not ready while any of checks fails.
+func Routes(checks map[string]func() error) chi.Router {
 	r := chi.NewRouter()
-	r.Get("/", readinessHandler)
+	r.Get("/", readinessHandler(checks))
 	return r
 }
 
-func readinessHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(ReadinessStatus())
+// readinessHandler responds with errors of failed checks, body is empty when service is ready.
+func readinessHandler(checks map[string]func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := ReadinessStatus()
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		failed := make(map[string]string)
+		for name, check := range checks {
+			if err := check(); err != nil {
+				failed[name] = err.Error()
+			}
+		}
+		if len(failed) == 0 {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{"failed": failed})
+	}
+}
+
+// Liveness serves liveness probe, it depends on nothing but the process serving requests, so instances
+// are not restarted while database is down or during shutdown, readiness takes them out of rotation instead.
+func Liveness(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
 }
@@ -0,0 +1,43 @@
+package leader
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// Routes are admin endpoints to get leadership status of the replica serving request.
+func Routes(e *Elector) chi.Router {
+	r := chi.NewRouter()
+	r.Use(handler.RequireRole(reqctx.AdminRole))
+	r.Get("/", makeHandler(e, getHandler))
+	return r
+}
+
+type handlerFunc func(e *Elector, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(e *Elector, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(e, w, r)
+	}
+}
+
+func getHandler(e *Elector, w http.ResponseWriter, r *http.Request) {
+	render.Render(w, r, newStatusResponse(e.Status()))
+}
+
+func newStatusResponse(s Status) *statusResponse {
+	return &statusResponse{s}
+}
+
+type statusResponse struct {
+	Status
+}
+
+func (sr *statusResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package leader
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/dlock"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/module"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type testJob struct {
+	started chan struct{}
+	stop    chan struct{}
+}
+
+func (j *testJob) Run(logger log.Logger) {
+	close(j.started)
+	<-j.stop
+}
+
+func (j *testJob) Close() error {
+	close(j.stop)
+	return nil
+}
+
+func TestElector(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).WithArgs(dlock.Key(LockName)).
+		WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(false))
+	mock.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).WithArgs(dlock.Key(LockName)).
+		WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(true))
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(dlock.Key(LockName)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	jobs := make(chan *testJob, 1)
+	newJob := func() module.Job {
+		j := &testJob{started: make(chan struct{}), stop: make(chan struct{})}
+		jobs <- j
+		return j
+	}
+	fake := clock.NewFake(time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC))
+	e := New(dlock.New(db, dlock.Config{}), "replica-1", time.Second, newJob)
+	e.clock = fake
+	go e.Run(log.New("", "", ioutil.Discard))
+
+	for fake.Tickers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if s := e.Status(); s.Leader || len(jobs) != 0 {
+		t.Fatalf("follower runs jobs: %+v", s)
+	}
+
+	fake.Add(time.Second)
+	job := <-jobs
+	<-job.started
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Use(withUser(&reqctx.User{ID: "1", Roles: []string{reqctx.AdminRole}}))
+	r.Mount("/leader", Routes(e))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/leader", nil))
+	var s Status
+	if err := json.NewDecoder(w.Body).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || !s.Leader || s.Identity != "replica-1" || s.Since == nil {
+		t.Errorf("unexpected status %d %+v", w.Code, s)
+	}
+
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-job.stop:
+	default:
+		t.Error("job of leader is not closed")
+	}
+	if e.Status().Leader {
+		t.Error("leadership is not given up")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   *reqctx.User
+		status int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"user", &reqctx.User{ID: "1"}, http.StatusForbidden},
+		{"admin", &reqctx.User{ID: "2", Roles: []string{reqctx.AdminRole}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(tt.user))
+		r.Mount("/leader", Routes(New(nil, "replica-1", time.Second)))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/leader", nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: unexpected status: %v", tt.name, w.Code)
+		}
+	}
+}
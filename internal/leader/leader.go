@@ -0,0 +1,164 @@
+// Package leader elects a single replica of service to run jobs which must not run concurrently,
+// e.g. schedulers and dispatchers, and serves an admin endpoint telling whether this replica leads.
+//
+// Leadership is a distributed lock: replicas try to take it every interval, the leader holds it
+// until it stops or loses its database session, then another replica takes over.
+package leader
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/dlock"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/module"
+)
+
+// LockName is the lock held by leader.
+const LockName = "leader"
+
+// metrics are exposed with expvar as leader.{leader,elections,demotions}.
+var metrics = expvar.NewMap("leader")
+
+// Status of replica.
+type Status struct {
+	Leader   bool   `json:"leader"`
+	Identity string `json:"identity"`
+	// Since is when replica became leader.
+	Since *time.Time `json:"since,omitempty"`
+	// Elections is how many times replica became leader.
+	Elections int `json:"elections"`
+}
+
+// Elector campaigns for leadership and runs jobs while it leads. Jobs can not run again once closed,
+// so they are made anew by their factories on every election.
+type Elector struct {
+	locker   *dlock.Locker
+	identity string
+	interval time.Duration
+	jobs     []func() module.Job
+	clock    clock.Clock
+
+	mu     sync.Mutex
+	status Status
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns elector which tries to become leader every interval, identity tells replicas apart in status,
+// e.g. host name.
+func New(locker *dlock.Locker, identity string, interval time.Duration, jobs ...func() module.Job) *Elector {
+	return &Elector{
+		locker:   locker,
+		identity: identity,
+		interval: interval,
+		jobs:     jobs,
+		clock:    clock.Real,
+		status:   Status{Identity: identity},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Status returns status of this replica.
+func (e *Elector) Status() Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status
+}
+
+// Run campaigns until Close is called.
+func (e *Elector) Run(logger log.Logger) {
+	defer close(e.done)
+
+	ticker := e.clock.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		lock, err := e.locker.TryLock(context.Background(), LockName)
+		switch err {
+		case nil:
+			stopped := e.lead(lock, logger)
+			if err := lock.Unlock(); err != nil {
+				logger.WithError(err).Error()
+			}
+			if stopped {
+				return
+			}
+		case dlock.ErrNotAcquired:
+		default:
+			logger.WithError(err).Error()
+		}
+
+		select {
+		case <-ticker.C():
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// lead runs jobs until leadership is lost or Close is called, it tells whether it was the latter.
+func (e *Elector) lead(lock *dlock.Lock, logger log.Logger) bool {
+	now := e.clock.Now()
+	e.setLeader(true, &now)
+	logger.WithField("context", "leader").Infof("%s is elected leader", e.identity)
+
+	var wg sync.WaitGroup
+	jobs := make([]module.Job, len(e.jobs))
+	for i, newJob := range e.jobs {
+		jobs[i] = newJob()
+		wg.Add(1)
+		go func(job module.Job) {
+			defer wg.Done()
+			// a panicking job is logged instead of crashing the service, like other jobs
+			defer func() {
+				if r := recover(); r != nil {
+					logger.WithField("context", "leader").Errorf("job panicked: %v", r)
+				}
+			}()
+			job.Run(logger)
+		}(jobs[i])
+	}
+
+	stopped := false
+	select {
+	case <-lock.Lost():
+		logger.WithField("context", "leader").Warnf("%s lost leadership", e.identity)
+	case <-e.stop:
+		stopped = true
+	}
+	for i := len(jobs) - 1; i >= 0; i-- {
+		if err := jobs[i].Close(); err != nil {
+			logger.WithError(err).Error()
+		}
+	}
+	wg.Wait()
+	e.setLeader(false, nil)
+	return stopped
+}
+
+func (e *Elector) setLeader(leader bool, since *time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status.Leader = leader
+	e.status.Since = since
+	if leader {
+		e.status.Elections++
+		metrics.Add("elections", 1)
+		metrics.Add("leader", 1)
+	} else {
+		metrics.Add("demotions", 1)
+		metrics.Add("leader", -1)
+	}
+}
+
+// Close stops jobs and gives leadership up.
+func (e *Elector) Close() error {
+	close(e.stop)
+	<-e.done
+	return nil
+}
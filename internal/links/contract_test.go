@@ -0,0 +1,43 @@
+package links
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/contract"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/signedurl"
+)
+
+func TestContract(t *testing.T) {
+	s := signedurl.New([]byte("secret"))
+	cfg := Config{Prefixes: []string{"/1.0/attachments/"}, MaxTTL: time.Hour}
+
+	tests := []struct {
+		name string
+		user *reqctx.User
+		body string
+	}{
+		{"create", &reqctx.User{ID: "u1"}, `{"path": "/1.0/attachments/5", "ttl": 60}`},
+		{"create_unauthorized", nil, `{"path": "/1.0/attachments/5"}`},
+		{"create_invalid_path", &reqctx.User{ID: "u1"}, `{"path": "/1.0/articles/5"}`},
+		{"create_invalid_ttl", &reqctx.User{ID: "u1"}, `{"path": "/1.0/attachments/5", "ttl": 7200}`},
+	}
+	for _, tt := range tests {
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(i18n.Middleware("en"), withUser(tt.user))
+		r.Mount("/links", Routes(s, cfg))
+
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/links", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		// signature and expiry depend on current time
+		contract.Check(t, r, "links/"+tt.name, req, contract.Mask("url", "expires_at"))
+	}
+}
@@ -0,0 +1,121 @@
+// Package links serves endpoint to share protected downloads, e.g. exports and attachments, via signed
+// time-limited links.
+package links
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+	"github.com/agalitsyn/goapi/pkg/signedurl"
+)
+
+// DefaultTTL is validity of links which request does not set it.
+const DefaultTTL = 15 * time.Minute
+
+// Config limits what may be shared.
+type Config struct {
+	// Prefixes of paths which may be shared, e.g. /1.0/attachments/.
+	Prefixes []string
+	MaxTTL   time.Duration
+}
+
+// Routes sign links to paths passed as {"path": "/1.0/attachments/5", "ttl": 600}, ttl is in seconds.
+// Links are served as the user who shared them, so handlers check access as usual.
+func Routes(s *signedurl.Signer, cfg Config) chi.Router {
+	r := chi.NewRouter()
+	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		signHandler(s, cfg, w, r)
+	})
+	return r
+}
+
+func signHandler(s *signedurl.Signer, cfg Config, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "links")
+
+	u := reqctx.GetUser(r.Context())
+	if u == nil {
+		err := i18n.Errorf("links.user_required")
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrUnauthorized(err))
+		return
+	}
+
+	var data linkRequest
+	if err := serializer.Decode(r, &data); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	if err := data.validate(cfg); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+
+	ttl := DefaultTTL
+	if data.TTL > 0 {
+		ttl = time.Duration(data.TTL) * time.Second
+	}
+	if ttl > cfg.MaxTTL {
+		ttl = cfg.MaxTTL
+	}
+	link, expires, err := s.Sign(data.Path, u.ID, ttl)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	logger.WithField("user", u.ID).Infof("shared %s until %s", data.Path, expires.Format(time.RFC3339))
+
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &linkResponse{URL: link, ExpiresAt: expires})
+}
+
+type linkRequest struct {
+	Path string `json:"path"`
+	TTL  int64  `json:"ttl"`
+}
+
+func (lr *linkRequest) validate(cfg Config) error {
+	p := lr.Path
+	if i := strings.IndexByte(p, '?'); i >= 0 {
+		p = p[:i]
+	}
+	// cleaning keeps links from escaping prefixes with dot segments
+	if p == "" || path.Clean(p) != strings.TrimSuffix(p, "/") || !shareable(p, cfg.Prefixes) {
+		return i18n.Errorf("links.invalid_path", lr.Path, strings.Join(cfg.Prefixes, ", "))
+	}
+	if lr.TTL < 0 || time.Duration(lr.TTL)*time.Second > cfg.MaxTTL {
+		return i18n.Errorf("request.invalid_param", "ttl", 1, int(cfg.MaxTTL/time.Second))
+	}
+	return nil
+}
+
+func shareable(p string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type linkResponse struct {
+	// URL is a path with signed query, it is relative to the API host.
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (lr *linkResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,82 @@
+package links
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/signedurl"
+)
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	s := signedurl.New([]byte("secret"))
+	cfg := Config{Prefixes: []string{"/1.0/attachments/", "/1.0/privacy/requests/"}, MaxTTL: time.Hour}
+
+	newRouter := func(u *reqctx.User) http.Handler {
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(u), s.Middleware)
+		r.Mount("/links", Routes(s, cfg))
+		r.Get("/1.0/attachments/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(reqctx.GetUser(r.Context()).ID))
+		})
+		return r
+	}
+	r := newRouter(&reqctx.User{ID: "u1", Roles: []string{"admin"}})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://example.com/links", strings.NewReader(`{"path": "/1.0/attachments/5"}`)))
+	var resp linkResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || !strings.HasPrefix(resp.URL, "/1.0/attachments/5?") {
+		t.Errorf("unexpected response: %v %+v", w.Code, resp)
+	}
+	if d := time.Until(resp.ExpiresAt); d <= 0 || d > DefaultTTL {
+		t.Errorf("unexpected expiry: %v", resp.ExpiresAt)
+	}
+
+	// link is served to anonymous clients as the user who shared it
+	w = httptest.NewRecorder()
+	newRouter(nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com"+resp.URL, nil))
+	if w.Code != http.StatusOK || w.Body.String() != "u1" {
+		t.Errorf("unexpected response of link: %v %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		router http.Handler
+		body   string
+		status int
+	}{
+		{newRouter(nil), `{"path": "/1.0/attachments/5"}`, http.StatusUnauthorized},
+		{r, `{"path": "/1.0/articles/5"}`, http.StatusBadRequest},
+		{r, `{"path": "/1.0/attachments/../articles/5"}`, http.StatusBadRequest},
+		{r, `{"path": "/1.0/attachments/5", "ttl": 7200}`, http.StatusBadRequest},
+		{r, `{"path": "/1.0/privacy/requests/5/data", "ttl": 60}`, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://example.com/links", strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s: unexpected status: %v", tt.body, w.Code)
+		}
+	}
+}
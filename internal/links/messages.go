@@ -0,0 +1,14 @@
+package links
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"links.user_required": "only signed-in users may share links",
+		"links.invalid_path":  "%s may not be shared, paths must start with one of: %s",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"links.user_required": "делиться ссылками могут только авторизованные пользователи",
+		"links.invalid_path":  "%s нельзя расшарить, путь должен начинаться с одного из: %s",
+	})
+}
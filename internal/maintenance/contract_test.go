@@ -0,0 +1,43 @@
+package maintenance
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/contract"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+func TestContract(t *testing.T) {
+	m := New("", time.Minute)
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Use(i18n.Middleware("en"))
+	r.Use(withUser(&reqctx.User{ID: "1", Roles: []string{reqctx.AdminRole}}))
+	r.Use(Middleware(m, "/1.0/admin/"))
+	r.Get("/1.0/articles", func(w http.ResponseWriter, r *http.Request) {})
+	r.Mount("/1.0/admin/maintenance", Routes(m))
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+	}{
+		{"get_disabled", http.MethodGet, "/1.0/admin/maintenance", ""},
+		{"enable", http.MethodPut, "/1.0/admin/maintenance", `{"message": "back at 10:00", "retry_after": 600}`},
+		{"unavailable", http.MethodGet, "/1.0/articles", ""},
+		{"disable", http.MethodDelete, "/1.0/admin/maintenance", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "http://example.com"+tt.url, strings.NewReader(tt.body))
+		contract.Check(t, r, "maintenance/"+tt.name, req, contract.Mask("started_at"))
+	}
+}
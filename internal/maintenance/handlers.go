@@ -0,0 +1,112 @@
+package maintenance
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+)
+
+// Routes are admin endpoints to get, enable and disable maintenance mode.
+func Routes(m *Mode) chi.Router {
+	r := chi.NewRouter()
+	r.Use(handler.RequireRole(reqctx.AdminRole))
+	r.Get("/", makeHandler(m, getHandler))
+	r.Put("/", makeHandler(m, putHandler))
+	r.Delete("/", makeHandler(m, deleteHandler))
+	return r
+}
+
+type handlerFunc func(m *Mode, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Mode, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m, w, r)
+	}
+}
+
+func getHandler(m *Mode, w http.ResponseWriter, r *http.Request) {
+	render.Render(w, r, newStateResponse(m.State()))
+}
+
+func putHandler(m *Mode, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "maintenance")
+
+	var data struct {
+		Message    string `json:"message"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	if err := serializer.Decode(r, &data); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	state := m.Enable(data.Message, time.Duration(data.RetryAfter)*time.Second)
+	logger.WithField("user", reqctx.GetUser(r.Context()).ID).Warn("maintenance mode enabled")
+	render.Render(w, r, newStateResponse(state))
+}
+
+func deleteHandler(m *Mode, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "maintenance")
+
+	state := m.Disable()
+	logger.WithField("user", reqctx.GetUser(r.Context()).ID).Warn("maintenance mode disabled")
+	render.Render(w, r, newStateResponse(state))
+}
+
+func newStateResponse(s State) *stateResponse {
+	return &stateResponse{s}
+}
+
+type stateResponse struct {
+	State
+}
+
+func (sr *stateResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Middleware responds with 503 and Retry-After while mode is enabled.
+// Requests to exempt path prefixes, e.g. health checks and admin endpoints, are served as usual.
+func Middleware(m *Mode, exempt ...string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state := m.State()
+			if !state.Enabled || isExempt(r.URL.Path, exempt) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			locale := reqctx.GetLocale(r.Context())
+			detail := state.Message
+			if detail == "" {
+				detail = i18n.Translate(locale, "maintenance.in_progress")
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(state.RetryAfter))
+			handler.WriteProblem(w, &handler.Problem{
+				Title:    i18n.StatusText(locale, http.StatusServiceUnavailable),
+				Status:   http.StatusServiceUnavailable,
+				Detail:   detail,
+				Instance: r.URL.Path,
+			})
+		})
+	}
+}
+
+func isExempt(path string, exempt []string) bool {
+	for _, prefix := range exempt {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package maintenance
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	m := New("", time.Minute)
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Use(Middleware(m, "/readiness"))
+	r.Get("/articles", func(w http.ResponseWriter, r *http.Request) {})
+	r.Get("/readiness", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/articles", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+
+	m.Enable("back at 10:00", 0)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/articles", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "60" {
+		t.Errorf("unexpected Retry-After: %v", ra)
+	}
+	var p handler.Problem
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Detail != "back at 10:00" {
+		t.Errorf("unexpected detail: %v", p.Detail)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/readiness", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("exempt route is unavailable: %v", w.Code)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   *reqctx.User
+		status int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"user", &reqctx.User{ID: "1"}, http.StatusForbidden},
+		{"admin", &reqctx.User{ID: "2", Roles: []string{reqctx.AdminRole}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		m := New("", time.Minute)
+
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(tt.user))
+		r.Mount("/maintenance", Routes(m))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/maintenance", bytes.NewBufferString(`{"retry_after": 120}`)))
+		if w.Code != tt.status {
+			t.Errorf("%s: unexpected status: %v", tt.name, w.Code)
+		}
+		if s := m.State(); s.Enabled != (tt.status == http.StatusOK) {
+			t.Errorf("%s: unexpected state: %+v", tt.name, s)
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if s := m.State(); s.RetryAfter != 120 || s.Source != "api" {
+			t.Errorf("%s: unexpected state: %+v", tt.name, s)
+		}
+
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "http://example.com/maintenance", nil))
+		if w.Code != http.StatusOK || m.State().Enabled {
+			t.Errorf("%s: maintenance is not disabled: %v", tt.name, w.Code)
+		}
+	}
+}
+
+func TestMode_CheckFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maintenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "maintenance")
+
+	m := New(file, time.Minute)
+	if err := m.CheckFile(); err != nil || m.State().Enabled {
+		t.Fatalf("unexpected state: %+v, %v", m.State(), err)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("upgrading database\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CheckFile(); err != nil {
+		t.Fatal(err)
+	}
+	if s := m.State(); !s.Enabled || s.Message != "upgrading database" || s.Source != "file" {
+		t.Errorf("unexpected state: %+v", s)
+	}
+
+	// disabling with API does not override the file
+	m.Disable()
+	if !m.State().Enabled {
+		t.Error("maintenance is disabled while file exists")
+	}
+
+	os.Remove(file)
+	if err := m.CheckFile(); err != nil || m.State().Enabled {
+		t.Errorf("unexpected state: %+v, %v", m.State(), err)
+	}
+}
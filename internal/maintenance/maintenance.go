@@ -0,0 +1,144 @@
+// Package maintenance switches API to maintenance mode at runtime, with admin endpoint or flag file.
+package maintenance
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// State of maintenance mode.
+type State struct {
+	Enabled bool `json:"enabled"`
+	// Message is shown to clients instead of the default one.
+	Message string `json:"message,omitempty"`
+	// RetryAfter is how many seconds clients should wait before retrying.
+	RetryAfter int        `json:"retry_after"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	// Source is api or file.
+	Source string `json:"source,omitempty"`
+}
+
+// Mode is enabled with Enable or by creating flag file, its content is used as message.
+// Mode enabled with API takes precedence over the file.
+type Mode struct {
+	file       string
+	retryAfter time.Duration
+	clock      clock.Clock
+
+	mu       sync.RWMutex
+	api      *State
+	fromFile *State
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates disabled mode, flag file is not watched when path is empty.
+func New(file string, retryAfter time.Duration) *Mode {
+	return &Mode{
+		file:       file,
+		retryAfter: retryAfter,
+		clock:      clock.Real,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// State returns current state.
+func (m *Mode) State() State {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	switch {
+	case m.api != nil:
+		return *m.api
+	case m.fromFile != nil:
+		return *m.fromFile
+	}
+	return State{RetryAfter: int(m.retryAfter.Seconds())}
+}
+
+// Enable enables mode, default retry interval is used when retryAfter is zero.
+func (m *Mode) Enable(message string, retryAfter time.Duration) State {
+	if retryAfter <= 0 {
+		retryAfter = m.retryAfter
+	}
+	now := m.clock.Now().UTC()
+	m.mu.Lock()
+	m.api = &State{
+		Enabled:    true,
+		Message:    message,
+		RetryAfter: int(retryAfter.Seconds()),
+		StartedAt:  &now,
+		Source:     "api",
+	}
+	m.mu.Unlock()
+	return m.State()
+}
+
+// Disable disables mode enabled with API, mode stays enabled while flag file exists.
+func (m *Mode) Disable() State {
+	m.mu.Lock()
+	m.api = nil
+	m.mu.Unlock()
+	return m.State()
+}
+
+// CheckFile enables or disables mode depending on flag file presence.
+func (m *Mode) CheckFile() error {
+	if m.file == "" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(m.file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.fromFile = nil
+		return nil
+	}
+	message := strings.TrimSpace(string(content))
+	if m.fromFile == nil || m.fromFile.Message != message {
+		now := m.clock.Now().UTC()
+		m.fromFile = &State{
+			Enabled:    true,
+			Message:    message,
+			RetryAfter: int(m.retryAfter.Seconds()),
+			StartedAt:  &now,
+			Source:     "file",
+		}
+	}
+	return nil
+}
+
+// Run checks flag file every interval until Close is called.
+func (m *Mode) Run(interval time.Duration, logger log.Logger) {
+	defer close(m.done)
+
+	ticker := m.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := m.CheckFile(); err != nil {
+				logger.WithError(err).Error("could not check maintenance flag file")
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Close stops Run.
+func (m *Mode) Close() {
+	close(m.stop)
+	<-m.done
+}
@@ -0,0 +1,12 @@
+package maintenance
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"maintenance.in_progress": "service is under maintenance, please retry later",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"maintenance.in_progress": "идут технические работы, повторите запрос позже",
+	})
+}
@@ -0,0 +1,61 @@
+package moderation
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/agalitsyn/goapi/internal/article"
+	"github.com/agalitsyn/goapi/pkg/surrogate"
+)
+
+// articleTarget makes articles reportable. Hidden articles keep their status, the article module leaves
+// them out of reads, feeds and sitemap and refuses their updates until reports are dismissed.
+type articleTarget struct {
+	m      *article.Manager
+	purger surrogate.Purger
+}
+
+func (t *articleTarget) Exists(id string) error {
+	_, err := t.byID(id)
+	return err
+}
+
+// Hide needs no state to restore, the article is flagged only.
+func (t *articleTarget) Hide(id string) (string, error) {
+	if _, err := t.byID(id); err != nil {
+		return "", err
+	}
+	return "", t.setHidden(id, true)
+}
+
+// Restore unhides article unless it is deleted since it is hidden.
+func (t *articleTarget) Restore(id, state string) error {
+	err := t.setHidden(id, false)
+	if err == article.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// byID treats invalid ids as missing articles, so they are not reported.
+func (t *articleTarget) byID(id string) (*article.Article, error) {
+	if n, err := strconv.ParseInt(id, 10, 32); err != nil || n <= 0 {
+		return nil, ErrNotFound
+	}
+	a, err := t.m.ByID(id)
+	if err == article.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return a, err
+}
+
+// setHidden flags article and purges its cached responses like the articles API does.
+func (t *articleTarget) setHidden(id string, hidden bool) error {
+	if err := t.m.SetHidden(id, hidden); err != nil {
+		return err
+	}
+	if t.purger == nil {
+		return nil
+	}
+	return t.purger.Purge(context.Background(), article.ListSurrogateKey, article.SurrogateKey(id))
+}
@@ -0,0 +1,341 @@
+package moderation
+
+import (
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/sanitize"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+)
+
+const (
+	// ModeratorRole is required to list and resolve reports, admins may do it too.
+	ModeratorRole = "moderator"
+
+	reasonEnum = "report_reason"
+	// maxCommentLength is in characters.
+	maxCommentLength = 1000
+)
+
+// Routes let users report content, hideThreshold is how many open reports hide content, 0 disables hiding.
+func Routes(m *Manager, hideThreshold int) chi.Router {
+	r := chi.NewRouter()
+
+	r.Post("/", makeHandler(m, createHandler(hideThreshold)))
+	r.Get("/reasons", reasonsHandler)
+
+	return r
+}
+
+// AdminRoutes let moderators review reports.
+func AdminRoutes(m *Manager) chi.Router {
+	r := chi.NewRouter()
+	r.Use(requireModerator)
+
+	r.Get("/", makeHandler(m, listHandler))
+	r.Route("/{reportID}", func(r chi.Router) {
+		r.Get("/", makeHandler(m, getHandler))
+		r.Post("/resolve", makeHandler(m, resolveHandler))
+	})
+
+	return r
+}
+
+type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m.WithContext(r.Context()), w, r)
+	}
+}
+
+func requireModerator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := reqctx.GetUser(r.Context())
+		if u == nil {
+			render.Render(w, r, handler.ErrUnauthorized(i18n.Errorf("moderation.moderator_required")))
+			return
+		}
+		if !u.HasRole(ModeratorRole) && !u.HasRole(reqctx.AdminRole) {
+			render.Render(w, r, handler.ErrForbidden(i18n.Errorf("moderation.moderator_required")))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// createHandler reports content as {"target_type": "article", "target_id": "42", "reason": "spam"}, content
+// is hidden once it has hideThreshold open reports. On dry run report is validated but not saved.
+func createHandler(hideThreshold int) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "moderation")
+		dryRun := handler.DryRun(w, r)
+
+		u := reqctx.GetUser(r.Context())
+		if u == nil {
+			err := i18n.Errorf("moderation.user_required")
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrUnauthorized(err))
+			return
+		}
+
+		var data reportRequest
+		if err := serializer.Decode(r, &data); err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if err := data.validate(); err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		target, ok := m.Target(data.TargetType)
+		if !ok {
+			err := i18n.Errorf("moderation.invalid_target", data.TargetType)
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if err := target.Exists(data.TargetID); err != nil {
+			if err == ErrNotFound {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrNotFound(err))
+				return
+			}
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+
+		report := &Report{
+			TargetType: data.TargetType,
+			TargetID:   data.TargetID,
+			UserID:     u.ID,
+			Reason:     data.Reason,
+			Comment:    data.Comment,
+		}
+		var open int
+		err := m.Tx(dryRun, func(m *Manager) (err error) {
+			open, err = m.Save(report)
+			return err
+		})
+		if err == ErrReported {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrConflict(err))
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+
+		// report is saved anyway, content is hidden on the next report or by moderator
+		if !dryRun && hideThreshold > 0 && open >= hideThreshold {
+			hidden, err := m.Hide(report.TargetType, report.TargetID)
+			if err != nil {
+				logger.WithError(err).Error("could not hide reported content")
+			} else if hidden {
+				logger.WithField("target_type", report.TargetType).WithField("target_id", report.TargetID).
+					WithField("reports", open).Info("reported content is hidden pending review")
+			}
+		}
+		render.Status(r, http.StatusCreated)
+		render.Render(w, r, newReportResponse(report))
+	}
+}
+
+// reasonsHandler lists reasons with labels in the request locale.
+func reasonsHandler(w http.ResponseWriter, r *http.Request) {
+	values := make([]string, 0, len(Reasons))
+	for _, v := range Reasons {
+		values = append(values, string(v))
+	}
+	render.JSON(w, r, i18n.Labels(reqctx.GetLocale(r.Context()), reasonEnum, values...))
+}
+
+// listHandler accepts ?status= which is open by default, "all" lists reports of any status,
+// ?target_type= and ?target_id= of reported content and ?limit=.
+func listHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "moderation")
+
+	q := Query{
+		Status:     Status(r.URL.Query().Get("status")),
+		TargetType: r.URL.Query().Get("target_type"),
+		TargetID:   r.URL.Query().Get("target_id"),
+	}
+	switch q.Status {
+	case "":
+		q.Status = StatusOpen
+	case "all":
+		q.Status = ""
+	default:
+		if !q.Status.valid() {
+			err := i18n.Errorf("moderation.invalid_status", q.Status)
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+	}
+	limit := r.URL.Query().Get("limit")
+	if limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > 500 {
+			err := i18n.Errorf("request.invalid_param", "limit", 1, 500)
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		q.Limit = n
+	}
+
+	reports, err := m.Find(q)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	if err := render.RenderList(w, r, newReportListResponse(reports)); err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+}
+
+func getHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "moderation")
+
+	report, err := m.ByID(chi.URLParam(r, "reportID"))
+	if err != nil {
+		if err == ErrNotFound {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.Render(w, r, newReportResponse(report))
+}
+
+// resolveHandler resolves open reports of content as {"status": "dismissed", "note": "not spam"}, see
+// Manager.Resolve. Actioned content is hidden, dismissed content is restored if reports have hidden it.
+// On dry run the resolved report is rendered, but nothing is changed.
+func resolveHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "moderation")
+	dryRun := handler.DryRun(w, r)
+
+	var data resolveRequest
+	if err := serializer.Decode(r, &data); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	if err := data.validate(); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+
+	var report *Report
+	err := m.Tx(dryRun, func(m *Manager) (err error) {
+		report, err = m.Resolve(chi.URLParam(r, "reportID"), data.Status, reqctx.GetUser(r.Context()).ID, data.Note)
+		return err
+	})
+	if err != nil {
+		switch err {
+		case ErrNotFound:
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+		case ErrResolved:
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrConflict(err))
+		default:
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+		}
+		return
+	}
+
+	// resolution is saved anyway, moderator may resolve new reports of the content again
+	if !dryRun {
+		var err error
+		if report.Status == StatusActioned {
+			_, err = m.Hide(report.TargetType, report.TargetID)
+		} else {
+			err = m.Unhide(report.TargetType, report.TargetID)
+		}
+		if err != nil && err != ErrNotFound {
+			logger.WithError(err).Error("could not change visibility of reported content")
+		}
+	}
+	render.Render(w, r, newReportResponse(report))
+}
+
+type reportRequest struct {
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+	Reason     Reason `json:"reason"`
+	Comment    string `json:"comment"`
+}
+
+// validate strips HTML of comment and checks request.
+func (rr *reportRequest) validate() error {
+	if !rr.Reason.valid() {
+		return i18n.Errorf("moderation.invalid_reason", rr.Reason)
+	}
+	if rr.TargetID == "" {
+		return i18n.Errorf("moderation.target_required")
+	}
+	rr.Comment = sanitize.TextPolicy.Sanitize(rr.Comment)
+	if utf8.RuneCountInString(rr.Comment) > maxCommentLength {
+		return i18n.Errorf("moderation.long_comment", maxCommentLength)
+	}
+	return nil
+}
+
+type resolveRequest struct {
+	Status Status `json:"status"`
+	Note   string `json:"note"`
+}
+
+func (rr *resolveRequest) validate() error {
+	if rr.Status != StatusDismissed && rr.Status != StatusActioned {
+		return i18n.Errorf("moderation.invalid_resolution", rr.Status)
+	}
+	rr.Note = sanitize.TextPolicy.Sanitize(rr.Note)
+	if utf8.RuneCountInString(rr.Note) > maxCommentLength {
+		return i18n.Errorf("moderation.long_comment", maxCommentLength)
+	}
+	return nil
+}
+
+func newReportListResponse(reports []*Report) []render.Renderer {
+	list := []render.Renderer{}
+	for _, report := range reports {
+		list = append(list, newReportResponse(report))
+	}
+	return list
+}
+
+func newReportResponse(report *Report) *reportResponse {
+	return &reportResponse{Report: report}
+}
+
+type reportResponse struct {
+	*Report
+}
+
+func (rr *reportResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,226 @@
+package moderation
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var reportColumnNames = []string{"id", "target_type", "target_id", "user_id", "reason", "comment", "status", "note", "created_at", "resolved_at", "resolved_by"}
+
+type testTarget struct {
+	hidden   []string
+	restored []string
+}
+
+func (t *testTarget) Exists(id string) error {
+	if id != "42" {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (t *testTarget) Hide(id string) (string, error) {
+	t.hidden = append(t.hidden, id)
+	return "published", nil
+}
+
+func (t *testTarget) Restore(id, state string) error {
+	t.restored = append(t.restored, id+":"+state)
+	return nil
+}
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	target := &testTarget{}
+	m := NewManager(db, map[string]Target{"article": target})
+	newRouter := func(u *reqctx.User) http.Handler {
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(u))
+		r.Mount("/reports", Routes(m, 2))
+		return r
+	}
+	r := newRouter(&reqctx.User{ID: "u1"})
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://example.com/reports", strings.NewReader(body)))
+		return w
+	}
+
+	// content is hidden once it has enough reports
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO report(.+) ON CONFLICT \\(target_type, target_id, user_id\\) WHERE status = 'open' DO NOTHING").
+		WithArgs("article", "42", "u1", ReasonSpam, "buy now").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "created_at"}).AddRow(7, "open", time.Now()))
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM report").
+		WithArgs("article", "42").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectCommit()
+	mock.ExpectExec("INSERT INTO report_hidden").
+		WithArgs("article", "42").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE report_hidden SET state = \\$3").
+		WithArgs("article", "42", "published").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := post(`{"target_type": "article", "target_id": "42", "reason": "spam", "comment": "<b>buy now</b>"}`)
+	var report Report
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || report.ID != "7" || report.Status != StatusOpen || report.UserID != "u1" {
+		t.Errorf("unexpected response: %v %+v", w.Code, report)
+	}
+	if len(target.hidden) != 1 {
+		t.Errorf("reported content is not hidden: %v", target.hidden)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO report").
+		WithArgs("article", "42", "u1", ReasonSpam, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "created_at"}))
+	mock.ExpectRollback()
+	if w := post(`{"target_type": "article", "target_id": "42", "reason": "spam"}`); w.Code != http.StatusConflict {
+		t.Errorf("unexpected status of repeated report: %v", w.Code)
+	}
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"target_type": "article", "target_id": "42", "reason": "boring"}`, http.StatusBadRequest},
+		{`{"target_type": "comment", "target_id": "42", "reason": "spam"}`, http.StatusBadRequest},
+		{`{"target_type": "article", "target_id": "43", "reason": "spam"}`, http.StatusNotFound},
+		{`{"target_type": "article", "target_id": "42", "reason": "other", "comment": "` + strings.Repeat("a", 1001) + `"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := post(tt.body); w.Code != tt.status {
+			t.Errorf("%.80s: unexpected status: %v", tt.body, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	newRouter(nil).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://example.com/reports", strings.NewReader(`{}`)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected status of anonymous user: %v", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	target := &testTarget{}
+	m := NewManager(db, map[string]Target{"article": target})
+	newRouter := func(u *reqctx.User) http.Handler {
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(u))
+		r.Mount("/admin/moderation/reports", AdminRoutes(m))
+		return r
+	}
+	r := newRouter(&reqctx.User{ID: "mod", Roles: []string{ModeratorRole}})
+
+	w := httptest.NewRecorder()
+	newRouter(&reqctx.User{ID: "u1"}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/admin/moderation/reports", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("unexpected status of user without role: %v", w.Code)
+	}
+
+	// open reports are listed by default
+	mock.ExpectQuery("SELECT (.+) FROM report WHERE (.+) ORDER BY created_at, id LIMIT \\$4;").
+		WithArgs(StatusOpen, "article", "", defaultLimit).
+		WillReturnRows(sqlmock.NewRows(reportColumnNames).AddRow(7, "article", "42", "u1", "spam", "", "open", "", time.Now(), nil, nil))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/admin/moderation/reports?target_type=article", nil))
+	var reports []Report
+	if err := json.NewDecoder(w.Body).Decode(&reports); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(reports) != 1 || reports[0].ResolvedAt != nil {
+		t.Errorf("unexpected response: %v %+v", w.Code, reports)
+	}
+
+	// dismissed reports restore hidden content
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM report WHERE id = \\$1;").
+		WithArgs("7").
+		WillReturnRows(sqlmock.NewRows(reportColumnNames).AddRow(7, "article", "42", "u1", "spam", "", "open", "", now, nil, nil))
+	mock.ExpectExec("UPDATE report SET status = \\$3, note = \\$4, resolved_at = now\\(\\), resolved_by = \\$5").
+		WithArgs("article", "42", StatusDismissed, "not spam", "mod").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery("SELECT (.+) FROM report WHERE id = \\$1;").
+		WithArgs("7").
+		WillReturnRows(sqlmock.NewRows(reportColumnNames).AddRow(7, "article", "42", "u1", "spam", "", "dismissed", "not spam", now, now, "mod"))
+	mock.ExpectCommit()
+	mock.ExpectQuery("DELETE FROM report_hidden WHERE target_type = \\$1 AND target_id = \\$2 RETURNING state;").
+		WithArgs("article", "42").
+		WillReturnRows(sqlmock.NewRows([]string{"state"}).AddRow("published"))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://example.com/admin/moderation/reports/7/resolve", strings.NewReader(`{"status": "dismissed", "note": "not spam"}`)))
+	var report Report
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || report.Status != StatusDismissed || report.ResolvedBy == nil || *report.ResolvedBy != "mod" {
+		t.Errorf("unexpected response: %v %+v", w.Code, report)
+	}
+	if len(target.restored) != 1 || target.restored[0] != "42:published" {
+		t.Errorf("hidden content is not restored: %v", target.restored)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM report WHERE id = \\$1;").
+		WithArgs("7").
+		WillReturnRows(sqlmock.NewRows(reportColumnNames).AddRow(7, "article", "42", "u1", "spam", "", "dismissed", "not spam", now, now, "mod"))
+	mock.ExpectRollback()
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://example.com/admin/moderation/reports/7/resolve", strings.NewReader(`{"status": "actioned"}`)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("unexpected status of resolved report: %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://example.com/admin/moderation/reports/7/resolve", strings.NewReader(`{"status": "open"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status of invalid resolution: %v", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
@@ -0,0 +1,42 @@
+package moderation
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"moderation.user_required":      "sign in to report content",
+		"moderation.moderator_required": "moderator role is required",
+		"moderation.invalid_reason":     "unknown reason %s",
+		"moderation.invalid_target":     "content of type %q can not be reported",
+		"moderation.target_required":    "target_id is required",
+		"moderation.long_comment":       "comment is longer than %d characters",
+		"moderation.invalid_status":     "unknown status %s",
+		"moderation.invalid_resolution": "reports are resolved as dismissed or actioned, got %q",
+		"moderation.reported":           "you have already reported it",
+		"moderation.resolved":           "report is already resolved",
+
+		"enum.report_reason.spam":      "Spam",
+		"enum.report_reason.abuse":     "Abuse or harassment",
+		"enum.report_reason.illegal":   "Illegal content",
+		"enum.report_reason.off_topic": "Off topic",
+		"enum.report_reason.other":     "Other",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"moderation.user_required":      "войдите, чтобы пожаловаться",
+		"moderation.moderator_required": "требуется роль модератора",
+		"moderation.invalid_reason":     "неизвестная причина %s",
+		"moderation.invalid_target":     "на содержимое типа %q нельзя пожаловаться",
+		"moderation.target_required":    "необходимо указать target_id",
+		"moderation.long_comment":       "комментарий длиннее %d символов",
+		"moderation.invalid_status":     "неизвестный статус %s",
+		"moderation.invalid_resolution": "жалобы закрываются со статусом dismissed или actioned, получено %q",
+		"moderation.reported":           "вы уже пожаловались на это",
+		"moderation.resolved":           "жалоба уже рассмотрена",
+
+		"enum.report_reason.spam":      "Спам",
+		"enum.report_reason.abuse":     "Оскорбления или травля",
+		"enum.report_reason.illegal":   "Незаконное содержимое",
+		"enum.report_reason.off_topic": "Не по теме",
+		"enum.report_reason.other":     "Другое",
+	})
+}
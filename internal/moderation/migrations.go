@@ -0,0 +1,39 @@
+package moderation
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "0021_report",
+			Up: []string{
+				`CREATE TABLE report (
+					id           SERIAL                      NOT NULL,
+					target_type  character varying(32)       NOT NULL,
+					target_id    character varying(64)       NOT NULL,
+					user_id      character varying(128)      NOT NULL,
+					reason       character varying(16)       NOT NULL,
+					comment      text                        NOT NULL DEFAULT '',
+					status       character varying(16)       NOT NULL DEFAULT 'open',
+					note         text                        NOT NULL DEFAULT '',
+					created_at   timestamp with time zone    NOT NULL DEFAULT now(),
+					resolved_at  timestamp with time zone,
+					resolved_by  character varying(128),
+					PRIMARY KEY (id)
+				);`,
+				// user reports content once until moderator resolves the report
+				`CREATE UNIQUE INDEX report_open_idx ON report (target_type, target_id, user_id) WHERE status = 'open';`,
+				`CREATE INDEX report_status_idx ON report (status, created_at);`,
+				`CREATE INDEX report_user_idx ON report (user_id);`,
+				// state is what content module needs to restore content, e.g. status of article
+				`CREATE TABLE report_hidden (
+					target_type  character varying(32)       NOT NULL,
+					target_id    character varying(64)       NOT NULL,
+					state        text                        NOT NULL DEFAULT '',
+					hidden_at    timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (target_type, target_id)
+				);`,
+			},
+		},
+	}
+}
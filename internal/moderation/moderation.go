@@ -0,0 +1,306 @@
+// Package moderation lets users report content, e.g. spam articles, and moderators resolve reports.
+// Content reported by many users is hidden until a moderator looks at it.
+package moderation
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrReported is returned when user has an open report of the same content.
+	ErrReported = i18n.Errorf("moderation.reported")
+	// ErrResolved is returned when report is already resolved.
+	ErrResolved = i18n.Errorf("moderation.resolved")
+)
+
+// TargetPrefix is a prefix of names modules provide reportable content with, e.g. moderation.target.article.
+const TargetPrefix = "moderation.target."
+
+// Target is a kind of reportable content.
+type Target interface {
+	// Exists returns ErrNotFound when there is no content with id.
+	Exists(id string) error
+	// Hide takes content out of public view, state is what Restore needs to bring it back.
+	Hide(id string) (state string, err error)
+	Restore(id, state string) error
+}
+
+// Reason is why content is reported.
+type Reason string
+
+const (
+	ReasonSpam     Reason = "spam"
+	ReasonAbuse    Reason = "abuse"
+	ReasonIllegal  Reason = "illegal"
+	ReasonOffTopic Reason = "off_topic"
+	ReasonOther    Reason = "other"
+)
+
+// defaultLimit is how many reports are listed unless query tells otherwise.
+const defaultLimit = 50
+
+// Reasons lists all reasons in display order.
+var Reasons = []Reason{ReasonSpam, ReasonAbuse, ReasonIllegal, ReasonOffTopic, ReasonOther}
+
+func (r Reason) valid() bool {
+	for _, v := range Reasons {
+		if r == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Status is a state of report, open reports wait for a moderator.
+type Status string
+
+const (
+	StatusOpen Status = "open"
+	// StatusDismissed is for reports of acceptable content, hidden content is restored.
+	StatusDismissed Status = "dismissed"
+	// StatusActioned is for reports of content moderator acted upon, hidden content stays hidden.
+	StatusActioned Status = "actioned"
+)
+
+// Statuses lists all statuses in display order.
+var Statuses = []Status{StatusOpen, StatusDismissed, StatusActioned}
+
+func (s Status) valid() bool {
+	for _, v := range Statuses {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+type Report struct {
+	ID         string     `json:"id"`
+	TargetType string     `json:"target_type"`
+	TargetID   string     `json:"target_id"`
+	UserID     string     `json:"user_id"`
+	Reason     Reason     `json:"reason"`
+	Comment    string     `json:"comment"`
+	Status     Status     `json:"status"`
+	Note       string     `json:"note"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	ResolvedBy *string    `json:"resolved_by"`
+}
+
+// Query filters reports, empty fields match any.
+type Query struct {
+	Status     Status
+	TargetType string
+	TargetID   string
+	Limit      int
+}
+
+type Manager struct {
+	db      postgres.Querier
+	targets map[string]Target
+}
+
+func NewManager(db *sql.DB, targets map[string]Target) *Manager {
+	return &Manager{db: db, targets: targets}
+}
+
+// Tx runs fn with manager bound to a transaction, which is rolled back when fn fails or on dry run.
+func (m *Manager) Tx(dryRun bool, fn func(m *Manager) error) error {
+	return postgres.Tx(m.db, dryRun, func(tx postgres.Querier) error {
+		return fn(&Manager{db: tx, targets: m.targets})
+	})
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db), targets: m.targets}
+}
+
+// Target returns reportable content by type.
+func (m *Manager) Target(typ string) (Target, bool) {
+	t, ok := m.targets[typ]
+	return t, ok
+}
+
+// Save creates report and returns how many open reports its content has, including this one.
+// User reports content once until the report is resolved, ErrReported is returned on repeat.
+func (m *Manager) Save(r *Report) (int, error) {
+	err := m.db.QueryRow(
+		`INSERT INTO report(target_type, target_id, user_id, reason, comment) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (target_type, target_id, user_id) WHERE status = 'open' DO NOTHING
+		RETURNING id, status, created_at;`,
+		r.TargetType, r.TargetID, r.UserID, r.Reason, r.Comment,
+	).Scan(&r.ID, &r.Status, &r.CreatedAt)
+	if err == sql.ErrNoRows {
+		return 0, ErrReported
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "could not save report")
+	}
+
+	var open int
+	err = m.db.QueryRow(
+		"SELECT count(*) FROM report WHERE target_type = $1 AND target_id = $2 AND status = 'open';",
+		r.TargetType, r.TargetID,
+	).Scan(&open)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not count open reports")
+	}
+	return open, nil
+}
+
+// Hide hides reported content unless it is hidden already, it reports whether content is hidden now.
+// Content is changed by its module outside of transaction of manager.
+func (m *Manager) Hide(typ, id string) (bool, error) {
+	t, ok := m.targets[typ]
+	if !ok {
+		return false, ErrNotFound
+	}
+	res, err := m.db.Exec(
+		"INSERT INTO report_hidden(target_type, target_id) VALUES ($1, $2) ON CONFLICT DO NOTHING;",
+		typ, id,
+	)
+	if err != nil {
+		return false, errors.Wrap(err, "could not hide reported content")
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return false, nil
+	}
+
+	state, err := t.Hide(id)
+	if err != nil {
+		if _, derr := m.db.Exec("DELETE FROM report_hidden WHERE target_type = $1 AND target_id = $2;", typ, id); derr != nil {
+			return false, errors.Wrap(derr, "could not unhide reported content")
+		}
+		return false, err
+	}
+	_, err = m.db.Exec("UPDATE report_hidden SET state = $3 WHERE target_type = $1 AND target_id = $2;", typ, id, state)
+	if err != nil {
+		return false, errors.Wrap(err, "could not save state of hidden content")
+	}
+	return true, nil
+}
+
+// Unhide restores content hidden by reports, it does nothing when content is not hidden.
+func (m *Manager) Unhide(typ, id string) error {
+	var state string
+	err := m.db.QueryRow(
+		"DELETE FROM report_hidden WHERE target_type = $1 AND target_id = $2 RETURNING state;",
+		typ, id,
+	).Scan(&state)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not unhide reported content")
+	}
+	if t, ok := m.targets[typ]; ok {
+		return t.Restore(id, state)
+	}
+	return nil
+}
+
+// Resolve resolves all open reports of the same content as report with id, since a moderator decides
+// on content rather than on single reports.
+func (m *Manager) Resolve(id string, status Status, moderatorID, note string) (*Report, error) {
+	r, err := m.ByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status != StatusOpen {
+		return nil, ErrResolved
+	}
+
+	_, err = m.db.Exec(
+		`UPDATE report SET status = $3, note = $4, resolved_at = now(), resolved_by = $5
+		WHERE target_type = $1 AND target_id = $2 AND status = 'open';`,
+		r.TargetType, r.TargetID, status, note, moderatorID,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not resolve reports")
+	}
+	return m.ByID(id)
+}
+
+const reportColumns = "id, target_type, target_id, user_id, reason, comment, status, note, created_at, resolved_at, resolved_by"
+
+func (m *Manager) ByID(id string) (*Report, error) {
+	rows, err := m.db.Query("SELECT "+reportColumns+" FROM report WHERE id = $1;", id)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get report by id")
+	}
+	defer rows.Close()
+
+	reports, err := scanAll(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, ErrNotFound
+	}
+	return reports[0], nil
+}
+
+// Find returns reports matching query, the oldest first, so moderators handle them in order.
+func (m *Manager) Find(q Query) ([]*Report, error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	rows, err := m.db.Query(
+		`SELECT `+reportColumns+` FROM report
+		WHERE ($1 = '' OR status = $1) AND ($2 = '' OR target_type = $2) AND ($3 = '' OR target_id = $3)
+		ORDER BY created_at, id LIMIT $4;`,
+		q.Status, q.TargetType, q.TargetID, q.Limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get reports")
+	}
+	defer rows.Close()
+
+	return scanAll(rows)
+}
+
+// ByUser returns reports made by user.
+func (m *Manager) ByUser(userID string) ([]*Report, error) {
+	rows, err := m.db.Query("SELECT "+reportColumns+" FROM report WHERE user_id = $1 ORDER BY created_at, id;", userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get reports of user")
+	}
+	defer rows.Close()
+
+	return scanAll(rows)
+}
+
+// DeleteByUser removes reports made by user, content stays hidden until moderator resolves other reports.
+func (m *Manager) DeleteByUser(userID string) error {
+	if _, err := m.db.Exec("DELETE FROM report WHERE user_id = $1;", userID); err != nil {
+		return errors.Wrap(err, "could not delete reports of user")
+	}
+	return nil
+}
+
+func scanAll(rows *sql.Rows) ([]*Report, error) {
+	reports := []*Report{}
+	for rows.Next() {
+		var r Report
+		err := rows.Scan(&r.ID, &r.TargetType, &r.TargetID, &r.UserID, &r.Reason, &r.Comment, &r.Status, &r.Note,
+			&r.CreatedAt, &r.ResolvedAt, &r.ResolvedBy)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not scan row to report model")
+		}
+		reports = append(reports, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get reports")
+	}
+	return reports, nil
+}
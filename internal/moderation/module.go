@@ -0,0 +1,59 @@
+package moderation
+
+import (
+	"net/http"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/internal/article"
+	"github.com/agalitsyn/goapi/internal/privacy"
+	"github.com/agalitsyn/goapi/pkg/module"
+	"github.com/agalitsyn/goapi/pkg/surrogate"
+)
+
+func init() {
+	module.Register(&moderationModule{})
+}
+
+type moderationModule struct {
+	module.Base
+
+	opts struct {
+		HideThreshold int `long:"moderation-hide-threshold" env:"GAPI_MODERATION_HIDE_THRESHOLD" default:"3" description:"How many open reports of different users hide content until a moderator reviews it, 0 disables hiding."`
+	}
+
+	env     *module.Env
+	manager *Manager
+}
+
+func (mod *moderationModule) Name() string                     { return "moderation" }
+func (mod *moderationModule) Options() interface{}             { return &mod.opts }
+func (mod *moderationModule) Migrations() []*migrate.Migration { return Migrations() }
+
+// Init provides article target as moderation.target.article and privacy handler of reports
+// as privacy.handler.moderation.
+func (mod *moderationModule) Init(env *module.Env) error {
+	mod.env = env
+	if m, ok := env.Lookup("article.manager"); ok {
+		var purger surrogate.Purger
+		if p, ok := env.Lookup("surrogate.purger"); ok {
+			purger = p.(surrogate.Purger)
+		}
+		env.Provide(TargetPrefix+"article", &articleTarget{m: m.(*article.Manager), purger: purger})
+	}
+	env.Provide(privacy.HandlerPrefix+"moderation", &privacyHandler{db: env.DB})
+	return nil
+}
+
+// Routes take targets provided by any module, so they are built after all modules are initialized.
+func (mod *moderationModule) Routes() map[string]http.Handler {
+	targets := make(map[string]Target)
+	for name, t := range mod.env.LookupPrefix(TargetPrefix) {
+		targets[name] = t.(Target)
+	}
+	mod.manager = NewManager(mod.env.DB, targets)
+	return map[string]http.Handler{
+		"/reports":                  Routes(mod.manager, mod.opts.HideThreshold),
+		"/admin/moderation/reports": AdminRoutes(mod.manager),
+	}
+}
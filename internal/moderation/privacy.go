@@ -0,0 +1,27 @@
+package moderation
+
+import (
+	"database/sql"
+
+	"github.com/agalitsyn/goapi/internal/privacy"
+)
+
+// privacyHandler exports and erases reports made by users, tenants make none. Moderators' notes
+// on reports stay with reports of other users.
+type privacyHandler struct {
+	db *sql.DB
+}
+
+func (h *privacyHandler) Export(s privacy.Subject) (interface{}, error) {
+	if s.User == "" {
+		return []*Report{}, nil
+	}
+	return NewManager(h.db, nil).ByUser(s.User)
+}
+
+func (h *privacyHandler) Erase(s privacy.Subject) error {
+	if s.User == "" {
+		return nil
+	}
+	return NewManager(h.db, nil).DeleteByUser(s.User)
+}
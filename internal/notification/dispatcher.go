@@ -0,0 +1,121 @@
+package notification
+
+import (
+	"context"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// DispatcherConfig configures delivery of notifications by email and webhook.
+type DispatcherConfig struct {
+	// Interval is how often queued deliveries are checked.
+	Interval time.Duration
+	// Batch is how many deliveries are claimed at once.
+	Batch int
+	// Timeout limits a single delivery, claimed deliveries are retried after it runs out.
+	Timeout time.Duration
+	// MaxAttempts is how many times delivery is tried before it is given up.
+	MaxAttempts int
+	// Backoff is delay before the second attempt, it doubles with every next one.
+	Backoff time.Duration
+}
+
+// Dispatcher sends queued deliveries with senders of their channels, it runs on a single replica.
+type Dispatcher struct {
+	m       *Manager
+	senders map[Channel]Sender
+	cfg     DispatcherConfig
+	clock   clock.Clock
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewDispatcher(m *Manager, senders map[Channel]Sender, cfg DispatcherConfig) *Dispatcher {
+	return &Dispatcher{
+		m:       m,
+		senders: senders,
+		cfg:     cfg,
+		clock:   clock.Real,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// RunPending sends due deliveries batch by batch until there are none.
+func (d *Dispatcher) RunPending(logger log.Logger) error {
+	for {
+		select {
+		case <-d.stop:
+			return nil
+		default:
+		}
+
+		now := d.clock.Now()
+		deliveries, err := d.m.claim(now, now.Add(d.cfg.Timeout), d.cfg.Batch)
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+		for _, dl := range deliveries {
+			if err := d.send(dl, logger); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// send delivers notification and records the outcome, error is returned when it can not be recorded.
+func (d *Dispatcher) send(dl *Delivery, logger log.Logger) error {
+	logger = logger.WithField("context", "notification").WithField("delivery", dl.ID).WithField("channel", dl.Channel)
+
+	sender, ok := d.senders[dl.Channel]
+	if !ok {
+		logger.Warn("channel is not configured, delivery is given up")
+		return d.m.retry(dl.ID, nil, "channel is not configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.cfg.Timeout)
+	err := sender.Send(ctx, dl.Address, &dl.Notification)
+	cancel()
+	if err == nil {
+		return d.m.delivered(dl.ID, d.clock.Now())
+	}
+
+	if dl.Attempts >= d.cfg.MaxAttempts {
+		logger.WithError(err).Errorf("delivery is given up after %d attempts", dl.Attempts)
+		return d.m.retry(dl.ID, nil, err.Error())
+	}
+	next := d.clock.Now().Add(d.cfg.Backoff << uint(dl.Attempts-1))
+	logger.WithError(err).Warnf("delivery is retried at %s", next.Format(time.RFC3339))
+	return d.m.retry(dl.ID, &next, err.Error())
+}
+
+// Run sends due deliveries every interval until Close is called.
+func (d *Dispatcher) Run(logger log.Logger) {
+	defer close(d.done)
+
+	ticker := d.clock.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := d.RunPending(logger); err != nil {
+				logger.WithError(err).Error()
+			}
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// Close stops Run, batch in progress is finished first.
+func (d *Dispatcher) Close() error {
+	close(d.stop)
+	<-d.done
+	return nil
+}
@@ -0,0 +1,233 @@
+package notification
+
+import (
+	"net/http"
+	"net/mail"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+)
+
+// Routes serve notifications of the signed-in user.
+func Routes(m *Manager) chi.Router {
+	r := chi.NewRouter()
+	r.Use(handler.RequireRole(""))
+
+	r.Get("/", makeHandler(m, listHandler))
+	r.Get("/unread-count", makeHandler(m, unreadCountHandler))
+	r.Post("/read", makeHandler(m, readAllHandler))
+	r.Post("/{notificationID}/read", makeHandler(m, readHandler))
+	r.Get("/preferences", makeHandler(m, preferencesHandler))
+	r.Put("/preferences", makeHandler(m, putPreferencesHandler))
+
+	return r
+}
+
+type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m.WithContext(r.Context()), w, r)
+	}
+}
+
+func userID(r *http.Request) string {
+	return reqctx.GetUser(r.Context()).ID
+}
+
+// listHandler accepts ?unread=true to list unread notifications only and ?limit=.
+func listHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "notification")
+
+	unread, _ := strconv.ParseBool(r.URL.Query().Get("unread"))
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			err := i18n.Errorf("request.invalid_param", "limit", 1, 200)
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		limit = n
+	}
+
+	notifications, err := m.List(userID(r), unread, limit)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	if err := render.RenderList(w, r, newNotificationListResponse(notifications)); err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+}
+
+// unreadCountHandler lets clients poll for a badge counter without listing notifications.
+func unreadCountHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "notification")
+
+	n, err := m.UnreadCount(userID(r))
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.JSON(w, r, map[string]int{"count": n})
+}
+
+func readHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "notification")
+
+	id := chi.URLParam(r, "notificationID")
+	if n, err := strconv.ParseInt(id, 10, 64); err != nil || n <= 0 {
+		logger.WithError(ErrNotFound).Warn()
+		render.Render(w, r, handler.ErrNotFound(ErrNotFound))
+		return
+	}
+	n, err := m.MarkRead(userID(r), id)
+	if err != nil {
+		if err == ErrNotFound {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.Render(w, r, newNotificationResponse(n))
+}
+
+// readAllHandler responds with how many notifications were marked read.
+func readAllHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "notification")
+
+	n, err := m.MarkAllRead(userID(r))
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.JSON(w, r, map[string]int64{"read": n})
+}
+
+func preferencesHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "notification")
+
+	p, err := m.Preferences(userID(r))
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.Render(w, r, newPreferencesResponse(p))
+}
+
+// putPreferencesHandler replaces preferences as {"email": "ann@example.com", "kinds": {"mention": ["in_app", "email"]}},
+// kinds which are left out are delivered in-app, an empty list turns kind off. On dry run preferences are validated,
+// but not saved.
+func putPreferencesHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "notification")
+	dryRun := handler.DryRun(w, r)
+
+	var data preferencesRequest
+	if err := serializer.Decode(r, &data); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	if err := data.validate(); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+
+	p := &Preferences{UserID: userID(r), Email: data.Email, WebhookURL: data.WebhookURL, Kinds: data.Kinds}
+	err := m.Tx(dryRun, func(m *Manager) error {
+		return m.SavePreferences(p)
+	})
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.Render(w, r, newPreferencesResponse(p))
+}
+
+type preferencesRequest struct {
+	Email      string             `json:"email"`
+	WebhookURL string             `json:"webhook_url"`
+	Kinds      map[Kind][]Channel `json:"kinds"`
+}
+
+// validate checks addresses and requires them for channels which are turned on.
+func (pr *preferencesRequest) validate() error {
+	if pr.Email != "" {
+		addr, err := mail.ParseAddress(pr.Email)
+		if err != nil || addr.Name != "" {
+			return i18n.Errorf("notification.invalid_email", pr.Email)
+		}
+	}
+	if pr.WebhookURL != "" && !validWebhookURL(pr.WebhookURL) {
+		return i18n.Errorf("notification.invalid_webhook", pr.WebhookURL)
+	}
+	if pr.Kinds == nil {
+		pr.Kinds = map[Kind][]Channel{}
+	}
+	for kind, channels := range pr.Kinds {
+		if !kind.valid() {
+			return i18n.Errorf("notification.invalid_kind", kind)
+		}
+		for _, c := range channels {
+			if !c.valid() {
+				return i18n.Errorf("notification.invalid_channel", c)
+			}
+			if (c == ChannelEmail && pr.Email == "") || (c == ChannelWebhook && pr.WebhookURL == "") {
+				return i18n.Errorf("notification.address_required", c)
+			}
+		}
+	}
+	return nil
+}
+
+func newNotificationListResponse(notifications []*Notification) []render.Renderer {
+	list := []render.Renderer{}
+	for _, n := range notifications {
+		list = append(list, newNotificationResponse(n))
+	}
+	return list
+}
+
+func newNotificationResponse(n *Notification) *notificationResponse {
+	return &notificationResponse{Notification: n}
+}
+
+type notificationResponse struct {
+	*Notification
+}
+
+func (nr *notificationResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func newPreferencesResponse(p *Preferences) *preferencesResponse {
+	return &preferencesResponse{Preferences: p}
+}
+
+type preferencesResponse struct {
+	*Preferences
+}
+
+func (pr *preferencesResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,226 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/egress"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var notificationColumnNames = []string{"id", "user_id", "kind", "actor_id", "title", "url", "created_at", "read_at"}
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	newRouter := func(u *reqctx.User) http.Handler {
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(u))
+		r.Mount("/notifications", Routes(NewManager(db)))
+		return r
+	}
+	r := newRouter(&reqctx.User{ID: "u1"})
+
+	mock.ExpectQuery("SELECT (.+) FROM notification WHERE user_id = \\$1 AND in_app AND \\(NOT \\$2 OR read_at IS NULL\\)").
+		WithArgs("u1", true, 10).
+		WillReturnRows(sqlmock.NewRows(notificationColumnNames).AddRow(5, "u1", "mention", "u2", "Новая", "http://example.com/1", time.Now(), nil))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/notifications?unread=true&limit=10", nil))
+	var notifications []Notification
+	if err := json.NewDecoder(w.Body).Decode(&notifications); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(notifications) != 1 || notifications[0].Kind != KindMention || notifications[0].ReadAt != nil {
+		t.Errorf("unexpected response: %v %+v", w.Code, notifications)
+	}
+
+	// notifications of other users are not found
+	mock.ExpectQuery("UPDATE notification SET read_at = coalesce\\(read_at, now\\(\\)\\) WHERE id = \\$1 AND user_id = \\$2").
+		WithArgs("6", "u1").
+		WillReturnRows(sqlmock.NewRows(notificationColumnNames))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://example.com/notifications/6/read", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status of notification of other user: %v", w.Code)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO notification_preference(.+) ON CONFLICT \\(user_id\\) DO UPDATE").
+		WithArgs("u1", "ann@example.com", "", []byte(`{"mention":["in_app","email"]}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/notifications/preferences",
+		strings.NewReader(`{"email": "ann@example.com", "kinds": {"mention": ["in_app", "email"]}}`)))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status of preferences: %v %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		body string
+	}{
+		{`{"email": "Ann <ann@example.com>"}`},
+		{`{"webhook_url": "ftp://example.com"}`},
+		{`{"kinds": {"like": ["in_app"]}}`},
+		{`{"kinds": {"mention": ["sms"]}}`},
+		{`{"kinds": {"mention": ["webhook"]}}`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://example.com/notifications/preferences", strings.NewReader(tt.body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status: %v", tt.body, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	newRouter(nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/notifications", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected status of anonymous user: %v", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestManager_Notify(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	// u1 gets mentions by email only, u2 turned mentions off, actor is not notified
+	mock.ExpectQuery("SELECT email, webhook_url, kinds FROM notification_preference WHERE user_id = \\$1;").
+		WithArgs("u1").
+		WillReturnRows(sqlmock.NewRows([]string{"email", "webhook_url", "kinds"}).AddRow("ann@example.com", "", []byte(`{"mention": ["email"]}`)))
+	mock.ExpectQuery("INSERT INTO notification(.+) RETURNING id;").
+		WithArgs("u1", KindMention, "u3", "Новая", "http://example.com/1", false).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectExec("INSERT INTO notification_delivery").
+		WithArgs("5", ChannelEmail, "ann@example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT email, webhook_url, kinds FROM notification_preference WHERE user_id = \\$1;").
+		WithArgs("u2").
+		WillReturnRows(sqlmock.NewRows([]string{"email", "webhook_url", "kinds"}).AddRow("", "", []byte(`{"mention": []}`)))
+	mock.ExpectCommit()
+
+	err = NewManager(db).Notify(Event{Kind: KindMention, UserIDs: []string{"u1", "u2", "u3", "u1"}, ActorID: "u3", Title: "Новая", URL: "http://example.com/1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+type testSender struct {
+	err  error
+	sent []string
+}
+
+func (s *testSender) Send(ctx context.Context, address string, n *Notification) error {
+	s.sent = append(s.sent, address+" "+n.Title)
+	return s.err
+}
+
+func TestDispatcher_RunPending(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	email, webhook := &testSender{}, &testSender{err: errors.New("webhook responded with 502 Bad Gateway")}
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDispatcher(NewManager(db), map[Channel]Sender{ChannelEmail: email, ChannelWebhook: webhook}, DispatcherConfig{
+		Batch: 10, Timeout: time.Second, MaxAttempts: 3, Backoff: time.Minute,
+	})
+	d.clock = clock.NewFake(now)
+
+	columns := []string{"id", "channel", "address", "attempts", "id", "user_id", "kind", "actor_id", "title", "url", "created_at"}
+	mock.ExpectQuery("UPDATE notification_delivery AS d SET attempts = d.attempts \\+ 1(.+) FOR UPDATE SKIP LOCKED").
+		WithArgs(now, now.Add(time.Second), 10).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "email", "ann@example.com", 1, 5, "u1", "mention", "", "Новая", "", now).
+			AddRow(2, "webhook", "http://example.com/hook", 2, 5, "u1", "mention", "", "Новая", "", now).
+			AddRow(3, "webhook", "http://example.com/hook", 3, 6, "u1", "mention", "", "Вторая", "", now))
+	mock.ExpectExec("UPDATE notification_delivery SET delivered_at = \\$2").
+		WithArgs("1", now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// backoff doubles with attempts
+	mock.ExpectExec("UPDATE notification_delivery SET next_at = \\$2, error = \\$3").
+		WithArgs("2", now.Add(2*time.Minute), "webhook responded with 502 Bad Gateway").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE notification_delivery SET failed = true, error = \\$2").
+		WithArgs("3", "webhook responded with 502 Bad Gateway").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("UPDATE notification_delivery AS d").
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	if err := d.RunPending(log.New("", "", ioutil.Discard)); err != nil {
+		t.Fatal(err)
+	}
+	if len(email.sent) != 1 || len(webhook.sent) != 2 {
+		t.Errorf("unexpected deliveries: %v %v", email.sent, webhook.sent)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestWebhookSender(t *testing.T) {
+	var signature, timestamp string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature, timestamp = r.Header.Get(HeaderSignature), r.Header.Get(HeaderTimestamp)
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	// test server listens on loopback, which default policy denies
+	n := &Notification{ID: "5", Kind: KindMention, Title: "Новая"}
+	if err := NewWebhookSender("secret", time.Second, nil).Send(context.Background(), srv.URL, n); err == nil {
+		t.Error("webhook to loopback is sent")
+	}
+	policy, err := egress.New(egress.Config{AllowNetworks: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewWebhookSender("secret", time.Second, policy)
+	if err := s.Send(context.Background(), srv.URL, n); err != nil {
+		t.Fatal(err)
+	}
+	if signature == "" || signature != Sign([]byte("secret"), timestamp, body) {
+		t.Errorf("unexpected signature %q of %s", signature, body)
+	}
+}
@@ -0,0 +1,26 @@
+package notification
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"notification.invalid_email":    "invalid email %q",
+		"notification.invalid_webhook":  "invalid webhook URL %q, expected http or https URL",
+		"notification.invalid_kind":     "unknown kind of notifications %s",
+		"notification.invalid_channel":  "unknown channel %s, channels are in_app, email and webhook",
+		"notification.address_required": "address of channel %s is required",
+
+		"notification.subject.comment": "New comment on %s",
+		"notification.subject.mention": "You are mentioned in %s",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"notification.invalid_email":    "неверный email %q",
+		"notification.invalid_webhook":  "неверный URL вебхука %q, ожидается URL http или https",
+		"notification.invalid_kind":     "неизвестный вид уведомлений %s",
+		"notification.invalid_channel":  "неизвестный канал %s, каналы: in_app, email и webhook",
+		"notification.address_required": "необходимо указать адрес канала %s",
+
+		"notification.subject.comment": "Новый комментарий к %s",
+		"notification.subject.mention": "Вас упомянули в %s",
+	})
+}
@@ -0,0 +1,49 @@
+package notification
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "0022_notification",
+			Up: []string{
+				// notifications delivered by other channels only are stored too, they are what deliveries send
+				`CREATE TABLE notification (
+					id          BIGSERIAL                   NOT NULL,
+					user_id     character varying(128)      NOT NULL,
+					kind        character varying(32)       NOT NULL,
+					actor_id    character varying(128)      NOT NULL DEFAULT '',
+					title       text                        NOT NULL DEFAULT '',
+					url         text                        NOT NULL DEFAULT '',
+					in_app      boolean                     NOT NULL DEFAULT true,
+					created_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					read_at     timestamp with time zone,
+					PRIMARY KEY (id)
+				);`,
+				`CREATE INDEX notification_user_idx ON notification (user_id, created_at DESC) WHERE in_app;`,
+				`CREATE INDEX notification_created_at_idx ON notification (created_at);`,
+				`CREATE TABLE notification_delivery (
+					id               BIGSERIAL                   NOT NULL,
+					notification_id  bigint                      NOT NULL REFERENCES notification(id) ON DELETE CASCADE,
+					channel          character varying(16)       NOT NULL,
+					address          text                        NOT NULL,
+					attempts         integer                     NOT NULL DEFAULT 0,
+					next_at          timestamp with time zone    NOT NULL DEFAULT now(),
+					delivered_at     timestamp with time zone,
+					failed           boolean                     NOT NULL DEFAULT false,
+					error            text                        NOT NULL DEFAULT '',
+					PRIMARY KEY (id)
+				);`,
+				`CREATE INDEX notification_delivery_due_idx ON notification_delivery (next_at) WHERE delivered_at IS NULL AND NOT failed;`,
+				`CREATE TABLE notification_preference (
+					user_id      character varying(128)      NOT NULL,
+					email        text                        NOT NULL DEFAULT '',
+					webhook_url  text                        NOT NULL DEFAULT '',
+					kinds        jsonb                       NOT NULL DEFAULT '{}',
+					updated_at   timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (user_id)
+				);`,
+			},
+		},
+	}
+}
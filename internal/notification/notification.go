@@ -0,0 +1,388 @@
+// Package notification stores notifications of users about events, e.g. mentions in articles, and delivers
+// them by channels users prefer: in-app list, email or webhook.
+package notification
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Kind is a kind of event users are notified about.
+type Kind string
+
+const (
+	KindComment Kind = "comment"
+	KindMention Kind = "mention"
+)
+
+// Kinds lists all kinds in display order.
+var Kinds = []Kind{KindComment, KindMention}
+
+func (k Kind) valid() bool {
+	for _, v := range Kinds {
+		if k == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Channel is a way notification reaches user.
+type Channel string
+
+const (
+	// ChannelInApp lists notification by the notifications API.
+	ChannelInApp   Channel = "in_app"
+	ChannelEmail   Channel = "email"
+	ChannelWebhook Channel = "webhook"
+)
+
+// Channels lists all channels in display order.
+var Channels = []Channel{ChannelInApp, ChannelEmail, ChannelWebhook}
+
+func (c Channel) valid() bool {
+	for _, v := range Channels {
+		if c == v {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultChannels are used for kinds user has no preference of.
+var DefaultChannels = []Channel{ChannelInApp}
+
+// Event is what happened to users, e.g. they are mentioned in article.
+type Event struct {
+	Kind Kind
+	// UserIDs are notified users, actor is never notified about own actions.
+	UserIDs []string
+	ActorID string
+	// Title is a title of subject of event, e.g. article title.
+	Title string
+	// URL is a page of subject of event.
+	URL string
+}
+
+type Notification struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"-"`
+	Kind      Kind       `json:"kind"`
+	ActorID   string     `json:"actor_id"`
+	Title     string     `json:"title"`
+	URL       string     `json:"url"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+// Preferences are channels user is notified by about each kind of event and addresses of channels.
+type Preferences struct {
+	UserID     string             `json:"-"`
+	Email      string             `json:"email"`
+	WebhookURL string             `json:"webhook_url"`
+	Kinds      map[Kind][]Channel `json:"kinds"`
+}
+
+// channels returns channels of kind which can be delivered to.
+func (p *Preferences) channels(kind Kind) []Channel {
+	channels, ok := p.Kinds[kind]
+	if !ok {
+		channels = DefaultChannels
+	}
+	var res []Channel
+	for _, c := range channels {
+		if (c == ChannelEmail && p.Email == "") || (c == ChannelWebhook && p.WebhookURL == "") {
+			continue
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+func (p *Preferences) address(c Channel) string {
+	switch c {
+	case ChannelEmail:
+		return p.Email
+	case ChannelWebhook:
+		return p.WebhookURL
+	}
+	return ""
+}
+
+type Manager struct {
+	db postgres.Querier
+}
+
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{db: db}
+}
+
+// Tx runs fn with manager bound to a transaction, which is rolled back when fn fails or on dry run.
+func (m *Manager) Tx(dryRun bool, fn func(m *Manager) error) error {
+	return postgres.Tx(m.db, dryRun, func(tx postgres.Querier) error {
+		return fn(&Manager{db: tx})
+	})
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db)}
+}
+
+// Notify stores notifications of event and queues their delivery by email and webhook, users who
+// turned off all channels of event kind are not notified.
+func (m *Manager) Notify(e Event) error {
+	return m.Tx(false, func(m *Manager) error {
+		seen := make(map[string]bool)
+		for _, userID := range e.UserIDs {
+			if userID == e.ActorID || seen[userID] {
+				continue
+			}
+			seen[userID] = true
+
+			p, err := m.Preferences(userID)
+			if err != nil {
+				return err
+			}
+			channels := p.channels(e.Kind)
+			if len(channels) == 0 {
+				continue
+			}
+			var inApp bool
+			for _, c := range channels {
+				inApp = inApp || c == ChannelInApp
+			}
+
+			var id string
+			err = m.db.QueryRow(
+				"INSERT INTO notification(user_id, kind, actor_id, title, url, in_app) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;",
+				userID, e.Kind, e.ActorID, e.Title, e.URL, inApp,
+			).Scan(&id)
+			if err != nil {
+				return errors.Wrap(err, "could not save notification")
+			}
+			for _, c := range channels {
+				if c == ChannelInApp {
+					continue
+				}
+				_, err := m.db.Exec(
+					"INSERT INTO notification_delivery(notification_id, channel, address) VALUES ($1, $2, $3);",
+					id, c, p.address(c),
+				)
+				if err != nil {
+					return errors.Wrap(err, "could not queue notification delivery")
+				}
+			}
+		}
+		return nil
+	})
+}
+
+const notificationColumns = "id, user_id, kind, actor_id, title, url, created_at, read_at"
+
+// List returns in-app notifications of user, the latest first.
+func (m *Manager) List(userID string, unread bool, limit int) ([]*Notification, error) {
+	rows, err := m.db.Query(
+		`SELECT `+notificationColumns+` FROM notification
+		WHERE user_id = $1 AND in_app AND (NOT $2 OR read_at IS NULL)
+		ORDER BY created_at DESC, id DESC LIMIT $3;`,
+		userID, unread, limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get notifications")
+	}
+	defer rows.Close()
+
+	return scanAll(rows)
+}
+
+// UnreadCount returns how many in-app notifications user has not read.
+func (m *Manager) UnreadCount(userID string) (int, error) {
+	var n int
+	err := m.db.QueryRow("SELECT count(*) FROM notification WHERE user_id = $1 AND in_app AND read_at IS NULL;", userID).Scan(&n)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not count unread notifications")
+	}
+	return n, nil
+}
+
+// MarkRead marks notification of user read, read notifications keep the time they were first read at.
+func (m *Manager) MarkRead(userID, id string) (*Notification, error) {
+	rows, err := m.db.Query(
+		`UPDATE notification SET read_at = coalesce(read_at, now()) WHERE id = $1 AND user_id = $2 AND in_app
+		RETURNING `+notificationColumns+";",
+		id, userID,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not mark notification read")
+	}
+	defer rows.Close()
+
+	notifications, err := scanAll(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(notifications) == 0 {
+		return nil, ErrNotFound
+	}
+	return notifications[0], nil
+}
+
+// MarkAllRead marks all notifications of user read and returns how many were unread.
+func (m *Manager) MarkAllRead(userID string) (int64, error) {
+	res, err := m.db.Exec("UPDATE notification SET read_at = now() WHERE user_id = $1 AND in_app AND read_at IS NULL;", userID)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not mark notifications read")
+	}
+	return res.RowsAffected()
+}
+
+// Preferences returns preferences of user, users who have not saved any get defaults.
+func (m *Manager) Preferences(userID string) (*Preferences, error) {
+	p := &Preferences{UserID: userID, Kinds: map[Kind][]Channel{}}
+	var kinds []byte
+	err := m.db.QueryRow(
+		"SELECT email, webhook_url, kinds FROM notification_preference WHERE user_id = $1;", userID,
+	).Scan(&p.Email, &p.WebhookURL, &kinds)
+	if err == sql.ErrNoRows {
+		return p, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get notification preferences")
+	}
+	if err := json.Unmarshal(kinds, &p.Kinds); err != nil {
+		return nil, errors.Wrap(err, "could not decode notification preferences")
+	}
+	return p, nil
+}
+
+// SavePreferences replaces preferences of user, queued deliveries go to addresses they were queued to.
+func (m *Manager) SavePreferences(p *Preferences) error {
+	kinds, err := json.Marshal(p.Kinds)
+	if err != nil {
+		return errors.Wrap(err, "could not encode notification preferences")
+	}
+	_, err = m.db.Exec(
+		`INSERT INTO notification_preference(user_id, email, webhook_url, kinds) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE SET email = EXCLUDED.email, webhook_url = EXCLUDED.webhook_url,
+			kinds = EXCLUDED.kinds, updated_at = now();`,
+		p.UserID, p.Email, p.WebhookURL, kinds,
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not save notification preferences")
+	}
+	return nil
+}
+
+// Delivery is a notification queued to be sent by email or webhook.
+type Delivery struct {
+	ID           string
+	Channel      Channel
+	Address      string
+	Attempts     int
+	Notification Notification
+}
+
+// claim leases up to limit deliveries which are due until leaseUntil, so other dispatchers skip them
+// and they are retried if dispatcher crashes while sending.
+func (m *Manager) claim(now, leaseUntil time.Time, limit int) ([]*Delivery, error) {
+	rows, err := m.db.Query(
+		`UPDATE notification_delivery AS d SET attempts = d.attempts + 1, next_at = $2
+		FROM notification AS n
+		WHERE n.id = d.notification_id AND d.id IN (
+			SELECT id FROM notification_delivery
+			WHERE delivered_at IS NULL AND NOT failed AND next_at <= $1
+			ORDER BY next_at, id LIMIT $3 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.channel, d.address, d.attempts, n.id, n.user_id, n.kind, n.actor_id, n.title, n.url, n.created_at;`,
+		now, leaseUntil, limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not claim notification deliveries")
+	}
+	defer rows.Close()
+
+	var res []*Delivery
+	for rows.Next() {
+		var d Delivery
+		n := &d.Notification
+		err := rows.Scan(&d.ID, &d.Channel, &d.Address, &d.Attempts, &n.ID, &n.UserID, &n.Kind, &n.ActorID, &n.Title, &n.URL, &n.CreatedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not scan row to notification delivery model")
+		}
+		res = append(res, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not claim notification deliveries")
+	}
+	return res, nil
+}
+
+// delivered marks delivery sent.
+func (m *Manager) delivered(id string, now time.Time) error {
+	if _, err := m.db.Exec("UPDATE notification_delivery SET delivered_at = $2, error = '' WHERE id = $1;", id, now); err != nil {
+		return errors.Wrap(err, "could not mark notification delivered")
+	}
+	return nil
+}
+
+// retry stores failure of delivery, it is retried at next, or never when next is nil.
+func (m *Manager) retry(id string, next *time.Time, reason string) error {
+	var err error
+	if next == nil {
+		_, err = m.db.Exec("UPDATE notification_delivery SET failed = true, error = $2 WHERE id = $1;", id, reason)
+	} else {
+		_, err = m.db.Exec("UPDATE notification_delivery SET next_at = $2, error = $3 WHERE id = $1;", id, *next, reason)
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not reschedule notification delivery")
+	}
+	return nil
+}
+
+// ByUser returns all notifications of user, including ones delivered by other channels only.
+func (m *Manager) ByUser(userID string) ([]*Notification, error) {
+	rows, err := m.db.Query("SELECT "+notificationColumns+" FROM notification WHERE user_id = $1 ORDER BY created_at, id;", userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get notifications of user")
+	}
+	defer rows.Close()
+
+	return scanAll(rows)
+}
+
+// DeleteByUser removes notifications and preferences of user, deliveries are removed with notifications.
+func (m *Manager) DeleteByUser(userID string) error {
+	return m.Tx(false, func(m *Manager) error {
+		if _, err := m.db.Exec("DELETE FROM notification WHERE user_id = $1;", userID); err != nil {
+			return errors.Wrap(err, "could not delete notifications of user")
+		}
+		if _, err := m.db.Exec("DELETE FROM notification_preference WHERE user_id = $1;", userID); err != nil {
+			return errors.Wrap(err, "could not delete notification preferences of user")
+		}
+		return nil
+	})
+}
+
+func scanAll(rows *sql.Rows) ([]*Notification, error) {
+	notifications := []*Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Kind, &n.ActorID, &n.Title, &n.URL, &n.CreatedAt, &n.ReadAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan row to notification model")
+		}
+		notifications = append(notifications, &n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get notifications")
+	}
+	return notifications, nil
+}
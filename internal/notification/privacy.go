@@ -0,0 +1,35 @@
+package notification
+
+import "github.com/agalitsyn/goapi/internal/privacy"
+
+// privacyHandler exports and erases notifications and preferences of users, tenants have none.
+type privacyHandler struct {
+	m *Manager
+}
+
+type userData struct {
+	Notifications []*Notification `json:"notifications"`
+	Preferences   *Preferences    `json:"preferences"`
+}
+
+func (h *privacyHandler) Export(s privacy.Subject) (interface{}, error) {
+	if s.User == "" {
+		return nil, nil
+	}
+	notifications, err := h.m.ByUser(s.User)
+	if err != nil {
+		return nil, err
+	}
+	p, err := h.m.Preferences(s.User)
+	if err != nil {
+		return nil, err
+	}
+	return &userData{Notifications: notifications, Preferences: p}, nil
+}
+
+func (h *privacyHandler) Erase(s privacy.Subject) error {
+	if s.User == "" {
+		return nil
+	}
+	return h.m.DeleteByUser(s.User)
+}
@@ -0,0 +1,18 @@
+package partner
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"partner.signature_required": "request must be signed by a partner",
+		"partner.signature_invalid":  "request signature is invalid",
+		"partner.signature_expired":  "request timestamp differs from server time by more than %s",
+		"partner.nonce_reused":       "request nonce is already used",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"partner.signature_required": "запрос должен быть подписан партнёром",
+		"partner.signature_invalid":  "подпись запроса неверна",
+		"partner.signature_expired":  "время запроса отличается от времени сервера более чем на %s",
+		"partner.nonce_reused":       "nonce запроса уже использован",
+	})
+}
@@ -0,0 +1,20 @@
+package partner
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "0011_partner_initial",
+			Up: []string{
+				`CREATE TABLE partner (
+					id          character varying(64)       NOT NULL,
+					secret      character varying(256)      NOT NULL,
+					active      boolean                     NOT NULL DEFAULT true,
+					created_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id)
+				);`,
+			},
+		},
+	}
+}
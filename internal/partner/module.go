@@ -0,0 +1,41 @@
+package partner
+
+import (
+	"net/http"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/pkg/module"
+)
+
+func init() {
+	module.Register(&partnerModule{})
+}
+
+type partnerModule struct {
+	module.Base
+
+	opts struct {
+		ClockSkew time.Duration `long:"partner-clock-skew" env:"GAPI_PARTNER_CLOCK_SKEW" default:"5m" description:"Maximal difference between timestamp of signed partner request and server time."`
+		MaxBody   int64         `long:"partner-max-body" env:"GAPI_PARTNER_MAX_BODY" default:"10485760" description:"Max size of signed partner request body in bytes."`
+		Required  []string      `long:"partner-required-prefix" env:"GAPI_PARTNER_REQUIRED_PREFIXES" env-delim:"," description:"Route prefix which accepts requests signed by partners only, e.g. /1.0/partner/."`
+	}
+
+	verifier *Verifier
+}
+
+func (mod *partnerModule) Name() string                     { return "partners" }
+func (mod *partnerModule) Options() interface{}             { return &mod.opts }
+func (mod *partnerModule) Migrations() []*migrate.Migration { return Migrations() }
+
+func (mod *partnerModule) Init(env *module.Env) error {
+	m := NewManager(env.DB)
+	mod.verifier = NewVerifier(m.Secret, mod.opts.ClockSkew, mod.opts.MaxBody, mod.opts.Required)
+	return nil
+}
+
+// Middleware verifies signatures of partner requests.
+func (mod *partnerModule) Middleware() func(next http.Handler) http.Handler {
+	return mod.verifier.Middleware
+}
@@ -0,0 +1,46 @@
+package partner
+
+import (
+	"sync"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+)
+
+// NonceCache remembers nonces until they expire. It is kept in memory,
+// so with several instances a request may be replayed once against each instance within the window.
+type NonceCache struct {
+	ttl   time.Duration
+	clock clock.Clock
+
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	nextSweep time.Time
+}
+
+func NewNonceCache(ttl time.Duration) *NonceCache {
+	return &NonceCache{ttl: ttl, clock: clock.Real, seen: make(map[string]time.Time)}
+}
+
+// Add remembers nonce and reports whether it was not seen before.
+// Expired nonces are swept at most once per ttl, so the cache does not grow beyond the window.
+func (c *NonceCache) Add(nonce string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if now.After(c.nextSweep) {
+		for n, exp := range c.seen {
+			if !now.Before(exp) {
+				delete(c.seen, n)
+			}
+		}
+		c.nextSweep = now.Add(c.ttl)
+	}
+
+	if exp, ok := c.seen[nonce]; ok && now.Before(exp) {
+		return false
+	}
+	c.seen[nonce] = now.Add(c.ttl)
+	return true
+}
@@ -0,0 +1,43 @@
+// Package partner verifies HMAC signatures of requests made by partner integrations.
+//
+// Partners share a secret with the service and sign every request:
+//
+//	X-Partner-ID: acme
+//	X-Signature-Timestamp: 1528977600
+//	X-Signature-Nonce: 5f2b6c1e
+//	X-Signature: hex(HMAC-SHA256(secret, method\npath?query\ntimestamp\nnonce\nhex(SHA256(body))))
+//
+// Requests with timestamp outside of clock skew tolerance are rejected, nonces are remembered
+// for the tolerance window, so a captured request can not be replayed.
+package partner
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+var ErrNotFound = errors.New("partner not found")
+
+type Manager struct {
+	db postgres.Querier
+}
+
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{db: db}
+}
+
+// Secret returns shared secret of active partner.
+func (m *Manager) Secret(id string) ([]byte, error) {
+	var secret string
+	err := m.db.QueryRow("SELECT secret FROM partner WHERE id = $1 AND active;", id).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get partner secret")
+	}
+	return []byte(secret), nil
+}
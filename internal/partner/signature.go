@@ -0,0 +1,160 @@
+package partner
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+const (
+	HeaderPartner   = "X-Partner-ID"
+	HeaderTimestamp = "X-Signature-Timestamp"
+	HeaderNonce     = "X-Signature-Nonce"
+	HeaderSignature = "X-Signature"
+)
+
+// metrics are exposed with expvar as partner.{verified,rejected}.
+var metrics = expvar.NewMap("partner")
+
+// Sign returns hex encoded signature of request, body is the whole request body.
+func Sign(secret []byte, method, uri, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{method, uri, timestamp, nonce, hex.EncodeToString(sum[:])}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SecretFunc returns shared secret of partner, ErrNotFound for unknown partners.
+type SecretFunc func(id string) ([]byte, error)
+
+// Verifier checks signatures of partner requests.
+type Verifier struct {
+	Secret SecretFunc
+	// Skew is maximal difference between request timestamp and server time.
+	Skew time.Duration
+	// MaxBody limits size of signed request body.
+	MaxBody int64
+	// Required are route prefixes which accept signed requests only, e.g. /1.0/partner/.
+	Required []string
+
+	nonces *NonceCache
+	clock  clock.Clock
+}
+
+func NewVerifier(secret SecretFunc, skew time.Duration, maxBody int64, required []string) *Verifier {
+	return &Verifier{
+		Secret:   secret,
+		Skew:     skew,
+		MaxBody:  maxBody,
+		Required: required,
+		// timestamps are accepted within skew at both sides, so are their nonces
+		nonces: NewNonceCache(2 * skew),
+		clock:  clock.Real,
+	}
+}
+
+// Middleware verifies signatures of requests with X-Partner-ID and sets partner of request context.
+// Unsigned requests pass through unless their path starts with a required prefix.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderPartner)
+		if id == "" {
+			if v.required(r.URL.Path) {
+				v.reject(w, r, "partner.signature_required")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logger := log.GetLogEntry(r).WithField("context", "partner").WithField("partner", id)
+
+		timestamp, nonce, signature := r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce), r.Header.Get(HeaderSignature)
+		if timestamp == "" || nonce == "" || signature == "" {
+			v.reject(w, r, "partner.signature_invalid")
+			return
+		}
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			v.reject(w, r, "partner.signature_invalid")
+			return
+		}
+		if d := v.clock.Now().Sub(time.Unix(sec, 0)); d > v.Skew || d < -v.Skew {
+			v.reject(w, r, "partner.signature_expired", v.Skew)
+			return
+		}
+
+		secret, err := v.Secret(id)
+		if err == ErrNotFound {
+			v.reject(w, r, "partner.signature_invalid")
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			render(w, r, http.StatusInternalServerError, "")
+			return
+		}
+
+		var body []byte
+		if r.Body != nil {
+			body, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, v.MaxBody))
+			if err != nil {
+				logger.WithError(err).Warn()
+				render(w, r, http.StatusRequestEntityTooLarge, "")
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		expected := Sign(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+		if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+			v.reject(w, r, "partner.signature_invalid")
+			return
+		}
+		// nonce is remembered only for valid signatures, otherwise anyone could burn nonces of a partner
+		if !v.nonces.Add(id + ":" + nonce) {
+			v.reject(w, r, "partner.nonce_reused")
+			return
+		}
+
+		metrics.Add("verified", 1)
+		next.ServeHTTP(w, r.WithContext(reqctx.WithPartner(r.Context(), id)))
+	})
+}
+
+func (v *Verifier) required(path string) bool {
+	for _, p := range v.Required {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Verifier) reject(w http.ResponseWriter, r *http.Request, key string, args ...interface{}) {
+	metrics.Add("rejected", 1)
+	log.GetLogEntry(r).WithField("context", "partner").Warn(i18n.Translate(i18n.DefaultLocale, key, args...))
+	render(w, r, http.StatusUnauthorized, i18n.Translate(reqctx.GetLocale(r.Context()), key, args...))
+}
+
+func render(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	handler.WriteProblem(w, &handler.Problem{
+		Title:    i18n.StatusText(reqctx.GetLocale(r.Context()), status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+	})
+}
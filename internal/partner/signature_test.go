@@ -0,0 +1,131 @@
+package partner
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestVerifier(t *testing.T) {
+	now := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	secrets := map[string][]byte{"acme": []byte("s3cret")}
+	v := NewVerifier(func(id string) ([]byte, error) {
+		s, ok := secrets[id]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		return s, nil
+	}, 5*time.Minute, 1024, []string{"/1.0/partner/"})
+	c := clock.NewFake(now)
+	v.clock = c
+	v.nonces.clock = c
+
+	var partner, body string
+	h := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	h.Use(v.Middleware)
+	h.HandleFunc("/*", func(w http.ResponseWriter, r *http.Request) {
+		partner = reqctx.GetPartner(r.Context())
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+	})
+
+	sign := func(id, secret, path, nonce string, ts time.Time, payload string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(payload))
+		timestamp := strconv.FormatInt(ts.Unix(), 10)
+		r.Header.Set(HeaderPartner, id)
+		r.Header.Set(HeaderTimestamp, timestamp)
+		r.Header.Set(HeaderNonce, nonce)
+		r.Header.Set(HeaderSignature, Sign([]byte(secret), http.MethodPost, path, timestamp, nonce, []byte(payload)))
+		return r
+	}
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"valid", sign("acme", "s3cret", "/1.0/partner/orders?x=1", "n1", now, `{"id":1}`), http.StatusOK},
+		{"replayed", sign("acme", "s3cret", "/1.0/partner/orders?x=1", "n1", now, `{"id":1}`), http.StatusUnauthorized},
+		{"skewed", sign("acme", "s3cret", "/1.0/partner/orders", "n2", now.Add(-6*time.Minute), ""), http.StatusUnauthorized},
+		{"wrong secret", sign("acme", "guess", "/1.0/partner/orders", "n3", now, ""), http.StatusUnauthorized},
+		{"unknown partner", sign("evil", "s3cret", "/1.0/partner/orders", "n4", now, ""), http.StatusUnauthorized},
+		{"unsigned required", httptest.NewRequest(http.MethodGet, "/1.0/partner/orders", nil), http.StatusUnauthorized},
+		{"unsigned optional", httptest.NewRequest(http.MethodGet, "/1.0/articles", nil), http.StatusOK},
+		{"too large", sign("acme", "s3cret", "/1.0/partner/orders", "n5", now, strings.Repeat("x", 2048)), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		partner, body = "", ""
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, tt.req)
+		if w.Code != tt.status {
+			t.Errorf("%s: unexpected status: %v %s", tt.name, w.Code, w.Body.String())
+		}
+		if tt.name == "valid" && (partner != "acme" || body != `{"id":1}`) {
+			t.Errorf("unexpected partner %q or body %q", partner, body)
+		}
+	}
+
+	// tampered body
+	r := sign("acme", "s3cret", "/1.0/partner/orders", "n6", now, `{"id":1}`)
+	r.Body = ioutil.NopCloser(strings.NewReader(`{"id":2}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("tampered body is accepted: %v", w.Code)
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	c := NewNonceCache(time.Minute)
+	f := clock.NewFake(time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC))
+	c.clock = f
+
+	if !c.Add("a") || c.Add("a") {
+		t.Fatal("nonce is not remembered")
+	}
+	f.Add(2 * time.Minute)
+	if !c.Add("b") {
+		t.Fatal("new nonce is rejected")
+	}
+	if len(c.seen) != 1 {
+		t.Errorf("expired nonces are not swept: %v", c.seen)
+	}
+	if !c.Add("a") {
+		t.Error("expired nonce is rejected")
+	}
+}
+
+func TestManager_Secret(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT secret FROM partner").WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows([]string{"secret"}).AddRow("s3cret"))
+	mock.ExpectQuery("SELECT secret FROM partner").WithArgs("evil").
+		WillReturnRows(sqlmock.NewRows([]string{"secret"}))
+
+	m := NewManager(db)
+	if s, err := m.Secret("acme"); err != nil || string(s) != "s3cret" {
+		t.Errorf("unexpected secret: %q %v", s, err)
+	}
+	if _, err := m.Secret("evil"); err != ErrNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
@@ -0,0 +1,139 @@
+package privacy
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+func Routes(m *Manager) chi.Router {
+	r := chi.NewRouter()
+	r.Post("/export", makeHandler(m, createHandler(KindExport)))
+	r.Post("/erase", makeHandler(m, createHandler(KindErase)))
+	r.Get("/requests/{requestID}", makeHandler(m, getHandler))
+	r.Get("/requests/{requestID}/data", makeHandler(m, dataHandler))
+	return r
+}
+
+type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m.WithContext(r.Context()), w, r)
+	}
+}
+
+// subjectOf returns authenticated user, or tenant for requests made on behalf of tenant only,
+// so a user of tenant can not erase data of the whole tenant.
+func subjectOf(r *http.Request) (Subject, bool) {
+	if u := reqctx.GetUser(r.Context()); u != nil {
+		return Subject{User: u.ID}, true
+	}
+	if t := reqctx.GetTenant(r.Context()); t != "" {
+		return Subject{Tenant: t}, true
+	}
+	return Subject{}, false
+}
+
+// createHandler queues request and responds with 202 and its status URL in Location.
+func createHandler(kind Kind) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "privacy")
+
+		s, ok := subjectOf(r)
+		if !ok {
+			err := i18n.Errorf("privacy.subject_required")
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrUnauthorized(err))
+			return
+		}
+
+		req, err := m.Create(kind, s)
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		logger.WithField("request", req.ID).Infof("%s is requested", kind)
+
+		w.Header().Set("Location", path.Join(path.Dir(r.URL.Path), "requests", req.ID))
+		render.Status(r, http.StatusAccepted)
+		render.Render(w, r, &requestResponse{req})
+	}
+}
+
+func getHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	req, ok := requestOf(m, w, r)
+	if !ok {
+		return
+	}
+	render.Render(w, r, &requestResponse{req})
+}
+
+// dataHandler responds with exported data as a JSON file.
+func dataHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "privacy")
+
+	req, ok := requestOf(m, w, r)
+	if !ok {
+		return
+	}
+	if req.Kind != KindExport || req.Status != StatusDone {
+		render.Render(w, r, handler.ErrNotFound(i18n.Errorf("privacy.not_exported")))
+		return
+	}
+	data, err := m.Data(req.ID)
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	if data == nil {
+		render.Render(w, r, handler.ErrNotFound(i18n.Errorf("privacy.export_expired")))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="export-`+req.ID+`.json"`)
+	w.Write(data)
+}
+
+// requestOf returns request of URL which belongs to subject, or responds with error.
+func requestOf(m *Manager, w http.ResponseWriter, r *http.Request) (*Request, bool) {
+	logger := log.GetLogEntry(r).WithField("context", "privacy")
+
+	s, ok := subjectOf(r)
+	if !ok {
+		err := i18n.Errorf("privacy.subject_required")
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrUnauthorized(err))
+		return nil, false
+	}
+	req, err := m.ByID(chi.URLParam(r, "requestID"), s)
+	if err != nil {
+		if err == ErrNotFound {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return nil, false
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return nil, false
+	}
+	return req, true
+}
+
+type requestResponse struct {
+	*Request
+}
+
+func (rr *requestResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,171 @@
+package privacy
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var requestRows = []string{"id", "kind", "subject_user", "subject_tenant", "status", "error", "created_at", "finished_at"}
+
+func withUser(id string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(reqctx.WithUser(r.Context(), &reqctx.User{ID: id})))
+		})
+	}
+}
+
+func TestCreateHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	created := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("INSERT INTO privacy_request").WithArgs(KindErase, "42", "").
+		WillReturnRows(sqlmock.NewRows(requestRows).AddRow("7", "erase", "42", "", "pending", "", created, nil))
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.With(withUser("42")).Mount("/1.0/privacy", Routes(&Manager{db: db}))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/1.0/privacy/erase", nil))
+	if w.Code != http.StatusAccepted || w.Header().Get("Location") != "/1.0/privacy/requests/7" {
+		t.Fatalf("unexpected response: %v %v", w.Code, w.Header())
+	}
+	var req Request
+	if err := json.NewDecoder(w.Body).Decode(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.ID != "7" || req.Status != StatusPending || req.Subject.User != "42" {
+		t.Errorf("unexpected request: %+v", req)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestGetHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// request of another user is not found
+	mock.ExpectQuery("SELECT (.+) FROM privacy_request WHERE id").WithArgs("7", "42", "").
+		WillReturnRows(sqlmock.NewRows(requestRows))
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Mount("/1.0/privacy", Routes(&Manager{db: db}))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/1.0/privacy/requests/7", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous request is served: %v", w.Code)
+	}
+
+	r = handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.With(withUser("42")).Mount("/1.0/privacy", Routes(&Manager{db: db}))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/1.0/privacy/requests/7", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+type testHandler struct {
+	data   interface{}
+	err    error
+	erased []Subject
+}
+
+func (h *testHandler) Export(s Subject) (interface{}, error) { return h.data, h.err }
+func (h *testHandler) Erase(s Subject) error {
+	h.erased = append(h.erased, s)
+	return h.err
+}
+
+func TestWorker_RunPending(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	handlers := map[string]Handler{
+		"articles": &testHandler{data: []string{"a"}},
+		"usage":    &testHandler{err: errors.New("database is down")},
+	}
+	wk := NewWorker(&Manager{db: db}, func() map[string]Handler { return handlers }, time.Hour, time.Hour, 24*time.Hour, 2)
+	now := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	wk.clock = clock.NewFake(now)
+
+	mock.ExpectExec("UPDATE privacy_request SET data = NULL").WithArgs(now.Add(-24 * time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("UPDATE privacy_request SET status = 'running'").WithArgs(now, now.Add(-time.Hour)).
+		WillReturnRows(sqlmock.NewRows(requestRows).AddRow("1", "erase", "42", "", "running", "", now, nil))
+	mock.ExpectExec("UPDATE privacy_request SET status").
+		WithArgs("1", StatusFailed, "usage: database is down", nil, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("UPDATE privacy_request SET status = 'running'").WithArgs(now, now.Add(-time.Hour)).
+		WillReturnRows(sqlmock.NewRows(requestRows))
+
+	if err := wk.RunPending(log.New("", "", ioutil.Discard)); err != nil {
+		t.Fatal(err)
+	}
+	// erasure goes on when a handler fails
+	if erased := handlers["articles"].(*testHandler).erased; len(erased) != 1 || erased[0].User != "42" {
+		t.Errorf("unexpected erased subjects: %v", erased)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestWorker_Export(t *testing.T) {
+	wk := NewWorker(nil, func() map[string]Handler {
+		return map[string]Handler{"articles": &testHandler{data: []string{"a"}}, "usage": &testHandler{data: []int{1}}}
+	}, time.Hour, time.Hour, time.Hour, 2)
+
+	data, reason := wk.execute(&Request{Kind: KindExport, Subject: Subject{User: "42"}}, log.New("", "", ioutil.Discard))
+	if reason != "" || string(data) != `{"articles":["a"],"usage":[1]}` {
+		t.Errorf("unexpected export: %s %q", data, reason)
+	}
+}
+
+type panicHandler struct{}
+
+func (panicHandler) Export(s Subject) (interface{}, error) { panic("boom") }
+func (panicHandler) Erase(s Subject) error                 { panic("boom") }
+
+func TestWorker_Panic(t *testing.T) {
+	articles := &testHandler{}
+	wk := NewWorker(nil, func() map[string]Handler {
+		return map[string]Handler{"articles": articles, "broken": panicHandler{}}
+	}, time.Hour, time.Hour, time.Hour, 2)
+
+	_, reason := wk.execute(&Request{Kind: KindErase, Subject: Subject{User: "42"}}, log.New("", "", ioutil.Discard))
+	if reason != "broken: handler panicked" || len(articles.erased) != 1 {
+		t.Errorf("unexpected erasure: %q %v", reason, articles.erased)
+	}
+}
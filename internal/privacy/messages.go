@@ -0,0 +1,16 @@
+package privacy
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"privacy.subject_required": "data is exported and erased for authenticated users and tenants only",
+		"privacy.not_exported":     "data is not exported yet",
+		"privacy.export_expired":   "exported data is expired, request export again",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"privacy.subject_required": "данные экспортируются и удаляются только для аутентифицированных пользователей и арендаторов",
+		"privacy.not_exported":     "данные ещё не экспортированы",
+		"privacy.export_expired":   "срок хранения экспортированных данных истёк, запросите экспорт снова",
+	})
+}
@@ -0,0 +1,27 @@
+package privacy
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "0012_privacy_initial",
+			Up: []string{
+				`CREATE TABLE privacy_request (
+					id              SERIAL                      NOT NULL,
+					kind            character varying(16)       NOT NULL,
+					subject_user    character varying(128)      NOT NULL DEFAULT '',
+					subject_tenant  character varying(128)      NOT NULL DEFAULT '',
+					status          character varying(16)       NOT NULL DEFAULT 'pending',
+					error           text                        NOT NULL DEFAULT '',
+					data            jsonb,
+					created_at      timestamp with time zone    NOT NULL DEFAULT now(),
+					started_at      timestamp with time zone,
+					finished_at     timestamp with time zone,
+					PRIMARY KEY (id)
+				);`,
+				`CREATE INDEX privacy_request_status_idx ON privacy_request (status) WHERE status IN ('pending', 'running');`,
+			},
+		},
+	}
+}
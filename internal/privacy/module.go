@@ -0,0 +1,62 @@
+package privacy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/pkg/module"
+	"github.com/agalitsyn/goapi/pkg/retention"
+)
+
+func init() {
+	module.Register(&privacyModule{})
+}
+
+type privacyModule struct {
+	module.Base
+
+	opts struct {
+		Interval    time.Duration `long:"privacy-interval" env:"GAPI_PRIVACY_INTERVAL" default:"10s" description:"How often to execute pending data export and erasure requests."`
+		StaleAfter  time.Duration `long:"privacy-stale-after" env:"GAPI_PRIVACY_STALE_AFTER" default:"1h" description:"Request running longer is executed again, e.g. when instance running it crashed."`
+		ExportTTL   time.Duration `long:"privacy-export-ttl" env:"GAPI_PRIVACY_EXPORT_TTL" default:"168h" description:"How long exported data is available for download."`
+		Concurrency int           `long:"privacy-concurrency" env:"GAPI_PRIVACY_CONCURRENCY" default:"4" description:"How many modules export or erase data of a request at once."`
+	}
+
+	manager *Manager
+	worker  *Worker
+}
+
+func (mod *privacyModule) Name() string                     { return "privacy" }
+func (mod *privacyModule) Options() interface{}             { return &mod.opts }
+func (mod *privacyModule) Migrations() []*migrate.Migration { return Migrations() }
+func (mod *privacyModule) Jobs() []module.Job               { return []module.Job{mod.worker} }
+
+func (mod *privacyModule) Init(env *module.Env) error {
+	if mod.opts.Interval <= 0 {
+		return errors.New("--privacy-interval must be positive")
+	}
+	mod.manager = NewManager(env.DB)
+	handlers := func() map[string]Handler {
+		found := make(map[string]Handler)
+		for name, h := range env.LookupPrefix(HandlerPrefix) {
+			if h, ok := h.(Handler); ok {
+				found[name] = h
+			}
+		}
+		return found
+	}
+	mod.worker = NewWorker(mod.manager, handlers, mod.opts.Interval, mod.opts.StaleAfter, mod.opts.ExportTTL, mod.opts.Concurrency)
+	return nil
+}
+
+// RetentionRules keep finished requests for 3 years as evidence they were fulfilled.
+func (mod *privacyModule) RetentionRules() []retention.Rule {
+	return []retention.Rule{{Name: "requests", Table: "privacy_request", Column: "finished_at", MaxAge: 3 * 365 * 24 * time.Hour}}
+}
+
+func (mod *privacyModule) Routes() map[string]http.Handler {
+	return map[string]http.Handler{"/privacy": Routes(mod.manager)}
+}
@@ -0,0 +1,176 @@
+// Package privacy exports and erases personal data of users and tenants on their request, as GDPR requires.
+//
+// Modules which store data of users or tenants provide a Handler as privacy.handler.<module>.
+// Requests are executed asynchronously by a worker, clients poll their status.
+package privacy
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+// HandlerPrefix is a prefix of names modules provide handlers with, e.g. privacy.handler.usage.
+const HandlerPrefix = "privacy.handler."
+
+var ErrNotFound = errors.New("not found")
+
+// Subject is whose data is requested, either user or tenant.
+type Subject struct {
+	User   string `json:"user,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Handler exports and erases data of subject stored by a module.
+type Handler interface {
+	// Export returns data of subject, it is serialized to JSON under name of handler.
+	Export(s Subject) (interface{}, error)
+	// Erase deletes or anonymizes data of subject, it must be safe to retry.
+	Erase(s Subject) error
+}
+
+type Kind string
+
+const (
+	KindExport Kind = "export"
+	KindErase  Kind = "erase"
+)
+
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// Request is an export or erasure of subject data.
+type Request struct {
+	ID         string     `json:"id"`
+	Kind       Kind       `json:"kind"`
+	Subject    Subject    `json:"subject"`
+	Status     Status     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type Manager struct {
+	db postgres.Querier
+}
+
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{db: db}
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db)}
+}
+
+const requestColumns = "id, kind, subject_user, subject_tenant, status, error, created_at, finished_at"
+
+// Create adds pending request.
+func (m *Manager) Create(kind Kind, s Subject) (*Request, error) {
+	row := m.db.QueryRow(
+		"INSERT INTO privacy_request (kind, subject_user, subject_tenant) VALUES ($1, $2, $3) RETURNING "+requestColumns+";",
+		kind, s.User, s.Tenant,
+	)
+	req, err := scanRequest(row)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create privacy request")
+	}
+	return req, nil
+}
+
+// ByID returns request of subject, requests of others are not found.
+func (m *Manager) ByID(id string, s Subject) (*Request, error) {
+	row := m.db.QueryRow(
+		"SELECT "+requestColumns+" FROM privacy_request WHERE id = $1 AND subject_user = $2 AND subject_tenant = $3;",
+		id, s.User, s.Tenant,
+	)
+	req, err := scanRequest(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get privacy request")
+	}
+	return req, nil
+}
+
+// Data returns exported data of finished export request, nil when it is expired.
+func (m *Manager) Data(id string) (json.RawMessage, error) {
+	var data []byte
+	if err := m.db.QueryRow("SELECT data FROM privacy_request WHERE id = $1;", id).Scan(&data); err != nil {
+		return nil, errors.Wrap(err, "could not get exported data")
+	}
+	return data, nil
+}
+
+// claim marks the oldest pending request as running, requests running longer than staleAfter
+// are claimed again, e.g. after instance crashed. It returns nil when there is nothing to run.
+func (m *Manager) claim(now time.Time, staleAfter time.Duration) (*Request, error) {
+	row := m.db.QueryRow(
+		`UPDATE privacy_request SET status = 'running', started_at = $1
+		WHERE id = (
+			SELECT id FROM privacy_request
+			WHERE status = 'pending' OR (status = 'running' AND started_at < $2)
+			ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED
+		) RETURNING `+requestColumns+";",
+		now, now.Add(-staleAfter),
+	)
+	req, err := scanRequest(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not claim privacy request")
+	}
+	return req, nil
+}
+
+// finish stores result of request, failed when reason is not empty.
+func (m *Manager) finish(id string, now time.Time, data []byte, reason string) error {
+	status := StatusDone
+	if reason != "" {
+		status = StatusFailed
+	}
+	// requests without data store NULL
+	var value interface{}
+	if data != nil {
+		value = data
+	}
+	_, err := m.db.Exec(
+		"UPDATE privacy_request SET status = $2, error = $3, data = $4, finished_at = $5 WHERE id = $1;",
+		id, status, reason, value, now,
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not finish privacy request")
+	}
+	return nil
+}
+
+// expire deletes exported data finished before t, so personal data does not stay in exports forever.
+func (m *Manager) expire(t time.Time) error {
+	_, err := m.db.Exec("UPDATE privacy_request SET data = NULL WHERE data IS NOT NULL AND finished_at < $1;", t)
+	if err != nil {
+		return errors.Wrap(err, "could not expire exported data")
+	}
+	return nil
+}
+
+func scanRequest(row *sql.Row) (*Request, error) {
+	var req Request
+	err := row.Scan(&req.ID, &req.Kind, &req.Subject.User, &req.Subject.Tenant, &req.Status, &req.Error, &req.CreatedAt, &req.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
@@ -0,0 +1,177 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/workerpool"
+)
+
+// Worker executes pending requests with handlers of modules.
+type Worker struct {
+	m *Manager
+	// handlers are looked up on every run, as modules provide them after privacy module is initialized
+	handlers   func() map[string]Handler
+	interval   time.Duration
+	staleAfter time.Duration
+	exportTTL  time.Duration
+	// concurrency is how many handlers run at once for a request
+	concurrency int
+	clock       clock.Clock
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewWorker(m *Manager, handlers func() map[string]Handler, interval, staleAfter, exportTTL time.Duration, concurrency int) *Worker {
+	return &Worker{
+		m:           m,
+		handlers:    handlers,
+		interval:    interval,
+		staleAfter:  staleAfter,
+		exportTTL:   exportTTL,
+		concurrency: concurrency,
+		clock:       clock.Real,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// RunPending executes pending requests one by one until there are none, and expires old exports.
+func (wk *Worker) RunPending(logger log.Logger) error {
+	if err := wk.m.expire(wk.clock.Now().Add(-wk.exportTTL)); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-wk.stop:
+			return nil
+		default:
+		}
+
+		req, err := wk.m.claim(wk.clock.Now(), wk.staleAfter)
+		if err != nil {
+			return err
+		}
+		if req == nil {
+			return nil
+		}
+
+		data, reason := wk.execute(req, logger)
+		if reason != "" {
+			logger.WithField("context", "privacy").WithField("request", req.ID).Errorf("%s failed: %s", req.Kind, reason)
+		}
+		if err := wk.m.finish(req.ID, wk.clock.Now(), data, reason); err != nil {
+			return err
+		}
+	}
+}
+
+// execute runs request with every handler, erasure goes on when some handlers fail,
+// so a retried request has less to erase.
+func (wk *Worker) execute(req *Request, logger log.Logger) ([]byte, string) {
+	handlers := wk.handlers()
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	switch req.Kind {
+	case KindExport:
+		var (
+			mu     sync.Mutex
+			export = make(map[string]interface{}, len(handlers))
+		)
+		errs := wk.each(handlers, names, logger, func(name string, h Handler) error {
+			data, err := h.Export(req.Subject)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			export[name] = data
+			mu.Unlock()
+			return nil
+		})
+		if len(errs) > 0 {
+			return nil, errs[0]
+		}
+		data, err := json.Marshal(export)
+		if err != nil {
+			return nil, err.Error()
+		}
+		return data, ""
+	case KindErase:
+		errs := wk.each(handlers, names, logger, func(name string, h Handler) error {
+			return h.Erase(req.Subject)
+		})
+		return nil, strings.Join(errs, "; ")
+	default:
+		return nil, fmt.Sprintf("unknown kind %q", req.Kind)
+	}
+}
+
+var errPanicked = errors.New("handler panicked")
+
+// each calls fn with handlers in a worker pool, so slow modules do not add up and a panicking
+// handler fails only its part of request. Failures are returned in order of names.
+func (wk *Worker) each(handlers map[string]Handler, names []string, logger log.Logger, fn func(name string, h Handler) error) []string {
+	pool := workerpool.New("privacy", wk.concurrency, len(names))
+	go pool.Run(logger)
+
+	var mu sync.Mutex
+	errs := make(map[string]error, len(names))
+	for _, name := range names {
+		name := name
+		errs[name] = errPanicked
+		pool.TrySubmit(func(ctx context.Context) {
+			err := fn(name, handlers[name])
+			mu.Lock()
+			errs[name] = err
+			mu.Unlock()
+		})
+	}
+	pool.Close()
+
+	var failed []string
+	for _, name := range names {
+		if err := errs[name]; err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	return failed
+}
+
+// Run executes pending requests every interval until Close is called.
+func (wk *Worker) Run(logger log.Logger) {
+	defer close(wk.done)
+
+	ticker := wk.clock.NewTicker(wk.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := wk.RunPending(logger); err != nil {
+				logger.WithError(err).Error()
+			}
+		case <-wk.stop:
+			return
+		}
+	}
+}
+
+// Close stops Run, request in progress is finished first.
+func (wk *Worker) Close() error {
+	close(wk.stop)
+	<-wk.done
+	return nil
+}
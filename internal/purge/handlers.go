@@ -0,0 +1,78 @@
+// Package purge serves admin endpoint to purge cached responses by surrogate keys, e.g. after data
+// is changed bypassing the API.
+package purge
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+	"github.com/agalitsyn/goapi/pkg/surrogate"
+)
+
+// Routes are admin endpoints to purge internal cache and CDN.
+func Routes(p surrogate.Purger) chi.Router {
+	r := chi.NewRouter()
+	r.Use(handler.RequireRole(reqctx.AdminRole))
+	r.Post("/", makeHandler(p, purgeHandler))
+	return r
+}
+
+type handlerFunc func(p surrogate.Purger, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(p surrogate.Purger, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(p, w, r)
+	}
+}
+
+// purgeHandler purges keys passed as {"keys": ["article:42"]}.
+func purgeHandler(p surrogate.Purger, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "purge")
+
+	var data purgeRequest
+	if err := serializer.Decode(r, &data); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	if err := data.validate(); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	if err := p.Purge(r.Context(), data.Keys...); err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	logger.WithField("user", reqctx.GetUser(r.Context()).ID).Infof("purged %s", strings.Join(data.Keys, " "))
+	render.Render(w, r, &data)
+}
+
+type purgeRequest struct {
+	Keys []string `json:"keys"`
+}
+
+func (pr *purgeRequest) validate() error {
+	if len(pr.Keys) == 0 {
+		return i18n.Errorf("purge.keys_required")
+	}
+	for _, k := range pr.Keys {
+		if k == "" || strings.ContainsAny(k, " \t\r\n") {
+			return i18n.Errorf("purge.invalid_key", k)
+		}
+	}
+	return nil
+}
+
+func (pr *purgeRequest) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,66 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type purger struct{ keys []string }
+
+func (p *purger) Purge(ctx context.Context, keys ...string) error {
+	p.keys = append(p.keys, keys...)
+	return nil
+}
+
+func TestRoutes(t *testing.T) {
+	admin := &reqctx.User{ID: "2", Roles: []string{reqctx.AdminRole}}
+	tests := []struct {
+		name   string
+		user   *reqctx.User
+		body   string
+		status int
+		keys   []string
+	}{
+		{"anonymous", nil, `{"keys": ["article:42"]}`, http.StatusUnauthorized, nil},
+		{"user", &reqctx.User{ID: "1"}, `{"keys": ["article:42"]}`, http.StatusForbidden, nil},
+		{"admin", admin, `{"keys": ["article:42", "articles"]}`, http.StatusOK, []string{"article:42", "articles"}},
+		{"no_keys", admin, `{"keys": []}`, http.StatusBadRequest, nil},
+		{"invalid_key", admin, `{"keys": ["article 42"]}`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		p := &purger{}
+
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(tt.user))
+		r.Mount("/purge", Routes(p))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://example.com/purge", bytes.NewBufferString(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s: unexpected status: %v %s", tt.name, w.Code, w.Body.String())
+		}
+		if !reflect.DeepEqual(p.keys, tt.keys) {
+			t.Errorf("%s: unexpected purged keys: %v", tt.name, p.keys)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package purge
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"purge.keys_required": "at least one surrogate key is required",
+		"purge.invalid_key":   "surrogate key %q must not be empty or contain spaces",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"purge.keys_required": "нужен хотя бы один суррогатный ключ",
+		"purge.invalid_key":   "суррогатный ключ %q не может быть пустым или содержать пробелы",
+	})
+}
@@ -0,0 +1,64 @@
+package revocation
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/jwtauth"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+)
+
+// Routes are admin endpoints to revoke tokens by their jti claim.
+func Routes(s jwtauth.Revocations) chi.Router {
+	r := chi.NewRouter()
+	r.Use(handler.RequireRole(reqctx.AdminRole))
+	r.Post("/", makeHandler(s, createHandler))
+	return r
+}
+
+type handlerFunc func(s jwtauth.Revocations, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(s jwtauth.Revocations, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(s, w, r)
+	}
+}
+
+// createHandler revokes token, expires_at of token lets the list forget it, it is kept forever if omitted.
+func createHandler(s jwtauth.Revocations, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "revocation")
+
+	var data struct {
+		ID        string     `json:"id"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := serializer.Decode(r, &data); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	if data.ID == "" {
+		err := i18n.Errorf("revocation.id_required")
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	var expires time.Time
+	if data.ExpiresAt != nil {
+		expires = *data.ExpiresAt
+	}
+	if err := s.Revoke(r.Context(), data.ID, expires); err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	logger.WithField("user", reqctx.GetUser(r.Context()).ID).Warnf("token %s revoked", data.ID)
+	render.NoContent(w, r)
+}
@@ -0,0 +1,76 @@
+package revocation
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestCreateHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	newRouter := func(u *reqctx.User) http.Handler {
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(u))
+		r.Mount("/admin/tokens/revocations", Routes(NewStore(db)))
+		return r
+	}
+	admin := &reqctx.User{ID: "a1", Roles: []string{reqctx.AdminRole}}
+	expires := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec("INSERT INTO revoked_tokens(.+) ON CONFLICT \\(id\\) DO NOTHING;").
+		WithArgs("t1", expires).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO revoked_tokens(.+)").
+		WithArgs("t2", nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	tests := []struct {
+		user   *reqctx.User
+		body   string
+		status int
+	}{
+		{admin, `{"id":"t1","expires_at":"2018-05-01T12:00:00Z"}`, http.StatusNoContent},
+		{admin, `{"id":"t2"}`, http.StatusNoContent},
+		{admin, `{"expires_at":"2018-05-01T12:00:00Z"}`, http.StatusBadRequest},
+		{admin, `{`, http.StatusBadRequest},
+		{&reqctx.User{ID: "u1"}, `{"id":"t3"}`, http.StatusForbidden},
+		{nil, `{"id":"t3"}`, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/admin/tokens/revocations", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		newRouter(tt.user).ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.body, tt.status, w.Code, w.Body)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,12 @@
+package revocation
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"revocation.id_required": "id of token is required",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"revocation.id_required": "необходимо указать id токена",
+	})
+}
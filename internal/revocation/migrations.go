@@ -0,0 +1,20 @@
+package revocation
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "0027_revoked_tokens",
+			Up: []string{
+				`CREATE TABLE revoked_tokens (
+					id          character varying(255)      NOT NULL,
+					expires_at  timestamp with time zone,
+					created_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id)
+				);`,
+				`CREATE INDEX revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);`,
+			},
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package revocation
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/pkg/jwtauth"
+	"github.com/agalitsyn/goapi/pkg/module"
+	"github.com/agalitsyn/goapi/pkg/redis"
+	"github.com/agalitsyn/goapi/pkg/retention"
+)
+
+func init() {
+	module.Register(&revocationModule{})
+}
+
+type revocationModule struct {
+	module.Base
+
+	opts struct {
+		Store string `long:"token-revocation-store" env:"GAPI_TOKEN_REVOCATION_STORE" default:"none" choice:"none" choice:"postgres" choice:"redis" description:"Where IDs of revoked access tokens are kept: postgres, or redis configured with --redis-url. Revocation is disabled if none."`
+	}
+
+	store jwtauth.Revocations
+}
+
+func (mod *revocationModule) Name() string                     { return "revocation" }
+func (mod *revocationModule) Options() interface{}             { return &mod.opts }
+func (mod *revocationModule) Migrations() []*migrate.Migration { return Migrations() }
+
+func (mod *revocationModule) Init(env *module.Env) error {
+	switch mod.opts.Store {
+	case "postgres":
+		mod.store = NewStore(env.DB)
+	case "redis":
+		c, ok := env.Lookup("redis.client")
+		if !ok {
+			return errors.New("redis token revocation store requires --redis-url")
+		}
+		mod.store = jwtauth.NewRedisRevocations(c.(*redis.Client))
+	default:
+		return nil
+	}
+	env.Provide(jwtauth.RevocationsService, mod.store)
+	return nil
+}
+
+func (mod *revocationModule) Routes() map[string]http.Handler {
+	if mod.store == nil {
+		return nil
+	}
+	return map[string]http.Handler{"/admin/tokens/revocations": Routes(mod.store)}
+}
+
+// RetentionRules forget tokens a day after they expire, expired tokens are rejected anyway.
+func (mod *revocationModule) RetentionRules() []retention.Rule {
+	return []retention.Rule{{Name: "expired", Table: "revoked_tokens", Column: "expires_at", MaxAge: 24 * time.Hour}}
+}
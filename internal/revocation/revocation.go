@@ -0,0 +1,46 @@
+// Package revocation keeps revocation list of access tokens, see pkg/jwtauth, and serves admin endpoint
+// to revoke tokens, e.g. leaked ones, before they expire.
+package revocation
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+// Store keeps IDs of revoked tokens in Postgres, they are purged by retention after tokens expire.
+type Store struct {
+	db postgres.Querier
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) Revoke(ctx context.Context, id string, expires time.Time) error {
+	var exp *time.Time
+	if !expires.IsZero() {
+		exp = &expires
+	}
+	_, err := postgres.WithContext(ctx, s.db).Exec(
+		"INSERT INTO revoked_tokens(id, expires_at) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING;",
+		id, exp,
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not revoke token")
+	}
+	return nil
+}
+
+func (s *Store) IsRevoked(ctx context.Context, id string) (bool, error) {
+	var revoked bool
+	err := postgres.WithContext(ctx, s.db).QueryRow("SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE id = $1);", id).Scan(&revoked)
+	if err != nil {
+		return false, errors.Wrap(err, "could not check token revocation")
+	}
+	return revoked, nil
+}
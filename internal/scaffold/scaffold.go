@@ -0,0 +1,102 @@
+// Package scaffold generates boilerplate of a new internal module: manager, routes, migrations,
+// messages and tests, laid out like the existing modules.
+package scaffold
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	validName   = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+	migrationID = regexp.MustCompile(`Id:\s*"(\d{4})_`)
+)
+
+type module struct {
+	Name string
+	// Type is exported name of the model.
+	Type string
+	// Migration is ID prefix of the initial migration.
+	Migration string
+}
+
+// Generate writes module name into dir, e.g. internal, and returns paths of created files.
+// Migration IDs are global, so the next one is found among migrations of existing modules in dir.
+func Generate(dir, name string) ([]string, error) {
+	if !validName.MatchString(name) {
+		return nil, errors.Errorf("invalid module name %q, it must be a lowercase Go package name", name)
+	}
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil, errors.Errorf("module %s already exists", path)
+	}
+	next, err := nextMigration(dir)
+	if err != nil {
+		return nil, err
+	}
+	mod := module{Name: name, Type: strings.ToUpper(name[:1]) + name[1:], Migration: fmt.Sprintf("%04d", next)}
+
+	files := map[string][]byte{}
+	funcs := template.FuncMap{"bt": func() string { return "`" }}
+	for file, text := range templates {
+		tmpl, err := template.New(file).Funcs(funcs).Parse(text)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not parse template %s", file)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, mod); err != nil {
+			return nil, errors.Wrapf(err, "could not execute template %s", file)
+		}
+		src, err := format.Source(buf.Bytes())
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not format %s", file)
+		}
+		files[strings.Replace(file, "{{.Name}}", name, 1)] = src
+	}
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, errors.Wrap(err, "could not create module directory")
+	}
+	var created []string
+	for file, src := range files {
+		p := filepath.Join(path, file)
+		if err := ioutil.WriteFile(p, src, 0644); err != nil {
+			return nil, errors.Wrapf(err, "could not write %s", p)
+		}
+		created = append(created, p)
+	}
+	sort.Strings(created)
+	return created, nil
+}
+
+// nextMigration returns the number following the largest migration ID of modules in dir.
+func nextMigration(dir string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*", "migrations.go"))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not list migrations")
+	}
+	max := 0
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return 0, errors.Wrap(err, "could not read migrations")
+		}
+		for _, m := range migrationID.FindAllSubmatch(data, -1) {
+			if n, _ := strconv.Atoi(string(m[1])); n > max {
+				max = n
+			}
+		}
+	}
+	return max + 1, nil
+}
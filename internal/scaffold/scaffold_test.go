@@ -0,0 +1,50 @@
+package scaffold
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "article"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "article", "migrations.go"), []byte(`{Id: "0001_article_initial"}, {Id: "0012_article_tags"}`), 0644)
+
+	files, err := Generate(dir, "comment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"comment.go", "handlers.go", "handlers_test.go", "messages.go", "migrations.go", "module.go"}
+	if len(files) != len(want) {
+		t.Fatalf("unexpected files: %v", files)
+	}
+	for i, f := range files {
+		if f != filepath.Join(dir, "comment", want[i]) {
+			t.Errorf("unexpected file: %v", f)
+		}
+	}
+
+	src, _ := ioutil.ReadFile(filepath.Join(dir, "comment", "migrations.go"))
+	if !strings.Contains(string(src), `Id: "0013_comment_initial"`) {
+		t.Errorf("unexpected migrations:\n%s", src)
+	}
+	src, _ = ioutil.ReadFile(filepath.Join(dir, "comment", "comment.go"))
+	if !strings.Contains(string(src), "type Comment struct {") || !strings.Contains(string(src), "`json:\"created_at\"`") {
+		t.Errorf("unexpected model:\n%s", src)
+	}
+
+	if _, err := Generate(dir, "comment"); err == nil {
+		t.Error("existing module is overwritten")
+	}
+	if _, err := Generate(dir, "Comment"); err == nil {
+		t.Error("invalid name is accepted")
+	}
+}
@@ -0,0 +1,379 @@
+package scaffold
+
+// templates mirror the structure of article and attachment modules, {{bt}} is a backtick.
+var templates = map[string]string{
+	"{{.Name}}.go": `package {{.Name}}
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type {{.Type}} struct {
+	ID        string    {{bt}}json:"id"{{bt}}
+	Name      string    {{bt}}json:"name"{{bt}}
+	CreatedAt time.Time {{bt}}json:"created_at"{{bt}}
+}
+
+type Manager struct {
+	db postgres.Querier
+}
+
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{db: db}
+}
+
+// Tx runs fn with manager bound to a transaction, which is rolled back when fn fails or on dry run.
+func (m *Manager) Tx(dryRun bool, fn func(m *Manager) error) error {
+	return postgres.Tx(m.db, dryRun, func(tx postgres.Querier) error {
+		return fn(&Manager{db: tx})
+	})
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db)}
+}
+
+func (m *Manager) Save(v *{{.Type}}) error {
+	err := m.db.QueryRow(
+		"INSERT INTO {{.Name}}(name) VALUES ($1) RETURNING id, created_at;", v.Name,
+	).Scan(&v.ID, &v.CreatedAt)
+	if err != nil {
+		return errors.Wrap(err, "could not save {{.Name}}")
+	}
+	return nil
+}
+
+func (m *Manager) Delete(v *{{.Type}}) error {
+	if _, err := m.db.Exec("DELETE FROM {{.Name}} WHERE id = $1;", v.ID); err != nil {
+		return errors.Wrap(err, "could not delete {{.Name}}")
+	}
+	return nil
+}
+
+func (m *Manager) ByID(id string) (*{{.Type}}, error) {
+	var v {{.Type}}
+	err := m.db.QueryRow("SELECT id, name, created_at FROM {{.Name}} WHERE id = $1;", id).Scan(&v.ID, &v.Name, &v.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get {{.Name}} by id")
+	}
+	return &v, nil
+}
+
+func (m *Manager) All() ([]*{{.Type}}, error) {
+	rows, err := m.db.Query("SELECT id, name, created_at FROM {{.Name}} ORDER BY id;")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get {{.Name}} list")
+	}
+	defer rows.Close()
+
+	var list []*{{.Type}}
+	for rows.Next() {
+		var v {{.Type}}
+		if err := rows.Scan(&v.ID, &v.Name, &v.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan row to {{.Name}} model")
+		}
+		list = append(list, &v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get {{.Name}} list")
+	}
+	return list, nil
+}
+`,
+
+	"handlers.go": `package {{.Name}}
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+)
+
+func Routes(m *Manager) chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", makeHandler(m, listHandler))
+	r.Post("/", makeHandler(m, createHandler))
+
+	r.Route("/{id}", func(r chi.Router) {
+		r.Get("/", makeHandler(m, getHandler))
+		r.Delete("/", makeHandler(m, deleteHandler))
+	})
+
+	return r
+}
+
+type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m.WithContext(r.Context()), w, r)
+	}
+}
+
+func listHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "{{.Name}}")
+
+	list, err := m.All()
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	if err := render.RenderList(w, r, new{{.Type}}ListResponse(list)); err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+}
+
+func getHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "{{.Name}}")
+
+	v, err := m.ByID(chi.URLParam(r, "id"))
+	if err != nil {
+		if err == ErrNotFound {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.Render(w, r, new{{.Type}}Response(v))
+}
+
+func createHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "{{.Name}}")
+	dryRun := handler.DryRun(w, r)
+
+	var data {{.Name}}Request
+	if err := serializer.Decode(r, &data); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	if err := data.validate(); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+
+	v := &{{.Type}}{Name: data.Name}
+	err := m.Tx(dryRun, func(m *Manager) error {
+		return m.Save(v)
+	})
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, new{{.Type}}Response(v))
+}
+
+func deleteHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "{{.Name}}")
+	dryRun := handler.DryRun(w, r)
+
+	v, err := m.ByID(chi.URLParam(r, "id"))
+	if err != nil {
+		if err == ErrNotFound {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	err = m.Tx(dryRun, func(m *Manager) error {
+		return m.Delete(v)
+	})
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	if dryRun {
+		render.Render(w, r, new{{.Type}}Response(v))
+		return
+	}
+	render.NoContent(w, r)
+}
+
+type {{.Name}}Request struct {
+	Name string {{bt}}json:"name"{{bt}}
+}
+
+func (req *{{.Name}}Request) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return i18n.Errorf("{{.Name}}.name_required")
+	}
+	return nil
+}
+
+func new{{.Type}}ListResponse(list []*{{.Type}}) []render.Renderer {
+	resp := []render.Renderer{}
+	for _, v := range list {
+		resp = append(resp, new{{.Type}}Response(v))
+	}
+	return resp
+}
+
+func new{{.Type}}Response(v *{{.Type}}) *{{.Name}}Response {
+	return &{{.Name}}Response{v}
+}
+
+type {{.Name}}Response struct {
+	*{{.Type}}
+}
+
+func (resp *{{.Name}}Response) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+`,
+
+	"module.go": `package {{.Name}}
+
+import (
+	"net/http"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/pkg/module"
+)
+
+func init() {
+	module.Register(&{{.Name}}Module{})
+}
+
+type {{.Name}}Module struct {
+	module.Base
+
+	manager *Manager
+}
+
+func (mod *{{.Name}}Module) Name() string                     { return "{{.Name}}" }
+func (mod *{{.Name}}Module) Migrations() []*migrate.Migration { return Migrations() }
+
+func (mod *{{.Name}}Module) Init(env *module.Env) error {
+	mod.manager = NewManager(env.DB)
+	return nil
+}
+
+func (mod *{{.Name}}Module) Routes() map[string]http.Handler {
+	return map[string]http.Handler{"/{{.Name}}": Routes(mod.manager)}
+}
+`,
+
+	"migrations.go": `package {{.Name}}
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "{{.Migration}}_{{.Name}}_initial",
+			Up: []string{
+				{{bt}}CREATE TABLE {{.Name}} (
+					id          serial                      NOT NULL,
+					name        character varying(256)      NOT NULL,
+					created_at  timestamp with time zone    NOT NULL DEFAULT current_timestamp,
+					PRIMARY KEY (id)
+				);{{bt}},
+			},
+		},
+	}
+}
+`,
+
+	"messages.go": `package {{.Name}}
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"{{.Name}}.name_required": "name is required",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"{{.Name}}.name_required": "необходимо указать название",
+	})
+}
+`,
+
+	"handlers_test.go": `package {{.Name}}
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestListHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, name, created_at FROM {{.Name}} ORDER BY id;").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at"}).AddRow(1, "First", time.Now()))
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Mount("/", Routes(&Manager{db: db}))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), {{bt}}"name":"First"{{bt}}) {
+		t.Errorf("unexpected response: %v %s", w.Code, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestCreateHandler_Validation(t *testing.T) {
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Mount("/", Routes(&Manager{}))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader({{bt}}{"name": " "}{{bt}}))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+}
+`,
+}
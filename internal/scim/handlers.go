@@ -0,0 +1,721 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+
+	"github.com/agalitsyn/goapi/internal/user"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// Schemas of resources and messages.
+const (
+	UserSchema   = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema  = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ListSchema   = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchSchema  = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema  = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+// ContentType of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// MaxCount is the largest page of lists.
+const MaxCount = 100
+
+// Config of SCIM endpoints.
+type Config struct {
+	// Role is required from clients, it is granted to tokens of identity provider.
+	Role string
+	// BaseURL is public URL of endpoints, locations of resources are built from it.
+	BaseURL string
+	// Events are handlers of account events by name, see user.EventHandlerPrefix.
+	Events map[string]user.EventHandler
+	// GroupRoles are roles groups may grant, entries ending with * match roles by prefix, e.g. team-*.
+	// Groups of other display names are refused, so identity provider does not grant e.g. admin.
+	GroupRoles []string
+}
+
+// manages reports whether groups may grant role.
+func (cfg Config) manages(role string) bool {
+	for _, r := range cfg.GroupRoles {
+		if r == role || strings.HasSuffix(r, "*") && strings.HasPrefix(role, strings.TrimSuffix(r, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Routes serve SCIM Users and Groups to clients with role of config. Responses are in SCIM format
+// rather than in format of API, as identity providers expect it.
+func Routes(m *Manager, cfg Config) chi.Router {
+	r := chi.NewRouter()
+	r.Use(handler.RequireRoleFunc(cfg.Role, renderError))
+	r.Get("/ServiceProviderConfig", serviceProviderConfigHandler)
+	r.Route("/Users", func(r chi.Router) {
+		r.Get("/", makeHandler(m, listUsersHandler(cfg)))
+		r.Post("/", makeHandler(m, createUserHandler(cfg)))
+		r.Get("/{id}", makeHandler(m, getUserHandler(cfg)))
+		r.Put("/{id}", makeHandler(m, replaceUserHandler(cfg)))
+		r.Patch("/{id}", makeHandler(m, patchUserHandler(cfg)))
+		r.Delete("/{id}", makeHandler(m, deleteUserHandler(cfg)))
+	})
+	r.Route("/Groups", func(r chi.Router) {
+		r.Get("/", makeHandler(m, listGroupsHandler(cfg)))
+		r.Post("/", makeHandler(m, createGroupHandler(cfg)))
+		r.Get("/{id}", makeHandler(m, getGroupHandler(cfg)))
+		r.Put("/{id}", makeHandler(m, replaceGroupHandler(cfg)))
+		r.Patch("/{id}", makeHandler(m, patchGroupHandler(cfg)))
+		r.Delete("/{id}", makeHandler(m, deleteGroupHandler(cfg)))
+	})
+	return r
+}
+
+type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m.WithContext(r.Context()), w, r)
+	}
+}
+
+// userColumnsByAttr map attributes of users which lists are filtered by to columns.
+var userColumnsByAttr = map[string]string{"username": "email", "externalid": "external_id"}
+
+// groupColumnsByAttr map attributes of groups which lists are filtered by to columns.
+var groupColumnsByAttr = map[string]string{"displayname": "display_name", "externalid": "external_id"}
+
+// listUsersHandler serves users, e.g. ?filter=userName eq "ann@example.com"&startIndex=1&count=100.
+func listUsersHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "scim")
+
+		f, p, err := parseListQuery(r, userColumnsByAttr)
+		if err != nil {
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if f.Column == "email" {
+			f.Value = user.NormalizeEmail(f.Value)
+		}
+		users, total, err := m.ListUsers(f, p)
+		if err != nil {
+			logger.WithError(err).Error()
+			renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		resources := make([]*userResource, 0, len(users))
+		for _, u := range users {
+			resources = append(resources, newUserResource(cfg, u))
+		}
+		renderJSON(w, http.StatusOK, &listResponse{
+			Schemas:      []string{ListSchema},
+			TotalResults: total,
+			StartIndex:   p.StartIndex,
+			ItemsPerPage: len(resources),
+			Resources:    resources,
+		})
+	}
+}
+
+func getUserHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "scim")
+
+		id := chi.URLParam(r, "id")
+		u, err := m.GetUser(id)
+		if err == ErrNotFound {
+			err := i18n.Errorf("scim.user_not_found", id)
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		renderJSON(w, http.StatusOK, newUserResource(cfg, u))
+	}
+}
+
+// createUserHandler provisions user, password is optional as users usually sign in with identity provider.
+func createUserHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "scim")
+
+		var res userResource
+		if err := decode(r, &res); err != nil {
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		u := &User{Active: true}
+		if err := res.apply(u); err != nil {
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		err := m.CreateUser(u, res.Password)
+		if err == ErrConflict {
+			err := i18n.Errorf("scim.user_exists")
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		logger.WithField("user", u.ID).Info("user provisioned")
+		user.Emit(r, cfg.Events, user.Event{Action: user.ActionProvisioned, UserID: u.ID, Email: u.UserName})
+		resource := newUserResource(cfg, u)
+		w.Header().Set("Location", resource.Meta.Location)
+		renderJSON(w, http.StatusCreated, resource)
+	}
+}
+
+// replaceUserHandler replaces attributes of user, active is true unless it is set.
+func replaceUserHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "scim")
+
+		var res userResource
+		if err := decode(r, &res); err != nil {
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		updateUser(cfg, m, w, r, res.Password, func(u *User) error {
+			u.Active, u.ExternalID = true, ""
+			return res.apply(u)
+		})
+	}
+}
+
+// patchUserHandler changes active, userName and externalId of user, e.g. deactivates user with
+// {"Operations": [{"op": "replace", "path": "active", "value": false}]}.
+func patchUserHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "scim")
+
+		var data patchRequest
+		if err := decode(r, &data); err != nil {
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		updateUser(cfg, m, w, r, "", func(u *User) error {
+			if err := patchUser(u, data.Operations); err != nil {
+				return err
+			}
+			return validateUserName(u.UserName)
+		})
+	}
+}
+
+// updateUser changes user with fn and renders it, deactivation and reactivation are emitted as such.
+func updateUser(cfg Config, m *Manager, w http.ResponseWriter, r *http.Request, password string, fn func(u *User) error) {
+	logger := log.GetLogEntry(r).WithField("context", "scim")
+
+	id := chi.URLParam(r, "id")
+	u, wasActive, err := m.UpdateUser(id, password, fn)
+	switch err.(type) {
+	case nil:
+	case *i18n.Error:
+		logger.WithError(err).Warn()
+		renderError(w, r, http.StatusBadRequest, err)
+		return
+	default:
+		switch err {
+		case ErrNotFound:
+			err := i18n.Errorf("scim.user_not_found", id)
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusNotFound, err)
+		case ErrConflict:
+			err := i18n.Errorf("scim.user_exists")
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusConflict, err)
+		default:
+			logger.WithError(err).Error()
+			renderError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	action := user.ActionUpdated
+	switch {
+	case wasActive && !u.Active:
+		action = user.ActionDeactivated
+	case !wasActive && u.Active:
+		action = user.ActionReactivated
+	}
+	logger.WithField("user", u.ID).Infof("user is %s", action)
+	user.Emit(r, cfg.Events, user.Event{Action: action, UserID: u.ID, Email: u.UserName})
+	renderJSON(w, http.StatusOK, newUserResource(cfg, u))
+}
+
+// deleteUserHandler deletes user, identity providers usually deactivate users instead.
+func deleteUserHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "scim")
+
+		id := chi.URLParam(r, "id")
+		u, err := m.DeleteUser(id)
+		if err == ErrNotFound {
+			err := i18n.Errorf("scim.user_not_found", id)
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		logger.WithField("user", u.ID).Info("user deleted")
+		user.Emit(r, cfg.Events, user.Event{Action: user.ActionDeleted, UserID: u.ID, Email: u.UserName})
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// listGroupsHandler serves groups, ?excludedAttributes=members skips members.
+func listGroupsHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "scim")
+
+		f, p, err := parseListQuery(r, groupColumnsByAttr)
+		if err != nil {
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		members := !strings.EqualFold(r.URL.Query().Get("excludedAttributes"), "members")
+		groups, total, err := m.ListGroups(f, p, members)
+		if err != nil {
+			logger.WithError(err).Error()
+			renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		resources := make([]*groupResource, 0, len(groups))
+		for _, g := range groups {
+			resources = append(resources, newGroupResource(cfg, g))
+		}
+		renderJSON(w, http.StatusOK, &listResponse{
+			Schemas:      []string{ListSchema},
+			TotalResults: total,
+			StartIndex:   p.StartIndex,
+			ItemsPerPage: len(resources),
+			Resources:    resources,
+		})
+	}
+}
+
+func getGroupHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "scim")
+
+		id := chi.URLParam(r, "id")
+		g, err := m.GetGroup(id)
+		if err == ErrNotFound {
+			err := i18n.Errorf("scim.group_not_found", id)
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			renderError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		renderJSON(w, http.StatusOK, newGroupResource(cfg, g))
+	}
+}
+
+// createGroupHandler creates group and grants its displayName as role to members.
+func createGroupHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "scim")
+
+		var res groupResource
+		if err := decode(r, &res); err != nil {
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		g := &Group{}
+		if err := res.apply(g); err != nil {
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if !cfg.manages(g.DisplayName) {
+			err := i18n.Errorf("scim.unmanaged_role", g.DisplayName)
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		changes, err := m.CreateGroup(g)
+		if !groupError(w, r, err, "") {
+			return
+		}
+		logger.WithField("group", g.ID).Infof("group %s is created", g.DisplayName)
+		emitRoleChanges(cfg, r, changes)
+		resource := newGroupResource(cfg, g)
+		w.Header().Set("Location", resource.Meta.Location)
+		renderJSON(w, http.StatusCreated, resource)
+	}
+}
+
+// replaceGroupHandler replaces displayName and members of group.
+func replaceGroupHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "scim")
+
+		var res groupResource
+		if err := decode(r, &res); err != nil {
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		updateGroup(cfg, m, w, r, func(g *Group) error {
+			g.ExternalID = ""
+			return res.apply(g)
+		})
+	}
+}
+
+// patchGroupHandler adds and removes members and renames group, e.g. with
+// {"Operations": [{"op": "remove", "path": "members[value eq \"1\"]"}]}.
+func patchGroupHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "scim")
+
+		var data patchRequest
+		if err := decode(r, &data); err != nil {
+			logger.WithError(err).Warn()
+			renderError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		updateGroup(cfg, m, w, r, func(g *Group) error {
+			if err := patchGroup(g, data.Operations); err != nil {
+				return err
+			}
+			return validateDisplayName(g.DisplayName)
+		})
+	}
+}
+
+// updateGroup changes group with fn and renders it, roles granted and revoked are emitted. Groups of roles
+// which are not managed with SCIM are not changed, e.g. ones created before roles are configured.
+func updateGroup(cfg Config, m *Manager, w http.ResponseWriter, r *http.Request, fn func(g *Group) error) {
+	id := chi.URLParam(r, "id")
+	g, changes, err := m.UpdateGroup(id, func(g *Group) error {
+		if err := fn(g); err != nil {
+			return err
+		}
+		if !cfg.manages(g.DisplayName) {
+			return i18n.Errorf("scim.unmanaged_role", g.DisplayName)
+		}
+		return nil
+	})
+	if !groupError(w, r, err, id) {
+		return
+	}
+	log.GetLogEntry(r).WithField("context", "scim").WithField("group", g.ID).Infof("group %s is updated", g.DisplayName)
+	emitRoleChanges(cfg, r, changes)
+	renderJSON(w, http.StatusOK, newGroupResource(cfg, g))
+}
+
+// deleteGroupHandler deletes group and revokes its role from members.
+func deleteGroupHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		changes, err := m.DeleteGroup(id)
+		if !groupError(w, r, err, id) {
+			return
+		}
+		log.GetLogEntry(r).WithField("context", "scim").WithField("group", id).Info("group deleted")
+		emitRoleChanges(cfg, r, changes)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// groupError renders error of changing group and returns false, or returns true if there is none.
+func groupError(w http.ResponseWriter, r *http.Request, err error, id string) bool {
+	logger := log.GetLogEntry(r).WithField("context", "scim")
+
+	if err == nil {
+		return true
+	}
+	if _, ok := err.(*i18n.Error); ok {
+		logger.WithError(err).Warn()
+		renderError(w, r, http.StatusBadRequest, err)
+		return false
+	}
+	switch err {
+	case ErrNotFound:
+		err := i18n.Errorf("scim.group_not_found", id)
+		logger.WithError(err).Warn()
+		renderError(w, r, http.StatusNotFound, err)
+	case ErrConflict:
+		err := i18n.Errorf("scim.group_exists")
+		logger.WithError(err).Warn()
+		renderError(w, r, http.StatusConflict, err)
+	case ErrUnknownMember:
+		err := i18n.Errorf("scim.unknown_member")
+		logger.WithError(err).Warn()
+		renderError(w, r, http.StatusBadRequest, err)
+	default:
+		logger.WithError(err).Error()
+		renderError(w, r, http.StatusInternalServerError, err)
+	}
+	return false
+}
+
+func emitRoleChanges(cfg Config, r *http.Request, changes []RoleChange) {
+	for _, c := range changes {
+		action := user.ActionRoleRevoked
+		if c.Granted {
+			action = user.ActionRoleGranted
+		}
+		user.Emit(r, cfg.Events, user.Event{Action: action, UserID: c.ID, Email: c.UserName, Detail: c.Role})
+	}
+}
+
+// serviceProviderConfigHandler tells identity providers which features are supported.
+func serviceProviderConfigHandler(w http.ResponseWriter, r *http.Request) {
+	type supported struct {
+		Supported bool `json:"supported"`
+	}
+	renderJSON(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{ConfigSchema},
+		"patch":          supported{true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": MaxCount},
+		"changePassword": supported{true},
+		"sort":           supported{false},
+		"etag":           supported{false},
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication with bearer token",
+		}},
+	})
+}
+
+// parseListQuery parses filter, startIndex and count of list request, startIndex is 1 and count is
+// MaxCount by default.
+func parseListQuery(r *http.Request, columns map[string]string) (Filter, Page, error) {
+	q := r.URL.Query()
+	p := Page{StartIndex: 1, Count: MaxCount}
+	f, err := parseFilter(q.Get("filter"), columns)
+	if err != nil {
+		return f, p, err
+	}
+	if v := q.Get("startIndex"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return f, p, i18n.Errorf("scim.invalid_value", "startIndex", v)
+		}
+		// values less than 1 are interpreted as 1, see RFC 7644 section 3.4.2.4
+		if n > 1 {
+			p.StartIndex = n
+		}
+	}
+	if v := q.Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return f, p, i18n.Errorf("scim.invalid_value", "count", v)
+		}
+		switch {
+		case n < 0:
+			p.Count = 0
+		case n < MaxCount:
+			p.Count = n
+		}
+	}
+	return f, p, nil
+}
+
+func validateUserName(userName string) error {
+	if addr, err := mail.ParseAddress(userName); err != nil || addr.Name != "" {
+		return i18n.Errorf("scim.invalid_value", "userName", userName)
+	}
+	return nil
+}
+
+func validateDisplayName(displayName string) error {
+	if strings.TrimSpace(displayName) == "" {
+		return i18n.Errorf("scim.invalid_value", "displayName", displayName)
+	}
+	return nil
+}
+
+type meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	Location     string    `json:"location"`
+}
+
+type emailValue struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// userResource is User of SCIM core schema, password is accepted but never rendered.
+type userResource struct {
+	Schemas    []string     `json:"schemas"`
+	ID         string       `json:"id,omitempty"`
+	ExternalID string       `json:"externalId,omitempty"`
+	UserName   string       `json:"userName"`
+	Active     *bool        `json:"active,omitempty"`
+	Emails     []emailValue `json:"emails,omitempty"`
+	Password   string       `json:"password,omitempty"`
+	Meta       *meta        `json:"meta,omitempty"`
+}
+
+func newUserResource(cfg Config, u *User) *userResource {
+	active := u.Active
+	return &userResource{
+		Schemas:    []string{UserSchema},
+		ID:         u.ID,
+		ExternalID: u.ExternalID,
+		UserName:   u.UserName,
+		Active:     &active,
+		Emails:     []emailValue{{Value: u.UserName, Primary: true}},
+		Meta:       &meta{ResourceType: "User", Created: u.CreatedAt, Location: cfg.BaseURL + "/Users/" + u.ID},
+	}
+}
+
+// apply sets attributes of resource to user, userName falls back to the primary email.
+func (res *userResource) apply(u *User) error {
+	u.UserName = res.UserName
+	if u.UserName == "" {
+		for i, e := range res.Emails {
+			if e.Primary || i == 0 {
+				u.UserName = e.Value
+			}
+		}
+	}
+	if res.ExternalID != "" {
+		u.ExternalID = res.ExternalID
+	}
+	if res.Active != nil {
+		u.Active = *res.Active
+	}
+	return validateUserName(u.UserName)
+}
+
+type memberResource struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// groupResource is Group of SCIM core schema.
+type groupResource struct {
+	Schemas     []string          `json:"schemas"`
+	ID          string            `json:"id,omitempty"`
+	ExternalID  string            `json:"externalId,omitempty"`
+	DisplayName string            `json:"displayName"`
+	Members     []*memberResource `json:"members,omitempty"`
+	Meta        *meta             `json:"meta,omitempty"`
+}
+
+func newGroupResource(cfg Config, g *Group) *groupResource {
+	res := &groupResource{
+		Schemas:     []string{GroupSchema},
+		ID:          g.ID,
+		ExternalID:  g.ExternalID,
+		DisplayName: g.DisplayName,
+		Meta:        &meta{ResourceType: "Group", Created: g.CreatedAt, Location: cfg.BaseURL + "/Groups/" + g.ID},
+	}
+	for _, mb := range g.Members {
+		res.Members = append(res.Members, &memberResource{Value: mb.ID, Display: mb.UserName, Ref: cfg.BaseURL + "/Users/" + mb.ID})
+	}
+	return res
+}
+
+// apply sets attributes of resource to group.
+func (res *groupResource) apply(g *Group) error {
+	g.DisplayName = res.DisplayName
+	if res.ExternalID != "" {
+		g.ExternalID = res.ExternalID
+	}
+	g.Members = make([]Member, 0, len(res.Members))
+	for _, mv := range res.Members {
+		g.Members = append(g.Members, Member{ID: mv.Value})
+	}
+	return validateDisplayName(g.DisplayName)
+}
+
+type patchRequest struct {
+	Schemas    []string  `json:"schemas"`
+	Operations []patchOp `json:"Operations"`
+}
+
+type listResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type errorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// scimTypes are types of errors by message key, see RFC 7644 section 3.12.
+var scimTypes = map[string]string{
+	"scim.invalid_body":   "invalidSyntax",
+	"scim.invalid_op":     "invalidSyntax",
+	"scim.invalid_filter": "invalidFilter",
+	"scim.invalid_path":   "invalidPath",
+	"scim.invalid_value":  "invalidValue",
+	"scim.unknown_member": "invalidValue",
+	"scim.unmanaged_role": "invalidValue",
+	"scim.user_exists":    "uniqueness",
+	"scim.group_exists":   "uniqueness",
+}
+
+func decode(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return i18n.Errorf("scim.invalid_body", err)
+	}
+	return nil
+}
+
+func renderJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// renderError renders error in SCIM format, messages are localized.
+func renderError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	resp := &errorResponse{Schemas: []string{ErrorSchema}, Status: strconv.Itoa(status), Detail: err.Error()}
+	if e, ok := err.(*i18n.Error); ok {
+		resp.ScimType = scimTypes[e.Key]
+		resp.Detail = e.Localize(reqctx.GetLocale(r.Context()))
+	}
+	renderJSON(w, status, resp)
+}
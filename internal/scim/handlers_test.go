@@ -0,0 +1,345 @@
+package scim
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/internal/user"
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+var (
+	userRow  = []string{"id", "external_id", "email", "active", "created_at"}
+	groupRow = []string{"id", "display_name", "external_id", "created_at"}
+	now      = time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+)
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// newTestRouter serves requests of identity provider and records events.
+func newTestRouter(t *testing.T, u *reqctx.User) (http.Handler, sqlmock.Sqlmock, *[]user.Event, func()) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	m := NewManager(db)
+	m.clock = clock.NewFake(now)
+
+	var events []user.Event
+	cfg := Config{
+		Role:       "scim",
+		BaseURL:    "https://example.com/1.0/scim/v2",
+		GroupRoles: []string{"editor", "team-*"},
+		Events: map[string]user.EventHandler{"test": func(e user.Event) error {
+			events = append(events, e)
+			return nil
+		}},
+	}
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Use(withUser(u))
+	r.Mount("/scim/v2", Routes(m, cfg))
+	return r, mock, &events, func() { db.Close() }
+}
+
+var idp = &reqctx.User{ID: "okta", Roles: []string{"scim"}}
+
+func do(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", ContentType)
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestRoutes_Role(t *testing.T) {
+	for _, tt := range []struct {
+		user   *reqctx.User
+		status int
+	}{
+		{nil, http.StatusUnauthorized},
+		{&reqctx.User{ID: "1", Roles: []string{"admin"}}, http.StatusForbidden},
+	} {
+		r, _, _, done := newTestRouter(t, tt.user)
+		w := do(r, http.MethodGet, "/scim/v2/Users", "")
+		done()
+		var resp errorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != tt.status || resp.Status != strconv.Itoa(tt.status) || resp.Schemas[0] != ErrorSchema {
+			t.Errorf("%+v: unexpected response %d %+v", tt.user, w.Code, resp)
+		}
+		if w.Header().Get("Content-Type") != ContentType {
+			t.Errorf("unexpected content type %s", w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestUsers(t *testing.T) {
+	r, mock, events, done := newTestRouter(t, idp)
+	defer done()
+
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM users WHERE email = \\$1;").
+		WithArgs("ann@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1 ORDER BY id LIMIT \\$2 OFFSET \\$3;").
+		WithArgs("ann@example.com", MaxCount, 0).
+		WillReturnRows(sqlmock.NewRows(userRow))
+	w := do(r, http.MethodGet, `/scim/v2/Users?filter=userName+eq+"Ann@example.com"`, "")
+	var list struct {
+		TotalResults int               `json:"totalResults"`
+		Resources    []json.RawMessage `json:"Resources"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || list.TotalResults != 0 || list.Resources == nil {
+		t.Errorf("unexpected list %d %s", w.Code, w.Body)
+	}
+
+	mock.ExpectQuery("INSERT INTO users(.+) ON CONFLICT DO NOTHING RETURNING id, created_at;").
+		WithArgs("ann@example.com", "", "00u1", true, now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, now))
+	w = do(r, http.MethodPost, "/scim/v2/Users", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"externalId": "00u1",
+		"userName": "Ann@example.com",
+		"name": {"givenName": "Ann"},
+		"emails": [{"value": "ann@example.com", "primary": true}],
+		"active": true
+	}`)
+	var res userResource
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || res.ID != "1" || res.UserName != "ann@example.com" || !*res.Active ||
+		w.Header().Get("Location") != "https://example.com/1.0/scim/v2/Users/1" {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+
+	mock.ExpectQuery("INSERT INTO users(.+)").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}))
+	if w := do(r, http.MethodPost, "/scim/v2/Users", `{"userName": "ann@example.com"}`); w.Code != http.StatusConflict {
+		t.Errorf("existing user: expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+	if w := do(r, http.MethodPost, "/scim/v2/Users", `{"userName": "ann"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid userName: expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// identity providers send booleans as strings
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1 FOR UPDATE;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(userRow).AddRow(1, "00u1", "ann@example.com", true, now))
+	mock.ExpectExec("UPDATE users SET (.+) WHERE id = \\$1 AND NOT EXISTS").
+		WithArgs("1", "ann@example.com", "00u1", false, "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE refresh_token_family SET revoked_at = \\$2 WHERE user_id = \\$1 AND revoked_at IS NULL;").
+		WithArgs("1", now).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	w = do(r, http.MethodPatch, "/scim/v2/Users/1", `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "Replace", "path": "active", "value": "False"}]
+	}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"active":false`) {
+		t.Errorf("deactivate: unexpected response %d %s", w.Code, w.Body)
+	}
+
+	mock.ExpectQuery("DELETE FROM users WHERE id = \\$1 RETURNING (.+);").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(userRow).AddRow(1, "00u1", "ann@example.com", false, now))
+	if w := do(r, http.MethodDelete, "/scim/v2/Users/1", ""); w.Code != http.StatusNoContent {
+		t.Errorf("delete: expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if w := do(r, http.MethodGet, "/scim/v2/Users/abc", ""); w.Code != http.StatusNotFound {
+		t.Errorf("invalid id: expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	var actions []string
+	for _, e := range *events {
+		if e.UserID != "1" || e.ActorID != "okta" {
+			t.Errorf("unexpected event %+v", e)
+		}
+		actions = append(actions, e.Action)
+	}
+	if strings.Join(actions, ",") != "provisioned,deactivated,deleted" {
+		t.Errorf("unexpected events %v", actions)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	r, mock, events, done := newTestRouter(t, idp)
+	defer done()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO scim_group(.+) ON CONFLICT DO NOTHING RETURNING id, created_at;").
+		WithArgs("editor", "00g1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, now))
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM users WHERE id = ANY\\(\\$1\\);").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery("UPDATE users SET roles = array_append\\(roles, \\$1\\)(.+)RETURNING id, email;").
+		WithArgs("editor", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "ann@example.com").AddRow(2, "bob@example.com"))
+	mock.ExpectQuery("SELECT id, email FROM users WHERE \\$1 = ANY\\(roles\\) ORDER BY id;").
+		WithArgs("editor").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "ann@example.com").AddRow(2, "bob@example.com"))
+	mock.ExpectCommit()
+	w := do(r, http.MethodPost, "/scim/v2/Groups", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+		"externalId": "00g1",
+		"displayName": "editor",
+		"members": [{"value": "1"}, {"value": "2"}]
+	}`)
+	var res groupResource
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || res.ID != "3" || len(res.Members) != 2 || res.Members[1].Display != "bob@example.com" {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM scim_group WHERE id = \\$1 FOR UPDATE;").
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows(groupRow).AddRow(3, "editor", "00g1", now))
+	mock.ExpectQuery("SELECT id, email FROM users WHERE \\$1 = ANY\\(roles\\) ORDER BY id;").
+		WithArgs("editor").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "ann@example.com").AddRow(2, "bob@example.com"))
+	mock.ExpectExec("UPDATE scim_group SET (.+)").
+		WithArgs("3", "editor", "00g1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("UPDATE users SET roles = array_remove\\(roles, \\$1\\)(.+)RETURNING id, email;").
+		WithArgs("editor", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(2, "bob@example.com"))
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM users WHERE id = ANY\\(\\$1\\);").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery("UPDATE users SET roles = array_append(.+)").
+		WithArgs("editor", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}))
+	mock.ExpectQuery("SELECT id, email FROM users WHERE \\$1 = ANY\\(roles\\) ORDER BY id;").
+		WithArgs("editor").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "ann@example.com"))
+	mock.ExpectCommit()
+	w = do(r, http.MethodPatch, "/scim/v2/Groups/3", `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "remove", "path": "members[value eq \"2\"]"}]
+	}`)
+	if w.Code != http.StatusOK {
+		t.Errorf("remove member: unexpected response %d %s", w.Code, w.Body)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM scim_group WHERE id = \\$1 FOR UPDATE;").
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows(groupRow).AddRow(3, "editor", "00g1", now))
+	mock.ExpectQuery("SELECT id, email FROM users(.+)").
+		WithArgs("editor").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "ann@example.com"))
+	mock.ExpectExec("UPDATE scim_group SET (.+)").
+		WithArgs("3", "editor", "00g1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM users WHERE id = ANY\\(\\$1\\);").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+	w = do(r, http.MethodPatch, "/scim/v2/Groups/3", `{"Operations": [{"op": "add", "path": "members", "value": [{"value": "9"}]}]}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"scimType":"invalidValue"`) {
+		t.Errorf("unknown member: unexpected response %d %s", w.Code, w.Body)
+	}
+
+	// groups do not grant roles which are not managed with SCIM
+	if w := do(r, http.MethodPost, "/scim/v2/Groups", `{"displayName": "admin", "members": [{"value": "1"}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unmanaged role: unexpected response %d %s", w.Code, w.Body)
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM scim_group WHERE id = \\$1 FOR UPDATE;").
+		WithArgs("3").
+		WillReturnRows(sqlmock.NewRows(groupRow).AddRow(3, "editor", "00g1", now))
+	mock.ExpectQuery("SELECT id, email FROM users(.+)").
+		WithArgs("editor").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow(1, "ann@example.com"))
+	mock.ExpectRollback()
+	w = do(r, http.MethodPatch, "/scim/v2/Groups/3", `{"Operations": [{"op": "replace", "value": {"displayName": "admin"}}]}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"scimType":"invalidValue"`) {
+		t.Errorf("renamed to unmanaged role: unexpected response %d %s", w.Code, w.Body)
+	}
+	if !(Config{GroupRoles: []string{"team-*"}}).manages("team-a") || (Config{GroupRoles: []string{"team-*"}}).manages("admin") {
+		t.Error("roles are not matched by prefix")
+	}
+
+	var got []string
+	for _, e := range *events {
+		got = append(got, e.Action+":"+e.UserID+":"+e.Detail)
+	}
+	if strings.Join(got, ",") != "role_granted:1:editor,role_granted:2:editor,role_revoked:2:editor" {
+		t.Errorf("unexpected events %v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   Filter
+		valid  bool
+	}{
+		{``, Filter{}, true},
+		{`userName eq "ann@example.com"`, Filter{"email", "ann@example.com"}, true},
+		{`EXTERNALID EQ "a\"b"`, Filter{"external_id", `a"b`}, true},
+		{`userName co "ann"`, Filter{}, false},
+		{`name.givenName eq "Ann"`, Filter{}, false},
+		{`userName eq "a" or userName eq "b"`, Filter{}, false},
+	}
+	for _, tt := range tests {
+		f, err := parseFilter(tt.filter, userColumnsByAttr)
+		if (err == nil) != tt.valid || f != tt.want {
+			t.Errorf("%s: unexpected filter %+v, %v", tt.filter, f, err)
+		}
+	}
+}
+
+func TestPatchGroup(t *testing.T) {
+	g := &Group{DisplayName: "editor", Members: []Member{{ID: "1"}, {ID: "2"}}}
+	err := patchGroup(g, []patchOp{
+		{Op: "add", Path: "members", Value: json.RawMessage(`[{"value": "3"}]`)},
+		{Op: "remove", Path: "members", Value: json.RawMessage(`[{"value": "1"}]`)},
+		{Op: "replace", Value: json.RawMessage(`{"displayName": "writer"}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.DisplayName != "writer" || len(g.Members) != 2 || g.Members[0].ID != "2" || g.Members[1].ID != "3" {
+		t.Errorf("unexpected group %+v", g)
+	}
+	if err := patchGroup(g, []patchOp{{Op: "move", Path: "members"}}); err == nil {
+		t.Error("unknown operation is applied")
+	}
+}
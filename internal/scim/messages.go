@@ -0,0 +1,32 @@
+package scim
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"scim.user_not_found":  "user %s is not found",
+		"scim.group_not_found": "group %s is not found",
+		"scim.user_exists":     "user with this userName or externalId already exists",
+		"scim.group_exists":    "group with this displayName or externalId already exists",
+		"scim.unknown_member":  "members of group must be users",
+		"scim.unmanaged_role":  "role %s is not managed with groups",
+		"scim.invalid_body":    "invalid request body: %s",
+		"scim.invalid_filter":  "filter %q is not supported, use attribute eq \"value\"",
+		"scim.invalid_op":      "patch operation %q is not supported",
+		"scim.invalid_path":    "attribute %q can not be patched",
+		"scim.invalid_value":   "invalid %s: %s",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"scim.user_not_found":  "пользователь %s не найден",
+		"scim.group_not_found": "группа %s не найдена",
+		"scim.user_exists":     "пользователь с таким userName или externalId уже существует",
+		"scim.group_exists":    "группа с таким displayName или externalId уже существует",
+		"scim.unknown_member":  "участниками группы могут быть только пользователи",
+		"scim.unmanaged_role":  "роль %s не управляется группами",
+		"scim.invalid_body":    "неверное тело запроса: %s",
+		"scim.invalid_filter":  "фильтр %q не поддерживается, используйте attribute eq \"value\"",
+		"scim.invalid_op":      "операция %q не поддерживается",
+		"scim.invalid_path":    "атрибут %q нельзя изменить",
+		"scim.invalid_value":   "неверное значение %s: %s",
+	})
+}
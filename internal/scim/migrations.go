@@ -0,0 +1,23 @@
+package scim
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "0035_scim_group",
+			Up: []string{
+				// members of group are users with role of display_name
+				`CREATE TABLE scim_group (
+					id            BIGSERIAL                   NOT NULL,
+					display_name  character varying(256)      NOT NULL,
+					external_id   character varying(256),
+					created_at    timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id),
+					UNIQUE (display_name),
+					UNIQUE (external_id)
+				);`,
+			},
+		},
+	}
+}
@@ -0,0 +1,52 @@
+package scim
+
+import (
+	"net/http"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/internal/user"
+	"github.com/agalitsyn/goapi/pkg/module"
+)
+
+func init() {
+	module.Register(&scimModule{})
+}
+
+type scimModule struct {
+	module.Base
+
+	opts struct {
+		Role       string   `long:"scim-role" env:"GAPI_SCIM_ROLE" default:"scim" description:"Role of identity provider tokens which may provision users with SCIM."`
+		GroupRoles []string `long:"scim-group-role" env:"GAPI_SCIM_GROUP_ROLES" env-delim:"," description:"Role granted to members of SCIM group with the same displayName, ending with * matches roles by prefix, e.g. team-*. Groups of other roles are refused."`
+	}
+
+	env     *module.Env
+	manager *Manager
+}
+
+func (mod *scimModule) Name() string                     { return "scim" }
+func (mod *scimModule) Options() interface{}             { return &mod.opts }
+func (mod *scimModule) Migrations() []*migrate.Migration { return Migrations() }
+
+func (mod *scimModule) Init(env *module.Env) error {
+	mod.env = env
+	mod.manager = NewManager(env.DB)
+	return nil
+}
+
+// Routes emit account events to handlers provided by other modules, they are looked up here,
+// after all modules are initialized.
+func (mod *scimModule) Routes() map[string]http.Handler {
+	cfg := Config{
+		Role:    mod.opts.Role,
+		BaseURL: mod.env.BaseURL + "/1.0/scim/v2",
+		Events:  make(map[string]user.EventHandler),
+
+		GroupRoles: mod.opts.GroupRoles,
+	}
+	for name, h := range mod.env.LookupPrefix(user.EventHandlerPrefix) {
+		cfg.Events[name] = h.(user.EventHandler)
+	}
+	return map[string]http.Handler{"/scim/v2": Routes(mod.manager, cfg)}
+}
@@ -0,0 +1,203 @@
+package scim
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+)
+
+// filterRe matches the only filter supported, attribute eq "value", which identity providers use to
+// find resources before they create them.
+var filterRe = regexp.MustCompile(`(?i)^\s*([a-z.]+)\s+eq\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+// parseFilter returns filter of attribute mapped to column by columns, names of attributes are
+// case-insensitive.
+func parseFilter(s string, columns map[string]string) (Filter, error) {
+	if s == "" {
+		return Filter{}, nil
+	}
+	m := filterRe.FindStringSubmatch(s)
+	if m == nil {
+		return Filter{}, i18n.Errorf("scim.invalid_filter", s)
+	}
+	column, ok := columns[strings.ToLower(m[1])]
+	if !ok {
+		return Filter{}, i18n.Errorf("scim.invalid_filter", s)
+	}
+	value, err := strconv.Unquote(`"` + m[2] + `"`)
+	if err != nil {
+		return Filter{}, i18n.Errorf("scim.invalid_filter", s)
+	}
+	return Filter{Column: column, Value: value}, nil
+}
+
+// patchOp is an operation of PATCH request, see RFC 7644 section 3.5.2.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// attributes returns attributes op sets by name in lowercase, either the one of path or all of value
+// when path is empty.
+func (op patchOp) attributes() (map[string]json.RawMessage, error) {
+	if op.Path != "" {
+		return map[string]json.RawMessage{strings.ToLower(op.Path): op.Value}, nil
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(op.Value, &values); err != nil {
+		return nil, i18n.Errorf("scim.invalid_value", "value", string(op.Value))
+	}
+	attrs := make(map[string]json.RawMessage, len(values))
+	for name, v := range values {
+		attrs[strings.ToLower(name)] = v
+	}
+	return attrs, nil
+}
+
+// patchUser applies operations to user. Attributes which are not kept, e.g. name, are ignored, so
+// identity providers may send them as they do.
+func patchUser(u *User, ops []patchOp) error {
+	for _, op := range ops {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			attrs, err := op.attributes()
+			if err != nil {
+				return err
+			}
+			for name, v := range attrs {
+				var err error
+				switch name {
+				case "active":
+					u.Active, err = parseBool(name, v)
+				case "username":
+					u.UserName, err = parseString(name, v)
+				case "externalid":
+					u.ExternalID, err = parseString(name, v)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		case "remove":
+			if strings.EqualFold(op.Path, "externalId") {
+				u.ExternalID = ""
+			}
+		default:
+			return i18n.Errorf("scim.invalid_op", op.Op)
+		}
+	}
+	return nil
+}
+
+// memberPathRe matches path of a member, e.g. members[value eq "1"].
+var memberPathRe = regexp.MustCompile(`(?i)^members\[\s*value\s+eq\s+"([^"]*)"\s*\]$`)
+
+// patchGroup applies operations to group.
+func patchGroup(g *Group, ops []patchOp) error {
+	for _, op := range ops {
+		kind := strings.ToLower(op.Op)
+		if kind != "add" && kind != "replace" && kind != "remove" {
+			return i18n.Errorf("scim.invalid_op", op.Op)
+		}
+		if m := memberPathRe.FindStringSubmatch(op.Path); m != nil && kind == "remove" {
+			g.Members = removeMembers(g.Members, []Member{{ID: m[1]}})
+			continue
+		}
+		if kind == "remove" {
+			if !strings.EqualFold(op.Path, "members") {
+				return i18n.Errorf("scim.invalid_path", op.Path)
+			}
+			// members without value are all members
+			if len(op.Value) == 0 {
+				g.Members = nil
+				continue
+			}
+			members, err := parseMembers(op.Value)
+			if err != nil {
+				return err
+			}
+			g.Members = removeMembers(g.Members, members)
+			continue
+		}
+
+		attrs, err := op.attributes()
+		if err != nil {
+			return err
+		}
+		for name, v := range attrs {
+			switch name {
+			case "displayname":
+				if g.DisplayName, err = parseString(name, v); err != nil {
+					return err
+				}
+			case "externalid":
+				if g.ExternalID, err = parseString(name, v); err != nil {
+					return err
+				}
+			case "members":
+				members, err := parseMembers(v)
+				if err != nil {
+					return err
+				}
+				if kind == "replace" {
+					g.Members = members
+				} else {
+					g.Members = append(g.Members[:len(g.Members):len(g.Members)], members...)
+				}
+			default:
+				return i18n.Errorf("scim.invalid_path", name)
+			}
+		}
+	}
+	return nil
+}
+
+// removeMembers returns members without removed ones.
+func removeMembers(members, removed []Member) []Member {
+	kept := make([]Member, 0, len(members))
+	for _, mb := range members {
+		if !contains(memberIDs(removed), mb.ID) {
+			kept = append(kept, mb)
+		}
+	}
+	return kept
+}
+
+func parseMembers(v json.RawMessage) ([]Member, error) {
+	var values []memberResource
+	if err := json.Unmarshal(v, &values); err != nil {
+		return nil, i18n.Errorf("scim.invalid_value", "members", string(v))
+	}
+	members := make([]Member, 0, len(values))
+	for _, mv := range values {
+		members = append(members, Member{ID: mv.Value})
+	}
+	return members, nil
+}
+
+func parseString(name string, v json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		return "", i18n.Errorf("scim.invalid_value", name, string(v))
+	}
+	return s, nil
+}
+
+// parseBool accepts booleans and strings "true" and "false" in any case, as some identity providers send.
+func parseBool(name string, v json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(v, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		if b, err := strconv.ParseBool(strings.ToLower(s)); err == nil {
+			return b, nil
+		}
+	}
+	return false, i18n.Errorf("scim.invalid_value", name, string(v))
+}
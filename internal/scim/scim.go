@@ -0,0 +1,556 @@
+// Package scim provisions users from identity providers with SCIM 2.0, see RFC 7643 and RFC 7644.
+//
+// SCIM users are accounts of the user module: userName is email and active users may sign in.
+// Deactivated users can not sign in, and their refresh tokens are revoked. SCIM groups are roles:
+// displayName of group is the role its members are granted, so renaming or deleting group renames or
+// revokes the role of its members.
+package scim
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/internal/user"
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when another resource has the same userName, displayName or externalId.
+	ErrConflict = errors.New("conflict")
+	// ErrUnknownMember is returned when group members are not users.
+	ErrUnknownMember = errors.New("unknown member")
+)
+
+// User is an account as identity provider sees it.
+type User struct {
+	ID         string
+	ExternalID string
+	// UserName is email of user.
+	UserName  string
+	Active    bool
+	CreatedAt time.Time
+}
+
+// Group grants role of its DisplayName to members.
+type Group struct {
+	ID          string
+	DisplayName string
+	ExternalID  string
+	Members     []Member
+	CreatedAt   time.Time
+}
+
+// Member of group is a user.
+type Member struct {
+	ID       string
+	UserName string
+}
+
+// Filter selects resources which attribute equals value, Column is empty to select all.
+type Filter struct {
+	Column string
+	Value  string
+}
+
+// Page is a window of list, StartIndex is 1-based.
+type Page struct {
+	StartIndex int
+	Count      int
+}
+
+// RoleChange is a role granted or revoked by changing members of group.
+type RoleChange struct {
+	Member
+	Role    string
+	Granted bool
+}
+
+type Manager struct {
+	db    postgres.Querier
+	clock clock.Clock
+}
+
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{db: db, clock: clock.Real}
+}
+
+// Tx runs fn with manager bound to a transaction, which is rolled back when fn fails or on dry run.
+func (m *Manager) Tx(dryRun bool, fn func(m *Manager) error) error {
+	return postgres.Tx(m.db, dryRun, func(tx postgres.Querier) error {
+		return fn(&Manager{db: tx, clock: m.clock})
+	})
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db), clock: m.clock}
+}
+
+// validID tells whether id may be id of a row, ids are bigserial.
+func validID(id string) bool {
+	n, err := strconv.ParseInt(id, 10, 64)
+	return err == nil && n > 0
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+const userColumns = "id, COALESCE(external_id, ''), email, active, created_at"
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var u User
+	if err := row.Scan(&u.ID, &u.ExternalID, &u.UserName, &u.Active, &u.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ListUsers returns page of users matching filter ordered by id and number of all matching users.
+func (m *Manager) ListUsers(f Filter, p Page) ([]*User, int, error) {
+	where, args := "true", []interface{}{}
+	if f.Column != "" {
+		where, args = f.Column+" = $1", append(args, f.Value)
+	}
+	var total int
+	if err := m.db.QueryRow("SELECT count(*) FROM users WHERE "+where+";", args...).Scan(&total); err != nil {
+		return nil, 0, errors.Wrap(err, "could not count users")
+	}
+
+	args = append(args, p.Count, p.StartIndex-1)
+	rows, err := m.db.Query(
+		"SELECT "+userColumns+" FROM users WHERE "+where+
+			" ORDER BY id LIMIT $"+strconv.Itoa(len(args)-1)+" OFFSET $"+strconv.Itoa(len(args))+";",
+		args...,
+	)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not get users")
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "could not scan row to user")
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, errors.Wrap(err, "could not get users")
+	}
+	return users, total, nil
+}
+
+func (m *Manager) GetUser(id string) (*User, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	u, err := scanUser(m.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1;", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get user")
+	}
+	return u, nil
+}
+
+// CreateUser creates user with verified email, password is empty unless users sign in with password
+// as well as with identity provider.
+func (m *Manager) CreateUser(u *User, password string) error {
+	var hash string
+	if password != "" {
+		var err error
+		if hash, err = user.HashPassword(password); err != nil {
+			return err
+		}
+	}
+	u.UserName = user.NormalizeEmail(u.UserName)
+	err := m.db.QueryRow(
+		`INSERT INTO users(email, password_hash, external_id, active, verified_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING RETURNING id, created_at;`,
+		u.UserName, hash, nullString(u.ExternalID), u.Active, m.clock.Now(),
+	).Scan(&u.ID, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return ErrConflict
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not create user")
+	}
+	return nil
+}
+
+// UpdateUser changes user with fn and returns whether user was active before. Refresh tokens of
+// deactivated user are revoked, so sessions end once their access tokens expire.
+func (m *Manager) UpdateUser(id, password string, fn func(u *User) error) (*User, bool, error) {
+	var hash string
+	if password != "" {
+		var err error
+		if hash, err = user.HashPassword(password); err != nil {
+			return nil, false, err
+		}
+	}
+	var u *User
+	var wasActive bool
+	err := m.Tx(false, func(m *Manager) error {
+		if !validID(id) {
+			return ErrNotFound
+		}
+		var err error
+		u, err = scanUser(m.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1 FOR UPDATE;", id))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return errors.Wrap(err, "could not get user")
+		}
+		wasActive = u.Active
+		if err := fn(u); err != nil {
+			return err
+		}
+		u.UserName = user.NormalizeEmail(u.UserName)
+
+		res, err := m.db.Exec(
+			`UPDATE users SET email = $2, external_id = $3, active = $4, password_hash = COALESCE(NULLIF($5, ''), password_hash)
+			WHERE id = $1 AND NOT EXISTS (
+				SELECT 1 FROM users o WHERE o.id <> $1 AND (o.email = $2 OR o.external_id = $3)
+			);`,
+			id, u.UserName, nullString(u.ExternalID), u.Active, hash,
+		)
+		if err != nil {
+			return errors.Wrap(err, "could not update user")
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return errors.Wrap(err, "could not update user")
+		} else if n == 0 {
+			return ErrConflict
+		}
+
+		if wasActive && !u.Active {
+			if _, err := m.db.Exec(
+				"UPDATE refresh_token_family SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL;", id, m.clock.Now(),
+			); err != nil {
+				return errors.Wrap(err, "could not revoke refresh token families")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return u, wasActive, nil
+}
+
+// DeleteUser deletes user with tokens, it is deprovisioned for good.
+func (m *Manager) DeleteUser(id string) (*User, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	u, err := scanUser(m.db.QueryRow("DELETE FROM users WHERE id = $1 RETURNING "+userColumns+";", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not delete user")
+	}
+	return u, nil
+}
+
+const groupColumns = "id, display_name, COALESCE(external_id, ''), created_at"
+
+func scanGroup(row interface{ Scan(...interface{}) error }) (*Group, error) {
+	var g Group
+	if err := row.Scan(&g.ID, &g.DisplayName, &g.ExternalID, &g.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// ListGroups returns page of groups matching filter ordered by id and number of all matching groups.
+// Members are not loaded unless members is true, groups of many users are expensive to list.
+func (m *Manager) ListGroups(f Filter, p Page, members bool) ([]*Group, int, error) {
+	where, args := "true", []interface{}{}
+	if f.Column != "" {
+		where, args = f.Column+" = $1", append(args, f.Value)
+	}
+	var total int
+	if err := m.db.QueryRow("SELECT count(*) FROM scim_group WHERE "+where+";", args...).Scan(&total); err != nil {
+		return nil, 0, errors.Wrap(err, "could not count groups")
+	}
+
+	args = append(args, p.Count, p.StartIndex-1)
+	rows, err := m.db.Query(
+		"SELECT "+groupColumns+" FROM scim_group WHERE "+where+
+			" ORDER BY id LIMIT $"+strconv.Itoa(len(args)-1)+" OFFSET $"+strconv.Itoa(len(args))+";",
+		args...,
+	)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not get groups")
+	}
+	defer rows.Close()
+
+	groups := []*Group{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "could not scan row to group")
+		}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, errors.Wrap(err, "could not get groups")
+	}
+	rows.Close()
+
+	if members {
+		for _, g := range groups {
+			if g.Members, err = m.members(g.DisplayName); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return groups, total, nil
+}
+
+func (m *Manager) GetGroup(id string) (*Group, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	g, err := scanGroup(m.db.QueryRow("SELECT "+groupColumns+" FROM scim_group WHERE id = $1;", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get group")
+	}
+	if g.Members, err = m.members(g.DisplayName); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// members returns users with role.
+func (m *Manager) members(role string) ([]Member, error) {
+	rows, err := m.db.Query("SELECT id, email FROM users WHERE $1 = ANY(roles) ORDER BY id;", role)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get members")
+	}
+	defer rows.Close()
+
+	members := []Member{}
+	for rows.Next() {
+		var mb Member
+		if err := rows.Scan(&mb.ID, &mb.UserName); err != nil {
+			return nil, errors.Wrap(err, "could not scan row to member")
+		}
+		members = append(members, mb)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get members")
+	}
+	return members, nil
+}
+
+// CreateGroup creates group and grants its role to members. Users who already have the role become
+// members too, so groups of identity provider may manage roles granted before.
+func (m *Manager) CreateGroup(g *Group) ([]RoleChange, error) {
+	var changes []RoleChange
+	err := m.Tx(false, func(m *Manager) error {
+		err := m.db.QueryRow(
+			"INSERT INTO scim_group(display_name, external_id) VALUES ($1, $2) ON CONFLICT DO NOTHING RETURNING id, created_at;",
+			g.DisplayName, nullString(g.ExternalID),
+		).Scan(&g.ID, &g.CreatedAt)
+		if err == sql.ErrNoRows {
+			return ErrConflict
+		}
+		if err != nil {
+			return errors.Wrap(err, "could not create group")
+		}
+		if changes, err = m.grant(g.DisplayName, memberIDs(g.Members)); err != nil {
+			return err
+		}
+		g.Members, err = m.members(g.DisplayName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// UpdateGroup changes group with fn, roles of members are granted, revoked and renamed accordingly.
+func (m *Manager) UpdateGroup(id string, fn func(g *Group) error) (*Group, []RoleChange, error) {
+	var g *Group
+	var changes []RoleChange
+	err := m.Tx(false, func(m *Manager) error {
+		if !validID(id) {
+			return ErrNotFound
+		}
+		var err error
+		g, err = scanGroup(m.db.QueryRow("SELECT "+groupColumns+" FROM scim_group WHERE id = $1 FOR UPDATE;", id))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return errors.Wrap(err, "could not get group")
+		}
+		if g.Members, err = m.members(g.DisplayName); err != nil {
+			return err
+		}
+		old := *g
+		old.Members = append([]Member(nil), g.Members...)
+		if err := fn(g); err != nil {
+			return err
+		}
+
+		res, err := m.db.Exec(
+			`UPDATE scim_group SET display_name = $2, external_id = $3 WHERE id = $1 AND NOT EXISTS (
+				SELECT 1 FROM scim_group o WHERE o.id <> $1 AND (o.display_name = $2 OR o.external_id = $3)
+			);`,
+			id, g.DisplayName, nullString(g.ExternalID),
+		)
+		if err != nil {
+			return errors.Wrap(err, "could not update group")
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return errors.Wrap(err, "could not update group")
+		} else if n == 0 {
+			return ErrConflict
+		}
+
+		// renamed role is revoked from all members and the new one is granted to members
+		wanted := memberIDs(g.Members)
+		var revoked []string
+		for _, id := range memberIDs(old.Members) {
+			if g.DisplayName != old.DisplayName || !contains(wanted, id) {
+				revoked = append(revoked, id)
+			}
+		}
+		changes, err = m.revoke(old.DisplayName, revoked)
+		if err != nil {
+			return err
+		}
+		granted, err := m.grant(g.DisplayName, wanted)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, granted...)
+		g.Members, err = m.members(g.DisplayName)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return g, changes, nil
+}
+
+// DeleteGroup deletes group and revokes its role from members.
+func (m *Manager) DeleteGroup(id string) ([]RoleChange, error) {
+	var changes []RoleChange
+	err := m.Tx(false, func(m *Manager) error {
+		if !validID(id) {
+			return ErrNotFound
+		}
+		var role string
+		err := m.db.QueryRow("DELETE FROM scim_group WHERE id = $1 RETURNING display_name;", id).Scan(&role)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return errors.Wrap(err, "could not delete group")
+		}
+		members, err := m.members(role)
+		if err != nil {
+			return err
+		}
+		changes, err = m.revoke(role, memberIDs(members))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// grant grants role to users who do not have it yet and returns them.
+func (m *Manager) grant(role string, ids []string) ([]RoleChange, error) {
+	return m.changeRole(
+		"UPDATE users SET roles = array_append(roles, $1) WHERE id = ANY($2) AND NOT $1 = ANY(roles) RETURNING id, email;",
+		role, ids, true,
+	)
+}
+
+// revoke revokes role from users who have it and returns them.
+func (m *Manager) revoke(role string, ids []string) ([]RoleChange, error) {
+	return m.changeRole(
+		"UPDATE users SET roles = array_remove(roles, $1) WHERE id = ANY($2) AND $1 = ANY(roles) RETURNING id, email;",
+		role, ids, false,
+	)
+}
+
+func (m *Manager) changeRole(query, role string, ids []string, granted bool) ([]RoleChange, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	for _, id := range ids {
+		if !validID(id) {
+			return nil, ErrUnknownMember
+		}
+	}
+	if granted {
+		var n int
+		if err := m.db.QueryRow("SELECT count(*) FROM users WHERE id = ANY($1);", pq.Array(ids)).Scan(&n); err != nil {
+			return nil, errors.Wrap(err, "could not get members")
+		}
+		if n != len(ids) {
+			return nil, ErrUnknownMember
+		}
+	}
+
+	rows, err := m.db.Query(query, role, pq.Array(ids))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not change roles")
+	}
+	defer rows.Close()
+
+	var changes []RoleChange
+	for rows.Next() {
+		c := RoleChange{Role: role, Granted: granted}
+		if err := rows.Scan(&c.ID, &c.UserName); err != nil {
+			return nil, errors.Wrap(err, "could not scan row to member")
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not change roles")
+	}
+	return changes, nil
+}
+
+// memberIDs returns ids of members without duplicates.
+func memberIDs(members []Member) []string {
+	ids := make([]string, 0, len(members))
+	for _, mb := range members {
+		if !contains(ids, mb.ID) {
+			ids = append(ids, mb.ID)
+		}
+	}
+	return ids
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+// Package seed loads development fixtures into database.
+//
+// Fixtures are JSON files in a directory per environment, e.g. fixtures/development/articles.json,
+// files are loaded in name order. Loading is idempotent: articles are matched by slug,
+// so fixtures can be loaded again after they are edited.
+package seed
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/internal/article"
+	"github.com/agalitsyn/goapi/pkg/sanitize"
+)
+
+// Fixtures are resources to load, files of a set may contain any of them.
+type Fixtures struct {
+	Articles []*article.Article `json:"articles"`
+}
+
+// Result counts loaded resources.
+type Result struct {
+	// Saved are created or changed resources, the rest are already up to date.
+	Saved     int
+	Unchanged int
+}
+
+// Load reads fixtures of environment from dir.
+func Load(dir, env string) (*Fixtures, error) {
+	dir = filepath.Join(dir, env)
+	if _, err := os.Stat(dir); err != nil {
+		return nil, errors.Wrapf(err, "could not find fixtures of environment %q", env)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list fixtures")
+	}
+
+	f := &Fixtures{}
+	for _, name := range files {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read fixtures")
+		}
+		var part Fixtures
+		if err := json.Unmarshal(data, &part); err != nil {
+			return nil, errors.Wrapf(err, "could not parse fixtures %s", name)
+		}
+		f.Articles = append(f.Articles, part.Articles...)
+	}
+	return f, nil
+}
+
+// Apply saves fixtures in a transaction, nothing is saved when any of them is invalid.
+func Apply(db *sql.DB, f *Fixtures) (Result, error) {
+	var res Result
+	m := article.NewManager(db, sanitize.DefaultPolicy)
+	err := m.Tx(false, func(m *article.Manager) error {
+		for i, a := range f.Articles {
+			if err := prepare(a); err != nil {
+				return errors.Wrapf(err, "invalid article #%d", i+1)
+			}
+			saved, err := m.Upsert(a)
+			if err != nil {
+				return err
+			}
+			if saved {
+				res.Saved++
+			} else {
+				res.Unchanged++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return res, nil
+}
+
+// prepare fills defaults the same way API does on creation.
+func prepare(a *article.Article) error {
+	if a.Title == "" {
+		return errors.New("title is required")
+	}
+	if a.Slug == "" {
+		a.Slug = article.Slugify(a.Title)
+	}
+	if a.Slug == "" {
+		return errors.Errorf("could not make slug of title %q", a.Title)
+	}
+	if a.Status == "" {
+		a.Status = article.StatusDraft
+	}
+	for _, s := range article.Statuses {
+		if a.Status == s {
+			return nil
+		}
+	}
+	return errors.Errorf("invalid status %q", a.Status)
+}
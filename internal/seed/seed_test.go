@@ -0,0 +1,77 @@
+package seed
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/internal/article"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "development"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "development", "2-more.json"), []byte(`{"articles": [{"title": "Second"}]}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "development", "1-articles.json"), []byte(`{"articles": [{"title": "First", "tags": ["news"]}]}`), 0644)
+
+	f, err := Load(dir, "development")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Articles) != 2 || f.Articles[0].Title != "First" || f.Articles[1].Title != "Second" {
+		t.Errorf("unexpected fixtures: %+v", f.Articles)
+	}
+
+	if _, err := Load(dir, "production"); err == nil {
+		t.Error("missing environment is loaded")
+	}
+}
+
+func TestApply(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO article(.+) ON CONFLICT \\(slug\\) DO UPDATE").
+		WithArgs("Новая", "novaya", article.StatusDraft, "{}", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "revision"}).AddRow("1", time.Now(), 1))
+	mock.ExpectQuery("INSERT INTO article(.+) ON CONFLICT \\(slug\\) DO UPDATE").
+		WithArgs("Old", "old", article.StatusPublished, `{"news"}`, "body").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectCommit()
+
+	res, err := Apply(db, &Fixtures{Articles: []*article.Article{
+		{Title: "Новая"},
+		{Title: "Old", Status: article.StatusPublished, Tags: []string{"news"}, Body: "body"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != (Result{Saved: 1, Unchanged: 1}) {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	// nothing is saved when fixtures are invalid
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	if _, err := Apply(db, &Fixtures{Articles: []*article.Article{{Title: "Hidden", Status: "hidden"}}}); err == nil {
+		t.Error("invalid fixture is applied")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
@@ -0,0 +1,261 @@
+package serviceauth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/jwtauth"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+)
+
+// GrantClientCredentials is the only grant type of token endpoint.
+const GrantClientCredentials = "client_credentials"
+
+// TokenRoutes serve token endpoint, tokens are signed by issuer.
+func TokenRoutes(m *Manager, issuer *jwtauth.Issuer) chi.Router {
+	r := chi.NewRouter()
+	r.Use(noStore)
+	r.Post("/", makeHandler(m, tokenHandler(issuer)))
+	return r
+}
+
+// AdminRoutes manage clients.
+func AdminRoutes(m *Manager) chi.Router {
+	r := chi.NewRouter()
+	r.Use(handler.RequireRole(reqctx.AdminRole))
+	r.Get("/", makeHandler(m, listHandler))
+	r.Post("/", makeHandler(m, createHandler))
+	r.Route("/{id}", func(r chi.Router) {
+		r.Delete("/", makeHandler(m, deleteHandler))
+		r.With(noStore).Post("/secret", makeHandler(m, resetSecretHandler))
+	})
+	return r
+}
+
+type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m.WithContext(r.Context()), w, r)
+	}
+}
+
+func noStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tokenRequest is read from form as RFC 6749 defines it, or from JSON like other endpoints.
+type tokenRequest struct {
+	GrantType    string `json:"grant_type"`
+	Scope        string `json:"scope"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+func decodeTokenRequest(r *http.Request) (*tokenRequest, error) {
+	var data tokenRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if err := r.ParseForm(); err != nil {
+			return nil, i18n.Errorf("request.invalid_body")
+		}
+		data.GrantType = r.PostForm.Get("grant_type")
+		data.Scope = r.PostForm.Get("scope")
+		data.ClientID = r.PostForm.Get("client_id")
+		data.ClientSecret = r.PostForm.Get("client_secret")
+	} else if err := serializer.Decode(r, &data); err != nil {
+		return nil, err
+	}
+	// credentials of Basic scheme are form-encoded, see RFC 6749 section 2.3.1
+	if id, secret, ok := r.BasicAuth(); ok {
+		var err error
+		if data.ClientID, err = url.QueryUnescape(id); err != nil {
+			return nil, ErrInvalidClient
+		}
+		if data.ClientSecret, err = url.QueryUnescape(secret); err != nil {
+			return nil, ErrInvalidClient
+		}
+	}
+	return &data, nil
+}
+
+// tokenHandler exchanges credentials of client for access token with requested scopes.
+func tokenHandler(issuer *jwtauth.Issuer) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "serviceauth")
+
+		data, err := decodeTokenRequest(r)
+		if err == ErrInvalidClient {
+			logger.WithError(err).Warn()
+			w.Header().Set("WWW-Authenticate", `Basic realm="services"`)
+			render.Render(w, r, handler.ErrUnauthorized(err))
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if data.GrantType != GrantClientCredentials {
+			err := i18n.Errorf("serviceauth.unsupported_grant", data.GrantType)
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+
+		c, err := m.Authenticate(data.ClientID, data.ClientSecret)
+		if err == ErrInvalidClient {
+			logger.WithError(err).WithField("client", data.ClientID).Warn()
+			w.Header().Set("WWW-Authenticate", `Basic realm="services"`)
+			render.Render(w, r, handler.ErrUnauthorized(err))
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		scopes, err := c.GrantScopes(data.Scope)
+		if err != nil {
+			logger.WithError(err).WithField("client", c.ID).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+
+		scope := strings.Join(scopes, " ")
+		token, _, err := issuer.Issue(jwtauth.Claims{Subject: c.ID, Roles: Roles(scopes), Scope: scope})
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		logger.WithField("client", c.ID).Infof("token issued with scope %q", scope)
+		render.Render(w, r, &tokenResponse{
+			AccessToken: token,
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(issuer.TTL() / time.Second),
+			Scope:       scope,
+		})
+	}
+}
+
+func listHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "serviceauth")
+
+	clients, err := m.List()
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.JSON(w, r, clients)
+}
+
+// createHandler registers client as {"id": "billing", "scopes": ["articles:read"]} and responds with
+// its secret, which is not shown again.
+func createHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "serviceauth")
+
+	var data struct {
+		ID     string   `json:"id"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := serializer.Decode(r, &data); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	if err := ValidateClient(data.ID, data.Scopes); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return
+	}
+	c, secret, err := m.Create(data.ID, data.Scopes)
+	if err == ErrClientExists {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrConflict(err))
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	logger.WithField("user", reqctx.GetUser(r.Context()).ID).Warnf("service client %s registered", c.ID)
+	w.Header().Set("Cache-Control", "no-store")
+	render.Status(r, http.StatusCreated)
+	render.Render(w, r, &clientResponse{Client: c, Secret: secret})
+}
+
+// resetSecretHandler responds with a new secret of client, the old one stops working at once.
+func resetSecretHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "serviceauth")
+
+	id := chi.URLParam(r, "id")
+	secret, err := m.ResetSecret(id)
+	if err == ErrNotFound {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrNotFound(i18n.Errorf("serviceauth.not_found", id)))
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	logger.WithField("user", reqctx.GetUser(r.Context()).ID).Warnf("secret of service client %s reset", id)
+	render.JSON(w, r, map[string]string{"secret": secret})
+}
+
+// deleteHandler removes client, tokens issued to it stay valid until they expire.
+func deleteHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	logger := log.GetLogEntry(r).WithField("context", "serviceauth")
+
+	id := chi.URLParam(r, "id")
+	err := m.Delete(id)
+	if err == ErrNotFound {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrNotFound(i18n.Errorf("serviceauth.not_found", id)))
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	logger.WithField("user", reqctx.GetUser(r.Context()).ID).Warnf("service client %s deleted", id)
+	render.NoContent(w, r)
+}
+
+// tokenResponse is in form of OAuth2 access token response, see RFC 6749.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+func (tr *tokenResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type clientResponse struct {
+	*Client
+	Secret string `json:"secret"`
+}
+
+func (cr *clientResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
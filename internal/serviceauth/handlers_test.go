@@ -0,0 +1,179 @@
+package serviceauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/ids"
+	"github.com/agalitsyn/goapi/pkg/jwtauth"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestTokenHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	key, _ := rsa.GenerateKey(rand.Reader, 1024)
+	keys, err := jwtauth.NewKeySet(jwtauth.SigningKey{ID: "k1", Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := jwtauth.NewIssuer(jwtauth.IssuerConfig{Keys: keys, Issuer: "https://api.example.com", TTL: 5 * time.Minute})
+	router := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	router.Mount("/services/token", TokenRoutes(NewManager(db), issuer))
+
+	expectClient := func(id string) {
+		mock.ExpectQuery("SELECT secret_hash, scopes, created_at FROM service_client WHERE id = \\$1;").
+			WithArgs(id).
+			WillReturnRows(sqlmock.NewRows([]string{"secret_hash", "scopes", "created_at"}).
+				AddRow(hashSecret("s3cret"), "{articles:read,users:read}", time.Now()))
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		basic       []string
+		body        string
+		expect      string
+		status      int
+		scope       string
+	}{
+		{"form with basic", "application/x-www-form-urlencoded", []string{"billing", "s3cret"},
+			"grant_type=client_credentials&scope=articles:read", "billing", http.StatusOK, "articles:read"},
+		{"json with all scopes", "application/json", nil,
+			`{"grant_type":"client_credentials","client_id":"billing","client_secret":"s3cret"}`, "billing", http.StatusOK, "articles:read users:read"},
+		{"wrong secret", "application/x-www-form-urlencoded", []string{"billing", "wrong"},
+			"grant_type=client_credentials", "billing", http.StatusUnauthorized, ""},
+		{"scope not allowed", "application/x-www-form-urlencoded", []string{"billing", "s3cret"},
+			"grant_type=client_credentials&scope=articles:write", "billing", http.StatusBadRequest, ""},
+		{"unsupported grant", "application/x-www-form-urlencoded", []string{"billing", "s3cret"},
+			"grant_type=password", "", http.StatusBadRequest, ""},
+		{"no credentials", "application/x-www-form-urlencoded", nil,
+			"grant_type=client_credentials", "", http.StatusUnauthorized, ""},
+	}
+	verifier := jwtauth.NewVerifier(jwtauth.VerifierConfig{Keys: keys, Issuer: "https://api.example.com"})
+	for _, tt := range tests {
+		if tt.expect != "" {
+			expectClient(tt.expect)
+		}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/services/token", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+		if tt.basic != nil {
+			req.SetBasicAuth(tt.basic[0], tt.basic[1])
+		}
+		router.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, w.Code, w.Body)
+			continue
+		}
+		if w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("%s: token response may be cached", tt.name)
+		}
+		if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate challenge", tt.name)
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var resp tokenResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Scope != tt.scope || resp.TokenType != "Bearer" || resp.ExpiresIn != 300 {
+			t.Errorf("%s: unexpected response %+v", tt.name, resp)
+		}
+		claims, err := verifier.Verify(resp.AccessToken)
+		if err != nil {
+			t.Errorf("%s: issued token is not valid: %v", tt.name, err)
+			continue
+		}
+		// scopes are namespaced in roles, so they do not grant roles of users
+		if claims.Subject != "billing" || claims.Scope != tt.scope || strings.Join(claims.Roles, " ") != "service:"+strings.Replace(tt.scope, " ", " service:", -1) {
+			t.Errorf("%s: unexpected claims %+v", tt.name, claims)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db, ids: &ids.Sequence{Prefix: "secret"}}
+	newRouter := func(u *reqctx.User) http.Handler {
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(withUser(u))
+		r.Mount("/admin/service-clients", AdminRoutes(m))
+		return r
+	}
+	admin := &reqctx.User{ID: "1", Roles: []string{reqctx.AdminRole}}
+
+	mock.ExpectQuery("INSERT INTO service_client(.+) ON CONFLICT \\(id\\) DO NOTHING RETURNING created_at;").
+		WithArgs("billing", hashSecret("secret1"), "{\"articles:read\"}").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	mock.ExpectQuery("INSERT INTO service_client(.+)").
+		WithArgs("search", hashSecret("secret2"), "{}").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}))
+
+	tests := []struct {
+		user   *reqctx.User
+		body   string
+		status int
+	}{
+		{admin, `{"id":"billing","scopes":["articles:read"]}`, http.StatusCreated},
+		{admin, `{"id":"search"}`, http.StatusConflict},
+		// numeric IDs would be confused with users
+		{admin, `{"id":"42"}`, http.StatusBadRequest},
+		{admin, `{"id":"billing","scopes":["articles read"]}`, http.StatusBadRequest},
+		{admin, `{"id":"billing","scopes":["admin"]}`, http.StatusBadRequest},
+		{admin, `{"id":"billing","scopes":["service:articles:read"]}`, http.StatusBadRequest},
+		{&reqctx.User{ID: "2"}, `{"id":"billing"}`, http.StatusForbidden},
+		{nil, `{"id":"billing"}`, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/admin/service-clients", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		newRouter(tt.user).ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.body, tt.status, w.Code, w.Body)
+			continue
+		}
+		if w.Code == http.StatusCreated && !strings.Contains(w.Body.String(), `"secret":"secret1"`) {
+			t.Errorf("%s: secret is not in response %s", tt.body, w.Body)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,26 @@
+package serviceauth
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"serviceauth.invalid_client":    "client id or secret is wrong",
+		"serviceauth.invalid_client_id": "invalid client id %q, use lowercase letters, digits, dots, dashes and underscores starting with a letter",
+		"serviceauth.invalid_scope":     "invalid scope %q",
+		"serviceauth.reserved_scope":    "scope %q is a reserved role name",
+		"serviceauth.scope_not_allowed": "client may not request scope %s",
+		"serviceauth.unsupported_grant": "grant type %s is not supported",
+		"serviceauth.client_exists":     "service client with this id is already registered",
+		"serviceauth.not_found":         "service client %s not found",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"serviceauth.invalid_client":    "неверный id или секрет клиента",
+		"serviceauth.invalid_client_id": "неверный id клиента %q, используйте строчные буквы, цифры, точки, дефисы и подчёркивания, начиная с буквы",
+		"serviceauth.invalid_scope":     "неверная область доступа %q",
+		"serviceauth.reserved_scope":    "область доступа %q совпадает с зарезервированной ролью",
+		"serviceauth.scope_not_allowed": "клиенту не разрешена область доступа %s",
+		"serviceauth.unsupported_grant": "тип гранта %s не поддерживается",
+		"serviceauth.client_exists":     "сервисный клиент с таким id уже зарегистрирован",
+		"serviceauth.not_found":         "сервисный клиент %s не найден",
+	})
+}
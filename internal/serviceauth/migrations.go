@@ -0,0 +1,20 @@
+package serviceauth
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "0036_service_client",
+			Up: []string{
+				`CREATE TABLE service_client (
+					id           character varying(64)       NOT NULL,
+					secret_hash  character varying(64)       NOT NULL,
+					scopes       text[]                      NOT NULL DEFAULT '{}',
+					created_at   timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id)
+				);`,
+			},
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package serviceauth
+
+import (
+	"net/http"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/pkg/jwtauth"
+	"github.com/agalitsyn/goapi/pkg/module"
+)
+
+func init() {
+	module.Register(&serviceAuthModule{})
+}
+
+type serviceAuthModule struct {
+	module.Base
+
+	opts struct {
+		TokenTTL time.Duration `long:"service-auth-token-ttl" env:"GAPI_SERVICE_AUTH_TOKEN_TTL" default:"5m" description:"How long tokens of service clients are valid."`
+		Issuer   string        `long:"service-auth-issuer" env:"GAPI_SERVICE_AUTH_ISSUER" description:"iss claim of tokens of service clients, public URL of service by default. It must match --jwt-issuer, if that is set, for the service to accept the tokens itself."`
+		Audience string        `long:"service-auth-audience" env:"GAPI_SERVICE_AUTH_AUDIENCE" description:"aud claim of tokens of service clients, omitted if empty."`
+	}
+
+	manager *Manager
+	// issuer is nil unless the service has signing keys, see --jwt-signing-key
+	issuer *jwtauth.Issuer
+}
+
+func (mod *serviceAuthModule) Name() string                     { return "serviceauth" }
+func (mod *serviceAuthModule) Options() interface{}             { return &mod.opts }
+func (mod *serviceAuthModule) Migrations() []*migrate.Migration { return Migrations() }
+
+func (mod *serviceAuthModule) Init(env *module.Env) error {
+	mod.manager = NewManager(env.DB)
+	keys, ok := env.Lookup(jwtauth.KeySetService)
+	if !ok {
+		return nil
+	}
+	issuer := mod.opts.Issuer
+	if issuer == "" {
+		issuer = env.BaseURL
+	}
+	mod.issuer = jwtauth.NewIssuer(jwtauth.IssuerConfig{
+		Keys:     keys.(*jwtauth.KeySet),
+		Issuer:   issuer,
+		Audience: mod.opts.Audience,
+		TTL:      mod.opts.TokenTTL,
+	})
+	return nil
+}
+
+// Routes serve token endpoint only when the service has signing keys, clients may be registered before.
+func (mod *serviceAuthModule) Routes() map[string]http.Handler {
+	routes := map[string]http.Handler{"/admin/service-clients": AdminRoutes(mod.manager)}
+	if mod.issuer != nil {
+		routes["/services/token"] = TokenRoutes(mod.manager, mod.issuer)
+	}
+	return routes
+}
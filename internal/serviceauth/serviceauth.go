@@ -0,0 +1,222 @@
+// Package serviceauth issues access tokens to other internal services with the OAuth2 client credentials
+// grant, see RFC 6749 section 4.4:
+//
+//	POST /1.0/services/token
+//	Authorization: Basic base64(client_id:client_secret)
+//	Content-Type: application/x-www-form-urlencoded
+//
+//	grant_type=client_credentials&scope=articles:read
+//
+// Clients are registered by admins and are given scopes they may request, tokens carry granted scopes
+// in scope claim, and in roles claim prefixed with RolePrefix, e.g. service:articles:read, so a scope
+// never grants a role of users such as admin. Tokens are short-lived and signed with keys of the service, see
+// jwtauth.KeySet, which other services verify with /.well-known/jwks.json.
+package serviceauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/ids"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+var (
+	ErrNotFound = errors.New("service client not found")
+	// ErrInvalidClient is returned for unknown client and wrong secret alike.
+	ErrInvalidClient = i18n.Errorf("serviceauth.invalid_client")
+	// ErrClientExists is returned when client with the same ID is registered.
+	ErrClientExists = i18n.Errorf("serviceauth.client_exists")
+)
+
+// RolePrefix namespaces roles of granted scopes in tokens.
+const RolePrefix = "service:"
+
+// reservedScopes are roles of users, clients may not be given them as scopes to avoid confusion,
+// since namespaced roles would not grant them anyway.
+var reservedScopes = map[string]bool{reqctx.AdminRole: true, "moderator": true, "replication": true}
+
+// Roles returns roles of granted scopes.
+func Roles(scopes []string) []string {
+	roles := make([]string, len(scopes))
+	for i, s := range scopes {
+		roles[i] = RolePrefix + s
+	}
+	return roles
+}
+
+// idPattern keeps client IDs apart from IDs of users, which are numbers, since both are subjects of tokens.
+var idPattern = regexp.MustCompile(`^[a-z][a-z0-9._-]{0,63}$`)
+
+// Client is a service which gets tokens with its secret, Scopes are the ones it may request.
+type Client struct {
+	ID        string    `json:"id"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Manager struct {
+	db  postgres.Querier
+	ids ids.Generator
+}
+
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{db: db, ids: ids.Random}
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db), ids: m.ids}
+}
+
+// ValidateClient checks that ID starts with a lowercase letter and scopes are non-empty words which
+// are not reserved role names.
+func ValidateClient(id string, scopes []string) error {
+	if !idPattern.MatchString(id) {
+		return i18n.Errorf("serviceauth.invalid_client_id", id)
+	}
+	for _, s := range scopes {
+		if s == "" || strings.ContainsAny(s, " \t\r\n\"\\") {
+			return i18n.Errorf("serviceauth.invalid_scope", s)
+		}
+		if reservedScopes[s] || strings.HasPrefix(s, RolePrefix) {
+			return i18n.Errorf("serviceauth.reserved_scope", s)
+		}
+	}
+	return nil
+}
+
+// Create registers client and returns its secret, which is shown only once, only its hash is kept.
+func (m *Manager) Create(id string, scopes []string) (*Client, string, error) {
+	if err := ValidateClient(id, scopes); err != nil {
+		return nil, "", err
+	}
+	secret, err := m.ids.NewID()
+	if err != nil {
+		return nil, "", err
+	}
+	c := &Client{ID: id, Scopes: scopes}
+	if c.Scopes == nil {
+		c.Scopes = []string{}
+	}
+	err = m.db.QueryRow(
+		"INSERT INTO service_client(id, secret_hash, scopes) VALUES ($1, $2, $3) ON CONFLICT (id) DO NOTHING RETURNING created_at;",
+		c.ID, hashSecret(secret), pq.Array(c.Scopes),
+	).Scan(&c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, "", ErrClientExists
+	}
+	if err != nil {
+		return nil, "", errors.Wrap(err, "could not create service client")
+	}
+	return c, secret, nil
+}
+
+func (m *Manager) List() ([]*Client, error) {
+	rows, err := m.db.Query("SELECT id, scopes, created_at FROM service_client ORDER BY id;")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list service clients")
+	}
+	defer rows.Close()
+	clients := []*Client{}
+	for rows.Next() {
+		var c Client
+		if err := rows.Scan(&c.ID, pq.Array(&c.Scopes), &c.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan service client")
+		}
+		clients = append(clients, &c)
+	}
+	return clients, errors.Wrap(rows.Err(), "could not list service clients")
+}
+
+// ResetSecret replaces secret of client and returns the new one, tokens issued before stay valid until
+// they expire.
+func (m *Manager) ResetSecret(id string) (string, error) {
+	secret, err := m.ids.NewID()
+	if err != nil {
+		return "", err
+	}
+	res, err := m.db.Exec("UPDATE service_client SET secret_hash = $2 WHERE id = $1;", id, hashSecret(secret))
+	if err != nil {
+		return "", errors.Wrap(err, "could not reset service client secret")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+func (m *Manager) Delete(id string) error {
+	res, err := m.db.Exec("DELETE FROM service_client WHERE id = $1;", id)
+	if err != nil {
+		return errors.Wrap(err, "could not delete service client")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Authenticate returns client with ID and secret.
+func (m *Manager) Authenticate(id, secret string) (*Client, error) {
+	if id == "" || secret == "" {
+		return nil, ErrInvalidClient
+	}
+	c := Client{ID: id}
+	var hash string
+	err := m.db.QueryRow(
+		"SELECT secret_hash, scopes, created_at FROM service_client WHERE id = $1;", id,
+	).Scan(&hash, pq.Array(&c.Scopes), &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get service client")
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(hashSecret(secret))) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return &c, nil
+}
+
+// GrantScopes returns scopes of space-separated request, all scopes of client when it is empty.
+// Scopes client may not request are rejected rather than left out, so clients notice misconfiguration.
+func (c *Client) GrantScopes(requested string) ([]string, error) {
+	fields := strings.Fields(requested)
+	if len(fields) == 0 {
+		return c.Scopes, nil
+	}
+	allowed := make(map[string]bool, len(c.Scopes))
+	for _, s := range c.Scopes {
+		allowed[s] = true
+	}
+	granted := make([]string, 0, len(fields))
+	seen := make(map[string]bool, len(fields))
+	for _, s := range fields {
+		if !allowed[s] {
+			return nil, i18n.Errorf("serviceauth.scope_not_allowed", s)
+		}
+		if !seen[s] {
+			seen[s] = true
+			granted = append(granted, s)
+		}
+	}
+	return granted, nil
+}
+
+// hashSecret is kept instead of secret, secrets are random, so a fast hash is enough.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,57 @@
+package sitemap
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+)
+
+var errNotGenerated = errors.New("sitemap is not generated yet")
+
+// Register adds sitemap routes to r. Sitemap may only list URLs under its own path,
+// so unlike other modules it is registered at root rather than mounted.
+func Register(r chi.Router, s *Sitemap) {
+	r.Get("/sitemap.xml", indexHandler(s))
+	r.Get("/sitemap-{page}.xml", pageHandler(s))
+}
+
+func indexHandler(s *Sitemap) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		content, mtime := s.Index()
+		if content == nil {
+			render.Render(w, r, handler.ErrNotFound(errNotGenerated))
+			return
+		}
+		serve(w, r, content, mtime)
+	}
+}
+
+func pageHandler(s *Sitemap) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimSuffix(chi.URLParam(r, "page"), ".xml"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		content, mtime := s.Page(n)
+		if content == nil {
+			http.NotFound(w, r)
+			return
+		}
+		serve(w, r, content, mtime)
+	}
+}
+
+func serve(w http.ResponseWriter, r *http.Request, content []byte, mtime time.Time) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeContent(w, r, "", mtime, bytes.NewReader(content))
+}
@@ -0,0 +1,59 @@
+package sitemap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+func TestRoutes(t *testing.T) {
+	source := func() ([]URL, error) {
+		var urls []URL
+		for i := 1; i <= 5; i++ {
+			urls = append(urls, URL{Loc: "https://example.com/" + strconv.Itoa(i), LastMod: time.Now()})
+		}
+		return urls, nil
+	}
+	s := New("https://api.example.com/", 2, source)
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	Register(r, s)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status before generation: %v", w.Code)
+	}
+
+	if err := s.Generate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		status   int
+		contains string
+	}{
+		{"/sitemap.xml", http.StatusOK, "<sitemap><loc>https://api.example.com/sitemap-3.xml</loc>"},
+		{"/sitemap-1.xml", http.StatusOK, "<url><loc>https://example.com/2</loc>"},
+		{"/sitemap-3.xml", http.StatusOK, "<url><loc>https://example.com/5</loc>"},
+		{"/sitemap-4.xml", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%v: unexpected status: %v", tt.path, w.Code)
+		}
+		if !bytes.Contains(w.Body.Bytes(), []byte(tt.contains)) {
+			t.Errorf("%v: unexpected body: %v", tt.path, w.Body.String())
+		}
+	}
+}
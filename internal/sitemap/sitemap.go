@@ -0,0 +1,195 @@
+// Package sitemap serves sitemap.xml of published content.
+//
+// Sitemaps are regenerated periodically and served from memory. When there are more URLs
+// than fit into a single file, sitemap.xml is an index of sitemap-N.xml pages.
+package sitemap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// MaxPageSize is a limit of URLs in a single sitemap file set by the protocol.
+const MaxPageSize = 50000
+
+const xmlns = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// URL is a page of the site.
+type URL struct {
+	Loc     string    `xml:"loc"`
+	LastMod time.Time `xml:"-"`
+}
+
+// Source lists URLs of some kind of content.
+type Source func() ([]URL, error)
+
+// SourcePrefix is a prefix of names modules provide sources with, e.g. sitemap.source.articles.
+const SourcePrefix = "sitemap.source."
+
+// Sitemap keeps generated sitemap files.
+type Sitemap struct {
+	baseURL  string
+	pageSize int
+	sources  []Source
+	clock    clock.Clock
+
+	mu    sync.RWMutex
+	index []byte
+	pages [][]byte
+	mtime time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates sitemap which files are located at baseURL, pageSize is capped by MaxPageSize.
+func New(baseURL string, pageSize int, sources ...Source) *Sitemap {
+	if pageSize <= 0 || pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	return &Sitemap{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		pageSize: pageSize,
+		sources:  sources,
+		clock:    clock.Real,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+type urlSet struct {
+	XMLName xml.Name `xml:"urlset"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	URLs    []entry  `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name `xml:"sitemapindex"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Sitemaps []entry  `xml:"sitemap"`
+}
+
+type entry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+func newEntry(loc string, lastMod time.Time) entry {
+	e := entry{Loc: loc}
+	if !lastMod.IsZero() {
+		e.LastMod = lastMod.UTC().Format(time.RFC3339)
+	}
+	return e
+}
+
+// Generate collects URLs from sources and replaces served files.
+func (s *Sitemap) Generate() error {
+	var urls []URL
+	for _, src := range s.sources {
+		u, err := src()
+		if err != nil {
+			return err
+		}
+		urls = append(urls, u...)
+	}
+
+	var pages [][]byte
+	var idx sitemapIndex
+	for start := 0; start < len(urls) || start == 0; start += s.pageSize {
+		end := start + s.pageSize
+		if end > len(urls) {
+			end = len(urls)
+		}
+		set := urlSet{Xmlns: xmlns, URLs: []entry{}}
+		var lastMod time.Time
+		for _, u := range urls[start:end] {
+			set.URLs = append(set.URLs, newEntry(u.Loc, u.LastMod))
+			if u.LastMod.After(lastMod) {
+				lastMod = u.LastMod
+			}
+		}
+		page, err := marshal(set)
+		if err != nil {
+			return err
+		}
+		pages = append(pages, page)
+		idx.Sitemaps = append(idx.Sitemaps, newEntry(s.pageURL(len(pages)), lastMod))
+	}
+
+	index := pages[0]
+	if len(pages) > 1 {
+		idx.Xmlns = xmlns
+		var err error
+		if index, err = marshal(idx); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.index, s.pages, s.mtime = index, pages, s.clock.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Sitemap) pageURL(n int) string {
+	return s.baseURL + "/sitemap-" + strconv.Itoa(n) + ".xml"
+}
+
+// Index returns sitemap.xml, which is the only page when URLs fit into it.
+func (s *Sitemap) Index() ([]byte, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index, s.mtime
+}
+
+// Page returns sitemap-n.xml, pages are numbered from 1. Nil is returned for missing page.
+func (s *Sitemap) Page(n int) ([]byte, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if n < 1 || n > len(s.pages) || len(s.pages) == 1 {
+		return nil, time.Time{}
+	}
+	return s.pages[n-1], s.mtime
+}
+
+// Run regenerates sitemap every interval until Close is called.
+func (s *Sitemap) Run(interval time.Duration, logger log.Logger) {
+	defer close(s.done)
+
+	ticker := s.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := s.Generate(); err != nil {
+				logger.WithError(err).Error("could not generate sitemap")
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops Run.
+func (s *Sitemap) Close() {
+	close(s.stop)
+	<-s.done
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, errors.Wrap(err, "could not encode sitemap")
+	}
+	return buf.Bytes(), nil
+}
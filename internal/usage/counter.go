@@ -0,0 +1,197 @@
+package usage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// Caps are monthly request caps, accounts without their own cap get Default. Zero cap is unlimited.
+type Caps struct {
+	Default  int64
+	Accounts map[string]int64
+}
+
+// ParseCap parses cap of account in form account=limit, e.g. tenant:acme=100000.
+func ParseCap(s string) (account string, limit int64, err error) {
+	i := strings.LastIndexByte(s, '=')
+	if i <= 0 {
+		return "", 0, fmt.Errorf("invalid usage cap %q, must be account=limit", s)
+	}
+	limit, err = strconv.ParseInt(s[i+1:], 10, 64)
+	if err != nil || limit < 0 {
+		return "", 0, fmt.Errorf("invalid usage cap %q, limit must be a non-negative integer", s)
+	}
+	return s[:i], limit, nil
+}
+
+func (c Caps) of(account string) int64 {
+	if limit, ok := c.Accounts[account]; ok {
+		return limit
+	}
+	return c.Default
+}
+
+// Quota is usage of account in the current calendar month (UTC).
+type Quota struct {
+	// Limit is zero when account is not capped.
+	Limit int64
+	Used  int64
+	Reset time.Time
+}
+
+// Exceeded reports whether account may not make more requests this month.
+func (q *Quota) Exceeded() bool {
+	return q.Limit > 0 && q.Used >= q.Limit
+}
+
+// Counter accumulates requests in memory and flushes them to database in batches, like article views.
+// Monthly totals of accounts are loaded on first request and kept up to date in memory,
+// so with several instances caps are enforced approximately.
+type Counter struct {
+	m        *Manager
+	caps     Caps
+	interval time.Duration
+	clock    clock.Clock
+
+	mu      sync.Mutex
+	pending map[key]int64
+	month   time.Time
+	totals  map[string]int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewCounter(m *Manager, caps Caps, interval time.Duration) *Counter {
+	return &Counter{
+		m:        m,
+		caps:     caps,
+		interval: interval,
+		clock:    clock.Real,
+		pending:  make(map[key]int64),
+		totals:   make(map[string]int64),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Check returns quota of account.
+func (c *Counter) Check(account string) (*Quota, error) {
+	month := monthOf(c.clock.Now())
+
+	c.mu.Lock()
+	c.rollover(month)
+	used, ok := c.totals[account]
+	c.mu.Unlock()
+
+	if !ok {
+		total, err := c.m.Total(account, month)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.rollover(month)
+		if _, ok := c.totals[account]; !ok {
+			// requests which are not flushed yet are not in total
+			from := month.Format("2006-01-02")
+			for k, n := range c.pending {
+				if k.account == account && k.day >= from {
+					total += n
+				}
+			}
+			c.totals[account] = total
+		}
+		used = c.totals[account]
+		c.mu.Unlock()
+	}
+	return &Quota{Limit: c.caps.of(account), Used: used, Reset: month.AddDate(0, 1, 0)}, nil
+}
+
+// Inc counts request of account to route.
+func (c *Counter) Inc(account, route string) {
+	now := c.clock.Now().UTC()
+	c.mu.Lock()
+	c.rollover(monthOf(now))
+	c.pending[key{account: account, route: route, day: now.Format("2006-01-02")}]++
+	if _, ok := c.totals[account]; ok {
+		c.totals[account]++
+	}
+	c.mu.Unlock()
+}
+
+// Forget drops pending requests and total of account.
+func (c *Counter) Forget(account string) {
+	c.mu.Lock()
+	for k := range c.pending {
+		if k.account == account {
+			delete(c.pending, k)
+		}
+	}
+	delete(c.totals, account)
+	c.mu.Unlock()
+}
+
+// rollover forgets totals of the previous month, it must be called with mu held.
+func (c *Counter) rollover(month time.Time) {
+	if !month.Equal(c.month) {
+		c.month = month
+		c.totals = make(map[string]int64)
+	}
+}
+
+// Flush writes pending requests with a single statement, they are kept for the next flush on failure.
+func (c *Counter) Flush() error {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[key]int64, len(pending))
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := c.m.add(pending); err != nil {
+		c.mu.Lock()
+		for k, n := range pending {
+			c.pending[k] += n
+		}
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run flushes requests every interval until Close is called.
+func (c *Counter) Run(logger log.Logger) {
+	defer close(c.done)
+
+	ticker := c.clock.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if err := c.Flush(); err != nil {
+				logger.WithError(err).Error()
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Close stops Run and flushes the rest of requests.
+func (c *Counter) Close() error {
+	close(c.stop)
+	<-c.done
+	return c.Flush()
+}
+
+func monthOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,144 @@
+package usage
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+)
+
+func Routes(m *Manager, c *Counter) chi.Router {
+	r := chi.NewRouter()
+	r.Get("/", makeHandler(m, usageHandler(c)))
+	return r
+}
+
+type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m.WithContext(r.Context()), w, r)
+	}
+}
+
+// usageHandler responds with quota and daily usage of the requesting account,
+// ?from= and ?to= days default to the current month. Requests not flushed yet are in quota only.
+func usageHandler(c *Counter) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "usage")
+
+		account := Account(r)
+		if account == "" {
+			err := i18n.Errorf("usage.account_required")
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrUnauthorized(err))
+			return
+		}
+
+		quota, err := c.Check(account)
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		from, err := dayParam(r, "from", quota.Reset.AddDate(0, -1, 0))
+		if err != nil {
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		to, err := dayParam(r, "to", quota.Reset.AddDate(0, 0, -1))
+		if err != nil {
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+
+		days, err := m.ByAccount(account, from, to)
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		render.Render(w, r, &usageResponse{
+			Account: account,
+			Limit:   quota.Limit,
+			Used:    quota.Used,
+			ResetAt: quota.Reset,
+			Days:    days,
+		})
+	}
+}
+
+// dayParam reads day query parameter, def is returned when it is absent.
+func dayParam(r *http.Request, name string, def time.Time) (time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return serializer.ParseTime(v)
+}
+
+type usageResponse struct {
+	Account string    `json:"account"`
+	Limit   int64     `json:"limit"`
+	Used    int64     `json:"used"`
+	ResetAt time.Time `json:"reset_at"`
+	Days    []*Usage  `json:"days"`
+}
+
+func (ur *usageResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Middleware sets X-Quota-* headers of accounted requests and rejects requests over monthly cap with 429.
+// Requests are counted by route pattern after they are served, rejected ones are not counted.
+// Usage is not enforced when it can not be loaded, as accounting must not take the API down.
+func Middleware(c *Counter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			account := Account(r)
+			if account == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			quota, err := c.Check(account)
+			if err != nil {
+				log.GetLogEntry(r).WithField("context", "usage").WithError(err).Error()
+			} else if quota.Limit > 0 {
+				remaining := quota.Limit - quota.Used - 1
+				if remaining < 0 {
+					remaining = 0
+				}
+				reset := strconv.Itoa(int(quota.Reset.Sub(c.clock.Now()).Seconds() + 0.5))
+				h := w.Header()
+				h.Set("X-Quota-Limit", strconv.FormatInt(quota.Limit, 10))
+				h.Set("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+				h.Set("X-Quota-Reset", reset)
+
+				if quota.Exceeded() {
+					h.Set("X-Quota-Remaining", "0")
+					h.Set("Retry-After", reset)
+					locale := reqctx.GetLocale(r.Context())
+					handler.WriteProblem(w, &handler.Problem{
+						Title:    i18n.StatusText(locale, http.StatusTooManyRequests),
+						Status:   http.StatusTooManyRequests,
+						Detail:   i18n.Translate(locale, "usage.exceeded", quota.Limit, serializer.Day(quota.Reset, time.UTC)),
+						Instance: r.URL.Path,
+					})
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+			c.Inc(account, handler.RoutePattern(r))
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package usage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func withTenant(tenant string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(reqctx.WithTenant(r.Context(), tenant)))
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	c := NewCounter(&Manager{db: db}, Caps{Default: 2}, time.Hour)
+	c.clock = clock.NewFake(time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC))
+	month := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT coalesce\\(sum\\(requests\\), 0\\) FROM usage").
+		WithArgs("tenant:acme", month).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(1))
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Use(withTenant("acme"), Middleware(c))
+	r.Get("/articles/{articleID}", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/articles/1", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+	if l, rem := w.Header().Get("X-Quota-Limit"), w.Header().Get("X-Quota-Remaining"); l != "2" || rem != "0" {
+		t.Errorf("unexpected quota headers: %v, %v", l, rem)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/articles/2", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "1339200" {
+		t.Errorf("unexpected Retry-After: %v", ra)
+	}
+
+	// rejected request is not counted
+	mock.ExpectExec("INSERT INTO usage").
+		WithArgs(`{"tenant:acme"}`, `{"/articles/{articleID}"}`, `{"2018-06-15"}`, "{1}").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestMiddleware_Anonymous(t *testing.T) {
+	c := NewCounter(&Manager{}, Caps{Default: 1}, time.Hour)
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Use(Middleware(c))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-Quota-Limit") != "" {
+		t.Errorf("anonymous request is accounted: %v", w.Code)
+	}
+}
+
+func TestUsageHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	m := &Manager{db: db}
+	c := NewCounter(m, Caps{Accounts: map[string]int64{"tenant:acme": 100}}, time.Hour)
+	c.clock = clock.NewFake(time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC))
+
+	mock.ExpectQuery("SELECT coalesce\\(sum\\(requests\\), 0\\) FROM usage").
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(7))
+	mock.ExpectQuery("SELECT (.+) FROM usage WHERE account = \\$1 AND day BETWEEN \\$2 AND \\$3").
+		WithArgs("tenant:acme", time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, 6, 30, 0, 0, 0, 0, time.UTC)).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "route", "requests"}).AddRow("2018-06-14", "/1.0/articles/", 7))
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Use(withTenant("acme"))
+	r.Get("/", makeHandler(m, usageHandler(c)))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+	var resp struct {
+		Limit int64    `json:"limit"`
+		Used  int64    `json:"used"`
+		Days  []*Usage `json:"days"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Limit != 100 || resp.Used != 7 || len(resp.Days) != 1 || resp.Days[0].Requests != 7 {
+		t.Errorf("unexpected usage: %+v", resp)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestParseCap(t *testing.T) {
+	account, limit, err := ParseCap("tenant:acme=100000")
+	if err != nil || account != "tenant:acme" || limit != 100000 {
+		t.Errorf("unexpected cap: %v, %v, %v", account, limit, err)
+	}
+	for _, s := range []string{"tenant:acme", "=1", "tenant:acme=-1", "tenant:acme=x"} {
+		if _, _, err := ParseCap(s); err == nil {
+			t.Errorf("%s: error expected", s)
+		}
+	}
+}
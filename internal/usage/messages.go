@@ -0,0 +1,14 @@
+package usage
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"usage.account_required": "usage is accounted for authenticated tenants and users only",
+		"usage.exceeded":         "monthly quota of %d requests is exceeded, it resets on %s",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"usage.account_required": "использование учитывается только для аутентифицированных арендаторов и пользователей",
+		"usage.exceeded":         "месячная квота в %d запросов исчерпана, она обновится %s",
+	})
+}
@@ -0,0 +1,20 @@
+package usage
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "0010_usage_initial",
+			Up: []string{
+				`CREATE TABLE usage (
+					account     character varying(128)      NOT NULL,
+					route       character varying(256)      NOT NULL,
+					day         date                        NOT NULL,
+					requests    bigint                      NOT NULL DEFAULT 0,
+					PRIMARY KEY (account, day, route)
+				);`,
+			},
+		},
+	}
+}
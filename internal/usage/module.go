@@ -0,0 +1,68 @@
+package usage
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/internal/privacy"
+	"github.com/agalitsyn/goapi/pkg/module"
+	"github.com/agalitsyn/goapi/pkg/retention"
+)
+
+func init() {
+	module.Register(&usageModule{})
+}
+
+type usageModule struct {
+	module.Base
+
+	opts struct {
+		MonthlyCap    int64         `long:"usage-monthly-cap" env:"GAPI_USAGE_MONTHLY_CAP" default:"0" description:"Requests a tenant or user may make per calendar month, 0 is unlimited."`
+		Caps          []string      `long:"usage-cap" env:"GAPI_USAGE_CAPS" env-delim:"," description:"Monthly cap of account overriding the default one in form account=limit, e.g. tenant:acme=100000."`
+		FlushInterval time.Duration `long:"usage-flush-interval" env:"GAPI_USAGE_FLUSH_INTERVAL" default:"10s" description:"How often to write accumulated usage to database."`
+	}
+
+	manager *Manager
+	counter *Counter
+}
+
+func (mod *usageModule) Name() string                     { return "usage" }
+func (mod *usageModule) Options() interface{}             { return &mod.opts }
+func (mod *usageModule) Migrations() []*migrate.Migration { return Migrations() }
+func (mod *usageModule) Jobs() []module.Job               { return []module.Job{mod.counter} }
+
+// Init provides privacy handler as privacy.handler.usage.
+func (mod *usageModule) Init(env *module.Env) error {
+	if mod.opts.FlushInterval <= 0 {
+		return errors.New("--usage-flush-interval must be positive")
+	}
+	caps := Caps{Default: mod.opts.MonthlyCap, Accounts: map[string]int64{}}
+	for _, c := range mod.opts.Caps {
+		account, limit, err := ParseCap(c)
+		if err != nil {
+			return err
+		}
+		caps.Accounts[account] = limit
+	}
+	mod.manager = NewManager(env.DB)
+	mod.counter = NewCounter(mod.manager, caps, mod.opts.FlushInterval)
+	env.Provide(privacy.HandlerPrefix+"usage", &privacyHandler{m: mod.manager, c: mod.counter})
+	return nil
+}
+
+func (mod *usageModule) Routes() map[string]http.Handler {
+	return map[string]http.Handler{"/usage": Routes(mod.manager, mod.counter)}
+}
+
+// RetentionRules keep usage for 13 months, so a year can be compared to the previous one.
+func (mod *usageModule) RetentionRules() []retention.Rule {
+	return []retention.Rule{{Name: "days", Table: "usage", Column: "day", MaxAge: 396 * 24 * time.Hour}}
+}
+
+// Middleware counts every API request.
+func (mod *usageModule) Middleware() func(next http.Handler) http.Handler {
+	return Middleware(mod.counter)
+}
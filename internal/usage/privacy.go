@@ -0,0 +1,27 @@
+package usage
+
+import "github.com/agalitsyn/goapi/internal/privacy"
+
+// privacyHandler exports and erases usage of subject account.
+type privacyHandler struct {
+	m *Manager
+	c *Counter
+}
+
+func accountOf(s privacy.Subject) string {
+	if s.Tenant != "" {
+		return "tenant:" + s.Tenant
+	}
+	return "user:" + s.User
+}
+
+func (h *privacyHandler) Export(s privacy.Subject) (interface{}, error) {
+	return h.m.AllByAccount(accountOf(s))
+}
+
+// Erase forgets requests which are not flushed yet too, otherwise they would be written back.
+func (h *privacyHandler) Erase(s privacy.Subject) error {
+	account := accountOf(s)
+	h.c.Forget(account)
+	return h.m.DeleteAccount(account)
+}
@@ -0,0 +1,127 @@
+// Package usage accounts requests of tenants and users per route and day, and enforces monthly caps.
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/postgres"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// Usage is a number of requests of account to route during a day.
+type Usage struct {
+	Day      string `json:"day"`
+	Route    string `json:"route"`
+	Requests int64  `json:"requests"`
+}
+
+type Manager struct {
+	db postgres.Querier
+}
+
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{db: db}
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db)}
+}
+
+// ByAccount returns usage of account in [from, to] days.
+func (m *Manager) ByAccount(account string, from, to time.Time) ([]*Usage, error) {
+	return m.query(
+		"SELECT to_char(day, 'YYYY-MM-DD'), route, requests FROM usage WHERE account = $1 AND day BETWEEN $2 AND $3 ORDER BY day, route;",
+		account, from, to,
+	)
+}
+
+// AllByAccount returns all usage of account.
+func (m *Manager) AllByAccount(account string) ([]*Usage, error) {
+	return m.query("SELECT to_char(day, 'YYYY-MM-DD'), route, requests FROM usage WHERE account = $1 ORDER BY day, route;", account)
+}
+
+// DeleteAccount deletes all usage of account.
+func (m *Manager) DeleteAccount(account string) error {
+	if _, err := m.db.Exec("DELETE FROM usage WHERE account = $1;", account); err != nil {
+		return errors.Wrap(err, "could not delete usage")
+	}
+	return nil
+}
+
+func (m *Manager) query(query string, args ...interface{}) ([]*Usage, error) {
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get usage")
+	}
+	defer rows.Close()
+
+	res := []*Usage{}
+	for rows.Next() {
+		var u Usage
+		if err := rows.Scan(&u.Day, &u.Route, &u.Requests); err != nil {
+			return nil, errors.Wrap(err, "could not scan row to usage model")
+		}
+		res = append(res, &u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not get usage")
+	}
+	return res, nil
+}
+
+// Total returns number of requests of account starting from day.
+func (m *Manager) Total(account string, from time.Time) (int64, error) {
+	var total int64
+	err := m.db.QueryRow("SELECT coalesce(sum(requests), 0) FROM usage WHERE account = $1 AND day >= $2;", account, from).Scan(&total)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not get total usage")
+	}
+	return total, nil
+}
+
+type key struct {
+	account string
+	route   string
+	day     string
+}
+
+// add adds requests with a single statement.
+func (m *Manager) add(counts map[key]int64) error {
+	var accounts, routes, days []string
+	var requests []int64
+	for k, n := range counts {
+		accounts = append(accounts, k.account)
+		routes = append(routes, k.route)
+		days = append(days, k.day)
+		requests = append(requests, n)
+	}
+	_, err := m.db.Exec(
+		`INSERT INTO usage (account, route, day, requests)
+		SELECT * FROM unnest($1::varchar[], $2::varchar[], $3::date[], $4::bigint[])
+		ON CONFLICT (account, day, route) DO UPDATE SET requests = usage.requests + EXCLUDED.requests;`,
+		pq.Array(accounts), pq.Array(routes), pq.Array(days), pq.Array(requests),
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not add usage")
+	}
+	return nil
+}
+
+// Account identifies whom request is accounted to: tenant, or user when there is no tenant.
+// Anonymous requests are not accounted and empty string is returned.
+func Account(r *http.Request) string {
+	if t := reqctx.GetTenant(r.Context()); t != "" {
+		return "tenant:" + t
+	}
+	if u := reqctx.GetUser(r.Context()); u != nil {
+		return "user:" + u.ID
+	}
+	return ""
+}
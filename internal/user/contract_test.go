@@ -0,0 +1,105 @@
+package user
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/contract"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestContract(t *testing.T) {
+	created := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ann := &reqctx.User{ID: "1"}
+
+	tests := []struct {
+		name   string
+		user   *reqctx.User
+		method string
+		url    string
+		body   string
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name: "register", method: http.MethodPost, url: "/users",
+			body: `{"email": "Ann@Example.com", "password": "correct horse"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("INSERT INTO users(.+)").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, created))
+			},
+		},
+		{
+			name: "register_conflict", method: http.MethodPost, url: "/users",
+			body: `{"email": "ann@example.com", "password": "correct horse"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("INSERT INTO users(.+)").WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}))
+			},
+		},
+		{
+			name: "register_invalid", method: http.MethodPost, url: "/users",
+			body: `{"email": "Ann <ann@example.com>", "password": "correct horse"}`,
+		},
+		{
+			name: "me", user: ann, method: http.MethodGet, url: "/users/me",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1;").
+					WillReturnRows(sqlmock.NewRows(getColumns).AddRow(1, "ann@example.com", "{editor}", false, true, created))
+			},
+		},
+		{
+			name: "me_unauthorized", method: http.MethodGet, url: "/users/me",
+		},
+		{
+			name: "token", method: http.MethodPost, url: "/auth/token",
+			body: `{"grant_type": "password", "email": "ann@example.com", "password": "correct horse"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1 AND active;").
+					WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, "ann@example.com", hash, "{editor}", false, true, created))
+				mock.ExpectBegin()
+				mock.ExpectQuery("INSERT INTO refresh_token_family(.+) RETURNING id;").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+				mock.ExpectExec("INSERT INTO refresh_token(.+)").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "token_invalid_credentials", method: http.MethodPost, url: "/auth/token",
+			body: `{"grant_type": "password", "email": "bob@example.com", "password": "correct horse"}`,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1 AND active;").WillReturnRows(sqlmock.NewRows(userColumns))
+			},
+		},
+		{
+			name: "token_unsupported_grant", method: http.MethodPost, url: "/auth/token",
+			body: `{"grant_type": "implicit"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, mock, _, done := newTestRouter(t, tt.user)
+			defer done()
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+
+			req := httptest.NewRequest(tt.method, "http://example.com"+tt.url, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			// access tokens are signed with current time
+			contract.Check(t, i18n.Middleware("en")(r), "user/"+tt.name, req, contract.Mask("access_token"))
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package user
+
+import (
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// Actions of events.
+const (
+	ActionLoginFailed = "login_failed"
+	ActionLocked      = "locked"
+	ActionUnlocked    = "unlocked"
+	ActionVerified    = "email_verified"
+	// Actions of provisioning, e.g. by identity provider over SCIM.
+	ActionProvisioned = "provisioned"
+	ActionUpdated     = "updated"
+	ActionDeactivated = "deactivated"
+	ActionReactivated = "reactivated"
+	ActionDeleted     = "deleted"
+	ActionRoleGranted = "role_granted"
+	ActionRoleRevoked = "role_revoked"
+)
+
+// EventHandlerPrefix is a prefix of names modules provide EventHandler with, e.g. user.event_handler.audit.
+const EventHandlerPrefix = "user.event_handler."
+
+// Event is a security-relevant action on account, e.g. for audit.
+type Event struct {
+	Action string
+	// UserID is empty when email is not registered.
+	UserID string
+	Email  string
+	// ActorID is the user who made the action, e.g. admin who unlocked account, empty for anonymous requests.
+	ActorID   string
+	IP        string
+	RequestID string
+	// Detail tells more about action, e.g. role granted.
+	Detail string
+}
+
+// EventHandler is called after action is done.
+type EventHandler func(e Event) error
+
+// events are handlers by name, they are called in order of names.
+type events map[string]EventHandler
+
+// emit passes event to handlers, failures are logged since action is done anyway.
+func (ev events) emit(r *http.Request, e Event) {
+	if len(ev) == 0 {
+		return
+	}
+	if u := reqctx.GetUser(r.Context()); u != nil {
+		e.ActorID = u.ID
+	}
+	e.IP = clientIP(r)
+	e.RequestID = reqctx.GetRequestID(r.Context())
+	names := make([]string, 0, len(ev))
+	for name := range ev {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := ev[name](e); err != nil {
+			log.GetLogEntry(r).WithField("context", "user").WithField("handler", name).WithError(err).Errorf("could not handle %s event", e.Action)
+		}
+	}
+}
+
+// Emit passes event of request to handlers, for modules which act on accounts, e.g. provisioning.
+func Emit(r *http.Request, handlers map[string]EventHandler, e Event) {
+	events(handlers).emit(r, e)
+}
+
+// clientIP is address of client, see pkg/realip for clients behind proxies.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,832 @@
+package user
+
+import (
+	"net/http"
+	"net/mail"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/jwtauth"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/ratelimit"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/serializer"
+	"github.com/agalitsyn/goapi/pkg/totp"
+)
+
+// GrantPassword exchanges email and password for tokens.
+const GrantPassword = "password"
+
+// OTPLimitPrefix is prefix of rate limit rule of one-time password attempts, they are counted per user.
+const OTPLimitPrefix = "totp"
+
+// VerifyLimitPrefix is prefix of rate limit rule of verification emails sent again, they are counted per email.
+const VerifyLimitPrefix = "verify"
+
+// Config of user endpoints.
+type Config struct {
+	// Issuer is nil unless service issues tokens, token endpoints are not served then.
+	Issuer *jwtauth.Issuer
+	// RefreshTTL is how long refresh token is valid, every refresh extends its family that long.
+	RefreshTTL time.Duration
+	TOTP       TOTPConfig
+	// Lockout is nil if logins are not throttled.
+	Lockout *Lockout
+	// Events are handlers of events by name, see EventHandlerPrefix.
+	Events map[string]EventHandler
+	// Passwords is policy of new passwords, Breaches is nil unless they are checked against known breaches.
+	Passwords    PasswordPolicy
+	Breaches     *BreachChecker
+	Verification VerificationConfig
+	// Provider is nil unless passwords are checked by external directory, e.g. LDAPProvider. Users neither
+	// register nor change passwords with service then.
+	Provider Provider
+	// ProviderSessionTTL bounds sessions of provider users since login unless it is 0, roles are synced
+	// with provider on login only, so roles revoked there are dropped when session ends.
+	ProviderSessionTTL time.Duration
+}
+
+// sessionTTL returns how long refresh token family lasts since login, 0 means every refresh extends it.
+func (cfg Config) sessionTTL() time.Duration {
+	if cfg.Provider == nil {
+		return 0
+	}
+	return cfg.ProviderSessionTTL
+}
+
+// TOTPConfig of two-factor authentication.
+type TOTPConfig struct {
+	// Issuer names the service in authenticator apps.
+	Issuer string
+	// Roles are granted only to users who signed in with the second factor.
+	Roles []string
+	// Limiter limits attempts to verify one-time passwords with rule of OTPLimitPrefix.
+	Limiter *ratelimit.Limiter
+}
+
+// VerificationConfig of email verification.
+type VerificationConfig struct {
+	// Required rejects logins of users who have not verified email.
+	Required bool
+	// TTL is how long the link sent to user is valid.
+	TTL time.Duration
+	// URL is a template of the link, {token} is replaced.
+	URL string
+	// Mailer is nil unless emails are sent, emails are not verified then.
+	Mailer Mailer
+	// Limiter limits emails sent again with rule of VerifyLimitPrefix.
+	Limiter *ratelimit.Limiter
+}
+
+// Routes serve registration and the current user. Registration and password changes are not served
+// when passwords are checked by provider. Two-factor authentication is served only when manager has
+// encryption keys, as TOTP secrets are stored encrypted.
+func Routes(m *Manager, cfg Config) chi.Router {
+	r := chi.NewRouter()
+	if cfg.Provider == nil {
+		r.Post("/", makeHandler(m, registerHandler(cfg)))
+		r.Post("/me/password", makeHandler(m, changePasswordHandler(cfg)))
+	}
+	r.Get("/me", makeHandler(m, meHandler))
+	if m.keys != nil {
+		r.Post("/me/totp", makeHandler(m, enrollTOTPHandler(cfg.TOTP)))
+		r.Post("/me/totp/confirm", makeHandler(m, confirmTOTPHandler(cfg.TOTP)))
+		r.Delete("/me/totp", makeHandler(m, disableTOTPHandler(cfg.TOTP)))
+	}
+	if cfg.Lockout != nil {
+		r.Delete("/{id}/lockout", makeHandler(m, unlockHandler(cfg)))
+	}
+	return r
+}
+
+// AuthRoutes serve token endpoints when service issues tokens and email verification when emails are sent,
+// responses are never cached.
+func AuthRoutes(m *Manager, cfg Config) chi.Router {
+	r := chi.NewRouter()
+	r.Use(noStore)
+	if cfg.Issuer != nil {
+		r.Post("/token", makeHandler(m, tokenHandler(cfg)))
+		r.Post("/refresh", makeHandler(m, refreshHandler(cfg)))
+	}
+	if cfg.Verification.Mailer != nil {
+		// GET serves links opened from emails as they are
+		r.Get("/verify", makeHandler(m, verifyHandler(cfg)))
+		r.Post("/verify", makeHandler(m, verifyHandler(cfg)))
+		r.Post("/verify/resend", makeHandler(m, resendHandler(cfg)))
+	}
+	return r
+}
+
+type handlerFunc func(m *Manager, w http.ResponseWriter, r *http.Request)
+
+func makeHandler(m *Manager, handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(m.WithContext(r.Context()), w, r)
+	}
+}
+
+func noStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// registerHandler creates user as {"email": "ann@example.com", "password": "..."}.
+func registerHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "user")
+
+		var data struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}
+		if err := serializer.Decode(r, &data); err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if addr, err := mail.ParseAddress(data.Email); err != nil || addr.Name != "" {
+			err := i18n.Errorf("user.invalid_email", data.Email)
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if !checkPassword(cfg, w, r, data.Password) {
+			return
+		}
+
+		u, err := m.Register(data.Email, data.Password)
+		if err == ErrEmailTaken {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrConflict(err))
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		logger.WithField("user", u.ID).Info("user registered")
+		if cfg.Verification.Mailer != nil {
+			// user may ask to send it again
+			if err := sendVerification(cfg.Verification, m, r, u); err != nil {
+				logger.WithError(err).WithField("user", u.ID).Error("could not send verification email")
+			}
+		}
+		render.Status(r, http.StatusCreated)
+		render.Render(w, r, &userResponse{u})
+	}
+}
+
+// changePasswordHandler changes password of the current user as {"current_password": "...", "password": "..."}.
+// Wrong current passwords count as failed logins, so a stolen access token does not help to guess it.
+func changePasswordHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "user")
+
+		u := currentUser(m, w, r)
+		if u == nil {
+			return
+		}
+		var data struct {
+			CurrentPassword string `json:"current_password"`
+			Password        string `json:"password"`
+		}
+		if err := serializer.Decode(r, &data); err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if !allowLogin(cfg, w, r, u.Email) || !checkPassword(cfg, w, r, data.Password) {
+			return
+		}
+
+		err := m.ChangePassword(u.ID, data.CurrentPassword, data.Password)
+		if err == ErrInvalidCredentials {
+			err := i18n.Errorf("user.wrong_password")
+			logger.WithError(err).WithField("user", u.ID).Warn()
+			failLogin(cfg, r, u.Email)
+			render.Render(w, r, handler.ErrForbidden(err))
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		logger.WithField("user", u.ID).Info("password changed")
+		render.NoContent(w, r)
+	}
+}
+
+// checkPassword renders error and returns false when password does not satisfy policy or is breached.
+// Passwords are accepted when breaches can not be checked, so registration does not depend on the API.
+func checkPassword(cfg Config, w http.ResponseWriter, r *http.Request, password string) bool {
+	logger := log.GetLogEntry(r).WithField("context", "user")
+
+	if err := cfg.Passwords.Validate(password); err != nil {
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return false
+	}
+	if cfg.Breaches == nil {
+		return true
+	}
+	n, err := cfg.Breaches.Count(r.Context(), password)
+	if err != nil {
+		logger.WithError(err).Error()
+		return true
+	}
+	if n > 0 {
+		err := i18n.Errorf("user.password_breached")
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrBadRequest(err))
+		return false
+	}
+	return true
+}
+
+func meHandler(m *Manager, w http.ResponseWriter, r *http.Request) {
+	u := currentUser(m, w, r)
+	if u == nil {
+		return
+	}
+	render.Render(w, r, &userResponse{u})
+}
+
+// currentUser returns account of request user, or renders error and returns nil.
+func currentUser(m *Manager, w http.ResponseWriter, r *http.Request) *User {
+	logger := log.GetLogEntry(r).WithField("context", "user")
+
+	cu := reqctx.GetUser(r.Context())
+	if cu == nil {
+		err := i18n.Errorf("user.user_required")
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrUnauthorized(err))
+		return nil
+	}
+	u, err := m.Get(cu.ID)
+	if err == ErrNotFound {
+		// users of external identity provider have no account
+		err := i18n.Errorf("user.not_found", cu.ID)
+		logger.WithError(err).Warn()
+		render.Render(w, r, handler.ErrNotFound(err))
+		return nil
+	}
+	if err != nil {
+		logger.WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return nil
+	}
+	return u
+}
+
+// enrollTOTPHandler generates TOTP secret of the current user and responds with it and provisioning URI
+// to show as QR code. TOTP is enabled once user confirms it with a code.
+func enrollTOTPHandler(cfg TOTPConfig) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "user")
+
+		u := currentUser(m, w, r)
+		if u == nil {
+			return
+		}
+		secret, err := m.EnrollTOTP(u.ID)
+		if err == ErrTOTPEnabled {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrConflict(err))
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		render.Render(w, r, &enrollmentResponse{Secret: secret, URI: totp.URI(cfg.Issuer, u.Email, secret)})
+	}
+}
+
+// confirmTOTPHandler enables TOTP of the current user with {"code": "123456"} and responds with backup codes,
+// they are shown only once.
+func confirmTOTPHandler(cfg TOTPConfig) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "user")
+
+		u := currentUser(m, w, r)
+		if u == nil {
+			return
+		}
+		var data struct {
+			Code string `json:"code"`
+		}
+		if err := serializer.Decode(r, &data); err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if !allowOTP(cfg, w, r, u.ID) {
+			return
+		}
+
+		codes, err := m.ConfirmTOTP(u.ID, data.Code)
+		switch {
+		case err == ErrInvalidOTP:
+			logger.WithError(err).WithField("user", u.ID).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		case err == ErrTOTPEnabled || err == ErrTOTPNotEnrolled:
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrConflict(err))
+			return
+		case err != nil:
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		logger.WithField("user", u.ID).Info("totp enabled")
+		w.Header().Set("Cache-Control", "no-store")
+		render.Render(w, r, &backupCodesResponse{codes})
+	}
+}
+
+// disableTOTPHandler disables TOTP of the current user with {"code": "..."} of authenticator app or a backup code.
+func disableTOTPHandler(cfg TOTPConfig) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "user")
+
+		u := currentUser(m, w, r)
+		if u == nil {
+			return
+		}
+		var data struct {
+			Code string `json:"code"`
+		}
+		if err := serializer.Decode(r, &data); err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if !allowOTP(cfg, w, r, u.ID) {
+			return
+		}
+
+		err := m.DisableTOTP(u.ID, data.Code)
+		switch {
+		case err == ErrInvalidOTP:
+			logger.WithError(err).WithField("user", u.ID).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		case err == ErrTOTPNotEnrolled:
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrConflict(err))
+			return
+		case err != nil:
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		logger.WithField("user", u.ID).Info("totp disabled")
+		render.NoContent(w, r)
+	}
+}
+
+// allowOTP counts attempt of user to verify one-time password, or renders error when user has too many.
+func allowOTP(cfg TOTPConfig, w http.ResponseWriter, r *http.Request, userID string) bool {
+	return allow(cfg.Limiter, OTPLimitPrefix, userID, "user.otp_rate_limited", w, r)
+}
+
+// allow counts attempt of client with rule of prefix, or renders error with message of key when client has too many.
+func allow(l *ratelimit.Limiter, prefix, client, key string, w http.ResponseWriter, r *http.Request) bool {
+	res := l.Allow(prefix, client)
+	if res == nil || !res.Exceeded || !res.Enforced {
+		return true
+	}
+	retryAfter := int(time.Until(res.Reset).Seconds() + 0.5)
+	err := i18n.Errorf(key, retryAfter)
+	log.GetLogEntry(r).WithField("context", "user").WithError(err).WithField("client", client).Warn()
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	render.Render(w, r, handler.ErrTooManyRequests(err))
+	return false
+}
+
+// tokenHandler exchanges {"grant_type": "password", "email": "...", "password": "...", "otp": "..."} for access
+// and refresh tokens, grant_type may be omitted. otp is a code of authenticator app or a backup code,
+// it is required from users with TOTP enabled. Logins of locked accounts and addresses are rejected
+// before password is checked, logins of unverified users after it when verified emails are required.
+// When passwords are checked by provider, login may be sent as username instead of email.
+func tokenHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "user")
+
+		var data struct {
+			GrantType string `json:"grant_type"`
+			Email     string `json:"email"`
+			Username  string `json:"username"`
+			Password  string `json:"password"`
+			OTP       string `json:"otp"`
+		}
+		if err := serializer.Decode(r, &data); err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if data.GrantType != "" && data.GrantType != GrantPassword {
+			err := i18n.Errorf("user.unsupported_grant", data.GrantType)
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+
+		login := data.Email
+		if cfg.Provider != nil && data.Username != "" {
+			login = data.Username
+		}
+		if !allowLogin(cfg, w, r, login) {
+			return
+		}
+
+		var u *User
+		var err error
+		if cfg.Provider != nil {
+			u, err = authenticateProvider(cfg, m, r, login, data.Password)
+		} else {
+			u, err = m.Authenticate(login, data.Password)
+		}
+		if err == ErrInvalidCredentials {
+			logger.WithError(err).Warn()
+			failLogin(cfg, r, login)
+			render.Render(w, r, handler.ErrUnauthorized(err))
+			return
+		}
+		if err == ErrNoEmail {
+			logger.WithError(err).Warnf("login %s has no email in directory", login)
+			render.Render(w, r, handler.ErrForbidden(err))
+			return
+		}
+		if err == ErrLocalAccount {
+			logger.WithError(err).Warnf("email of login %s belongs to local account", login)
+			render.Render(w, r, handler.ErrForbidden(err))
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+
+		if cfg.Verification.Required && !u.Verified {
+			logger.WithError(ErrEmailNotVerified).WithField("user", u.ID).Warn()
+			render.Render(w, r, handler.ErrForbidden(ErrEmailNotVerified))
+			return
+		}
+
+		var mfa bool
+		if u.TOTP {
+			if data.OTP == "" {
+				logger.WithError(ErrOTPRequired).Info()
+				render.Render(w, r, handler.ErrUnauthorized(ErrOTPRequired))
+				return
+			}
+			if !allowOTP(cfg.TOTP, w, r, u.ID) {
+				return
+			}
+			err := m.VerifyOTP(u.ID, data.OTP)
+			if err == ErrInvalidOTP {
+				logger.WithError(err).WithField("user", u.ID).Warn()
+				render.Render(w, r, handler.ErrUnauthorized(err))
+				return
+			}
+			if err != nil {
+				logger.WithError(err).Error()
+				render.Render(w, r, handler.ErrUnknown(err))
+				return
+			}
+			mfa = true
+		}
+
+		ttl := cfg.RefreshTTL
+		if max := cfg.sessionTTL(); max > 0 && max < ttl {
+			ttl = max
+		}
+		refresh, err := m.StartFamily(u.ID, mfa, ttl)
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		if cfg.Lockout != nil {
+			if err := cfg.Lockout.Unlock(login); err != nil {
+				logger.WithError(err).Error("could not reset login attempts")
+			}
+		}
+		respondTokens(w, r, cfg, u, mfa, refresh)
+	}
+}
+
+// authenticateProvider checks password with provider and returns account of user, which is created
+// on the first login and updated on every one, e.g. when roles change in directory.
+func authenticateProvider(cfg Config, m *Manager, r *http.Request, login, password string) (*User, error) {
+	id, err := cfg.Provider.Authenticate(r.Context(), login, password)
+	if err != nil {
+		return nil, err
+	}
+	u, created, err := m.Sync(id)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		log.GetLogEntry(r).WithField("context", "user").WithField("user", u.ID).Info("user provisioned on login")
+		events(cfg.Events).emit(r, Event{Action: ActionProvisioned, UserID: u.ID, Email: u.Email})
+	}
+	return u, nil
+}
+
+// refreshHandler exchanges {"refresh_token": "..."} for new access and refresh tokens, the used refresh
+// token is not valid anymore.
+func refreshHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "user")
+
+		var data struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := serializer.Decode(r, &data); err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+
+		f, refresh, err := m.Rotate(data.RefreshToken, cfg.RefreshTTL, cfg.sessionTTL())
+		switch {
+		case err == ErrRefreshReused:
+			logger.WithError(err).WithField("user", f.UserID).Warn("refresh token family is revoked")
+			render.Render(w, r, handler.ErrUnauthorized(err))
+			return
+		case err == ErrInvalidRefresh:
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrUnauthorized(err))
+			return
+		case err != nil:
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		// roles may change between refreshes
+		u, err := m.Get(f.UserID)
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		respondTokens(w, r, cfg, u, f.MFA, refresh)
+	}
+}
+
+func respondTokens(w http.ResponseWriter, r *http.Request, cfg Config, u *User, mfa bool, refresh string) {
+	access, _, err := cfg.Issuer.Issue(jwtauth.Claims{Subject: u.ID, Roles: grantedRoles(u.Roles, mfa, cfg.TOTP.Roles)})
+	if err != nil {
+		log.GetLogEntry(r).WithField("context", "user").WithError(err).Error()
+		render.Render(w, r, handler.ErrUnknown(err))
+		return
+	}
+	render.Render(w, r, &tokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(cfg.Issuer.TTL() / time.Second),
+		RefreshToken: refresh,
+	})
+}
+
+// allowLogin renders error and returns false when login of email or address of client is locked.
+// Logins are allowed when lockouts can not be checked, so store failures do not lock everyone out.
+func allowLogin(cfg Config, w http.ResponseWriter, r *http.Request, email string) bool {
+	if cfg.Lockout == nil {
+		return true
+	}
+	logger := log.GetLogEntry(r).WithField("context", "user")
+
+	locked, err := cfg.Lockout.Locked(email, clientIP(r))
+	if err != nil {
+		logger.WithError(err).Error("could not check lockout")
+		return true
+	}
+	if locked <= 0 {
+		return true
+	}
+	retryAfter := int(locked.Seconds() + 0.5)
+	err = i18n.Errorf("user.login_locked", retryAfter)
+	logger.WithError(err).WithField("ip", clientIP(r)).Warn()
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	render.Render(w, r, handler.ErrTooManyRequests(err))
+	return false
+}
+
+// failLogin counts failed login of email and emits events of failure and of lockout, if it happens.
+func failLogin(cfg Config, r *http.Request, email string) {
+	ev := events(cfg.Events)
+	ev.emit(r, Event{Action: ActionLoginFailed, Email: NormalizeEmail(email)})
+	if cfg.Lockout == nil {
+		return
+	}
+	locked, err := cfg.Lockout.Fail(email, clientIP(r))
+	if err != nil {
+		log.GetLogEntry(r).WithField("context", "user").WithError(err).Error("could not count login attempt")
+		return
+	}
+	if locked {
+		log.GetLogEntry(r).WithField("context", "user").WithField("ip", clientIP(r)).Warnf("account %s is locked", NormalizeEmail(email))
+		ev.emit(r, Event{Action: ActionLocked, Email: NormalizeEmail(email)})
+	}
+}
+
+// unlockHandler forgets failed logins of user, so user may sign in again before lockout ends. Only admins
+// may unlock accounts.
+func unlockHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "user")
+
+		admin := reqctx.GetUser(r.Context())
+		if admin == nil {
+			err := i18n.Errorf("user.user_required")
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrUnauthorized(err))
+			return
+		}
+		if !admin.HasRole(reqctx.AdminRole) {
+			err := i18n.Errorf("user.admin_required")
+			logger.WithError(err).WithField("user", admin.ID).Warn()
+			render.Render(w, r, handler.ErrForbidden(err))
+			return
+		}
+
+		id := chi.URLParam(r, "id")
+		u, err := m.Get(id)
+		if err == ErrNotFound {
+			err := i18n.Errorf("user.not_found", id)
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrNotFound(err))
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		if err := cfg.Lockout.Unlock(u.Email); err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		logger.WithField("user", admin.ID).Infof("user %s is unlocked", u.ID)
+		events(cfg.Events).emit(r, Event{Action: ActionUnlocked, UserID: u.ID, Email: u.Email})
+		render.NoContent(w, r)
+	}
+}
+
+// verifyHandler verifies email with token of the link sent to user, as ?token=... or {"token": "..."}.
+func verifyHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "user")
+
+		data := struct {
+			Token string `json:"token"`
+		}{Token: r.URL.Query().Get("token")}
+		if r.Method == http.MethodPost {
+			if err := serializer.Decode(r, &data); err != nil {
+				logger.WithError(err).Warn()
+				render.Render(w, r, handler.ErrBadRequest(err))
+				return
+			}
+		}
+
+		userID, err := m.Verify(data.Token)
+		if err == ErrInvalidVerification {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		}
+		logger.WithField("user", userID).Info("email verified")
+		events(cfg.Events).emit(r, Event{Action: ActionVerified, UserID: userID})
+		render.NoContent(w, r)
+	}
+}
+
+// resendHandler sends verification email again as {"email": "..."}. It responds alike whether email
+// is registered or not, so it does not tell which emails are.
+func resendHandler(cfg Config) handlerFunc {
+	return func(m *Manager, w http.ResponseWriter, r *http.Request) {
+		logger := log.GetLogEntry(r).WithField("context", "user")
+
+		var data struct {
+			Email string `json:"email"`
+		}
+		if err := serializer.Decode(r, &data); err != nil {
+			logger.WithError(err).Warn()
+			render.Render(w, r, handler.ErrBadRequest(err))
+			return
+		}
+		if !allow(cfg.Verification.Limiter, VerifyLimitPrefix, NormalizeEmail(data.Email), "user.verification_rate_limited", w, r) {
+			return
+		}
+
+		u, err := m.GetByEmail(data.Email)
+		switch {
+		case err == ErrNotFound:
+			logger.Infof("verification of unknown email %s is requested", NormalizeEmail(data.Email))
+		case err != nil:
+			logger.WithError(err).Error()
+			render.Render(w, r, handler.ErrUnknown(err))
+			return
+		case u.Verified:
+			logger.WithField("user", u.ID).Info("verification of verified email is requested")
+		default:
+			if err := sendVerification(cfg.Verification, m, r, u); err != nil {
+				logger.WithError(err).WithField("user", u.ID).Error("could not send verification email")
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// sendVerification sends the link verifying email of user in the language of request.
+func sendVerification(cfg VerificationConfig, m *Manager, r *http.Request, u *User) error {
+	token, err := m.StartVerification(u.ID, cfg.TTL)
+	if err != nil {
+		return err
+	}
+	subject := i18n.T(r, "user.verification_subject")
+	body := i18n.T(r, "user.verification_body", verificationLink(cfg.URL, token), int(cfg.TTL.Hours()))
+	return cfg.Mailer.SendMail(r.Context(), u.Email, subject, body)
+}
+
+// grantedRoles drops roles which require the second factor unless user signed in with it.
+func grantedRoles(roles []string, mfa bool, mfaRoles []string) []string {
+	if mfa || len(mfaRoles) == 0 {
+		return roles
+	}
+	granted := make([]string, 0, len(roles))
+	for _, role := range roles {
+		required := false
+		for _, r := range mfaRoles {
+			if role == r {
+				required = true
+				break
+			}
+		}
+		if !required {
+			granted = append(granted, role)
+		}
+	}
+	return granted
+}
+
+type userResponse struct {
+	*User
+}
+
+func (ur *userResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type enrollmentResponse struct {
+	Secret string `json:"secret"`
+	// URI is otpauth:// URI of secret, see totp.URI.
+	URI string `json:"uri"`
+}
+
+func (er *enrollmentResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type backupCodesResponse struct {
+	BackupCodes []string `json:"backup_codes"`
+}
+
+func (br *backupCodesResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// tokenResponse is in form of OAuth2 access token response, see RFC 6749.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (tr *tokenResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
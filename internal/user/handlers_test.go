@@ -0,0 +1,982 @@
+package user
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/crypto"
+	"github.com/agalitsyn/goapi/pkg/egress"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/ids"
+	"github.com/agalitsyn/goapi/pkg/jwtauth"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/ratelimit"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+	"github.com/agalitsyn/goapi/pkg/totp"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func init() {
+	// hashes of tests need not be slow
+	hashIterations = 1000
+}
+
+var (
+	userColumns = []string{"id", "email", "password_hash", "roles", "totp_enabled", "verified", "created_at"}
+	getColumns  = []string{"id", "email", "roles", "totp_enabled", "verified", "created_at"}
+	totpColumns = []string{"totp_secret", "totp_enabled", "totp_last_step"}
+)
+
+var testKeys, _ = crypto.NewKeyring([]crypto.Key{{ID: "k1", Secret: []byte("0123456789abcdef")}}, "")
+
+func withUser(u *reqctx.User) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if u != nil {
+				r = r.WithContext(reqctx.WithUser(r.Context(), u))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// testConfig requires the second factor for admin role and allows two attempts to verify it.
+func testConfig() Config {
+	return Config{
+		Issuer:     jwtauth.NewIssuer(jwtauth.IssuerConfig{Alg: jwtauth.HS256, Key: []byte("secret"), TTL: 15 * time.Minute}),
+		RefreshTTL: time.Hour,
+		Passwords:  PasswordPolicy{MinLength: 8, MinEntropy: 50},
+		TOTP: TOTPConfig{
+			Issuer:  "goapi",
+			Roles:   []string{"admin"},
+			Limiter: ratelimit.New([]ratelimit.Rule{{Prefix: OTPLimitPrefix, Limit: 2, Window: 24 * time.Hour}}, nil),
+		},
+	}
+}
+
+// newTestRouter serves requests of u with testConfig.
+func newTestRouter(t *testing.T, u *reqctx.User) (http.Handler, sqlmock.Sqlmock, *jwtauth.Verifier, func()) {
+	return newTestRouterConfig(t, u, testConfig())
+}
+
+func newTestRouterConfig(t *testing.T, u *reqctx.User, cfg Config) (http.Handler, sqlmock.Sqlmock, *jwtauth.Verifier, func()) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	m := NewManager(db)
+	m.ids = &ids.Sequence{Prefix: "rt"}
+	m.clock = clock.NewFake(time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC))
+	m.keys = testKeys
+
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Use(withUser(u))
+	r.Mount("/users", Routes(m, cfg))
+	r.Mount("/auth", AuthRoutes(m, cfg))
+	return r, mock, jwtauth.NewVerifier(jwtauth.VerifierConfig{Secret: []byte("secret")}), func() { db.Close() }
+}
+
+func post(h http.Handler, path, body string) *httptest.ResponseRecorder {
+	return do(h, http.MethodPost, path, body)
+}
+
+func do(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestRegisterHandler(t *testing.T) {
+	r, mock, _, done := newTestRouter(t, nil)
+	defer done()
+
+	mock.ExpectQuery("INSERT INTO users(.+) ON CONFLICT \\(email\\) DO NOTHING RETURNING id, created_at;").
+		WithArgs("ann@example.com", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+	mock.ExpectQuery("INSERT INTO users(.+)").
+		WithArgs("ann@example.com", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}))
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"email":"Ann@Example.com","password":"correct horse"}`, http.StatusCreated},
+		{`{"email":"ann@example.com","password":"correct horse"}`, http.StatusConflict},
+		{`{"email":"Ann <ann@example.com>","password":"correct horse"}`, http.StatusBadRequest},
+		{`{"email":"ann@example.com","password":"short"}`, http.StatusBadRequest},
+		{`{"email":"ann@example.com","password":"password1"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := post(r, "/users", tt.body); w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.body, tt.status, w.Code, w.Body)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChangePasswordHandler(t *testing.T) {
+	breached := "correct horse battery"
+	sum := sha1.Sum([]byte(breached))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/range/") || len(r.URL.Path) != len("/range/")+5 || r.Header.Get("Add-Padding") != "true" {
+			t.Errorf("unexpected request %s %v", r.URL, r.Header)
+		}
+		fmt.Fprintf(w, "0018A45C4D1DEF81644B54AB7F969B88D65:0\r\n%s:3\r\n", hash[5:])
+	}))
+	defer api.Close()
+	policy, err := egress.New(egress.Config{AllowNetworks: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig()
+	cfg.Breaches = NewBreachChecker(api.URL+"/range/", time.Second, policy)
+	r, mock, _, done := newTestRouterConfig(t, &reqctx.User{ID: "1"}, cfg)
+	defer done()
+
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	current, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectUser := func() {
+		mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1;").
+			WithArgs("1").
+			WillReturnRows(sqlmock.NewRows(getColumns).AddRow(1, "ann@example.com", "{}", false, true, now))
+	}
+	expectHash := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT password_hash FROM users WHERE id = \\$1 FOR UPDATE;").
+			WithArgs("1").
+			WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(current))
+	}
+
+	expectUser()
+	if w := post(r, "/users/me/password", `{"current_password":"correct horse","password":"`+breached+`"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("breached password: expected status %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body)
+	}
+
+	expectUser()
+	expectHash()
+	mock.ExpectRollback()
+	if w := post(r, "/users/me/password", `{"current_password":"wrong horse","password":"staple battery horse"}`); w.Code != http.StatusForbidden {
+		t.Errorf("wrong password: expected status %d, got %d: %s", http.StatusForbidden, w.Code, w.Body)
+	}
+
+	expectUser()
+	expectHash()
+	mock.ExpectExec("UPDATE users SET password_hash = \\$2 WHERE id = \\$1;").
+		WithArgs("1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE refresh_token_family SET revoked_at = \\$2 WHERE user_id = \\$1 AND revoked_at IS NULL;").
+		WithArgs("1", now).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	if w := post(r, "/users/me/password", `{"current_password":"correct horse","password":"staple battery horse"}`); w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		password string
+		min, max float64
+	}{
+		{"", 0, 0},
+		{"aaaaaaaaaa", 9.4, 9.5},
+		{"password", 37.6, 37.7},
+		{"Tr0ub4dor&3", 72, 73},
+		{"correct horse battery staple", 164, 165},
+	}
+	for _, tt := range tests {
+		if got := Entropy(tt.password); got < tt.min || got > tt.max {
+			t.Errorf("%q: expected entropy in [%v, %v], got %v", tt.password, tt.min, tt.max, got)
+		}
+	}
+}
+
+func TestTokenHandler(t *testing.T) {
+	r, mock, verifier, done := newTestRouter(t, nil)
+	defer done()
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1 AND active;").
+		WithArgs("ann@example.com").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, "ann@example.com", hash, "{editor,admin}", false, true, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO refresh_token_family(.+) RETURNING id;").
+		WithArgs("1", false, time.Date(2018, 5, 1, 13, 0, 0, 0, time.UTC)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec("INSERT INTO refresh_token(.+)").
+		WithArgs(hashToken("rt1"), "7", time.Date(2018, 5, 1, 13, 0, 0, 0, time.UTC)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := post(r, "/auth/token", `{"grant_type":"password","email":"ann@example.com","password":"correct horse"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Error("token response may be cached")
+	}
+	var resp tokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.RefreshToken != "rt1" || resp.TokenType != "Bearer" || resp.ExpiresIn != 900 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	c, err := verifier.Verify(resp.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// admin role requires the second factor
+	if c.Subject != "1" || len(c.Roles) != 1 || c.Roles[0] != "editor" || c.ID == "" {
+		t.Errorf("unexpected claims %+v", c)
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1 AND active;").
+		WithArgs("ann@example.com").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, "ann@example.com", hash, "{}", false, true, time.Now()))
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1 AND active;").
+		WithArgs("bob@example.com").
+		WillReturnRows(sqlmock.NewRows(userColumns))
+	for _, body := range []string{
+		`{"email":"ann@example.com","password":"wrong horse"}`,
+		`{"email":"bob@example.com","password":"correct horse"}`,
+	} {
+		if w := post(r, "/auth/token", body); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status %d, got %d", body, http.StatusUnauthorized, w.Code)
+		}
+	}
+	if w := post(r, "/auth/token", `{"grant_type":"implicit"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unsupported grant: expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRefreshHandler(t *testing.T) {
+	r, mock, verifier, done := newTestRouter(t, nil)
+	defer done()
+
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	familyColumns := []string{"id", "user_id", "mfa", "revoked_at", "created_at", "used_at", "expires_at"}
+	selectToken := "SELECT (.+) FROM refresh_token t JOIN refresh_token_family f (.+) WHERE t.hash = \\$1 FOR UPDATE OF f;"
+
+	// fresh token is rotated
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectToken).
+		WithArgs(hashToken("rt0")).
+		WillReturnRows(sqlmock.NewRows(familyColumns).AddRow(7, 1, true, nil, now.Add(-time.Hour), nil, now.Add(time.Minute)))
+	mock.ExpectExec("UPDATE refresh_token SET used_at = \\$2 WHERE hash = \\$1;").
+		WithArgs(hashToken("rt0"), now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE refresh_token_family SET expires_at = \\$2 WHERE id = \\$1;").
+		WithArgs("7", now.Add(time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO refresh_token(.+)").
+		WithArgs(hashToken("rt1"), "7", now.Add(time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(getColumns).AddRow(1, "ann@example.com", "{admin}", true, true, now))
+
+	w := post(r, "/auth/refresh", `{"refresh_token":"rt0"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var resp tokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.RefreshToken != "rt1" || resp.AccessToken == "" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	// family of login with the second factor keeps roles which require it
+	c, err := verifier.Verify(resp.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Roles) != 1 || c.Roles[0] != "admin" {
+		t.Errorf("unexpected roles %v", c.Roles)
+	}
+
+	// used token revokes its family, which is committed despite the error
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectToken).
+		WithArgs(hashToken("rt0")).
+		WillReturnRows(sqlmock.NewRows(familyColumns).AddRow(7, 1, false, nil, now.Add(-time.Hour), now, now.Add(time.Minute)))
+	mock.ExpectExec("UPDATE refresh_token_family SET revoked_at = \\$2 WHERE id = \\$1;").
+		WithArgs("7", now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if w := post(r, "/auth/refresh", `{"refresh_token":"rt0"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("reused token: expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	// tokens of revoked family and expired tokens are not valid
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectToken).
+		WithArgs(hashToken("rt1")).
+		WillReturnRows(sqlmock.NewRows(familyColumns).AddRow(7, 1, false, now, now.Add(-time.Hour), nil, now.Add(time.Hour)))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectToken).
+		WithArgs(hashToken("rt2")).
+		WillReturnRows(sqlmock.NewRows(familyColumns).AddRow(8, 1, false, nil, now.Add(-time.Hour), nil, now))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectToken).
+		WithArgs(hashToken("unknown")).
+		WillReturnRows(sqlmock.NewRows(familyColumns))
+	mock.ExpectRollback()
+	for _, token := range []string{"rt1", "rt2", "unknown"} {
+		if w := post(r, "/auth/refresh", `{"refresh_token":"`+token+`"}`); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status %d, got %d", token, http.StatusUnauthorized, w.Code)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTOTPHandlers(t *testing.T) {
+	r, mock, _, done := newTestRouter(t, &reqctx.User{ID: "1"})
+	defer done()
+
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	expectUser := func(enabled bool) {
+		mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1;").
+			WithArgs("1").
+			WillReturnRows(sqlmock.NewRows(getColumns).AddRow(1, "ann@example.com", "{}", enabled, true, now))
+	}
+
+	expectUser(false)
+	mock.ExpectExec("UPDATE users SET totp_secret = \\$2, totp_last_step = 0 WHERE id = \\$1 AND NOT totp_enabled;").
+		WithArgs("1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	w := post(r, "/users/me/totp", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("enroll: expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var enrollment enrollmentResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &enrollment); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/goapi:ann@example.com?") || !strings.Contains(enrollment.URI, "secret="+enrollment.Secret) {
+		t.Errorf("unexpected provisioning uri %s", enrollment.URI)
+	}
+
+	encrypted, err := testKeys.Encrypt(totpColumn, []byte(enrollment.Secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := totp.Step(now)
+	code, err := totp.Code(enrollment.Secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectUser(false)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1 FOR UPDATE;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(totpColumns).AddRow(encrypted, false, 0))
+	mock.ExpectExec("UPDATE users SET totp_enabled = true, totp_last_step = \\$2 WHERE id = \\$1;").
+		WithArgs("1", step).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM user_backup_code WHERE user_id = \\$1;").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for i := 0; i < BackupCodes; i++ {
+		mock.ExpectExec("INSERT INTO user_backup_code(.+)").
+			WithArgs("1", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+	w = post(r, "/users/me/totp/confirm", `{"code":"`+code+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm: expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var backup backupCodesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &backup); err != nil {
+		t.Fatal(err)
+	}
+	if len(backup.BackupCodes) != BackupCodes {
+		t.Errorf("expected %d backup codes, got %v", BackupCodes, backup.BackupCodes)
+	}
+
+	// the same code is not accepted again, backup code disables
+	expectUser(true)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1 FOR UPDATE;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(totpColumns).AddRow(encrypted, true, step))
+	mock.ExpectExec("UPDATE user_backup_code SET used_at = \\$3 (.+)").
+		WithArgs("1", hashToken(normalizeBackupCode(backup.BackupCodes[0])), now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET totp_secret = NULL(.+)").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM user_backup_code WHERE user_id = \\$1;").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, BackupCodes))
+	mock.ExpectCommit()
+	if w := do(r, http.MethodDelete, "/users/me/totp", `{"code":"`+strings.ToUpper(backup.BackupCodes[0])+`"}`); w.Code != http.StatusNoContent {
+		t.Errorf("disable: expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body)
+	}
+
+	// attempts over limit are rejected before verification
+	expectUser(true)
+	if w := do(r, http.MethodDelete, "/users/me/totp", `{"code":"000000"}`); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("rate limited: expected status %d with Retry-After, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTokenHandler_TOTP(t *testing.T) {
+	r, mock, verifier, done := newTestRouter(t, nil)
+	defer done()
+
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := testKeys.Encrypt(totpColumn, []byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := totp.Step(now)
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLogin := func() {
+		mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1 AND active;").
+			WithArgs("ann@example.com").
+			WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, "ann@example.com", hash, "{admin}", true, true, now))
+	}
+
+	// password alone is not enough
+	expectLogin()
+	if w := post(r, "/auth/token", `{"email":"ann@example.com","password":"correct horse"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("without otp: expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	expectLogin()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1 FOR UPDATE;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(totpColumns).AddRow(encrypted, true, step-1))
+	mock.ExpectExec("UPDATE users SET totp_last_step = \\$2 WHERE id = \\$1;").
+		WithArgs("1", step).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO refresh_token_family(.+) RETURNING id;").
+		WithArgs("1", true, now.Add(time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec("INSERT INTO refresh_token(.+)").
+		WithArgs(hashToken("rt1"), "7", now.Add(time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	w := post(r, "/auth/token", `{"email":"ann@example.com","password":"correct horse","otp":"`+code+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var resp tokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	c, err := verifier.Verify(resp.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Roles) != 1 || c.Roles[0] != "admin" {
+		t.Errorf("unexpected roles %v", c.Roles)
+	}
+
+	// replayed code is neither a valid code nor a backup code
+	expectLogin()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1 FOR UPDATE;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(totpColumns).AddRow(encrypted, true, step))
+	mock.ExpectExec("UPDATE user_backup_code SET used_at = \\$3 (.+)").
+		WithArgs("1", hashToken(code), now).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if w := post(r, "/auth/token", `{"email":"ann@example.com","password":"correct horse","otp":"`+code+`"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed otp: expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// memoryAttempts is AttemptStore of tests.
+type memoryAttempts map[string]struct {
+	failures int
+	last     time.Time
+	expires  time.Time
+}
+
+func (s memoryAttempts) Fail(key string, now time.Time, ttl time.Duration) (int, error) {
+	a := s[key]
+	if !a.expires.After(now) {
+		a.failures = 0
+	}
+	a.failures++
+	a.last, a.expires = now, now.Add(ttl)
+	s[key] = a
+	return a.failures, nil
+}
+
+func (s memoryAttempts) Get(key string, now time.Time) (int, time.Time, error) {
+	a := s[key]
+	if !a.expires.After(now) {
+		return 0, time.Time{}, nil
+	}
+	return a.failures, a.last, nil
+}
+
+func (s memoryAttempts) Reset(key string) error {
+	delete(s, key)
+	return nil
+}
+
+func TestLockout(t *testing.T) {
+	c := clock.NewFake(time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC))
+	l := NewLockout(memoryAttempts{}, LockoutConfig{
+		Attempts:    3,
+		IPAttempts:  5,
+		Duration:    time.Minute,
+		MaxDuration: 3 * time.Minute,
+		Reset:       time.Hour,
+	})
+	l.clock = c
+
+	assertLocked := func(name string, email, ip string, want time.Duration) {
+		t.Helper()
+		got, err := l.Locked(email, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: expected lockout %s, got %s", name, want, got)
+		}
+	}
+	fail := func(email, ip string, want bool) {
+		t.Helper()
+		locked, err := l.Fail(email, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != want {
+			t.Errorf("expected locked %v, got %v", want, locked)
+		}
+	}
+
+	fail("ann@example.com", "192.0.2.1", false)
+	fail("Ann@Example.com", "192.0.2.1", false)
+	assertLocked("under limit", "ann@example.com", "192.0.2.1", 0)
+	fail("ann@example.com", "192.0.2.1", true)
+	assertLocked("locked", "ann@example.com", "192.0.2.2", time.Minute)
+
+	// every failure after lockout doubles it up to max
+	c.Add(time.Minute)
+	assertLocked("lockout ended", "ann@example.com", "192.0.2.2", 0)
+	fail("ann@example.com", "192.0.2.2", true)
+	assertLocked("doubled", "ann@example.com", "192.0.2.2", 2*time.Minute)
+	c.Add(2 * time.Minute)
+	fail("ann@example.com", "192.0.2.2", true)
+	assertLocked("max", "ann@example.com", "192.0.2.2", 3*time.Minute)
+
+	// address is locked for other accounts too, unlocking account keeps it locked
+	fail("bob@example.com", "192.0.2.1", false)
+	assertLocked("address under limit", "joe@example.com", "192.0.2.1", 0)
+	fail("eve@example.com", "192.0.2.1", false)
+	assertLocked("address", "joe@example.com", "192.0.2.1", time.Minute)
+	if err := l.Unlock("ann@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	assertLocked("unlocked", "ann@example.com", "192.0.2.3", 0)
+	assertLocked("unlocked account at locked address", "ann@example.com", "192.0.2.1", time.Minute)
+}
+
+func TestTokenHandler_Lockout(t *testing.T) {
+	var recorded []Event
+	cfg := testConfig()
+	cfg.Lockout = NewLockout(memoryAttempts{}, LockoutConfig{Attempts: 2, Duration: time.Minute, MaxDuration: time.Hour, Reset: time.Hour})
+	cfg.Events = map[string]EventHandler{"test": func(e Event) error {
+		recorded = append(recorded, e)
+		return nil
+	}}
+	r, mock, _, done := newTestRouterConfig(t, nil, cfg)
+	defer done()
+
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1 AND active;").
+			WithArgs("bob@example.com").
+			WillReturnRows(sqlmock.NewRows(userColumns))
+		if w := post(r, "/auth/token", `{"email":"bob@example.com","password":"wrong horse"}`); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+		}
+	}
+	// password is not checked while locked
+	w := post(r, "/auth/token", `{"email":"Bob@example.com","password":"correct horse"}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("locked: expected status %d with Retry-After, got %d %v", http.StatusTooManyRequests, w.Code, w.Header())
+	}
+	var actions []string
+	for _, e := range recorded {
+		if e.Email != "bob@example.com" || e.IP != "192.0.2.1" {
+			t.Errorf("unexpected event %+v", e)
+		}
+		actions = append(actions, e.Action)
+	}
+	if strings.Join(actions, ",") != "login_failed,login_failed,locked" {
+		t.Errorf("unexpected events %v", actions)
+	}
+
+	recorded = nil
+	now := time.Now()
+	for _, tt := range []struct {
+		user   *reqctx.User
+		status int
+	}{
+		{&reqctx.User{ID: "2"}, http.StatusForbidden},
+		{&reqctx.User{ID: "1", Roles: []string{reqctx.AdminRole}}, http.StatusNoContent},
+	} {
+		admin, mock, _, done := newTestRouterConfig(t, tt.user, cfg)
+		defer done()
+		if tt.status == http.StatusNoContent {
+			mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1;").
+				WithArgs("3").
+				WillReturnRows(sqlmock.NewRows(getColumns).AddRow(3, "bob@example.com", "{}", false, true, now))
+		}
+		if w := do(admin, http.MethodDelete, "/users/3/lockout", ""); w.Code != tt.status {
+			t.Errorf("unlock by %+v: expected status %d, got %d", tt.user, tt.status, w.Code)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	}
+	if len(recorded) != 1 || recorded[0].Action != ActionUnlocked || recorded[0].UserID != "3" || recorded[0].ActorID != "1" {
+		t.Errorf("unexpected events %+v", recorded)
+	}
+	if locked, _ := cfg.Lockout.Locked("bob@example.com", "192.0.2.1"); locked != 0 {
+		t.Errorf("account is still locked for %s", locked)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGrantedRoles(t *testing.T) {
+	roles := []string{"editor", "admin"}
+	if got := grantedRoles(roles, false, []string{"admin"}); len(got) != 1 || got[0] != "editor" {
+		t.Errorf("unexpected roles without second factor %v", got)
+	}
+	if got := grantedRoles(roles, true, []string{"admin"}); len(got) != 2 {
+		t.Errorf("unexpected roles with second factor %v", got)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPassword(hash, "correct horse") {
+		t.Error("password does not match its hash")
+	}
+	if CheckPassword(hash, "wrong horse") || CheckPassword("", "") || CheckPassword("pbkdf2-sha256$x$y$z", "") {
+		t.Error("wrong password matches")
+	}
+	// test vector of PBKDF2-HMAC-SHA256
+	if got := pbkdf2([]byte("password"), []byte("salt"), 2, 32); hex.EncodeToString(got) != "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43" {
+		t.Errorf("unexpected key %x", got)
+	}
+}
+
+type sentMail struct {
+	address, subject, body string
+}
+
+type memoryMailer struct {
+	sent []sentMail
+}
+
+func (m *memoryMailer) SendMail(ctx context.Context, address, subject, body string) error {
+	m.sent = append(m.sent, sentMail{address, subject, body})
+	return nil
+}
+
+func TestVerification(t *testing.T) {
+	mailer := &memoryMailer{}
+	cfg := testConfig()
+	cfg.Verification = VerificationConfig{
+		Required: true,
+		TTL:      48 * time.Hour,
+		URL:      "https://example.com/verify?token={token}",
+		Mailer:   mailer,
+		Limiter:  ratelimit.New([]ratelimit.Rule{{Prefix: VerifyLimitPrefix, Limit: 1, Window: time.Hour}}, nil),
+	}
+	r, mock, _, done := newTestRouterConfig(t, nil, cfg)
+	defer done()
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("INSERT INTO users(.+)").
+		WithArgs("ann@example.com", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, now))
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM email_verification WHERE user_id = \\$1;").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO email_verification(.+)").
+		WithArgs(hashToken("rt1"), "1", now.Add(48*time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if w := post(r, "/users", `{"email":"ann@example.com","password":"correct horse"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+	if len(mailer.sent) != 1 || mailer.sent[0].address != "ann@example.com" ||
+		!strings.Contains(mailer.sent[0].body, "https://example.com/verify?token=rt1") {
+		t.Fatalf("unexpected emails %+v", mailer.sent)
+	}
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1 AND active;").
+		WithArgs("ann@example.com").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, "ann@example.com", hash, "{}", false, false, now))
+	if w := post(r, "/auth/token", `{"email":"ann@example.com","password":"correct horse"}`); w.Code != http.StatusForbidden {
+		t.Errorf("unverified: expected status %d, got %d: %s", http.StatusForbidden, w.Code, w.Body)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM email_verification WHERE hash = \\$1 RETURNING user_id, expires_at;").
+		WithArgs(hashToken("rt1")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "expires_at"}).AddRow(1, now.Add(time.Hour)))
+	mock.ExpectExec("UPDATE users SET verified_at = \\$2 WHERE id = \\$1 AND verified_at IS NULL;").
+		WithArgs("1", now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if w := do(r, http.MethodGet, "/auth/verify?token=rt1", ""); w.Code != http.StatusNoContent {
+		t.Errorf("verify: expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM email_verification(.+)").
+		WithArgs(hashToken("rt1")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "expires_at"}))
+	mock.ExpectRollback()
+	if w := post(r, "/auth/verify", `{"token":"rt1"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("used token: expected status %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body)
+	}
+
+	// unknown emails are not told from registered ones
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE email = \\$1 AND active;").
+		WithArgs("bob@example.com").
+		WillReturnRows(sqlmock.NewRows(getColumns))
+	if w := post(r, "/auth/verify/resend", `{"email":"Bob@example.com"}`); w.Code != http.StatusAccepted {
+		t.Errorf("resend: expected status %d, got %d: %s", http.StatusAccepted, w.Code, w.Body)
+	}
+	w := post(r, "/auth/verify/resend", `{"email":"bob@example.com"}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("resend again: expected status %d with Retry-After, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if len(mailer.sent) != 1 {
+		t.Errorf("unexpected emails %+v", mailer.sent)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// staticProvider knows one login with password secret.
+type staticProvider map[string]*Identity
+
+func (p staticProvider) Authenticate(ctx context.Context, login, password string) (*Identity, error) {
+	id, ok := p[login]
+	if !ok || password != "secret" {
+		return nil, ErrInvalidCredentials
+	}
+	return id, nil
+}
+
+func TestTokenHandler_Provider(t *testing.T) {
+	var recorded []Event
+	cfg := testConfig()
+	cfg.Provider = staticProvider{
+		"ann": {ExternalID: "uid=ann,dc=example,dc=com", Email: "Ann@example.com", Roles: []string{"editor"}},
+		"bob": {ExternalID: "uid=bob,dc=example,dc=com"},
+	}
+	cfg.ProviderSessionTTL = 90 * time.Minute
+	cfg.Events = map[string]EventHandler{"test": func(e Event) error {
+		recorded = append(recorded, e)
+		return nil
+	}}
+	r, mock, verifier, done := newTestRouterConfig(t, nil, cfg)
+	defer done()
+	syncColumns := []string{"id", "totp_enabled", "active", "created_at", "local"}
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	expectFamily := func(token string) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO refresh_token_family(.+) RETURNING id;").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		mock.ExpectExec("INSERT INTO refresh_token(.+)").
+			WithArgs(hashToken(token), "7", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	// the first login creates account
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE external_id = \\$1 OR email = \\$2 (.+) FOR UPDATE;").
+		WithArgs("uid=ann,dc=example,dc=com", "ann@example.com").
+		WillReturnRows(sqlmock.NewRows(syncColumns))
+	mock.ExpectQuery("INSERT INTO users(.+) RETURNING id, created_at;").
+		WithArgs("ann@example.com", "uid=ann,dc=example,dc=com", "{\"editor\"}", now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, now))
+	mock.ExpectCommit()
+	expectFamily("rt1")
+	w := post(r, "/auth/token", `{"username":"ann","password":"secret"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var resp tokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := verifier.Verify(resp.AccessToken); err != nil || c.Subject != "1" || len(c.Roles) != 1 || c.Roles[0] != "editor" {
+		t.Errorf("unexpected claims %+v %v", c, err)
+	}
+	if len(recorded) != 1 || recorded[0].Action != ActionProvisioned || recorded[0].UserID != "1" {
+		t.Errorf("unexpected events %+v", recorded)
+	}
+
+	// the next ones update it
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE external_id = \\$1 OR email = \\$2 (.+) FOR UPDATE;").
+		WithArgs("uid=ann,dc=example,dc=com", "ann@example.com").
+		WillReturnRows(sqlmock.NewRows(syncColumns).AddRow(1, false, true, now, false))
+	mock.ExpectExec("UPDATE users SET email = \\$2, external_id = \\$3, roles = \\$4(.+) WHERE id = \\$1;").
+		WithArgs("1", "ann@example.com", "uid=ann,dc=example,dc=com", "{\"editor\"}", now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectFamily("rt2")
+	if w := post(r, "/auth/token", `{"username":"ann","password":"secret"}`); w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	// deactivated accounts do not sign in
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE external_id = \\$1 OR email = \\$2 (.+) FOR UPDATE;").
+		WillReturnRows(sqlmock.NewRows(syncColumns).AddRow(1, false, false, now, false))
+	mock.ExpectRollback()
+	if w := post(r, "/auth/token", `{"email":"ann","password":"secret"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("deactivated: expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	// account with password is not taken over by directory user with the same email
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE external_id = \\$1 OR email = \\$2 (.+) FOR UPDATE;").
+		WillReturnRows(sqlmock.NewRows(syncColumns).AddRow(2, false, true, now, true))
+	mock.ExpectRollback()
+	if w := post(r, "/auth/token", `{"username":"ann","password":"secret"}`); w.Code != http.StatusForbidden {
+		t.Errorf("local account: expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	// session is not extended beyond its lifetime since login, so roles are synced again
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM refresh_token t JOIN refresh_token_family f (.+) FOR UPDATE OF f;").
+		WithArgs(hashToken("rt2")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "mfa", "revoked_at", "created_at", "used_at", "expires_at"}).
+			AddRow(7, 1, false, nil, now.Add(-time.Hour), nil, now.Add(time.Minute)))
+	mock.ExpectExec("UPDATE refresh_token SET used_at = \\$2 WHERE hash = \\$1;").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE refresh_token_family SET expires_at = \\$2 WHERE id = \\$1;").
+		WithArgs("7", now.Add(30*time.Minute)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO refresh_token(.+)").
+		WithArgs(hashToken("rt3"), "7", now.Add(30*time.Minute)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT (.+) FROM users WHERE id = \\$1;").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows(getColumns).AddRow(1, "ann@example.com", "{editor}", false, true, now))
+	if w := post(r, "/auth/refresh", `{"refresh_token":"rt2"}`); w.Code != http.StatusOK {
+		t.Errorf("refresh: expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	if w := post(r, "/auth/token", `{"username":"ann","password":"wrong"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := post(r, "/auth/token", `{"username":"bob","password":"secret"}`); w.Code != http.StatusForbidden {
+		t.Errorf("no email: expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	// users neither register nor change passwords with service
+	if w := post(r, "/users", `{"email":"eve@example.com","password":"correct horse battery"}`); w.Code != http.StatusNotFound {
+		t.Errorf("registration: expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestLDAPProvider_Roles(t *testing.T) {
+	if _, err := ParseGroupRoles([]string{"cn=admins,dc=example,dc=com"}); err == nil {
+		t.Error("mapping without role is accepted")
+	}
+	roles, err := ParseGroupRoles([]string{
+		"admin:cn=Admins, dc=example, dc=com",
+		"editor:cn=admins,dc=example,dc=com",
+		"editor:cn=editors,dc=example,dc=com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewLDAPProvider(nil, LDAPConfig{GroupRoles: roles})
+	got := p.roles([]string{"CN=Admins,DC=example,DC=com", "cn=editors,dc=example,dc=com", "cn=staff,dc=example,dc=com"})
+	if strings.Join(got, ",") != "admin,editor" {
+		t.Errorf("unexpected roles %v", got)
+	}
+	if got := p.roles(nil); got == nil || len(got) != 0 {
+		t.Errorf("unexpected roles of user without groups %v", got)
+	}
+}
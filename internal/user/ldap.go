@@ -0,0 +1,231 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/ldap"
+)
+
+// ErrNoEmail is returned when provider has no email of user, accounts are kept by email.
+var ErrNoEmail = i18n.Errorf("user.ldap_no_email")
+
+// ErrLocalAccount is returned when email of identity belongs to account with password of service, which
+// is not taken over by provider.
+var ErrLocalAccount = i18n.Errorf("user.ldap_local_account")
+
+// Identity is a user authenticated by Provider.
+type Identity struct {
+	// ExternalID identifies user in provider, e.g. DN in directory.
+	ExternalID string
+	Email      string
+	Roles      []string
+}
+
+// Provider checks passwords of users instead of service, e.g. LDAPProvider. It returns ErrInvalidCredentials
+// for unknown login and wrong password alike.
+type Provider interface {
+	Authenticate(ctx context.Context, login, password string) (*Identity, error)
+}
+
+// LDAPConfig maps entries of directory to users.
+type LDAPConfig struct {
+	// BindDN and BindPassword authenticate service to search users and groups.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds entry of user, {login} is replaced with escaped login, e.g. (uid={login}).
+	UserFilter     string
+	EmailAttribute string
+	// GroupAttribute of user entry lists DNs of groups, e.g. memberOf. It is used unless GroupFilter is set.
+	GroupAttribute string
+	// GroupFilter finds groups of user under BaseDN, {dn} is replaced with escaped DN of user,
+	// e.g. (member={dn}), for directories without memberOf.
+	GroupFilter string
+	// GroupRoles are roles granted to members of group by DN, see ParseGroupRoles.
+	GroupRoles map[string][]string
+}
+
+// LDAPProvider authenticates users with bind to directory as them.
+type LDAPProvider struct {
+	client *ldap.Client
+	cfg    LDAPConfig
+}
+
+func NewLDAPProvider(client *ldap.Client, cfg LDAPConfig) *LDAPProvider {
+	roles := make(map[string][]string, len(cfg.GroupRoles))
+	for dn, r := range cfg.GroupRoles {
+		roles[normalizeDN(dn)] = r
+	}
+	cfg.GroupRoles = roles
+	return &LDAPProvider{client: client, cfg: cfg}
+}
+
+// ParseGroupRoles parses mappings in form role:group DN, e.g. admin:cn=admins,ou=groups,dc=example,dc=com.
+// A group may grant several roles.
+func ParseGroupRoles(mappings []string) (map[string][]string, error) {
+	roles := make(map[string][]string, len(mappings))
+	for _, m := range mappings {
+		parts := strings.SplitN(m, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, errors.Errorf("invalid group role %q, expected role:group DN", m)
+		}
+		dn := normalizeDN(parts[1])
+		roles[dn] = append(roles[dn], parts[0])
+	}
+	return roles, nil
+}
+
+// normalizeDN lowercases DN and trims spaces around its components, so DNs written differently match.
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	return strings.ToLower(strings.Join(parts, ","))
+}
+
+// Authenticate finds entry of login as service, collects its email and groups, then binds as the entry
+// with password. Connections are bound as service again before they are reused.
+func (p *LDAPProvider) Authenticate(ctx context.Context, login, password string) (*Identity, error) {
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	var id *Identity
+	err := p.client.Do(ctx, func(cn *ldap.Conn) error {
+		if err := cn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+			return errors.Wrap(err, "could not bind to ldap as service")
+		}
+		attrs := []string{p.cfg.EmailAttribute}
+		if p.cfg.GroupFilter == "" {
+			attrs = append(attrs, p.cfg.GroupAttribute)
+		}
+		entries, err := cn.Search(ldap.SearchRequest{
+			BaseDN:     p.cfg.BaseDN,
+			Scope:      ldap.ScopeSub,
+			Filter:     strings.Replace(p.cfg.UserFilter, "{login}", ldap.EscapeFilter(login), -1),
+			Attributes: attrs,
+			SizeLimit:  2,
+		})
+		if ldap.IsCode(err, ldap.ResultSizeLimitExceeded) || len(entries) > 1 {
+			return errors.Errorf("ldap user filter matches several entries of login %q", login)
+		}
+		if err != nil {
+			return errors.Wrap(err, "could not search ldap user")
+		}
+		// unknown login and wrong password leave id nil, connection is fine to reuse then
+		if len(entries) == 0 {
+			return nil
+		}
+		entry := entries[0]
+
+		var groups []string
+		if p.cfg.GroupFilter == "" {
+			groups = entry.Values(p.cfg.GroupAttribute)
+		} else {
+			entries, err := cn.Search(ldap.SearchRequest{
+				BaseDN: p.cfg.BaseDN,
+				Scope:  ldap.ScopeSub,
+				Filter: strings.Replace(p.cfg.GroupFilter, "{dn}", ldap.EscapeFilter(entry.DN), -1),
+				// no attributes, see RFC 4511 section 4.5.1.8
+				Attributes: []string{"1.1"},
+			})
+			if err != nil {
+				return errors.Wrap(err, "could not search ldap groups")
+			}
+			for _, e := range entries {
+				groups = append(groups, e.DN)
+			}
+		}
+
+		err = cn.Bind(entry.DN, password)
+		if ldap.IsCode(err, ldap.ResultInvalidCredentials) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "could not bind to ldap as user")
+		}
+		id = &Identity{ExternalID: entry.DN, Email: entry.Value(p.cfg.EmailAttribute), Roles: p.roles(groups)}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if id == nil {
+		return nil, ErrInvalidCredentials
+	}
+	return id, nil
+}
+
+// roles returns sorted roles granted to members of groups.
+func (p *LDAPProvider) roles(groups []string) []string {
+	seen := make(map[string]bool)
+	roles := []string{}
+	for _, g := range groups {
+		for _, role := range p.cfg.GroupRoles[normalizeDN(g)] {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Sync creates or updates account of identity authenticated by provider and returns it, created tells
+// whether account is new. Account is found by external id, or by email when identity moved in provider,
+// its email and roles are replaced with ones of provider. Accounts with password of service are not
+// linked by email, so whoever controls email in provider does not take them over. Deactivated accounts
+// do not sign in, identities without email have no account.
+func (m *Manager) Sync(id *Identity) (u *User, created bool, err error) {
+	if id.Email == "" {
+		return nil, false, ErrNoEmail
+	}
+	u = &User{Email: NormalizeEmail(id.Email), Roles: id.Roles, Verified: true}
+	err = m.Tx(false, func(m *Manager) error {
+		var active, local bool
+		// external_id of accounts without it is NULL, which must not sort first
+		err := m.db.QueryRow(
+			`SELECT id, totp_enabled, active, created_at, external_id IS NULL AND password_hash <> '' FROM users
+			WHERE external_id = $1 OR email = $2 ORDER BY external_id = $1 DESC NULLS LAST LIMIT 1 FOR UPDATE;`,
+			id.ExternalID, u.Email,
+		).Scan(&u.ID, &u.TOTP, &active, &u.CreatedAt, &local)
+		if err == sql.ErrNoRows {
+			created = true
+			// accounts of provider have no password
+			err := m.db.QueryRow(
+				`INSERT INTO users(email, password_hash, external_id, roles, verified_at) VALUES ($1, '', $2, $3, $4)
+				RETURNING id, created_at;`,
+				u.Email, id.ExternalID, pq.Array(u.Roles), m.clock.Now(),
+			).Scan(&u.ID, &u.CreatedAt)
+			return errors.Wrap(err, "could not create user")
+		}
+		if err != nil {
+			return errors.Wrap(err, "could not get user")
+		}
+		if !active {
+			return ErrInvalidCredentials
+		}
+		if local {
+			return ErrLocalAccount
+		}
+		if _, err := m.db.Exec(
+			"UPDATE users SET email = $2, external_id = $3, roles = $4, verified_at = COALESCE(verified_at, $5) WHERE id = $1;",
+			u.ID, u.Email, id.ExternalID, pq.Array(u.Roles), m.clock.Now(),
+		); err != nil {
+			return errors.Wrap(err, "could not update user")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return u, created, nil
+}
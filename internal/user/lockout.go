@@ -0,0 +1,229 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+	"github.com/agalitsyn/goapi/pkg/redis"
+)
+
+// AttemptStore counts failed login attempts by key, e.g. account:ann@example.com or ip:192.0.2.1.
+type AttemptStore interface {
+	// Fail counts failed attempt of key at now and returns number of failures, they are forgotten
+	// after ttl without new ones.
+	Fail(key string, now time.Time, ttl time.Duration) (int, error)
+	// Get returns number of failures of key and time of the last one, 0 if they are forgotten.
+	Get(key string, now time.Time) (int, time.Time, error)
+	// Reset forgets failures of key.
+	Reset(key string) error
+}
+
+// LockoutConfig of brute-force protection, zero attempts do not lock.
+type LockoutConfig struct {
+	// Attempts is how many failures lock account, they are counted for unknown emails too,
+	// so responses do not tell which emails are registered.
+	Attempts int
+	// IPAttempts is how many failures lock client address, it is not reset by successful logins.
+	IPAttempts int
+	// Duration is how long the first lockout lasts, every further failure doubles it up to MaxDuration.
+	Duration    time.Duration
+	MaxDuration time.Duration
+	// Reset is how long failures are kept without new ones.
+	Reset time.Duration
+}
+
+// Lockout locks accounts and client addresses with too many failed logins for exponentially growing time.
+type Lockout struct {
+	store AttemptStore
+	cfg   LockoutConfig
+	clock clock.Clock
+}
+
+func NewLockout(store AttemptStore, cfg LockoutConfig) *Lockout {
+	return &Lockout{store: store, cfg: cfg, clock: clock.Real}
+}
+
+func accountKey(email string) string { return "account:" + NormalizeEmail(email) }
+func ipKey(ip string) string         { return "ip:" + ip }
+
+// Locked returns how long login of email from ip stays locked, 0 if it is not.
+func (l *Lockout) Locked(email, ip string) (time.Duration, error) {
+	now := l.clock.Now()
+	account, err := l.lockedUntil(accountKey(email), l.cfg.Attempts, now)
+	if err != nil {
+		return 0, err
+	}
+	addr, err := l.lockedUntil(ipKey(ip), l.cfg.IPAttempts, now)
+	if err != nil {
+		return 0, err
+	}
+	if addr.After(account) {
+		account = addr
+	}
+	if account.IsZero() {
+		return 0, nil
+	}
+	return account.Sub(now), nil
+}
+
+func (l *Lockout) lockedUntil(key string, attempts int, now time.Time) (time.Time, error) {
+	if attempts <= 0 {
+		return time.Time{}, nil
+	}
+	failures, last, err := l.store.Get(key, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if until := last.Add(l.delay(failures, attempts)); failures >= attempts && until.After(now) {
+		return until, nil
+	}
+	return time.Time{}, nil
+}
+
+// Fail counts failed login of email from ip and returns whether account got locked by it.
+func (l *Lockout) Fail(email, ip string) (bool, error) {
+	now := l.clock.Now()
+	if l.cfg.IPAttempts > 0 {
+		if _, err := l.store.Fail(ipKey(ip), now, l.cfg.Reset); err != nil {
+			return false, err
+		}
+	}
+	if l.cfg.Attempts <= 0 {
+		return false, nil
+	}
+	failures, err := l.store.Fail(accountKey(email), now, l.cfg.Reset)
+	if err != nil {
+		return false, err
+	}
+	return failures >= l.cfg.Attempts, nil
+}
+
+// Unlock forgets failed logins of email, it is called on successful login and by admins.
+func (l *Lockout) Unlock(email string) error {
+	return l.store.Reset(accountKey(email))
+}
+
+// delay is Duration once failures reach attempts, it doubles with every further failure.
+func (l *Lockout) delay(failures, attempts int) time.Duration {
+	d := l.cfg.Duration
+	for i := attempts; i < failures && d < l.cfg.MaxDuration; i++ {
+		d *= 2
+	}
+	if d > l.cfg.MaxDuration {
+		d = l.cfg.MaxDuration
+	}
+	return d
+}
+
+// PostgresAttemptStore keeps failures in login_attempt table.
+type PostgresAttemptStore struct {
+	db postgres.Querier
+}
+
+func NewPostgresAttemptStore(db *sql.DB) *PostgresAttemptStore {
+	return &PostgresAttemptStore{db: db}
+}
+
+func (s *PostgresAttemptStore) Fail(key string, now time.Time, ttl time.Duration) (int, error) {
+	var failures int
+	err := s.db.QueryRow(
+		`INSERT INTO login_attempt(key, failures, last_failure_at, expires_at) VALUES ($1, 1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempt.expires_at <= $2 THEN 1 ELSE login_attempt.failures + 1 END,
+			last_failure_at = $2, expires_at = $3
+		RETURNING failures;`,
+		key, now, now.Add(ttl),
+	).Scan(&failures)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not count login attempt")
+	}
+	return failures, nil
+}
+
+func (s *PostgresAttemptStore) Get(key string, now time.Time) (int, time.Time, error) {
+	var failures int
+	var last time.Time
+	err := s.db.QueryRow(
+		"SELECT failures, last_failure_at FROM login_attempt WHERE key = $1 AND expires_at > $2;", key, now,
+	).Scan(&failures, &last)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, errors.Wrap(err, "could not get login attempts")
+	}
+	return failures, last, nil
+}
+
+func (s *PostgresAttemptStore) Reset(key string) error {
+	if _, err := s.db.Exec("DELETE FROM login_attempt WHERE key = $1;", key); err != nil {
+		return errors.Wrap(err, "could not reset login attempts")
+	}
+	return nil
+}
+
+// RedisAttemptPrefix is prefix of Redis keys of failed login attempts.
+const RedisAttemptPrefix = "goapi.user.attempts:"
+
+// fail counts failure and remembers its time in milliseconds, key expires ttl after the last failure.
+const fail = `local n = redis.call('HINCRBY', KEYS[1], 'failures', 1)
+redis.call('HSET', KEYS[1], 'last', ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return n`
+
+// RedisAttemptStore keeps failures in Redis, so replicas lock together without load on database.
+type RedisAttemptStore struct {
+	c *redis.Client
+}
+
+func NewRedisAttemptStore(c *redis.Client) *RedisAttemptStore {
+	return &RedisAttemptStore{c: c}
+}
+
+func (s *RedisAttemptStore) Fail(key string, now time.Time, ttl time.Duration) (int, error) {
+	n, err := redis.Int(s.c.Do(context.Background(), "EVAL", fail, 1, RedisAttemptPrefix+key,
+		now.UnixNano()/int64(time.Millisecond), int64(ttl/time.Millisecond)))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not count login attempt")
+	}
+	return int(n), nil
+}
+
+func (s *RedisAttemptStore) Get(key string, now time.Time) (int, time.Time, error) {
+	reply, err := s.c.Do(context.Background(), "HMGET", RedisAttemptPrefix+key, "failures", "last")
+	if err != nil {
+		return 0, time.Time{}, errors.Wrap(err, "could not get login attempts")
+	}
+	// fields of expired key are nil
+	values, _ := reply.([]interface{})
+	if len(values) != 2 {
+		return 0, time.Time{}, nil
+	}
+	f, ok := values[0].([]byte)
+	l, ok2 := values[1].([]byte)
+	if !ok || !ok2 {
+		return 0, time.Time{}, nil
+	}
+	failures, err := strconv.Atoi(string(f))
+	if err != nil {
+		return 0, time.Time{}, errors.Wrap(err, "invalid login attempts")
+	}
+	ms, err := strconv.ParseInt(string(l), 10, 64)
+	if err != nil {
+		return 0, time.Time{}, errors.Wrap(err, "invalid login attempts")
+	}
+	return failures, time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+func (s *RedisAttemptStore) Reset(key string) error {
+	if _, err := s.c.Do(context.Background(), "DEL", RedisAttemptPrefix+key); err != nil {
+		return errors.Wrap(err, "could not reset login attempts")
+	}
+	return nil
+}
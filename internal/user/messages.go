@@ -0,0 +1,62 @@
+package user
+
+import "github.com/agalitsyn/goapi/pkg/i18n"
+
+func init() {
+	i18n.Register("en", i18n.Catalog{
+		"user.invalid_email":             "invalid email %q",
+		"user.password_too_short":        "password must be at least %d characters long",
+		"user.password_too_weak":         "password is too easy to guess, make it longer or mix letters, digits and symbols",
+		"user.password_breached":         "password appeared in a data breach, choose another one",
+		"user.wrong_password":            "current password is wrong",
+		"user.email_taken":               "user with this email is already registered",
+		"user.user_required":             "sign in to see your account",
+		"user.not_found":                 "user %s has no account",
+		"user.invalid_credentials":       "email or password is wrong",
+		"user.unsupported_grant":         "grant type %s is not supported",
+		"user.invalid_refresh_token":     "refresh token is not valid, sign in again",
+		"user.refresh_token_reused":      "refresh token is already used, sign in again",
+		"user.totp_enabled":              "two-factor authentication is already enabled",
+		"user.totp_not_enrolled":         "two-factor authentication is not enabled",
+		"user.invalid_otp":               "one-time password is wrong or already used",
+		"user.otp_required":              "one-time password of authenticator app or backup code is required",
+		"user.otp_rate_limited":          "too many one-time password attempts, try again in %d seconds",
+		"user.login_locked":              "too many failed logins, try again in %d seconds",
+		"user.admin_required":            "only admins may unlock accounts",
+		"user.invalid_verification":      "verification link is not valid, ask to send it again",
+		"user.email_not_verified":        "verify your email to sign in, follow the link we sent to it",
+		"user.verification_rate_limited": "too many verification emails, try again in %d seconds",
+		"user.verification_subject":      "Verify your email",
+		"user.verification_body":         "Follow the link to verify your email:\r\n\r\n%s\r\n\r\nThe link is valid for %d hours. If you did not sign up, ignore this email.\r\n",
+		"user.ldap_no_email":             "your directory account has no email, ask your administrator to add it",
+		"user.ldap_local_account":        "email of your directory account belongs to an account with password, ask your administrator to link them",
+	})
+	i18n.Register("ru", i18n.Catalog{
+		"user.invalid_email":             "неверный email %q",
+		"user.password_too_short":        "пароль должен быть не короче %d символов",
+		"user.password_too_weak":         "пароль слишком легко подобрать, сделайте его длиннее или используйте буквы, цифры и символы",
+		"user.password_breached":         "пароль встречался в утечках данных, выберите другой",
+		"user.wrong_password":            "текущий пароль неверен",
+		"user.email_taken":               "пользователь с таким email уже зарегистрирован",
+		"user.user_required":             "войдите, чтобы просматривать свою учётную запись",
+		"user.not_found":                 "у пользователя %s нет учётной записи",
+		"user.invalid_credentials":       "неверный email или пароль",
+		"user.unsupported_grant":         "тип гранта %s не поддерживается",
+		"user.invalid_refresh_token":     "токен обновления недействителен, войдите снова",
+		"user.refresh_token_reused":      "токен обновления уже использован, войдите снова",
+		"user.totp_enabled":              "двухфакторная аутентификация уже включена",
+		"user.totp_not_enrolled":         "двухфакторная аутентификация не включена",
+		"user.invalid_otp":               "одноразовый пароль неверен или уже использован",
+		"user.otp_required":              "требуется одноразовый пароль приложения-аутентификатора или резервный код",
+		"user.otp_rate_limited":          "слишком много попыток ввода одноразового пароля, повторите через %d секунд",
+		"user.login_locked":              "слишком много неудачных попыток входа, повторите через %d секунд",
+		"user.admin_required":            "только администраторы могут разблокировать учётные записи",
+		"user.invalid_verification":      "ссылка подтверждения недействительна, запросите её снова",
+		"user.email_not_verified":        "подтвердите email, чтобы войти, перейдите по ссылке из письма",
+		"user.verification_rate_limited": "слишком много писем подтверждения, повторите через %d секунд",
+		"user.verification_subject":      "Подтвердите email",
+		"user.verification_body":         "Перейдите по ссылке, чтобы подтвердить email:\r\n\r\n%s\r\n\r\nСсылка действительна %d ч. Если вы не регистрировались, проигнорируйте это письмо.\r\n",
+		"user.ldap_no_email":             "у вашей учётной записи в каталоге нет email, попросите администратора добавить его",
+		"user.ldap_local_account":        "email вашей учётной записи в каталоге принадлежит учётной записи с паролем, попросите администратора связать их",
+	})
+}
@@ -0,0 +1,102 @@
+package user
+
+import migrate "github.com/rubenv/sql-migrate"
+
+func Migrations() []*migrate.Migration {
+	return []*migrate.Migration{
+		{
+			Id: "0028_user",
+			Up: []string{
+				`CREATE TABLE users (
+					id             BIGSERIAL                   NOT NULL,
+					email          character varying(254)      NOT NULL,
+					password_hash  text                        NOT NULL,
+					roles          text[]                      NOT NULL DEFAULT '{}',
+					created_at     timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id),
+					UNIQUE (email)
+				);`,
+				// every login starts a family, refresh tokens of family are rotated one after another
+				`CREATE TABLE refresh_token_family (
+					id          BIGSERIAL                   NOT NULL,
+					user_id     bigint                      NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					revoked_at  timestamp with time zone,
+					expires_at  timestamp with time zone    NOT NULL,
+					created_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (id)
+				);`,
+				`CREATE INDEX refresh_token_family_user_idx ON refresh_token_family (user_id);`,
+				`CREATE INDEX refresh_token_family_expires_at_idx ON refresh_token_family (expires_at);`,
+				`CREATE TABLE refresh_token (
+					hash        character(64)               NOT NULL,
+					family_id   bigint                      NOT NULL REFERENCES refresh_token_family(id) ON DELETE CASCADE,
+					used_at     timestamp with time zone,
+					expires_at  timestamp with time zone    NOT NULL,
+					created_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (hash)
+				);`,
+				`CREATE INDEX refresh_token_family_id_idx ON refresh_token (family_id);`,
+			},
+		},
+		{
+			Id: "0029_user_totp",
+			Up: []string{
+				// totp_secret is encrypted, totp_last_step is step of the last accepted code, so codes are not replayed
+				`ALTER TABLE users
+					ADD COLUMN totp_secret     text,
+					ADD COLUMN totp_enabled    boolean    NOT NULL DEFAULT false,
+					ADD COLUMN totp_last_step  bigint     NOT NULL DEFAULT 0;`,
+				`CREATE TABLE user_backup_code (
+					user_id     bigint                      NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					hash        character(64)               NOT NULL,
+					used_at     timestamp with time zone,
+					created_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (user_id, hash)
+				);`,
+				// families started with a second factor keep roles which require it
+				`ALTER TABLE refresh_token_family ADD COLUMN mfa boolean NOT NULL DEFAULT false;`,
+			},
+		},
+		{
+			Id: "0030_login_attempt",
+			Up: []string{
+				// key is account:<email> or ip:<address>, failures are forgotten at expires_at
+				`CREATE TABLE login_attempt (
+					key              character varying(320)      NOT NULL,
+					failures         integer                     NOT NULL,
+					last_failure_at  timestamp with time zone    NOT NULL,
+					expires_at       timestamp with time zone    NOT NULL,
+					PRIMARY KEY (key)
+				);`,
+				`CREATE INDEX login_attempt_expires_at_idx ON login_attempt (expires_at);`,
+			},
+		},
+		{
+			Id: "0032_user_verification",
+			Up: []string{
+				`ALTER TABLE users ADD COLUMN verified_at timestamp with time zone;`,
+				// users registered before verification could not verify emails
+				`UPDATE users SET verified_at = created_at;`,
+				`CREATE TABLE email_verification (
+					hash        character(64)               NOT NULL,
+					user_id     bigint                      NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					expires_at  timestamp with time zone    NOT NULL,
+					created_at  timestamp with time zone    NOT NULL DEFAULT now(),
+					PRIMARY KEY (hash)
+				);`,
+				`CREATE INDEX email_verification_user_idx ON email_verification (user_id);`,
+				`CREATE INDEX email_verification_expires_at_idx ON email_verification (expires_at);`,
+			},
+		},
+		{
+			Id: "0033_user_provisioning",
+			Up: []string{
+				// external_id is id of user in identity provider, inactive users can not sign in
+				`ALTER TABLE users
+					ADD COLUMN external_id  character varying(256),
+					ADD COLUMN active       boolean    NOT NULL DEFAULT true;`,
+				`CREATE UNIQUE INDEX users_external_id_idx ON users (external_id);`,
+			},
+		},
+	}
+}
@@ -0,0 +1,253 @@
+package user
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/internal/privacy"
+	"github.com/agalitsyn/goapi/pkg/crypto"
+	"github.com/agalitsyn/goapi/pkg/jwtauth"
+	"github.com/agalitsyn/goapi/pkg/ldap"
+	"github.com/agalitsyn/goapi/pkg/module"
+	"github.com/agalitsyn/goapi/pkg/ratelimit"
+	"github.com/agalitsyn/goapi/pkg/redis"
+	"github.com/agalitsyn/goapi/pkg/retention"
+)
+
+func init() {
+	module.Register(&userModule{})
+}
+
+type userModule struct {
+	module.Base
+
+	opts struct {
+		RefreshTTL    time.Duration `long:"users-refresh-token-ttl" env:"GAPI_USERS_REFRESH_TOKEN_TTL" default:"720h" description:"How long refresh token is valid, every refresh extends the session that long."`
+		TOTPIssuer    string        `long:"users-totp-issuer" env:"GAPI_USERS_TOTP_ISSUER" default:"goapi" description:"Name of the service in authenticator apps."`
+		TOTPRoles     []string      `long:"users-totp-required-role" env:"GAPI_USERS_TOTP_REQUIRED_ROLES" env-delim:"," description:"Role granted only to users who sign in with TOTP as the second factor. Requires --encryption-key."`
+		TOTPRateLimit string        `long:"users-totp-rate-limit" env:"GAPI_USERS_TOTP_RATE_LIMIT" default:"5/15m" description:"Attempts to verify one-time passwords per user in form limit/window."`
+
+		Password struct {
+			MinLength     int           `long:"users-password-min-length" env:"GAPI_USERS_PASSWORD_MIN_LENGTH" default:"8" description:"The shortest password users may choose."`
+			MinEntropy    float64       `long:"users-password-min-entropy" env:"GAPI_USERS_PASSWORD_MIN_ENTROPY" default:"50" description:"The least estimated entropy of passwords in bits, 0 disables the check."`
+			BreachCheck   bool          `long:"users-password-breach-check" env:"GAPI_USERS_PASSWORD_BREACH_CHECK" description:"Reject passwords which appeared in data breaches, only 5 characters of their SHA-1 are sent to the API."`
+			BreachURL     string        `long:"users-password-breach-url" env:"GAPI_USERS_PASSWORD_BREACH_URL" default:"https://api.pwnedpasswords.com/range/" description:"Range API of breached passwords, the hash prefix is appended to it."`
+			BreachTimeout time.Duration `long:"users-password-breach-timeout" env:"GAPI_USERS_PASSWORD_BREACH_TIMEOUT" default:"3s" description:"Timeout of breach check, passwords are accepted if it fails."`
+		}
+
+		Verification struct {
+			Required  bool          `long:"users-verification-required" env:"GAPI_USERS_VERIFICATION_REQUIRED" description:"Reject logins of users who have not verified email. Requires SMTP configured for notifications."`
+			TTL       time.Duration `long:"users-verification-ttl" env:"GAPI_USERS_VERIFICATION_TTL" default:"48h" description:"How long the link verifying email is valid."`
+			URL       string        `long:"users-verification-url" env:"GAPI_USERS_VERIFICATION_URL" description:"Template of the link verifying email, {token} is replaced. Defaults to /1.0/auth/verify of the service."`
+			RateLimit string        `long:"users-verification-rate-limit" env:"GAPI_USERS_VERIFICATION_RATE_LIMIT" default:"3/1h" description:"Verification emails sent again per email in form limit/window."`
+		}
+
+		AuthProvider string `long:"users-auth-provider" env:"GAPI_USERS_AUTH_PROVIDER" default:"local" choice:"local" choice:"ldap" description:"Where passwords are checked: local accounts, or LDAP directory configured with --users-ldap-*, its users get accounts on the first login."`
+
+		LDAP struct {
+			URL            string        `long:"users-ldap-url" env:"GAPI_USERS_LDAP_URL" description:"Directory in form ldap://host[:port] or ldaps://host[:port]."`
+			StartTLS       bool          `long:"users-ldap-start-tls" env:"GAPI_USERS_LDAP_START_TLS" description:"Upgrade ldap:// connections to TLS with StartTLS."`
+			CAFile         string        `long:"users-ldap-ca-file" env:"GAPI_USERS_LDAP_CA_FILE" description:"PEM file of CA certificates verifying directory, system ones are used by default."`
+			BindDN         string        `long:"users-ldap-bind-dn" env:"GAPI_USERS_LDAP_BIND_DN" description:"DN the service binds as to search users and groups."`
+			BindPassword   string        `long:"users-ldap-bind-password" env:"GAPI_USERS_LDAP_BIND_PASSWORD" description:"Password of --users-ldap-bind-dn."`
+			BaseDN         string        `long:"users-ldap-base-dn" env:"GAPI_USERS_LDAP_BASE_DN" description:"DN users and groups are searched under."`
+			UserFilter     string        `long:"users-ldap-user-filter" env:"GAPI_USERS_LDAP_USER_FILTER" default:"(&(objectClass=person)(uid={login}))" description:"Filter finding user, {login} is replaced with login."`
+			EmailAttribute string        `long:"users-ldap-email-attribute" env:"GAPI_USERS_LDAP_EMAIL_ATTRIBUTE" default:"mail" description:"Attribute of user with email."`
+			GroupAttribute string        `long:"users-ldap-group-attribute" env:"GAPI_USERS_LDAP_GROUP_ATTRIBUTE" default:"memberOf" description:"Attribute of user with DNs of groups."`
+			GroupFilter    string        `long:"users-ldap-group-filter" env:"GAPI_USERS_LDAP_GROUP_FILTER" description:"Filter finding groups of user instead of --users-ldap-group-attribute, {dn} is replaced with DN of user, e.g. (member={dn})."`
+			GroupRoles     []string      `long:"users-ldap-group-role" env:"GAPI_USERS_LDAP_GROUP_ROLES" env-delim:";" description:"Role granted to members of group in form role:group DN. Roles of users are replaced with mapped ones on every login."`
+			PoolSize       int           `long:"users-ldap-pool-size" env:"GAPI_USERS_LDAP_POOL_SIZE" default:"5" description:"The maximum number of idle connections to directory."`
+			Timeout        time.Duration `long:"users-ldap-timeout" env:"GAPI_USERS_LDAP_TIMEOUT" default:"5s" description:"Timeout of connecting to directory and of every operation."`
+			SessionTTL     time.Duration `long:"users-ldap-session-ttl" env:"GAPI_USERS_LDAP_SESSION_TTL" default:"12h" description:"How long session lasts since login however often it is refreshed, roles are synced with directory on login only. 0 disables."`
+		}
+
+		Lockout struct {
+			Store       string        `long:"users-lockout-store" env:"GAPI_USERS_LOCKOUT_STORE" default:"postgres" choice:"none" choice:"postgres" choice:"redis" description:"Where failed logins are counted: postgres, or redis configured with --redis-url. Logins are not throttled if none."`
+			Attempts    int           `long:"users-lockout-attempts" env:"GAPI_USERS_LOCKOUT_ATTEMPTS" default:"5" description:"Failed logins locking account, 0 disables."`
+			IPAttempts  int           `long:"users-lockout-ip-attempts" env:"GAPI_USERS_LOCKOUT_IP_ATTEMPTS" default:"50" description:"Failed logins locking client address, 0 disables."`
+			Duration    time.Duration `long:"users-lockout-duration" env:"GAPI_USERS_LOCKOUT_DURATION" default:"1m" description:"How long the first lockout lasts, every further failed login doubles it."`
+			MaxDuration time.Duration `long:"users-lockout-max-duration" env:"GAPI_USERS_LOCKOUT_MAX_DURATION" default:"1h" description:"The longest lockout."`
+			Reset       time.Duration `long:"users-lockout-reset" env:"GAPI_USERS_LOCKOUT_RESET" default:"24h" description:"How long failed logins are counted after the last one."`
+		}
+	}
+
+	env     *module.Env
+	manager *Manager
+	totp    TOTPConfig
+	lockout *Lockout
+	// verification lacks mailer until Routes, it is provided by another module
+	verification VerificationConfig
+	// breaches is nil unless passwords are checked against breaches
+	breaches *BreachChecker
+	// issuer is nil unless service issues tokens, see --jwt-secret
+	issuer *jwtauth.Issuer
+	// provider is nil unless passwords are checked by directory, see --users-auth-provider
+	provider Provider
+}
+
+func (mod *userModule) Name() string                     { return "users" }
+func (mod *userModule) Options() interface{}             { return &mod.opts }
+func (mod *userModule) Migrations() []*migrate.Migration { return Migrations() }
+
+// Init provides privacy handler as privacy.handler.users, it deletes accounts.
+func (mod *userModule) Init(env *module.Env) error {
+	mod.env = env
+	mod.manager = NewManager(env.DB)
+	mod.manager.keys = env.Keys
+	if len(mod.opts.TOTPRoles) > 0 && env.Keys == nil {
+		return errors.New("--users-totp-required-role requires --encryption-key, TOTP secrets are stored encrypted")
+	}
+
+	otpRule, err := ratelimit.ParseRule(OTPLimitPrefix + ":" + mod.opts.TOTPRateLimit)
+	if err != nil {
+		return errors.Wrap(err, "invalid --users-totp-rate-limit")
+	}
+	vo := mod.opts.Verification
+	verifyRule, err := ratelimit.ParseRule(VerifyLimitPrefix + ":" + vo.RateLimit)
+	if err != nil {
+		return errors.Wrap(err, "invalid --users-verification-rate-limit")
+	}
+	// attempts are counted across replicas when Redis is configured
+	var store ratelimit.Store
+	if c, ok := env.Lookup("redis.client"); ok {
+		store = ratelimit.NewRedisStore(c.(*redis.Client))
+	}
+	limiter := ratelimit.New([]ratelimit.Rule{otpRule, verifyRule}, store)
+	mod.totp = TOTPConfig{
+		Issuer:  mod.opts.TOTPIssuer,
+		Roles:   mod.opts.TOTPRoles,
+		Limiter: limiter,
+	}
+	mod.verification = VerificationConfig{Required: vo.Required, TTL: vo.TTL, URL: vo.URL, Limiter: limiter}
+	if mod.verification.URL == "" {
+		mod.verification.URL = env.BaseURL + "/1.0/auth/verify?token={token}"
+	}
+	if issuer, ok := env.Lookup(jwtauth.IssuerService); ok {
+		mod.issuer = issuer.(*jwtauth.Issuer)
+	}
+
+	if pw := mod.opts.Password; pw.BreachCheck {
+		mod.breaches = NewBreachChecker(pw.BreachURL, pw.BreachTimeout, env.Egress)
+	}
+
+	if mod.opts.AuthProvider == "ldap" {
+		if mod.provider, err = mod.ldapProvider(); err != nil {
+			return err
+		}
+	}
+
+	lo := mod.opts.Lockout
+	if lo.Reset < lo.MaxDuration {
+		return errors.New("--users-lockout-reset must not be shorter than --users-lockout-max-duration")
+	}
+	var attempts AttemptStore
+	switch lo.Store {
+	case "postgres":
+		attempts = NewPostgresAttemptStore(env.DB)
+	case "redis":
+		c, ok := env.Lookup("redis.client")
+		if !ok {
+			return errors.New("redis lockout store requires --redis-url")
+		}
+		attempts = NewRedisAttemptStore(c.(*redis.Client))
+	}
+	if attempts != nil && (lo.Attempts > 0 || lo.IPAttempts > 0) {
+		mod.lockout = NewLockout(attempts, LockoutConfig{
+			Attempts:    lo.Attempts,
+			IPAttempts:  lo.IPAttempts,
+			Duration:    lo.Duration,
+			MaxDuration: lo.MaxDuration,
+			Reset:       lo.Reset,
+		})
+	}
+	env.Provide(privacy.HandlerPrefix+"users", &privacyHandler{m: mod.manager, lockout: mod.lockout})
+	return nil
+}
+
+// Routes serve token endpoints only when service issues tokens and email verification only when mailer
+// is provided. Mailer and event handlers provided by other modules are looked up here, after all modules
+// are initialized.
+func (mod *userModule) Routes() map[string]http.Handler {
+	if mailer, ok := mod.env.Lookup(MailerName); ok {
+		mod.verification.Mailer = mailer.(Mailer)
+	} else if mod.verification.Required {
+		mod.env.Logger.Warn("--users-verification-required without mailer, users registered from now on can not sign in")
+	}
+	cfg := Config{
+		Issuer:       mod.issuer,
+		RefreshTTL:   mod.opts.RefreshTTL,
+		TOTP:         mod.totp,
+		Lockout:      mod.lockout,
+		Events:       make(map[string]EventHandler),
+		Passwords:    PasswordPolicy{MinLength: mod.opts.Password.MinLength, MinEntropy: mod.opts.Password.MinEntropy},
+		Breaches:     mod.breaches,
+		Verification: mod.verification,
+		Provider:     mod.provider,
+
+		ProviderSessionTTL: mod.opts.LDAP.SessionTTL,
+	}
+	for name, h := range mod.env.LookupPrefix(EventHandlerPrefix) {
+		cfg.Events[name] = h.(EventHandler)
+	}
+	routes := map[string]http.Handler{"/users": Routes(mod.manager, cfg)}
+	if mod.issuer != nil || mod.verification.Mailer != nil {
+		routes["/auth"] = AuthRoutes(mod.manager, cfg)
+	}
+	return routes
+}
+
+func (mod *userModule) ldapProvider() (Provider, error) {
+	o := mod.opts.LDAP
+	if o.URL == "" || o.BindDN == "" || o.BindPassword == "" || o.BaseDN == "" {
+		return nil, errors.New("ldap auth provider requires --users-ldap-url, --users-ldap-bind-dn, --users-ldap-bind-password and --users-ldap-base-dn")
+	}
+	roles, err := ParseGroupRoles(o.GroupRoles)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --users-ldap-group-role")
+	}
+	tc := &tls.Config{}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read --users-ldap-ca-file")
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates in %s", o.CAFile)
+		}
+	}
+	client, err := ldap.New(o.URL, ldap.Config{PoolSize: o.PoolSize, Timeout: o.Timeout, StartTLS: o.StartTLS, TLS: tc})
+	if err != nil {
+		return nil, err
+	}
+	return NewLDAPProvider(client, LDAPConfig{
+		BindDN:         o.BindDN,
+		BindPassword:   o.BindPassword,
+		BaseDN:         o.BaseDN,
+		UserFilter:     o.UserFilter,
+		EmailAttribute: o.EmailAttribute,
+		GroupAttribute: o.GroupAttribute,
+		GroupFilter:    o.GroupFilter,
+		GroupRoles:     roles,
+	}), nil
+}
+
+func (mod *userModule) EncryptedColumns() []crypto.Column {
+	return []crypto.Column{{Table: "users", Key: "id", Name: "totp_secret"}}
+}
+
+// RetentionRules forget refresh token families a week after they expire, reuse of their tokens
+// is told from unknown tokens until then, forgotten login attempts and expired verification tokens.
+func (mod *userModule) RetentionRules() []retention.Rule {
+	return []retention.Rule{
+		{Name: "refresh_families", Table: "refresh_token_family", Column: "expires_at", MaxAge: 7 * 24 * time.Hour},
+		{Name: "login_attempts", Table: "login_attempt", Column: "expires_at", MaxAge: time.Hour},
+		{Name: "email_verifications", Table: "email_verification", Column: "expires_at", MaxAge: time.Hour},
+	}
+}
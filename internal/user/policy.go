@@ -0,0 +1,140 @@
+package user
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/egress"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+)
+
+// PasswordPolicy is what passwords users choose must satisfy.
+type PasswordPolicy struct {
+	MinLength int
+	// MinEntropy is the least estimated entropy in bits, see Entropy, 0 disables the check.
+	MinEntropy float64
+}
+
+// Validate returns error describing why password does not satisfy policy.
+func (p PasswordPolicy) Validate(password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return i18n.Errorf("user.password_too_short", p.MinLength)
+	}
+	if p.MinEntropy > 0 && Entropy(password) < p.MinEntropy {
+		return i18n.Errorf("user.password_too_weak")
+	}
+	return nil
+}
+
+// Entropy estimates entropy of password in bits as if it was random of the character classes it uses:
+// lowercase and uppercase letters, digits, symbols and other characters. Runs of the same character
+// count as two characters, so aaaaaaaa is not stronger than aa.
+func Entropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	var length int
+	var prev rune
+	run := 0
+	for _, c := range password {
+		if c == prev {
+			run++
+		} else {
+			prev, run = c, 1
+		}
+		if run > 2 {
+			continue
+		}
+		length++
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c < unicode.MaxASCII && unicode.IsPrint(c):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(length) * math.Log2(float64(pool))
+}
+
+// DefaultBreachURL is range API of Have I Been Pwned.
+const DefaultBreachURL = "https://api.pwnedpasswords.com/range/"
+
+// BreachChecker tells whether password appeared in known data breaches with range API of
+// Have I Been Pwned, see https://haveibeenpwned.com/API/v3#PwnedPasswords. Only the first 5 characters
+// of SHA-1 of password are sent, k-anonymity keeps password unknown to the API.
+type BreachChecker struct {
+	url    string
+	client *http.Client
+}
+
+// NewBreachChecker returns checker of range API at url, the prefix of hash is appended to it.
+func NewBreachChecker(url string, timeout time.Duration, policy *egress.Policy) *BreachChecker {
+	return &BreachChecker{url: url, client: policy.Client(timeout)}
+}
+
+// Count returns how many times password appeared in breaches, 0 if it never did.
+func (c *BreachChecker) Count(ctx context.Context, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequest(http.MethodGet, c.url+prefix, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not build breach check request")
+	}
+	// padding hides how many suffixes share the prefix from observers of response size
+	req.Header.Set("Add-Padding", "true")
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not check password breaches")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Errorf("could not check password breaches: unexpected status %d", resp.StatusCode)
+	}
+
+	// lines are SUFFIX:COUNT, padding lines have count 0
+	s := bufio.NewScanner(resp.Body)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		i := strings.IndexByte(line, ':')
+		if i < 0 || !strings.EqualFold(line[:i], suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(line[i+1:])
+		if err != nil {
+			return 0, errors.Errorf("could not check password breaches: invalid count %q", line[i+1:])
+		}
+		return n, nil
+	}
+	if err := s.Err(); err != nil {
+		return 0, errors.Wrap(err, "could not check password breaches")
+	}
+	return 0, nil
+}
@@ -0,0 +1,54 @@
+package user
+
+import (
+	"strconv"
+
+	"github.com/agalitsyn/goapi/internal/privacy"
+)
+
+// privacyHandler exports and deletes accounts, tenants have none. Failed logins counted for email
+// of the account are forgotten with it.
+type privacyHandler struct {
+	m       *Manager
+	lockout *Lockout
+}
+
+func (h *privacyHandler) Export(s privacy.Subject) (interface{}, error) {
+	if !isUserID(s.User) {
+		return nil, nil
+	}
+	u, err := h.m.Get(s.User)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	return u, err
+}
+
+func (h *privacyHandler) Erase(s privacy.Subject) error {
+	if !isUserID(s.User) {
+		return nil
+	}
+	u, err := h.m.Get(s.User)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// failures are forgotten first, so a retry still knows email if deletion fails
+	if h.lockout != nil {
+		if err := h.lockout.Unlock(u.Email); err != nil {
+			return err
+		}
+	}
+	if _, err := h.m.Delete(s.User); err != nil && err != ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// isUserID tells whether id may be id of an account, subjects of other identity providers are not.
+func isUserID(id string) bool {
+	_, err := strconv.ParseInt(id, 10, 64)
+	return err == nil
+}
@@ -0,0 +1,188 @@
+package user
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/totp"
+)
+
+// BackupCodes is how many backup codes user gets on enrollment, each signs in once without authenticator app.
+const BackupCodes = 10
+
+// totpColumn is authenticated with encrypted secrets, see crypto.Keyring.
+const totpColumn = "users.totp_secret"
+
+var (
+	// ErrTOTPEnabled is returned on enrollment of user who already has TOTP enabled.
+	ErrTOTPEnabled = i18n.Errorf("user.totp_enabled")
+	// ErrTOTPNotEnrolled is returned when user has not enrolled or confirmed TOTP.
+	ErrTOTPNotEnrolled = i18n.Errorf("user.totp_not_enrolled")
+	// ErrInvalidOTP is returned for wrong, replayed and used backup codes alike.
+	ErrInvalidOTP = i18n.Errorf("user.invalid_otp")
+	// ErrOTPRequired is returned on login of user with TOTP enabled without a code.
+	ErrOTPRequired = i18n.Errorf("user.otp_required")
+)
+
+// EnrollTOTP generates secret of user, TOTP is enabled once user confirms it with a code, see ConfirmTOTP.
+// Enrolling again replaces unconfirmed secret.
+func (m *Manager) EnrollTOTP(userID string) (string, error) {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return "", err
+	}
+	res, err := m.db.Exec(
+		"UPDATE users SET totp_secret = $2, totp_last_step = 0 WHERE id = $1 AND NOT totp_enabled;",
+		userID, m.keys.String(totpColumn, &secret),
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "could not enroll totp")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return "", errors.Wrap(err, "could not enroll totp")
+	} else if n == 0 {
+		return "", ErrTOTPEnabled
+	}
+	return secret, nil
+}
+
+// ConfirmTOTP enables TOTP of user with code of enrolled secret and returns new backup codes,
+// they are only kept hashed.
+func (m *Manager) ConfirmTOTP(userID, code string) ([]string, error) {
+	var codes []string
+	err := m.Tx(false, func(m *Manager) error {
+		secret, enabled, last, err := m.lockTOTP(userID)
+		if err != nil {
+			return err
+		}
+		if secret == "" {
+			return ErrTOTPNotEnrolled
+		}
+		if enabled {
+			return ErrTOTPEnabled
+		}
+		step, err := totp.Validate(secret, code, m.clock.Now(), last)
+		if err != nil {
+			return err
+		}
+		if step == 0 {
+			return ErrInvalidOTP
+		}
+		if _, err := m.db.Exec(
+			"UPDATE users SET totp_enabled = true, totp_last_step = $2 WHERE id = $1;", userID, step,
+		); err != nil {
+			return errors.Wrap(err, "could not enable totp")
+		}
+
+		if _, err := m.db.Exec("DELETE FROM user_backup_code WHERE user_id = $1;", userID); err != nil {
+			return errors.Wrap(err, "could not delete backup codes")
+		}
+		for i := 0; i < BackupCodes; i++ {
+			c, err := newBackupCode()
+			if err != nil {
+				return err
+			}
+			if _, err := m.db.Exec(
+				"INSERT INTO user_backup_code(user_id, hash) VALUES ($1, $2);", userID, hashToken(normalizeBackupCode(c)),
+			); err != nil {
+				return errors.Wrap(err, "could not create backup code")
+			}
+			codes = append(codes, c)
+		}
+		return nil
+	})
+	return codes, err
+}
+
+// VerifyOTP checks code of authenticator app or unused backup code of user, every code is accepted once.
+func (m *Manager) VerifyOTP(userID, code string) error {
+	return m.Tx(false, func(m *Manager) error {
+		return m.verifyOTP(userID, code)
+	})
+}
+
+// DisableTOTP disables TOTP of user with a code, so a stolen access token alone does not disable it.
+func (m *Manager) DisableTOTP(userID, code string) error {
+	return m.Tx(false, func(m *Manager) error {
+		if err := m.verifyOTP(userID, code); err != nil {
+			return err
+		}
+		if _, err := m.db.Exec(
+			"UPDATE users SET totp_secret = NULL, totp_enabled = false, totp_last_step = 0 WHERE id = $1;", userID,
+		); err != nil {
+			return errors.Wrap(err, "could not disable totp")
+		}
+		if _, err := m.db.Exec("DELETE FROM user_backup_code WHERE user_id = $1;", userID); err != nil {
+			return errors.Wrap(err, "could not delete backup codes")
+		}
+		return nil
+	})
+}
+
+// verifyOTP must be called in transaction, user is locked so concurrent requests do not accept the same code.
+func (m *Manager) verifyOTP(userID, code string) error {
+	secret, enabled, last, err := m.lockTOTP(userID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrTOTPNotEnrolled
+	}
+
+	step, err := totp.Validate(secret, code, m.clock.Now(), last)
+	if err != nil {
+		return err
+	}
+	if step != 0 {
+		if _, err := m.db.Exec("UPDATE users SET totp_last_step = $2 WHERE id = $1;", userID, step); err != nil {
+			return errors.Wrap(err, "could not accept totp")
+		}
+		return nil
+	}
+
+	res, err := m.db.Exec(
+		"UPDATE user_backup_code SET used_at = $3 WHERE user_id = $1 AND hash = $2 AND used_at IS NULL;",
+		userID, hashToken(normalizeBackupCode(code)), m.clock.Now(),
+	)
+	if err != nil {
+		return errors.Wrap(err, "could not use backup code")
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Wrap(err, "could not use backup code")
+	} else if n == 0 {
+		return ErrInvalidOTP
+	}
+	return nil
+}
+
+func (m *Manager) lockTOTP(userID string) (secret string, enabled bool, last int64, err error) {
+	err = m.db.QueryRow(
+		"SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = $1 FOR UPDATE;", userID,
+	).Scan(m.keys.String(totpColumn, &secret), &enabled, &last)
+	if err == sql.ErrNoRows {
+		return "", false, 0, ErrNotFound
+	}
+	if err != nil {
+		return "", false, 0, errors.Wrap(err, "could not get totp")
+	}
+	return secret, enabled, last, nil
+}
+
+// newBackupCode returns code in form 1a2b3-c4d5e.
+func newBackupCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "could not generate backup code")
+	}
+	s := hex.EncodeToString(b)
+	return s[:5] + "-" + s[5:], nil
+}
+
+func normalizeBackupCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,303 @@
+// Package user keeps accounts of users who sign in with email and password, and issues them access
+// tokens, see pkg/jwtauth, with refresh tokens which are rotated on every use. A refresh token used
+// twice means it leaked, so the whole family of tokens rotated from the same login is revoked.
+//
+// Users may enable TOTP as the second factor, see pkg/totp, then they sign in with a one-time password
+// of authenticator app or a backup code. Roles may require the second factor, they are granted only
+// to tokens of logins verified with it.
+//
+// Failed logins are counted per account and per client address, see Lockout, and both are locked
+// for exponentially growing time once there are too many of them.
+//
+// Registered users get a link verifying their email when Mailer is provided, logins of unverified
+// users may be rejected, see VerificationConfig.
+//
+// Passwords may be checked by external directory instead, see Provider and LDAPProvider, then accounts
+// are created on the first login and their roles follow groups of directory.
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/crypto"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/ids"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrEmailTaken is returned when another user has the same email.
+	ErrEmailTaken = i18n.Errorf("user.email_taken")
+	// ErrInvalidCredentials is returned for unknown email and wrong password alike.
+	ErrInvalidCredentials = i18n.Errorf("user.invalid_credentials")
+	// ErrInvalidRefresh is returned for unknown, expired and revoked refresh tokens.
+	ErrInvalidRefresh = i18n.Errorf("user.invalid_refresh_token")
+	// ErrRefreshReused is returned when refresh token is used again, its family is revoked then.
+	ErrRefreshReused = i18n.Errorf("user.refresh_token_reused")
+)
+
+// dummy is hash checked when email is unknown, so responses do not tell which emails are registered.
+var dummy struct {
+	once sync.Once
+	hash string
+}
+
+func dummyHash() string {
+	dummy.once.Do(func() { dummy.hash, _ = HashPassword("") })
+	return dummy.hash
+}
+
+// User is an account, TOTP is whether user signs in with one-time passwords as the second factor,
+// Verified is whether user followed the link sent to email.
+type User struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Roles     []string  `json:"roles"`
+	TOTP      bool      `json:"totp_enabled"`
+	Verified  bool      `json:"email_verified"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Family of refresh tokens rotated one after another since login.
+type Family struct {
+	ID     string
+	UserID string
+	// MFA is whether login was verified with the second factor.
+	MFA bool
+}
+
+type Manager struct {
+	db    postgres.Querier
+	ids   ids.Generator
+	clock clock.Clock
+	// keys encrypt TOTP secrets, two-factor authentication is not available without them
+	keys *crypto.Keyring
+}
+
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{db: db, ids: ids.Random, clock: clock.Real}
+}
+
+// Tx runs fn with manager bound to a transaction, which is rolled back when fn fails or on dry run.
+func (m *Manager) Tx(dryRun bool, fn func(m *Manager) error) error {
+	return postgres.Tx(m.db, dryRun, func(tx postgres.Querier) error {
+		return fn(&Manager{db: tx, ids: m.ids, clock: m.clock, keys: m.keys})
+	})
+}
+
+// WithContext returns manager which queries are cancelled with ctx, e.g. when request budget runs out.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	return &Manager{db: postgres.WithContext(ctx, m.db), ids: m.ids, clock: m.clock, keys: m.keys}
+}
+
+// NormalizeEmail lowercases email, so it is unique regardless of case.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Register creates user without roles.
+func (m *Manager) Register(email, password string) (*User, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	u := &User{Email: NormalizeEmail(email), Roles: []string{}}
+	err = m.db.QueryRow(
+		"INSERT INTO users(email, password_hash) VALUES ($1, $2) ON CONFLICT (email) DO NOTHING RETURNING id, created_at;",
+		u.Email, hash,
+	).Scan(&u.ID, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create user")
+	}
+	return u, nil
+}
+
+// Authenticate returns active user with email and password. Users provisioned without password
+// have empty hash, they never match.
+func (m *Manager) Authenticate(email, password string) (*User, error) {
+	var u User
+	var hash string
+	err := m.db.QueryRow(
+		"SELECT id, email, password_hash, roles, totp_enabled, verified_at IS NOT NULL, created_at FROM users WHERE email = $1 AND active;",
+		NormalizeEmail(email),
+	).Scan(&u.ID, &u.Email, &hash, pq.Array(&u.Roles), &u.TOTP, &u.Verified, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		CheckPassword(dummyHash(), password)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get user")
+	}
+	if !CheckPassword(hash, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &u, nil
+}
+
+// ChangePassword sets password of user who knows the current one and revokes refresh token families,
+// so sessions elsewhere, e.g. of whoever learned the old password, end once their access tokens expire.
+func (m *Manager) ChangePassword(id, current, password string) error {
+	newHash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return m.Tx(false, func(m *Manager) error {
+		var hash string
+		err := m.db.QueryRow("SELECT password_hash FROM users WHERE id = $1 FOR UPDATE;", id).Scan(&hash)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return errors.Wrap(err, "could not get user")
+		}
+		if !CheckPassword(hash, current) {
+			return ErrInvalidCredentials
+		}
+		if _, err := m.db.Exec("UPDATE users SET password_hash = $2 WHERE id = $1;", id, newHash); err != nil {
+			return errors.Wrap(err, "could not change password")
+		}
+		if _, err := m.db.Exec(
+			"UPDATE refresh_token_family SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL;", id, m.clock.Now(),
+		); err != nil {
+			return errors.Wrap(err, "could not revoke refresh token families")
+		}
+		return nil
+	})
+}
+
+func (m *Manager) Get(id string) (*User, error) {
+	var u User
+	err := m.db.QueryRow(
+		"SELECT id, email, roles, totp_enabled, verified_at IS NOT NULL, created_at FROM users WHERE id = $1;", id,
+	).Scan(&u.ID, &u.Email, pq.Array(&u.Roles), &u.TOTP, &u.Verified, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get user")
+	}
+	return &u, nil
+}
+
+// Delete deletes user with its tokens and verifications and returns email of the deleted account.
+func (m *Manager) Delete(id string) (string, error) {
+	var email string
+	err := m.db.QueryRow("DELETE FROM users WHERE id = $1 RETURNING email;", id).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "could not delete user")
+	}
+	return email, nil
+}
+
+// StartFamily starts family of refresh tokens of user on login and returns its first token,
+// mfa tells whether login was verified with the second factor.
+func (m *Manager) StartFamily(userID string, mfa bool, ttl time.Duration) (string, error) {
+	var token string
+	err := m.Tx(false, func(m *Manager) error {
+		expires := m.clock.Now().Add(ttl)
+		var familyID string
+		err := m.db.QueryRow(
+			"INSERT INTO refresh_token_family(user_id, mfa, expires_at) VALUES ($1, $2, $3) RETURNING id;", userID, mfa, expires,
+		).Scan(&familyID)
+		if err != nil {
+			return errors.Wrap(err, "could not start refresh token family")
+		}
+		token, err = m.issueRefresh(familyID, expires)
+		return err
+	})
+	return token, err
+}
+
+// Rotate exchanges refresh token for a new one of the same family and returns the family.
+// Family is revoked if token was used before, so neither the thief nor the user can refresh anymore.
+// Family is not extended beyond maxAge since login unless maxAge is 0.
+func (m *Manager) Rotate(token string, ttl, maxAge time.Duration) (*Family, string, error) {
+	var f Family
+	var next string
+	var reused bool
+	err := m.Tx(false, func(m *Manager) error {
+		var expires, started time.Time
+		var usedAt, revokedAt pq.NullTime
+		// family is locked, so concurrent refreshes with the same token are serialized
+		err := m.db.QueryRow(
+			`SELECT f.id, f.user_id, f.mfa, f.revoked_at, f.created_at, t.used_at, t.expires_at FROM refresh_token t
+			JOIN refresh_token_family f ON f.id = t.family_id WHERE t.hash = $1 FOR UPDATE OF f;`,
+			hashToken(token),
+		).Scan(&f.ID, &f.UserID, &f.MFA, &revokedAt, &started, &usedAt, &expires)
+		if err == sql.ErrNoRows {
+			return ErrInvalidRefresh
+		}
+		if err != nil {
+			return errors.Wrap(err, "could not get refresh token")
+		}
+		now := m.clock.Now()
+		switch {
+		case revokedAt.Valid:
+			return ErrInvalidRefresh
+		case usedAt.Valid:
+			reused = true
+			if _, err := m.db.Exec("UPDATE refresh_token_family SET revoked_at = $2 WHERE id = $1;", f.ID, now); err != nil {
+				return errors.Wrap(err, "could not revoke refresh token family")
+			}
+			return nil
+		case !now.Before(expires):
+			return ErrInvalidRefresh
+		}
+
+		expires = now.Add(ttl)
+		if maxAge > 0 && expires.After(started.Add(maxAge)) {
+			expires = started.Add(maxAge)
+		}
+		if _, err := m.db.Exec("UPDATE refresh_token SET used_at = $2 WHERE hash = $1;", hashToken(token), now); err != nil {
+			return errors.Wrap(err, "could not use refresh token")
+		}
+		if _, err := m.db.Exec("UPDATE refresh_token_family SET expires_at = $2 WHERE id = $1;", f.ID, expires); err != nil {
+			return errors.Wrap(err, "could not extend refresh token family")
+		}
+		next, err = m.issueRefresh(f.ID, expires)
+		return err
+	})
+	if err == nil && reused {
+		return &f, "", ErrRefreshReused
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &f, next, nil
+}
+
+func (m *Manager) issueRefresh(familyID string, expires time.Time) (string, error) {
+	token, err := m.ids.NewID()
+	if err != nil {
+		return "", err
+	}
+	if _, err := m.db.Exec(
+		"INSERT INTO refresh_token(hash, family_id, expires_at) VALUES ($1, $2, $3);", hashToken(token), familyID, expires,
+	); err != nil {
+		return "", errors.Wrap(err, "could not issue refresh token")
+	}
+	return token, nil
+}
+
+// hashToken is kept instead of refresh token, so tokens do not leak with database.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
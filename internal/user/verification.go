@@ -0,0 +1,104 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+)
+
+// MailerName is a name another module provides Mailer with, e.g. notification module when SMTP is configured.
+const MailerName = "user.mailer"
+
+// Mailer sends plain text emails.
+type Mailer interface {
+	SendMail(ctx context.Context, address, subject, body string) error
+}
+
+var (
+	// ErrInvalidVerification is returned for unknown, expired and used verification tokens.
+	ErrInvalidVerification = i18n.Errorf("user.invalid_verification")
+	// ErrEmailNotVerified is returned on login of unverified user when verified emails are required.
+	ErrEmailNotVerified = i18n.Errorf("user.email_not_verified")
+)
+
+// verificationLink replaces {token} in URL template.
+func verificationLink(tmpl, token string) string {
+	return strings.Replace(tmpl, "{token}", url.QueryEscape(token), -1)
+}
+
+// StartVerification returns new token verifying email of user, tokens issued before are not valid anymore.
+func (m *Manager) StartVerification(userID string, ttl time.Duration) (string, error) {
+	token, err := m.ids.NewID()
+	if err != nil {
+		return "", err
+	}
+	err = m.Tx(false, func(m *Manager) error {
+		if _, err := m.db.Exec("DELETE FROM email_verification WHERE user_id = $1;", userID); err != nil {
+			return errors.Wrap(err, "could not delete verification tokens")
+		}
+		if _, err := m.db.Exec(
+			"INSERT INTO email_verification(hash, user_id, expires_at) VALUES ($1, $2, $3);",
+			hashToken(token), userID, m.clock.Now().Add(ttl),
+		); err != nil {
+			return errors.Wrap(err, "could not create verification token")
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Verify marks email of user with token verified and returns the user id, token is valid once.
+func (m *Manager) Verify(token string) (string, error) {
+	var userID string
+	err := m.Tx(false, func(m *Manager) error {
+		var expires time.Time
+		err := m.db.QueryRow(
+			"DELETE FROM email_verification WHERE hash = $1 RETURNING user_id, expires_at;", hashToken(token),
+		).Scan(&userID, &expires)
+		if err == sql.ErrNoRows {
+			return ErrInvalidVerification
+		}
+		if err != nil {
+			return errors.Wrap(err, "could not get verification token")
+		}
+		if !m.clock.Now().Before(expires) {
+			return ErrInvalidVerification
+		}
+		if _, err := m.db.Exec(
+			"UPDATE users SET verified_at = $2 WHERE id = $1 AND verified_at IS NULL;", userID, m.clock.Now(),
+		); err != nil {
+			return errors.Wrap(err, "could not verify email")
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// GetByEmail returns active user with email.
+func (m *Manager) GetByEmail(email string) (*User, error) {
+	var u User
+	err := m.db.QueryRow(
+		"SELECT id, email, roles, totp_enabled, verified_at IS NOT NULL, created_at FROM users WHERE email = $1 AND active;",
+		NormalizeEmail(email),
+	).Scan(&u.ID, &u.Email, pq.Array(&u.Roles), &u.TOTP, &u.Verified, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get user")
+	}
+	return &u, nil
+}
@@ -0,0 +1,20 @@
+package main
+
+// Modules register themselves on import, see pkg/module.
+import (
+	_ "github.com/agalitsyn/goapi/internal/activity"
+	_ "github.com/agalitsyn/goapi/internal/article"
+	_ "github.com/agalitsyn/goapi/internal/attachment"
+	_ "github.com/agalitsyn/goapi/internal/audit"
+	_ "github.com/agalitsyn/goapi/internal/author"
+	_ "github.com/agalitsyn/goapi/internal/cdc"
+	_ "github.com/agalitsyn/goapi/internal/moderation"
+	_ "github.com/agalitsyn/goapi/internal/notification"
+	_ "github.com/agalitsyn/goapi/internal/partner"
+	_ "github.com/agalitsyn/goapi/internal/privacy"
+	_ "github.com/agalitsyn/goapi/internal/revocation"
+	_ "github.com/agalitsyn/goapi/internal/scim"
+	_ "github.com/agalitsyn/goapi/internal/serviceauth"
+	_ "github.com/agalitsyn/goapi/internal/usage"
+	_ "github.com/agalitsyn/goapi/internal/user"
+)
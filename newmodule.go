@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/internal/scaffold"
+)
+
+// newModuleCommand generates a module in internal folder and prints how to register it, e.g.
+//
+//	goapi new-module comment
+type newModuleCommand struct {
+	Dir  string `long:"dir" default:"internal" description:"Path to folder of modules."`
+	Args struct {
+		Name string `positional-arg-name:"name" description:"Name of module, a lowercase Go package name."`
+	} `positional-args:"true" required:"true"`
+}
+
+func (c *newModuleCommand) Execute(args []string) error {
+	if len(args) > 0 {
+		return errors.Errorf("unexpected arguments: %v", args)
+	}
+	name := c.Args.Name
+	files, err := scaffold.Generate(c.Dir, name)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		fmt.Fprintln(os.Stdout, "created", f)
+	}
+	fmt.Fprintf(os.Stdout, `
+Register module in modules.go:
+
+	_ "github.com/agalitsyn/goapi/internal/%s"
+`, name)
+	return nil
+}
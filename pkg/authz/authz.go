@@ -0,0 +1,252 @@
+// Package authz evaluates authorization policies of API requests, e.g. who may update which article
+// or which tenant data user may reach, before requests reach handlers.
+//
+// Policy is a list of rules, each is an expression of a subset of CEL, see Expr for its grammar,
+// guarding requests which method and route pattern match the rule. Request is allowed when all rules matching
+// it allow it, requests no rule matches are left to handlers. Rules which fail to evaluate deny request.
+//
+// Expressions see variables:
+//
+//	user       {"id": "42", "roles": ["admin"]}, null for anonymous requests
+//	real_user  user who authenticated request, it differs from user while admin impersonates user
+//	tenant     tenant of request, empty if none
+//	request    {"method": "PUT", "path": "/1.0/articles/5", "params": {"articleID": "5"}, "query": {"force": "true"}}
+//	resource   attributes of resource rule names, e.g. {"id": "5", "owner_id": "42"}, null if it is not found
+package authz
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// metrics are exposed with expvar as authz.<rule>.{allowed,denied,failed}.
+var metrics = expvar.NewMap("authz")
+
+// ResourcePrefix is a prefix of names modules provide resources with, e.g. authz.resource.article.
+const ResourcePrefix = "authz.resource."
+
+// Resource returns attributes of resource by parameters of request path, nil if it is not found.
+type Resource func(ctx context.Context, params map[string]string) (map[string]interface{}, error)
+
+// Rule guards requests which method and route pattern match it.
+type Rule struct {
+	// Methods are matched case insensitively, * matches any method.
+	Methods []string
+	// Pattern is a path with {name} segments, which are passed as request.params, and optional
+	// trailing /* matching any suffix, e.g. /1.0/articles/{articleID}.
+	Pattern string
+	// Resource is a name of resource loaded for expression, none if empty.
+	Resource string
+	Expr     *Expr
+}
+
+// String is the form rule is parsed from, it names rule in logs and metrics.
+func (rule Rule) String() string {
+	s := strings.Join(rule.Methods, ",") + " " + rule.Pattern
+	if rule.Resource != "" {
+		s += " " + rule.Resource
+	}
+	return s + " => " + rule.Expr.String()
+}
+
+// ParseRule parses rule in form "METHODS PATTERN [RESOURCE] => EXPRESSION", where methods are comma
+// separated, e.g. PUT,DELETE /1.0/articles/{articleID} article => resource.owner_id == user.id.
+func ParseRule(s string) (Rule, error) {
+	parts := strings.SplitN(s, "=>", 2)
+	if len(parts) != 2 {
+		return Rule{}, errors.Errorf("invalid authz rule %q, expected METHODS PATTERN [RESOURCE] => EXPRESSION", s)
+	}
+	fields := strings.Fields(parts[0])
+	if len(fields) < 2 || len(fields) > 3 || !strings.HasPrefix(fields[1], "/") {
+		return Rule{}, errors.Errorf("invalid authz rule %q, expected METHODS PATTERN [RESOURCE] => EXPRESSION", s)
+	}
+	rule := Rule{Methods: strings.Split(strings.ToUpper(fields[0]), ","), Pattern: fields[1]}
+	if len(fields) == 3 {
+		rule.Resource = fields[2]
+	}
+	expr, err := Compile(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return Rule{}, errors.Wrapf(err, "invalid authz rule %q", s)
+	}
+	rule.Expr = expr
+	return rule, nil
+}
+
+// match reports whether rule matches method and pattern of route request is routed to.
+func (rule Rule) match(method, route string) bool {
+	methodOK := false
+	for _, m := range rule.Methods {
+		if m == "*" || m == method {
+			methodOK = true
+			break
+		}
+	}
+	return methodOK && rule.matchRoute(route)
+}
+
+// matchRoute reports whether rule pattern matches route pattern.
+func (rule Rule) matchRoute(route string) bool {
+	// routes of mounted routers end with slash, e.g. /1.0/articles/
+	pattern, route := strings.TrimSuffix(rule.Pattern, "/"), strings.TrimSuffix(route, "/")
+	if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern && strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(route+"/", prefix)
+	}
+	return pattern == route
+}
+
+// Engine is safe for concurrent use.
+type Engine struct {
+	rules     []Rule
+	resources map[string]Resource
+	routes    *handler.RouteTable
+}
+
+// New returns engine, resources are loaded by names rules refer to, all of them must be present.
+// Rules match patterns of routes requests are routed to, routes may be compiled after engine is
+// created, but before it serves requests and is checked.
+func New(rules []Rule, resources map[string]Resource, routes *handler.RouteTable) (*Engine, error) {
+	for _, rule := range rules {
+		if _, ok := resources[rule.Resource]; rule.Resource != "" && !ok {
+			return nil, errors.Errorf("unknown resource %s of authz rule %q", rule.Resource, rule)
+		}
+	}
+	return &Engine{rules: rules, resources: resources, routes: routes}, nil
+}
+
+// Check returns error if a rule matches no route, e.g. its pattern names parameter other than route does,
+// as such rule would never guard requests.
+func (e *Engine) Check() error {
+	for _, rule := range e.rules {
+		matched := false
+		for _, route := range e.routes.Routes() {
+			if rule.matchRoute(route.Pattern) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return errors.Errorf("authz rule %q matches no route", rule)
+		}
+	}
+	return nil
+}
+
+// Decision of a rule on request.
+type Decision struct {
+	Rule    Rule
+	Allowed bool
+	// Err is why rule could not be evaluated, request is denied then.
+	Err error
+}
+
+// Decide evaluates rules matching request until one denies it, decisions are returned in order of rules.
+func (e *Engine) Decide(r *http.Request) []Decision {
+	// path is matched as router matches it, escaped if it has escaped slashes
+	path := r.URL.Path
+	if r.URL.RawPath != "" {
+		path = r.URL.RawPath
+	}
+	route := e.routes.Match(path)
+	if route == nil {
+		return nil
+	}
+	var (
+		decisions []Decision
+		params    map[string]string
+	)
+	for _, rule := range e.rules {
+		if !rule.match(r.Method, route.Pattern) {
+			continue
+		}
+		if params == nil {
+			params = route.Params(path)
+		}
+		d := Decision{Rule: rule}
+		vars, err := e.vars(r, rule, params)
+		if err == nil {
+			d.Allowed, err = rule.Expr.Eval(vars)
+		}
+		d.Err = err
+		decisions = append(decisions, d)
+		if !d.Allowed {
+			break
+		}
+	}
+	return decisions
+}
+
+func (e *Engine) vars(r *http.Request, rule Rule, params map[string]string) (map[string]interface{}, error) {
+	query := make(map[string]interface{})
+	for k, v := range r.URL.Query() {
+		query[k] = v[0]
+	}
+	vars := map[string]interface{}{
+		"user":      user(reqctx.GetUser(r.Context())),
+		"real_user": user(reqctx.GetRealUser(r.Context())),
+		"tenant":    reqctx.GetTenant(r.Context()),
+		"request": map[string]interface{}{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"params": Value(params),
+			"query":  query,
+		},
+		"resource": nil,
+	}
+	if rule.Resource != "" {
+		res, err := e.resources[rule.Resource](r.Context(), params)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not load %s", rule.Resource)
+		}
+		if res != nil {
+			vars["resource"] = res
+		}
+	}
+	return vars, nil
+}
+
+func user(u *reqctx.User) interface{} {
+	if u == nil {
+		return nil
+	}
+	return map[string]interface{}{"id": u.ID, "roles": Value(u.Roles)}
+}
+
+// Middleware rejects requests policy denies with 403, every decision is logged: denials as warnings,
+// failures as errors and allowances at debug level.
+func Middleware(e *Engine) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, d := range e.Decide(r) {
+				logger := log.GetLogEntry(r).WithField("context", "authz").WithField("rule", d.Rule.String())
+				if u := reqctx.GetUser(r.Context()); u != nil {
+					logger = logger.WithField("user", u.ID)
+				}
+				switch {
+				case d.Err != nil:
+					metrics.Add(d.Rule.String()+".failed", 1)
+					logger.WithError(d.Err).Error("request is denied, rule failed")
+				case !d.Allowed:
+					metrics.Add(d.Rule.String()+".denied", 1)
+					logger.Warn("request is denied")
+				default:
+					metrics.Add(d.Rule.String()+".allowed", 1)
+					logger.Debug("request is allowed")
+					continue
+				}
+				render.Render(w, r, handler.ErrForbidden(i18n.Errorf("authz.denied")))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
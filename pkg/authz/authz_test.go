@@ -0,0 +1,218 @@
+package authz
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+func TestExpr(t *testing.T) {
+	vars := map[string]interface{}{
+		"user":    map[string]interface{}{"id": "42", "roles": []string{"editor"}},
+		"request": map[string]interface{}{"path": "/1.0/articles/5", "params": map[string]string{"id": "5"}},
+		"n":       3,
+		"none":    nil,
+	}
+	tests := []struct {
+		src  string
+		want bool
+		err  bool
+	}{
+		{`user.id == "42"`, true, false},
+		{`user.id != '42' || "editor" in user.roles`, true, false},
+		{`"admin" in user.roles && user.id == "42"`, false, false},
+		{`!("admin" in user.roles)`, true, false},
+		{`request.path.startsWith("/1.0/articles/") && request.params["id"] == "5"`, true, false},
+		{`"id" in request.params && size(user.roles) == 1`, true, false},
+		{`n > 2 && n <= 3 && "b" < "c" && [1, 2] == [1, 2]`, true, false},
+		{`none == null && !has(user.tenant)`, true, false},
+		// right side is not evaluated when left one decides
+		{`has(user.tenant) && user.tenant == "acme"`, false, false},
+		{`user.tenant == "acme"`, false, true},
+		{`user.id`, false, true},
+		{`n > "2"`, false, true},
+		{`unknown == 1`, false, true},
+	}
+	for _, tc := range tests {
+		e, err := Compile(tc.src)
+		if err != nil {
+			t.Errorf("could not compile %s: %v", tc.src, err)
+			continue
+		}
+		got, err := e.Eval(vars)
+		if (err != nil) != tc.err || got != tc.want {
+			t.Errorf("%s = %v, %v, want %v", tc.src, got, err, tc.want)
+		}
+	}
+
+	for _, src := range []string{`user.id ==`, `(true`, `"open`, `user.id = "1"`, `size(1, 2)`, `has(user)`, `x.lower()`, `a b`} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("invalid expression %s is compiled", src)
+		}
+	}
+}
+
+// Syntax of CEL outside of the documented subset is rejected rather than misread.
+func TestExpr_Unsupported(t *testing.T) {
+	for _, src := range []string{
+		`n + 1 > 2`,
+		`n > -1`,
+		`n % 2 == 0`,
+		`user != null ? user.id == "1" : false`,
+		`n > 1.5`,
+		`n == 1u`,
+		`user.id == b"42"`,
+		`user.id == r"42"`,
+		`user.id == """42"""`,
+		`{"id": "42"} == user`,
+		`user.roles.all(r, r != "admin")`,
+		`user.roles.exists(r, r == "admin")`,
+		`size(user.roles.filter(r, r == "admin")) > 0`,
+		`int(request.params["id"]) > 0`,
+		`user.id.matches("^4")`,
+		`0 < n < 5`,
+	} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("unsupported expression %s is compiled", src)
+		}
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	rule, err := ParseRule(`put,DELETE /1.0/articles/{articleID} article => resource.owner_id == user.id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rule.Methods) != 2 || rule.Methods[0] != "PUT" || rule.Pattern != "/1.0/articles/{articleID}" || rule.Resource != "article" {
+		t.Errorf("unexpected rule: %+v", rule)
+	}
+	if !rule.match("PUT", "/1.0/articles/{articleID}") {
+		t.Error("route is not matched")
+	}
+	if rule.match("PUT", "/1.0/articles/{articleID}/translations") {
+		t.Error("longer route is matched")
+	}
+	if rule.match("PUT", "/1.0/articles/{id}") {
+		t.Error("route with other parameter is matched")
+	}
+	if rule.match("GET", "/1.0/articles/{articleID}") {
+		t.Error("other method is matched")
+	}
+
+	rule, err = ParseRule(`* /1.0/tenants/{tenant}/* => request.params.tenant == tenant`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rule.match("GET", "/1.0/tenants/{tenant}/articles/{articleID}") || !rule.match("GET", "/1.0/tenants/{tenant}/") {
+		t.Error("route is not matched")
+	}
+
+	for _, s := range []string{`PUT /1.0/articles`, `PUT => true`, `PUT articles => true`, `PUT /1.0/articles => user.id ==`} {
+		if _, err := ParseRule(s); err == nil {
+			t.Errorf("invalid rule %s is parsed", s)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var rules []Rule
+	for _, s := range []string{
+		`PUT,DELETE /1.0/articles/{articleID} article => resource == null || resource.owner_id == user.id || "admin" in user.roles`,
+		`* /1.0/articles/{articleID} => user != null`,
+	} {
+		rule, err := ParseRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	routes := &handler.RouteTable{}
+	if _, err := New(rules, nil, routes); err == nil {
+		t.Error("rule with unknown resource is accepted")
+	}
+	e, err := New(rules, map[string]Resource{
+		"article": func(ctx context.Context, params map[string]string) (map[string]interface{}, error) {
+			if params["articleID"] != "5" {
+				return nil, nil
+			}
+			return map[string]interface{}{"id": "5", "owner_id": "42"}, nil
+		},
+	}, routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var user *reqctx.User
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(reqctx.With(r.Context(), reqctx.Values{User: user})))
+		})
+	})
+	r.Use(Middleware(e))
+	r.Route("/1.0/articles", func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+		r.Route("/{articleID}", func(r chi.Router) {
+			r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+			r.Put("/", func(w http.ResponseWriter, r *http.Request) {})
+			r.Delete("/", func(w http.ResponseWriter, r *http.Request) {})
+			r.Get("/translations", func(w http.ResponseWriter, r *http.Request) {})
+		})
+	})
+	routes.Compile(r)
+	if err := e.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		user   *reqctx.User
+		status int
+	}{
+		{http.MethodPut, "/1.0/articles/5", &reqctx.User{ID: "42"}, http.StatusOK},
+		{http.MethodPut, "/1.0/articles/5", &reqctx.User{ID: "7"}, http.StatusForbidden},
+		{http.MethodPut, "/1.0/articles/5", &reqctx.User{ID: "7", Roles: []string{"admin"}}, http.StatusOK},
+		// missing article is left to handler
+		{http.MethodDelete, "/1.0/articles/6", &reqctx.User{ID: "7"}, http.StatusOK},
+		{http.MethodGet, "/1.0/articles/5", nil, http.StatusForbidden},
+		// selecting id of null user fails, so request is denied
+		{http.MethodPut, "/1.0/articles/5", nil, http.StatusForbidden},
+		{http.MethodGet, "/1.0/articles", nil, http.StatusOK},
+		{http.MethodGet, "/1.0/articles/5/translations", nil, http.StatusOK},
+		// escaped slash is a part of parameter as router sees it, so rule still guards request
+		{http.MethodPut, "/1.0/articles/a%2Fb/", nil, http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		user = tc.user
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, "http://example.com"+tc.path, nil))
+		if w.Code != tc.status {
+			t.Errorf("unexpected status of %s %s by %+v: %v", tc.method, tc.path, tc.user, w.Code)
+		}
+	}
+}
+
+func TestEngine_Check(t *testing.T) {
+	rule, err := ParseRule(`PUT /1.0/articles/{id} => true`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Put("/1.0/articles/{articleID}", func(w http.ResponseWriter, r *http.Request) {})
+	e, err := New([]Rule{rule}, nil, handler.NewRouteTable(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Check(); err == nil {
+		t.Error("rule matching no route is accepted")
+	}
+}
@@ -0,0 +1,681 @@
+package authz
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Expr is a compiled expression of a subset of CEL, https://github.com/google/cel-spec. Its grammar
+// from the lowest precedence to the highest one is:
+//
+//	expr     = and { "||" and }
+//	and      = relation { "&&" relation }
+//	relation = unary [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" | "in" ) unary ]
+//	unary    = "!" unary | postfix
+//	postfix  = primary { "." ident [ "(" [ args ] ")" ] | "[" expr "]" }
+//	primary  = int | string | "true" | "false" | "null" | ident | "[" [ args ] "]" | "(" expr ")"
+//	         | ( "size" | "has" ) "(" expr ")"
+//	args     = expr { "," expr }
+//
+// Integers are decimal without sign, strings are quoted with " or ' and escape \n, \t and other
+// characters with \. Identifiers are variables, x.f and x["f"] select field of map, x[0] is an
+// element of list. Methods s.startsWith(p), s.endsWith(p) and s.contains(p) take strings, size(x) is
+// length of string, list or map and has(x.f) reports whether map x has field f.
+//
+// Values are strings, integers, booleans, null, lists and maps with string keys. == and != compare
+// values of any types, values of different types are not equal, < <= > >= compare two integers or
+// two strings, x in y checks whether list y has element x or map y has key x. && and || take
+// booleans and short circuit. Selecting a missing field of map is an error, has() checks whether it
+// is present.
+//
+// The rest of CEL is not supported and expressions using it are not compiled: arithmetic and
+// negative numbers, ?: operator, floating point and unsigned numbers, bytes, raw and triple-quoted
+// strings, map literals, macros such as all, exists, map and filter, other functions and methods,
+// e.g. int(x) and s.matches(re), and chained relations such as a < b < c.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Compile parses expression.
+func Compile(src string) (*Expr, error) {
+	p := &parser{lex: lexer{src: src}}
+	p.next()
+	root, err := p.or()
+	if err == nil && p.tok.kind != tokEOF {
+		err = p.errorf("unexpected %s", p.tok)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid expression %q", src)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates expression to boolean, vars are normalized with Value.
+func (e *Expr) Eval(vars map[string]interface{}) (bool, error) {
+	v, err := e.root.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, errors.Errorf("expression evaluates to %s, not bool", typeName(v))
+	}
+	return b, nil
+}
+
+// Value converts Go values to values of expressions, e.g. int to int64 and []string to list.
+func Value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, string, int64, bool, []interface{}, map[string]interface{}:
+		return v
+	case int:
+		return int64(v)
+	case []string:
+		list := make([]interface{}, len(v))
+		for i, s := range v {
+			list[i] = s
+		}
+		return list
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+		for k, s := range v {
+			m[k] = s
+		}
+		return m
+	}
+	return fmt.Sprint(v)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+var puncts = []string{"==", "!=", "<=", ">=", "&&", "||", "(", ")", "[", "]", ".", ",", "!", "<", ">"}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(rune(l.src[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c, size := utf8.DecodeRuneInString(l.src[l.pos:])
+	switch {
+	case c == '_' || unicode.IsLetter(c):
+		for l.pos < len(l.src) {
+			c, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				break
+			}
+			l.pos += size
+		}
+		return token{kind: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+	case unicode.IsDigit(c):
+		for l.pos < len(l.src) && unicode.IsDigit(rune(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokInt, text: l.src[start:l.pos], pos: start}, nil
+	case c == '"' || c == '\'':
+		var b strings.Builder
+		l.pos += size
+		for l.pos < len(l.src) {
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			l.pos += size
+			switch {
+			case r == c:
+				return token{kind: tokString, text: b.String(), pos: start}, nil
+			case r == '\\' && l.pos < len(l.src):
+				r, size = utf8.DecodeRuneInString(l.src[l.pos:])
+				l.pos += size
+				switch r {
+				case 'n':
+					r = '\n'
+				case 't':
+					r = '\t'
+				}
+			}
+			b.WriteRune(r)
+		}
+		return token{}, errors.Errorf("unterminated string at %d", start)
+	}
+	for _, p := range puncts {
+		if strings.HasPrefix(l.src[l.pos:], p) {
+			l.pos += len(p)
+			return token{kind: tokPunct, text: p, pos: start}, nil
+		}
+	}
+	return token{}, errors.Errorf("unexpected %q at %d", c, start)
+}
+
+// parser is a recursive descent parser, each method parses a level of precedence.
+type parser struct {
+	lex lexer
+	tok token
+	err error
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	return errors.Errorf(format+" at %d", append(args, p.tok.pos)...)
+}
+
+func (p *parser) is(punct string) bool {
+	return p.err == nil && p.tok.kind == tokPunct && p.tok.text == punct
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.is(punct) {
+		return p.errorf("expected %q, got %s", punct, p.tok)
+	}
+	p.next()
+	return p.err
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	for err == nil && p.is("||") {
+		p.next()
+		var right node
+		right, err = p.and()
+		left = &binary{op: "||", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.relation()
+	for err == nil && p.is("&&") {
+		p.next()
+		var right node
+		right, err = p.relation()
+		left = &binary{op: "&&", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *parser) relation() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	op := ""
+	switch {
+	case p.tok.kind == tokPunct && strings.Contains(" == != < <= > >= ", " "+p.tok.text+" "):
+		op = p.tok.text
+	case p.tok.kind == tokIdent && p.tok.text == "in":
+		op = "in"
+	default:
+		return left, p.err
+	}
+	p.next()
+	right, err := p.unary()
+	return &binary{op: op, left: left, right: right}, err
+}
+
+func (p *parser) unary() (node, error) {
+	if p.is("!") {
+		p.next()
+		x, err := p.unary()
+		return &not{x: x}, err
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (node, error) {
+	x, err := p.primary()
+	for err == nil {
+		switch {
+		case p.is("."):
+			p.next()
+			if p.tok.kind != tokIdent {
+				return nil, p.errorf("expected field name, got %s", p.tok)
+			}
+			name := p.tok.text
+			p.next()
+			if !p.is("(") {
+				x = &selectNode{x: x, field: name}
+				continue
+			}
+			var args []node
+			if args, err = p.args(); err == nil {
+				x, err = newMethod(x, name, args)
+			}
+		case p.is("["):
+			p.next()
+			var index node
+			if index, err = p.or(); err == nil {
+				err = p.expect("]")
+			}
+			x = &indexNode{x: x, index: index}
+		default:
+			return x, p.err
+		}
+	}
+	return nil, err
+}
+
+func (p *parser) args() ([]node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []node
+	for !p.is(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+	return args, p.err
+}
+
+func (p *parser) primary() (node, error) {
+	tok := p.tok
+	switch {
+	case p.err != nil:
+		return nil, p.err
+	case tok.kind == tokInt:
+		p.next()
+		n, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid integer %s at %d", tok.text, tok.pos)
+		}
+		return literal{n}, p.err
+	case tok.kind == tokString:
+		p.next()
+		return literal{tok.text}, p.err
+	case tok.kind == tokIdent:
+		p.next()
+		switch tok.text {
+		case "true", "false":
+			return literal{tok.text == "true"}, p.err
+		case "null":
+			return literal{nil}, p.err
+		case "size", "has":
+			if !p.is("(") {
+				break
+			}
+			args, err := p.args()
+			if err != nil {
+				return nil, err
+			}
+			return newFunction(tok, args)
+		}
+		return ident(tok.text), p.err
+	case p.is("("):
+		p.next()
+		x, err := p.or()
+		if err == nil {
+			err = p.expect(")")
+		}
+		return x, err
+	case p.is("["):
+		p.next()
+		var list listNode
+		for !p.is("]") {
+			if len(list) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			x, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, x)
+		}
+		p.next()
+		return list, p.err
+	}
+	return nil, p.errorf("unexpected %s", tok)
+}
+
+func newFunction(tok token, args []node) (node, error) {
+	if len(args) != 1 {
+		return nil, errors.Errorf("%s expects 1 argument at %d", tok.text, tok.pos)
+	}
+	if tok.text == "size" {
+		return &sizeNode{x: args[0]}, nil
+	}
+	sel, ok := args[0].(*selectNode)
+	if !ok {
+		return nil, errors.Errorf("has expects field selection, e.g. has(x.field), at %d", tok.pos)
+	}
+	return &hasNode{x: sel.x, field: sel.field}, nil
+}
+
+var methods = map[string]func(s, arg string) bool{
+	"startsWith": strings.HasPrefix,
+	"endsWith":   strings.HasSuffix,
+	"contains":   strings.Contains,
+}
+
+func newMethod(target node, name string, args []node) (node, error) {
+	fn, ok := methods[name]
+	if !ok {
+		return nil, errors.Errorf("unknown function %s", name)
+	}
+	if len(args) != 1 {
+		return nil, errors.Errorf("%s expects 1 argument", name)
+	}
+	return &methodNode{target: target, name: name, fn: fn, arg: args[0]}, nil
+}
+
+type node interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type literal struct{ v interface{} }
+
+func (n literal) eval(vars map[string]interface{}) (interface{}, error) {
+	return n.v, nil
+}
+
+type ident string
+
+func (n ident) eval(vars map[string]interface{}) (interface{}, error) {
+	v, ok := vars[string(n)]
+	if !ok {
+		return nil, errors.Errorf("undeclared variable %s", string(n))
+	}
+	return Value(v), nil
+}
+
+type listNode []node
+
+func (n listNode) eval(vars map[string]interface{}) (interface{}, error) {
+	list := make([]interface{}, len(n))
+	for i, x := range n {
+		v, err := x.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+type selectNode struct {
+	x     node
+	field string
+}
+
+func (n *selectNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("can not select %s of %s", n.field, typeName(v))
+	}
+	f, ok := m[n.field]
+	if !ok {
+		return nil, errors.Errorf("no such key: %s", n.field)
+	}
+	return Value(f), nil
+}
+
+type hasNode struct {
+	x     node
+	field string
+}
+
+func (n *hasNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("can not select %s of %s", n.field, typeName(v))
+	}
+	_, ok = m[n.field]
+	return ok, nil
+}
+
+type indexNode struct {
+	x, index node
+}
+
+func (n *indexNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	i, err := n.index.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if k, ok := i.(string); ok {
+			f, ok := v[k]
+			if !ok {
+				return nil, errors.Errorf("no such key: %s", k)
+			}
+			return Value(f), nil
+		}
+	case []interface{}:
+		if k, ok := i.(int64); ok {
+			if k < 0 || k >= int64(len(v)) {
+				return nil, errors.Errorf("index %d out of range", k)
+			}
+			return v[k], nil
+		}
+	}
+	return nil, errors.Errorf("can not index %s with %s", typeName(v), typeName(i))
+}
+
+type sizeNode struct{ x node }
+
+func (n *sizeNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case string:
+		return int64(utf8.RuneCountInString(v)), nil
+	case []interface{}:
+		return int64(len(v)), nil
+	case map[string]interface{}:
+		return int64(len(v)), nil
+	}
+	return nil, errors.Errorf("no size of %s", typeName(v))
+}
+
+type methodNode struct {
+	target node
+	name   string
+	fn     func(s, arg string) bool
+	arg    node
+}
+
+func (n *methodNode) eval(vars map[string]interface{}) (interface{}, error) {
+	t, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	a, err := n.arg.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	s, ok1 := t.(string)
+	arg, ok2 := a.(string)
+	if !ok1 || !ok2 {
+		return nil, errors.Errorf("%s expects strings, got %s and %s", n.name, typeName(t), typeName(a))
+	}
+	return n.fn(s, arg), nil
+}
+
+type not struct{ x node }
+
+func (n *not) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, errors.Errorf("can not negate %s", typeName(v))
+	}
+	return !b, nil
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n *binary) eval(vars map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" || n.op == "||" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, errors.Errorf("%s expects bool, got %s", n.op, typeName(l))
+		}
+		// short circuit, so right side may rely on left one, e.g. has(x.f) && x.f == 1
+		if lb == (n.op == "||") {
+			return lb, nil
+		}
+	}
+	r, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, errors.Errorf("%s expects bool, got %s", n.op, typeName(r))
+		}
+		return rb, nil
+	case "==":
+		return reflect.DeepEqual(l, r), nil
+	case "!=":
+		return !reflect.DeepEqual(l, r), nil
+	case "in":
+		switch r := r.(type) {
+		case []interface{}:
+			for _, v := range r {
+				if reflect.DeepEqual(l, v) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			k, ok := l.(string)
+			if !ok {
+				return nil, errors.Errorf("map keys are strings, not %s", typeName(l))
+			}
+			_, ok = r[k]
+			return ok, nil
+		}
+		return nil, errors.Errorf("in expects list or map, got %s", typeName(r))
+	}
+
+	var cmp int
+	switch l := l.(type) {
+	case int64:
+		ri, ok := r.(int64)
+		if !ok {
+			return nil, errors.Errorf("can not compare int with %s", typeName(r))
+		}
+		if l < ri {
+			cmp = -1
+		} else if l > ri {
+			cmp = 1
+		}
+	case string:
+		rs, ok := r.(string)
+		if !ok {
+			return nil, errors.Errorf("can not compare string with %s", typeName(r))
+		}
+		cmp = strings.Compare(l, rs)
+	default:
+		return nil, errors.Errorf("can not compare %s", typeName(l))
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case int64:
+		return "int"
+	case bool:
+		return "bool"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
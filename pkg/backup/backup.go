@@ -0,0 +1,389 @@
+// Package backup dumps application data to an archive and restores it, so small deployments
+// do not need pg_dump of the same version as the server.
+//
+// Archive is a gzipped tar with manifest.json followed by <table>.ndjson files, a row per line
+// as Postgres serializes it to JSON. Manifest lists tables in restore order with row counts and
+// SHA-256 checksums, and migrations the data was dumped at. Restore runs in a single transaction
+// and commits only when every table matches its checksum.
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+// FormatVersion is incremented on incompatible changes of archive layout.
+const FormatVersion = 1
+
+const manifestName = "manifest.json"
+
+// restoreBatch is how many rows are inserted per statement.
+const restoreBatch = 500
+
+// Manifest describes archive content.
+type Manifest struct {
+	Version    int       `json:"version"`
+	CreatedAt  time.Time `json:"created_at"`
+	Migrations []string  `json:"migrations"`
+	// Tables are in order they are restored in, referenced tables first.
+	Tables []Table `json:"tables"`
+}
+
+type Table struct {
+	Name   string `json:"name"`
+	Rows   int64  `json:"rows"`
+	SHA256 string `json:"sha256"`
+}
+
+// Dump writes archive of all tables except migrations to w. Tables are read in a single
+// read-only transaction, so archive is consistent while the service keeps running.
+func Dump(db *sql.DB, w io.Writer) (*Manifest, error) {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not begin transaction")
+	}
+	defer tx.Rollback()
+
+	tables, err := tableOrder(tx)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := appliedMigrations(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// tables are spooled to temporary files, as manifest with checksums goes first
+	dir, err := ioutil.TempDir("", "goapi-backup")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create temporary folder")
+	}
+	defer os.RemoveAll(dir)
+
+	m := &Manifest{Version: FormatVersion, CreatedAt: time.Now().UTC(), Migrations: migrations}
+	files := make([]*os.File, 0, len(tables))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, name := range tables {
+		f, err := ioutil.TempFile(dir, "table")
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create temporary file")
+		}
+		files = append(files, f)
+		t, err := dumpTable(tx, name, f)
+		if err != nil {
+			return nil, err
+		}
+		m.Tables = append(m.Tables, t)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode manifest")
+	}
+	if err := writeEntry(tw, manifestName, int64(len(manifest)), bytes.NewReader(manifest)); err != nil {
+		return nil, err
+	}
+	for i, f := range files {
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not stat temporary file")
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, errors.Wrap(err, "could not rewind temporary file")
+		}
+		if err := writeEntry(tw, m.Tables[i].Name+".ndjson", fi.Size(), f); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, errors.Wrap(err, "could not write archive")
+	}
+	if err := gz.Close(); err != nil {
+		return nil, errors.Wrap(err, "could not write archive")
+	}
+	return m, nil
+}
+
+func writeEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0600, Size: size, ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return errors.Wrapf(err, "could not write %s", name)
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return errors.Wrapf(err, "could not write %s", name)
+	}
+	return nil
+}
+
+func dumpTable(q postgres.Querier, name string, w io.Writer) (Table, error) {
+	t := Table{Name: name}
+	rows, err := q.Query(fmt.Sprintf("SELECT row_to_json(t)::text FROM %s t;", pq.QuoteIdentifier(name)))
+	if err != nil {
+		return t, errors.Wrapf(err, "could not dump %s", name)
+	}
+	defer rows.Close()
+
+	h := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(w, h))
+	for rows.Next() {
+		var row string
+		if err := rows.Scan(&row); err != nil {
+			return t, errors.Wrapf(err, "could not dump %s", name)
+		}
+		bw.WriteString(row)
+		bw.WriteByte('\n')
+		t.Rows++
+	}
+	if err := rows.Err(); err != nil {
+		return t, errors.Wrapf(err, "could not dump %s", name)
+	}
+	if err := bw.Flush(); err != nil {
+		return t, errors.Wrapf(err, "could not dump %s", name)
+	}
+	t.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return t, nil
+}
+
+// tableOrder returns tables of current schema so that referenced tables go before referencing ones.
+func tableOrder(q postgres.Querier) ([]string, error) {
+	tables, err := firstColumn(q,
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' AND table_name <> 'migrations' ORDER BY table_name;",
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list tables")
+	}
+
+	rows, err := q.Query(
+		`SELECT c.relname, r.relname FROM pg_constraint f
+		JOIN pg_class c ON c.oid = f.conrelid JOIN pg_class r ON r.oid = f.confrelid
+		WHERE f.contype = 'f' AND f.connamespace = current_schema()::regnamespace;`,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list foreign keys")
+	}
+	defer rows.Close()
+	deps := make(map[string][]string)
+	for rows.Next() {
+		var table, referenced string
+		if err := rows.Scan(&table, &referenced); err != nil {
+			return nil, errors.Wrap(err, "could not list foreign keys")
+		}
+		if table != referenced {
+			deps[table] = append(deps[table], referenced)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not list foreign keys")
+	}
+
+	var order []string
+	visited := make(map[string]bool)
+	var visit func(t string)
+	visit = func(t string) {
+		if visited[t] {
+			return
+		}
+		visited[t] = true
+		sort.Strings(deps[t])
+		for _, d := range deps[t] {
+			visit(d)
+		}
+		order = append(order, t)
+	}
+	for _, t := range tables {
+		visit(t)
+	}
+	return order, nil
+}
+
+func appliedMigrations(q postgres.Querier) ([]string, error) {
+	ids, err := firstColumn(q, "SELECT id FROM migrations ORDER BY id;")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list migrations")
+	}
+	return ids, nil
+}
+
+// firstColumn returns the first column of rows.
+func firstColumn(q postgres.Querier, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
+
+// Restore replaces data of tables in archive. It refuses to overwrite existing data unless force is set,
+// and requires database to be migrated exactly as the one archive was dumped from. Triggers do not fire
+// while data is restored, which needs a role allowed to set session_replication_role, e.g. superuser.
+func Restore(db *sql.DB, r io.Reader, force bool) (*Manifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read archive")
+	}
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != manifestName {
+		return nil, errors.New("archive does not start with manifest")
+	}
+	var m Manifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, errors.Wrap(err, "could not decode manifest")
+	}
+	if m.Version != FormatVersion {
+		return nil, errors.Errorf("unsupported archive version %d", m.Version)
+	}
+
+	err = postgres.Tx(db, false, func(tx postgres.Querier) error {
+		migrations, err := appliedMigrations(tx)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(migrations, m.Migrations) {
+			return errors.Errorf("database schema differs from archive: migrated to %s, archive is made at %s", last(migrations), last(m.Migrations))
+		}
+
+		names := make([]string, len(m.Tables))
+		for i, t := range m.Tables {
+			names[i] = pq.QuoteIdentifier(t.Name)
+		}
+		if !force {
+			for i, name := range names {
+				var exists bool
+				if err := tx.QueryRow(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s);", name)).Scan(&exists); err != nil {
+					return errors.Wrapf(err, "could not check %s", m.Tables[i].Name)
+				}
+				if exists {
+					return errors.Errorf("table %s is not empty, restore with force to replace data", m.Tables[i].Name)
+				}
+			}
+		}
+		// rows are restored as they were, triggers must not capture them as changes or touch them,
+		// and foreign keys are consistent in archive as a whole, not table by table
+		if _, err := tx.Exec("SET LOCAL session_replication_role = replica;"); err != nil {
+			return errors.Wrap(err, "could not disable triggers")
+		}
+		if len(names) > 0 {
+			if _, err := tx.Exec(fmt.Sprintf("TRUNCATE %s CASCADE;", strings.Join(names, ", "))); err != nil {
+				return errors.Wrap(err, "could not truncate tables")
+			}
+		}
+
+		for _, t := range m.Tables {
+			hdr, err := tr.Next()
+			if err != nil || hdr.Name != t.Name+".ndjson" {
+				return errors.Errorf("archive is truncated, %s is missing", t.Name)
+			}
+			if err := restoreTable(tx, t, tr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func restoreTable(tx postgres.Querier, t Table, r io.Reader) error {
+	name := pq.QuoteIdentifier(t.Name)
+	query := fmt.Sprintf("INSERT INTO %s SELECT * FROM json_populate_recordset(NULL::%s, $1::json);", name, name)
+
+	h := sha256.New()
+	sc := bufio.NewScanner(io.TeeReader(r, h))
+	// rows may contain large bodies
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var rows int64
+	var batch []string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := tx.Exec(query, "["+strings.Join(batch, ",")+"]"); err != nil {
+			return errors.Wrapf(err, "could not restore %s", t.Name)
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for sc.Scan() {
+		batch = append(batch, sc.Text())
+		rows++
+		if len(batch) == restoreBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return errors.Wrapf(err, "could not read %s", t.Name)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != t.SHA256 || rows != t.Rows {
+		return errors.Errorf("%s is corrupted: checksum or row count does not match manifest", t.Name)
+	}
+
+	// serial sequences continue after restored ids
+	columns, err := firstColumn(tx,
+		"SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 AND column_default LIKE 'nextval(%';",
+		t.Name,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "could not list serial columns of %s", t.Name)
+	}
+	for _, c := range columns {
+		_, err := tx.Exec(fmt.Sprintf(
+			"SELECT setval(pg_get_serial_sequence($1, $2), coalesce(max(%s), 0) + 1, false) FROM %s;",
+			pq.QuoteIdentifier(c), name,
+		), t.Name, c)
+		if err != nil {
+			return errors.Wrapf(err, "could not reset sequence of %s.%s", t.Name, c)
+		}
+	}
+	return nil
+}
+
+func last(ids []string) string {
+	if len(ids) == 0 {
+		return "none"
+	}
+	return ids[len(ids)-1]
+}
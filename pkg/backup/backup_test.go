@@ -0,0 +1,135 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func expectDump(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT table_name FROM information_schema.tables").
+		WillReturnRows(sqlmock.NewRows([]string{"table_name"}).AddRow("attachment").AddRow("article"))
+	mock.ExpectQuery("SELECT c.relname, r.relname FROM pg_constraint").
+		WillReturnRows(sqlmock.NewRows([]string{"relname", "relname"}).AddRow("attachment", "article"))
+	mock.ExpectQuery("SELECT id FROM migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("0001_initial").AddRow("0002_attachment_initial"))
+	mock.ExpectQuery(`SELECT row_to_json\(t\)::text FROM "article" t`).
+		WillReturnRows(sqlmock.NewRows([]string{"row"}).AddRow(`{"id":1,"title":"Hello"}`).AddRow(`{"id":2,"title":"World"}`))
+	mock.ExpectQuery(`SELECT row_to_json\(t\)::text FROM "attachment" t`).
+		WillReturnRows(sqlmock.NewRows([]string{"row"}))
+	mock.ExpectRollback()
+}
+
+func TestDumpRestore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectDump(mock)
+	var buf bytes.Buffer
+	m, err := Dump(db, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// referenced tables go first
+	if len(m.Tables) != 2 || m.Tables[0].Name != "article" || m.Tables[0].Rows != 2 || m.Tables[1].Name != "attachment" {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("0001_initial").AddRow("0002_attachment_initial"))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM "article"\)`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM "attachment"\)`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec("SET LOCAL session_replication_role = replica;").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`TRUNCATE "article", "attachment" CASCADE`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO "article" SELECT \* FROM json_populate_recordset\(NULL::"article", \$1::json\)`).
+		WithArgs(`[{"id":1,"title":"Hello"},{"id":2,"title":"World"}]`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery("SELECT column_name FROM information_schema.columns").WithArgs("article").
+		WillReturnRows(sqlmock.NewRows([]string{"column_name"}).AddRow("id"))
+	mock.ExpectExec(`SELECT setval\(pg_get_serial_sequence\(\$1, \$2\), coalesce\(max\("id"\), 0\) \+ 1, false\) FROM "article"`).
+		WithArgs("article", "id").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT column_name FROM information_schema.columns").WithArgs("attachment").
+		WillReturnRows(sqlmock.NewRows([]string{"column_name"}))
+	mock.ExpectCommit()
+
+	if _, err := Restore(db, bytes.NewReader(buf.Bytes()), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestRestore_Corrupted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectDump(mock)
+	var buf bytes.Buffer
+	if _, err := Dump(db, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// tamper with a row of uncompressed archive
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw = bytes.Replace(raw, []byte(`"Hello"`), []byte(`"Hellx"`), 1)
+	var tampered bytes.Buffer
+	zw := gzip.NewWriter(&tampered)
+	zw.Write(raw)
+	zw.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("0001_initial").AddRow("0002_attachment_initial"))
+	mock.ExpectExec("SET LOCAL session_replication_role").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("TRUNCATE").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO "article"`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectRollback()
+
+	_, err = Restore(db, &tampered, true)
+	if err == nil || !strings.Contains(err.Error(), "article is corrupted") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestRestore_SchemaMismatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectDump(mock)
+	var buf bytes.Buffer
+	if _, err := Dump(db, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM migrations").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("0001_initial"))
+	mock.ExpectRollback()
+	if _, err := Restore(db, &buf, true); err == nil || !strings.Contains(err.Error(), "schema differs") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
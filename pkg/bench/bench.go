@@ -0,0 +1,248 @@
+// Package bench drives a weighted mix of requests against a running instance
+// and reports throughput and latency percentiles per request.
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Request is a request of the mix, requests are sent proportionally to their weights.
+type Request struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Weight int             `json:"weight,omitempty"`
+}
+
+func (r Request) String() string {
+	return r.Method + " " + r.Path
+}
+
+// ParseRequest parses request in form [weight*]METHOD path, e.g. 3*GET /1.0/articles.
+func ParseRequest(s string) (Request, error) {
+	r := Request{Weight: 1}
+	if i := strings.IndexByte(s, '*'); i >= 0 {
+		w, err := strconv.Atoi(s[:i])
+		if err != nil || w <= 0 {
+			return Request{}, errors.Errorf("invalid request %q, weight must be positive integer", s)
+		}
+		r.Weight, s = w, s[i+1:]
+	}
+	parts := strings.Fields(s)
+	if len(parts) != 2 || !strings.HasPrefix(parts[1], "/") {
+		return Request{}, errors.Errorf("invalid request %q, expected [weight*]METHOD path", s)
+	}
+	r.Method, r.Path = strings.ToUpper(parts[0]), parts[1]
+	return r, nil
+}
+
+// ReadRequests reads requests in JSON lines format, e.g. {"method": "GET", "path": "/1.0/articles", "weight": 3}.
+func ReadRequests(rd io.Reader) ([]Request, error) {
+	var reqs []Request
+	s := bufio.NewScanner(rd)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		var r Request
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			return nil, errors.Wrapf(err, "could not parse request on line %d", line)
+		}
+		if r.Method == "" || r.Path == "" || r.Weight < 0 {
+			return nil, errors.Errorf("invalid request on line %d", line)
+		}
+		if r.Weight == 0 {
+			r.Weight = 1
+		}
+		reqs = append(reqs, r)
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read requests")
+	}
+	return reqs, nil
+}
+
+type Config struct {
+	// URL is base URL of instance under test.
+	URL      string
+	Requests []Request
+	Header   http.Header
+	// Concurrency is a number of clients sending requests one after another.
+	Concurrency int
+	// Duration of the run, it ends earlier when Count requests are sent.
+	Duration time.Duration
+	// Count limits the total number of requests, 0 means no limit.
+	Count   int
+	Timeout time.Duration
+}
+
+// Stat summarizes responses to a request of the mix.
+type Stat struct {
+	Request string
+	// Count includes failed requests.
+	Count int
+	// Errors are transport errors and 5xx responses.
+	Errors int
+
+	latencies []time.Duration
+}
+
+// Percentile returns latency p percent of successful responses fit in.
+func (s *Stat) Percentile(p int) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	return s.latencies[(len(s.latencies)-1)*p/100]
+}
+
+type Report struct {
+	Elapsed time.Duration
+	Stats   []*Stat
+	Total   *Stat
+}
+
+// Write prints report as a table.
+func (r *Report) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REQUEST\tCOUNT\tRPS\tERRORS\tP50\tP90\tP99\tMAX")
+	for _, s := range append(r.Stats, r.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%d\t%v\t%v\t%v\t%v\n",
+			s.Request, s.Count, float64(s.Count)/r.Elapsed.Seconds(), s.Errors,
+			s.Percentile(50), s.Percentile(90), s.Percentile(99), s.Percentile(100),
+		)
+	}
+	return tw.Flush()
+}
+
+// Run sends requests until duration elapses or count is reached.
+func Run(cfg Config) (*Report, error) {
+	if len(cfg.Requests) == 0 {
+		return nil, errors.New("no requests to send")
+	}
+	if cfg.Concurrency <= 0 {
+		return nil, errors.New("concurrency must be positive")
+	}
+	if cfg.Duration <= 0 && cfg.Count <= 0 {
+		return nil, errors.New("either duration or count must be set")
+	}
+
+	var totalWeight int
+	for _, r := range cfg.Requests {
+		totalWeight += r.Weight
+	}
+
+	var (
+		client   = &http.Client{Timeout: cfg.Timeout}
+		stats    = make([]*Stat, len(cfg.Requests))
+		mu       sync.Mutex
+		sent     int
+		deadline time.Time
+		wg       sync.WaitGroup
+	)
+	for i, r := range cfg.Requests {
+		stats[i] = &Stat{Request: r.String()}
+	}
+	start := time.Now()
+	if cfg.Duration > 0 {
+		deadline = start.Add(cfg.Duration)
+	}
+	// next reserves a request to send, false is returned when run is over
+	next := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if cfg.Count > 0 && sent >= cfg.Count || !deadline.IsZero() && !time.Now().Before(deadline) {
+			return false
+		}
+		sent++
+		return true
+	}
+
+	for c := 0; c < cfg.Concurrency; c++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for next() {
+				i := pick(cfg.Requests, rnd.Intn(totalWeight))
+				latency, err := send(client, cfg, cfg.Requests[i])
+
+				mu.Lock()
+				s := stats[i]
+				s.Count++
+				if err != nil {
+					s.Errors++
+				} else {
+					s.latencies = append(s.latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}(start.UnixNano() + int64(c))
+	}
+	wg.Wait()
+
+	report := &Report{Elapsed: time.Since(start), Stats: stats, Total: &Stat{Request: "TOTAL"}}
+	for _, s := range stats {
+		sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+		report.Total.Count += s.Count
+		report.Total.Errors += s.Errors
+		report.Total.latencies = append(report.Total.latencies, s.latencies...)
+	}
+	sort.Slice(report.Total.latencies, func(i, j int) bool { return report.Total.latencies[i] < report.Total.latencies[j] })
+	return report, nil
+}
+
+// pick returns index of request n falls to in weighted mix.
+func pick(reqs []Request, n int) int {
+	for i, r := range reqs {
+		if n < r.Weight {
+			return i
+		}
+		n -= r.Weight
+	}
+	return len(reqs) - 1
+}
+
+func send(client *http.Client, cfg Config, req Request) (time.Duration, error) {
+	var body io.Reader
+	if len(req.Body) > 0 {
+		body = bytes.NewReader(req.Body)
+	}
+	r, err := http.NewRequest(req.Method, strings.TrimSuffix(cfg.URL, "/")+req.Path, body)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not build request %s", req)
+	}
+	for k, v := range cfg.Header {
+		r.Header[k] = v
+	}
+	if body != nil && r.Header.Get("Content-Type") == "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	start := time.Now()
+	resp, err := client.Do(r)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return 0, errors.Errorf("%s responded with %s", req, resp.Status)
+	}
+	return latency, nil
+}
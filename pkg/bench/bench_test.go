@@ -0,0 +1,101 @@
+package bench
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		in    string
+		req   Request
+		valid bool
+	}{
+		{"GET /1.0/articles", Request{Method: "GET", Path: "/1.0/articles", Weight: 1}, true},
+		{"3*get /1.0/articles/1", Request{Method: "GET", Path: "/1.0/articles/1", Weight: 3}, true},
+		{"0*GET /1.0/articles", Request{}, false},
+		{"GET", Request{}, false},
+		{"GET 1.0/articles", Request{}, false},
+	}
+	for _, tt := range tests {
+		req, err := ParseRequest(tt.in)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: unexpected error: %v", tt.in, err)
+			continue
+		}
+		if req.String() != tt.req.String() || req.Weight != tt.req.Weight {
+			t.Errorf("%q: unexpected request: %+v", tt.in, req)
+		}
+	}
+}
+
+func TestReadRequests(t *testing.T) {
+	reqs, err := ReadRequests(strings.NewReader(`{"method": "GET", "path": "/a", "weight": 3}
+
+{"method": "POST", "path": "/b", "body": {"title": "x"}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 || reqs[0].Weight != 3 || reqs[1].Weight != 1 || string(reqs[1].Body) != `{"title": "x"}` {
+		t.Errorf("unexpected requests: %+v", reqs)
+	}
+}
+
+func TestRun(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		hits = map[string]int{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	report, err := Run(Config{
+		URL: srv.URL,
+		Requests: []Request{
+			{Method: "GET", Path: "/ok", Weight: 3},
+			{Method: "GET", Path: "/fail", Weight: 1},
+		},
+		Header:      http.Header{"Authorization": {"Bearer token"}},
+		Concurrency: 4,
+		Count:       400,
+		Timeout:     time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total.Count != 400 || hits["/ok"]+hits["/fail"] != 400 {
+		t.Fatalf("unexpected number of requests: %d %v", report.Total.Count, hits)
+	}
+	ok, fail := report.Stats[0], report.Stats[1]
+	if ok.Count < 250 || ok.Count > 350 || ok.Errors != 0 || fail.Errors != fail.Count {
+		t.Errorf("unexpected stats: %+v %+v", ok, fail)
+	}
+	if ok.Percentile(50) <= 0 || ok.Percentile(50) > ok.Percentile(100) || fail.Percentile(99) != 0 {
+		t.Errorf("unexpected percentiles: %v %v %v", ok.Percentile(50), ok.Percentile(100), fail.Percentile(99))
+	}
+
+	var buf bytes.Buffer
+	if err := report.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 4 || !strings.HasPrefix(lines[3], "TOTAL") {
+		t.Errorf("unexpected report:\n%s", buf.String())
+	}
+}
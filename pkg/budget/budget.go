@@ -0,0 +1,230 @@
+// Package budget bounds how long a request may take. The deadline is set on request context,
+// which database queries and outbound calls of handlers use, so a slow dependency fails
+// the request instead of holding its goroutine.
+//
+// Once budget runs out, the client gets 503 with Retry-After in the usual error envelope at once, unless
+// handler has already started the response, and whatever handler writes afterwards is discarded.
+// Requests which are held on purpose, e.g. long polling of changes, need a rule with zero budget.
+package budget
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+)
+
+// metrics are exposed with expvar as budget.<rule>.exceeded, where rule is e.g. "GET /1.0/articles",
+// or budget.default.exceeded.
+var metrics = expvar.NewMap("budget")
+
+// Rule sets Budget of requests which path starts with Prefix, zero budget means unbounded,
+// e.g. for uploads. Rule applies to requests with any method unless Method is set.
+type Rule struct {
+	Method string
+	Prefix string
+	Budget time.Duration
+}
+
+func (r Rule) String() string {
+	if r.Method == "" {
+		return r.Prefix
+	}
+	return r.Method + " " + r.Prefix
+}
+
+// ParseRule parses rule in form [method ]prefix:budget, e.g. /1.0/articles:2s or POST /1.0/imports:10s.
+func ParseRule(s string) (Rule, error) {
+	i := strings.LastIndex(s, ":")
+	if i <= 0 {
+		return Rule{}, errors.Errorf("invalid request budget rule %q, expected [method ]prefix:budget", s)
+	}
+	budget, err := time.ParseDuration(s[i+1:])
+	if err != nil || budget < 0 {
+		return Rule{}, errors.Errorf("invalid request budget rule %q, budget must be non-negative duration", s)
+	}
+	rule := Rule{Prefix: s[:i], Budget: budget}
+	if j := strings.Index(rule.Prefix, " "); j >= 0 {
+		rule.Method, rule.Prefix = strings.ToUpper(rule.Prefix[:j]), strings.TrimSpace(rule.Prefix[j+1:])
+		if rule.Method == "" || rule.Prefix == "" {
+			return Rule{}, errors.Errorf("invalid request budget rule %q, expected [method ]prefix:budget", s)
+		}
+	}
+	return rule, nil
+}
+
+// Budgets keeps default budget and rules overriding it.
+type Budgets struct {
+	def   time.Duration
+	rules []Rule
+}
+
+func New(def time.Duration, rules []Rule) *Budgets {
+	return &Budgets{def: def, rules: rules}
+}
+
+// For returns budget of the most specific rule for request, or the default one.
+func (b *Budgets) For(method, path string) time.Duration {
+	_, budget := b.match(method, path)
+	return budget
+}
+
+// match returns name of the most specific rule and its budget. The longest prefix wins, rules
+// with method win over ones without it for the same prefix.
+func (b *Budgets) match(method, path string) (string, time.Duration) {
+	name, budget, longest := "default", b.def, -1
+	for _, r := range b.rules {
+		if r.Method != "" && r.Method != method || !strings.HasPrefix(path, r.Prefix) {
+			continue
+		}
+		n := 2 * len(r.Prefix)
+		if r.Method != "" {
+			n++
+		}
+		if n > longest {
+			name, budget, longest = r.String(), r.Budget, n
+		}
+	}
+	return name, budget
+}
+
+// Middleware sets deadline of request context to the budget of its path and responds with 503 once
+// it runs out. Retry-After of the response is retryAfter rounded up to seconds.
+func Middleware(b *Budgets, retryAfter time.Duration) func(next http.Handler) http.Handler {
+	retry := strconv.Itoa(int((retryAfter + time.Second - 1) / time.Second))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name, budget := b.match(r.Method, r.URL.Path)
+			if budget == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), budget)
+			defer cancel()
+
+			tw := &timeoutWriter{w: w, h: cloneHeader(w.Header())}
+			done, stopped := make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(stopped)
+				select {
+				case <-done:
+				case <-ctx.Done():
+					// parent context is cancelled when client goes away, nobody waits for 503 then
+					if ctx.Err() == context.DeadlineExceeded {
+						metrics.Add(name+".exceeded", 1)
+						tw.timeout(r, budget, retry)
+					}
+				}
+			}()
+			// handler may panic, the response must be left to recoverer then
+			defer func() {
+				tw.finish()
+				close(done)
+				<-stopped
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+		})
+	}
+}
+
+// timeoutWriter passes response of handler through until budget runs out. Handler has its own
+// header, so 503 may be written concurrently with handler setting headers.
+type timeoutWriter struct {
+	w http.ResponseWriter
+	h http.Header
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+	finished    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeader(code)
+}
+
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.wroteHeader || tw.timedOut {
+		return
+	}
+	tw.wroteHeader = true
+	copyHeader(tw.w.Header(), tw.h)
+	tw.w.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeader(http.StatusOK)
+	return tw.w.Write(p)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if f, ok := tw.w.(http.Flusher); ok && !tw.timedOut {
+		tw.writeHeader(http.StatusOK)
+		f.Flush()
+	}
+}
+
+// timeout responds with 503 unless handler has started or finished the response.
+func (tw *timeoutWriter) timeout(r *http.Request, budget time.Duration, retryAfter string) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.wroteHeader || tw.finished {
+		return
+	}
+	tw.timedOut = true
+	tw.w.Header().Set("Retry-After", retryAfter)
+	// render keeps status in context of request it is given, handler has its own copy of r
+	render.Render(tw.w, r.WithContext(r.Context()), handler.ErrServiceUnavailable(i18n.Errorf("budget.exceeded", budget)))
+	// handler keeps going until it notices cancellation, the client does not wait for it
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish passes headers of handler which returned without writing, e.g. 200 with an empty body.
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.finished = true
+	if !tw.wroteHeader && !tw.timedOut {
+		copyHeader(tw.w.Header(), tw.h)
+	}
+}
+
+func cloneHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	copyHeader(c, h)
+	return c
+}
+
+// copyHeader replaces dst with src, so headers removed by handler are removed from response too.
+func copyHeader(dst, src http.Header) {
+	for k := range dst {
+		delete(dst, k)
+	}
+	for k, v := range src {
+		dst[k] = append([]string(nil), v...)
+	}
+}
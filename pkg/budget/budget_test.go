@@ -0,0 +1,138 @@
+package budget
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in   string
+		rule Rule
+		err  bool
+	}{
+		{in: "/1.0/articles:2s", rule: Rule{Prefix: "/1.0/articles", Budget: 2 * time.Second}},
+		{in: "/1.0/attachments:0", rule: Rule{Prefix: "/1.0/attachments"}},
+		{in: "post /1.0/imports:10s", rule: Rule{Method: "POST", Prefix: "/1.0/imports", Budget: 10 * time.Second}},
+		{in: "/1.0:soon", err: true},
+		{in: ":1s", err: true},
+		{in: "/1.0:-1s", err: true},
+		{in: "GET :1s", err: true},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.in)
+		if (err != nil) != tt.err || rule != tt.rule {
+			t.Errorf("%s: unexpected %+v, %v", tt.in, rule, err)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	b := New(time.Minute, []Rule{
+		{Prefix: "/1.0/articles", Budget: time.Second},
+		{Method: http.MethodPost, Prefix: "/1.0/articles", Budget: 10 * time.Second},
+		{Prefix: "/1.0/attachments", Budget: 0},
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		budget time.Duration
+	}{
+		{http.MethodGet, "/1.0/articles/1", time.Second},
+		{http.MethodPost, "/1.0/articles", 10 * time.Second},
+		{http.MethodGet, "/1.0/usage", time.Minute},
+		{http.MethodGet, "/1.0/attachments", 0},
+	}
+	for _, tt := range tests {
+		var (
+			deadline time.Time
+			ok       bool
+		)
+		h := Middleware(b, time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline, ok = r.Context().Deadline()
+		}))
+		start := time.Now()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+		if ok != (tt.budget > 0) {
+			t.Errorf("%s %s: unexpected deadline %v", tt.method, tt.path, deadline)
+			continue
+		}
+		if ok && (deadline.Before(start.Add(tt.budget)) || deadline.After(time.Now().Add(tt.budget))) {
+			t.Errorf("%s %s: unexpected deadline %v", tt.method, tt.path, deadline.Sub(start))
+		}
+	}
+}
+
+func TestMiddleware_Exceeded(t *testing.T) {
+	b := New(0, []Rule{{Prefix: "/1.0/articles", Budget: 10 * time.Millisecond}})
+	budgeted := Middleware(b, 1500*time.Millisecond)
+	mw := func(h http.Handler) http.Handler { return middleware.RequestID(budgeted(h)) }
+
+	exceeded := func() int64 {
+		if v, ok := metrics.Get("/1.0/articles.exceeded").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := exceeded()
+
+	// handler notices cancellation late and still tries to respond
+	slow := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "slow")
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+		if _, err := w.Write([]byte("context deadline exceeded")); err != http.ErrHandlerTimeout {
+			t.Errorf("unexpected error of write after timeout %v", err)
+		}
+	}))
+	w := httptest.NewRecorder()
+	slow.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/1.0/articles", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status 503, got %d", w.Code)
+	}
+	if v := w.Header().Get("Retry-After"); v != "2" {
+		t.Errorf("unexpected Retry-After %q", v)
+	}
+	var resp handler.ErrResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Header().Get("X-Handler") != "" {
+		t.Errorf("response of handler leaked into 503: %v %s", w.Header(), w.Body)
+	}
+	if resp.RequestID == "" || !strings.Contains(resp.ErrorText, "10ms") {
+		t.Errorf("unexpected response %s", w.Body)
+	}
+	if n := exceeded() - before; n != 1 {
+		t.Errorf("unexpected number of exceeded budgets %d", n)
+	}
+
+	// response started in time is left as is
+	started := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "started")
+		w.WriteHeader(http.StatusAccepted)
+		<-r.Context().Done()
+	}))
+	w = httptest.NewRecorder()
+	started.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/1.0/articles", nil))
+	if w.Code != http.StatusAccepted || w.Header().Get("X-Handler") != "started" {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+
+	fast := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "fast")
+	}))
+	w = httptest.NewRecorder()
+	fast.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/1.0/articles", nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-Handler") != "fast" {
+		t.Errorf("unexpected response %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,201 @@
+// Package cache keeps small hot lookups in memory, e.g. tags or feature flags, so they are not
+// queried on every request. Cache is bounded by number of entries, the least recently used entry
+// is evicted first, and entries may expire after TTL.
+//
+// Entries can be tagged with groups and invalidated together, e.g. everything derived from an
+// article when it is updated.
+package cache
+
+import (
+	"container/list"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+)
+
+// metrics are exposed with expvar as cache.<name>.{hits,misses,evictions,invalidations}.
+var metrics = expvar.NewMap("cache")
+
+type Config struct {
+	// Size is the maximum number of entries, cache is disabled if it is not positive.
+	Size int
+	// TTL is how long entries live, they live until evicted if zero.
+	TTL time.Duration
+	// OnEvict is called without lock held for entries removed for any reason but Set of the same key.
+	OnEvict func(key, value interface{})
+}
+
+// Cache is safe for concurrent use.
+type Cache struct {
+	name  string
+	cfg   Config
+	clock clock.Clock
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[interface{}]*list.Element
+	groups  map[string]map[interface{}]struct{}
+}
+
+type entry struct {
+	key     interface{}
+	value   interface{}
+	expires time.Time
+	groups  []string
+}
+
+// New returns cache, name is used in metrics.
+func New(name string, cfg Config) *Cache {
+	return &Cache{
+		name:    name,
+		cfg:     cfg,
+		clock:   clock.Real,
+		order:   list.New(),
+		entries: make(map[interface{}]*list.Element),
+		groups:  make(map[string]map[interface{}]struct{}),
+	}
+}
+
+// Get returns value of key unless it is missing or expired.
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	var evicted []*entry
+	if ok && c.expired(el.Value.(*entry)) {
+		evicted = append(evicted, c.remove(el))
+		ok = false
+	}
+	var value interface{}
+	if ok {
+		c.order.MoveToFront(el)
+		value = el.Value.(*entry).value
+	}
+	c.mu.Unlock()
+
+	c.evicted(evicted)
+	if ok {
+		metrics.Add(c.name+".hits", 1)
+	} else {
+		metrics.Add(c.name+".misses", 1)
+	}
+	return value, ok
+}
+
+// Set stores value of key in groups, the least recently used entries are evicted when cache is full.
+func (c *Cache) Set(key, value interface{}, groups ...string) {
+	if c.cfg.Size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	e := &entry{key: key, value: value, groups: groups}
+	if c.cfg.TTL > 0 {
+		e.expires = c.clock.Now().Add(c.cfg.TTL)
+	}
+	c.entries[key] = c.order.PushFront(e)
+	for _, g := range groups {
+		if c.groups[g] == nil {
+			c.groups[g] = make(map[interface{}]struct{})
+		}
+		c.groups[g][key] = struct{}{}
+	}
+	var evicted []*entry
+	for c.order.Len() > c.cfg.Size {
+		evicted = append(evicted, c.remove(c.order.Back()))
+	}
+	c.mu.Unlock()
+
+	metrics.Add(c.name+".evictions", int64(len(evicted)))
+	c.evicted(evicted)
+}
+
+// Load returns value of key, it is loaded and stored in groups on miss.
+// Concurrent misses of the same key load it each.
+func (c *Cache) Load(key interface{}, load func() (interface{}, error), groups ...string) (interface{}, error) {
+	if v, ok := c.Get(key); ok {
+		return v, nil
+	}
+	v, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.Set(key, v, groups...)
+	return v, nil
+}
+
+// Delete removes key.
+func (c *Cache) Delete(key interface{}) {
+	c.mu.Lock()
+	var evicted []*entry
+	if el, ok := c.entries[key]; ok {
+		evicted = append(evicted, c.remove(el))
+	}
+	c.mu.Unlock()
+	c.evicted(evicted)
+}
+
+// Invalidate removes entries of groups and returns how many are removed.
+func (c *Cache) Invalidate(groups ...string) int {
+	c.mu.Lock()
+	var evicted []*entry
+	for _, g := range groups {
+		for key := range c.groups[g] {
+			if el, ok := c.entries[key]; ok {
+				evicted = append(evicted, c.remove(el))
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	metrics.Add(c.name+".invalidations", int64(len(evicted)))
+	c.evicted(evicted)
+	return len(evicted)
+}
+
+// Purge removes all entries.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	var evicted []*entry
+	for c.order.Len() > 0 {
+		evicted = append(evicted, c.remove(c.order.Back()))
+	}
+	c.mu.Unlock()
+	c.evicted(evicted)
+}
+
+// Len returns number of entries, expired ones included until they are accessed or evicted.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *Cache) expired(e *entry) bool {
+	return !e.expires.IsZero() && !c.clock.Now().Before(e.expires)
+}
+
+// remove drops entry from list, map and groups, it must be called with mu held.
+func (c *Cache) remove(el *list.Element) *entry {
+	e := c.order.Remove(el).(*entry)
+	delete(c.entries, e.key)
+	for _, g := range e.groups {
+		delete(c.groups[g], e.key)
+		if len(c.groups[g]) == 0 {
+			delete(c.groups, g)
+		}
+	}
+	return e
+}
+
+func (c *Cache) evicted(entries []*entry) {
+	if c.cfg.OnEvict == nil {
+		return
+	}
+	for _, e := range entries {
+		c.cfg.OnEvict(e.key, e.value)
+	}
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+)
+
+func TestCache_LRU(t *testing.T) {
+	var evicted []interface{}
+	c := New("test", Config{Size: 2, OnEvict: func(key, value interface{}) { evicted = append(evicted, key) }})
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("unexpected value: %v %v", v, ok)
+	}
+	// b is the least recently used
+	c.Set("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Error("b is not evicted")
+	}
+	if c.Len() != 2 || !reflect.DeepEqual(evicted, []interface{}{"b"}) {
+		t.Errorf("unexpected evictions: %d %v", c.Len(), evicted)
+	}
+
+	c.Delete("a")
+	c.Purge()
+	if c.Len() != 0 || !reflect.DeepEqual(evicted, []interface{}{"b", "a", "c"}) {
+		t.Errorf("unexpected evictions: %d %v", c.Len(), evicted)
+	}
+}
+
+func TestCache_TTL(t *testing.T) {
+	now := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	c := New("test", Config{Size: 10, TTL: time.Minute})
+	fake := clock.NewFake(now)
+	c.clock = fake
+
+	c.Set("a", 1)
+	fake.Add(59 * time.Second)
+	if _, ok := c.Get("a"); !ok {
+		t.Error("entry expired early")
+	}
+	fake.Add(time.Second)
+	if _, ok := c.Get("a"); ok || c.Len() != 0 {
+		t.Error("entry is not expired")
+	}
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	c := New("test", Config{Size: 10})
+	c.Set("article:1", "a", "article:1", "tags")
+	c.Set("article:2", "b", "article:2", "tags")
+	c.Set("flags", "c")
+
+	if n := c.Invalidate("article:1"); n != 1 {
+		t.Errorf("unexpected invalidated entries: %d", n)
+	}
+	if _, ok := c.Get("article:2"); !ok {
+		t.Error("entry of another group is invalidated")
+	}
+	if n := c.Invalidate("tags"); n != 1 || c.Len() != 1 {
+		t.Errorf("unexpected invalidated entries: %d, left %d", n, c.Len())
+	}
+}
+
+func TestCache_Load(t *testing.T) {
+	c := New("test", Config{Size: 10})
+	loads := 0
+	load := func() (interface{}, error) {
+		loads++
+		return "value", nil
+	}
+	for i := 0; i < 2; i++ {
+		if v, err := c.Load("key", load); err != nil || v != "value" {
+			t.Fatalf("unexpected load: %v %v", v, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("value is loaded %d times", loads)
+	}
+	if _, err := c.Load("other", func() (interface{}, error) { return nil, errors.New("down") }); err == nil {
+		t.Error("error of load is lost")
+	}
+	if _, ok := c.Get("other"); ok {
+		t.Error("failed load is cached")
+	}
+}
@@ -0,0 +1,177 @@
+// Package chaos injects faults into requests, so client retries and service timeouts can be tested.
+// It is meant for development and testing environments only.
+//
+// Faulty requests get X-Chaos-Fault header describing the fault, except for dropped ones,
+// which connection is closed without response.
+package chaos
+
+import (
+	"context"
+	"expvar"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// Header describes the fault injected into response.
+const Header = "X-Chaos-Fault"
+
+// metrics are exposed with expvar as chaos.<prefix>.{latency,error,drop}.
+var metrics = expvar.NewMap("chaos")
+
+type Kind string
+
+const (
+	// Latency delays request for a random duration between MinLatency and MaxLatency.
+	Latency Kind = "latency"
+	// Error responds with Status instead of calling handler.
+	Error Kind = "error"
+	// Drop closes connection without response.
+	Drop Kind = "drop"
+)
+
+// Rule injects fault into Percent of requests which path starts with Prefix.
+type Rule struct {
+	Prefix  string
+	Kind    Kind
+	Percent float64
+
+	MinLatency time.Duration
+	MaxLatency time.Duration
+	Status     int
+}
+
+// ParseRule parses rule in form prefix:fault:percent, where fault is latency=duration, latency=min-max,
+// error=status or drop, e.g. /1.0/articles:latency=100ms-2s:20 or /1.0:error=503:5.
+func ParseRule(s string) (Rule, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return Rule{}, errors.Errorf("invalid chaos rule %q, expected prefix:fault:percent", s)
+	}
+	rule := Rule{Prefix: parts[0]}
+
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(parts[2], "%"), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return Rule{}, errors.Errorf("invalid chaos rule %q, percent must be in (0, 100]", s)
+	}
+	rule.Percent = percent
+
+	kind := strings.SplitN(parts[1], "=", 2)
+	switch rule.Kind = Kind(kind[0]); {
+	case rule.Kind == Drop && len(kind) == 1:
+	case rule.Kind == Latency && len(kind) == 2:
+		bounds := strings.SplitN(kind[1], "-", 2)
+		rule.MinLatency, err = time.ParseDuration(bounds[0])
+		if err == nil {
+			rule.MaxLatency = rule.MinLatency
+			if len(bounds) == 2 {
+				rule.MaxLatency, err = time.ParseDuration(bounds[1])
+			}
+		}
+		if err != nil || rule.MinLatency < 0 || rule.MaxLatency < rule.MinLatency {
+			return Rule{}, errors.Errorf("invalid chaos rule %q, latency must be duration or min-max", s)
+		}
+	case rule.Kind == Error && len(kind) == 2:
+		rule.Status, err = strconv.Atoi(kind[1])
+		if err != nil || rule.Status < 400 || rule.Status > 599 {
+			return Rule{}, errors.Errorf("invalid chaos rule %q, error must be 4xx or 5xx status", s)
+		}
+	default:
+		return Rule{}, errors.Errorf("invalid chaos rule %q, fault must be latency=duration, error=status or drop", s)
+	}
+	return rule, nil
+}
+
+// Injector decides which requests get faults.
+type Injector struct {
+	rules []Rule
+	sleep func(ctx context.Context, d time.Duration)
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func New(rules []Rule) *Injector {
+	return &Injector{
+		rules: rules,
+		sleep: sleep,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// sleep returns early when client goes away.
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// faults returns rules which faults are injected into request to path. All matching rules are rolled
+// independently, so e.g. latency and error may be injected together.
+func (in *Injector) faults(path string) []*Rule {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	var found []*Rule
+	for i := range in.rules {
+		r := &in.rules[i]
+		if strings.HasPrefix(path, r.Prefix) && in.rand.Float64()*100 < r.Percent {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func (in *Injector) latency(r *Rule) time.Duration {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return r.MinLatency + time.Duration(in.rand.Int63n(int64(r.MaxLatency-r.MinLatency)+1))
+}
+
+// Middleware injects faults into requests matching rules. Latency is injected before the others.
+func Middleware(in *Injector) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			faults := in.faults(r.URL.Path)
+			for _, f := range faults {
+				if f.Kind == Latency {
+					d := in.latency(f)
+					metrics.Add(f.Prefix+".latency", 1)
+					w.Header().Add(Header, "latency="+d.String())
+					in.sleep(r.Context(), d)
+				}
+			}
+			for _, f := range faults {
+				switch f.Kind {
+				case Drop:
+					metrics.Add(f.Prefix+".drop", 1)
+					// server closes connection without response
+					panic(http.ErrAbortHandler)
+				case Error:
+					metrics.Add(f.Prefix+".error", 1)
+					w.Header().Add(Header, "error="+strconv.Itoa(f.Status))
+					handler.WriteProblem(w, &handler.Problem{
+						Title:    i18n.StatusText(reqctx.GetLocale(r.Context()), f.Status),
+						Status:   f.Status,
+						Detail:   "fault injected",
+						Instance: r.URL.Path,
+					})
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package chaos
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in    string
+		rule  Rule
+		valid bool
+	}{
+		{"/1.0:latency=100ms:20", Rule{Prefix: "/1.0", Kind: Latency, Percent: 20, MinLatency: 100 * time.Millisecond, MaxLatency: 100 * time.Millisecond}, true},
+		{"/1.0:latency=100ms-2s:20%", Rule{Prefix: "/1.0", Kind: Latency, Percent: 20, MinLatency: 100 * time.Millisecond, MaxLatency: 2 * time.Second}, true},
+		{"/1.0:error=503:5", Rule{Prefix: "/1.0", Kind: Error, Percent: 5, Status: 503}, true},
+		{"/1.0:drop:0.5", Rule{Prefix: "/1.0", Kind: Drop, Percent: 0.5}, true},
+		{"/1.0:latency=2s-1s:5", Rule{}, false},
+		{"/1.0:error=200:5", Rule{}, false},
+		{"/1.0:drop=1:5", Rule{}, false},
+		{"/1.0:drop:0", Rule{}, false},
+		{"/1.0:drop:101", Rule{}, false},
+		{"/1.0:timeout:5", Rule{}, false},
+		{"/1.0:drop", Rule{}, false},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.in)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: unexpected error: %v", tt.in, err)
+			continue
+		}
+		if rule != tt.rule {
+			t.Errorf("%q: unexpected rule: %+v", tt.in, rule)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	in := New([]Rule{
+		{Prefix: "/slow", Kind: Latency, Percent: 100, MinLatency: time.Second, MaxLatency: 2 * time.Second},
+		{Prefix: "/slow/error", Kind: Error, Percent: 100, Status: http.StatusServiceUnavailable},
+		{Prefix: "/drop", Kind: Drop, Percent: 100},
+	})
+	var slept time.Duration
+	in.sleep = func(ctx context.Context, d time.Duration) { slept += d }
+	h := Middleware(in)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	if w.Code != http.StatusOK || slept < time.Second || slept > 2*time.Second {
+		t.Errorf("unexpected latency: %d %v", w.Code, slept)
+	}
+
+	slept = 0
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/slow/error", nil))
+	if w.Code != http.StatusServiceUnavailable || slept == 0 || len(w.Header()[Header]) != 2 {
+		t.Errorf("unexpected response: %d %v %v", w.Code, slept, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/other", nil))
+	if w.Code != http.StatusOK || w.Header().Get(Header) != "" {
+		t.Errorf("fault injected into unmatched request: %v", w.Header())
+	}
+
+	func() {
+		defer func() {
+			if rvr := recover(); rvr != http.ErrAbortHandler {
+				t.Errorf("connection is not dropped: %v", rvr)
+			}
+		}()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/drop", nil))
+	}()
+}
+
+func TestMiddleware_Percent(t *testing.T) {
+	in := New([]Rule{{Prefix: "/", Kind: Error, Percent: 30, Status: http.StatusInternalServerError}})
+	h := Middleware(in)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	failed := 0
+	for i := 0; i < 1000; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusOK {
+			failed++
+		}
+	}
+	if failed < 200 || failed > 400 {
+		t.Errorf("unexpected number of faults: %d", failed)
+	}
+}
@@ -0,0 +1,114 @@
+// Package clock abstracts time, so tests of timestamps, TTLs and periodic jobs are deterministic.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells time and makes tickers.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+
+func (t realTicker) Stop() { t.t.Stop() }
+
+// Fake is a clock which time moves only when told to. Tickers fire when time passes their next tick,
+// ticks are dropped for slow receivers like with time.Ticker.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTicker{f: f, c: make(chan time.Time, 1), interval: d, next: f.now.Add(d)}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+// Tickers returns the number of running tickers, so tests can wait for a job to start before moving time.
+func (f *Fake) Tickers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.tickers)
+}
+
+// Add moves time forward by d and fires tickers.
+func (f *Fake) Add(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves time to t and fires tickers, time can be moved backwards without firing them.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = t
+	for _, tk := range f.tickers {
+		if t.Before(tk.next) {
+			continue
+		}
+		select {
+		case tk.c <- t:
+		default:
+		}
+		for !t.Before(tk.next) {
+			tk.next = tk.next.Add(tk.interval)
+		}
+	}
+}
+
+type fakeTicker struct {
+	f        *Fake
+	c        chan time.Time
+	interval time.Duration
+	next     time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	for i, tk := range t.f.tickers {
+		if tk == t {
+			t.f.tickers = append(t.f.tickers[:i], t.f.tickers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	tk := c.NewTicker(time.Minute)
+
+	c.Add(30 * time.Second)
+	select {
+	case <-tk.C():
+		t.Fatal("ticker fired early")
+	default:
+	}
+
+	// ticks are dropped for slow receivers, the next one is scheduled after the current time
+	c.Add(3 * time.Minute)
+	select {
+	case now := <-tk.C():
+		if !now.Equal(start.Add(210 * time.Second)) {
+			t.Errorf("unexpected tick: %v", now)
+		}
+	default:
+		t.Fatal("ticker did not fire")
+	}
+	select {
+	case <-tk.C():
+		t.Fatal("dropped ticks are delivered")
+	default:
+	}
+	c.Add(30 * time.Second)
+	if _, ok := <-tk.C(); !ok {
+		t.Error("ticker did not fire")
+	}
+
+	tk.Stop()
+	if c.Tickers() != 0 {
+		t.Errorf("stopped ticker is running")
+	}
+	c.Add(time.Hour)
+	select {
+	case <-tk.C():
+		t.Error("stopped ticker fired")
+	default:
+	}
+}
@@ -0,0 +1,188 @@
+// Package contract checks HTTP responses against golden files, so changes of response shape
+// are noticed in review rather than by clients.
+//
+// Every exchange is stored in testdata/contract/<name>.golden of the tested package in canonical form:
+// request line and body, status, headers sorted by name and JSON body with sorted keys.
+// Run tests with -update flag to record new or intentionally changed exchanges:
+//
+//	go test ./internal/article/ -run Contract -update
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update contract golden files")
+
+// Dir is where golden files are stored relative to the tested package.
+const Dir = "testdata/contract"
+
+// masked replaces values of masked fields.
+const masked = "<masked>"
+
+// volatileHeaders change between runs and are never recorded.
+var volatileHeaders = []string{"Date", "Content-Length", "X-Request-Id"}
+
+type options struct {
+	mask    map[string]bool
+	headers map[string]bool
+}
+
+type Option func(o *options)
+
+// Mask replaces values of JSON fields with these names at any depth, e.g. generated timestamps.
+func Mask(fields ...string) Option {
+	return func(o *options) {
+		for _, f := range fields {
+			o.mask[f] = true
+		}
+	}
+}
+
+// IgnoreHeaders excludes headers from exchange in addition to Date, Content-Length and X-Request-Id.
+func IgnoreHeaders(names ...string) Option {
+	return func(o *options) {
+		for _, n := range names {
+			o.headers[http.CanonicalHeaderKey(n)] = true
+		}
+	}
+}
+
+// Check serves req with h and compares the exchange with golden file name, e.g. article/get.
+func Check(t testing.TB, h http.Handler, name string, req *http.Request, opts ...Option) {
+	t.Helper()
+	o := &options{mask: map[string]bool{}, headers: map[string]bool{}}
+	for _, h := range volatileHeaders {
+		o.headers[h] = true
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	var reqBody []byte
+	if req.Body != nil {
+		reqBody, _ = ioutil.ReadAll(req.Body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	got, err := canonical(req, reqBody, w, o)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	path := filepath.Join(Dir, filepath.FromSlash(name)+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s: could not read golden file, run test with -update to record it: %v", name, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: response does not match %s, run test with -update if change is intended\n%s",
+			name, path, diff(string(want), string(got)))
+	}
+}
+
+func canonical(req *http.Request, reqBody []byte, w *httptest.ResponseRecorder, o *options) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s\n", req.Method, req.URL.RequestURI())
+	if len(reqBody) > 0 {
+		if err := writeBody(&buf, reqBody, o); err != nil {
+			return nil, fmt.Errorf("could not canonicalize request body: %v", err)
+		}
+	}
+
+	fmt.Fprintf(&buf, "\n%d %s\n", w.Code, http.StatusText(w.Code))
+	var names []string
+	for name := range w.Header() {
+		if !o.headers[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range w.Header()[name] {
+			fmt.Fprintf(&buf, "%s: %s\n", name, v)
+		}
+	}
+	if w.Body.Len() > 0 {
+		buf.WriteByte('\n')
+		if err := writeBody(&buf, w.Body.Bytes(), o); err != nil {
+			return nil, fmt.Errorf("could not canonicalize response body: %v", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBody writes JSON indented with sorted keys and masked fields, other bodies are written as is.
+func writeBody(buf *bytes.Buffer, body []byte, o *options) error {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		buf.Write(body)
+		if !bytes.HasSuffix(body, []byte("\n")) {
+			buf.WriteByte('\n')
+		}
+		return nil
+	}
+	enc := json.NewEncoder(buf)
+	enc.SetIndent("", "  ")
+	// markup in bodies is easier to review unescaped
+	enc.SetEscapeHTML(false)
+	return enc.Encode(mask(v, o.mask))
+}
+
+func mask(v interface{}, fields map[string]bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			if fields[k] && val != nil {
+				v[k] = masked
+			} else {
+				v[k] = mask(val, fields)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = mask(v[i], fields)
+		}
+	}
+	return v
+}
+
+// diff lists lines which differ, golden files are small enough for this to be readable.
+func diff(want, got string) string {
+	wl, gl := strings.Split(want, "\n"), strings.Split(got, "\n")
+	var buf bytes.Buffer
+	for i := 0; i < len(wl) || i < len(gl); i++ {
+		var w, g string
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if w != g {
+			fmt.Fprintf(&buf, "line %d:\n- %s\n+ %s\n", i+1, w, g)
+		}
+	}
+	return buf.String()
+}
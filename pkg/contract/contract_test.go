@@ -0,0 +1,56 @@
+package contract
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failures records failures instead of failing the test.
+type failures struct {
+	testing.TB
+	msgs []string
+}
+
+func (f *failures) Helper() {}
+
+func (f *failures) Errorf(format string, args ...interface{}) {
+	f.msgs = append(f.msgs, fmt.Sprintf(format, args...))
+}
+
+func (f *failures) Fatalf(format string, args ...interface{}) {
+	f.Errorf(format, args...)
+}
+
+func echo(extra string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Date", time.Now().Format(http.TimeFormat))
+		fmt.Fprintf(w, `{"path": %q, "time": %q, "items": [{"time": 1}]%s}`, r.URL.Path, time.Now(), extra)
+	})
+}
+
+func TestCheck(t *testing.T) {
+	req := func() *http.Request {
+		return httptest.NewRequest("POST", "http://example.com/echo?x=1", strings.NewReader(`{"b": 1, "a": "<b>"}`))
+	}
+	Check(t, echo(""), "echo", req(), Mask("time"))
+	if *update {
+		return
+	}
+
+	f := &failures{TB: t}
+	Check(f, echo(`, "new": true`), "echo", req(), Mask("time"))
+	if len(f.msgs) != 1 || !strings.Contains(f.msgs[0], `+   "new": true`) {
+		t.Errorf("changed response is not reported: %q", f.msgs)
+	}
+
+	f = &failures{TB: t}
+	Check(f, echo(""), "missing", req())
+	if len(f.msgs) == 0 || !strings.Contains(f.msgs[0], "-update") {
+		t.Errorf("missing golden file is not reported: %q", f.msgs)
+	}
+}
@@ -0,0 +1,185 @@
+// Package crypto encrypts database fields at application level, so personal data is not stored in plaintext.
+//
+// Values are encrypted with AES-GCM by the primary key of a keyring and stored as v1:<key id>:<base64>,
+// so keys can be rotated: a new primary key encrypts new values, old keys still decrypt existing ones
+// until they are re-encrypted, see RotateColumn. Column name is authenticated with the value,
+// so a ciphertext copied to another column does not decrypt.
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const version = "v1"
+
+var keyID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Key is AES-128, AES-192 or AES-256 key.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseKey parses key in form id:base64, e.g. 2018-06:q83vEjRWeJq83vEjRWeJq83vEjRWeJq83vEjRWeJo=.
+func ParseKey(s string) (Key, error) {
+	i := strings.IndexByte(s, ':')
+	if i <= 0 || !keyID.MatchString(s[:i]) {
+		return Key{}, fmt.Errorf("invalid encryption key, must be id:base64 where id is alphanumeric")
+	}
+	secret, err := base64.StdEncoding.DecodeString(s[i+1:])
+	if err != nil {
+		return Key{}, fmt.Errorf("invalid encryption key %s, secret is not base64", s[:i])
+	}
+	if n := len(secret); n != 16 && n != 24 && n != 32 {
+		return Key{}, fmt.Errorf("invalid encryption key %s, secret must be 16, 24 or 32 bytes", s[:i])
+	}
+	return Key{ID: s[:i], Secret: secret}, nil
+}
+
+// Keyring encrypts with the primary key and decrypts with any key.
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewKeyring creates keyring, the first key is primary if primary is empty.
+func NewKeyring(keys []Key, primary string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption keys")
+	}
+	if primary == "" {
+		primary = keys[0].ID
+	}
+	k := &Keyring{primary: primary, aeads: make(map[string]cipher.AEAD)}
+	for _, key := range keys {
+		if _, ok := k.aeads[key.ID]; ok {
+			return nil, errors.Errorf("encryption key %s is duplicated", key.ID)
+		}
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid encryption key %s", key.ID)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid encryption key %s", key.ID)
+		}
+		k.aeads[key.ID] = aead
+	}
+	if _, ok := k.aeads[primary]; !ok {
+		return nil, errors.Errorf("primary encryption key %s is not configured", primary)
+	}
+	return k, nil
+}
+
+// Encrypt encrypts plaintext of column, e.g. user.email.
+func (k *Keyring) Encrypt(column string, plaintext []byte) (string, error) {
+	if k == nil {
+		return "", errors.New("no encryption keys configured")
+	}
+	aead := k.aeads[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Wrap(err, "could not generate nonce")
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(column))
+	return version + ":" + k.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts value of column encrypted by any key of keyring.
+func (k *Keyring) Decrypt(column, value string) ([]byte, error) {
+	if k == nil {
+		return nil, errors.New("no encryption keys configured")
+	}
+	id, sealed, err := split(value)
+	if err != nil {
+		return nil, err
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, errors.Errorf("unknown encryption key %s", id)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted value is truncated")
+	}
+	n := aead.NonceSize()
+	plaintext, err := aead.Open(nil, sealed[:n], sealed[n:], []byte(column))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not decrypt %s", column)
+	}
+	return plaintext, nil
+}
+
+// Rotated reports whether value is encrypted by a key which is not primary anymore.
+func (k *Keyring) Rotated(value string) bool {
+	id, _, err := split(value)
+	return err == nil && id != k.primary
+}
+
+func split(value string) (id string, sealed []byte, err error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 || parts[0] != version {
+		return "", nil, errors.New("value is not encrypted")
+	}
+	sealed, err = base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, errors.New("encrypted value is corrupted")
+	}
+	return parts[1], sealed, nil
+}
+
+// String returns database argument and scan destination which encrypts and decrypts *v, e.g.
+//
+//	m.db.Exec("INSERT INTO author (email) VALUES ($1);", m.keys.String("author.email", &a.Email))
+//	row.Scan(m.keys.String("author.email", &a.Email))
+//
+// Empty strings are stored as NULL.
+func (k *Keyring) String(column string, v *string) *Field {
+	return &Field{keys: k, column: column, v: v}
+}
+
+// Field is an encrypted column value, see Keyring.String.
+type Field struct {
+	keys   *Keyring
+	column string
+	v      *string
+}
+
+// Value implements driver.Valuer.
+func (f *Field) Value() (driver.Value, error) {
+	if *f.v == "" {
+		return nil, nil
+	}
+	return f.keys.Encrypt(f.column, []byte(*f.v))
+}
+
+// Scan implements sql.Scanner.
+func (f *Field) Scan(src interface{}) error {
+	var value string
+	switch s := src.(type) {
+	case nil:
+		*f.v = ""
+		return nil
+	case string:
+		value = s
+	case []byte:
+		value = string(s)
+	default:
+		return errors.Errorf("could not scan %T into encrypted %s", src, f.column)
+	}
+	plaintext, err := f.keys.Decrypt(f.column, value)
+	if err != nil {
+		return err
+	}
+	*f.v = string(plaintext)
+	return nil
+}
@@ -0,0 +1,119 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func testKey(t *testing.T, id string, b byte) Key {
+	k, err := ParseKey(id + ":" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKeyring(t *testing.T) {
+	old := testKey(t, "old", 'a')
+	k, err := NewKeyring([]Key{old}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := k.Encrypt("author.email", []byte("john@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enc, "v1:old:") || strings.Contains(enc, "john") {
+		t.Errorf("unexpected encrypted value: %v", enc)
+	}
+	if _, err := k.Decrypt("author.phone", enc); err == nil {
+		t.Error("value is decrypted for another column")
+	}
+
+	rotated, err := NewKeyring([]Key{testKey(t, "new", 'b'), old}, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rotated.Rotated(enc) {
+		t.Error("value of old key is not rotated")
+	}
+	dec, err := rotated.Decrypt("author.email", enc)
+	if err != nil || string(dec) != "john@example.com" {
+		t.Errorf("unexpected decrypted value: %q %v", dec, err)
+	}
+
+	if _, err := k.Decrypt("author.email", "john@example.com"); err == nil {
+		t.Error("plaintext is decrypted")
+	}
+	if _, err := NewKeyring([]Key{old}, "missing"); err == nil {
+		t.Error("missing primary key is accepted")
+	}
+	for _, s := range []string{"", "nokey", "id:short", "bad id:" + base64.StdEncoding.EncodeToString(make([]byte, 32))} {
+		if _, err := ParseKey(s); err == nil {
+			t.Errorf("%q: invalid key is accepted", s)
+		}
+	}
+}
+
+func TestField(t *testing.T) {
+	k, err := NewKeyring([]Key{testKey(t, "k1", 'a')}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := "john@example.com"
+	v, err := k.String("author.email", &email).Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scanned string
+	if err := k.String("author.email", &scanned).Scan([]byte(v.(string))); err != nil || scanned != email {
+		t.Errorf("unexpected scanned value: %q %v", scanned, err)
+	}
+	if err := k.String("author.email", &scanned).Scan(nil); err != nil || scanned != "" {
+		t.Errorf("unexpected scanned null: %q %v", scanned, err)
+	}
+	empty := ""
+	if v, err := k.String("author.email", &empty).Value(); err != nil || v != nil {
+		t.Errorf("empty value is not null: %v %v", v, err)
+	}
+}
+
+func TestRotateColumn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	old := testKey(t, "old", 'a')
+	ok, err := NewKeyring([]Key{old}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	col := Column{Table: "author", Key: "id", Name: "email"}
+	enc, err := ok.Encrypt(col.String(), []byte("john@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := NewKeyring([]Key{testKey(t, "new", 'b'), old}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(`SELECT "id"::text, "email" FROM "author"`).WithArgs("v1:new:%").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}).AddRow("1", enc))
+	mock.ExpectExec(`UPDATE "author" SET "email"`).WithArgs(sqlmock.AnyArg(), "1", enc).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	n, err := RotateColumn(db, k, col)
+	if err != nil || n != 1 {
+		t.Errorf("unexpected result: %v %v", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
@@ -0,0 +1,79 @@
+package crypto
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+// rotateBatch is how many values are re-encrypted per query.
+const rotateBatch = 1000
+
+// Column is an encrypted column declared by module, Key is primary key column of table.
+type Column struct {
+	Table string
+	Key   string
+	Name  string
+}
+
+// String returns name which is authenticated with values of column, e.g. author.email.
+func (c Column) String() string {
+	return c.Table + "." + c.Name
+}
+
+// RotateColumn re-encrypts values of column encrypted by old keys with the primary key,
+// and returns how many were re-encrypted. A value changed concurrently is left as is.
+func RotateColumn(q postgres.Querier, k *Keyring, c Column) (int, error) {
+	table, key, name := pq.QuoteIdentifier(c.Table), pq.QuoteIdentifier(c.Key), pq.QuoteIdentifier(c.Name)
+	selectQuery := fmt.Sprintf(
+		"SELECT %s::text, %s FROM %s WHERE %s LIKE 'v1:%%' AND %s NOT LIKE $1 LIMIT %d;",
+		key, name, table, name, name, rotateBatch,
+	)
+	updateQuery := fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s::text = $2 AND %s = $3;", table, name, key, name)
+
+	total := 0
+	for {
+		rows, err := q.Query(selectQuery, version+":"+k.primary+":%")
+		if err != nil {
+			return total, errors.Wrapf(err, "could not get values of %s", c)
+		}
+		type row struct{ id, value string }
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.value); err != nil {
+				rows.Close()
+				return total, errors.Wrapf(err, "could not scan value of %s", c)
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, errors.Wrapf(err, "could not get values of %s", c)
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+
+		for _, r := range batch {
+			plaintext, err := k.Decrypt(c.String(), r.value)
+			if err != nil {
+				return total, errors.Wrapf(err, "could not rotate %s of %s", c, r.id)
+			}
+			value, err := k.Encrypt(c.String(), plaintext)
+			if err != nil {
+				return total, err
+			}
+			if _, err := q.Exec(updateQuery, value, r.id, r.value); err != nil {
+				return total, errors.Wrapf(err, "could not update %s of %s", c, r.id)
+			}
+			total++
+		}
+		if len(batch) < rotateBatch {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Package diagnostics captures artifacts for on-call engineers when server errors pile up.
+//
+// When the number of 5xx responses within a window reaches the threshold, artifacts such as
+// goroutine dump and heap profile are written to a store and an alert event is reported.
+package diagnostics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+	"github.com/agalitsyn/goapi/pkg/report"
+)
+
+// Artifact is a single diagnostic file.
+type Artifact struct {
+	Name  string
+	Write func(w io.Writer) error
+}
+
+// Goroutines dumps stacks of all goroutines.
+func Goroutines() Artifact {
+	return Artifact{Name: "goroutines.txt", Write: func(w io.Writer) error {
+		return pprof.Lookup("goroutine").WriteTo(w, 2)
+	}}
+}
+
+// Heap writes heap profile, which can be inspected with go tool pprof.
+func Heap() Artifact {
+	return Artifact{Name: "heap.pprof", Write: func(w io.Writer) error {
+		return pprof.Lookup("heap").WriteTo(w, 0)
+	}}
+}
+
+// JSON writes v as indented JSON, e.g. configuration snapshot. Secrets must be removed by caller.
+func JSON(name string, v interface{}) Artifact {
+	return Artifact{Name: name, Write: func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}}
+}
+
+// SlowQueries lists queries running longer than min from pg_stat_activity.
+func SlowQueries(db postgres.Querier, min time.Duration) Artifact {
+	return Artifact{Name: "slow_queries.txt", Write: func(w io.Writer) error {
+		rows, err := db.Query(
+			`SELECT pid, state, now() - query_start AS duration, query FROM pg_stat_activity
+			WHERE state <> 'idle' AND now() - query_start >= $1 * interval '1 millisecond'
+			ORDER BY query_start LIMIT 50;`,
+			int64(min/time.Millisecond),
+		)
+		if err != nil {
+			return errors.Wrap(err, "could not get slow queries")
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var pid int
+			var state, duration, query string
+			if err := rows.Scan(&pid, &state, &duration, &query); err != nil {
+				return errors.Wrap(err, "could not scan slow query")
+			}
+			fmt.Fprintf(w, "pid=%d state=%s duration=%s\n%s\n\n", pid, state, duration, query)
+		}
+		return rows.Err()
+	}}
+}
+
+// Config configures when diagnostics are captured.
+type Config struct {
+	// Threshold is a number of 5xx responses within Window which triggers capture.
+	Threshold int
+	Window    time.Duration
+	// Cooldown is a minimal interval between captures.
+	Cooldown time.Duration
+}
+
+// Trigger counts server errors and captures artifacts when they exceed the threshold.
+type Trigger struct {
+	cfg       Config
+	store     Store
+	artifacts []Artifact
+	reporter  report.Reporter
+	logger    log.Logger
+	clock     clock.Clock
+
+	mu          sync.Mutex
+	failures    []time.Time
+	lastCapture time.Time
+	wg          sync.WaitGroup
+}
+
+func New(cfg Config, store Store, reporter report.Reporter, logger log.Logger, artifacts ...Artifact) *Trigger {
+	return &Trigger{
+		cfg:       cfg,
+		store:     store,
+		artifacts: artifacts,
+		reporter:  reporter,
+		logger:    logger,
+		clock:     clock.Real,
+	}
+}
+
+// Middleware records 5xx responses, it must be placed before Recoverer to see panics.
+func (t *Trigger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		if ww.Status() >= http.StatusInternalServerError {
+			t.record()
+		}
+	})
+}
+
+func (t *Trigger) record() {
+	now := t.clock.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures = append(t.failures, now)
+	i := 0
+	for i < len(t.failures) && now.Sub(t.failures[i]) > t.cfg.Window {
+		i++
+	}
+	t.failures = t.failures[i:]
+
+	if len(t.failures) < t.cfg.Threshold || (!t.lastCapture.IsZero() && now.Sub(t.lastCapture) < t.cfg.Cooldown) {
+		return
+	}
+	t.lastCapture = now
+	count := len(t.failures)
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		t.capture(now, count)
+	}()
+}
+
+// capture writes artifacts under a prefix of capture time, failed artifacts don't prevent others.
+func (t *Trigger) capture(at time.Time, count int) {
+	prefix := at.UTC().Format("20060102T150405Z") + "/"
+	var failed []string
+	for _, a := range t.artifacts {
+		var buf bytes.Buffer
+		err := a.Write(&buf)
+		if err == nil {
+			err = t.store.Put(prefix+a.Name, &buf)
+		}
+		if err != nil {
+			t.logger.WithError(err).WithField("artifact", a.Name).Error("could not capture diagnostics")
+			failed = append(failed, a.Name)
+		}
+	}
+
+	t.reporter.Report(&report.Event{
+		Time:    at,
+		Level:   "error",
+		Message: fmt.Sprintf("%d server errors within %v, diagnostics captured", count, t.cfg.Window),
+		Extra: map[string]interface{}{
+			"artifacts": t.store.Location(prefix),
+			"failed":    failed,
+		},
+	})
+}
+
+// Wait waits for captures in progress, e.g. before shutdown.
+func (t *Trigger) Wait() {
+	t.wg.Wait()
+}
@@ -0,0 +1,76 @@
+package diagnostics
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/report"
+)
+
+type recorder struct {
+	events []*report.Event
+}
+
+func (r *recorder) Report(e *report.Event) { r.events = append(r.events, e) }
+func (r *recorder) Close() error           { return nil }
+
+func TestTrigger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goapi-diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	rec := &recorder{}
+	tr := New(Config{Threshold: 2, Window: time.Minute, Cooldown: time.Hour}, NewDirStore(dir), rec, log.New("", "", ioutil.Discard),
+		Goroutines(),
+		JSON("config.json", map[string]string{"addr": "localhost:5000"}),
+		Artifact{Name: "broken.txt", Write: func(w io.Writer) error { return io.ErrUnexpectedEOF }},
+	)
+	clk := clock.NewFake(now)
+	tr.clock = clk
+
+	status := http.StatusInternalServerError
+	h := tr.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	serve := func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		tr.Wait()
+	}
+
+	serve()
+	clk.Add(2 * time.Minute)
+	serve()
+	if len(rec.events) != 0 {
+		t.Fatalf("expected errors out of window to be ignored")
+	}
+
+	serve()
+	if len(rec.events) != 1 {
+		t.Fatalf("expected alert, got %v", len(rec.events))
+	}
+	for _, name := range []string{"goroutines.txt", "config.json"} {
+		if _, err := os.Stat(filepath.Join(dir, "20180102T030605Z", name)); err != nil {
+			t.Errorf("expected artifact %v: %v", name, err)
+		}
+	}
+	if failed := rec.events[0].Extra["failed"].([]string); len(failed) != 1 || failed[0] != "broken.txt" {
+		t.Errorf("unexpected failed artifacts: %v", failed)
+	}
+
+	// cooldown
+	serve()
+	if len(rec.events) != 1 {
+		t.Errorf("expected no alerts during cooldown")
+	}
+}
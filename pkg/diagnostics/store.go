@@ -0,0 +1,49 @@
+package diagnostics
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Store keeps captured artifacts. Implementations for object storages (S3, GCS) belong here too.
+type Store interface {
+	Put(name string, content io.Reader) error
+	// Location returns where artifacts with name prefix can be found, it is included in alerts.
+	Location(prefix string) string
+}
+
+// DirStore keeps artifacts in local directory, which may be a mounted volume.
+type DirStore struct {
+	dir string
+}
+
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{dir: dir}
+}
+
+func (s *DirStore) Put(name string, content io.Reader) error {
+	path := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "could not create diagnostics directory")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "could not create diagnostics file")
+	}
+	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
+		return errors.Wrap(err, "could not write diagnostics file")
+	}
+	return f.Close()
+}
+
+func (s *DirStore) Location(prefix string) string {
+	abs, err := filepath.Abs(filepath.Join(s.dir, filepath.FromSlash(prefix)))
+	if err != nil {
+		return prefix
+	}
+	return abs
+}
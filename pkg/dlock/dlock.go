@@ -0,0 +1,162 @@
+// Package dlock provides locks shared by replicas of service, built on Postgres session advisory locks.
+//
+// A lock is held by a database session, so it is released by Postgres when the holder dies or loses
+// its connection, there are no stale locks to expire. Holders learn that such a lock is gone from Lost,
+// since the session is checked every renewal interval.
+package dlock
+
+import (
+	"context"
+	"database/sql"
+	"expvar"
+	"hash/fnv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+)
+
+// ErrNotAcquired is returned by TryLock when lock is held by someone else.
+var ErrNotAcquired = errors.New("lock is held by another session")
+
+// metrics are exposed with expvar as dlock.{acquired,contended,lost}.
+var metrics = expvar.NewMap("dlock")
+
+type Config struct {
+	// Renew is how often held locks check their session, 10s if zero.
+	Renew time.Duration
+	// Retry is how often Lock tries to acquire a held lock, 1s if zero.
+	Retry time.Duration
+}
+
+// Locker acquires locks with connections of db. Every lock takes a connection until it is unlocked,
+// so db should be a pool of its own which keeps no idle connections: queries under lock can not wait
+// for the connection it holds, and a connection closed on unlock releases lock even if unlock fails.
+type Locker struct {
+	db    *sql.DB
+	cfg   Config
+	clock clock.Clock
+}
+
+func New(db *sql.DB, cfg Config) *Locker {
+	if cfg.Renew <= 0 {
+		cfg.Renew = 10 * time.Second
+	}
+	if cfg.Retry <= 0 {
+		cfg.Retry = time.Second
+	}
+	return &Locker{db: db, cfg: cfg, clock: clock.Real}
+}
+
+// Key returns advisory lock key of name, names share key space of the database with other users
+// of advisory locks, so they should be specific, e.g. retention or migrations.
+func Key(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// TryLock acquires lock of name or returns ErrNotAcquired at once.
+func (l *Locker) TryLock(ctx context.Context, name string) (*Lock, error) {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get connection for lock")
+	}
+	key := Key(name)
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1);", key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "could not acquire lock %s", name)
+	}
+	if !acquired {
+		conn.Close()
+		metrics.Add("contended", 1)
+		return nil, ErrNotAcquired
+	}
+	metrics.Add("acquired", 1)
+
+	lock := &Lock{
+		name: name,
+		key:  key,
+		conn: conn,
+		lost: make(chan struct{}),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go lock.renew(l.clock, l.cfg.Renew)
+	return lock, nil
+}
+
+// Lock waits until lock of name is acquired or ctx is done.
+func (l *Locker) Lock(ctx context.Context, name string) (*Lock, error) {
+	ticker := l.clock.NewTicker(l.cfg.Retry)
+	defer ticker.Stop()
+	for {
+		lock, err := l.TryLock(ctx, name)
+		if err != ErrNotAcquired {
+			return lock, err
+		}
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return nil, errors.Wrapf(ctx.Err(), "could not acquire lock %s", name)
+		}
+	}
+}
+
+// Lock is a held lock, it must be unlocked.
+type Lock struct {
+	name string
+	key  int64
+	conn *sql.Conn
+
+	lost chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Lost is closed when session holding lock is gone, so is the lock and holder should stop its work.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// renew checks session every interval until Unlock is called or session is gone.
+func (l *Lock) renew(c clock.Clock, interval time.Duration) {
+	defer close(l.done)
+
+	ticker := c.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			_, err := l.conn.ExecContext(ctx, "SELECT 1;")
+			cancel()
+			if err != nil {
+				metrics.Add("lost", 1)
+				close(l.lost)
+				return
+			}
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Unlock releases lock and its connection.
+func (l *Lock) Unlock() error {
+	close(l.stop)
+	<-l.done
+	defer l.conn.Close()
+
+	select {
+	case <-l.lost:
+		return nil
+	default:
+	}
+	if _, err := l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1);", l.key); err != nil {
+		return errors.Wrapf(err, "could not release lock %s", l.name)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package dlock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestLocker_TryLock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	fake := clock.NewFake(time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC))
+	l := New(db, Config{Renew: time.Second})
+	l.clock = fake
+	key := Key("retention")
+
+	mock.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).WithArgs(key).
+		WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(false))
+	mock.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).WithArgs(key).
+		WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(true))
+	mock.ExpectExec(`SELECT 1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(key).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if _, err := l.TryLock(context.Background(), "retention"); err != ErrNotAcquired {
+		t.Fatalf("held lock is acquired: %v", err)
+	}
+	lock, err := l.TryLock(context.Background(), "retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fake.Tickers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	fake.Add(time.Second)
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-lock.Lost():
+		t.Error("lock of live session is lost")
+	default:
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestLock_Lost(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	fake := clock.NewFake(time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC))
+	l := New(db, Config{Renew: time.Second})
+	l.clock = fake
+
+	mock.ExpectQuery(`SELECT pg_try_advisory_lock`).WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(true))
+	mock.ExpectExec(`SELECT 1`).WillReturnError(errors.New("connection reset"))
+
+	lock, err := l.Lock(context.Background(), "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fake.Tickers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	fake.Add(time.Second)
+	select {
+	case <-lock.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lock of broken session is not lost")
+	}
+	// lock is gone with session, there is nothing to release
+	if err := lock.Unlock(); err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
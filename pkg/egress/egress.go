@@ -0,0 +1,197 @@
+// Package egress restricts outbound requests to URLs users provide, e.g. webhooks and linked pages,
+// so they can not reach internal services.
+//
+// Hosts may be allowed or denied by name, and addresses by network. Private, loopback, link-local
+// and other non-public addresses are denied unless their network is allowed. Host is resolved once
+// and connection is made to the checked address, so a name can not be rebound to a private address
+// after it is checked.
+package egress
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/ipfilter"
+)
+
+// ErrBlocked is a cause of errors of requests policy does not allow.
+var ErrBlocked = errors.New("outbound request is blocked")
+
+// metrics are exposed with expvar as egress.{blocked_host,blocked_address}.
+var metrics = expvar.NewMap("egress")
+
+// maxRedirects limits how many redirects clients follow, each is checked like the first request.
+const maxRedirects = 5
+
+type Config struct {
+	// AllowHosts limits requests to hosts, *.example.com matches subdomains. Any host is allowed if empty.
+	AllowHosts []string
+	// DenyHosts are never requested, they win over allowed hosts.
+	DenyHosts []string
+	// AllowNetworks are non-public networks which may be requested, e.g. internal webhook receivers.
+	AllowNetworks []string
+	// DenyNetworks are never requested, they win over allowed networks.
+	DenyNetworks []string
+}
+
+// Policy is safe for concurrent use. Nil policy is the default one, it denies only non-public addresses.
+type Policy struct {
+	allowHosts    []string
+	denyHosts     []string
+	allowNetworks []*net.IPNet
+	denyNetworks  []*net.IPNet
+	// lookup resolves host names, it is replaced in tests
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+func New(cfg Config) (*Policy, error) {
+	p := &Policy{lookup: net.DefaultResolver.LookupIPAddr}
+	for _, h := range cfg.AllowHosts {
+		p.allowHosts = append(p.allowHosts, strings.ToLower(h))
+	}
+	for _, h := range cfg.DenyHosts {
+		p.denyHosts = append(p.denyHosts, strings.ToLower(h))
+	}
+	for _, s := range cfg.AllowNetworks {
+		n, err := ipfilter.ParseNetwork(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid allowed egress network")
+		}
+		p.allowNetworks = append(p.allowNetworks, n)
+	}
+	for _, s := range cfg.DenyNetworks {
+		n, err := ipfilter.ParseNetwork(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid denied egress network")
+		}
+		p.denyNetworks = append(p.denyNetworks, n)
+	}
+	return p, nil
+}
+
+var defaultPolicy, _ = New(Config{})
+
+func (p *Policy) orDefault() *Policy {
+	if p == nil {
+		return defaultPolicy
+	}
+	return p
+}
+
+// CheckHost returns error caused by ErrBlocked if host may not be requested.
+func (p *Policy) CheckHost(host string) error {
+	p = p.orDefault()
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if matchHost(p.denyHosts, host) || (len(p.allowHosts) > 0 && !matchHost(p.allowHosts, host)) {
+		metrics.Add("blocked_host", 1)
+		return errors.Wrapf(ErrBlocked, "host %s is not allowed", host)
+	}
+	return nil
+}
+
+// CheckIP returns error caused by ErrBlocked if address may not be requested.
+func (p *Policy) CheckIP(ip net.IP) error {
+	p = p.orDefault()
+	if contains(p.denyNetworks, ip) || (!Public(ip) && !contains(p.allowNetworks, ip)) {
+		metrics.Add("blocked_address", 1)
+		return errors.Wrapf(ErrBlocked, "address %s is not allowed", ip)
+	}
+	return nil
+}
+
+// DialContext connects to the first address of host if host and all its addresses are allowed.
+func (p *Policy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	p = p.orDefault()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.CheckHost(host); err != nil {
+		return nil, err
+	}
+	addrs, err := p.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.Errorf("%s has no addresses", host)
+	}
+	for _, a := range addrs {
+		if err := p.CheckIP(a.IP); err != nil {
+			return nil, errors.Wrapf(err, "%s resolves to blocked address", host)
+		}
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, net.JoinHostPort(addrs[0].IP.String(), port))
+}
+
+// Client returns HTTP client which requests only what policy allows, including redirects.
+// Proxies from environment are not used, they would connect instead of the client.
+func (p *Policy) Client(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         p.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 1,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.Wrapf(ErrBlocked, "redirect to %s scheme", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// matchHost reports whether host is one of patterns, *.example.com matches subdomains of example.com.
+func matchHost(patterns []string, host string) bool {
+	for _, p := range patterns {
+		if p == host || (strings.HasPrefix(p, "*.") && strings.HasSuffix(host, p[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, s := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+		"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+		"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+	} {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}()
+
+// Public reports whether IP is a public unicast address.
+func Public(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return !contains(privateNetworks, ip)
+}
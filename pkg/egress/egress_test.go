@@ -0,0 +1,113 @@
+package egress
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	p, err := New(Config{
+		AllowHosts:    []string{"hooks.example.com", "*.partner.org"},
+		DenyHosts:     []string{"admin.partner.org"},
+		AllowNetworks: []string{"10.1.0.0/16"},
+		DenyNetworks:  []string{"10.1.2.0/24", "203.0.113.7"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, allowed := range map[string]bool{
+		"hooks.example.com":  true,
+		"HOOKS.example.com.": true,
+		"api.partner.org":    true,
+		"partner.org":        false,
+		"admin.partner.org":  false,
+		"example.com":        false,
+	} {
+		if err := p.CheckHost(host); (err == nil) != allowed {
+			t.Errorf("CheckHost(%s) = %v, allowed %v", host, err, allowed)
+		}
+	}
+	for ip, allowed := range map[string]bool{
+		"8.8.8.8":         true,
+		"10.1.1.1":        true,
+		"10.1.2.3":        false,
+		"10.2.0.1":        false,
+		"203.0.113.7":     false,
+		"169.254.169.254": false,
+		"::ffff:10.1.1.1": true,
+		"::1":             false,
+	} {
+		if err := p.CheckIP(net.ParseIP(ip)); (err == nil) != allowed {
+			t.Errorf("CheckIP(%s) = %v, allowed %v", ip, err, allowed)
+		}
+	}
+
+	// nil policy denies only non-public addresses
+	var def *Policy
+	if def.CheckHost("example.com") != nil || def.CheckIP(net.ParseIP("8.8.8.8")) != nil || def.CheckIP(net.ParseIP("192.168.1.1")) == nil {
+		t.Error("unexpected default policy")
+	}
+}
+
+func TestPolicy_Client(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	p, err := New(Config{AllowNetworks: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the name is rebound to a private address after the first lookup
+	lookups := 0
+	p.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		if lookups > 1 {
+			return []net.IPAddr{{IP: net.ParseIP("127.0.0.2")}}, nil
+		}
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+	client := p.Client(time.Second)
+
+	resp, err := client.Get("http://hooks.example.com:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected status: %v", resp.StatusCode)
+	}
+
+	client.Transport.(*http.Transport).CloseIdleConnections()
+	_, err = client.Get("http://hooks.example.com:" + port + "/")
+	if uerr, ok := err.(*url.Error); !ok || errors.Cause(uerr.Err) != ErrBlocked {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPublic(t *testing.T) {
+	for ip, want := range map[string]bool{
+		"8.8.8.8":         true,
+		"2001:4860::8888": true,
+		"10.1.2.3":        false,
+		"127.0.0.1":       false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"::1":             false,
+		"::ffff:10.0.0.1": false,
+		"fd00::1":         false,
+	} {
+		if got := Public(net.ParseIP(ip)); got != want {
+			t.Errorf("Public(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
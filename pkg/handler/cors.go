@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goware/cors"
+)
+
+// CORSGroup configures cross-origin requests to routes which path starts with Prefix.
+type CORSGroup struct {
+	Prefix string
+	// AllowedOrigins may contain a wildcard, e.g. https://*.example.com, or be "*" to allow any origin.
+	AllowedOrigins   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight responses.
+	MaxAge time.Duration
+}
+
+// corsMethods are passed to cors handlers, preflight requests are checked against routes before.
+var corsMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+}
+
+// CORS handles cross-origin requests by the group with the longest matching prefix, requests outside
+// of groups get no CORS headers. Preflight requests are allowed only for methods registered for
+// the requested route, so allowed methods never drift from the router.
+// Other OPTIONS requests are passed through, e.g. to AutoMethods.
+func CORS(routes *RouteTable, groups ...CORSGroup) func(next http.Handler) http.Handler {
+	groups = append([]CORSGroup(nil), groups...)
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].Prefix) > len(groups[j].Prefix) })
+
+	return func(next http.Handler) http.Handler {
+		handlers := make([]http.Handler, len(groups))
+		for i, g := range groups {
+			handlers[i] = cors.New(cors.Options{
+				AllowedOrigins:   g.AllowedOrigins,
+				AllowedHeaders:   g.AllowedHeaders,
+				ExposedHeaders:   g.ExposedHeaders,
+				AllowedMethods:   corsMethods,
+				AllowCredentials: g.AllowCredentials,
+				MaxAge:           int(g.MaxAge / time.Second),
+			}).Handler(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			i := corsGroup(groups, r.URL.Path)
+			if i < 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.Method == http.MethodOptions {
+				method := r.Header.Get("Access-Control-Request-Method")
+				if method == "" {
+					next.ServeHTTP(w, r)
+					return
+				}
+				if !routeAllows(routes.Match(r.URL.Path), strings.ToUpper(method)) {
+					// browser fails the request without CORS headers
+					w.Header().Add("Vary", "Origin")
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+			handlers[i].ServeHTTP(w, r)
+		})
+	}
+}
+
+func corsGroup(groups []CORSGroup, path string) int {
+	for i, g := range groups {
+		if strings.HasPrefix(path, g.Prefix) {
+			return i
+		}
+	}
+	return -1
+}
+
+func routeAllows(m *RouteMeta, method string) bool {
+	if m == nil {
+		return false
+	}
+	return m.Allows(method) || method == http.MethodHead && m.Allows(http.MethodGet)
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestCORS(t *testing.T) {
+	routes := &RouteTable{}
+	r := chi.NewRouter()
+	r.Use(CORS(routes,
+		CORSGroup{Prefix: "/", AllowedOrigins: []string{"https://*.example.com"}, MaxAge: 10 * time.Minute},
+		CORSGroup{Prefix: "/admin/", AllowedOrigins: []string{"https://admin.example.com"}, AllowCredentials: true},
+	))
+	r.Use(AutoMethods(routes))
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r.Get("/articles", ok)
+	r.Post("/articles", ok)
+	r.Delete("/admin/maintenance", ok)
+	routes.Compile(r)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		origin      string
+		reqMethod   string
+		allowOrigin string
+		allowMethod string
+		maxAge      string
+		credentials string
+	}{
+		{"preflight", http.MethodOptions, "/articles", "https://app.example.com", "POST", "https://app.example.com", "POST", "600", ""},
+		{"implied head", http.MethodOptions, "/articles", "https://app.example.com", "HEAD", "https://app.example.com", "HEAD", "600", ""},
+		{"unregistered method", http.MethodOptions, "/articles", "https://app.example.com", "DELETE", "", "", "", ""},
+		{"unknown route", http.MethodOptions, "/unknown", "https://app.example.com", "GET", "", "", "", ""},
+		{"other origin", http.MethodOptions, "/articles", "https://example.org", "POST", "", "", "", ""},
+		{"actual", http.MethodGet, "/articles", "https://app.example.com", "", "https://app.example.com", "", "", ""},
+		{"admin preflight", http.MethodOptions, "/admin/maintenance", "https://admin.example.com", "DELETE", "https://admin.example.com", "DELETE", "", "true"},
+		{"admin public origin", http.MethodOptions, "/admin/maintenance", "https://app.example.com", "DELETE", "", "", "", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Origin", tt.origin)
+		if tt.reqMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", tt.reqMethod)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		h := w.Header()
+		if h.Get("Access-Control-Allow-Origin") != tt.allowOrigin || h.Get("Access-Control-Allow-Methods") != tt.allowMethod ||
+			h.Get("Access-Control-Max-Age") != tt.maxAge || h.Get("Access-Control-Allow-Credentials") != tt.credentials {
+			t.Errorf("%s: unexpected headers: %v", tt.name, h)
+		}
+	}
+
+	// OPTIONS without preflight headers is answered by AutoMethods
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/articles", nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") == "" {
+		t.Errorf("unexpected OPTIONS response: %v %v", w.Code, w.Header())
+	}
+}
@@ -2,27 +2,105 @@ package handler
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
 )
 
-// FileServer conveniently sets up a http.FileServer handler to serve
-// static files from a http.FileSystem.
-func FileServer(r chi.Router, path string, root http.FileSystem) {
-	if strings.ContainsAny(path, "{}*") {
-		panic("FileServer does not permit URL parameters.")
+// Option configures router created by New.
+type Option func(r *chi.Mux)
+
+// New creates a router configured with options.
+func New(opts ...Option) *chi.Mux {
+	r := chi.NewRouter()
+	for _, opt := range opts {
+		opt(r)
 	}
+	return r
+}
 
-	fs := http.StripPrefix(path, http.FileServer(root))
+// WithLogging sets request logger, it is required by handlers which use log.GetLogEntry.
+func WithLogging(logger log.Logger) Option {
+	return func(r *chi.Mux) {
+		r.Use(RequestLogger(logger))
+	}
+}
 
-	if path != "/" && path[len(path)-1] != '/' {
-		r.Get(path, http.RedirectHandler(path+"/", 301).ServeHTTP)
-		path += "/"
+// WithMethodOverride allows clients behind restrictive proxies to tunnel methods through POST.
+func WithMethodOverride() Option {
+	return func(r *chi.Mux) {
+		r.Use(MethodOverride)
 	}
-	path += "*"
+}
 
-	r.Get(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fs.ServeHTTP(w, r)
-	}))
+// DryRunHeader marks responses of requests which changes were rolled back.
+const DryRunHeader = "X-Dry-Run"
+
+// DryRun reports whether request has ?dry_run=true and marks response accordingly.
+// Mutating handlers run full validation and roll back instead of committing.
+func DryRun(w http.ResponseWriter, r *http.Request) bool {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	if dryRun {
+		w.Header().Set(DryRunHeader, "true")
+	}
+	return dryRun
+}
+
+// NotModified sets Last-Modified and ETag of response and reports whether client has the current
+// representation, then 304 is written and handler must not write anything else. Last-Modified has
+// second precision, so changes within the same second are told apart by ETag of the exact time,
+// If-None-Match is evaluated instead of If-Modified-Since when both are sent.
+func NotModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	if modified.IsZero() {
+		return false
+	}
+	// representations vary by locale, e.g. labels of statuses, strong tag must tell them apart
+	etag := strconv.FormatInt(modified.UnixNano(), 36)
+	if locale := reqctx.GetLocale(r.Context()); locale != "" {
+		etag += "-" + locale
+	}
+	etag = `"` + etag + `"`
+	w.Header().Set("ETag", etag)
+	// HTTP dates have second precision
+	w.Header().Set("Last-Modified", modified.Truncate(time.Second).UTC().Format(http.TimeFormat))
+	if !Fresh(r, w.Header()) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// Fresh reports whether client has the representation with ETag and Last-Modified of header, e.g. of
+// a cached response. If-None-Match is evaluated instead of If-Modified-Since when both are sent.
+func Fresh(r *http.Request, header http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		etag := header.Get("ETag")
+		return etag != "" && etagMatch(match, etag)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
+
+// etagMatch reports whether list of If-None-Match has etag, weak tags match strong ones, see RFC 7232
+// section 3.2.
+func etagMatch(list, etag string) bool {
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
 }
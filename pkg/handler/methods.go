@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// AutoMethods serves HEAD for routes which have GET handler and OPTIONS for all routes,
+// unless they are registered explicitly. It must be used before routing,
+// routes table may be compiled after the middleware is registered.
+func AutoMethods(routes *RouteTable) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodHead && r.Method != http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			m := routes.Match(r.URL.Path)
+			if m == nil || m.Allows(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			switch {
+			case r.Method == http.MethodOptions:
+				w.Header().Set("Allow", m.Allow)
+				w.Header().Set("Content-Length", "0")
+				w.WriteHeader(http.StatusNoContent)
+			case m.Allows(http.MethodGet):
+				get := r.WithContext(r.Context())
+				get.Method = http.MethodGet
+				hw := &headWriter{ResponseWriter: w}
+				next.ServeHTTP(hw, get)
+				hw.flush()
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// headWriter discards body and holds headers until handler returns to set correct Content-Length.
+type headWriter struct {
+	http.ResponseWriter
+	status int
+	length int
+}
+
+func (w *headWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *headWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.length += len(b)
+	return len(b), nil
+}
+
+func (w *headWriter) flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.Header().Get("Content-Length") == "" && w.status != http.StatusNoContent && w.status != http.StatusNotModified {
+		w.Header().Set("Content-Length", strconv.Itoa(w.length))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
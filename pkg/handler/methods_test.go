@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestAutoMethods(t *testing.T) {
+	routes := &RouteTable{}
+	r := New(func(r *chi.Mux) { r.Use(AutoMethods(routes)) })
+	r.Get("/articles", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	})
+	routes.Compile(r)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "http://example.com/articles", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("unexpected body: %v", w.Body.String())
+	}
+	if cl := w.Header().Get("Content-Length"); cl != "2" {
+		t.Errorf("unexpected Content-Length: %v", cl)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "http://example.com/articles", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS" {
+		t.Errorf("unexpected Allow header: %v", allow)
+	}
+}
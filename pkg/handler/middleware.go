@@ -1,26 +1,40 @@
 package handler
 
 import (
-	"context"
 	"net/http"
+	"strings"
 
 	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
 	"github.com/go-chi/chi/middleware"
 )
 
-type contextKey string
-
-const ApiVersionContextKey contextKey = "api.version"
-
 func ApiVersion(version string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			r = r.WithContext(context.WithValue(r.Context(), ApiVersionContextKey, version))
+			r = r.WithContext(reqctx.WithAPIVersion(r.Context(), version))
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// MethodOverrideHeader is used to tunnel PUT, PATCH and DELETE through POST.
+const MethodOverrideHeader = "X-HTTP-Method-Override"
+
+// MethodOverride replaces method of POST requests with the one from X-HTTP-Method-Override header.
+// It must be used before routing.
+func MethodOverride(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			switch m := strings.ToUpper(r.Header.Get(MethodOverrideHeader)); m {
+			case http.MethodPut, http.MethodPatch, http.MethodDelete:
+				r.Method = m
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func RequestLogger(logger log.Logger) func(next http.Handler) http.Handler {
 	l, ok := logger.(middleware.LogFormatter)
 	if !ok {
@@ -7,17 +7,14 @@ import (
 	"testing"
 
 	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
 )
 
 func TestApiVersion(t *testing.T) {
 	r := New(WithLogging(log.New("", "", ioutil.Discard)))
 	r.Use(ApiVersion("v1"))
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		v, ok := r.Context().Value(ApiVersionContextKey).(string)
-		if !ok {
-			t.Fatal("cast failed")
-		}
-		w.Write([]byte(v))
+		w.Write([]byte(reqctx.GetAPIVersion(r.Context())))
 	})
 
 	w := httptest.NewRecorder()
@@ -38,3 +35,19 @@ func TestApiVersion(t *testing.T) {
 		t.Fatalf("expected v1, got %s", string(body))
 	}
 }
+
+func TestMethodOverride(t *testing.T) {
+	r := New(WithMethodOverride())
+	r.Delete("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/", nil)
+	req.Header.Set(MethodOverrideHeader, "delete")
+
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("unexpected status: %v", w.Code)
+	}
+}
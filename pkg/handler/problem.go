@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// ProblemContentType is a media type of RFC 7807 error responses.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an error response in RFC 7807 format.
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Suggestions lists routes close to the requested one.
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// WriteProblem writes problem with its status code.
+func WriteProblem(w http.ResponseWriter, p *Problem) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// NotFound responds with problem and up to maxSuggestions routes which are close to the requested path.
+func NotFound(routes *RouteTable, maxSuggestions int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		locale := reqctx.GetLocale(r.Context())
+		WriteProblem(w, &Problem{
+			Title:       i18n.StatusText(locale, http.StatusNotFound),
+			Status:      http.StatusNotFound,
+			Detail:      i18n.Translate(locale, "route.not_found", r.URL.Path),
+			Instance:    r.URL.Path,
+			Suggestions: suggest(routes, r.URL.Path, maxSuggestions),
+		})
+	}
+}
+
+// MethodNotAllowed responds with problem and Allow header of the matched route.
+func MethodNotAllowed(routes *RouteTable) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		locale := reqctx.GetLocale(r.Context())
+		p := &Problem{
+			Title:    i18n.StatusText(locale, http.StatusMethodNotAllowed),
+			Status:   http.StatusMethodNotAllowed,
+			Detail:   i18n.Translate(locale, "route.method_not_allowed", r.Method, r.URL.Path),
+			Instance: r.URL.Path,
+		}
+		if m := routes.Match(r.URL.Path); m != nil {
+			w.Header().Set("Allow", m.Allow)
+			p.Detail += ", " + i18n.Translate(locale, "route.allowed_methods", m.Allow)
+		}
+		WriteProblem(w, p)
+	}
+}
+
+// suggest returns routes with the smallest edit distance to path.
+// Route parameters are substituted with the corresponding path segments before comparison.
+func suggest(routes *RouteTable, path string, max int) []string {
+	if max <= 0 || routes == nil {
+		return nil
+	}
+
+	type candidate struct {
+		pattern  string
+		distance int
+	}
+	var candidates []candidate
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	threshold := len(path)/3 + 1
+	for _, m := range routes.Routes() {
+		d := distance(substitute(m.segments, parts), strings.Trim(path, "/"))
+		if d <= threshold {
+			candidates = append(candidates, candidate{m.Pattern, d})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	var res []string
+	for i := 0; i < len(candidates) && i < max; i++ {
+		res = append(res, candidates[i].pattern)
+	}
+	return res
+}
+
+func substitute(segs []segment, parts []string) string {
+	res := make([]string, 0, len(segs))
+	for i, s := range segs {
+		switch {
+		case s.kind == segmentStatic:
+			res = append(res, s.value)
+		case i < len(parts) && s.kind == segmentWildcard:
+			res = append(res, parts[i:]...)
+		case i < len(parts):
+			res = append(res, parts[i])
+		}
+	}
+	return strings.Join(res, "/")
+}
+
+// distance is a Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
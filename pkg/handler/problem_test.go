@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMethodNotAllowed(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/1.0/articles/1", nil)
+	MethodNotAllowed(testRoutes())(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status: %v", resp.StatusCode)
+	}
+	if allow := resp.Header.Get("Allow"); allow != "DELETE, OPTIONS, PUT" {
+		t.Errorf("unexpected Allow header: %v", allow)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != ProblemContentType {
+		t.Errorf("unexpected content type: %v", ct)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/1.0/artcles/stats", nil)
+	NotFound(testRoutes(), 1)(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status: %v", resp.StatusCode)
+	}
+
+	var p Problem
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Suggestions) != 1 || p.Suggestions[0] != "/1.0/articles/stats" {
+		t.Errorf("unexpected suggestions: %v", p.Suggestions)
+	}
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/report"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// metrics are exposed with expvar as handler.panics.
+var metrics = expvar.NewMap("handler")
+
+// maxFingerprints bounds fingerprints remembered to log stacks once, stacks of further ones are
+// always logged.
+const maxFingerprints = 1024
+
+var fingerprints struct {
+	sync.Mutex
+	seen map[string]bool
+}
+
+// firstSeen remembers fingerprint and returns whether it is new.
+func firstSeen(fp string) bool {
+	fingerprints.Lock()
+	defer fingerprints.Unlock()
+	if fingerprints.seen == nil {
+		fingerprints.seen = make(map[string]bool)
+	}
+	if fingerprints.seen[fp] {
+		return false
+	}
+	if len(fingerprints.seen) < maxFingerprints {
+		fingerprints.seen[fp] = true
+	}
+	return true
+}
+
+// Recoverer recovers from panics, reports them with request context and responds with 500 in the usual
+// error envelope, unless response has already started. Panics are fingerprinted by their call site,
+// so the stack is logged only the first time and later panics log fingerprint to find it.
+func Recoverer(reporter report.Reporter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rvr := recover()
+				if rvr == nil {
+					return
+				}
+				if rvr == http.ErrAbortHandler {
+					panic(rvr)
+				}
+
+				metrics.Add("panics", 1)
+				stack := debug.Stack()
+				fp := fingerprint(rvr)
+				if entry := middleware.GetLogEntry(r); entry != nil {
+					if firstSeen(fp) {
+						entry.Panic(rvr, stack)
+					} else {
+						log.LogEntrySetField(r, "panic", fmt.Sprintf("%+v", rvr))
+					}
+					log.LogEntrySetField(r, "panic_fingerprint", fp)
+				}
+
+				e := &report.Event{
+					Time:        time.Now(),
+					Level:       "fatal",
+					Message:     fmt.Sprintf("panic: %v", rvr),
+					Stack:       stack,
+					Fingerprint: fp,
+					RequestID:   reqctx.GetRequestID(r.Context()),
+					Route:       RoutePattern(r),
+					Method:      r.Method,
+					URL:         r.URL.String(),
+				}
+				if err, ok := rvr.(error); ok {
+					e.Err = err
+				}
+				if u := reqctx.GetUser(r.Context()); u != nil {
+					e.User = u.ID
+				}
+				e.Tenant = reqctx.GetTenant(r.Context())
+				reporter.Report(e)
+
+				// status of started response is already sent, the client sees the connection end
+				if ww, ok := w.(middleware.WrapResponseWriter); ok && ww.Status() != 0 {
+					return
+				}
+				render.Render(w, r, ErrUnknown(i18n.Errorf("request.panic")))
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// fingerprint hashes type of panic value and functions of the stack below panic, without values,
+// lines and arguments, which differ between panics of the same cause and between releases.
+func fingerprint(v interface{}) string {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%T", v)
+	panicked := false
+	for {
+		f, more := frames.Next()
+		switch {
+		case f.Function == "runtime.gopanic":
+			panicked = true
+		case panicked && !strings.HasPrefix(f.Function, "runtime."):
+			h.Write([]byte("\n" + f.Function))
+		}
+		if !more {
+			break
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/middleware"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/report"
+)
+
+type recorder struct {
+	events []*report.Event
+}
+
+func (r *recorder) Report(e *report.Event) { r.events = append(r.events, e) }
+func (r *recorder) Close() error           { return nil }
+
+func TestRecoverer(t *testing.T) {
+	rec := &recorder{}
+	r := New(WithLogging(log.New("", "", ioutil.Discard)))
+	r.Use(middleware.RequestID, Recoverer(rec))
+	r.Get("/index/{i}", func(w http.ResponseWriter, r *http.Request) {
+		var list []int
+		_ = list[len(r.URL.Path)]
+	})
+	r.Get("/nil", func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["a"]++
+	})
+	r.Get("/started", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("too late")
+	})
+
+	panics := func() int64 {
+		if v, ok := metrics.Get("panics").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := panics()
+
+	for _, path := range []string{"/index/1", "/index/22", "/nil"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("%s: expected status 500, got %d", path, w.Code)
+		}
+		var resp ErrResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: response is not an error envelope: %s", path, w.Body)
+		}
+		if resp.RequestID == "" || resp.ErrorText == "" {
+			t.Errorf("%s: unexpected response %s", path, w.Body)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/started", nil))
+	if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+		t.Errorf("started response is overwritten: %d %s", w.Code, w.Body)
+	}
+
+	if n := panics() - before; n != 4 {
+		t.Errorf("unexpected number of panics %d", n)
+	}
+	if len(rec.events) != 4 {
+		t.Fatalf("unexpected events %v", rec.events)
+	}
+	first, second, other := rec.events[0], rec.events[1], rec.events[2]
+	if first.Fingerprint == "" || first.Fingerprint != second.Fingerprint {
+		t.Errorf("panics of the same call site have fingerprints %q and %q", first.Fingerprint, second.Fingerprint)
+	}
+	if other.Fingerprint == first.Fingerprint {
+		t.Errorf("panics of different call sites have the same fingerprint %q", other.Fingerprint)
+	}
+	if first.RequestID == "" || first.Stack == nil {
+		t.Errorf("unexpected event %+v", first)
+	}
+}
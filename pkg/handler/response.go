@@ -4,6 +4,10 @@ import (
 	"net/http"
 
 	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
 )
 
 // ErrResponse renderer type for handling all sorts of errors.
@@ -18,9 +22,18 @@ type ErrResponse struct {
 	StatusText string `json:"status"`          // user-level status message
 	AppCode    int64  `json:"code,omitempty"`  // application-specific error code
 	ErrorText  string `json:"error,omitempty"` // application-level error message, for debugging
+	RequestID  string `json:"request_id,omitempty"`
 }
 
+// Render localizes status and errors created with i18n.Errorf to the request locale. Request ID lets
+// clients refer to the request when they report errors.
 func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	locale := reqctx.GetLocale(r.Context())
+	e.RequestID = reqctx.GetRequestID(r.Context())
+	e.StatusText = i18n.StatusText(locale, e.HTTPStatusCode)
+	if le, ok := errors.Cause(e.Err).(*i18n.Error); ok {
+		e.ErrorText = le.Localize(locale)
+	}
 	render.Status(r, e.HTTPStatusCode)
 	return nil
 }
@@ -61,6 +74,15 @@ func ErrForbidden(err error) render.Renderer {
 	}
 }
 
+func ErrConflict(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusConflict,
+		StatusText:     http.StatusText(http.StatusConflict),
+		ErrorText:      err.Error(),
+	}
+}
+
 func ErrBadRequest(err error) render.Renderer {
 	return &ErrResponse{
 		Err:            err,
@@ -69,3 +91,30 @@ func ErrBadRequest(err error) render.Renderer {
 		ErrorText:      err.Error(),
 	}
 }
+
+func ErrRequestEntityTooLarge(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusRequestEntityTooLarge,
+		StatusText:     http.StatusText(http.StatusRequestEntityTooLarge),
+		ErrorText:      err.Error(),
+	}
+}
+
+func ErrTooManyRequests(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusTooManyRequests,
+		StatusText:     http.StatusText(http.StatusTooManyRequests),
+		ErrorText:      err.Error(),
+	}
+}
+
+func ErrServiceUnavailable(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: http.StatusServiceUnavailable,
+		StatusText:     http.StatusText(http.StatusServiceUnavailable),
+		ErrorText:      err.Error(),
+	}
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// RequireRole rejects requests of anonymous clients with 401 and of users without role with 403,
+// empty role requires a signed in user only.
+func RequireRole(role string) func(next http.Handler) http.Handler {
+	return RequireRoleFunc(role, func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		render.Render(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: status,
+			StatusText:     http.StatusText(status),
+			ErrorText:      err.Error(),
+		})
+	})
+}
+
+// RequireRoleFunc is RequireRole which renders errors with fn, e.g. in format of protocol API implements.
+func RequireRoleFunc(role string, fn func(w http.ResponseWriter, r *http.Request, status int, err error)) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := reqctx.GetUser(r.Context())
+			if u == nil {
+				fn(w, r, http.StatusUnauthorized, i18n.Errorf("request.user_required"))
+				return
+			}
+			if role != "" && !u.HasRole(role) {
+				fn(w, r, http.StatusForbidden, i18n.Errorf("request.role_required", role))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+func TestRequireRole(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		role   string
+		user   *reqctx.User
+		status int
+		error  string
+	}{
+		{reqctx.AdminRole, nil, http.StatusUnauthorized, "sign in is required"},
+		{reqctx.AdminRole, &reqctx.User{ID: "1", Roles: []string{"editor"}}, http.StatusForbidden, "admin role is required"},
+		{reqctx.AdminRole, &reqctx.User{ID: "1", Roles: []string{"editor", reqctx.AdminRole}}, http.StatusOK, ""},
+		{"", nil, http.StatusUnauthorized, "sign in is required"},
+		{"", &reqctx.User{ID: "1"}, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.user != nil {
+			req = req.WithContext(reqctx.WithUser(req.Context(), tt.user))
+		}
+		w := httptest.NewRecorder()
+		RequireRole(tt.role)(ok).ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%q %+v: expected status %d, got %d", tt.role, tt.user, tt.status, w.Code)
+			continue
+		}
+		if tt.error == "" {
+			continue
+		}
+		var resp ErrResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.ErrorText != tt.error {
+			t.Errorf("%q %+v: expected error %q, got %q", tt.role, tt.user, tt.error, resp.ErrorText)
+		}
+	}
+}
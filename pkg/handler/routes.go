@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// RouteMeta describes a route registered in the router.
+type RouteMeta struct {
+	Pattern string
+	Methods []string
+	// Allow is a precomputed value of Allow header, it includes automatic HEAD and OPTIONS.
+	Allow string
+
+	segments []segment
+}
+
+// Allows reports whether route has a registered handler for method.
+func (m *RouteMeta) Allows(method string) bool {
+	for _, mt := range m.Methods {
+		if mt == method {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteTable is a snapshot of router routes.
+// Compile it once after all routes are registered, lookups do not allocate.
+type RouteTable struct {
+	byPattern map[string]*RouteMeta
+	// sorted from the most specific to the least specific route
+	routes []*RouteMeta
+}
+
+// NewRouteTable walks the router and compiles all of its routes.
+func NewRouteTable(r chi.Routes) *RouteTable {
+	t := &RouteTable{}
+	t.Compile(r)
+	return t
+}
+
+// Compile walks the router and replaces the snapshot. Middlewares which use the table
+// may be registered with an empty table before routes, it must be compiled before serving requests.
+func (t *RouteTable) Compile(r chi.Routes) {
+	t.byPattern = make(map[string]*RouteMeta)
+	t.routes = nil
+	walk(r, "", func(pattern string, methods []string) {
+		m, ok := t.byPattern[pattern]
+		if !ok {
+			m = &RouteMeta{Pattern: pattern, segments: compile(pattern)}
+			t.byPattern[pattern] = m
+			t.routes = append(t.routes, m)
+		}
+		m.Methods = append(m.Methods, methods...)
+	})
+
+	for _, m := range t.routes {
+		sort.Strings(m.Methods)
+		m.Allow = strings.Join(implied(m.Methods), ", ")
+	}
+	sort.SliceStable(t.routes, func(i, j int) bool {
+		return moreSpecific(t.routes[i].segments, t.routes[j].segments)
+	})
+}
+
+// Params returns values of {name} segments of route in path, path must match the route.
+func (m *RouteMeta) Params(path string) map[string]string {
+	params := make(map[string]string)
+	path = strings.Trim(path, "/")
+	for _, s := range m.segments {
+		if s.kind == segmentWildcard || path == "" {
+			break
+		}
+		part := path
+		rest := ""
+		if i := strings.IndexByte(path, '/'); i >= 0 {
+			part, rest = path[:i], path[i+1:]
+		}
+		if s.kind == segmentParam {
+			params[s.value] = part
+		}
+		path = rest
+	}
+	return params
+}
+
+// Lookup returns route by its full pattern, e.g. /1.0/articles/{articleID}/
+func (t *RouteTable) Lookup(pattern string) *RouteMeta {
+	return t.byPattern[pattern]
+}
+
+// Match returns the most specific route matching path regardless of method.
+func (t *RouteTable) Match(path string) *RouteMeta {
+	for _, m := range t.routes {
+		if match(m.segments, path) {
+			return m
+		}
+	}
+	return nil
+}
+
+// Routes returns all routes from the most specific to the least specific one.
+func (t *RouteTable) Routes() []*RouteMeta {
+	return t.routes
+}
+
+// RoutePattern returns full pattern of the route which served request, e.g. /1.0/articles/{articleID}/
+func RoutePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+	var pattern string
+	for _, p := range rctx.RoutePatterns {
+		pattern = strings.TrimSuffix(pattern, "/*") + p
+	}
+	return pattern
+}
+
+func walk(r chi.Routes, prefix string, fn func(pattern string, methods []string)) {
+	for _, rt := range r.Routes() {
+		pattern := prefix + rt.Pattern
+		if rt.SubRoutes != nil {
+			walk(rt.SubRoutes, strings.TrimSuffix(pattern, "/"), fn)
+			continue
+		}
+		var methods []string
+		for m := range rt.Handlers {
+			if m != "*" {
+				methods = append(methods, m)
+			}
+		}
+		fn(pattern, methods)
+	}
+}
+
+// implied adds methods served automatically by AutoMethods, methods must be sorted.
+func implied(methods []string) []string {
+	res := append([]string(nil), methods...)
+	has := func(m string) bool {
+		for _, mt := range res {
+			if mt == m {
+				return true
+			}
+		}
+		return false
+	}
+	if has(http.MethodGet) && !has(http.MethodHead) {
+		res = append(res, http.MethodHead)
+	}
+	if !has(http.MethodOptions) {
+		res = append(res, http.MethodOptions)
+	}
+	sort.Strings(res)
+	return res
+}
+
+type segmentKind int
+
+const (
+	segmentStatic segmentKind = iota
+	segmentParam
+	segmentWildcard
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+func compile(pattern string) []segment {
+	var segs []segment
+	for _, s := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		switch {
+		case s == "*":
+			segs = append(segs, segment{kind: segmentWildcard})
+		case strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}"):
+			segs = append(segs, segment{kind: segmentParam, value: s[1 : len(s)-1]})
+		case s != "":
+			segs = append(segs, segment{kind: segmentStatic, value: s})
+		}
+	}
+	return segs
+}
+
+// match walks path segments in place without splitting it.
+func match(segs []segment, path string) bool {
+	path = strings.Trim(path, "/")
+	for _, s := range segs {
+		if s.kind == segmentWildcard {
+			return true
+		}
+		if path == "" {
+			return false
+		}
+		part := path
+		rest := ""
+		if i := strings.IndexByte(path, '/'); i >= 0 {
+			part, rest = path[:i], path[i+1:]
+		}
+		if s.kind == segmentStatic && s.value != part {
+			return false
+		}
+		path = rest
+	}
+	return path == ""
+}
+
+func moreSpecific(a, b []segment) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].kind != b[i].kind {
+			return a[i].kind < b[i].kind
+		}
+	}
+	return len(a) > len(b)
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func testRoutes() *RouteTable {
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := chi.NewRouter()
+	r.Get("/readiness", noop)
+	r.Route("/1.0", func(r chi.Router) {
+		r.Route("/articles", func(r chi.Router) {
+			r.Get("/", noop)
+			r.Get("/stats", noop)
+			r.Put("/{articleID}", noop)
+			r.Delete("/{articleID}", noop)
+		})
+	})
+	return NewRouteTable(r)
+}
+
+func TestRouteTable(t *testing.T) {
+	table := testRoutes()
+
+	m := table.Lookup("/1.0/articles/{articleID}")
+	if m == nil {
+		t.Fatal("route not found")
+	}
+	if m.Allow != "DELETE, OPTIONS, PUT" {
+		t.Errorf("unexpected route meta: %+v", m)
+	}
+
+	if m := table.Match("/1.0/articles/stats"); m == nil || m.Pattern != "/1.0/articles/stats" {
+		t.Errorf("expected static route to win, got %+v", m)
+	}
+	if m := table.Match("/1.0/articles/1"); m == nil || m.Pattern != "/1.0/articles/{articleID}" {
+		t.Errorf("expected param route, got %+v", m)
+	}
+	if m := table.Match("/1.0/articles/1/comments"); m != nil {
+		t.Errorf("expected no route, got %+v", m)
+	}
+	if params := m.Params("/1.0/articles/a%2Fb/"); len(params) != 1 || params["articleID"] != "a%2Fb" {
+		t.Errorf("unexpected params %v", params)
+	}
+}
@@ -0,0 +1,49 @@
+package handler
+
+import "net/http"
+
+// SecurityHeaders are response headers which restrict what browsers may do with content, empty ones are not sent.
+type SecurityHeaders struct {
+	// StrictTransportSecurity is honored by browsers only over HTTPS.
+	StrictTransportSecurity string
+	ContentTypeOptions      string
+	FrameOptions            string
+	ContentSecurityPolicy   string
+	ReferrerPolicy          string
+}
+
+// DefaultSecurityHeaders suit JSON API, which is never rendered as a page.
+var DefaultSecurityHeaders = SecurityHeaders{
+	StrictTransportSecurity: "max-age=31536000; includeSubDomains",
+	ContentTypeOptions:      "nosniff",
+	FrameOptions:            "DENY",
+	ContentSecurityPolicy:   "default-src 'none'; frame-ancestors 'none'",
+	ReferrerPolicy:          "no-referrer",
+}
+
+// Apply sets headers, and removes empty ones, so it overrides headers set before, e.g. by middleware.
+func (s SecurityHeaders) Apply(h http.Header) {
+	set := func(name, value string) {
+		if value == "" {
+			h.Del(name)
+			return
+		}
+		h.Set(name, value)
+	}
+	set("Strict-Transport-Security", s.StrictTransportSecurity)
+	set("X-Content-Type-Options", s.ContentTypeOptions)
+	set("X-Frame-Options", s.FrameOptions)
+	set("Content-Security-Policy", s.ContentSecurityPolicy)
+	set("Referrer-Policy", s.ReferrerPolicy)
+}
+
+// Secure sets security headers of all responses, routes serving pages override them,
+// e.g. with StaticConfig.Security.
+func Secure(s SecurityHeaders) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.Apply(w.Header())
+			next.ServeHTTP(w, r)
+		})
+	}
+}
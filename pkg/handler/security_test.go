@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestSecure(t *testing.T) {
+	pages := DefaultSecurityHeaders
+	pages.ContentSecurityPolicy = "default-src 'self'"
+	pages.FrameOptions = ""
+
+	r := chi.NewRouter()
+	r.Use(Secure(DefaultSecurityHeaders))
+	r.Get("/api", func(w http.ResponseWriter, r *http.Request) {})
+	FileServer(r, "/docs", http.Dir("."), StaticConfig{Security: &pages})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api", nil))
+	h := w.Header()
+	if h.Get("Strict-Transport-Security") != DefaultSecurityHeaders.StrictTransportSecurity ||
+		h.Get("X-Content-Type-Options") != "nosniff" || h.Get("X-Frame-Options") != "DENY" ||
+		h.Get("Content-Security-Policy") != DefaultSecurityHeaders.ContentSecurityPolicy || h.Get("Referrer-Policy") != "no-referrer" {
+		t.Errorf("unexpected API headers: %v", h)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs/security.go", nil))
+	h = w.Header()
+	if w.Code != http.StatusOK || h.Get("Content-Security-Policy") != "default-src 'self'" || h.Get("X-Frame-Options") != "" ||
+		h.Get("Strict-Transport-Security") == "" {
+		t.Errorf("unexpected docs headers: %v %v", w.Code, h)
+	}
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// StaticConfig configures FileServer.
+type StaticConfig struct {
+	// CacheControl is sent with files, e.g. "public, max-age=300", none is sent if empty.
+	CacheControl string
+	// ListDirectories allows listings of folders without index.html, they are not found otherwise.
+	ListDirectories bool
+	// Fallback is a file served instead of missing ones without extension, e.g. /index.html
+	// of a single-page app which routes on client. Missing assets are still not found.
+	Fallback string
+	// Immutable reports whether file never changes, e.g. HashedAsset, such files are cached for a year.
+	Immutable func(name string) bool
+	// Security overrides headers set by Secure middleware, e.g. pages need a looser policy than API.
+	Security *SecurityHeaders
+}
+
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// hashedAsset matches names with content hash added by bundlers, e.g. app.3f9a1c2b.js or chunk-5d41402abc4b2a76.css.
+var hashedAsset = regexp.MustCompile(`[.-][0-9a-fA-F]{8,}\.[a-z0-9]+$`)
+
+// HashedAsset reports whether file name has a content hash.
+func HashedAsset(name string) bool {
+	return hashedAsset.MatchString(path.Base(name))
+}
+
+// staticTypes are types of files built frontends consist of, which are missing from mime builtin table
+// and from systems without mime.types, e.g. alpine.
+var staticTypes = map[string]string{
+	".ico":         "image/x-icon",
+	".map":         "application/json",
+	".otf":         "font/otf",
+	".ttf":         "font/ttf",
+	".txt":         "text/plain; charset=utf-8",
+	".webmanifest": "application/manifest+json",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+}
+
+func init() {
+	for ext, typ := range staticTypes {
+		if mime.TypeByExtension(ext) == "" {
+			mime.AddExtensionType(ext, typ)
+		}
+	}
+}
+
+// FileServer serves static files from root at path, which must not contain URL parameters.
+// Files are served with ETag and Last-Modified, so clients can revalidate them, and support Range requests.
+func FileServer(r chi.Router, path string, root http.FileSystem, cfg StaticConfig) {
+	if strings.ContainsAny(path, "{}*") {
+		panic("FileServer does not permit URL parameters.")
+	}
+
+	prefix := strings.TrimSuffix(path, "/")
+	if path != "/" && path[len(path)-1] != '/' {
+		r.Get(path, http.RedirectHandler(path+"/", 301).ServeHTTP)
+		path += "/"
+	}
+	path += "*"
+
+	s := &staticServer{root: root, cfg: cfg, prefix: prefix, listing: http.StripPrefix(prefix, http.FileServer(root))}
+	r.Get(path, s.ServeHTTP)
+}
+
+type staticServer struct {
+	root    http.FileSystem
+	cfg     StaticConfig
+	prefix  string
+	listing http.Handler
+}
+
+func (s *staticServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.cfg.Security != nil {
+		s.cfg.Security.Apply(w.Header())
+	}
+	if strings.ContainsAny(r.URL.Path, "\x00\\") {
+		writeStaticProblem(w, r, http.StatusBadRequest)
+		return
+	}
+	// cleaning a rooted path drops every .. which would leave root
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, s.prefix))
+
+	f, fi, err := s.open(name)
+	if err != nil && s.cfg.Fallback != "" && path.Ext(name) == "" {
+		name = s.cfg.Fallback
+		f, fi, err = s.open(name)
+	}
+	if err != nil {
+		if os.IsNotExist(err) || os.IsPermission(err) {
+			writeStaticProblem(w, r, http.StatusNotFound)
+			return
+		}
+		writeStaticProblem(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if fi.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+			return
+		}
+		index, ifi, err := s.open(path.Join(name, "index.html"))
+		if err != nil {
+			if s.cfg.ListDirectories {
+				s.listing.ServeHTTP(w, r)
+				return
+			}
+			writeStaticProblem(w, r, http.StatusNotFound)
+			return
+		}
+		defer index.Close()
+		f, fi = index, ifi
+	}
+
+	h := w.Header()
+	// the same validator as nginx, it is strong, so interrupted downloads can be resumed with If-Range
+	h.Set("ETag", fmt.Sprintf(`"%x-%x"`, fi.ModTime().Unix(), fi.Size()))
+	h.Set("X-Content-Type-Options", "nosniff")
+	if s.cfg.Immutable != nil && s.cfg.Immutable(name) {
+		h.Set("Cache-Control", immutableCacheControl)
+	} else if s.cfg.CacheControl != "" {
+		h.Set("Cache-Control", s.cfg.CacheControl)
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// open returns file with its info, directories are returned as is.
+func (s *staticServer) open(name string) (http.File, os.FileInfo, error) {
+	f, err := s.root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+func writeStaticProblem(w http.ResponseWriter, r *http.Request, status int) {
+	WriteProblem(w, &Problem{
+		Title:    i18n.StatusText(reqctx.GetLocale(r.Context()), status),
+		Status:   status,
+		Instance: r.URL.Path,
+	})
+}
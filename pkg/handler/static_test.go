@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func staticRoot(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "public", "guide"), 0755)
+	os.MkdirAll(filepath.Join(dir, "public", "empty"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "public", "index.html"), []byte("<html>index</html>"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "public", "app.js"), []byte("console.log(1)"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "public", "guide", "index.html"), []byte("<html>guide</html>"), 0644)
+	return dir
+}
+
+func TestFileServer(t *testing.T) {
+	dir := staticRoot(t)
+	defer os.RemoveAll(dir)
+
+	r := chi.NewRouter()
+	FileServer(r, "/docs", http.Dir(filepath.Join(dir, "public")), StaticConfig{CacheControl: "public, max-age=60"})
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/docs", http.StatusMovedPermanently, ""},
+		{"/docs/", http.StatusOK, "<html>index</html>"},
+		{"/docs/app.js", http.StatusOK, "console.log(1)"},
+		{"/docs/guide", http.StatusMovedPermanently, ""},
+		{"/docs/guide/", http.StatusOK, "<html>guide</html>"},
+		{"/docs/empty/", http.StatusNotFound, ""},
+		{"/docs/missing.js", http.StatusNotFound, ""},
+		{"/docs/../secret.txt", http.StatusNotFound, ""},
+		{"/docs/guide/../../secret.txt", http.StatusNotFound, ""},
+		{"/docs/%2e%2e/secret.txt", http.StatusNotFound, ""},
+		{"/docs/..%5csecret.txt", http.StatusBadRequest, ""},
+		{"/docs/app.js%00.html", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if w.Code != tt.status {
+			t.Errorf("%s: unexpected status: %v", tt.path, w.Code)
+			continue
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: unexpected body: %q", tt.path, w.Body.String())
+		}
+		if w.Code == http.StatusOK && (w.Header().Get("ETag") == "" || w.Header().Get("Last-Modified") == "" ||
+			w.Header().Get("Cache-Control") != "public, max-age=60") {
+			t.Errorf("%s: unexpected headers: %v", tt.path, w.Header())
+		}
+	}
+}
+
+func TestFileServer_Conditional(t *testing.T) {
+	dir := staticRoot(t)
+	defer os.RemoveAll(dir)
+
+	r := chi.NewRouter()
+	FileServer(r, "/", http.Dir(filepath.Join(dir, "public")), StaticConfig{})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app.js", nil))
+	etag := w.Header().Get("ETag")
+	if w.Header().Get("Cache-Control") != "" {
+		t.Errorf("unexpected Cache-Control: %q", w.Header().Get("Cache-Control"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("unexpected status of revalidation: %v", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	req.Header.Set("Range", "bytes=0-6")
+	req.Header.Set("If-Range", etag)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "console" {
+		t.Errorf("unexpected range response: %v %q", w.Code, w.Body.String())
+	}
+}
+
+func TestFileServer_Options(t *testing.T) {
+	dir := staticRoot(t)
+	defer os.RemoveAll(dir)
+
+	r := chi.NewRouter()
+	FileServer(r, "/app", http.Dir(filepath.Join(dir, "public")), StaticConfig{ListDirectories: true, Fallback: "/index.html"})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app/empty/", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("directory is not listed: %v %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app/articles/42", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "<html>index</html>" {
+		t.Errorf("fallback is not served: %v %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app/missing.js", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("fallback is served instead of missing asset: %v", w.Code)
+	}
+}
+
+func TestFileServer_SPA(t *testing.T) {
+	dir := staticRoot(t)
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "public", "main.3f9a1c2b.js"), []byte("console.log(2)"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "public", "font-5d41402abc4b2a76.woff2"), []byte("font"), 0644)
+
+	r := chi.NewRouter()
+	r.Get("/1.0/ping", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("pong")) })
+	FileServer(r, "/", http.Dir(filepath.Join(dir, "public")), StaticConfig{CacheControl: "no-cache", Fallback: "/index.html", Immutable: HashedAsset})
+
+	tests := []struct {
+		path         string
+		body         string
+		contentType  string
+		cacheControl string
+	}{
+		{"/1.0/ping", "pong", "", ""},
+		{"/", "<html>index</html>", "text/html; charset=utf-8", "no-cache"},
+		{"/articles/42/edit", "<html>index</html>", "text/html; charset=utf-8", "no-cache"},
+		{"/app.js", "console.log(1)", "javascript", "no-cache"},
+		{"/main.3f9a1c2b.js", "console.log(2)", "javascript", immutableCacheControl},
+		{"/font-5d41402abc4b2a76.woff2", "font", "font/woff2", immutableCacheControl},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != tt.body {
+			t.Errorf("%s: unexpected response: %v %q", tt.path, w.Code, w.Body.String())
+			continue
+		}
+		if !strings.Contains(w.Header().Get("Content-Type"), tt.contentType) {
+			t.Errorf("%s: unexpected Content-Type: %q", tt.path, w.Header().Get("Content-Type"))
+		}
+		if w.Header().Get("Cache-Control") != tt.cacheControl {
+			t.Errorf("%s: unexpected Cache-Control: %q", tt.path, w.Header().Get("Cache-Control"))
+		}
+	}
+}
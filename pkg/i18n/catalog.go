@@ -0,0 +1,85 @@
+package i18n
+
+func init() {
+	Register("en", Catalog{
+		"status.400": "Bad Request",
+		"status.401": "Unauthorized",
+		"status.403": "Forbidden",
+		"status.404": "Not Found",
+		"status.405": "Method Not Allowed",
+		"status.409": "Conflict",
+		"status.413": "Request Entity Too Large",
+		"status.429": "Too Many Requests",
+		"status.500": "Internal Server Error",
+		"status.503": "Service Unavailable",
+
+		"route.not_found":          "no route matches %s",
+		"route.method_not_allowed": "%s is not allowed for %s",
+		"route.allowed_methods":    "allowed methods: %s",
+
+		"request.invalid_body":  "request body is not valid JSON",
+		"request.invalid_time":  "%v is not a valid timestamp, use RFC 3339",
+		"request.invalid_tz":    "unknown time zone %s",
+		"request.invalid_param": "%s must be an integer from %d to %d",
+		"request.panic":         "unexpected error, it is reported, mention request ID if you contact support",
+		"request.user_required": "sign in is required",
+		"request.role_required": "%s role is required",
+
+		"ratelimit.exceeded": "rate limit of %d requests per %v exceeded, retry in %s seconds",
+
+		"budget.exceeded": "request took longer than %v, retry later",
+
+		"ipfilter.forbidden": "access to %s is not allowed from your network",
+
+		"signedurl.invalid": "signature of link is not valid",
+		"signedurl.expired": "link is expired, ask for a new one",
+
+		"authz.denied": "access policy does not allow this request",
+
+		"jwtauth.invalid":     "access token is not valid",
+		"jwtauth.expired":     "access token is expired, sign in again",
+		"jwtauth.revoked":     "access token is revoked, sign in again",
+		"jwtauth.unavailable": "access token could not be validated, retry later",
+	})
+
+	Register("ru", Catalog{
+		"status.400": "Некорректный запрос",
+		"status.401": "Требуется авторизация",
+		"status.403": "Доступ запрещён",
+		"status.404": "Не найдено",
+		"status.405": "Метод не поддерживается",
+		"status.409": "Конфликт",
+		"status.413": "Слишком большой запрос",
+		"status.429": "Слишком много запросов",
+		"status.500": "Внутренняя ошибка сервера",
+		"status.503": "Сервис недоступен",
+
+		"route.not_found":          "нет маршрута для %s",
+		"route.method_not_allowed": "метод %s не поддерживается для %s",
+		"route.allowed_methods":    "допустимые методы: %s",
+
+		"request.invalid_body":  "тело запроса не является корректным JSON",
+		"request.invalid_time":  "%v не является корректной меткой времени, используйте RFC 3339",
+		"request.invalid_tz":    "неизвестный часовой пояс %s",
+		"request.invalid_param": "%s должен быть целым числом от %d до %d",
+		"request.panic":         "непредвиденная ошибка, мы уже получили отчёт о ней, укажите ID запроса, если обратитесь в поддержку",
+		"request.user_required": "требуется вход",
+		"request.role_required": "требуется роль %s",
+
+		"ratelimit.exceeded": "превышен лимит в %d запросов за %v, повторите через %s с",
+
+		"budget.exceeded": "запрос выполнялся дольше %v, повторите позже",
+
+		"ipfilter.forbidden": "доступ к %s из вашей сети запрещён",
+
+		"signedurl.invalid": "подпись ссылки недействительна",
+		"signedurl.expired": "срок действия ссылки истёк, запросите новую",
+
+		"authz.denied": "политика доступа не разрешает этот запрос",
+
+		"jwtauth.invalid":     "токен доступа недействителен",
+		"jwtauth.expired":     "срок действия токена доступа истёк, войдите снова",
+		"jwtauth.revoked":     "токен доступа отозван, войдите снова",
+		"jwtauth.unavailable": "не удалось проверить токен доступа, повторите позже",
+	})
+}
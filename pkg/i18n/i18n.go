@@ -0,0 +1,194 @@
+// Package i18n provides message catalogs and Accept-Language negotiation.
+//
+// Catalogs are compiled into the binary, modules register their messages from init:
+//
+//	func init() {
+//	    i18n.Register("en", i18n.Catalog{"article.title_required": "title is required"})
+//	}
+package i18n
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// DefaultLocale is used when message is missing in the requested locale.
+const DefaultLocale = "en"
+
+// Catalog maps message keys to format strings.
+type Catalog map[string]string
+
+var catalogs = map[string]Catalog{}
+
+// Register merges messages into locale catalog, it is not safe for concurrent use and must be called from init.
+func Register(locale string, c Catalog) {
+	cat, ok := catalogs[locale]
+	if !ok {
+		cat = Catalog{}
+		catalogs[locale] = cat
+	}
+	for k, v := range c {
+		cat[k] = v
+	}
+}
+
+// Locales returns registered locales.
+func Locales() []string {
+	var res []string
+	for l := range catalogs {
+		res = append(res, l)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Translate formats message in locale, falls back to DefaultLocale and then to the key itself.
+func Translate(locale, key string, args ...interface{}) string {
+	msg, ok := catalogs[locale][key]
+	if !ok {
+		msg, ok = catalogs[DefaultLocale][key]
+	}
+	if !ok {
+		msg = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// T translates message to the request locale.
+func T(r *http.Request, key string, args ...interface{}) string {
+	return Translate(reqctx.GetLocale(r.Context()), key, args...)
+}
+
+// StatusText returns localized text for HTTP status code.
+func StatusText(locale string, code int) string {
+	key := "status." + strconv.Itoa(code)
+	if _, ok := catalogs[locale][key]; !ok {
+		return http.StatusText(code)
+	}
+	return Translate(locale, key)
+}
+
+// Label is a machine enum value with its localized display label.
+type Label struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+// EnumLabel returns localized label of enum value, catalog key is "enum.<enum>.<value>".
+// Value itself is returned when there is no translation.
+func EnumLabel(locale, enum, value string) string {
+	key := "enum." + enum + "." + value
+	if _, ok := catalogs[locale][key]; !ok {
+		if _, ok := catalogs[DefaultLocale][key]; !ok {
+			return value
+		}
+	}
+	return Translate(locale, key)
+}
+
+// Labels returns localized labels of enum values in the given order.
+func Labels(locale, enum string, values ...string) []Label {
+	res := make([]Label, 0, len(values))
+	for _, v := range values {
+		res = append(res, Label{Value: v, Label: EnumLabel(locale, enum, v)})
+	}
+	return res
+}
+
+// Error is an error with localizable message.
+type Error struct {
+	Key  string
+	Args []interface{}
+}
+
+// Errorf creates error which message is translated on rendering.
+func Errorf(key string, args ...interface{}) *Error {
+	return &Error{Key: key, Args: args}
+}
+
+// Error returns message in DefaultLocale, it is what gets logged.
+func (e *Error) Error() string {
+	return Translate(DefaultLocale, e.Key, e.Args...)
+}
+
+func (e *Error) Localize(locale string) string {
+	return Translate(locale, e.Key, e.Args...)
+}
+
+// Negotiate picks the best registered locale from Accept-Language header.
+// Regional variants match their base language, e.g. ru-RU matches ru.
+func Negotiate(acceptLanguage, fallback string) string {
+	for _, lang := range Preferences(acceptLanguage) {
+		if _, ok := catalogs[lang]; ok {
+			return lang
+		}
+	}
+	return fallback
+}
+
+// Preferences returns lowercase languages of Accept-Language header from the most preferred one, regional
+// variants are followed by their base language, e.g. "pt-BR, en;q=0.5" gives pt-br, pt, en.
+// Languages with q=0 and wildcard are left out.
+func Preferences(acceptLanguage string) []string {
+	type tag struct {
+		lang string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tag{lang, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	var res []string
+	seen := make(map[string]bool)
+	for _, t := range tags {
+		langs := []string{t.lang}
+		if i := strings.IndexByte(t.lang, '-'); i > 0 {
+			langs = append(langs, t.lang[:i])
+		}
+		for _, lang := range langs {
+			if !seen[lang] {
+				seen[lang] = true
+				res = append(res, lang)
+			}
+		}
+	}
+	return res
+}
+
+// Middleware negotiates request locale and stores it in request context.
+func Middleware(fallback string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			locale := Negotiate(r.Header.Get("Accept-Language"), fallback)
+			w.Header().Set("Content-Language", locale)
+			w.Header().Add("Vary", "Accept-Language")
+			next.ServeHTTP(w, r.WithContext(reqctx.WithLocale(r.Context(), locale)))
+		})
+	}
+}
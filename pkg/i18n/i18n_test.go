@@ -0,0 +1,63 @@
+package i18n
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"", "en"},
+		{"ru", "ru"},
+		{"ru-RU,ru;q=0.9,en;q=0.8", "ru"},
+		{"de-DE, en;q=0.5", "en"},
+		{"en;q=0.3, ru;q=0.7", "ru"},
+		{"fr", "en"},
+		{"ru;q=0", "en"},
+	}
+	for _, tt := range tests {
+		if l := Negotiate(tt.header, "en"); l != tt.expected {
+			t.Errorf("%q: expected %v, got %v", tt.header, tt.expected, l)
+		}
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected []string
+	}{
+		{"", nil},
+		{"pt-BR, en;q=0.5", []string{"pt-br", "pt", "en"}},
+		{"ru;q=0.5, de-AT, de-DE;q=0.8, *;q=0.1", []string{"de-at", "de", "de-de", "ru"}},
+		{"en;q=0", nil},
+	}
+	for _, tt := range tests {
+		if langs := Preferences(tt.header); !reflect.DeepEqual(langs, tt.expected) {
+			t.Errorf("%q: expected %v, got %v", tt.header, tt.expected, langs)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	if msg := Translate("ru", "route.not_found", "/x"); msg != "нет маршрута для /x" {
+		t.Errorf("unexpected message: %v", msg)
+	}
+	if msg := Translate("fr", "route.not_found", "/x"); msg != "no route matches /x" {
+		t.Errorf("expected fallback to default locale, got %v", msg)
+	}
+	if msg := Translate("ru", "missing.key"); msg != "missing.key" {
+		t.Errorf("expected fallback to key, got %v", msg)
+	}
+	if text := StatusText("fr", 404); text != "Not Found" {
+		t.Errorf("unexpected status text: %v", text)
+	}
+
+	err := Errorf("route.allowed_methods", "GET")
+	if err.Error() != "allowed methods: GET" || err.Localize("ru") != "допустимые методы: GET" {
+		t.Errorf("unexpected error messages: %v, %v", err.Error(), err.Localize("ru"))
+	}
+}
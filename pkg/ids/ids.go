@@ -0,0 +1,44 @@
+// Package ids generates identifiers, tests replace random ones with a deterministic sequence.
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Generator makes unique identifiers.
+type Generator interface {
+	NewID() (string, error)
+}
+
+// Random generates 128-bit random hex identifiers.
+var Random Generator = random{}
+
+type random struct{}
+
+func (random) NewID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", errors.Wrap(err, "could not generate id")
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Sequence generates identifiers Prefix1, Prefix2 and so on.
+type Sequence struct {
+	Prefix string
+
+	mu sync.Mutex
+	n  int
+}
+
+func (s *Sequence) NewID() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	return fmt.Sprintf("%s%d", s.Prefix, s.n), nil
+}
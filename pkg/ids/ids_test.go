@@ -0,0 +1,23 @@
+package ids
+
+import "testing"
+
+func TestRandom(t *testing.T) {
+	a, err := Random.NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Random.NewID()
+	if len(a) != 32 || a == b {
+		t.Errorf("unexpected ids: %q %q", a, b)
+	}
+}
+
+func TestSequence(t *testing.T) {
+	s := &Sequence{Prefix: "event-"}
+	for _, want := range []string{"event-1", "event-2"} {
+		if id, _ := s.NewID(); id != want {
+			t.Errorf("unexpected id: %q", id)
+		}
+	}
+}
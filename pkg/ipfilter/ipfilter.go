@@ -0,0 +1,167 @@
+// Package ipfilter restricts access to routes by client network, e.g. admin API to office networks.
+//
+// Client address is taken from request RemoteAddr, so forwarded headers are honored
+// exactly as far as the realip middleware in front of the filter trusts them.
+package ipfilter
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// metrics are exposed with expvar as ipfilter.<prefix>.rejected.
+var metrics = expvar.NewMap("ipfilter")
+
+// Action of a rule.
+type Action string
+
+const (
+	Allow Action = "allow"
+	Deny  Action = "deny"
+)
+
+// Rule allows or denies network to routes which path starts with Prefix.
+type Rule struct {
+	Prefix  string
+	Action  Action
+	Network *net.IPNet
+}
+
+// ParseRule parses rule in form prefix:action:network, e.g. /1.0/admin/:allow:10.0.0.0/8,
+// where action is allow or deny and network is CIDR or a single address.
+func ParseRule(s string) (Rule, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return Rule{}, errors.Errorf("invalid ip rule %q, expected prefix:action:network", s)
+	}
+	rule := Rule{Prefix: parts[0], Action: Action(parts[1])}
+	if rule.Action != Allow && rule.Action != Deny {
+		return Rule{}, errors.Errorf("invalid ip rule %q, action must be allow or deny", s)
+	}
+	network, err := ParseNetwork(parts[2])
+	if err != nil {
+		return Rule{}, errors.Wrapf(err, "invalid ip rule %q", s)
+	}
+	rule.Network = network
+	return rule, nil
+}
+
+// ParseNetwork parses CIDR, a single address is a network of one host.
+func ParseNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.Errorf("%q is not an IP address or CIDR", s)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, errors.Errorf("%q is not an IP address or CIDR", s)
+	}
+	return network, nil
+}
+
+// group is rules of a prefix.
+type group struct {
+	prefix string
+	allow  []*net.IPNet
+	deny   []*net.IPNet
+}
+
+// Filter checks client addresses by the group of rules with the longest matching prefix.
+type Filter struct {
+	groups []group
+}
+
+func New(rules []Rule) *Filter {
+	byPrefix := make(map[string]*group)
+	var groups []*group
+	for _, r := range rules {
+		g, ok := byPrefix[r.Prefix]
+		if !ok {
+			g = &group{prefix: r.Prefix}
+			byPrefix[r.Prefix] = g
+			groups = append(groups, g)
+		}
+		if r.Action == Allow {
+			g.allow = append(g.allow, r.Network)
+		} else {
+			g.deny = append(g.deny, r.Network)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].prefix) > len(groups[j].prefix) })
+
+	f := &Filter{}
+	for _, g := range groups {
+		f.groups = append(f.groups, *g)
+	}
+	return f
+}
+
+// Allowed reports whether ip may access path and returns prefix of the matched group.
+// Denied networks win, and when group allows some networks, all others are denied.
+func (f *Filter) Allowed(path string, ip net.IP) (bool, string) {
+	for _, g := range f.groups {
+		if !strings.HasPrefix(path, g.prefix) {
+			continue
+		}
+		if ip == nil || contains(g.deny, ip) {
+			return false, g.prefix
+		}
+		return len(g.allow) == 0 || contains(g.allow, ip), g.prefix
+	}
+	return true, ""
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware rejects requests from networks which are not allowed with 403.
+func Middleware(f *Filter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r)
+			allowed, prefix := f.Allowed(r.URL.Path, ip)
+			if allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+			metrics.Add(prefix+".rejected", 1)
+			locale := reqctx.GetLocale(r.Context())
+			handler.WriteProblem(w, &handler.Problem{
+				Title:    i18n.StatusText(locale, http.StatusForbidden),
+				Status:   http.StatusForbidden,
+				Detail:   i18n.Translate(locale, "ipfilter.forbidden", r.URL.Path),
+				Instance: r.URL.Path,
+			})
+		})
+	}
+}
+
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,88 @@
+package ipfilter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		network string
+		valid   bool
+	}{
+		{"/1.0/admin/:allow:10.0.0.0/8", "10.0.0.0/8", true},
+		{"/1.0/admin/:deny:10.1.2.3", "10.1.2.3/32", true},
+		{"/:allow:2001:db8::/32", "2001:db8::/32", true},
+		{"/:allow:::1", "::1/128", true},
+		{"/:block:10.0.0.0/8", "", false},
+		{"/:allow:10.0.0.0/33", "", false},
+		{"/:allow:office", "", false},
+		{"/1.0/admin/", "", false},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.in)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: unexpected error: %v", tt.in, err)
+			continue
+		}
+		if tt.valid && rule.Network.String() != tt.network {
+			t.Errorf("%q: unexpected network: %v", tt.in, rule.Network)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var rules []Rule
+	for _, s := range []string{
+		"/1.0/admin/:allow:10.0.0.0/8",
+		"/1.0/admin/:allow:192.168.1.0/24",
+		"/1.0/admin/:deny:10.0.0.13",
+		"/1.0/:deny:203.0.113.0/24",
+	} {
+		rule, err := ParseRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	h := Middleware(New(rules))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		path   string
+		addr   string
+		status int
+	}{
+		{"/1.0/admin/maintenance", "10.1.2.3:1234", http.StatusOK},
+		{"/1.0/admin/maintenance", "192.168.1.10:1234", http.StatusOK},
+		{"/1.0/admin/maintenance", "10.0.0.13:1234", http.StatusForbidden},
+		{"/1.0/admin/maintenance", "198.51.100.1:1234", http.StatusForbidden},
+		{"/1.0/admin/maintenance", "garbage", http.StatusForbidden},
+		{"/1.0/articles", "198.51.100.1:1234", http.StatusOK},
+		{"/1.0/articles", "203.0.113.7:1234", http.StatusForbidden},
+		// admin group is more specific, it does not inherit denials of /1.0/
+		{"/1.0/admin/maintenance", "203.0.113.7:1234", http.StatusForbidden},
+		{"/readiness", "203.0.113.7:1234", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.RemoteAddr = tt.addr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s from %s: unexpected status: %v", tt.path, tt.addr, w.Code)
+		}
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	n, err := ParseNetwork("192.168.1.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.Contains(net.ParseIP("192.168.1.7")) || n.Contains(net.ParseIP("192.168.1.8")) {
+		t.Errorf("unexpected network: %v", n)
+	}
+}
@@ -0,0 +1,168 @@
+// Package jwtauth authenticates requests with bearer tokens in JWT format. Tokens are verified locally
+// with configured keys, and tokens of external identity provider may be validated online with OAuth2
+// token introspection. IDs of tokens, jti claim, may be revoked before tokens expire.
+package jwtauth
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// metrics are exposed with expvar as jwtauth.{verified,introspected,rejected,revoked,failed}.
+var metrics = expvar.NewMap("jwtauth")
+
+// Introspection modes, they tell which tokens are validated online.
+const (
+	// IntrospectNever validates all tokens locally.
+	IntrospectNever = "never"
+	// IntrospectUnknown validates tokens signed with keys verifier does not have, e.g. by external IdP.
+	IntrospectUnknown = "unknown"
+	// IntrospectAlways validates all tokens online, so tokens revoked by IdP are rejected at once.
+	IntrospectAlways = "always"
+)
+
+type Config struct {
+	// Verifier is nil if tokens are validated online only.
+	Verifier *Verifier
+	// Introspector is nil unless introspection mode is other than never.
+	Introspector *Introspector
+	Introspect   string
+	// Revocations are not checked if nil.
+	Revocations Revocations
+}
+
+// Authenticator validates tokens, it is safe for concurrent use.
+type Authenticator struct {
+	cfg Config
+}
+
+func New(cfg Config) (*Authenticator, error) {
+	switch cfg.Introspect {
+	case "", IntrospectNever:
+		cfg.Introspect = IntrospectNever
+		if cfg.Verifier == nil {
+			return nil, errors.New("tokens can not be validated without keys or introspection")
+		}
+	case IntrospectUnknown, IntrospectAlways:
+		if cfg.Introspector == nil {
+			return nil, errors.Errorf("introspection mode %s requires introspection endpoint", cfg.Introspect)
+		}
+	default:
+		return nil, errors.Errorf("unknown introspection mode %q", cfg.Introspect)
+	}
+	return &Authenticator{cfg: cfg}, nil
+}
+
+// Authenticate returns claims of valid token which is not revoked. ErrInvalid, ErrExpired, ErrUnknownKey
+// and ErrRevoked mean token is not accepted, other errors mean it could not be validated.
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (*Claims, error) {
+	var c *Claims
+	var err error
+	if a.cfg.Introspect == IntrospectAlways || a.cfg.Verifier == nil {
+		c, err = a.cfg.Introspector.Introspect(ctx, token)
+		if err == nil {
+			metrics.Add("introspected", 1)
+		}
+	} else {
+		c, err = a.cfg.Verifier.Verify(token)
+		if err == ErrUnknownKey && a.cfg.Introspect == IntrospectUnknown {
+			c, err = a.cfg.Introspector.Introspect(ctx, token)
+			if err == nil {
+				metrics.Add("introspected", 1)
+			}
+		} else if err == nil {
+			metrics.Add("verified", 1)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if a.cfg.Revocations != nil && c.ID != "" {
+		revoked, err := a.cfg.Revocations.IsRevoked(ctx, c.ID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			metrics.Add("revoked", 1)
+			return nil, ErrRevoked
+		}
+	}
+	return c, nil
+}
+
+// Rejected reports whether err means token is not accepted rather than it could not be validated.
+func Rejected(err error) bool {
+	switch err {
+	case ErrInvalid, ErrExpired, ErrUnknownKey, ErrRevoked:
+		return true
+	}
+	return false
+}
+
+// Middleware sets user and tenant of requests with Authorization: Bearer token, other requests pass through.
+// Requests with tokens which are not accepted are rejected with 401, and with 503 if tokens could not be
+// validated, e.g. identity provider or revocation list is unavailable.
+func Middleware(a *Authenticator) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearer(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			c, err := a.Authenticate(r.Context(), token)
+			if err != nil {
+				logger := log.GetLogEntry(r).WithField("context", "jwtauth")
+				locale := reqctx.GetLocale(r.Context())
+				p := &handler.Problem{Instance: r.URL.Path}
+				if Rejected(err) {
+					metrics.Add("rejected", 1)
+					logger.WithError(err).Warn("token is rejected")
+					key := "jwtauth.invalid"
+					switch err {
+					case ErrExpired:
+						key = "jwtauth.expired"
+					case ErrRevoked:
+						key = "jwtauth.revoked"
+					}
+					p.Status, p.Detail = http.StatusUnauthorized, i18n.Translate(locale, key)
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				} else {
+					metrics.Add("failed", 1)
+					logger.WithError(err).Error("could not validate token")
+					p.Status, p.Detail = http.StatusServiceUnavailable, i18n.Translate(locale, "jwtauth.unavailable")
+				}
+				p.Title = i18n.StatusText(locale, p.Status)
+				handler.WriteProblem(w, p)
+				return
+			}
+
+			ctx := reqctx.WithUser(r.Context(), &reqctx.User{ID: c.Subject, Roles: c.Roles})
+			if c.Tenant != "" {
+				ctx = reqctx.WithTenant(ctx, c.Tenant)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// bearer returns token of Authorization header, scheme is case-insensitive.
+func bearer(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(h[7:])
+	return token, token != ""
+}
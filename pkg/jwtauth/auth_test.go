@@ -0,0 +1,308 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/cache"
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/egress"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+type memoryRevocations struct {
+	ids map[string]bool
+	err error
+}
+
+func (m *memoryRevocations) Revoke(ctx context.Context, id string, expires time.Time) error {
+	m.ids[id] = true
+	return m.err
+}
+
+func (m *memoryRevocations) IsRevoked(ctx context.Context, id string) (bool, error) {
+	return m.ids[id], m.err
+}
+
+// newIdP starts introspection endpoint which knows tokens by value.
+func newIdP(t *testing.T, tokens map[string]string) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if id, secret, _ := r.BasicAuth(); id != "api" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		resp, ok := tokens[r.PostFormValue("token")]
+		if !ok {
+			resp = `{"active":false}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(resp))
+	}))
+	return srv, &calls
+}
+
+func newTestIntrospector(t *testing.T, url string) *Introspector {
+	p, err := egress.New(egress.Config{AllowNetworks: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewIntrospector(IntrospectorConfig{
+		URL:          url,
+		ClientID:     "api",
+		ClientSecret: "s3cret",
+		Timeout:      time.Second,
+		CacheSize:    10,
+		CacheTTL:     time.Minute,
+		Roles:        map[string][]string{"idp-editor": {"editor"}},
+		Egress:       p,
+	})
+}
+
+func TestIntrospector_Introspect(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	srv, calls := newIdP(t, map[string]string{
+		"user":    `{"active":true,"sub":"u1","jti":"t1","exp":` + jsonInt(now.Add(time.Hour).Unix()) + `,"roles":["idp-editor","admin"]}`,
+		"client":  `{"active":true,"client_id":"c1","exp":` + jsonInt(now.Add(time.Hour).Unix()) + `}`,
+		"expired": `{"active":true,"sub":"u1","exp":` + jsonInt(now.Add(-time.Hour).Unix()) + `}`,
+		"short":   `{"active":true,"sub":"u1","exp":` + jsonInt(now.Add(time.Second).Unix()) + `}`,
+		"forever": `{"active":true,"sub":"u1"}`,
+	})
+	defer srv.Close()
+	i := newTestIntrospector(t, srv.URL)
+	i.clock = clock.NewFake(now)
+
+	c, err := i.Introspect(context.Background(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// roles of identity provider which are not mapped are dropped
+	if c.Subject != "u1" || c.ID != "t1" || !reflect.DeepEqual(c.Roles, []string{"editor"}) {
+		t.Errorf("unexpected claims %+v", c)
+	}
+	// callers can not change cached answer
+	c.Subject = "admin"
+	if c, _ := i.Introspect(context.Background(), "user"); c.Subject != "u1" {
+		t.Errorf("cached claims are changed: %+v", c)
+	}
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("answer is not cached, %d calls", n)
+	}
+
+	if c, err := i.Introspect(context.Background(), "client"); err != nil || c.Subject != "c1" {
+		t.Errorf("client token: unexpected claims %+v, error %v", c, err)
+	}
+	if _, err := i.Introspect(context.Background(), "unknown"); err != ErrInvalid {
+		t.Errorf("inactive token: expected %v, got %v", ErrInvalid, err)
+	}
+	if _, err := i.Introspect(context.Background(), "expired"); err != ErrExpired {
+		t.Errorf("expired token: expected %v, got %v", ErrExpired, err)
+	}
+
+	// tokens expiring sooner than cache TTL are not cached
+	atomic.StoreInt32(calls, 0)
+	i.Introspect(context.Background(), "short")
+	i.Introspect(context.Background(), "short")
+	if n := atomic.LoadInt32(calls); n != 2 {
+		t.Errorf("short-lived token is cached, %d calls", n)
+	}
+
+	// tokens without expiry are accepted only when allowed and cached for cache TTL at most
+	if _, err := i.Introspect(context.Background(), "forever"); err != ErrInvalid {
+		t.Errorf("token without expiry: expected %v, got %v", ErrInvalid, err)
+	}
+	i = newTestIntrospector(t, srv.URL)
+	i.cfg.AllowNoExpiry = true
+	clk := clock.NewFake(now)
+	i.clock = clk
+	atomic.StoreInt32(calls, 0)
+	for n := 0; n < 2; n++ {
+		if _, err := i.Introspect(context.Background(), "forever"); err != nil {
+			t.Errorf("allowed token without expiry: unexpected error %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(calls); n != 1 {
+		t.Errorf("token without expiry is not cached, %d calls", n)
+	}
+	i.cfg.CacheTTL = 0
+	i.cache = cache.New("jwtauth.introspection", cache.Config{Size: 10})
+	i.Introspect(context.Background(), "forever")
+	i.Introspect(context.Background(), "forever")
+	if n := atomic.LoadInt32(calls); n != 3 {
+		t.Errorf("token without expiry is cached without cache TTL, %d calls", n-1)
+	}
+
+	// answers cached without TTL are not used past expiration
+	i.Introspect(context.Background(), "user")
+	clk.Add(2 * time.Hour)
+	if _, err := i.Introspect(context.Background(), "user"); err != ErrExpired {
+		t.Errorf("cached expired token: expected %v, got %v", ErrExpired, err)
+	}
+
+	bad := newTestIntrospector(t, srv.URL)
+	bad.cfg.ClientSecret = "wrong"
+	if _, err := bad.Introspect(context.Background(), "user"); err == nil || Rejected(err) {
+		t.Errorf("failed introspection: unexpected error %v", err)
+	}
+}
+
+func TestParseRoles(t *testing.T) {
+	if _, err := ParseRoles([]string{"realm-admin"}); err == nil {
+		t.Error("mapping without role is accepted")
+	}
+	roles, err := ParseRoles([]string{"admin:realm-admin", "editor:realm-admin", "editor:writer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := NewIntrospector(IntrospectorConfig{Roles: roles})
+	if got := i.roles([]string{"writer", "realm-admin", "admin"}); !reflect.DeepEqual(got, []string{"admin", "editor"}) {
+		t.Errorf("unexpected roles %v", got)
+	}
+}
+
+func jsonInt(n int64) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
+
+func TestMiddleware(t *testing.T) {
+	now := time.Now()
+	srv, calls := newIdP(t, map[string]string{
+		"external": `{"active":true,"sub":"u2","tenant":"acme","exp":` + jsonInt(now.Add(time.Hour).Unix()) + `}`,
+	})
+	defer srv.Close()
+
+	verifier := NewVerifier(VerifierConfig{Secret: []byte("secret")})
+	revocations := &memoryRevocations{ids: map[string]bool{"revoked": true}}
+	sign := func(c Claims) string {
+		if c.ExpiresAt == 0 {
+			c.ExpiresAt = now.Add(time.Hour).Unix()
+		}
+		token, err := Sign(c, HS256, "", []byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	newRouter := func(introspect string) (http.Handler, *reqctx.User, *string) {
+		a, err := New(Config{
+			Verifier:     verifier,
+			Introspector: newTestIntrospector(t, srv.URL),
+			Introspect:   introspect,
+			Revocations:  revocations,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, tenant := &reqctx.User{}, new(string)
+		r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+		r.Use(Middleware(a))
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			*user, *tenant = reqctx.User{}, reqctx.GetTenant(r.Context())
+			if u := reqctx.GetUser(r.Context()); u != nil {
+				*user = *u
+			}
+		})
+		return r, user, tenant
+	}
+
+	tests := []struct {
+		introspect string
+		header     string
+		status     int
+		user       string
+	}{
+		{IntrospectUnknown, "", http.StatusOK, ""},
+		{IntrospectUnknown, "Basic dTpw", http.StatusOK, ""},
+		{IntrospectUnknown, "Bearer " + sign(Claims{ID: "t1", Subject: "u1", Roles: []string{"admin"}}), http.StatusOK, "u1"},
+		{IntrospectUnknown, "bearer " + sign(Claims{Subject: "u1"}), http.StatusOK, "u1"},
+		{IntrospectUnknown, "Bearer " + sign(Claims{ID: "revoked", Subject: "u1"}), http.StatusUnauthorized, ""},
+		{IntrospectUnknown, "Bearer " + sign(Claims{Subject: "u1", ExpiresAt: now.Add(-time.Hour).Unix()}), http.StatusUnauthorized, ""},
+		{IntrospectUnknown, "Bearer garbage", http.StatusUnauthorized, ""},
+		// opaque tokens of identity provider are not JWT at all
+		{IntrospectAlways, "Bearer external", http.StatusOK, "u2"},
+		{IntrospectAlways, "Bearer unknown", http.StatusUnauthorized, ""},
+		{IntrospectNever, "Bearer external", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		r, user, _ := newRouter(tt.introspect)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s %q: expected status %d, got %d: %s", tt.introspect, tt.header, tt.status, w.Code, w.Body)
+			continue
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %q: no WWW-Authenticate header", tt.introspect, tt.header)
+		}
+		if user.ID != tt.user {
+			t.Errorf("%s %q: expected user %q, got %q", tt.introspect, tt.header, tt.user, user.ID)
+		}
+	}
+
+	// tokens signed with unknown key are validated online
+	r, _, _ := newRouter(IntrospectUnknown)
+	token, err := Sign(Claims{Subject: "u2"}, RS256, "idp", testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(calls, 0)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || atomic.LoadInt32(calls) != 1 {
+		t.Errorf("token of unknown key: expected introspection and 401, got %d after %d calls", w.Code, atomic.LoadInt32(calls))
+	}
+	w = httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer external")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("opaque token is accepted without introspection of all tokens: %d", w.Code)
+	}
+
+	r, user, tenant := newRouter(IntrospectAlways)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if user.ID != "u2" || *tenant != "acme" {
+		t.Errorf("unexpected user %q of tenant %q", user.ID, *tenant)
+	}
+
+	// tokens are not accepted when revocation list is unavailable
+	revocations.err = errors.New("connection refused")
+	r, _, _ = newRouter(IntrospectNever)
+	w = httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer "+sign(Claims{ID: "t1", Subject: "u1"}))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unavailable revocation list: expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Error("authenticator without keys is created")
+	}
+	if _, err := New(Config{Verifier: NewVerifier(VerifierConfig{}), Introspect: IntrospectAlways}); err == nil {
+		t.Error("introspection without endpoint is accepted")
+	}
+	if _, err := New(Config{Verifier: NewVerifier(VerifierConfig{}), Introspect: "sometimes"}); err == nil {
+		t.Error("unknown introspection mode is accepted")
+	}
+}
@@ -0,0 +1,166 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/cache"
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/egress"
+)
+
+// IntrospectorConfig of OAuth2 token introspection endpoint, see RFC 7662.
+type IntrospectorConfig struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	Timeout      time.Duration
+	// CacheSize and CacheTTL bound how long answers are reused, tokens are never cached past expiration.
+	CacheSize int
+	CacheTTL  time.Duration
+	// AllowNoExpiry accepts active tokens without exp claim like VerifierConfig.AllowNoExpiry does,
+	// they are cached for CacheTTL at most and are not cached if it is zero.
+	AllowNoExpiry bool
+	// Roles map roles granted by identity provider to roles of the service, see ParseRoles.
+	// Roles which are not mapped are dropped, so tokens have no roles unless mapping is configured.
+	Roles map[string][]string
+	// Egress restricts addresses endpoint may resolve to, identity providers in private networks must be allowed.
+	Egress *egress.Policy
+}
+
+// Introspector asks identity provider whether token is active, it is safe for concurrent use.
+type Introspector struct {
+	cfg    IntrospectorConfig
+	client *http.Client
+	cache  *cache.Cache
+	clock  clock.Clock
+}
+
+func NewIntrospector(cfg IntrospectorConfig) *Introspector {
+	return &Introspector{
+		cfg:    cfg,
+		client: cfg.Egress.Client(cfg.Timeout),
+		cache:  cache.New("jwtauth.introspection", cache.Config{Size: cfg.CacheSize, TTL: cfg.CacheTTL}),
+		clock:  clock.Real,
+	}
+}
+
+type introspection struct {
+	Active bool `json:"active"`
+	Claims
+	ClientID string `json:"client_id"`
+}
+
+// Introspect returns claims of active token, ErrInvalid if it is not active.
+func (i *Introspector) Introspect(ctx context.Context, token string) (*Claims, error) {
+	// tokens are bearer credentials, so they are not kept in memory as is
+	key := sha256.Sum256([]byte(token))
+	if v, ok := i.cache.Get(key); ok {
+		c, err := cached(v)
+		if err == nil && c.ExpiresAt != 0 && i.clock.Now().Unix() >= c.ExpiresAt {
+			// cache without TTL keeps answers until they are evicted
+			return nil, ErrExpired
+		}
+		return c, err
+	}
+
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest(http.MethodPost, i.cfg.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not build introspection request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.cfg.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(i.cfg.ClientID), url.QueryEscape(i.cfg.ClientSecret))
+	}
+	resp, err := i.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not introspect token")
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("introspection endpoint responded with %s", resp.Status)
+	}
+	var res introspection
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&res); err != nil {
+		return nil, errors.Wrap(err, "could not decode introspection response")
+	}
+
+	if !res.Active {
+		i.cache.Set(key, ErrInvalid)
+		return nil, ErrInvalid
+	}
+	c := res.Claims
+	if c.Subject == "" {
+		// tokens of client credentials grant have no user
+		c.Subject = res.ClientID
+	}
+	if c.Subject == "" {
+		return nil, ErrInvalid
+	}
+	if c.ExpiresAt == 0 && !i.cfg.AllowNoExpiry {
+		i.cache.Set(key, ErrInvalid)
+		return nil, ErrInvalid
+	}
+	if c.ExpiresAt != 0 && i.clock.Now().Unix() >= c.ExpiresAt {
+		return nil, ErrExpired
+	}
+	c.Roles = i.roles(c.Roles)
+	if c.ExpiresAt == 0 && i.cfg.CacheTTL > 0 || c.ExpiresAt != 0 && c.Expires().Sub(i.clock.Now()) >= i.cfg.CacheTTL {
+		i.cache.Set(key, &c)
+	}
+	return cached(&c)
+}
+
+// ParseRoles parses mappings in form role:provider role, e.g. admin:realm-admin, into roles of the
+// service by roles of identity provider. A provider role may map to several roles.
+func ParseRoles(mappings []string) (map[string][]string, error) {
+	roles := make(map[string][]string, len(mappings))
+	for _, m := range mappings {
+		parts := strings.SplitN(m, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("invalid role %q, expected role:provider role", m)
+		}
+		roles[parts[1]] = append(roles[parts[1]], parts[0])
+	}
+	return roles, nil
+}
+
+// roles returns sorted roles of the service mapped from roles granted by identity provider.
+func (i *Introspector) roles(granted []string) []string {
+	seen := make(map[string]bool)
+	var roles []string
+	for _, g := range granted {
+		for _, role := range i.cfg.Roles[g] {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// cached returns copy of cached answer, so callers can not change it.
+func cached(v interface{}) (*Claims, error) {
+	if err, ok := v.(error); ok {
+		return nil, err
+	}
+	c := *v.(*Claims)
+	return &c, nil
+}
@@ -0,0 +1,69 @@
+package jwtauth
+
+import (
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/ids"
+)
+
+// IssuerService is name issuer of access tokens is provided to modules by, see module.Env.
+const IssuerService = "jwtauth.issuer"
+
+// IssuerConfig of access tokens the service issues itself.
+type IssuerConfig struct {
+	// Alg, Kid and Key sign tokens, see Sign.
+	Alg string
+	Kid string
+	Key interface{}
+	// Keys sign tokens with RS256 instead of Key when set, the current key of set signs.
+	Keys *KeySet
+	// Issuer and Audience are set as iss and aud claims unless empty.
+	Issuer   string
+	Audience string
+	TTL      time.Duration
+}
+
+// Issuer signs access tokens with unique IDs, so they can be revoked.
+type Issuer struct {
+	cfg   IssuerConfig
+	ids   ids.Generator
+	clock clock.Clock
+}
+
+func NewIssuer(cfg IssuerConfig) *Issuer {
+	return &Issuer{cfg: cfg, ids: ids.Random, clock: clock.Real}
+}
+
+// TTL is how long issued tokens are valid.
+func (i *Issuer) TTL() time.Duration {
+	return i.cfg.TTL
+}
+
+// Issue returns token of claims, ID, issuer, audience and times of claims are set by issuer.
+func (i *Issuer) Issue(c Claims) (string, *Claims, error) {
+	id, err := i.ids.NewID()
+	if err != nil {
+		return "", nil, err
+	}
+	now := i.clock.Now()
+	c.ID = id
+	c.Issuer = i.cfg.Issuer
+	c.Audience = nil
+	if i.cfg.Audience != "" {
+		c.Audience = Audience{i.cfg.Audience}
+	}
+	c.IssuedAt = now.Unix()
+	c.NotBefore = 0
+	c.ExpiresAt = now.Add(i.cfg.TTL).Unix()
+	alg, kid, key := i.cfg.Alg, i.cfg.Kid, i.cfg.Key
+	if i.cfg.Keys != nil {
+		k := i.cfg.Keys.Current()
+		alg, kid, key = RS256, k.ID, k.Key
+	}
+	token, err := Sign(c, alg, kid, key)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &c, nil
+}
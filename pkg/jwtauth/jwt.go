@@ -0,0 +1,271 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+)
+
+// Algorithms of signatures.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+)
+
+var (
+	ErrInvalid = errors.New("token is not valid")
+	ErrExpired = errors.New("token is expired")
+	// ErrUnknownKey means token may be valid, but it is signed with a key verifier does not have,
+	// e.g. it is issued by external identity provider.
+	ErrUnknownKey = errors.New("token is signed with unknown key")
+)
+
+// Claims of tokens, unknown claims are ignored.
+type Claims struct {
+	ID        string   `json:"jti,omitempty"`
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	Tenant    string   `json:"tenant,omitempty"`
+}
+
+// Expires returns expiration time, zero if token does not expire.
+func (c *Claims) Expires() time.Time {
+	if c.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// Audience is a list of recipients, a single one is encoded as a string.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a Audience) contains(s string) bool {
+	for _, aud := range a {
+		if aud == s {
+			return true
+		}
+	}
+	return false
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// Sign encodes claims as token, key is []byte for HS256 and *rsa.PrivateKey for RS256.
+func Sign(c Claims, alg, kid string, key interface{}) (string, error) {
+	h, err := json.Marshal(header{Alg: alg, Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := encode(h) + "." + encode(p)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		if alg != HS256 {
+			return "", errors.Errorf("%s key can not sign %s token", HS256, alg)
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if alg != RS256 {
+			return "", errors.Errorf("%s key can not sign %s token", RS256, alg)
+		}
+		sum := sha256.Sum256([]byte(signed))
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:]); err != nil {
+			return "", errors.Wrap(err, "could not sign token")
+		}
+	default:
+		return "", errors.Errorf("unsupported key %T", key)
+	}
+	return signed + "." + encode(sig), nil
+}
+
+func encode(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// VerifierConfig of tokens verified locally.
+type VerifierConfig struct {
+	// Secret verifies HS256 tokens, they are not accepted if it is empty.
+	Secret []byte
+	// PublicKeys verify RS256 tokens by key ID, a key with empty ID verifies tokens without one.
+	PublicKeys map[string]*rsa.PublicKey
+	// Keys verify RS256 tokens the service signs itself, see KeySet.
+	Keys *KeySet
+	// Issuer and Audience are checked unless empty.
+	Issuer   string
+	Audience string
+	// Leeway tolerates clock skew of issuer.
+	Leeway time.Duration
+	// AllowNoExpiry accepts tokens without exp claim, which are valid forever, e.g. of legacy issuers.
+	AllowNoExpiry bool
+}
+
+// Verifier checks signature and claims of tokens.
+type Verifier struct {
+	cfg   VerifierConfig
+	clock clock.Clock
+}
+
+func NewVerifier(cfg VerifierConfig) *Verifier {
+	return &Verifier{cfg: cfg, clock: clock.Real}
+}
+
+// Verify returns claims of valid token.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalid
+	}
+	var h header
+	if err := decode(parts[0], &h); err != nil {
+		return nil, ErrInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	signed := parts[0] + "." + parts[1]
+	switch h.Alg {
+	case HS256:
+		if len(v.cfg.Secret) == 0 {
+			return nil, ErrUnknownKey
+		}
+		mac := hmac.New(sha256.New, v.cfg.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, ErrInvalid
+		}
+	case RS256:
+		key, ok := v.cfg.PublicKeys[h.Kid]
+		if !ok && v.cfg.Keys != nil {
+			key, ok = v.cfg.Keys.PublicKey(h.Kid)
+		}
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		sum := sha256.Sum256([]byte(signed))
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) != nil {
+			return nil, ErrInvalid
+		}
+	default:
+		// none and algorithms of other key types are never accepted
+		return nil, ErrUnknownKey
+	}
+
+	var c Claims
+	if err := decode(parts[1], &c); err != nil {
+		return nil, ErrInvalid
+	}
+	if err := v.check(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// check validates time, issuer and audience of claims, tokens must expire unless config allows otherwise.
+func (v *Verifier) check(c *Claims) error {
+	now := v.clock.Now().Unix()
+	leeway := int64(v.cfg.Leeway / time.Second)
+	if c.ExpiresAt == 0 && !v.cfg.AllowNoExpiry {
+		return ErrInvalid
+	}
+	if c.ExpiresAt != 0 && now > c.ExpiresAt+leeway {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now < c.NotBefore-leeway {
+		return ErrInvalid
+	}
+	if v.cfg.Issuer != "" && c.Issuer != v.cfg.Issuer {
+		return ErrInvalid
+	}
+	if v.cfg.Audience != "" && !c.Audience.contains(v.cfg.Audience) {
+		return ErrInvalid
+	}
+	if c.Subject == "" {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func decode(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// ParsePublicKey parses PEM encoded RSA public key or certificate.
+func ParsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block in public key")
+	}
+	var key interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not parse certificate")
+		}
+		key = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		k, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not parse public key")
+		}
+		key = k
+	default:
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not parse public key")
+		}
+		key = k
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.Errorf("%T is not an RSA public key", key)
+	}
+	return rsaKey, nil
+}
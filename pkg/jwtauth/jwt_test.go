@@ -0,0 +1,211 @@
+package jwtauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+)
+
+var testKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+
+func TestVerifier_Verify(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	v := NewVerifier(VerifierConfig{
+		Secret:     []byte("secret"),
+		PublicKeys: map[string]*rsa.PublicKey{"k1": &testKey.PublicKey},
+		Issuer:     "goapi",
+		Audience:   "api",
+		Leeway:     time.Minute,
+	})
+	v.clock = clock.NewFake(now)
+
+	valid := Claims{
+		ID:        "t1",
+		Subject:   "u1",
+		Issuer:    "goapi",
+		Audience:  Audience{"api", "web"},
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		Roles:     []string{"admin"},
+	}
+	with := func(f func(c *Claims)) Claims {
+		c := valid
+		f(&c)
+		return c
+	}
+	sign := func(c Claims, alg, kid string, key interface{}) string {
+		token, err := Sign(c, alg, kid, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"hs256", sign(valid, HS256, "", []byte("secret")), nil},
+		{"rs256", sign(valid, RS256, "k1", testKey), nil},
+		{"within leeway", sign(with(func(c *Claims) { c.ExpiresAt = now.Add(-30 * time.Second).Unix() }), HS256, "", []byte("secret")), nil},
+		{"wrong secret", sign(valid, HS256, "", []byte("other")), ErrInvalid},
+		{"wrong key", sign(valid, RS256, "k1", otherKey), ErrInvalid},
+		{"unknown kid", sign(valid, RS256, "k2", testKey), ErrUnknownKey},
+		{"expired", sign(with(func(c *Claims) { c.ExpiresAt = now.Add(-time.Hour).Unix() }), HS256, "", []byte("secret")), ErrExpired},
+		{"not yet valid", sign(with(func(c *Claims) { c.NotBefore = now.Add(time.Hour).Unix() }), HS256, "", []byte("secret")), ErrInvalid},
+		{"other issuer", sign(with(func(c *Claims) { c.Issuer = "idp" }), HS256, "", []byte("secret")), ErrInvalid},
+		{"other audience", sign(with(func(c *Claims) { c.Audience = Audience{"web"} }), HS256, "", []byte("secret")), ErrInvalid},
+		{"no subject", sign(with(func(c *Claims) { c.Subject = "" }), HS256, "", []byte("secret")), ErrInvalid},
+		{"no expiry", sign(with(func(c *Claims) { c.ExpiresAt = 0 }), HS256, "", []byte("secret")), ErrInvalid},
+		{"none", encode([]byte(`{"alg":"none"}`)) + "." + encode([]byte(`{"sub":"u1"}`)) + ".", ErrUnknownKey},
+		{"malformed", "a.b", ErrInvalid},
+	}
+	for _, tt := range tests {
+		c, err := v.Verify(tt.token)
+		if err != tt.err {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.err, err)
+			continue
+		}
+		if err == nil && (c.Subject != "u1" || c.ID != "t1" || len(c.Roles) != 1) {
+			t.Errorf("%s: unexpected claims %+v", tt.name, c)
+		}
+	}
+
+	// signature of header and payload is checked, not of re-encoded claims
+	token := sign(valid, HS256, "", []byte("secret"))
+	parts := strings.Split(token, ".")
+	parts[1] = encode([]byte(`{"sub":"admin","iss":"goapi","aud":"api"}`))
+	if _, err := v.Verify(strings.Join(parts, ".")); err != ErrInvalid {
+		t.Errorf("tampered token: expected %v, got %v", ErrInvalid, err)
+	}
+
+	// tokens without expiry are accepted only when allowed
+	v.cfg.AllowNoExpiry = true
+	if _, err := v.Verify(sign(with(func(c *Claims) { c.ExpiresAt = 0 }), HS256, "", []byte("secret"))); err != nil {
+		t.Errorf("token without expiry: unexpected error %v", err)
+	}
+}
+
+func TestAudience_UnmarshalJSON(t *testing.T) {
+	var c Claims
+	if err := decode(encode([]byte(`{"aud":"api"}`)), &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Audience) != 1 || c.Audience[0] != "api" {
+		t.Errorf("unexpected audience %v", c.Audience)
+	}
+	if err := decode(encode([]byte(`{"aud":["api","web"]}`)), &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Audience) != 2 || !c.Audience.contains("web") {
+		t.Errorf("unexpected audience %v", c.Audience)
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	der, err := x509.MarshalPKIXPublicKey(&testKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.N.Cmp(testKey.N) != 0 {
+		t.Error("parsed key differs")
+	}
+
+	if _, err := ParsePublicKey([]byte("not a key")); err == nil {
+		t.Error("invalid key is parsed")
+	}
+}
+
+func TestKeySet(t *testing.T) {
+	newKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	old, err := NewKeySet(SigningKey{ID: "k1", Key: testKey}, SigningKey{ID: "k2", Key: newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// k2 signs after rotation, tokens signed with k1 are still valid
+	rotated, err := NewKeySet(SigningKey{ID: "k2", Key: newKey}, SigningKey{ID: "k1", Key: testKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, _, err := NewIssuer(IssuerConfig{Keys: old, TTL: time.Minute}).Issue(Claims{Subject: "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, _, err := NewIssuer(IssuerConfig{Keys: rotated, TTL: time.Minute}).Issue(Claims{Subject: "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h header
+	if err := decode(strings.Split(newToken, ".")[0], &h); err != nil || h.Alg != RS256 || h.Kid != "k2" {
+		t.Errorf("unexpected header %+v %v", h, err)
+	}
+	v := NewVerifier(VerifierConfig{Keys: rotated})
+	for _, token := range []string{oldToken, newToken} {
+		if c, err := v.Verify(token); err != nil || c.Subject != "svc" {
+			t.Errorf("unexpected claims %+v %v", c, err)
+		}
+	}
+
+	// published keys verify tokens elsewhere
+	w := httptest.NewRecorder()
+	JWKSHandler(rotated).ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	var jwks struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Kid != "k2" || jwks.Keys[1].Kid != "k1" || jwks.Keys[0].Alg != RS256 {
+		t.Fatalf("unexpected keys %+v", jwks.Keys)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[1].N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[1].E)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	if c, err := NewVerifier(VerifierConfig{PublicKeys: map[string]*rsa.PublicKey{"k1": pub}}).Verify(oldToken); err != nil || c.Subject != "svc" {
+		t.Errorf("token is not verified with published key: %v", err)
+	}
+
+	for _, keys := range [][]SigningKey{nil, {{Key: testKey}}, {{ID: "k1", Key: testKey}, {ID: "k1", Key: newKey}}} {
+		if _, err := NewKeySet(keys...); err == nil {
+			t.Errorf("invalid keys %+v are accepted", keys)
+		}
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range []*pem.Block{
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(testKey)},
+		{Type: "PRIVATE KEY", Bytes: pkcs8},
+	} {
+		key, err := ParsePrivateKey(pem.EncodeToMemory(block))
+		if err != nil {
+			t.Errorf("%s: %v", block.Type, err)
+			continue
+		}
+		if key.N.Cmp(testKey.N) != 0 {
+			t.Errorf("%s: parsed key differs", block.Type)
+		}
+	}
+	if _, err := ParsePrivateKey([]byte("not a key")); err == nil {
+		t.Error("invalid key is parsed")
+	}
+}
@@ -0,0 +1,120 @@
+package jwtauth
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// KeySetService is name signing keys of the service are provided to modules by, see module.Env.
+const KeySetService = "jwtauth.keys"
+
+// SigningKey is an RSA key tokens are signed with, ID is kid of their header.
+type SigningKey struct {
+	ID  string
+	Key *rsa.PrivateKey
+}
+
+// KeySet is an ordered list of signing keys, the first one signs and all of them verify and are published
+// as JWKS. Keys are rotated by adding a new key after the current one, so it is published before it signs,
+// then moving it first, and removing the old key once tokens signed with it expire.
+type KeySet struct {
+	keys []SigningKey
+}
+
+func NewKeySet(keys ...SigningKey) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("key set requires at least one key")
+	}
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, errors.New("signing keys require IDs, verifiers pick keys by kid")
+		}
+		if seen[k.ID] {
+			return nil, errors.Errorf("signing key %s is given twice", k.ID)
+		}
+		seen[k.ID] = true
+	}
+	return &KeySet{keys: keys}, nil
+}
+
+// Current returns key new tokens are signed with.
+func (s *KeySet) Current() SigningKey {
+	return s.keys[0]
+}
+
+// PublicKey returns public part of key by ID.
+func (s *KeySet) PublicKey(kid string) (*rsa.PublicKey, bool) {
+	for _, k := range s.keys {
+		if k.ID == kid {
+			return &k.Key.PublicKey, true
+		}
+	}
+	return nil, false
+}
+
+// JWK is an RSA public key in JSON Web Key format, see RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS returns public keys of set.
+func (s *KeySet) JWKS() []JWK {
+	keys := make([]JWK, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: RS256,
+			Kid: k.ID,
+			N:   encode(k.Key.N.Bytes()),
+			E:   encode(big.NewInt(int64(k.Key.E)).Bytes()),
+		})
+	}
+	return keys
+}
+
+// JWKSHandler serves public keys of set as {"keys": [...]}, other services verify tokens with them.
+// Responses may be cached for a few minutes, rotation publishes keys before they sign.
+func JWKSHandler(s *KeySet) http.Handler {
+	body, _ := json.Marshal(struct {
+		Keys []JWK `json:"keys"`
+	}{s.JWKS()})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(body)
+	})
+}
+
+// ParsePrivateKey parses PEM encoded RSA private key in PKCS #1 or PKCS #8 form.
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block in private key")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		return k, errors.Wrap(err, "could not parse private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse private key")
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf("%T is not an RSA private key", key)
+	}
+	return rsaKey, nil
+}
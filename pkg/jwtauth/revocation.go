@@ -0,0 +1,62 @@
+package jwtauth
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/redis"
+)
+
+// ErrRevoked means token is valid, but its ID is in revocation list.
+var ErrRevoked = errors.New("token is revoked")
+
+// Revocations is a list of IDs of revoked tokens, IDs are kept until tokens expire.
+// Tokens without ID can not be revoked.
+type Revocations interface {
+	// Revoke adds ID to the list, zero expires keeps it forever.
+	Revoke(ctx context.Context, id string, expires time.Time) error
+	IsRevoked(ctx context.Context, id string) (bool, error)
+}
+
+// RevocationsService is name modules provide revocation list by, see module.Env.
+const RevocationsService = "jwtauth.revocations"
+
+// RedisRevocationPrefix is prefix of Redis keys of revoked IDs.
+const RedisRevocationPrefix = "goapi.jwtauth.revoked:"
+
+// RedisRevocations keeps revoked IDs in Redis, keys expire with tokens.
+type RedisRevocations struct {
+	c     *redis.Client
+	clock clock.Clock
+}
+
+func NewRedisRevocations(c *redis.Client) *RedisRevocations {
+	return &RedisRevocations{c: c, clock: clock.Real}
+}
+
+func (s *RedisRevocations) Revoke(ctx context.Context, id string, expires time.Time) error {
+	args := []interface{}{"SET", RedisRevocationPrefix + id, "1"}
+	if !expires.IsZero() {
+		ttl := expires.Sub(s.clock.Now())
+		if ttl <= 0 {
+			// expired tokens are rejected anyway
+			return nil
+		}
+		args = append(args, "PX", int64(ttl/time.Millisecond)+1)
+	}
+	if _, err := s.c.Do(ctx, args...); err != nil {
+		return errors.Wrap(err, "could not revoke token")
+	}
+	return nil
+}
+
+func (s *RedisRevocations) IsRevoked(ctx context.Context, id string) (bool, error) {
+	n, err := redis.Int(s.c.Do(ctx, "EXISTS", RedisRevocationPrefix+id))
+	if err != nil {
+		return false, errors.Wrap(err, "could not check token revocation")
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,166 @@
+package ldap
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Tags of BER elements LDAP uses, see RFC 4511 section 5.1.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// maxPacket bounds messages of server, so a broken server does not exhaust memory.
+const maxPacket = 16 << 20
+
+// element is a decoded BER element, children of constructed elements are decoded on demand.
+type element struct {
+	tag  byte
+	data []byte
+}
+
+func encode(tag byte, data []byte) []byte {
+	n := len(data)
+	var b []byte
+	switch {
+	case n < 0x80:
+		b = []byte{tag, byte(n)}
+	case n < 0x100:
+		b = []byte{tag, 0x81, byte(n)}
+	case n < 0x10000:
+		b = []byte{tag, 0x82, byte(n >> 8), byte(n)}
+	default:
+		b = []byte{tag, 0x84, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	return append(b, data...)
+}
+
+func encodeSeq(tag byte, children ...[]byte) []byte {
+	var data []byte
+	for _, c := range children {
+		data = append(data, c...)
+	}
+	return encode(tag, data)
+}
+
+func encodeString(tag byte, s string) []byte { return encode(tag, []byte(s)) }
+
+func encodeInt(tag byte, v int64) []byte {
+	// two's complement in the fewest bytes
+	n := 1
+	for i := v; i > 127 || i < -128; i >>= 8 {
+		n++
+	}
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return encode(tag, b)
+}
+
+func encodeBool(v bool) []byte {
+	if v {
+		return encode(tagBoolean, []byte{0xff})
+	}
+	return encode(tagBoolean, []byte{0})
+}
+
+// readPacket reads one BER element of r as it is, e.g. an LDAP message.
+func readPacket(r *bufio.Reader) ([]byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	header := []byte{tag, first}
+	n := int(first)
+	if first&0x80 != 0 {
+		size := int(first & 0x7f)
+		if size == 0 || size > 4 {
+			return nil, errors.New("ldap: unsupported length of message")
+		}
+		n = 0
+		for i := 0; i < size; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			header = append(header, b)
+			n = n<<8 | int(b)
+		}
+	}
+	if n > maxPacket {
+		return nil, errors.Errorf("ldap: message of %d bytes is too large", n)
+	}
+	packet := make([]byte, len(header)+n)
+	copy(packet, header)
+	if _, err := io.ReadFull(r, packet[len(header):]); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// decode returns the first element of b and the rest of b.
+func decode(b []byte) (element, []byte, error) {
+	if len(b) < 2 {
+		return element{}, nil, errors.New("ldap: truncated element")
+	}
+	tag, n, off := b[0], int(b[1]), 2
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < 2+size {
+			return element{}, nil, errors.New("ldap: invalid length of element")
+		}
+		n = 0
+		for _, c := range b[2 : 2+size] {
+			n = n<<8 | int(c)
+		}
+		off += size
+	}
+	if n < 0 || len(b)-off < n {
+		return element{}, nil, errors.New("ldap: truncated element")
+	}
+	return element{tag: tag, data: b[off : off+n]}, b[off+n:], nil
+}
+
+// children decodes elements of constructed element.
+func (e element) children() ([]element, error) {
+	var elems []element
+	for rest := e.data; len(rest) > 0; {
+		var c element
+		var err error
+		if c, rest, err = decode(rest); err != nil {
+			return nil, err
+		}
+		elems = append(elems, c)
+	}
+	return elems, nil
+}
+
+func (e element) int() int64 {
+	var v int64
+	for i, b := range e.data {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+func (e element) string() string { return string(e.data) }
@@ -0,0 +1,171 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Tags of filter choices, see RFC 4511 section 4.5.1.7.
+const (
+	filterAnd            = classContext | constructed | 0
+	filterOr             = classContext | constructed | 1
+	filterNot            = classContext | constructed | 2
+	filterEquality       = classContext | constructed | 3
+	filterSubstrings     = classContext | constructed | 4
+	filterGreaterOrEqual = classContext | constructed | 5
+	filterLessOrEqual    = classContext | constructed | 6
+	filterPresent        = classContext | 7
+	filterApprox         = classContext | constructed | 8
+)
+
+// EscapeFilter escapes special characters of value, so it is matched literally in filter.
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case '*', '(', ')', '\\', 0:
+			b.WriteString(`\` + hex.EncodeToString([]byte{c}))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter encodes filter in string representation of RFC 4515, e.g. (&(objectClass=person)(uid=j*)).
+// Extensible matches are not supported.
+func compileFilter(s string) ([]byte, error) {
+	f, rest, err := parseFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, errors.Errorf("ldap: unexpected %q after filter %q", rest, s)
+	}
+	return f, nil
+}
+
+func parseFilter(s string) ([]byte, string, error) {
+	if len(s) < 3 || s[0] != '(' {
+		return nil, "", errors.Errorf("ldap: invalid filter %q", s)
+	}
+	switch s[1] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if s[1] == '|' {
+			tag = filterOr
+		}
+		var children [][]byte
+		rest := s[2:]
+		for len(rest) > 0 && rest[0] == '(' {
+			var f []byte
+			var err error
+			if f, rest, err = parseFilter(rest); err != nil {
+				return nil, "", err
+			}
+			children = append(children, f)
+		}
+		if len(children) == 0 || len(rest) == 0 || rest[0] != ')' {
+			return nil, "", errors.Errorf("ldap: invalid filter %q", s)
+		}
+		return encodeSeq(tag, children...), rest[1:], nil
+	case '!':
+		f, rest, err := parseFilter(s[2:])
+		if err != nil {
+			return nil, "", err
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", errors.Errorf("ldap: invalid filter %q", s)
+		}
+		return encode(filterNot, f), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", errors.Errorf("ldap: unterminated filter %q", s)
+	}
+	f, err := parseItem(s[1:end])
+	if err != nil {
+		return nil, "", err
+	}
+	return f, s[end+1:], nil
+}
+
+// parseItem encodes simple item of filter, e.g. uid=john.
+func parseItem(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, errors.Errorf("ldap: invalid filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+	tag := byte(filterEquality)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = filterGreaterOrEqual, attr[:len(attr)-1]
+	case '<':
+		tag, attr = filterLessOrEqual, attr[:len(attr)-1]
+	case '~':
+		tag, attr = filterApprox, attr[:len(attr)-1]
+	}
+	if attr == "" {
+		return nil, errors.Errorf("ldap: invalid filter item %q", item)
+	}
+	if tag != filterEquality || !strings.Contains(value, "*") {
+		v, err := unescapeFilter(value)
+		if err != nil {
+			return nil, err
+		}
+		return encodeSeq(tag, encodeString(tagOctetString, attr), encodeString(tagOctetString, v)), nil
+	}
+	if value == "*" {
+		return encodeString(filterPresent, attr), nil
+	}
+
+	parts := strings.Split(value, "*")
+	var subs [][]byte
+	for i, p := range parts {
+		if p == "" {
+			continue
+		}
+		v, err := unescapeFilter(p)
+		if err != nil {
+			return nil, err
+		}
+		// initial, any and final substrings are tagged 0, 1 and 2
+		kind := byte(1)
+		if i == 0 {
+			kind = 0
+		} else if i == len(parts)-1 {
+			kind = 2
+		}
+		subs = append(subs, encodeString(classContext|kind, v))
+	}
+	return encodeSeq(filterSubstrings, encodeString(tagOctetString, attr), encodeSeq(tagSequence, subs...)), nil
+}
+
+// unescapeFilter decodes escapes of value in form \XX.
+func unescapeFilter(value string) (string, error) {
+	if !strings.Contains(value, `\`) {
+		return value, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", errors.Errorf("ldap: invalid escape in filter value %q", value)
+		}
+		c, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", errors.Errorf("ldap: invalid escape in filter value %q", value)
+		}
+		b.Write(c)
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,425 @@
+// Package ldap is a small LDAPv3 client for authentication against directories of on-premises
+// customers. It covers what the service needs: simple bind and search over a pool of connections,
+// secured with LDAPS or StartTLS.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"expvar"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// metrics are exposed with expvar as ldap.{operations,errors,dials}.
+var metrics = expvar.NewMap("ldap")
+
+// Result codes of operations, see RFC 4511 appendix A.
+const (
+	ResultSuccess            = 0
+	ResultSizeLimitExceeded  = 4
+	ResultNoSuchObject       = 32
+	ResultInvalidCredentials = 49
+)
+
+// ErrEmptyPassword is returned by Bind when password is empty, servers treat such bind as anonymous and
+// accept it whatever name is, see RFC 4513 section 5.1.2.
+var ErrEmptyPassword = errors.New("ldap: empty password")
+
+// Error is a result of operation other than success.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// IsCode reports whether err is a result of operation with code.
+func IsCode(err error, code int) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.Code == code
+}
+
+// Tags of protocol operations, see RFC 4511 section 4.
+const (
+	opBindRequest      = classApplication | constructed | 0
+	opBindResponse     = classApplication | constructed | 1
+	opUnbindRequest    = classApplication | 2
+	opSearchRequest    = classApplication | constructed | 3
+	opSearchEntry      = classApplication | constructed | 4
+	opSearchDone       = classApplication | constructed | 5
+	opSearchReference  = classApplication | constructed | 19
+	opExtendedRequest  = classApplication | constructed | 23
+	opExtendedResponse = classApplication | constructed | 24
+)
+
+// startTLSOID is name of extended operation which starts TLS, see RFC 4511 section 4.14.
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+type Config struct {
+	// PoolSize is the maximum number of idle connections.
+	PoolSize int
+	// Timeout bounds dial and every operation unless context has an earlier deadline.
+	Timeout time.Duration
+	// StartTLS upgrades ldap:// connections to TLS before any other operation.
+	StartTLS bool
+	// TLS configures LDAPS and StartTLS, server name defaults to host of URL.
+	TLS *tls.Config
+}
+
+// Scopes of search.
+const (
+	ScopeBase = 0
+	ScopeOne  = 1
+	ScopeSub  = 2
+)
+
+type SearchRequest struct {
+	BaseDN string
+	Scope  int
+	// Filter is in string representation of RFC 4515, e.g. (&(objectClass=person)(uid=john)).
+	Filter     string
+	Attributes []string
+	// SizeLimit is the maximum number of entries, zero means no limit but one of server.
+	SizeLimit int
+}
+
+type Entry struct {
+	DN string
+	// Attributes are keyed by names in lowercase, as names are case-insensitive.
+	Attributes map[string][]string
+}
+
+// Values returns values of attribute by name in any case.
+func (e *Entry) Values(name string) []string {
+	return e.Attributes[strings.ToLower(name)]
+}
+
+// Value returns the first value of attribute or empty string.
+func (e *Entry) Value(name string) string {
+	if v := e.Values(name); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Client is safe for concurrent use.
+type Client struct {
+	addr string
+	tls  bool
+	cfg  Config
+
+	idle chan *Conn
+}
+
+// New returns client of server at URL in form ldap://host[:port] or ldaps://host[:port].
+// Connections are dialed on demand.
+func New(rawurl string, cfg Config) (*Client, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Hostname() == "" {
+		return nil, errors.Errorf("invalid ldap url %q, expected ldap://host[:port] or ldaps://host[:port]", rawurl)
+	}
+	c := &Client{addr: u.Host, tls: u.Scheme == "ldaps", cfg: cfg}
+	if u.Port() == "" {
+		port := "389"
+		if c.tls {
+			port = "636"
+		}
+		c.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	if c.tls && c.cfg.StartTLS {
+		return nil, errors.Errorf("invalid ldap url %q, StartTLS is used with ldap:// only", rawurl)
+	}
+	if c.cfg.TLS == nil {
+		c.cfg.TLS = &tls.Config{}
+	}
+	if c.cfg.TLS.ServerName == "" {
+		c.cfg.TLS = c.cfg.TLS.Clone()
+		c.cfg.TLS.ServerName = u.Hostname()
+	}
+	if c.cfg.PoolSize <= 0 {
+		c.cfg.PoolSize = 5
+	}
+	if c.cfg.Timeout <= 0 {
+		c.cfg.Timeout = 5 * time.Second
+	}
+	c.idle = make(chan *Conn, c.cfg.PoolSize)
+	return c, nil
+}
+
+// Do calls fn with a connection of pool. Connection is bound as the last Bind of any caller, so fn
+// should bind before other operations. Connection is closed instead of returned to pool when fn fails
+// with other error than a result of operation, as it is in unknown state.
+func (c *Client) Do(ctx context.Context, fn func(*Conn) error) error {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+	cn.ctx = ctx
+	err = fn(cn)
+	if _, ok := errors.Cause(err).(*Error); err != nil && !ok && errors.Cause(err) != ErrEmptyPassword {
+		cn.Close()
+		return err
+	}
+	c.put(cn)
+	return err
+}
+
+// Close closes idle connections, connections in use are closed when they are returned.
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) get(ctx context.Context) (*Conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+	return c.dial(ctx)
+}
+
+func (c *Client) put(cn *Conn) {
+	cn.ctx = nil
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+// dial connects and sets up TLS.
+func (c *Client) dial(ctx context.Context) (*Conn, error) {
+	metrics.Add("dials", 1)
+	deadline := c.deadline(ctx)
+	d := net.Dialer{Deadline: deadline}
+	nc, err := d.Dial("tcp", c.addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not connect to ldap")
+	}
+	if c.tls {
+		if nc, err = c.handshake(nc, deadline); err != nil {
+			return nil, err
+		}
+	}
+	cn := newConn(nc, c)
+	if c.cfg.StartTLS {
+		cn.ctx = ctx
+		if _, err := cn.do(opExtendedResponse, encodeSeq(opExtendedRequest, encodeString(classContext|0, startTLSOID))); err != nil {
+			cn.Close()
+			return nil, errors.Wrap(err, "could not start tls")
+		}
+		tc, err := c.handshake(nc, deadline)
+		if err != nil {
+			return nil, err
+		}
+		cn.nc, cn.r = tc, bufio.NewReader(tc)
+	}
+	return cn, nil
+}
+
+func (c *Client) handshake(nc net.Conn, deadline time.Time) (net.Conn, error) {
+	tc := tls.Client(nc, c.cfg.TLS)
+	tc.SetDeadline(deadline)
+	if err := tc.Handshake(); err != nil {
+		nc.Close()
+		return nil, errors.Wrap(err, "could not establish tls with ldap")
+	}
+	return tc, nil
+}
+
+func (c *Client) deadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(c.cfg.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
+
+// Conn is a connection of Client passed to Do, it is not safe for concurrent use.
+type Conn struct {
+	nc  net.Conn
+	r   *bufio.Reader
+	c   *Client
+	ctx context.Context
+	id  int64
+}
+
+func newConn(nc net.Conn, c *Client) *Conn {
+	return &Conn{nc: nc, r: bufio.NewReader(nc), c: c}
+}
+
+// Bind authenticates connection as dn with password, failed bind leaves connection anonymous.
+func (cn *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return ErrEmptyPassword
+	}
+	_, err := cn.do(opBindResponse, encodeSeq(opBindRequest,
+		encodeInt(tagInteger, 3),
+		encodeString(tagOctetString, dn),
+		encodeString(classContext|0, password),
+	))
+	return err
+}
+
+// Search returns entries matching request. References to other servers are skipped.
+func (cn *Conn) Search(req SearchRequest) ([]*Entry, error) {
+	filter, err := compileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	attrs := make([][]byte, 0, len(req.Attributes))
+	for _, a := range req.Attributes {
+		attrs = append(attrs, encodeString(tagOctetString, a))
+	}
+	var entries []*Entry
+	_, err = cn.do(opSearchDone, encodeSeq(opSearchRequest,
+		encodeString(tagOctetString, req.BaseDN),
+		encodeInt(tagEnumerated, int64(req.Scope)),
+		encodeInt(tagEnumerated, 0), // never dereference aliases
+		encodeInt(tagInteger, int64(req.SizeLimit)),
+		encodeInt(tagInteger, int64(cn.c.cfg.Timeout/time.Second)),
+		encodeBool(false),
+		filter,
+		encodeSeq(tagSequence, attrs...),
+	), func(op element) error {
+		if op.tag != opSearchEntry {
+			return nil
+		}
+		e, err := parseEntry(op)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+// Close unbinds and closes connection.
+func (cn *Conn) Close() error {
+	cn.id++
+	cn.nc.SetDeadline(time.Now().Add(cn.c.cfg.Timeout))
+	cn.nc.Write(encodeSeq(tagSequence, encodeInt(tagInteger, cn.id), encode(opUnbindRequest, nil)))
+	return cn.nc.Close()
+}
+
+// do sends request and reads responses until one tagged done, other responses are passed to
+// intermediate callbacks. It returns the final response.
+func (cn *Conn) do(done byte, req []byte, intermediate ...func(element) error) (element, error) {
+	metrics.Add("operations", 1)
+	op, err := cn.roundTrip(done, req, intermediate...)
+	if err != nil {
+		metrics.Add("errors", 1)
+	}
+	return op, err
+}
+
+func (cn *Conn) roundTrip(done byte, req []byte, intermediate ...func(element) error) (element, error) {
+	ctx := cn.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cn.nc.SetDeadline(cn.c.deadline(ctx))
+	cn.id++
+	if _, err := cn.nc.Write(encodeSeq(tagSequence, encodeInt(tagInteger, cn.id), req)); err != nil {
+		return element{}, errors.Wrap(err, "ldap request failed")
+	}
+	for {
+		packet, err := readPacket(cn.r)
+		if err != nil {
+			return element{}, errors.Wrap(err, "ldap request failed")
+		}
+		msg, _, err := decode(packet)
+		if err != nil {
+			return element{}, err
+		}
+		fields, err := msg.children()
+		if err != nil {
+			return element{}, err
+		}
+		if msg.tag != tagSequence || len(fields) < 2 {
+			return element{}, errors.New("ldap: invalid message")
+		}
+		// unsolicited notifications have id 0, e.g. notice of disconnection
+		if id := fields[0].int(); id != cn.id {
+			return element{}, errors.Errorf("ldap: unexpected message %d, expected %d", id, cn.id)
+		}
+		op := fields[1]
+		if op.tag != done {
+			for _, fn := range intermediate {
+				if err := fn(op); err != nil {
+					return element{}, err
+				}
+			}
+			continue
+		}
+		return op, parseResult(op)
+	}
+}
+
+// parseResult returns Error when result of op is not success.
+func parseResult(op element) error {
+	fields, err := op.children()
+	if err != nil {
+		return err
+	}
+	if len(fields) < 3 {
+		return errors.New("ldap: invalid result")
+	}
+	if code := int(fields[0].int()); code != ResultSuccess {
+		return &Error{Code: code, Message: fields[2].string()}
+	}
+	return nil
+}
+
+func parseEntry(op element) (*Entry, error) {
+	fields, err := op.children()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 2 {
+		return nil, errors.New("ldap: invalid search entry")
+	}
+	attrs, err := fields[1].children()
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{DN: fields[0].string(), Attributes: make(map[string][]string, len(attrs))}
+	for _, a := range attrs {
+		parts, err := a.children()
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) != 2 {
+			return nil, errors.New("ldap: invalid attribute of search entry")
+		}
+		vals, err := parts[1].children()
+		if err != nil {
+			return nil, err
+		}
+		name := strings.ToLower(parts[0].string())
+		for _, v := range vals {
+			e.Attributes[name] = append(e.Attributes[name], v.string())
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,244 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// server is a fake directory which answers operations with handle and counts connections.
+type server struct {
+	ln     net.Listener
+	tls    *tls.Config
+	handle func(op element) [][]byte
+
+	mu    sync.Mutex
+	conns int
+}
+
+func newServer(t *testing.T, handle func(op element) [][]byte) *server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{ln: ln, handle: handle}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *server) serve(c net.Conn) {
+	defer func() { c.Close() }()
+	r := bufio.NewReader(c)
+	for {
+		packet, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		msg, _, _ := decode(packet)
+		fields, _ := msg.children()
+		id, op := fields[0], fields[1]
+		if op.tag == opUnbindRequest {
+			return
+		}
+		if op.tag == opExtendedRequest {
+			c.Write(encodeSeq(tagSequence, encode(tagInteger, id.data), result(opExtendedResponse, ResultSuccess, "")))
+			tc := tls.Server(c, s.tls)
+			c, r = tc, bufio.NewReader(tc)
+			continue
+		}
+		for _, resp := range s.handle(op) {
+			c.Write(encodeSeq(tagSequence, encode(tagInteger, id.data), resp))
+		}
+	}
+}
+
+func (s *server) Conns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func result(tag byte, code int, msg string) []byte {
+	return encodeSeq(tag, encodeInt(tagEnumerated, int64(code)), encodeString(tagOctetString, ""), encodeString(tagOctetString, msg))
+}
+
+func entry(dn string, attrs map[string][]string) []byte {
+	var list [][]byte
+	for name, values := range attrs {
+		var vals [][]byte
+		for _, v := range values {
+			vals = append(vals, encodeString(tagOctetString, v))
+		}
+		list = append(list, encodeSeq(tagSequence, encodeString(tagOctetString, name), encodeSeq(tagSet, vals...)))
+	}
+	return encodeSeq(opSearchEntry, encodeString(tagOctetString, dn), encodeSeq(tagSequence, list...))
+}
+
+// directory answers binds of admin with password secret and searches with one person.
+func directory(op element) [][]byte {
+	fields, _ := op.children()
+	switch op.tag {
+	case opBindRequest:
+		if fields[1].string() == "cn=admin,dc=example,dc=com" && fields[2].string() == "secret" {
+			return [][]byte{result(opBindResponse, ResultSuccess, "")}
+		}
+		return [][]byte{result(opBindResponse, ResultInvalidCredentials, "invalid credentials")}
+	case opSearchRequest:
+		filter, _ := compileFilter("(&(objectClass=person)(uid=john))")
+		if fields[0].string() != "dc=example,dc=com" || !bytes.Equal(encode(fields[6].tag, fields[6].data), filter) {
+			return [][]byte{result(opSearchDone, ResultNoSuchObject, "")}
+		}
+		return [][]byte{
+			entry("uid=john,dc=example,dc=com", map[string][]string{
+				"mail":     {"john@example.com"},
+				"memberOf": {"cn=admins,dc=example,dc=com", "cn=staff,dc=example,dc=com"},
+			}),
+			encodeSeq(opSearchReference, encodeString(tagOctetString, "ldap://other/dc=example,dc=com")),
+			result(opSearchDone, ResultSuccess, ""),
+		}
+	}
+	return [][]byte{result(op.tag+1, 53, "unwilling to perform")}
+}
+
+func TestClient_Do(t *testing.T) {
+	s := newServer(t, directory)
+	defer s.ln.Close()
+
+	c, err := New("ldap://"+s.ln.Addr().String(), Config{PoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	var entries []*Entry
+	err = c.Do(ctx, func(cn *Conn) error {
+		if err := cn.Bind("cn=admin,dc=example,dc=com", "secret"); err != nil {
+			return err
+		}
+		entries, err = cn.Search(SearchRequest{
+			BaseDN:     "dc=example,dc=com",
+			Scope:      ScopeSub,
+			Filter:     "(&(objectClass=person)(uid=" + EscapeFilter("john") + "))",
+			Attributes: []string{"mail", "memberOf"},
+		})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].DN != "uid=john,dc=example,dc=com" {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	if v := entries[0].Value("MAIL"); v != "john@example.com" {
+		t.Errorf("unexpected mail %q", v)
+	}
+	if v := entries[0].Values("memberof"); len(v) != 2 {
+		t.Errorf("unexpected groups %v", v)
+	}
+
+	err = c.Do(ctx, func(cn *Conn) error { return cn.Bind("cn=admin,dc=example,dc=com", "wrong") })
+	if !IsCode(err, ResultInvalidCredentials) {
+		t.Errorf("unexpected error of invalid credentials %v", err)
+	}
+	err = c.Do(ctx, func(cn *Conn) error { return cn.Bind("cn=admin,dc=example,dc=com", "") })
+	if err != ErrEmptyPassword {
+		t.Errorf("unexpected error of empty password %v", err)
+	}
+	// failed binds do not break connection, so it is reused
+	if n := s.Conns(); n != 1 {
+		t.Errorf("unexpected number of connections %d", n)
+	}
+
+	for _, u := range []string{"http://localhost", "ldap://", "ldaps://"} {
+		if _, err := New(u, Config{}); err == nil {
+			t.Errorf("%s: invalid url is accepted", u)
+		}
+	}
+	if _, err := New("ldaps://localhost", Config{StartTLS: true}); err == nil {
+		t.Error("StartTLS is accepted with ldaps")
+	}
+}
+
+func TestClient_StartTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	s := newServer(t, directory)
+	defer s.ln.Close()
+	s.tls = ts.TLS
+
+	_, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	c, err := New("ldap://127.0.0.1:"+port, Config{
+		StartTLS: true,
+		// certificate of httptest is issued for example.com
+		TLS: &tls.Config{RootCAs: ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs, ServerName: "example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	err = c.Do(context.Background(), func(cn *Conn) error {
+		if _, ok := cn.nc.(*tls.Conn); !ok {
+			t.Error("connection is not upgraded to tls")
+		}
+		return cn.Bind("cn=admin,dc=example,dc=com", "secret")
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	str := func(s string) []byte { return encodeString(tagOctetString, s) }
+	cases := []struct {
+		filter string
+		want   []byte
+	}{
+		{"(uid=john)", encodeSeq(filterEquality, str("uid"), str("john"))},
+		{`(cn=a\2ab)`, encodeSeq(filterEquality, str("cn"), str("a*b"))},
+		{"(mail=*)", encodeString(filterPresent, "mail")},
+		{"(cn=J*o*n)", encodeSeq(filterSubstrings, str("cn"), encodeSeq(tagSequence,
+			encodeString(classContext|0, "J"), encodeString(classContext|1, "o"), encodeString(classContext|2, "n")))},
+		{"(age>=18)", encodeSeq(filterGreaterOrEqual, str("age"), str("18"))},
+		{"(&(objectClass=person)(!(cn=x)))", encodeSeq(filterAnd,
+			encodeSeq(filterEquality, str("objectClass"), str("person")),
+			encode(filterNot, encodeSeq(filterEquality, str("cn"), str("x"))))},
+		{"(|(a=1)(b=2))", encodeSeq(filterOr, encodeSeq(filterEquality, str("a"), str("1")), encodeSeq(filterEquality, str("b"), str("2")))},
+	}
+	for _, c := range cases {
+		got, err := compileFilter(c.filter)
+		if err != nil {
+			t.Errorf("%s: %v", c.filter, err)
+			continue
+		}
+		if !bytes.Equal(got, c.want) {
+			t.Errorf("%s: unexpected encoding % x, expected % x", c.filter, got, c.want)
+		}
+	}
+	for _, f := range []string{"", "uid=john", "(uid=john", "(&)", "(=x)", "(uid=john))", `(cn=\zz)`} {
+		if _, err := compileFilter(f); err == nil {
+			t.Errorf("%q: invalid filter is accepted", f)
+		}
+	}
+
+	if got := EscapeFilter(`a*(b)\`); got != `a\2a\28b\29\5c` {
+		t.Errorf("unexpected escaping %q", got)
+	}
+}
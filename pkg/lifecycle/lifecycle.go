@@ -0,0 +1,163 @@
+// Package lifecycle starts and stops components of the service in order.
+//
+// Components append hooks with names of hooks they depend on. Start runs hooks so that
+// dependencies are started first, Stop runs them in reverse order of start. When a hook
+// fails to start, hooks started before it are stopped, so a failed startup leaves nothing running.
+package lifecycle
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// Hook starts and stops a component.
+type Hook struct {
+	Name      string
+	DependsOn []string
+	// Start must not block, long running work is started in a goroutine.
+	// Hook without Start is started when it is appended, e.g. a connection opened while building.
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+	// Timeout limits Start and Stop each, default timeout of manager is used if zero.
+	Timeout time.Duration
+}
+
+// Manager keeps hooks of components.
+type Manager struct {
+	timeout time.Duration
+	logger  log.Logger
+
+	mu      sync.Mutex
+	pending []Hook
+	started []Hook
+}
+
+// New creates manager, timeout limits hooks which do not set their own.
+func New(timeout time.Duration, logger log.Logger) *Manager {
+	return &Manager{timeout: timeout, logger: logger}
+}
+
+// Append adds hook, it is started by the next Start.
+func (m *Manager) Append(h Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if h.Start == nil {
+		m.started = append(m.started, h)
+		return
+	}
+	m.pending = append(m.pending, h)
+}
+
+// Start runs pending hooks with dependencies first, otherwise in order of appending.
+// On failure already started hooks are stopped and the error of failed hook is returned.
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for len(m.pending) > 0 {
+		i := m.next()
+		if i < 0 {
+			err := errors.Errorf("could not start %s: unknown or cyclic dependencies", m.names(m.pending))
+			m.stop(ctx)
+			return err
+		}
+		h := m.pending[i]
+		m.pending = append(m.pending[:i], m.pending[i+1:]...)
+
+		m.logger.Debugf("starting %s", h.Name)
+		if err := m.run(ctx, h, h.Start); err != nil {
+			m.pending = nil
+			m.stop(ctx)
+			return errors.Wrapf(err, "could not start %s", h.Name)
+		}
+		m.started = append(m.started, h)
+	}
+	return nil
+}
+
+// Stop runs hooks in reverse order of start, all hooks are stopped even if some fail.
+// It returns the first error.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stop(ctx)
+}
+
+func (m *Manager) stop(ctx context.Context) error {
+	var first error
+	for i := len(m.started) - 1; i >= 0; i-- {
+		h := m.started[i]
+		if h.Stop == nil {
+			continue
+		}
+		m.logger.Debugf("stopping %s", h.Name)
+		if err := m.run(ctx, h, h.Stop); err != nil {
+			err = errors.Wrapf(err, "could not stop %s", h.Name)
+			m.logger.WithError(err).Error()
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	m.started = nil
+	return first
+}
+
+// next returns index of the first pending hook which dependencies are started, or -1.
+func (m *Manager) next() int {
+	for i, h := range m.pending {
+		ready := true
+		for _, dep := range h.DependsOn {
+			if !m.isStarted(dep) {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Manager) isStarted(name string) bool {
+	for _, h := range m.started {
+		if h.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) names(hooks []Hook) string {
+	var names []string
+	for _, h := range hooks {
+		names = append(names, h.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// run calls fn with timeout, fn is abandoned when it does not return in time.
+func (m *Manager) run(ctx context.Context, h Hook, fn func(ctx context.Context) error) error {
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = m.timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- fn(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return errors.Errorf("timed out after %s", timeout)
+	}
+}
@@ -0,0 +1,121 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) hook(name string, startErr error, dependsOn ...string) Hook {
+	return Hook{
+		Name:      name,
+		DependsOn: dependsOn,
+		Start: func(ctx context.Context) error {
+			r.events = append(r.events, "start "+name)
+			return startErr
+		},
+		Stop: func(ctx context.Context) error {
+			r.events = append(r.events, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestManager(t *testing.T) {
+	var r recorder
+	m := New(time.Second, log.New("text", "error", ioutil.Discard))
+	m.Append(Hook{Name: "db", Stop: func(ctx context.Context) error {
+		r.events = append(r.events, "stop db")
+		return nil
+	}})
+	m.Append(r.hook("http", nil, "jobs", "cache"))
+	m.Append(r.hook("jobs", nil, "db"))
+	m.Append(r.hook("cache", nil))
+
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start jobs", "start cache", "start http", "stop http", "stop cache", "stop jobs", "stop db"}
+	if !reflect.DeepEqual(r.events, want) {
+		t.Errorf("unexpected events: %v", r.events)
+	}
+}
+
+func TestManager_StartFailure(t *testing.T) {
+	var r recorder
+	m := New(time.Second, log.New("text", "error", ioutil.Discard))
+	m.Append(r.hook("jobs", nil))
+	m.Append(r.hook("http", errors.New("address in use"), "jobs"))
+	m.Append(r.hook("debug", nil))
+
+	err := m.Start(context.Background())
+	if err == nil || err.Error() != "could not start http: address in use" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := []string{"start jobs", "start http", "stop jobs"}
+	if !reflect.DeepEqual(r.events, want) {
+		t.Errorf("unexpected events: %v", r.events)
+	}
+}
+
+func TestManager_UnknownDependency(t *testing.T) {
+	var r recorder
+	m := New(time.Second, log.New("text", "error", ioutil.Discard))
+	m.Append(r.hook("jobs", nil))
+	m.Append(r.hook("http", nil, "cache"))
+
+	if err := m.Start(context.Background()); err == nil {
+		t.Error("hook with unknown dependency is started")
+	}
+	want := []string{"start jobs", "stop jobs"}
+	if !reflect.DeepEqual(r.events, want) {
+		t.Errorf("unexpected events: %v", r.events)
+	}
+}
+
+func TestManager_Timeout(t *testing.T) {
+	m := New(10*time.Millisecond, log.New("text", "error", ioutil.Discard))
+	block := make(chan struct{})
+	defer close(block)
+	m.Append(Hook{
+		Name:  "slow",
+		Start: func(ctx context.Context) error { return nil },
+		Stop: func(ctx context.Context) error {
+			<-block
+			return nil
+		},
+	})
+	stopped := false
+	m.Append(Hook{
+		Name:    "patient",
+		Start:   func(ctx context.Context) error { return nil },
+		Stop:    func(ctx context.Context) error { return nil },
+		Timeout: time.Second,
+	})
+	m.Append(Hook{Name: "first", Stop: func(ctx context.Context) error {
+		stopped = true
+		return nil
+	}})
+
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Stop(context.Background()); err == nil {
+		t.Error("slow hook is not timed out")
+	}
+	if !stopped {
+		t.Error("hooks after slow one are not stopped")
+	}
+}
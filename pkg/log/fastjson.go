@@ -0,0 +1,139 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FastJSONFormatter is a drop-in replacement for logrus.JSONFormatter for hot paths.
+// It appends fields directly to the pooled entry buffer instead of copying them to a map
+// and marshaling it with reflection, common field types are encoded without allocations.
+// Zap backend selected with SetBackend is an alternative which also encodes text format.
+type FastJSONFormatter struct{}
+
+func (f *FastJSONFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	b := entry.Buffer
+	if b == nil {
+		b = &bytes.Buffer{}
+	}
+
+	buf := b.Bytes()[:0]
+	buf = append(buf, `{"time":"`...)
+	buf = entry.Time.AppendFormat(buf, time.RFC3339)
+	buf = append(buf, `","level":"`...)
+	buf = append(buf, entry.Level.String()...)
+	buf = append(buf, `","msg":`...)
+	buf = appendString(buf, entry.Message)
+
+	for k, v := range entry.Data {
+		switch k {
+		case "time", "level", "msg":
+			// the same way as logrus resolves clashes with default fields
+			k = "fields." + k
+		}
+		buf = append(buf, ',')
+		buf = appendString(buf, k)
+		buf = append(buf, ':')
+		var err error
+		buf, err = appendValue(buf, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	buf = append(buf, '}', '\n')
+
+	b.Reset()
+	b.Write(buf)
+	return b.Bytes(), nil
+}
+
+func appendValue(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, "null"...), nil
+	case string:
+		return appendString(buf, v), nil
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case int32:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case uint:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint64:
+		return strconv.AppendUint(buf, v, 10), nil
+	case float64:
+		return strconv.AppendFloat(buf, v, 'f', -1, 64), nil
+	case float32:
+		return strconv.AppendFloat(buf, float64(v), 'f', -1, 32), nil
+	case bool:
+		return strconv.AppendBool(buf, v), nil
+	case time.Duration:
+		return appendString(buf, v.String()), nil
+	case time.Time:
+		buf = append(buf, '"')
+		buf = v.AppendFormat(buf, time.RFC3339Nano)
+		return append(buf, '"'), nil
+	case error:
+		return appendString(buf, v.Error()), nil
+	case fmt.Stringer:
+		return appendString(buf, v.String()), nil
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal field to JSON, %v", err)
+		}
+		return append(buf, data...), nil
+	}
+}
+
+const hex = "0123456789abcdef"
+
+// appendString appends s as JSON string.
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				buf = append(buf, s[start:i]...)
+				buf = append(buf, `�`...)
+				i += size
+				start = i
+				continue
+			}
+			i += size
+			continue
+		}
+		if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+			i++
+			continue
+		}
+		buf = append(buf, s[start:i]...)
+		switch c {
+		case '"', '\\':
+			buf = append(buf, '\\', c)
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		default:
+			buf = append(buf, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		}
+		i++
+		start = i
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
@@ -0,0 +1,63 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFastJSONFormatter(t *testing.T) {
+	var out bytes.Buffer
+	l := New("fastjson", "info", &out)
+	l.WithFields(logrus.Fields{
+		"req_id":  "abc",
+		"status":  200,
+		"elapsed": 1.5,
+		"error":   errors.New("\"quoted\"\n"),
+		"msg":     "clash",
+		"struct":  struct{ A int }{1},
+		"took":    time.Second,
+	}).Info("request complete")
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &data); err != nil {
+		t.Fatalf("invalid json %q: %v", out.String(), err)
+	}
+	expected := map[string]interface{}{
+		"msg":        "request complete",
+		"level":      "info",
+		"req_id":     "abc",
+		"status":     float64(200),
+		"elapsed":    1.5,
+		"error":      "\"quoted\"\n",
+		"fields.msg": "clash",
+		"took":       "1s",
+	}
+	for k, v := range expected {
+		if data[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, data[k])
+		}
+	}
+}
+
+func BenchmarkFastJSONFormatter(b *testing.B) {
+	entry := logrus.NewEntry(logrus.New()).WithFields(logrus.Fields{
+		"req_id": "abc",
+		"status": 200,
+		"uri":    "http://example.com/1.0/articles",
+	})
+	entry.Message = "request complete"
+	entry.Buffer = &bytes.Buffer{}
+	f := &FastJSONFormatter{}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := f.Format(entry); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
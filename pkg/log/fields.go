@@ -0,0 +1,30 @@
+package log
+
+import "github.com/sirupsen/logrus"
+
+// SetFields adds fields to every entry, including request log, e.g. name of pod, so entries of replicas
+// can be told apart. Fields of entry win over them. It must be called before SetRouteRules.
+func (l *StructuredLogger) SetFields(fields map[string]string) {
+	if len(fields) == 0 {
+		return
+	}
+	l.Formatter = &fieldsFormatter{Formatter: l.Formatter, fields: fields}
+}
+
+type fieldsFormatter struct {
+	logrus.Formatter
+	fields map[string]string
+}
+
+func (f *fieldsFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	// entry data is shared with the entry it was derived from, so it is copied
+	e := *entry
+	e.Data = make(logrus.Fields, len(entry.Data)+len(f.fields))
+	for k, v := range f.fields {
+		e.Data[k] = v
+	}
+	for k, v := range entry.Data {
+		e.Data[k] = v
+	}
+	return f.Formatter.Format(&e)
+}
@@ -0,0 +1,23 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestSetFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := New("json", "info", &buf)
+	l.SetFields(map[string]string{"pod": "goapi-7d4b9-x2x5q", "node": "node-1"})
+
+	l.WithField("node", "overridden").Info("started")
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["pod"] != "goapi-7d4b9-x2x5q" || got["node"] != "overridden" || got["msg"] != "started" {
+		t.Errorf("unexpected entry: %v", got)
+	}
+}
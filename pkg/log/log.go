@@ -19,6 +19,8 @@ type Logger interface {
 // StructuredLogger provides the logger backend using Sirupsen/logrus
 type StructuredLogger struct {
 	*logrus.Logger
+
+	routes []routeLogger
 }
 
 // New creates StructuredLogger and configure it
@@ -31,6 +33,8 @@ func New(format, level string, output io.Writer) *StructuredLogger {
 	switch f {
 	case "json":
 		logger.Formatter = &logrus.JSONFormatter{}
+	case "fastjson":
+		logger.Formatter = &FastJSONFormatter{}
 	case "text":
 		logger.Formatter = &logrus.TextFormatter{ForceColors: true}
 	default:
@@ -45,11 +49,15 @@ func New(format, level string, output io.Writer) *StructuredLogger {
 	}
 	logger.Level = sev
 
-	return &StructuredLogger{logger}
+	return &StructuredLogger{Logger: logger}
 }
 
 func (l *StructuredLogger) NewLogEntry(r *http.Request) middleware.LogEntry {
-	entry := &StructuredLoggerEntry{Logger: logrus.NewEntry(l.Logger)}
+	base, rate := l.Logger, 1.0
+	if route := l.route(r.URL.Path); route != nil {
+		base, rate = route.logger, route.SampleRate
+	}
+	entry := &StructuredLoggerEntry{Logger: logrus.NewEntry(base), sampleRate: rate}
 	logFields := logrus.Fields{}
 
 	if reqID := middleware.GetReqID(r.Context()); reqID != "" {
@@ -75,6 +83,8 @@ func (l *StructuredLogger) NewLogEntry(r *http.Request) middleware.LogEntry {
 
 type StructuredLoggerEntry struct {
 	Logger logrus.FieldLogger
+
+	sampleRate float64
 }
 
 func (l *StructuredLoggerEntry) Write(status, bytes int, elapsed time.Duration) {
@@ -83,7 +93,14 @@ func (l *StructuredLoggerEntry) Write(status, bytes int, elapsed time.Duration)
 		"resp_elasped_ms": float64(elapsed.Nanoseconds()) / 1000000.0,
 	})
 
-	l.Logger.Infoln("request complete")
+	switch {
+	case status >= http.StatusInternalServerError:
+		l.Logger.Errorln("request complete")
+	case status >= http.StatusBadRequest:
+		l.Logger.Warnln("request complete")
+	case sampled(l.sampleRate):
+		l.Logger.Infoln("request complete")
+	}
 }
 
 func (l *StructuredLoggerEntry) Panic(v interface{}, stack []byte) {
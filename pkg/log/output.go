@@ -0,0 +1,104 @@
+package log
+
+import (
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// OutputConfig describes where logs are written.
+type OutputConfig struct {
+	// Output is one of stdout, stderr, file or syslog.
+	Output string
+
+	FilePath       string
+	FileMaxSize    int64
+	FileMaxAge     time.Duration
+	FileMaxBackups int
+
+	// Empty network and address means local syslog, which is also collected by journald.
+	SyslogNetwork string
+	SyslogAddr    string
+	SyslogTag     string
+}
+
+// SetOutput redirects logger to configured output. Returned closer must be closed on exit.
+// It must be called before SetRouteRules.
+func (l *StructuredLogger) SetOutput(cfg OutputConfig) (io.Closer, error) {
+	switch cfg.Output {
+	case "", "stdout":
+		l.Out = os.Stdout
+		return ioutil.NopCloser(nil), nil
+	case "stderr":
+		l.Out = os.Stderr
+		return ioutil.NopCloser(nil), nil
+	case "file":
+		f, err := OpenRotatingFile(cfg.FilePath, cfg.FileMaxSize, cfg.FileMaxAge, cfg.FileMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		l.disableColors()
+		l.Out = f
+		return f, nil
+	case "syslog":
+		w, err := syslog.Dial(cfg.SyslogNetwork, cfg.SyslogAddr, syslog.LOG_INFO|syslog.LOG_DAEMON, cfg.SyslogTag)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not connect to syslog")
+		}
+		// entries are written by hook with proper severity
+		l.disableColors()
+		l.Out = ioutil.Discard
+		l.Hooks.Add(&syslogHook{writer: w})
+		return w, nil
+	default:
+		return nil, errors.Errorf("unknown log output: %v", cfg.Output)
+	}
+}
+
+func (l *StructuredLogger) disableColors() {
+	formatter := l.Formatter
+	if rf, ok := formatter.(*redactFormatter); ok {
+		formatter = rf.Formatter
+	}
+	switch f := formatter.(type) {
+	case *logrus.TextFormatter:
+		f.ForceColors = false
+		f.DisableColors = true
+	case *zapFormatter:
+		f.setColors(false)
+	}
+}
+
+type syslogHook struct {
+	writer *syslog.Writer
+}
+
+func (h *syslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *syslogHook) Fire(entry *logrus.Entry) error {
+	line, err := entry.String()
+	if err != nil {
+		return err
+	}
+	switch entry.Level {
+	case logrus.PanicLevel:
+		return h.writer.Crit(line)
+	case logrus.FatalLevel:
+		return h.writer.Crit(line)
+	case logrus.ErrorLevel:
+		return h.writer.Err(line)
+	case logrus.WarnLevel:
+		return h.writer.Warning(line)
+	case logrus.InfoLevel:
+		return h.writer.Info(line)
+	default:
+		return h.writer.Debug(line)
+	}
+}
@@ -0,0 +1,45 @@
+package log
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"github.com/agalitsyn/goapi/pkg/redact"
+)
+
+// urlFields are masked as URLs, so sensitive query parameters are masked while the rest stays readable.
+var urlFields = map[string]bool{"uri": true, "url": true}
+
+// SetRedactor masks sensitive fields of every entry, including request log, before it is formatted,
+// so every output and route logger gets redacted entries. It must be called before SetRouteRules.
+func (l *StructuredLogger) SetRedactor(r *redact.Redactor) {
+	if f, ok := l.Formatter.(*redactFormatter); ok {
+		f.redactor = r
+		return
+	}
+	l.Formatter = &redactFormatter{Formatter: l.Formatter, redactor: r}
+}
+
+type redactFormatter struct {
+	logrus.Formatter
+	redactor *redact.Redactor
+}
+
+func (f *redactFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	// entry data is shared with the entry it was derived from, so it is copied
+	e := *entry
+	e.Data = make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		switch {
+		case f.redactor.Sensitive(k):
+			v = redact.Mask
+		case urlFields[k]:
+			if s, ok := v.(string); ok {
+				v = f.redactor.URL(s)
+			}
+		default:
+			v = f.redactor.Value(v)
+		}
+		e.Data[k] = v
+	}
+	return f.Formatter.Format(&e)
+}
@@ -0,0 +1,34 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/redact"
+)
+
+func TestSetRedactor(t *testing.T) {
+	var buf bytes.Buffer
+	l := New("json", "info", &buf)
+	l.SetRedactor(redact.New("password", "token"))
+
+	entry := l.NewLogEntry(httptest.NewRequest("GET", "/1.0/articles?access_token=abc&page=2", nil))
+	e := entry.(*StructuredLoggerEntry)
+	e.Logger = e.Logger.WithField("body", map[string]interface{}{"user": map[string]interface{}{"password": "s3cret", "name": "John"}})
+	entry.Write(200, 0, time.Millisecond)
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["uri"] != "http://example.com/1.0/articles?access_token=%5BFiltered%5D&page=2" {
+		t.Errorf("unexpected uri: %v", got["uri"])
+	}
+	user := got["body"].(map[string]interface{})["user"].(map[string]interface{})
+	if user["password"] != redact.Mask || user["name"] != "John" {
+		t.Errorf("unexpected body: %v", user)
+	}
+}
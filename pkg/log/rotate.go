@@ -0,0 +1,148 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const backupTimeFormat = "20060102T150405.000000000"
+
+// rename is replaced by tests to fail rotation.
+var rename = os.Rename
+
+// RotatingFile is an io.WriteCloser which rotates the underlying file
+// when it grows over MaxSize bytes or gets older than MaxAge.
+// Rotated files are renamed to <path>.<timestamp>, only MaxBackups latest of them are kept.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// OpenRotatingFile opens (or creates) file at path for appending.
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		Path:       path,
+		MaxSize:    maxSize,
+		MaxAge:     maxAge,
+		MaxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		// file could not be reopened on rotation
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	var rotateErr error
+	if f.needsRotation(int64(len(p))) {
+		rotateErr = f.rotate()
+		if f.file == nil {
+			return 0, rotateErr
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err == nil {
+		// entry is written even if file is not rotated, rotation is retried by the next write
+		err = rotateErr
+	}
+	return n, err
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+func (f *RotatingFile) needsRotation(n int64) bool {
+	if f.MaxSize > 0 && f.size > 0 && f.size+n > f.MaxSize {
+		return true
+	}
+	return f.MaxAge > 0 && time.Since(f.openedAt) > f.MaxAge
+}
+
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return errors.Wrap(err, "could not create log directory")
+	}
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not open log file")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "could not stat log file")
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	return nil
+}
+
+// rotate renames file to backup and opens a new one, file is reopened for appending if it can not be renamed.
+// File is nil if it can not be opened again.
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return errors.Wrap(err, "could not close log file")
+	}
+	backup := f.Path + "." + time.Now().UTC().Format(backupTimeFormat)
+	if err := rename(f.Path, backup); err != nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+		return errors.Wrap(err, "could not rotate log file")
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.prune()
+}
+
+func (f *RotatingFile) prune() error {
+	if f.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(f.Path + ".*")
+	if err != nil {
+		return errors.Wrap(err, "could not list rotated log files")
+	}
+	if len(backups) <= f.MaxBackups {
+		return nil
+	}
+	// timestamp suffix keeps lexical order chronological
+	sort.Strings(backups)
+	for _, b := range backups[:len(backups)-f.MaxBackups] {
+		if err := os.Remove(b); err != nil {
+			return errors.Wrap(err, "could not remove rotated log file")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package log
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goapi-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "goapi.log")
+	f, err := OpenRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := f.Write([]byte("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Errorf("expected 2 backups, got %v", backups)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "0123456789" {
+		t.Errorf("unexpected log file content: %q", data)
+	}
+}
+
+func TestRotatingFile_RenameFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goapi-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "goapi.log")
+	f, err := OpenRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rename = func(string, string) error { return errors.New("device or resource busy") }
+	defer func() { rename = os.Rename }()
+
+	// entries are written to the file which could not be rotated
+	if _, err := f.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("abc")); err == nil {
+		t.Error("expected error of failed rotation")
+	}
+	if _, err := f.Write([]byte("def")); err == nil {
+		t.Error("expected error of failed rotation")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "0123456789abcdef" {
+		t.Errorf("unexpected log file content: %q", data)
+	}
+
+	// rotation is retried once rename succeeds
+	rename = os.Rename
+	if _, err := f.Write([]byte("ghi")); err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "ghi" {
+		t.Errorf("unexpected log file content after rotation: %q", data)
+	}
+}
@@ -0,0 +1,76 @@
+package log
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// RouteRule overrides request logging for requests which path starts with Prefix.
+type RouteRule struct {
+	Prefix string
+	Level  logrus.Level
+	// SampleRate is a fraction of successful responses to log, errors are always logged.
+	SampleRate float64
+}
+
+// ParseRouteRule parses rule in form prefix:level[:sample-rate], e.g. /readiness:info:0.01
+func ParseRouteRule(s string) (RouteRule, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return RouteRule{}, errors.Errorf("invalid route rule %q, expected prefix:level[:sample-rate]", s)
+	}
+
+	lvl, err := logrus.ParseLevel(parts[1])
+	if err != nil {
+		return RouteRule{}, errors.Wrapf(err, "invalid route rule %q", s)
+	}
+
+	rate := 1.0
+	if len(parts) == 3 {
+		rate, err = strconv.ParseFloat(parts[2], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return RouteRule{}, errors.Errorf("invalid route rule %q, sample rate must be in [0, 1]", s)
+		}
+	}
+	return RouteRule{Prefix: parts[0], Level: lvl, SampleRate: rate}, nil
+}
+
+type routeLogger struct {
+	RouteRule
+	logger *logrus.Logger
+}
+
+// SetRouteRules configures per-route request logging. It must be called before serving requests.
+func (l *StructuredLogger) SetRouteRules(rules []RouteRule) {
+	l.routes = make([]routeLogger, 0, len(rules))
+	for _, rule := range rules {
+		// shares output and formatting with the parent logger, only level differs
+		rl := &logrus.Logger{
+			Out:       l.Out,
+			Hooks:     l.Hooks,
+			Formatter: l.Formatter,
+			Level:     rule.Level,
+		}
+		l.routes = append(l.routes, routeLogger{RouteRule: rule, logger: rl})
+	}
+}
+
+// route returns the most specific rule for path.
+func (l *StructuredLogger) route(path string) *routeLogger {
+	var found *routeLogger
+	for i := range l.routes {
+		r := &l.routes[i]
+		if strings.HasPrefix(path, r.Prefix) && (found == nil || len(r.Prefix) > len(found.Prefix)) {
+			found = r
+		}
+	}
+	return found
+}
+
+func sampled(rate float64) bool {
+	return rate >= 1 || rand.Float64() < rate
+}
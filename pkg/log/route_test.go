@@ -0,0 +1,47 @@
+package log
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseRouteRule(t *testing.T) {
+	rule, err := ParseRouteRule("/readiness:warn:0.01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Prefix != "/readiness" || rule.Level != logrus.WarnLevel || rule.SampleRate != 0.01 {
+		t.Errorf("unexpected rule: %+v", rule)
+	}
+
+	rule, err = ParseRouteRule("/1.0/articles:debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.SampleRate != 1 {
+		t.Errorf("expected sample rate 1, got %v", rule.SampleRate)
+	}
+
+	for _, s := range []string{"", "/readiness", ":info", "/readiness:loud", "/readiness:info:2"} {
+		if _, err := ParseRouteRule(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	l := New("", "", ioutil.Discard)
+	l.SetRouteRules([]RouteRule{
+		{Prefix: "/1.0", Level: logrus.InfoLevel, SampleRate: 1},
+		{Prefix: "/1.0/articles", Level: logrus.WarnLevel, SampleRate: 1},
+	})
+
+	if r := l.route("/1.0/articles/1"); r == nil || r.Prefix != "/1.0/articles" {
+		t.Errorf("expected the most specific rule, got %+v", r)
+	}
+	if r := l.route("/readiness"); r != nil {
+		t.Errorf("expected no rule, got %+v", r)
+	}
+}
@@ -0,0 +1,165 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap/zapcore"
+)
+
+// SetBackend selects what encodes entries: logrus, or zap which also encodes text format without
+// reflection for common field types. Modules keep logging with Logger, zap only takes over encoding
+// of entries in the configured format, so outputs, hooks and route loggers work with both. It must be called before
+// SetFields and SetRedactor.
+func (l *StructuredLogger) SetBackend(name string) error {
+	switch name {
+	case "", "logrus":
+		return nil
+	case "zap":
+		_, text := l.Formatter.(*logrus.TextFormatter)
+		l.Formatter = newZapFormatter(text)
+		return nil
+	}
+	return errors.Errorf("log: invalid backend %q, expected logrus or zap", name)
+}
+
+// zapFormatter encodes entries with zap encoders, JSON or console one for text format.
+type zapFormatter struct {
+	text bool
+	enc  zapcore.Encoder
+}
+
+func newZapFormatter(text bool) *zapFormatter {
+	f := &zapFormatter{text: text}
+	f.setColors(text)
+	return f
+}
+
+// setColors colors levels of text format, logrus text format is colored too unless output is a file.
+func (f *zapFormatter) setColors(colors bool) {
+	cfg := zapcore.EncoderConfig{
+		TimeKey:        "time",
+		LevelKey:       "level",
+		MessageKey:     "msg",
+		EncodeTime:     zapcore.RFC3339TimeEncoder,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+		LineEnding:     zapcore.DefaultLineEnding,
+	}
+	if !f.text {
+		f.enc = zapcore.NewJSONEncoder(cfg)
+		return
+	}
+	cfg.EncodeLevel = zapcore.CapitalLevelEncoder
+	if colors {
+		cfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+	f.enc = zapcore.NewConsoleEncoder(cfg)
+}
+
+// zapScratch keeps sorted keys and fields of entry between entries, so they are not allocated per entry.
+type zapScratch struct {
+	keys   []string
+	fields []zapcore.Field
+}
+
+var zapScratchPool = sync.Pool{New: func() interface{} { return &zapScratch{} }}
+
+// Format writes encoded entry to the pooled entry buffer like FastJSONFormatter.
+func (f *zapFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	s := zapScratchPool.Get().(*zapScratch)
+	defer func() {
+		// fields may refer to values of entry
+		for i := range s.fields {
+			s.fields[i] = zapcore.Field{}
+		}
+		s.keys, s.fields = s.keys[:0], s.fields[:0]
+		zapScratchPool.Put(s)
+	}()
+
+	for k := range entry.Data {
+		s.keys = append(s.keys, k)
+	}
+	sort.Strings(s.keys)
+	for _, k := range s.keys {
+		key := k
+		switch k {
+		case "time", "level", "msg":
+			// the same way as logrus resolves clashes with default fields
+			key = "fields." + k
+		}
+		s.fields = append(s.fields, zapField(key, entry.Data[k]))
+	}
+
+	buf, err := f.enc.EncodeEntry(zapcore.Entry{
+		Level:   zapLevel(entry.Level),
+		Time:    entry.Time,
+		Message: entry.Message,
+	}, s.fields)
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Free()
+
+	b := entry.Buffer
+	if b == nil {
+		b = &bytes.Buffer{}
+	}
+	b.Reset()
+	b.Write(buf.Bytes())
+	return b.Bytes(), nil
+}
+
+// zapField encodes common types natively, errors as their messages like logrus does and the rest
+// with reflection.
+func zapField(key string, v interface{}) zapcore.Field {
+	switch v := v.(type) {
+	case nil:
+		return zapcore.Field{Key: key, Type: zapcore.ReflectType}
+	case string:
+		return zapcore.Field{Key: key, Type: zapcore.StringType, String: v}
+	case bool:
+		var i int64
+		if v {
+			i = 1
+		}
+		return zapcore.Field{Key: key, Type: zapcore.BoolType, Integer: i}
+	case int:
+		return zapcore.Field{Key: key, Type: zapcore.Int64Type, Integer: int64(v)}
+	case int64:
+		return zapcore.Field{Key: key, Type: zapcore.Int64Type, Integer: v}
+	case float64:
+		return zapcore.Field{Key: key, Type: zapcore.Float64Type, Integer: int64(math.Float64bits(v))}
+	case time.Duration:
+		return zapcore.Field{Key: key, Type: zapcore.DurationType, Integer: int64(v)}
+	case time.Time:
+		return zapcore.Field{Key: key, Type: zapcore.TimeFullType, Interface: v}
+	case error:
+		return zapcore.Field{Key: key, Type: zapcore.StringType, String: v.Error()}
+	case fmt.Stringer:
+		return zapcore.Field{Key: key, Type: zapcore.StringerType, Interface: v}
+	}
+	return zapcore.Field{Key: key, Type: zapcore.ReflectType, Interface: v}
+}
+
+func zapLevel(l logrus.Level) zapcore.Level {
+	switch l {
+	case logrus.PanicLevel:
+		return zapcore.PanicLevel
+	case logrus.FatalLevel:
+		return zapcore.FatalLevel
+	case logrus.ErrorLevel:
+		return zapcore.ErrorLevel
+	case logrus.WarnLevel:
+		return zapcore.WarnLevel
+	case logrus.InfoLevel:
+		return zapcore.InfoLevel
+	}
+	return zapcore.DebugLevel
+}
@@ -0,0 +1,90 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/agalitsyn/goapi/pkg/redact"
+)
+
+func TestZapBackend(t *testing.T) {
+	var out bytes.Buffer
+	l := New("json", "info", &out)
+	if err := l.SetBackend("zap"); err != nil {
+		t.Fatal(err)
+	}
+	l.SetRedactor(redact.New("password"))
+	l.WithFields(logrus.Fields{
+		"req_id":   "abc",
+		"status":   200,
+		"elapsed":  1.5,
+		"error":    errors.New("\"quoted\"\n"),
+		"msg":      "clash",
+		"struct":   struct{ A int }{1},
+		"took":     time.Second,
+		"password": "secret",
+	}).Warn("request complete")
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &data); err != nil {
+		t.Fatalf("invalid json %q: %v", out.String(), err)
+	}
+	expected := map[string]interface{}{
+		"msg":        "request complete",
+		"level":      "warn",
+		"req_id":     "abc",
+		"status":     float64(200),
+		"elapsed":    1.5,
+		"error":      "\"quoted\"\n",
+		"fields.msg": "clash",
+		"took":       "1s",
+		"password":   redact.Mask,
+	}
+	for k, v := range expected {
+		if data[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, data[k])
+		}
+	}
+	if s, ok := data["struct"].(map[string]interface{}); !ok || s["A"] != float64(1) {
+		t.Errorf("unexpected struct %v", data["struct"])
+	}
+
+	out.Reset()
+	l = New("text", "info", &out)
+	if err := l.SetBackend("zap"); err != nil {
+		t.Fatal(err)
+	}
+	l.disableColors()
+	l.WithField("req_id", "abc").Info("started")
+	if s := out.String(); !strings.Contains(s, "\tINFO\tstarted\t") || !strings.Contains(s, `{"req_id": "abc"}`) {
+		t.Errorf("unexpected text entry %q", s)
+	}
+
+	if err := New("json", "info", &out).SetBackend("zerolog"); err == nil {
+		t.Error("expected error of unknown backend")
+	}
+}
+
+func BenchmarkZapFormatter(b *testing.B) {
+	entry := logrus.NewEntry(logrus.New()).WithFields(logrus.Fields{
+		"req_id": "abc",
+		"status": 200,
+		"uri":    "http://example.com/1.0/articles",
+	})
+	entry.Message = "request complete"
+	entry.Buffer = &bytes.Buffer{}
+	f := newZapFormatter(false)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := f.Format(entry); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+// Package markdown renders a safe subset of Markdown to HTML.
+//
+// Supported are ATX headings, paragraphs, fenced code blocks, block quotes, flat lists,
+// horizontal rules, emphasis, strong emphasis, code spans, links and images.
+// Raw HTML is always escaped, and links with schemes other than http, https and mailto are dropped,
+// so the output can be embedded as is. Elements missing from Policy are rendered as text.
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Policy is an allowlist of HTML elements renderer may produce.
+type Policy map[string]bool
+
+// DefaultPolicy allows everything renderer can produce.
+var DefaultPolicy = NewPolicy("h1", "h2", "h3", "h4", "h5", "h6", "p", "pre", "code", "blockquote",
+	"ul", "ol", "li", "hr", "strong", "em", "a", "img")
+
+func NewPolicy(elements ...string) Policy {
+	p := make(Policy, len(elements))
+	for _, e := range elements {
+		p[strings.ToLower(strings.TrimSpace(e))] = true
+	}
+	return p
+}
+
+var (
+	headingRe = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	ulRe      = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	olRe      = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	hrRe      = regexp.MustCompile(`^\s*(-\s*){3,}$|^\s*(\*\s*){3,}$|^\s*(_\s*){3,}$`)
+)
+
+// Render renders Markdown source to HTML allowed by policy.
+func Render(src string, p Policy) string {
+	r := &renderer{policy: p}
+	r.blocks(strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n"))
+	return r.buf.String()
+}
+
+type renderer struct {
+	policy Policy
+	buf    bytes.Buffer
+}
+
+func (r *renderer) open(tag string) {
+	if r.policy[tag] {
+		r.buf.WriteString("<" + tag + ">")
+	}
+}
+
+func (r *renderer) close(tag string) {
+	if r.policy[tag] {
+		r.buf.WriteString("</" + tag + ">")
+	}
+}
+
+// block wraps inline content into tag, falling back to paragraph.
+func (r *renderer) block(tag, text string) {
+	if !r.policy[tag] {
+		tag = "p"
+	}
+	r.open(tag)
+	r.inline(text)
+	r.close(tag)
+	r.buf.WriteByte('\n')
+}
+
+func (r *renderer) blocks(lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+
+		case strings.HasPrefix(strings.TrimSpace(line), "```"):
+			i++
+			start := i
+			for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```") {
+				i++
+			}
+			r.code(strings.Join(lines[start:i], "\n"))
+			i++
+
+		case headingRe.MatchString(line):
+			m := headingRe.FindStringSubmatch(line)
+			r.block("h"+strconv.Itoa(len(m[1])), m[2])
+			i++
+
+		case hrRe.MatchString(line):
+			if r.policy["hr"] {
+				r.buf.WriteString("<hr>\n")
+			}
+			i++
+
+		case strings.HasPrefix(strings.TrimSpace(line), ">"):
+			var quoted []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quoted = append(quoted, strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">"), " "))
+			}
+			r.open("blockquote")
+			r.buf.WriteByte('\n')
+			r.blocks(quoted)
+			r.close("blockquote")
+			r.buf.WriteByte('\n')
+
+		case ulRe.MatchString(line) || olRe.MatchString(line):
+			re, tag := ulRe, "ul"
+			if !ulRe.MatchString(line) {
+				re, tag = olRe, "ol"
+			}
+			var items []string
+			for ; i < len(lines) && re.MatchString(lines[i]); i++ {
+				items = append(items, re.FindStringSubmatch(lines[i])[1])
+			}
+			r.list(tag, items)
+
+		default:
+			var para []string
+			for ; i < len(lines) && r.paragraph(lines[i]); i++ {
+				para = append(para, strings.TrimSpace(lines[i]))
+			}
+			r.block("p", strings.Join(para, "\n"))
+		}
+	}
+}
+
+// paragraph reports whether line continues paragraph.
+func (r *renderer) paragraph(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed != "" && !strings.HasPrefix(trimmed, "```") && !strings.HasPrefix(trimmed, ">") &&
+		!headingRe.MatchString(line) && !hrRe.MatchString(line) && !ulRe.MatchString(line) && !olRe.MatchString(line)
+}
+
+func (r *renderer) code(text string) {
+	if !r.policy["pre"] {
+		r.open("p")
+		r.buf.WriteString(html.EscapeString(text))
+		r.close("p")
+		r.buf.WriteByte('\n')
+		return
+	}
+	r.buf.WriteString("<pre>")
+	r.open("code")
+	r.buf.WriteString(html.EscapeString(text))
+	r.close("code")
+	r.buf.WriteString("</pre>\n")
+}
+
+func (r *renderer) list(tag string, items []string) {
+	if !r.policy[tag] || !r.policy["li"] {
+		for _, item := range items {
+			r.block("p", item)
+		}
+		return
+	}
+	r.buf.WriteString("<" + tag + ">\n")
+	for _, item := range items {
+		r.block("li", item)
+	}
+	r.buf.WriteString("</" + tag + ">\n")
+}
+
+// inline renders spans of text, delimiters without a pair are rendered as is.
+func (r *renderer) inline(text string) {
+	for len(text) > 0 {
+		switch {
+		case text[0] == '\\' && len(text) > 1 && strings.IndexByte("\\`*_[]()!#>-+.", text[1]) >= 0:
+			r.buf.WriteString(html.EscapeString(text[1:2]))
+			text = text[2:]
+			continue
+
+		case text[0] == '`':
+			if end := strings.IndexByte(text[1:], '`'); end >= 0 {
+				r.open("code")
+				r.buf.WriteString(html.EscapeString(text[1 : end+1]))
+				r.close("code")
+				text = text[end+2:]
+				continue
+			}
+
+		case strings.HasPrefix(text, "**") || strings.HasPrefix(text, "__"):
+			if end := strings.Index(text[2:], text[:2]); end > 0 {
+				r.open("strong")
+				r.inline(text[2 : end+2])
+				r.close("strong")
+				text = text[end+4:]
+				continue
+			}
+
+		case text[0] == '*' || text[0] == '_':
+			if end := strings.IndexByte(text[1:], text[0]); end > 0 {
+				r.open("em")
+				r.inline(text[1 : end+1])
+				r.close("em")
+				text = text[end+2:]
+				continue
+			}
+
+		case text[0] == '[' || strings.HasPrefix(text, "!["):
+			if label, dest, rest, ok := link(text); ok {
+				if text[0] == '!' {
+					r.image(label, dest)
+				} else {
+					r.link(label, dest)
+				}
+				text = rest
+				continue
+			}
+
+		case text[0] == '\n':
+			r.buf.WriteByte('\n')
+			text = text[1:]
+			continue
+		}
+
+		r.buf.WriteString(html.EscapeString(text[:1]))
+		text = text[1:]
+	}
+}
+
+// link parses [label](dest) or ![label](dest) at the start of text.
+func link(text string) (label, dest, rest string, ok bool) {
+	text = strings.TrimPrefix(text, "!")
+	closing := strings.Index(text, "](")
+	if closing < 0 {
+		return "", "", "", false
+	}
+	// destination may contain balanced parentheses
+	depth := 0
+	for i := closing + 2; i < len(text); i++ {
+		switch text[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return text[1:closing], strings.TrimSpace(text[closing+2 : i]), text[i+1:], true
+			}
+			depth--
+		}
+	}
+	return "", "", "", false
+}
+
+func (r *renderer) link(label, dest string) {
+	if !r.policy["a"] || !SafeURL(dest) {
+		r.inline(label)
+		return
+	}
+	r.buf.WriteString(`<a href="` + html.EscapeString(dest) + `" rel="nofollow">`)
+	r.inline(label)
+	r.buf.WriteString("</a>")
+}
+
+func (r *renderer) image(alt, src string) {
+	if !r.policy["img"] || !SafeURL(src) {
+		r.buf.WriteString(html.EscapeString(alt))
+		return
+	}
+	r.buf.WriteString(`<img src="` + html.EscapeString(src) + `" alt="` + html.EscapeString(alt) + `">`)
+}
+
+// SafeURL reports whether URL is relative or has http, https or mailto scheme.
+func SafeURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package markdown
+
+import "testing"
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		policy Policy
+		out    string
+	}{
+		{"heading", "## Title ##", DefaultPolicy, "<h2>Title</h2>\n"},
+		{"paragraph", "one *two*\n**three** `<b>`", DefaultPolicy, "<p>one <em>two</em>\n<strong>three</strong> <code>&lt;b&gt;</code></p>\n"},
+		{"list", "- a\n- b\n\n1. c", DefaultPolicy, "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n<ol>\n<li>c</li>\n</ol>\n"},
+		{"code", "```go\nif a < b {}\n```", DefaultPolicy, "<pre><code>if a &lt; b {}</code></pre>\n"},
+		{"quote", "> quoted", DefaultPolicy, "<blockquote>\n<p>quoted</p>\n</blockquote>\n"},
+		{"link", "[site](https://example.com)", DefaultPolicy, `<p><a href="https://example.com" rel="nofollow">site</a></p>` + "\n"},
+		{"raw html", "<script>alert(1)</script>", DefaultPolicy, "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"unsafe link", "[x](javascript:alert(1))", DefaultPolicy, "<p>x</p>\n"},
+		{"unsafe image", "![x](data:image/png;base64,AAAA)", DefaultPolicy, "<p>x</p>\n"},
+		{"disallowed", "# Title\n\n![alt](/a.png)", NewPolicy("p"), "<p>Title</p>\n<p>alt</p>\n"},
+	}
+	for _, tt := range tests {
+		if out := Render(tt.src, tt.policy); out != tt.out {
+			t.Errorf("%s: unexpected html:\n%q\nwant:\n%q", tt.name, out, tt.out)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Package module is a registry of domain modules.
+//
+// Modules register themselves in init, so adding a module to the service is a blank import in main.go:
+// main parses module options, initializes modules in order of registration, applies their migrations,
+// mounts their routes under API version, runs their jobs and serves their health checks.
+package module
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/pkg/crypto"
+	"github.com/agalitsyn/goapi/pkg/egress"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/redact"
+	"github.com/agalitsyn/goapi/pkg/retention"
+)
+
+// Module is a domain package served by the service.
+type Module interface {
+	// Name is unique name of module, e.g. articles.
+	Name() string
+	// Options returns pointer to flags struct of module, nil if module has none.
+	Options() interface{}
+	// Init is called after options are parsed and database is migrated.
+	Init(env *Env) error
+	Migrations() []*migrate.Migration
+	// Routes are mounted under API version by pattern, e.g. /articles.
+	Routes() map[string]http.Handler
+	// HealthChecks are run on readiness probes, service is not ready while any fails.
+	HealthChecks() map[string]func() error
+	// Jobs run in background until service is stopped.
+	Jobs() []Job
+}
+
+// Job is a background worker, Run blocks until Close is called.
+type Job interface {
+	Run(logger log.Logger)
+	Close() error
+}
+
+// Middleware is implemented by modules which wrap every API request, e.g. to account usage.
+type Middleware interface {
+	Middleware() func(next http.Handler) http.Handler
+}
+
+// Encrypted is implemented by modules which store columns encrypted with Env.Keys,
+// they are re-encrypted with the primary key by rotate-keys command.
+type Encrypted interface {
+	EncryptedColumns() []crypto.Column
+}
+
+// Retention is implemented by modules which keep data for a limited time, names of rules are prefixed
+// with module name, e.g. usage.days, so they can be overridden by --retention flag.
+type Retention interface {
+	RetentionRules() []retention.Rule
+}
+
+// Singleton is implemented by modules with jobs which must not run on several replicas at once,
+// e.g. dispatchers, they run on the elected leader only. Jobs can not run again once closed,
+// so they are made by factories on every election.
+type Singleton interface {
+	SingletonJobs() []func() Job
+}
+
+// Base implements Module except Name, it is embedded into modules which do not need everything.
+type Base struct{}
+
+func (Base) Options() interface{}                  { return nil }
+func (Base) Init(env *Env) error                   { return nil }
+func (Base) Migrations() []*migrate.Migration      { return nil }
+func (Base) Routes() map[string]http.Handler       { return nil }
+func (Base) HealthChecks() map[string]func() error { return nil }
+func (Base) Jobs() []Job                           { return nil }
+
+// Env is shared by modules on Init.
+type Env struct {
+	DB     *sql.DB
+	Logger log.Logger
+	// BaseURL is public URL of service, e.g. to build links in feeds.
+	BaseURL string
+	// Keys encrypt personal data at rest, nil if no keys are configured.
+	Keys *crypto.Keyring
+	// Egress restricts outbound requests to URLs users provide, e.g. webhooks.
+	Egress *egress.Policy
+	// Redactor masks personal data and secrets configured for logs, e.g. in records which keep URLs.
+	Redactor *redact.Redactor
+
+	mu       sync.Mutex
+	services map[string]interface{}
+}
+
+// Provide makes v available to modules initialized later, or to routes of any module.
+func (e *Env) Provide(name string, v interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.services == nil {
+		e.services = make(map[string]interface{})
+	}
+	e.services[name] = v
+}
+
+// Lookup returns service provided by name.
+func (e *Env) Lookup(name string) (interface{}, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	v, ok := e.services[name]
+	return v, ok
+}
+
+// LookupPrefix returns services which names start with prefix, keyed by the rest of name.
+// It lets modules contribute extensions, e.g. article.relation.attachments.
+func (e *Env) LookupPrefix(prefix string) map[string]interface{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	found := make(map[string]interface{})
+	for name, v := range e.services {
+		if strings.HasPrefix(name, prefix) {
+			found[strings.TrimPrefix(name, prefix)] = v
+		}
+	}
+	return found
+}
+
+var (
+	mu       sync.Mutex
+	registry []Module
+)
+
+// Register adds module to the registry, it panics when module with the same name is registered.
+func Register(m Module) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, r := range registry {
+		if r.Name() == m.Name() {
+			panic(fmt.Sprintf("module %q is registered twice", m.Name()))
+		}
+	}
+	registry = append(registry, m)
+}
+
+// All returns registered modules in order of registration.
+func All() []Module {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Module(nil), registry...)
+}
+
+// Migrations returns migrations of all modules, ids are global, so they are applied in order of ids.
+func Migrations(modules []Module) []*migrate.Migration {
+	migrations := []*migrate.Migration{}
+	for _, m := range modules {
+		migrations = append(migrations, m.Migrations()...)
+	}
+	return migrations
+}
+
+// Init initializes modules in order, and stops at the first failure.
+func Init(modules []Module, env *Env) error {
+	for _, m := range modules {
+		if err := m.Init(env); err != nil {
+			return errors.Wrapf(err, "could not init module %s", m.Name())
+		}
+	}
+	return nil
+}
+
+// EncryptedColumns returns encrypted columns of all modules.
+func EncryptedColumns(modules []Module) []crypto.Column {
+	var columns []crypto.Column
+	for _, m := range modules {
+		if e, ok := m.(Encrypted); ok {
+			columns = append(columns, e.EncryptedColumns()...)
+		}
+	}
+	return columns
+}
+
+// RetentionRules returns retention rules of all modules named module.rule.
+func RetentionRules(modules []Module) []retention.Rule {
+	var rules []retention.Rule
+	for _, m := range modules {
+		if r, ok := m.(Retention); ok {
+			for _, rule := range r.RetentionRules() {
+				rule.Name = m.Name() + "." + rule.Name
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
+
+// SingletonJobs returns factories of singleton jobs of all modules.
+func SingletonJobs(modules []Module) []func() Job {
+	var jobs []func() Job
+	for _, m := range modules {
+		if s, ok := m.(Singleton); ok {
+			jobs = append(jobs, s.SingletonJobs()...)
+		}
+	}
+	return jobs
+}
+
+// HealthChecks returns checks of modules keyed by module.check name.
+func HealthChecks(modules []Module) map[string]func() error {
+	checks := make(map[string]func() error)
+	for _, m := range modules {
+		for name, check := range m.HealthChecks() {
+			checks[m.Name()+"."+name] = check
+		}
+	}
+	return checks
+}
@@ -0,0 +1,98 @@
+package module
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/pkg/retention"
+)
+
+type testModule struct {
+	Base
+	name    string
+	initErr error
+	inited  bool
+}
+
+func (m *testModule) Name() string { return m.name }
+
+func (m *testModule) Init(env *Env) error {
+	m.inited = true
+	env.Provide("test.relation."+m.name, m.name)
+	return m.initErr
+}
+
+func (m *testModule) Migrations() []*migrate.Migration {
+	return []*migrate.Migration{{Id: m.name}}
+}
+
+func (m *testModule) HealthChecks() map[string]func() error {
+	return map[string]func() error{"ping": func() error { return nil }}
+}
+
+type retainingModule struct {
+	testModule
+}
+
+func (m *retainingModule) RetentionRules() []retention.Rule {
+	return []retention.Rule{{Name: "events", Table: "event", Column: "created_at", MaxAge: time.Hour}}
+}
+
+func TestRetentionRules(t *testing.T) {
+	rules := RetentionRules([]Module{&testModule{name: "a"}, &retainingModule{testModule{name: "b"}}})
+	if len(rules) != 1 || rules[0].Name != "b.events" || rules[0].Table != "event" {
+		t.Errorf("unexpected rules: %+v", rules)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	defer func(saved []Module) { registry = saved }(registry)
+	registry = nil
+
+	a, b := &testModule{name: "a"}, &testModule{name: "b"}
+	Register(a)
+	Register(b)
+	if all := All(); len(all) != 2 || all[0] != a || all[1] != b {
+		t.Fatalf("unexpected modules: %v", all)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("duplicate module is registered")
+		}
+	}()
+	Register(&testModule{name: "a"})
+}
+
+func TestInit(t *testing.T) {
+	a, b, c := &testModule{name: "a"}, &testModule{name: "b", initErr: errors.New("boom")}, &testModule{name: "c"}
+	modules := []Module{a, b, c}
+	env := &Env{}
+
+	if err := Init(modules, env); err == nil || err.Error() != "could not init module b: boom" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !a.inited || !b.inited || c.inited {
+		t.Error("modules after failed one are initialized")
+	}
+	if provided := env.LookupPrefix("test.relation."); len(provided) != 2 || provided["a"] != "a" {
+		t.Errorf("unexpected services: %v", provided)
+	}
+	if _, ok := env.Lookup("test.relation.c"); ok {
+		t.Error("service of not initialized module is provided")
+	}
+
+	if m := Migrations(modules); len(m) != 3 || m[0].Id != "a" || m[2].Id != "c" {
+		t.Errorf("unexpected migrations: %v", m)
+	}
+	checks := HealthChecks(modules)
+	if _, ok := checks["b.ping"]; !ok || len(checks) != 3 {
+		t.Errorf("unexpected checks: %v", checks)
+	}
+	if routes := a.Routes(); routes != nil {
+		t.Errorf("unexpected routes: %v", routes)
+	}
+}
@@ -0,0 +1,146 @@
+// Package mtls authenticates clients of the HTTPS listener by certificates.
+//
+// Client certificates are verified against a CA bundle and optionally a CRL, and subject of
+// the verified certificate becomes user of the request: common name is user ID,
+// organizational units are roles.
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// Modes of client authentication.
+const (
+	None    = "none"
+	Request = "request"
+	Require = "require"
+)
+
+// Config of the HTTPS listener.
+type Config struct {
+	CertFile string
+	KeyFile  string
+	// ClientAuth is one of None, Request or Require. Requested certificates are verified
+	// when presented, clients without certificate are anonymous.
+	ClientAuth string
+	// ClientCA is a PEM bundle of CAs which issue client certificates.
+	ClientCA string
+	// ClientCRL is a PEM or DER revocation list of client CA, revocation is not checked if empty.
+	ClientCRL string
+}
+
+// TLSConfig builds server TLS config.
+func TLSConfig(cfg Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load server certificate")
+	}
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	switch cfg.ClientAuth {
+	case None, "":
+		return tc, nil
+	case Request:
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	case Require:
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, errors.Errorf("unknown client auth mode %q", cfg.ClientAuth)
+	}
+
+	if cfg.ClientCA == "" {
+		return nil, errors.New("client CA is required to verify client certificates")
+	}
+	caPEM, err := ioutil.ReadFile(cfg.ClientCA)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read client CA")
+	}
+	tc.ClientCAs = x509.NewCertPool()
+	if !tc.ClientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, errors.Errorf("no certificates in client CA %s", cfg.ClientCA)
+	}
+
+	if cfg.ClientCRL != "" {
+		revoked, err := loadCRL(cfg.ClientCRL, caPEM)
+		if err != nil {
+			return nil, err
+		}
+		tc.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+			for _, chain := range chains {
+				if revoked[chain[0].SerialNumber.String()] {
+					return errors.Errorf("client certificate %s is revoked", chain[0].SerialNumber)
+				}
+			}
+			return nil
+		}
+	}
+	return tc, nil
+}
+
+// loadCRL returns serial numbers of revoked certificates, CRL must be signed by one of CAs.
+func loadCRL(path string, caPEM []byte) (map[string]bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read client CRL")
+	}
+	crl, err := x509.ParseCRL(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse client CRL")
+	}
+
+	signed := false
+	for _, ca := range parseCerts(caPEM) {
+		if ca.CheckCRLSignature(crl) == nil {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return nil, errors.Errorf("client CRL %s is not signed by client CA", path)
+	}
+
+	revoked := make(map[string]bool)
+	for _, rc := range crl.TBSCertList.RevokedCertificates {
+		revoked[(*big.Int)(rc.SerialNumber).String()] = true
+	}
+	return revoked, nil
+}
+
+func parseCerts(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if c, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, c)
+		}
+	}
+}
+
+// Middleware sets user of requests with verified client certificate.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		subject := r.TLS.VerifiedChains[0][0].Subject
+		u := &reqctx.User{ID: subject.CommonName, Roles: subject.OrganizationalUnit}
+		next.ServeHTTP(w, r.WithContext(reqctx.WithUser(r.Context(), u)))
+	})
+}
@@ -0,0 +1,155 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+type issued struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func issue(t *testing.T, serial int64, subject pkix.Name, parent *issued) *issued {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &issued{cert: cert, key: key}
+}
+
+func (i *issued) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{i.cert.Raw}, PrivateKey: i.key}
+}
+
+func write(t *testing.T, dir, name, typ string, der []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := issue(t, 1, pkix.Name{CommonName: "test ca"}, nil)
+	server := issue(t, 2, pkix.Name{CommonName: "localhost"}, ca)
+	client := issue(t, 3, pkix.Name{CommonName: "billing", OrganizationalUnit: []string{"admin"}}, ca)
+	revoked := issue(t, 4, pkix.Name{CommonName: "old"}, ca)
+	stranger := issue(t, 5, pkix.Name{CommonName: "stranger"}, issue(t, 6, pkix.Name{CommonName: "other ca"}, nil))
+
+	crl, err := ca.cert.CreateCRL(rand.Reader, ca.key, []pkix.RevokedCertificate{
+		{SerialNumber: revoked.cert.SerialNumber, RevocationTime: time.Now()},
+	}, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(server.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc, err := TLSConfig(Config{
+		CertFile:   write(t, dir, "server.crt", "CERTIFICATE", server.cert.Raw),
+		KeyFile:    write(t, dir, "server.key", "EC PRIVATE KEY", keyDER),
+		ClientAuth: Request,
+		ClientCA:   write(t, dir, "ca.crt", "CERTIFICATE", ca.cert.Raw),
+		ClientCRL:  write(t, dir, "ca.crl", "X509 CRL", crl),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := reqctx.GetUser(r.Context()); u != nil {
+			w.Write([]byte(u.ID + ":" + strings.Join(u.Roles, ",")))
+		}
+	})))
+	srv.TLS = tc
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(c *issued) (string, error) {
+		tr := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}}
+		if c != nil {
+			tr.TLSClientConfig.Certificates = []tls.Certificate{c.tlsCert()}
+		}
+		resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	if body, err := get(client); err != nil || body != "billing:admin" {
+		t.Errorf("unexpected identity: %q %v", body, err)
+	}
+	if body, err := get(nil); err != nil || body != "" {
+		t.Errorf("unexpected anonymous response: %q %v", body, err)
+	}
+	if _, err := get(revoked); err == nil {
+		t.Error("revoked certificate is accepted")
+	}
+	// client does not present certificate which server does not accept
+	if body, err := get(stranger); err != nil || body != "" {
+		t.Errorf("certificate of unknown CA is accepted: %q %v", body, err)
+	}
+}
+
+func TestTLSConfig_Invalid(t *testing.T) {
+	if _, err := TLSConfig(Config{CertFile: "missing.crt", KeyFile: "missing.key"}); err == nil {
+		t.Error("missing certificate is accepted")
+	}
+}
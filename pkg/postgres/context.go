@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+)
+
+// WithContext returns querier which runs queries of q with ctx, so they are cancelled with request.
+// Transactions begun by Tx on it are bound to ctx as well.
+func WithContext(ctx context.Context, q Querier) Querier {
+	if c, ok := q.(*ctxQuerier); ok {
+		q = c.q
+	}
+	cq, ok := q.(contextQuerier)
+	if !ok {
+		return q
+	}
+	return &ctxQuerier{ctx: ctx, q: q, cq: cq}
+}
+
+// contextQuerier is implemented by *sql.DB and *sql.Tx.
+type contextQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type ctxQuerier struct {
+	ctx context.Context
+	q   Querier
+	cq  contextQuerier
+}
+
+func (c *ctxQuerier) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.cq.ExecContext(c.ctx, query, args...)
+}
+
+func (c *ctxQuerier) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.cq.QueryContext(c.ctx, query, args...)
+}
+
+func (c *ctxQuerier) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.cq.QueryRowContext(c.ctx, query, args...)
+}
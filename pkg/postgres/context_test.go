@@ -0,0 +1,43 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestWithContext(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	q := WithContext(ctx, db)
+
+	mock.ExpectExec("UPDATE article").WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 1))
+	if _, err := q.Exec("UPDATE article SET title = $1", "slow"); err != sqlmock.ErrCancelled {
+		t.Errorf("query is not cancelled: %v", err)
+	}
+
+	// transaction of bound querier can be bound to a shorter context
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE article").WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+	err = Tx(WithContext(context.Background(), db), false, func(tx Querier) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := WithContext(ctx, tx).Exec("UPDATE article SET title = $1", "slow")
+		return err
+	})
+	if err != sqlmock.ErrCancelled {
+		t.Errorf("query in transaction is not cancelled: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"database/sql/driver"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Drivers connections are opened with, lib/pq is the default.
+const (
+	DriverPQ  = "pq"
+	DriverPGX = "pgx"
+)
+
+// ErrorCode returns SQLSTATE of server error of either driver, e.g. 23505 of unique violation,
+// or empty string for other errors.
+func ErrorCode(err error) string {
+	switch e := errors.Cause(err).(type) {
+	case *pq.Error:
+		return string(e.Code)
+	case *pgconn.PgError:
+		return e.Code
+	}
+	return ""
+}
+
+func driverOf(name string) (driver.Driver, error) {
+	switch name {
+	case "", DriverPQ:
+		return &pq.Driver{}, nil
+	case DriverPGX:
+		return stdlib.GetDefaultDriver(), nil
+	}
+	return nil, errors.Errorf("unsupported database driver %q, expected %s or %s", name, DriverPQ, DriverPGX)
+}
+
+// pgxDSN sets size of statement cache of pgx, which caches 512 statements per connection unless
+// DSN sets statement_cache_capacity.
+func pgxDSN(dsn string, statementCache int) string {
+	if strings.Contains(dsn, "statement_cache_capacity") {
+		return dsn
+	}
+	size := strconv.Itoa(statementCache)
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		return dsn + " statement_cache_capacity=" + size
+	}
+	query := u.Query()
+	query.Set("statement_cache_capacity", size)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+func TestNewConnector_Driver(t *testing.T) {
+	c, err := NewConnector("postgres://db/app?statement_cache_size=8", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Driver().(*pq.Driver); !ok || c.statementCache != 8 {
+		t.Errorf("unexpected connector %+v", c)
+	}
+
+	tests := []struct {
+		dsn  string
+		cfg  Config
+		dsns []string
+	}{
+		{
+			dsn:  "postgres://db1,db2/app?driver=pgx&statement_cache_size=8",
+			dsns: []string{"postgres://db1/app?statement_cache_capacity=8", "postgres://db2/app?statement_cache_capacity=8"},
+		},
+		{
+			dsn:  "postgres://db/app?statement_cache_capacity=64",
+			cfg:  Config{Driver: DriverPGX, StatementCache: 8},
+			dsns: []string{"postgres://db/app?statement_cache_capacity=64"},
+		},
+		{
+			dsn:  "host=db user=u",
+			cfg:  Config{Driver: DriverPGX},
+			dsns: []string{"host=db user=u statement_cache_capacity=0"},
+		},
+	}
+	for _, tt := range tests {
+		c, err := NewConnector(tt.dsn, tt.cfg)
+		if err != nil {
+			t.Errorf("%s: %v", tt.dsn, err)
+			continue
+		}
+		// pgx caches statements itself
+		if c.Driver() != stdlib.GetDefaultDriver() || c.statementCache != 0 || !reflect.DeepEqual(c.dsns, tt.dsns) {
+			t.Errorf("%s: unexpected connector %+v", tt.dsn, c)
+		}
+	}
+
+	if _, err := NewConnector("postgres://db/app?driver=mysql", Config{}); err == nil {
+		t.Error("expected error of unsupported driver")
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{&pq.Error{Code: "23505"}, "23505"},
+		{errors.Wrap(&pgconn.PgError{Code: "23503"}, "could not save"), "23503"},
+		{errors.New("connection refused"), ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if code := ErrorCode(tt.err); code != tt.code {
+			t.Errorf("%v: unexpected code %q", tt.err, code)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		dsn       string
+		dsns      []string
+		readWrite bool
+		// statementCache is -1 when DSN does not set it
+		statementCache int
+		driver         string
+		err            bool
+	}{
+		{
+			dsn:            "postgres://u:p@db:5432/app?sslmode=disable",
+			dsns:           []string{"postgres://u:p@db:5432/app?sslmode=disable"},
+			statementCache: -1,
+		},
+		{
+			dsn:            "postgres://u:p@db1:5432,db2:5433/app?sslmode=disable&target_session_attrs=read-write",
+			dsns:           []string{"postgres://u:p@db1:5432/app?sslmode=disable", "postgres://u:p@db2:5433/app?sslmode=disable"},
+			readWrite:      true,
+			statementCache: -1,
+		},
+		{
+			dsn:            "postgresql://db1,db2?target_session_attrs=any&statement_cache_size=64",
+			dsns:           []string{"postgresql://db1", "postgresql://db2"},
+			statementCache: 64,
+		},
+		{
+			dsn:            "postgres://db1,db2/app?driver=pgx",
+			dsns:           []string{"postgres://db1/app", "postgres://db2/app"},
+			statementCache: -1,
+			driver:         "pgx",
+		},
+		{
+			dsn:            "host=db user=u sslmode=disable",
+			dsns:           []string{"host=db user=u sslmode=disable"},
+			statementCache: -1,
+		},
+		{dsn: "postgres://db/app?target_session_attrs=read-only", err: true},
+		{dsn: "postgres://db/app?statement_cache_size=many", err: true},
+	}
+	for _, tt := range tests {
+		opts, err := parseDSN(tt.dsn)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.dsn)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.dsn, err)
+			continue
+		}
+		if !reflect.DeepEqual(opts.dsns, tt.dsns) || opts.readWrite != tt.readWrite || opts.statementCache != tt.statementCache || opts.driver != tt.driver {
+			t.Errorf("%s: unexpected %+v", tt.dsn, opts)
+		}
+	}
+}
+
+type fakeConn struct {
+	readOnly string
+	closed   bool
+	prepared []string
+	// err is returned by statements
+	err error
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.prepared = append(c.prepared, query)
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error              { c.closed = true; return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (c *fakeConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	if query == "SELECT pg_backend_pid()" {
+		return &fakeRows{value: int64(42)}, nil
+	}
+	return &fakeRows{value: []byte(c.readOnly)}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+type fakeStmt struct {
+	conn   *fakeConn
+	query  string
+	closed bool
+}
+
+func (s *fakeStmt) Close() error  { s.closed = true; return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if s.conn.err != nil {
+		return nil, s.conn.err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.conn.err != nil {
+		return nil, s.conn.err
+	}
+	return &fakeRows{value: args[0]}, nil
+}
+
+type fakeRows struct {
+	value driver.Value
+	read  bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"value"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.read {
+		return io.EOF
+	}
+	r.read = true
+	dest[0] = r.value
+	return nil
+}
+
+func TestConnector(t *testing.T) {
+	conns := map[string]*fakeConn{
+		"postgres://replica/app": {readOnly: "on"},
+		"postgres://primary/app": {readOnly: "off"},
+	}
+	c, err := NewConnector("postgres://down,replica,primary/app?target_session_attrs=read-write", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.open = func(dsn string) (driver.Conn, error) {
+		cn, ok := conns[dsn]
+		if !ok {
+			return nil, errors.New("connection refused")
+		}
+		return cn, nil
+	}
+
+	cn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn.(*conn).Conn != conns["postgres://primary/app"] || !conns["postgres://replica/app"].closed {
+		t.Errorf("unexpected connection %+v", cn)
+	}
+
+	ctx := context.Background()
+	if _, err := cn.(driver.ExecerContext).ExecContext(ctx, "SELECT 1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cn.(driver.QueryerContext).QueryContext(ctx, "SELECT 1", nil); err != driver.ErrSkip {
+		t.Errorf("unexpected error of unsupported method: %v", err)
+	}
+
+	c.Recycle()
+	if err := cn.(driver.SessionResetter).ResetSession(ctx); err != driver.ErrBadConn {
+		t.Errorf("recycled connection is reset: %v", err)
+	}
+	if _, err := cn.(driver.ExecerContext).ExecContext(ctx, "SELECT 1", nil); err != driver.ErrBadConn {
+		t.Errorf("recycled connection is used: %v", err)
+	}
+
+	cn, err = c.Connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cn.(driver.SessionResetter).ResetSession(ctx); err != nil {
+		t.Errorf("new connection is not reset: %v", err)
+	}
+
+	conns["postgres://primary/app"].readOnly = "on"
+	if _, err := c.Connect(ctx); err == nil {
+		t.Error("connected to read-only server")
+	}
+}
+
+// Probe must reach the server, ping of lib/pq connection does not, so idle connection to a server
+// which is gone passes it.
+func TestProber_Probe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		cn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		// startup message is followed by authentication ok and ready for query
+		var size int32
+		binary.Read(cn, binary.BigEndian, &size)
+		io.CopyN(ioutil.Discard, cn, int64(size)-4)
+		cn.Write([]byte{'R', 0, 0, 0, 8, 0, 0, 0, 0, 'Z', 0, 0, 0, 5, 'I'})
+		accepted <- cn
+	}()
+
+	db, err := sql.Open("postgres", "postgres://app@"+l.Addr().String()+"/app?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// connection is left idle in the pool
+	cn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn.Close()
+
+	l.Close()
+	(<-accepted).Close()
+	p := &Prober{db: db, connector: &Connector{}, interval: time.Second}
+	if err := p.Probe(); err == nil {
+		t.Error("probe of closed server succeeded")
+	}
+}
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"database/sql"
+	"expvar"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// metrics are exposed with expvar as postgres.pool, stats of the last pool created.
+var metrics = expvar.NewMap("postgres")
+
+// Errors of invalid limits.
+var (
+	ErrNegativeLimits  = errors.New("limits must not be negative")
+	ErrIdleExceedsOpen = errors.New("max idle connections must not exceed max open ones")
+)
+
+// Limits are sizes of connection pool, zero MaxOpenConns means unlimited.
+type Limits struct {
+	MaxOpenConns int `json:"max_open_conns"`
+	MaxIdleConns int `json:"max_idle_conns"`
+}
+
+// Stats tell how saturated the pool is, wait counters are cumulative,
+// so rate of waits and acquire latency are derived by scraper.
+type Stats struct {
+	Limits
+	Open         int   `json:"open"`
+	InUse        int   `json:"in_use"`
+	Idle         int   `json:"idle"`
+	WaitCount    int64 `json:"wait_count"`
+	WaitDuration int64 `json:"wait_duration_ms"`
+}
+
+// Pool changes limits of database at runtime, sql.DB does not tell them back.
+type Pool struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	limits Limits
+}
+
+// NewPool applies limits to db and publishes its stats.
+func NewPool(db *sql.DB, limits Limits) *Pool {
+	p := &Pool{db: db}
+	p.apply(limits)
+	metrics.Set("pool", expvar.Func(func() interface{} { return p.Stats() }))
+	return p
+}
+
+// Limits returns current limits.
+func (p *Pool) Limits() Limits {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.limits
+}
+
+// SetLimits changes limits, connections above them are closed once released.
+func (p *Pool) SetLimits(l Limits) error {
+	if l.MaxOpenConns < 0 || l.MaxIdleConns < 0 {
+		return ErrNegativeLimits
+	}
+	if l.MaxOpenConns > 0 && l.MaxIdleConns > l.MaxOpenConns {
+		return ErrIdleExceedsOpen
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.apply(l)
+	return nil
+}
+
+func (p *Pool) apply(l Limits) {
+	p.limits = l
+	p.db.SetMaxOpenConns(l.MaxOpenConns)
+	p.db.SetMaxIdleConns(l.MaxIdleConns)
+}
+
+// Stats returns current limits and usage.
+func (p *Pool) Stats() Stats {
+	s := Stats{Limits: p.Limits()}
+	p.dbStats(&s)
+	return s
+}
+
+// closeIdle closes idle connections keeping limits.
+func (p *Pool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.db.SetMaxIdleConns(0)
+	p.db.SetMaxIdleConns(p.limits.MaxIdleConns)
+}
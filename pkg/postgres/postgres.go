@@ -1,43 +1,127 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
-	_ "github.com/lib/pq"
-
 	"github.com/pkg/errors"
 	migrate "github.com/rubenv/sql-migrate"
 
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/dlock"
 	"github.com/agalitsyn/goapi/pkg/log"
 )
 
+// Querier is implemented by *sql.DB and *sql.Tx, so managers can work either way.
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Tx runs fn in transaction, which is rolled back when fn fails or rollback is set, e.g. for dry runs.
+// When q is a transaction already, fn runs in it and the outer caller decides.
+// When q is bound to context with WithContext, so is transaction.
+func Tx(q Querier, rollback bool, fn func(tx Querier) error) error {
+	ctx, inner := context.Background(), q
+	if c, ok := q.(*ctxQuerier); ok {
+		ctx, inner = c.ctx, c.q
+	}
+	db, ok := inner.(interface {
+		BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	})
+	if !ok {
+		return fn(q)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "could not begin transaction")
+	}
+	var txq Querier = tx
+	if inner != q {
+		txq = WithContext(ctx, tx)
+	}
+	if err := fn(txq); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if rollback {
+		if err := tx.Rollback(); err != nil {
+			return errors.Wrap(err, "could not rollback transaction")
+		}
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "could not commit transaction")
+	}
+	return nil
+}
+
 type Database struct {
 	DB     *sql.DB
 	Logger log.Logger
+	// Pool changes limits of DB at runtime.
+	Pool *Pool
+
+	connector *Connector
+	// locks is a pool of sessions holding distributed locks, see Locker.
+	locks *sql.DB
 }
 
 type Config struct {
 	MaxConnLifetime time.Duration
 	MaxIdleConns    int
 	MaxOpenConns    int
+	// StatementCache is how many prepared statements each connection keeps, unless DSN sets it.
+	StatementCache int
+	// Driver is DriverPQ or DriverPGX, unless DSN sets it.
+	Driver string
 }
 
 func New(dsn string, logger log.Logger, cfg Config) (*Database, error) {
-	db, err := sql.Open("postgres", dsn)
+	connector, err := NewConnector(dsn, cfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not open database")
 	}
+	db := sql.OpenDB(connector)
 	db.SetConnMaxLifetime(cfg.MaxConnLifetime)
-	db.SetMaxIdleConns(cfg.MaxIdleConns)
-	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	pool := NewPool(db, Limits{MaxOpenConns: cfg.MaxOpenConns, MaxIdleConns: cfg.MaxIdleConns})
+
+	// sessions of locks are not reused, so a lock is never left behind on an idle connection
+	locks := sql.OpenDB(connector)
+	locks.SetMaxIdleConns(0)
 
 	return &Database{
-		DB:     db,
-		Logger: logger,
+		DB:        db,
+		Logger:    logger,
+		Pool:      pool,
+		connector: connector,
+		locks:     locks,
 	}, nil
 }
 
+// Locker returns distributed locks held by sessions apart from DB pool, so work under lock does not
+// compete with the lock for connections.
+func (d *Database) Locker(cfg dlock.Config) *dlock.Locker {
+	return dlock.New(d.locks, cfg)
+}
+
+// Prober returns job which recycles connections after failures probes in a row fail, see Prober.
+func (d *Database) Prober(interval time.Duration, failures int) *Prober {
+	return &Prober{
+		db:        d.DB,
+		connector: d.connector,
+		pool:      d.Pool,
+		interval:  interval,
+		failures:  failures,
+		clock:     clock.Real,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
 func (d *Database) Connect() error {
 	var err error
 	maxAttempts := 10
@@ -57,13 +141,21 @@ func (d *Database) Connect() error {
 }
 
 func (d *Database) Close() error {
+	d.locks.Close()
 	if err := d.DB.Close(); err != nil {
 		return errors.Wrap(err, "could not close database")
 	}
 	return nil
 }
 
+// Migrate applies migrations, replicas starting together take turns, so each migration runs once.
 func (d *Database) Migrate(migrations *migrate.MemoryMigrationSource) error {
+	lock, err := d.Locker(dlock.Config{}).Lock(context.Background(), "migrations")
+	if err != nil {
+		return errors.Wrap(err, "could not lock database migrations")
+	}
+	defer lock.Unlock()
+
 	migrate.SetTable("migrations")
 	done, err := migrate.Exec(d.DB, "postgres", migrations, migrate.Up)
 	if err != nil {
@@ -0,0 +1,15 @@
+//go:build go1.11
+// +build go1.11
+
+package postgres
+
+import "time"
+
+func (p *Pool) dbStats(s *Stats) {
+	st := p.db.Stats()
+	s.Open = st.OpenConnections
+	s.InUse = st.InUse
+	s.Idle = st.Idle
+	s.WaitCount = st.WaitCount
+	s.WaitDuration = int64(st.WaitDuration / time.Millisecond)
+}
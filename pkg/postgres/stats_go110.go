@@ -0,0 +1,9 @@
+//go:build !go1.11
+// +build !go1.11
+
+package postgres
+
+// dbStats fills open connections only, Go 1.10 does not count usage and waits.
+func (p *Pool) dbStats(s *Stats) {
+	s.Open = p.db.Stats().OpenConnections
+}
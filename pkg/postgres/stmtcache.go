@@ -0,0 +1,131 @@
+package postgres
+
+import (
+	"container/list"
+	"context"
+	"database/sql/driver"
+)
+
+// stmtCache keeps statements prepared on connection by query, so a query with arguments is parsed
+// and planned by the server once per connection instead of on every call.
+// The least recently used statement is closed when cache is full.
+type stmtCache struct {
+	size  int
+	order *list.List
+	stmts map[string]*list.Element
+}
+
+type cachedStmt struct {
+	query string
+	stmt  driver.Stmt
+}
+
+func newStmtCache(size int) *stmtCache {
+	return &stmtCache{size: size, order: list.New(), stmts: make(map[string]*list.Element)}
+}
+
+func (c *stmtCache) get(cn driver.Conn, query string) (driver.Stmt, error) {
+	if e, ok := c.stmts[query]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*cachedStmt).stmt, nil
+	}
+	stmt, err := cn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[query] = c.order.PushFront(&cachedStmt{query: query, stmt: stmt})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return stmt, nil
+}
+
+// evict drops statement which server no longer accepts, e.g. after schema changed its result type.
+func (c *stmtCache) evict(query string, err error) {
+	e, ok := c.stmts[query]
+	if !ok {
+		return
+	}
+	if code := ErrorCode(err); code == "0A000" || code == "26000" {
+		c.remove(e)
+	}
+}
+
+func (c *stmtCache) remove(e *list.Element) {
+	cs := c.order.Remove(e).(*cachedStmt)
+	delete(c.stmts, cs.query)
+	cs.stmt.Close()
+}
+
+func (cn *conn) queryCached(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	stmt, err := cn.stmts.get(cn.Conn, query)
+	if err != nil {
+		return nil, err
+	}
+	finish := cn.watchCancel(ctx)
+	rows, err := stmt.Query(values(args))
+	if err != nil {
+		finish()
+		cn.stmts.evict(query, err)
+		return nil, err
+	}
+	return &finishRows{Rows: rows, finish: finish}, nil
+}
+
+func (cn *conn) execCached(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	stmt, err := cn.stmts.get(cn.Conn, query)
+	if err != nil {
+		return nil, err
+	}
+	finish := cn.watchCancel(ctx)
+	defer finish()
+	res, err := stmt.Exec(values(args))
+	if err != nil {
+		cn.stmts.evict(query, err)
+	}
+	return res, err
+}
+
+// watchCancel cancels query running on connection when ctx is done, like lib/pq does for
+// queries it runs itself. The returned func must be called once query is finished.
+func (cn *conn) watchCancel(ctx context.Context) func() {
+	done := ctx.Done()
+	if done == nil {
+		return func() {}
+	}
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			cn.connector.cancel(cn.dsn, cn.pid)
+			finished <- struct{}{}
+		case <-finished:
+		}
+	}()
+	return func() {
+		select {
+		case <-finished:
+		case finished <- struct{}{}:
+		}
+	}
+}
+
+// finishRows stops watching context when rows are closed.
+type finishRows struct {
+	driver.Rows
+	finish func()
+}
+
+func (r *finishRows) Close() error {
+	err := r.Rows.Close()
+	r.finish()
+	return err
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	list := make([]driver.Value, len(args))
+	for i, nv := range args {
+		list[i] = nv.Value
+	}
+	return list
+}
@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestStmtCache(t *testing.T) {
+	fake := &fakeConn{readOnly: "off"}
+	c, err := NewConnector("postgres://db/app?statement_cache_size=2", Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.open = func(dsn string) (driver.Conn, error) { return fake, nil }
+
+	cn, err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn.(*conn).pid != int64(42) {
+		t.Errorf("unexpected backend pid: %v", cn.(*conn).pid)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := cn.(driver.QueryerContext)
+	args := []driver.NamedValue{{Ordinal: 1, Value: int64(1)}}
+	for _, query := range []string{"SELECT $1", "SELECT $1", "SELECT $1 + 1", "SELECT $1", "SELECT $1 + 2"} {
+		rows, err := q.QueryContext(ctx, query, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+	// queries without arguments are passed to the driver
+	if _, err := q.QueryContext(ctx, "SELECT 1", nil); err != driver.ErrSkip {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// SELECT $1 + 1 is the least recently used when cache is full
+	want := []string{"SELECT $1", "SELECT $1 + 1", "SELECT $1 + 2"}
+	if !reflect.DeepEqual(fake.prepared, want) {
+		t.Errorf("unexpected prepared statements: %v", fake.prepared)
+	}
+	stmts := cn.(*conn).stmts
+	if _, ok := stmts.stmts["SELECT $1 + 1"]; ok || stmts.order.Len() != 2 {
+		t.Errorf("statement is not evicted: %v", stmts.stmts)
+	}
+
+	fake.err = &pq.Error{Code: "23505"}
+	if _, err := cn.(driver.ExecerContext).ExecContext(ctx, "SELECT $1", args); err != fake.err {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, ok := stmts.stmts["SELECT $1"]; !ok {
+		t.Error("statement is evicted on query error")
+	}
+	fake.err = &pq.Error{Code: "0A000", Message: "cached plan must not change result type"}
+	if _, err := cn.(driver.ExecerContext).ExecContext(ctx, "SELECT $1", args); err != fake.err {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, ok := stmts.stmts["SELECT $1"]; ok {
+		t.Error("statement with changed plan is not evicted")
+	}
+}
@@ -0,0 +1,272 @@
+// Package ratelimit limits requests per client with fixed windows.
+//
+// New limits can be rolled out in warn mode first: exceeding requests are served
+// with Warning header and counted in metrics, and are rejected only after the enforcement date.
+package ratelimit
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/redis"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// metrics are exposed with expvar as ratelimit.<prefix>.{allowed,warned,rejected} and ratelimit.store_errors.
+var metrics = expvar.NewMap("ratelimit")
+
+// Rule limits requests which path starts with Prefix to Limit per Window for every client.
+type Rule struct {
+	Prefix string
+	Limit  int
+	Window time.Duration
+	// EnforceFrom is a time exceeding requests are rejected from, they are only warned about before it.
+	// Zero time enforces immediately, Never only warns.
+	EnforceFrom time.Time
+}
+
+// Never is EnforceFrom of rules which are never enforced.
+var Never = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ParseRule parses rule in form prefix:limit/window[:enforce], e.g. /1.0/articles:100/1m:2018-06-01,
+// where enforce is "now" (default), "never" or a date to enforce from.
+func ParseRule(s string) (Rule, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return Rule{}, errors.Errorf("invalid rate limit rule %q, expected prefix:limit/window[:enforce]", s)
+	}
+
+	lw := strings.SplitN(parts[1], "/", 2)
+	if len(lw) != 2 {
+		return Rule{}, errors.Errorf("invalid rate limit rule %q, expected limit/window", s)
+	}
+	limit, err := strconv.Atoi(lw[0])
+	if err != nil || limit <= 0 {
+		return Rule{}, errors.Errorf("invalid rate limit rule %q, limit must be positive integer", s)
+	}
+	window, err := time.ParseDuration(lw[1])
+	if err != nil || window <= 0 {
+		return Rule{}, errors.Errorf("invalid rate limit rule %q, window must be positive duration", s)
+	}
+
+	rule := Rule{Prefix: parts[0], Limit: limit, Window: window}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "now":
+		case "never":
+			rule.EnforceFrom = Never
+		default:
+			rule.EnforceFrom, err = time.Parse("2006-01-02", parts[2])
+			if err != nil {
+				return Rule{}, errors.Errorf("invalid rate limit rule %q, enforce must be now, never or YYYY-MM-DD", s)
+			}
+		}
+	}
+	return rule, nil
+}
+
+// Store counts requests in windows of clients.
+type Store interface {
+	// Incr counts request in window of key which started at start and returns number of requests in it.
+	Incr(key string, start time.Time, window time.Duration) (int, error)
+}
+
+// Limiter counts requests of clients in fixed windows.
+type Limiter struct {
+	rules []Rule
+	store Store
+	clock clock.Clock
+}
+
+// New returns limiter counting requests in store, in memory of instance if store is nil.
+func New(rules []Rule, store Store) *Limiter {
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	return &Limiter{rules: rules, store: store, clock: clock.Real}
+}
+
+// Result describes the state of client window after request is counted.
+type Result struct {
+	Rule      *Rule
+	Remaining int
+	Reset     time.Time
+	Exceeded  bool
+	Enforced  bool
+}
+
+// Allow counts request of client to path, nil is returned when no rule matches or store fails.
+func (l *Limiter) Allow(path, client string) *Result {
+	rule := l.rule(path)
+	if rule == nil {
+		return nil
+	}
+
+	now := l.clock.Now()
+	start := now.Truncate(rule.Window)
+	count, err := l.store.Incr(rule.Prefix+"\x00"+client, start, rule.Window)
+	if err != nil {
+		// limits are not worth failing requests while store is unavailable
+		metrics.Add("store_errors", 1)
+		return nil
+	}
+
+	res := &Result{Rule: rule, Reset: start.Add(rule.Window)}
+	if count > rule.Limit {
+		res.Exceeded = true
+		res.Enforced = !now.Before(rule.EnforceFrom)
+	} else {
+		res.Remaining = rule.Limit - count
+	}
+	return res
+}
+
+// rule returns the most specific rule for path.
+func (l *Limiter) rule(path string) *Rule {
+	var found *Rule
+	for i := range l.rules {
+		r := &l.rules[i]
+		if strings.HasPrefix(path, r.Prefix) && (found == nil || len(r.Prefix) > len(found.Prefix)) {
+			found = r
+		}
+	}
+	return found
+}
+
+type window struct {
+	start time.Time
+	end   time.Time
+	count int
+}
+
+// MemoryStore keeps windows in memory, so every replica of service limits clients on its own.
+type MemoryStore struct {
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{windows: make(map[string]*window)}
+}
+
+func (s *MemoryStore) Incr(key string, start time.Time, d time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(start)
+	w, ok := s.windows[key]
+	if !ok || !w.start.Equal(start) {
+		w = &window{start: start, end: start.Add(d)}
+		s.windows[key] = w
+	}
+	w.count++
+	return w.count, nil
+}
+
+// sweep drops windows which ended before now, at most once a minute. It must be called with mu held.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, w := range s.windows {
+		if !w.end.After(now) {
+			delete(s.windows, key)
+		}
+	}
+}
+
+// incr counts request and makes counter expire with its window.
+const incr = `local n = redis.call('INCR', KEYS[1])
+if n == 1 then redis.call('PEXPIREAT', KEYS[1], ARGV[1]) end
+return n`
+
+// RedisStore keeps windows in Redis, so limits hold for all replicas of service together.
+type RedisStore struct {
+	c *redis.Client
+}
+
+func NewRedisStore(c *redis.Client) *RedisStore {
+	return &RedisStore{c: c}
+}
+
+func (s *RedisStore) Incr(key string, start time.Time, d time.Duration) (int, error) {
+	key = "ratelimit:" + key + ":" + strconv.FormatInt(start.Unix(), 10)
+	end := start.Add(d).UnixNano() / int64(time.Millisecond)
+	n, err := redis.Int(s.c.Do(context.Background(), "EVAL", incr, 1, key, end))
+	if err != nil {
+		return 0, errors.Wrap(err, "could not count request")
+	}
+	return int(n), nil
+}
+
+// Middleware sets RateLimit-* headers and rejects requests over enforced limits with 429.
+// Clients are identified by user, or by remote address for anonymous requests.
+func Middleware(l *Limiter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res := l.Allow(r.URL.Path, client(r))
+			if res == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Rule.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(int(res.Reset.Sub(l.clock.Now()).Seconds()+0.5)))
+
+			switch {
+			case !res.Exceeded:
+				metrics.Add(res.Rule.Prefix+".allowed", 1)
+			case !res.Enforced:
+				metrics.Add(res.Rule.Prefix+".warned", 1)
+				h.Add("Warning", `199 - "`+warning(res.Rule)+`"`)
+			default:
+				metrics.Add(res.Rule.Prefix+".rejected", 1)
+				retryAfter := strconv.Itoa(int(res.Reset.Sub(l.clock.Now()).Seconds() + 0.5))
+				h.Set("Retry-After", retryAfter)
+				locale := reqctx.GetLocale(r.Context())
+				handler.WriteProblem(w, &handler.Problem{
+					Title:    i18n.StatusText(locale, http.StatusTooManyRequests),
+					Status:   http.StatusTooManyRequests,
+					Detail:   i18n.Translate(locale, "ratelimit.exceeded", res.Rule.Limit, res.Rule.Window, retryAfter),
+					Instance: r.URL.Path,
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// warning is in English, as Warning header is meant for developers rather than end users.
+func warning(rule *Rule) string {
+	msg := "rate limit of " + strconv.Itoa(rule.Limit) + " requests per " + rule.Window.String() + " exceeded"
+	if rule.EnforceFrom != Never {
+		msg += ", it is enforced from " + rule.EnforceFrom.Format("2006-01-02")
+	}
+	return msg
+}
+
+func client(r *http.Request) string {
+	if u := reqctx.GetUser(r.Context()); u != nil {
+		return "user:" + u.ID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
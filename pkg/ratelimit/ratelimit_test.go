@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in    string
+		rule  Rule
+		valid bool
+	}{
+		{"/1.0:100/1m", Rule{Prefix: "/1.0", Limit: 100, Window: time.Minute}, true},
+		{"/1.0:100/1m:never", Rule{Prefix: "/1.0", Limit: 100, Window: time.Minute, EnforceFrom: Never}, true},
+		{"/1.0:100/1m:2018-06-01", Rule{Prefix: "/1.0", Limit: 100, Window: time.Minute, EnforceFrom: time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)}, true},
+		{"/1.0:100", Rule{}, false},
+		{"/1.0:0/1m", Rule{}, false},
+		{"/1.0:100/1m:tomorrow", Rule{}, false},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRule(tt.in)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: unexpected error: %v", tt.in, err)
+			continue
+		}
+		if rule != tt.rule {
+			t.Errorf("%q: unexpected rule: %+v", tt.in, rule)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New([]Rule{
+		{Prefix: "/warn", Limit: 1, Window: time.Minute, EnforceFrom: now.AddDate(0, 1, 0)},
+		{Prefix: "/enforce", Limit: 1, Window: time.Minute},
+	}, nil)
+	clk := clock.NewFake(now)
+	l.clock = clk
+
+	h := Middleware(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		path    string
+		status  int
+		warning bool
+	}{
+		{"/warn", http.StatusOK, false},
+		{"/warn", http.StatusOK, true},
+		{"/enforce", http.StatusOK, false},
+		{"/enforce", http.StatusTooManyRequests, false},
+		{"/other", http.StatusOK, false},
+	}
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if w.Code != tt.status {
+			t.Errorf("%d %s: unexpected status: %v", i, tt.path, w.Code)
+		}
+		if warning := w.Header().Get("Warning") != ""; warning != tt.warning {
+			t.Errorf("%d %s: unexpected warning: %q", i, tt.path, w.Header().Get("Warning"))
+		}
+	}
+
+	// next window starts over
+	clk.Add(time.Minute)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/enforce", nil))
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("unexpected response in next window: %v %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,89 @@
+// Package realip sets request RemoteAddr to the client address forwarded by trusted proxies.
+//
+// Unlike chi middleware.RealIP, X-Forwarded-For and X-Real-IP are honored only when the request
+// comes from a trusted proxy, otherwise clients could spoof addresses which rate limits,
+// network rules and logs rely on.
+package realip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/agalitsyn/goapi/pkg/ipfilter"
+)
+
+// ParseProxies parses trusted proxy networks in CIDR form or single addresses.
+func ParseProxies(specs []string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, s := range specs {
+		n, err := ipfilter.ParseNetwork(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, n)
+	}
+	return proxies, nil
+}
+
+// Middleware replaces RemoteAddr of requests from trusted proxies with the client address.
+// X-Forwarded-For is walked from the right, the first address which is not a trusted proxy is the client,
+// so entries prepended by clients are ignored.
+func Middleware(trusted []*net.IPNet) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := clientIP(r, trusted); ip != "" {
+				r.RemoteAddr = ip
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	if !isTrusted(peerIP(r.RemoteAddr), trusted) {
+		return ""
+	}
+
+	var hops []string
+	for _, h := range r.Header["X-Forwarded-For"] {
+		for _, hop := range strings.Split(h, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			// garbage can only come from the client, addresses left of it are not trustworthy either
+			return ""
+		}
+		if !isTrusted(ip, trusted) || i == 0 {
+			return ip.String()
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+func peerIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package realip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	trusted, err := ParseProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	h := Middleware(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.RemoteAddr
+	}))
+
+	tests := []struct {
+		name   string
+		peer   string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{"direct client", "203.0.113.7:1234", nil, "", "203.0.113.7:1234"},
+		{"spoofed by direct client", "203.0.113.7:1234", []string{"1.2.3.4"}, "1.2.3.4", "203.0.113.7:1234"},
+		{"single proxy", "10.0.0.2:1234", []string{"203.0.113.7"}, "", "203.0.113.7"},
+		{"proxy chain", "10.0.0.2:1234", []string{"203.0.113.7, 192.168.1.1"}, "", "203.0.113.7"},
+		{"several headers", "10.0.0.2:1234", []string{"203.0.113.7", "10.0.0.3"}, "", "203.0.113.7"},
+		{"spoofed through proxy", "10.0.0.2:1234", []string{"1.2.3.4, 203.0.113.7"}, "", "203.0.113.7"},
+		{"only proxies", "10.0.0.2:1234", []string{"10.0.0.5, 10.0.0.3"}, "", "10.0.0.5"},
+		{"garbage", "10.0.0.2:1234", []string{"203.0.113.7, unknown"}, "", "10.0.0.2:1234"},
+		{"real ip", "10.0.0.2:1234", nil, "203.0.113.7", "203.0.113.7"},
+		{"ipv6", "10.0.0.2:1234", []string{"2001:db8::1"}, "", "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.peer
+		for _, v := range tt.xff {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		if tt.realIP != "" {
+			req.Header.Set("X-Real-IP", tt.realIP)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if got != tt.want {
+			t.Errorf("%s: unexpected address: %v", tt.name, got)
+		}
+	}
+
+	if _, err := ParseProxies([]string{"proxy.local"}); err == nil {
+		t.Error("invalid proxy is accepted")
+	}
+}
@@ -0,0 +1,141 @@
+// Package redact masks personal data and secrets, e.g. passwords, emails and tokens,
+// in structured values before they are written to logs or audit records.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces redacted values.
+const Mask = "[Filtered]"
+
+// urlPattern matches URLs in text without trailing punctuation.
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]*[^\s"'<>.,:;)]`)
+
+// Redactor masks values of fields which names contain any of configured names, case-insensitive,
+// so token also masks access_token and X-Auth-Token.
+type Redactor struct {
+	fields []string
+	// pairs matches key=value and "key": "value" pairs of sensitive keys in text, values do not end
+	// with punctuation
+	pairs *regexp.Regexp
+}
+
+func New(fields ...string) *Redactor {
+	r := &Redactor{}
+	var quoted []string
+	for _, f := range fields {
+		if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+			r.fields = append(r.fields, f)
+			quoted = append(quoted, regexp.QuoteMeta(f))
+		}
+	}
+	if len(quoted) > 0 {
+		r.pairs = regexp.MustCompile(`(?i)([\w.-]*(?:` + strings.Join(quoted, "|") + `)[\w.-]*)("?\s*[:=]\s*"?)[^\s"',;&]*[^\s"',;&.:)]`)
+	}
+	return r
+}
+
+// Sensitive reports whether value of field must be masked.
+func (r *Redactor) Sensitive(field string) bool {
+	field = strings.ToLower(field)
+	for _, f := range r.fields {
+		if strings.Contains(field, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns copy of v with sensitive fields masked at any depth. Maps, slices and
+// JSON documents in []byte or json.RawMessage are walked, other values are returned as is.
+func (r *Redactor) Value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			if r.Sensitive(k) {
+				res[k] = Mask
+				continue
+			}
+			res[k] = r.Value(val)
+		}
+		return res
+	case map[string]string:
+		res := make(map[string]string, len(v))
+		for k, val := range v {
+			if r.Sensitive(k) {
+				val = Mask
+			}
+			res[k] = val
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, val := range v {
+			res[i] = r.Value(val)
+		}
+		return res
+	case json.RawMessage:
+		return json.RawMessage(r.JSON(v))
+	case []byte:
+		return r.JSON(v)
+	default:
+		return v
+	}
+}
+
+// JSON returns JSON document with sensitive fields masked, data which is not a JSON object
+// or array is returned as is.
+func (r *Redactor) JSON(data []byte) []byte {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return data
+	}
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	// numbers are kept as is, e.g. large ids do not lose precision
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return data
+	}
+	res, err := json.Marshal(r.Value(v))
+	if err != nil {
+		return data
+	}
+	return res
+}
+
+// URL masks sensitive query parameters and password of URL, invalid URLs are returned as is.
+func (r *Redactor) URL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), Mask)
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			if r.Sensitive(k) {
+				q[k] = []string{Mask}
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// Text masks URLs the way URL does and key=value or "key": "value" pairs with sensitive keys in free
+// text, e.g. in error messages.
+func (r *Redactor) Text(s string) string {
+	if r.pairs != nil {
+		s = r.pairs.ReplaceAllString(s, "${1}${2}"+Mask)
+	}
+	return urlPattern.ReplaceAllStringFunc(s, r.URL)
+}
@@ -0,0 +1,357 @@
+// Package redis is a small Redis client for state shared by replicas of service, e.g. rate limit
+// counters and cache purges. It speaks RESP2 and covers what the service needs: commands over
+// a pool of connections and subscriptions to channels.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// metrics are exposed with expvar as redis.{commands,errors,dials}.
+var metrics = expvar.NewMap("redis")
+
+// ErrNil is returned by Do when reply is nil, e.g. GET of missing key.
+var ErrNil = errors.New("redis: nil reply")
+
+// Error is an error reply of server.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+type Config struct {
+	// PoolSize is the maximum number of idle connections.
+	PoolSize int
+	// Timeout bounds dial and every command unless context has an earlier deadline.
+	Timeout time.Duration
+}
+
+// Client is safe for concurrent use.
+type Client struct {
+	addr     string
+	password string
+	db       int
+	cfg      Config
+
+	idle chan *conn
+}
+
+// New returns client of server at URL in form redis://[:password@]host[:port][/db].
+// Connections are dialed on demand.
+func New(rawurl string, cfg Config) (*Client, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Scheme != "redis" || u.Hostname() == "" {
+		return nil, errors.Errorf("invalid redis url %q, expected redis://[:password@]host[:port][/db]", rawurl)
+	}
+	c := &Client{addr: u.Host, cfg: cfg}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, errors.Errorf("invalid redis url %q, database must be a number", rawurl)
+		}
+	}
+	if c.cfg.PoolSize <= 0 {
+		c.cfg.PoolSize = 10
+	}
+	if c.cfg.Timeout <= 0 {
+		c.cfg.Timeout = time.Second
+	}
+	c.idle = make(chan *conn, c.cfg.PoolSize)
+	return c, nil
+}
+
+// Do runs command and returns its reply: string for status, int64 for integer, []byte for bulk string
+// and []interface{} for array. Error replies are returned as Error, nil reply as ErrNil.
+func (c *Client) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	metrics.Add("commands", 1)
+	cn, err := c.get(ctx)
+	if err != nil {
+		metrics.Add("errors", 1)
+		return nil, err
+	}
+	reply, err := cn.do(c.deadline(ctx), args...)
+	if err != nil {
+		metrics.Add("errors", 1)
+		if _, ok := err.(Error); !ok && err != ErrNil {
+			// connection is in unknown state after network errors
+			cn.Close()
+			return nil, errors.Wrap(err, "redis command failed")
+		}
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Close closes idle connections, connections in use are closed when they are returned.
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) deadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(c.cfg.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+	return c.dial(ctx)
+}
+
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+// dial connects, authenticates and selects database.
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	metrics.Add("dials", 1)
+	d := net.Dialer{Deadline: c.deadline(ctx)}
+	nc, err := d.Dial("tcp", c.addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not connect to redis")
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if c.password != "" {
+		if _, err := cn.do(c.deadline(ctx), "AUTH", c.password); err != nil {
+			cn.Close()
+			return nil, errors.Wrap(err, "could not authenticate to redis")
+		}
+	}
+	if c.db != 0 {
+		if _, err := cn.do(c.deadline(ctx), "SELECT", c.db); err != nil {
+			cn.Close()
+			return nil, errors.Wrap(err, "could not select redis database")
+		}
+	}
+	return cn, nil
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (cn *conn) do(deadline time.Time, args ...interface{}) (interface{}, error) {
+	cn.SetDeadline(deadline)
+	if err := cn.write(args...); err != nil {
+		return nil, err
+	}
+	return cn.read()
+}
+
+// write sends command as array of bulk strings.
+func (cn *conn) write(args ...interface{}) error {
+	fmt.Fprintf(cn.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		case int:
+			s = strconv.Itoa(v)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		default:
+			return errors.Errorf("redis: unsupported argument type %T", arg)
+		}
+		fmt.Fprintf(cn.w, "$%d\r\n%s\r\n", len(s), s)
+	}
+	return cn.w.Flush()
+}
+
+func (cn *conn) read() (interface{}, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.Errorf("redis: invalid reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, Error(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errors.Errorf("redis: invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errors.Errorf("redis: invalid array length %q", line)
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = cn.read()
+			if err != nil && err != ErrNil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, errors.Errorf("redis: invalid reply %q", line)
+}
+
+// Int converts reply of Do to int64.
+func Int(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, errors.Errorf("redis: unexpected reply %v, integer expected", reply)
+	}
+	return n, nil
+}
+
+// Subscriber receives messages of channel and calls fn with their payloads, it reconnects after
+// failures, messages published while it is disconnected are lost.
+type Subscriber struct {
+	c       *Client
+	channel string
+	fn      func(payload string)
+	retry   time.Duration
+
+	// mu guards connection, so Close can interrupt blocked read
+	mu     sync.Mutex
+	cn     *conn
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (c *Client) NewSubscriber(channel string, fn func(payload string)) *Subscriber {
+	return &Subscriber{
+		c:       c,
+		channel: channel,
+		fn:      fn,
+		retry:   time.Second,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Run receives messages until Close is called.
+func (s *Subscriber) Run(logger log.Logger) {
+	defer close(s.done)
+	for {
+		if err := s.receive(); err != nil {
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+			logger.WithError(err).WithField("channel", s.channel).Error("redis subscription failed")
+		}
+		select {
+		case <-time.After(s.retry):
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Subscriber) receive() error {
+	cn, err := s.c.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	defer cn.Close()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.cn = cn
+	s.mu.Unlock()
+
+	if _, err := cn.do(time.Now().Add(s.c.cfg.Timeout), "SUBSCRIBE", s.channel); err != nil {
+		return errors.Wrap(err, "could not subscribe")
+	}
+	cn.SetDeadline(time.Time{})
+	for {
+		reply, err := cn.read()
+		if err != nil {
+			return errors.Wrap(err, "could not receive message")
+		}
+		// messages are [message, channel, payload]
+		msg, ok := reply.([]interface{})
+		if !ok || len(msg) != 3 {
+			continue
+		}
+		if kind, _ := msg[0].([]byte); string(kind) != "message" {
+			continue
+		}
+		if payload, ok := msg[2].([]byte); ok {
+			s.fn(string(payload))
+		}
+	}
+}
+
+// Close stops Run.
+func (s *Subscriber) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	if s.cn != nil {
+		s.cn.Close()
+	}
+	s.mu.Unlock()
+	close(s.stop)
+	<-s.done
+	return nil
+}
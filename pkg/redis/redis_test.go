@@ -0,0 +1,154 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// server is a fake Redis which answers commands with handle and records them.
+type server struct {
+	ln     net.Listener
+	handle func(cmd []string, w *bufio.Writer)
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newServer(t *testing.T, handle func(cmd []string, w *bufio.Writer)) *server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{ln: ln, handle: handle}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *server) serve(c net.Conn) {
+	defer c.Close()
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		cmd := make([]string, n)
+		for i := range cmd {
+			r.ReadString('\n')
+			arg, _ := r.ReadString('\n')
+			cmd[i] = strings.TrimSuffix(arg, "\r\n")
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, strings.Join(cmd, " "))
+		s.mu.Unlock()
+		s.handle(cmd, w)
+		w.Flush()
+	}
+}
+
+func (s *server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func TestClient_Do(t *testing.T) {
+	s := newServer(t, func(cmd []string, w *bufio.Writer) {
+		switch cmd[0] {
+		case "AUTH", "SELECT":
+			w.WriteString("+OK\r\n")
+		case "INCR":
+			w.WriteString(":1\r\n")
+		case "GET":
+			w.WriteString("$-1\r\n")
+		case "MGET":
+			w.WriteString("*2\r\n$5\r\nhello\r\n$-1\r\n")
+		default:
+			w.WriteString("-ERR unknown command\r\n")
+		}
+	})
+	defer s.ln.Close()
+
+	c, err := New(fmt.Sprintf("redis://:secret@%s/2", s.ln.Addr()), Config{PoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	if n, err := Int(c.Do(ctx, "INCR", "ratelimit:a")); err != nil || n != 1 {
+		t.Errorf("unexpected reply %v %v", n, err)
+	}
+	if _, err := c.Do(ctx, "GET", "missing"); err != ErrNil {
+		t.Errorf("unexpected error of nil reply %v", err)
+	}
+	reply, err := c.Do(ctx, "MGET", "a", "b")
+	if items, ok := reply.([]interface{}); err != nil || !ok || string(items[0].([]byte)) != "hello" || items[1] != nil {
+		t.Errorf("unexpected reply %v %v", reply, err)
+	}
+	if _, err := c.Do(ctx, "FLUSHALL"); err == nil || err.Error() != "ERR unknown command" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// connection is reused, so it is authenticated once
+	want := []string{"AUTH secret", "SELECT 2", "INCR ratelimit:a", "GET missing", "MGET a b", "FLUSHALL"}
+	if got := s.Commands(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected commands %v", got)
+	}
+
+	for _, u := range []string{"http://localhost", "redis://", "redis://localhost/db"} {
+		if _, err := New(u, Config{}); err == nil {
+			t.Errorf("%s: invalid url is accepted", u)
+		}
+	}
+}
+
+func TestSubscriber(t *testing.T) {
+	s := newServer(t, func(cmd []string, w *bufio.Writer) {
+		if cmd[0] != "SUBSCRIBE" {
+			return
+		}
+		w.WriteString("*3\r\n$9\r\nsubscribe\r\n$5\r\npurge\r\n:1\r\n")
+		w.WriteString("*3\r\n$7\r\nmessage\r\n$5\r\npurge\r\n$10\r\narticle:42\r\n")
+	})
+	defer s.ln.Close()
+
+	c, err := New("redis://"+s.ln.Addr().String(), Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1)
+	sub := c.NewSubscriber("purge", func(payload string) { received <- payload })
+	go sub.Run(log.New("", "", ioutil.Discard))
+
+	select {
+	case p := <-received:
+		if p != "article:42" {
+			t.Errorf("unexpected payload %q", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message is not received")
+	}
+	if err := sub.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,59 @@
+package report
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Hook reports error log entries which carry an error, request fields set by request logger are used as event context.
+// Entries without error, e.g. completion of failed requests, are skipped to avoid duplicates.
+type Hook struct {
+	Reporter Reporter
+}
+
+func (h *Hook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	e := &Event{
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+		Extra:   make(map[string]interface{}, len(entry.Data)),
+	}
+	for k, v := range entry.Data {
+		switch k {
+		case logrus.ErrorKey:
+			if err, ok := v.(error); ok {
+				e.Err = err
+				continue
+			}
+		case "req_id":
+			e.RequestID = fmt.Sprint(v)
+		case "route":
+			e.Route = fmt.Sprint(v)
+		case "http_method":
+			e.Method = fmt.Sprint(v)
+		case "uri":
+			e.URL = fmt.Sprint(v)
+		case "user":
+			e.User = fmt.Sprint(v)
+		case "tenant":
+			e.Tenant = fmt.Sprint(v)
+		case "stack":
+			e.Stack = []byte(fmt.Sprint(v))
+		default:
+			e.Extra[k] = v
+		}
+	}
+	if e.Err == nil {
+		return nil
+	}
+	if e.Message == "" {
+		e.Message = e.Err.Error()
+	}
+	h.Reporter.Report(e)
+	return nil
+}
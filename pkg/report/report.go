@@ -0,0 +1,142 @@
+// Package report sends application errors and panics to an external error tracker.
+package report
+
+import (
+	"math/rand"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/redact"
+)
+
+// Event is an error occurrence with request context.
+type Event struct {
+	Time    time.Time
+	Level   string
+	Message string
+	Err     error
+	Stack   []byte
+
+	RequestID string
+	Route     string
+	Method    string
+	URL       string
+	User      string
+	Tenant    string
+
+	// Fingerprint groups events of the same cause in tracker, e.g. panics of the same call site,
+	// tracker groups by its own rules if it is empty.
+	Fingerprint string
+
+	// Tags index events in tracker, e.g. by pod.
+	Tags  map[string]string
+	Extra map[string]interface{}
+}
+
+// Reporter delivers events to an error tracker.
+type Reporter interface {
+	Report(e *Event)
+	// Close flushes pending events.
+	Close() error
+}
+
+// Nop discards all events, it is used when reporting is disabled.
+type Nop struct{}
+
+func (Nop) Report(e *Event) {}
+func (Nop) Close() error    { return nil }
+
+const filtered = redact.Mask
+
+// Options are applied to every event before it is delivered.
+type Options struct {
+	// SampleRate is a fraction of events to deliver, panics are always delivered.
+	SampleRate float64
+	// ScrubFields are case-insensitive substrings of extra fields and query parameters to filter out,
+	// they are filtered out of key=value pairs in messages and errors as well.
+	ScrubFields []string
+	// SendUser enables sending user and tenant identifiers.
+	SendUser bool
+	// Tags are added to every event, e.g. pod and node names, tags of event win over them.
+	Tags map[string]string
+}
+
+type filter struct {
+	next     Reporter
+	opts     Options
+	redactor *redact.Redactor
+}
+
+// WithOptions wraps reporter with sampling and PII scrubbing.
+func WithOptions(r Reporter, opts Options) Reporter {
+	return &filter{next: r, opts: opts, redactor: redact.New(opts.ScrubFields...)}
+}
+
+func (f *filter) Report(e *Event) {
+	if e.Stack == nil && f.opts.SampleRate < 1 && rand.Float64() >= f.opts.SampleRate {
+		return
+	}
+
+	ev := *e
+	if !f.opts.SendUser {
+		ev.User, ev.Tenant = "", ""
+	}
+	ev.URL = f.scrubURL(ev.URL)
+	ev.Message = f.redactor.Text(ev.Message)
+	if ev.Err != nil {
+		if msg := f.redactor.Text(ev.Err.Error()); msg != ev.Err.Error() {
+			ev.Err = &scrubbedError{msg: msg, cause: ev.Err}
+		}
+	}
+	if len(f.opts.Tags) > 0 {
+		ev.Tags = make(map[string]string, len(f.opts.Tags)+len(e.Tags))
+		for k, v := range f.opts.Tags {
+			ev.Tags[k] = v
+		}
+		for k, v := range e.Tags {
+			ev.Tags[k] = v
+		}
+	}
+	if len(ev.Extra) > 0 {
+		ev.Extra = make(map[string]interface{}, len(e.Extra))
+		for k, v := range e.Extra {
+			if f.redactor.Sensitive(k) {
+				v = filtered
+			}
+			ev.Extra[k] = v
+		}
+	}
+	f.next.Report(&ev)
+}
+
+// scrubbedError has message of error with sensitive values masked, its cause keeps type of error,
+// trackers group events by it.
+type scrubbedError struct {
+	msg   string
+	cause error
+}
+
+func (e *scrubbedError) Error() string { return e.msg }
+func (e *scrubbedError) Cause() error  { return errors.Cause(e.cause) }
+
+func (f *filter) Close() error {
+	return f.next.Close()
+}
+
+func (f *filter) scrubURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.RawQuery == "" {
+		return raw
+	}
+	q := u.Query()
+	for k := range q {
+		if f.redactor.Sensitive(k) {
+			q.Set(k, filtered)
+		}
+	}
+	u.RawQuery = q.Encode()
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,85 @@
+package report
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type recorder struct {
+	events []*Event
+}
+
+func (r *recorder) Report(e *Event) { r.events = append(r.events, e) }
+func (r *recorder) Close() error    { return nil }
+
+func TestWithOptions(t *testing.T) {
+	rec := &recorder{}
+	r := WithOptions(rec, Options{SampleRate: 0, ScrubFields: []string{"token", "password"}, Tags: map[string]string{"pod": "goapi-1", "node": "node-1"}})
+
+	r.Report(&Event{Message: "sampled out"})
+	if len(rec.events) != 0 {
+		t.Fatalf("expected event to be sampled out")
+	}
+
+	r.Report(&Event{
+		Message: "panic",
+		Stack:   []byte("stack"),
+		URL:     "http://example.com/1.0/articles?access_token=abc&page=2",
+		User:    "42",
+		Tags:    map[string]string{"node": "node-2"},
+		Extra:   map[string]interface{}{"Password": "qwerty", "title": "new"},
+	})
+	if len(rec.events) != 1 {
+		t.Fatalf("expected panic to be reported")
+	}
+
+	e := rec.events[0]
+	if e.URL != "http://example.com/1.0/articles?access_token=%5BFiltered%5D&page=2" {
+		t.Errorf("unexpected url: %v", e.URL)
+	}
+	if e.Extra["Password"] != filtered || e.Extra["title"] != "new" {
+		t.Errorf("unexpected extra: %v", e.Extra)
+	}
+	if e.Tags["pod"] != "goapi-1" || e.Tags["node"] != "node-2" {
+		t.Errorf("unexpected tags: %v", e.Tags)
+	}
+	if e.User != "" {
+		t.Errorf("expected user to be removed, got %v", e.User)
+	}
+}
+
+type authError struct{ dsn string }
+
+func (e *authError) Error() string { return "could not connect to " + e.dsn }
+
+func TestWithOptions_ScrubText(t *testing.T) {
+	rec := &recorder{}
+	r := WithOptions(rec, Options{SampleRate: 1, ScrubFields: []string{"token"}})
+
+	r.Report(&Event{
+		Message: "could not refresh token=abc",
+		Err:     errors.Wrap(&authError{dsn: "postgres://app:secret@db/app"}, "could not start"),
+	})
+	r.Report(&Event{Message: "could not publish article", Err: errors.New("timeout")})
+	if len(rec.events) != 2 {
+		t.Fatalf("unexpected events: %v", rec.events)
+	}
+
+	e := rec.events[0]
+	if e.Message != "could not refresh token=[Filtered]" {
+		t.Errorf("unexpected message: %v", e.Message)
+	}
+	if e.Err.Error() != "could not start: could not connect to postgres://app:%5BFiltered%5D@db/app" {
+		t.Errorf("unexpected error: %v", e.Err)
+	}
+	// trackers group by type of cause
+	if typ := fmt.Sprintf("%T", errors.Cause(e.Err)); typ != "*report.authError" {
+		t.Errorf("unexpected type of cause: %v", typ)
+	}
+
+	if e := rec.events[1]; e.Message != "could not publish article" || e.Err.Error() != "timeout" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
@@ -0,0 +1,179 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/ids"
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// Sentry delivers events to Sentry store API asynchronously.
+// Events are dropped when the queue is full, so reporting never blocks request handling.
+type Sentry struct {
+	storeURL    string
+	auth        string
+	environment string
+	release     string
+	ids         ids.Generator
+
+	client *http.Client
+	logger log.Logger
+	queue  chan *Event
+	wg     sync.WaitGroup
+}
+
+// NewSentry parses DSN in form https://<key>[:<secret>]@<host>/<project> and starts delivery worker.
+func NewSentry(dsn, environment, release string, logger log.Logger) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse sentry dsn")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("sentry dsn has no public key")
+	}
+	project := strings.Trim(u.Path, "/")
+	if project == "" {
+		return nil, errors.New("sentry dsn has no project id")
+	}
+
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=goapi/%s, sentry_key=%s", release, u.User.Username())
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+
+	s := &Sentry{
+		storeURL:    fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project),
+		auth:        auth,
+		environment: environment,
+		release:     release,
+		ids:         ids.Random,
+		client:      &http.Client{Timeout: 5 * time.Second},
+		logger:      logger,
+		queue:       make(chan *Event, 100),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+func (s *Sentry) Report(e *Event) {
+	select {
+	case s.queue <- e:
+	default:
+		s.logger.Warn("report: queue is full, event dropped")
+	}
+}
+
+func (s *Sentry) Close() error {
+	close(s.queue)
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Sentry) run() {
+	defer s.wg.Done()
+	for e := range s.queue {
+		if err := s.send(e); err != nil {
+			s.logger.WithError(err).Warn("report: could not send event")
+		}
+	}
+}
+
+type sentryException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sentryEvent struct {
+	EventID     string                 `json:"event_id"`
+	Timestamp   string                 `json:"timestamp"`
+	Level       string                 `json:"level"`
+	Platform    string                 `json:"platform"`
+	Message     string                 `json:"message,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
+	Release     string                 `json:"release,omitempty"`
+	Transaction string                 `json:"transaction,omitempty"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	User        map[string]string      `json:"user,omitempty"`
+	Request     map[string]string      `json:"request,omitempty"`
+	Exception   []sentryException      `json:"exception,omitempty"`
+	Fingerprint []string               `json:"fingerprint,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+}
+
+func (s *Sentry) send(e *Event) error {
+	id, err := s.ids.NewID()
+	if err != nil {
+		return errors.Wrap(err, "could not generate event id")
+	}
+
+	se := sentryEvent{
+		EventID:     id,
+		Timestamp:   e.Time.UTC().Format("2006-01-02T15:04:05"),
+		Level:       e.Level,
+		Platform:    "go",
+		Message:     e.Message,
+		Environment: s.environment,
+		Release:     s.release,
+		Transaction: e.Route,
+		Tags:        map[string]string{},
+		Extra:       e.Extra,
+	}
+	for k, v := range e.Tags {
+		se.Tags[k] = v
+	}
+	if e.RequestID != "" {
+		se.Tags["req_id"] = e.RequestID
+	}
+	if e.Tenant != "" {
+		se.Tags["tenant"] = e.Tenant
+	}
+	if e.User != "" {
+		se.User = map[string]string{"id": e.User}
+	}
+	if e.URL != "" {
+		se.Request = map[string]string{"url": e.URL, "method": e.Method}
+	}
+	if e.Err != nil {
+		se.Exception = []sentryException{{Type: fmt.Sprintf("%T", errors.Cause(e.Err)), Value: e.Err.Error()}}
+	}
+	if e.Fingerprint != "" {
+		se.Fingerprint = []string{e.Fingerprint}
+	}
+	if e.Stack != nil {
+		if se.Extra == nil {
+			se.Extra = map[string]interface{}{}
+		}
+		se.Extra["stack"] = string(e.Stack)
+	}
+
+	body, err := json.Marshal(se)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal event")
+	}
+	req, err := http.NewRequest(http.MethodPost, s.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "could not build request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "could not send event")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected response status: %v", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Package reqctx provides typed accessors for request-scoped values,
+// modules must use it instead of defining their own context keys.
+package reqctx
+
+import (
+	"context"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	tenantKey
+	apiVersionKey
+	localeKey
+	partnerKey
+	realUserKey
+)
+
+// AdminRole is granted to users who manage the service, e.g. toggle maintenance and purge caches.
+const AdminRole = "admin"
+
+// User is an authenticated user.
+type User struct {
+	ID    string
+	Roles []string
+}
+
+// HasRole reports whether user has role.
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Values is a set of request-scoped values, empty values are not set.
+type Values struct {
+	RequestID string
+	User      *User
+	// RealUser is set while User is impersonated.
+	RealUser   *User
+	Tenant     string
+	APIVersion string
+	Locale     string
+	Partner    string
+}
+
+// With sets all non-empty values, it is convenient for building contexts in tests.
+func With(ctx context.Context, v Values) context.Context {
+	if v.RequestID != "" {
+		ctx = WithRequestID(ctx, v.RequestID)
+	}
+	if v.User != nil {
+		ctx = WithUser(ctx, v.User)
+	}
+	if v.RealUser != nil {
+		ctx = WithRealUser(ctx, v.RealUser)
+	}
+	if v.Tenant != "" {
+		ctx = WithTenant(ctx, v.Tenant)
+	}
+	if v.APIVersion != "" {
+		ctx = WithAPIVersion(ctx, v.APIVersion)
+	}
+	if v.Locale != "" {
+		ctx = WithLocale(ctx, v.Locale)
+	}
+	if v.Partner != "" {
+		ctx = WithPartner(ctx, v.Partner)
+	}
+	return ctx
+}
+
+// WithRequestID uses the same key as middleware.RequestID, so both are interchangeable.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, middleware.RequestIDKey, id)
+}
+
+func GetRequestID(ctx context.Context) string {
+	return middleware.GetReqID(ctx)
+}
+
+func WithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, userKey, u)
+}
+
+// GetUser returns nil for anonymous requests.
+func GetUser(ctx context.Context) *User {
+	u, _ := ctx.Value(userKey).(*User)
+	return u
+}
+
+// WithRealUser sets user who authenticated request while it is made on behalf of another user,
+// e.g. admin impersonating a user for support.
+func WithRealUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, realUserKey, u)
+}
+
+// GetRealUser returns user who authenticated request, it is the same as GetUser unless the user is impersonated.
+func GetRealUser(ctx context.Context) *User {
+	if u, ok := ctx.Value(realUserKey).(*User); ok {
+		return u
+	}
+	return GetUser(ctx)
+}
+
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+func GetTenant(ctx context.Context) string {
+	t, _ := ctx.Value(tenantKey).(string)
+	return t
+}
+
+func WithAPIVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, apiVersionKey, version)
+}
+
+func GetAPIVersion(ctx context.Context) string {
+	v, _ := ctx.Value(apiVersionKey).(string)
+	return v
+}
+
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey, locale)
+}
+
+func GetLocale(ctx context.Context) string {
+	l, _ := ctx.Value(localeKey).(string)
+	return l
+}
+
+// WithPartner sets id of partner which signature of request is verified.
+func WithPartner(ctx context.Context, partner string) context.Context {
+	return context.WithValue(ctx, partnerKey, partner)
+}
+
+// GetPartner returns empty string for requests which are not signed by a partner.
+func GetPartner(ctx context.Context) string {
+	p, _ := ctx.Value(partnerKey).(string)
+	return p
+}
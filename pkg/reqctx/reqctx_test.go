@@ -0,0 +1,48 @@
+package reqctx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWith(t *testing.T) {
+	ctx := With(context.Background(), Values{
+		RequestID:  "req-1",
+		User:       &User{ID: "42", Roles: []string{"admin"}},
+		Tenant:     "acme",
+		APIVersion: "1.0",
+		Locale:     "ru",
+		Partner:    "acme",
+	})
+
+	if id := GetRequestID(ctx); id != "req-1" {
+		t.Errorf("unexpected request id: %v", id)
+	}
+	if u := GetUser(ctx); u == nil || u.ID != "42" || !u.HasRole("admin") {
+		t.Errorf("unexpected user: %+v", u)
+	}
+	// real user is the user unless it is impersonated
+	if u := GetRealUser(ctx); u == nil || u.ID != "42" {
+		t.Errorf("unexpected real user: %+v", u)
+	}
+	if u := GetRealUser(WithRealUser(ctx, &User{ID: "1"})); u == nil || u.ID != "1" {
+		t.Errorf("unexpected real user: %+v", u)
+	}
+	if tenant := GetTenant(ctx); tenant != "acme" {
+		t.Errorf("unexpected tenant: %v", tenant)
+	}
+	if v := GetAPIVersion(ctx); v != "1.0" {
+		t.Errorf("unexpected api version: %v", v)
+	}
+	if l := GetLocale(ctx); l != "ru" {
+		t.Errorf("unexpected locale: %v", l)
+	}
+
+	if p := GetPartner(ctx); p != "acme" {
+		t.Errorf("unexpected partner: %v", p)
+	}
+
+	if u := GetUser(context.Background()); u != nil {
+		t.Errorf("expected anonymous user, got %+v", u)
+	}
+}
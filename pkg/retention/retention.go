@@ -0,0 +1,199 @@
+// Package retention purges data older than retention period of its kind.
+//
+// Modules declare rules, e.g. usage is kept for 13 months, and a purger deletes expired rows
+// periodically in batches, so purging does not lock tables or bloat transactions.
+package retention
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/dlock"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+// metrics are exposed with expvar as retention.<rule>.deleted.
+var metrics = expvar.NewMap("retention")
+
+// Rule deletes rows of Table which Column, a timestamp or date, is older than MaxAge.
+type Rule struct {
+	Name   string
+	Table  string
+	Column string
+	// Where is an extra SQL condition, e.g. status = 'archived', it is written by module, never by users.
+	Where  string
+	MaxAge time.Duration
+}
+
+// Override changes max age of named rule, zero max age disables rule.
+type Override struct {
+	Name   string
+	MaxAge time.Duration
+}
+
+// ParseOverride parses override in form name=max-age, e.g. usage.days=8760h.
+func ParseOverride(s string) (Override, error) {
+	i := strings.LastIndexByte(s, '=')
+	if i <= 0 {
+		return Override{}, errors.Errorf("invalid retention override %q, expected name=max-age", s)
+	}
+	age, err := time.ParseDuration(s[i+1:])
+	if err != nil || age < 0 {
+		return Override{}, errors.Errorf("invalid retention override %q, max age must be non-negative duration", s)
+	}
+	return Override{Name: s[:i], MaxAge: age}, nil
+}
+
+// Apply returns rules with overrides applied, disabled rules are dropped.
+// Overrides of unknown rules are errors, so a typo does not keep data forever unnoticed.
+func Apply(rules []Rule, overrides []Override) ([]Rule, error) {
+	ages := make(map[string]time.Duration, len(overrides))
+	for _, o := range overrides {
+		ages[o.Name] = o.MaxAge
+	}
+	var res []Rule
+	for _, r := range rules {
+		if age, ok := ages[r.Name]; ok {
+			r.MaxAge = age
+			delete(ages, r.Name)
+		}
+		if r.MaxAge > 0 {
+			res = append(res, r)
+		}
+	}
+	for name := range ages {
+		return nil, errors.Errorf("unknown retention rule %s", name)
+	}
+	return res, nil
+}
+
+// Purger deletes expired rows of rules every interval.
+type Purger struct {
+	db       postgres.Querier
+	rules    []Rule
+	batch    int
+	interval time.Duration
+	clock    clock.Clock
+	// Locker lets a single replica purge at a time, every replica purges if it is nil.
+	Locker *dlock.Locker
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewPurger(db postgres.Querier, rules []Rule, batch int, interval time.Duration) *Purger {
+	return &Purger{
+		db:       db,
+		rules:    rules,
+		batch:    batch,
+		interval: interval,
+		clock:    clock.Real,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Purge deletes expired rows of every rule and returns numbers of deleted rows by rule.
+// It goes on with other rules when one fails and returns the first error.
+func (p *Purger) Purge() (map[string]int64, error) {
+	deleted := make(map[string]int64, len(p.rules))
+	var first error
+	for _, r := range p.rules {
+		n, err := p.purge(r)
+		deleted[r.Name] = n
+		metrics.Add(r.Name+".deleted", n)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return deleted, first
+}
+
+// purge deletes rows of rule batch by batch until there are no expired ones or purger is closed.
+func (p *Purger) purge(r Rule) (int64, error) {
+	table, column := pq.QuoteIdentifier(r.Table), pq.QuoteIdentifier(r.Column)
+	cond := column + " < $1"
+	if r.Where != "" {
+		cond += " AND (" + r.Where + ")"
+	}
+	// ctid addresses rows of any table, so rules do not need to know primary keys
+	query := fmt.Sprintf("DELETE FROM %s WHERE ctid IN (SELECT ctid FROM %s WHERE %s LIMIT %d);", table, table, cond, p.batch)
+	before := p.clock.Now().Add(-r.MaxAge)
+
+	var total int64
+	for {
+		res, err := p.db.Exec(query, before)
+		if err != nil {
+			return total, errors.Wrapf(err, "could not purge %s", r.Name)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, errors.Wrapf(err, "could not purge %s", r.Name)
+		}
+		total += n
+		if n < int64(p.batch) {
+			return total, nil
+		}
+		select {
+		case <-p.stop:
+			return total, nil
+		default:
+		}
+	}
+}
+
+// Run purges every interval until Close is called.
+func (p *Purger) Run(logger log.Logger) {
+	defer close(p.done)
+
+	ticker := p.clock.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			p.run(logger)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// run purges unless another replica does.
+func (p *Purger) run(logger log.Logger) {
+	if p.Locker != nil {
+		lock, err := p.Locker.TryLock(context.Background(), "retention")
+		if err == dlock.ErrNotAcquired {
+			return
+		}
+		if err != nil {
+			logger.WithError(err).Error()
+			return
+		}
+		defer lock.Unlock()
+	}
+
+	deleted, err := p.Purge()
+	if err != nil {
+		logger.WithError(err).Error()
+	}
+	for name, n := range deleted {
+		if n > 0 {
+			logger.WithField("context", "retention").Infof("%d rows of %s are purged", n, name)
+		}
+	}
+}
+
+// Close stops Run, purging is interrupted between batches.
+func (p *Purger) Close() error {
+	close(p.stop)
+	<-p.done
+	return nil
+}
@@ -0,0 +1,67 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestApply(t *testing.T) {
+	rules := []Rule{
+		{Name: "usage.days", MaxAge: time.Hour},
+		{Name: "privacy.requests", MaxAge: time.Hour},
+	}
+	overrides := []Override{{Name: "usage.days", MaxAge: 2 * time.Hour}, {Name: "privacy.requests"}}
+	res, err := Apply(rules, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].Name != "usage.days" || res[0].MaxAge != 2*time.Hour {
+		t.Errorf("unexpected rules: %+v", res)
+	}
+
+	if _, err := Apply(rules, []Override{{Name: "usage.typo"}}); err == nil {
+		t.Error("override of unknown rule is accepted")
+	}
+	for _, s := range []string{"usage.days", "=1h", "usage.days=forever", "usage.days=-1h"} {
+		if _, err := ParseOverride(s); err == nil {
+			t.Errorf("%q: invalid override is accepted", s)
+		}
+	}
+}
+
+func TestPurger_Purge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	p := NewPurger(db, []Rule{
+		{Name: "usage.days", Table: "usage", Column: "day", MaxAge: 24 * time.Hour},
+		{Name: "privacy.requests", Table: "privacy_request", Column: "finished_at", Where: "status = 'done'", MaxAge: time.Hour},
+	}, 2, time.Hour)
+	p.clock = clock.NewFake(now)
+
+	// full batch is followed by the next one
+	mock.ExpectExec(`DELETE FROM "usage" WHERE ctid IN \(SELECT ctid FROM "usage" WHERE "day" < \$1 LIMIT 2\)`).
+		WithArgs(now.Add(-24 * time.Hour)).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM "usage"`).WithArgs(now.Add(-24 * time.Hour)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM "privacy_request" WHERE ctid IN \(SELECT ctid FROM "privacy_request" WHERE "finished_at" < \$1 AND \(status = 'done'\) LIMIT 2\)`).
+		WithArgs(now.Add(-time.Hour)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	deleted, err := p.Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted["usage.days"] != 3 || deleted["privacy.requests"] != 0 {
+		t.Errorf("unexpected deleted rows: %v", deleted)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
@@ -0,0 +1,205 @@
+// Package saga runs operations of several steps which can not share a transaction, e.g. a database
+// write followed by calls to external services. When a step fails, completed steps are undone by
+// their compensations in reverse order, so the operation leaves nothing half done.
+//
+// States of steps are recorded in a Store as they change, so a failed compensation can be found
+// and finished by hand or by a job.
+package saga
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+)
+
+// metrics are exposed with expvar as saga.<name>.{completed,compensated,compensation_failed}.
+var metrics = expvar.NewMap("saga")
+
+// Step is a part of operation. Compensate undoes Do, it may be nil for steps which need no undo,
+// e.g. the last one or read-only ones. Compensations must be safe to retry.
+type Step struct {
+	Name       string
+	Do         func(ctx context.Context) error
+	Compensate func(ctx context.Context) error
+}
+
+// State of step.
+type State string
+
+const (
+	StatePending            State = "pending"
+	StateDone               State = "done"
+	StateFailed             State = "failed"
+	StateCompensated        State = "compensated"
+	StateCompensationFailed State = "compensation_failed"
+)
+
+// Record is a state of step of run.
+type Record struct {
+	// Run identifies a single run of saga.
+	Run   string
+	Saga  string
+	Step  string
+	State State
+	Error string
+	At    time.Time
+}
+
+// Store records states of steps.
+type Store interface {
+	Record(ctx context.Context, r Record) error
+}
+
+type Config struct {
+	// Store records states, they are not recorded if nil.
+	Store Store
+	// CompensationTimeout bounds compensations, which run even if context of Run is done.
+	CompensationTimeout time.Duration
+}
+
+// Saga is a sequence of steps, it is safe to run concurrently.
+type Saga struct {
+	name  string
+	steps []Step
+	cfg   Config
+	clock clock.Clock
+}
+
+func New(name string, cfg Config, steps ...Step) *Saga {
+	if cfg.CompensationTimeout <= 0 {
+		cfg.CompensationTimeout = 30 * time.Second
+	}
+	return &Saga{name: name, steps: steps, cfg: cfg, clock: clock.Real}
+}
+
+// Error is returned by Run when a step fails.
+type Error struct {
+	// Step is the failed step.
+	Step string
+	Err  error
+	// Compensations are errors of compensations by step, operation is left half done if any.
+	Compensations map[string]error
+}
+
+func (e *Error) Error() string {
+	if len(e.Compensations) > 0 {
+		return fmt.Sprintf("step %s failed: %v, %d compensations failed", e.Step, e.Err, len(e.Compensations))
+	}
+	return fmt.Sprintf("step %s failed: %v", e.Step, e.Err)
+}
+
+// Cause returns error of the failed step, so errors.Cause finds it.
+func (e *Error) Cause() error { return e.Err }
+
+// Run runs steps in order, run identifies this run in records, e.g. id of request.
+// When a step fails, compensations of completed steps run in reverse order and *Error is returned.
+// Errors of store fail the run like errors of steps.
+func (s *Saga) Run(ctx context.Context, run string) error {
+	var done []Step
+	for _, step := range s.steps {
+		err := s.record(ctx, run, step.Name, StatePending, nil)
+		if err == nil {
+			err = do(ctx, step)
+		}
+		if err != nil {
+			s.record(ctx, run, step.Name, StateFailed, err)
+			return s.compensate(run, step.Name, err, done)
+		}
+		done = append(done, step)
+		if err := s.record(ctx, run, step.Name, StateDone, nil); err != nil {
+			return s.compensate(run, step.Name, err, done)
+		}
+	}
+	metrics.Add(s.name+".completed", 1)
+	return nil
+}
+
+// do runs step, panic is a failure of step.
+func do(ctx context.Context, step Step) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic: %v", r)
+		}
+	}()
+	return step.Do(ctx)
+}
+
+func (s *Saga) compensate(run, failed string, cause error, done []Step) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.CompensationTimeout)
+	defer cancel()
+
+	sagaErr := &Error{Step: failed, Err: cause}
+	for i := len(done) - 1; i >= 0; i-- {
+		step := done[i]
+		if step.Compensate == nil {
+			continue
+		}
+		if err := undo(ctx, step); err != nil {
+			if sagaErr.Compensations == nil {
+				sagaErr.Compensations = make(map[string]error)
+			}
+			sagaErr.Compensations[step.Name] = err
+			s.record(ctx, run, step.Name, StateCompensationFailed, err)
+			continue
+		}
+		s.record(ctx, run, step.Name, StateCompensated, nil)
+	}
+	if len(sagaErr.Compensations) > 0 {
+		metrics.Add(s.name+".compensation_failed", 1)
+	} else {
+		metrics.Add(s.name+".compensated", 1)
+	}
+	return sagaErr
+}
+
+func undo(ctx context.Context, step Step) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("panic: %v", r)
+		}
+	}()
+	return step.Compensate(ctx)
+}
+
+func (s *Saga) record(ctx context.Context, run, step string, state State, err error) error {
+	if s.cfg.Store == nil {
+		return nil
+	}
+	r := Record{Run: run, Saga: s.name, Step: step, State: state, At: s.clock.Now()}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return s.cfg.Store.Record(ctx, r)
+}
+
+// MemoryStore keeps records in memory, e.g. for tests or to inspect a run.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+func (m *MemoryStore) Record(ctx context.Context, r Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = append(m.records, r)
+	return nil
+}
+
+// Records returns records of run in order.
+func (m *MemoryStore) Records(run string) []Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var records []Record
+	for _, r := range m.records {
+		if r.Run == run {
+			records = append(records, r)
+		}
+	}
+	return records
+}
@@ -0,0 +1,92 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSaga_Run(t *testing.T) {
+	var calls []string
+	step := func(name string, err error) Step {
+		return Step{
+			Name: name,
+			Do: func(ctx context.Context) error {
+				calls = append(calls, "do "+name)
+				return err
+			},
+			Compensate: func(ctx context.Context) error {
+				calls = append(calls, "undo "+name)
+				return nil
+			},
+		}
+	}
+	store := &MemoryStore{}
+	failure := errors.New("unavailable")
+	s := New("create_article", Config{Store: store},
+		step("insert", nil),
+		Step{Name: "purge", Do: func(ctx context.Context) error { calls = append(calls, "do purge"); return nil }},
+		step("index", failure),
+		step("notify", nil),
+	)
+
+	err := s.Run(context.Background(), "1")
+	sagaErr, ok := err.(*Error)
+	if !ok || sagaErr.Step != "index" || sagaErr.Err != failure || len(sagaErr.Compensations) != 0 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if want := []string{"do insert", "do purge", "do index", "undo insert"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("unexpected calls %v, want %v", calls, want)
+	}
+
+	var states []string
+	for _, r := range store.Records("1") {
+		states = append(states, r.Step+" "+string(r.State))
+	}
+	want := []string{
+		"insert pending", "insert done",
+		"purge pending", "purge done",
+		"index pending", "index failed",
+		"insert compensated",
+	}
+	if !reflect.DeepEqual(states, want) {
+		t.Errorf("unexpected records %v, want %v", states, want)
+	}
+
+	calls = nil
+	s = New("create_article", Config{}, step("insert", nil), step("notify", nil))
+	if err := s.Run(context.Background(), "2"); err != nil || len(calls) != 2 {
+		t.Errorf("unexpected error %v after calls %v", err, calls)
+	}
+}
+
+func TestSaga_RunFailedCompensation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var compensated bool
+	s := New("create_article", Config{},
+		Step{
+			Name: "insert",
+			Do:   func(ctx context.Context) error { return nil },
+			Compensate: func(ctx context.Context) error {
+				// compensations run even if request is gone
+				compensated = ctx.Err() == nil
+				return nil
+			},
+		},
+		Step{
+			Name:       "index",
+			Do:         func(ctx context.Context) error { cancel(); return nil },
+			Compensate: func(ctx context.Context) error { panic("broken") },
+		},
+		Step{Name: "notify", Do: func(ctx context.Context) error { return ctx.Err() }},
+	)
+
+	err := s.Run(ctx, "1").(*Error)
+	if err.Step != "notify" || err.Err != context.Canceled || !compensated {
+		t.Errorf("unexpected error %v, compensated %v", err, compensated)
+	}
+	if len(err.Compensations) != 1 || err.Compensations["index"] == nil {
+		t.Errorf("unexpected compensation errors %v", err.Compensations)
+	}
+}
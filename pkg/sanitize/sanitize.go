@@ -0,0 +1,275 @@
+// Package sanitize strips user-submitted HTML down to an allowlist of elements and attributes,
+// so stored content can be embedded by consumers as is.
+//
+// Disallowed elements are removed but their text is kept, except for script-like elements
+// which are removed with their content. Event handler and style attributes are always removed,
+// URL attributes are kept only when they are relative or have http, https or mailto scheme.
+// Unclosed allowed elements are closed at the end, so content can not break out of markup it is embedded in.
+package sanitize
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Policy maps allowed elements to their allowed attributes.
+type Policy map[string]map[string]bool
+
+// TextPolicy allows no elements, only text is kept.
+var TextPolicy = Policy{}
+
+// DefaultPolicy allows basic formatting, links and images.
+var DefaultPolicy = MustParsePolicy("p", "br", "hr", "b", "i", "strong", "em", "code", "pre", "blockquote",
+	"ul", "ol", "li", "h1", "h2", "h3", "h4", "h5", "h6", "a[href|title|rel]", "img[src|alt|title]")
+
+var (
+	// forbidden elements can not be allowed by policy.
+	forbidden = map[string]bool{
+		"script": true, "style": true, "iframe": true, "frame": true, "frameset": true, "object": true, "embed": true,
+		"applet": true, "base": true, "link": true, "meta": true, "form": true, "svg": true, "math": true,
+	}
+	// dropContent elements are removed with their content.
+	dropContent = map[string]bool{
+		"script": true, "style": true, "iframe": true, "object": true, "applet": true, "svg": true, "math": true,
+		"textarea": true, "title": true, "xmp": true, "noembed": true, "noframes": true, "noscript": true, "template": true,
+	}
+	void = map[string]bool{
+		"area": true, "br": true, "col": true, "hr": true, "img": true, "input": true, "source": true, "track": true, "wbr": true,
+	}
+	urlAttrs = map[string]bool{
+		"href": true, "src": true, "cite": true, "action": true, "formaction": true, "poster": true,
+		"background": true, "longdesc": true, "usemap": true, "xlink:href": true,
+	}
+)
+
+// ParsePolicy parses element specs in form name or name[attr|attr], e.g. a[href|title].
+func ParsePolicy(specs ...string) (Policy, error) {
+	p := Policy{}
+	for _, spec := range specs {
+		spec = strings.ToLower(strings.TrimSpace(spec))
+		name, attrs := spec, ""
+		if i := strings.IndexByte(spec, '['); i >= 0 {
+			if !strings.HasSuffix(spec, "]") {
+				return nil, fmt.Errorf("invalid element spec %q", spec)
+			}
+			name, attrs = spec[:i], spec[i+1:len(spec)-1]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid element spec %q", spec)
+		}
+		if forbidden[name] {
+			return nil, fmt.Errorf("element %q can not be allowed", name)
+		}
+		allowed := map[string]bool{}
+		for _, a := range strings.Split(attrs, "|") {
+			if a = strings.TrimSpace(a); a != "" {
+				allowed[a] = true
+			}
+		}
+		p[name] = allowed
+	}
+	return p, nil
+}
+
+// MustParsePolicy is like ParsePolicy but panics on error.
+func MustParsePolicy(specs ...string) Policy {
+	p, err := ParsePolicy(specs...)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Sanitize removes everything policy does not allow from s.
+func (p Policy) Sanitize(s string) string {
+	var (
+		buf  bytes.Buffer
+		open []string
+	)
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			text(&buf, s)
+			break
+		}
+		text(&buf, s[:i])
+		s = s[i:]
+
+		switch {
+		case strings.HasPrefix(s, "<!--"):
+			s = skipPast(s[4:], "-->")
+		case len(s) > 1 && (s[1] == '!' || s[1] == '?' || s[1] == '/' && (len(s) == 2 || !isLetter(s[2]))):
+			// doctype, processing instruction or bogus comment
+			s = skipPast(s[1:], ">")
+		case len(s) > 1 && (isLetter(s[1]) || s[1] == '/'):
+			t, rest, ok := parseTag(s)
+			if !ok {
+				// unterminated tag is dropped with the rest of input
+				s = ""
+				break
+			}
+			s = rest
+
+			_, allowed := p[t.name]
+			switch {
+			case !allowed:
+				if !t.closing && dropContent[t.name] {
+					s = skipPast(s, "</"+t.name)
+					s = skipPast(s, ">")
+				}
+			case t.closing:
+				for j := len(open) - 1; j >= 0; j-- {
+					if open[j] == t.name {
+						for k := len(open) - 1; k >= j; k-- {
+							buf.WriteString("</" + open[k] + ">")
+						}
+						open = open[:j]
+						break
+					}
+				}
+			default:
+				p.writeTag(&buf, t)
+				if !void[t.name] {
+					open = append(open, t.name)
+				}
+			}
+		default:
+			buf.WriteByte('<')
+			s = s[1:]
+		}
+	}
+	for j := len(open) - 1; j >= 0; j-- {
+		buf.WriteString("</" + open[j] + ">")
+	}
+	return buf.String()
+}
+
+func (p Policy) writeTag(buf *bytes.Buffer, t *tag) {
+	buf.WriteString("<" + t.name)
+	seen := map[string]bool{}
+	for _, a := range t.attrs {
+		if seen[a.name] || !p[t.name][a.name] || strings.HasPrefix(a.name, "on") || a.name == "style" {
+			continue
+		}
+		seen[a.name] = true
+		if urlAttrs[a.name] && !SafeURL(a.value) {
+			continue
+		}
+		buf.WriteString(" " + a.name + `="` + html.EscapeString(a.value) + `"`)
+	}
+	buf.WriteString(">")
+}
+
+// text writes text as is, except for a stray '<' which would start a tag together with the text following it.
+func text(buf *bytes.Buffer, s string) {
+	if s == "" {
+		return
+	}
+	if b := buf.Bytes(); len(b) > 0 && b[len(b)-1] == '<' {
+		if c := s[0]; isLetter(c) || c == '/' || c == '!' || c == '?' {
+			buf.Truncate(len(b) - 1)
+			buf.WriteString("&lt;")
+		}
+	}
+	buf.WriteString(s)
+}
+
+// SafeURL reports whether URL is relative or has http, https or mailto scheme.
+// Entities, whitespace and control characters browsers ignore in URLs are taken into account.
+func SafeURL(s string) bool {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, html.UnescapeString(s))
+	i := strings.IndexAny(s, ":/?#")
+	if i < 0 || s[i] != ':' {
+		return true
+	}
+	switch strings.ToLower(s[:i]) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+type tag struct {
+	name    string
+	closing bool
+	attrs   []attr
+}
+
+type attr struct {
+	name  string
+	value string
+}
+
+// parseTag parses tag at the start of s, ok is false when tag is not terminated.
+func parseTag(s string) (t *tag, rest string, ok bool) {
+	t = &tag{}
+	s = s[1:]
+	if s[0] == '/' {
+		t.closing = true
+		s = s[1:]
+	}
+	n := strings.IndexAny(s, " \t\n\r\f/>")
+	if n < 0 {
+		return nil, "", false
+	}
+	t.name, s = strings.ToLower(s[:n]), s[n:]
+
+	for {
+		s = strings.TrimLeft(s, " \t\n\r\f/")
+		if s == "" {
+			return nil, "", false
+		}
+		if s[0] == '>' {
+			return t, s[1:], true
+		}
+
+		n := strings.IndexAny(s[1:], " \t\n\r\f/>=") + 1
+		if n == 0 {
+			return nil, "", false
+		}
+		a := attr{name: strings.ToLower(s[:n])}
+		s = strings.TrimLeft(s[n:], " \t\n\r\f")
+		if strings.HasPrefix(s, "=") {
+			s = strings.TrimLeft(s[1:], " \t\n\r\f")
+			if s == "" {
+				return nil, "", false
+			}
+			if q := s[0]; q == '"' || q == '\'' {
+				end := strings.IndexByte(s[1:], q)
+				if end < 0 {
+					return nil, "", false
+				}
+				a.value, s = s[1:end+1], s[end+2:]
+			} else {
+				end := strings.IndexAny(s, " \t\n\r\f>")
+				if end < 0 {
+					return nil, "", false
+				}
+				a.value, s = s[:end], s[end:]
+			}
+			a.value = html.UnescapeString(a.value)
+		}
+		t.attrs = append(t.attrs, a)
+	}
+}
+
+// skipPast returns s after the first ASCII case-insensitive occurrence of sep, or empty string.
+func skipPast(s, sep string) string {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(sep)], sep) {
+			return s[i+len(sep):]
+		}
+	}
+	return ""
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,83 @@
+package sanitize
+
+import "testing"
+
+func TestSanitize_XSS(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{`<script>alert(1)</script>`, ``},
+		{`<SCRIPT SRC=//evil/xss.js></SCRIPT>`, ``},
+		{`<script/xss>alert(1)</script>ok`, `ok`},
+		{`<img src=x onerror=alert(1)>`, `<img src="x">`},
+		{`<IMG SRC=JaVaScRiPt:alert('XSS')>`, `<img>`},
+		{`<img src="jav&#x09;ascript:alert(1)">`, `<img>`},
+		{`<img src=" &#14;  javascript:alert(1)">`, `<img>`},
+		{`<a href="&#106;&#97;&#118;&#97;&#115;&#99;&#114;&#105;&#112;&#116;&#58;alert(1)">x</a>`, `<a>x</a>`},
+		{`<a href="java&NewLine;script&colon;alert(1)">x</a>`, `<a>x</a>`},
+		{`<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`, `<a>x</a>`},
+		{`<a href="vbscript:msgbox(1)">x</a>`, `<a>x</a>`},
+		{`<a href="https://example.com" onclick="alert(1)" title='t'>x</a>`, `<a href="https://example.com" title="t">x</a>`},
+		{`<img src="x" alt="a&quot; onerror=&quot;alert(1)">`, `<img src="x" alt="a&#34; onerror=&#34;alert(1)">`},
+		{`<p style="background:url(javascript:alert(1))">x</p>`, `<p>x</p>`},
+		{`<svg/onload=alert(1)>`, ``},
+		{`<svg><script>alert(1)</script></svg>ok`, `ok`},
+		{`<iframe src="javascript:alert(1)"></iframe>`, ``},
+		{`<body onload=alert(1)>x`, `x`},
+		{`<<script>script>alert(1)<</script>/script>`, `&lt;/script>`},
+		{`<scr<script>ipt>alert(1)</script>`, `ipt>alert(1)`},
+		{`<!--<script>alert(1)--><b>x</b>`, `<b>x</b>`},
+		{`<!DOCTYPE html><?xml version="1.0"?>x`, `x`},
+		{`<img src=x onerror=alert(1)//`, ``},
+		{`<a href="x>y`, ``},
+		{`<style>body{background:red}</style>x`, `x`},
+		{`<textarea><img src=x onerror=alert(1)></textarea>`, ``},
+	}
+	for _, tt := range tests {
+		if out := DefaultPolicy.Sanitize(tt.in); out != tt.out {
+			t.Errorf("%s: unexpected html: %q, want: %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		policy Policy
+		out    string
+	}{
+		{"text", "Tom & Jerry, a < b, 1 <3", TextPolicy, "Tom & Jerry, a < b, 1 <3"},
+		{"stray lt", "<<b>script>alert(1)", TextPolicy, "&lt;script>alert(1)"},
+		{"strip tags", "<b>bold</b> and <i>italic</i>", TextPolicy, "bold and italic"},
+		{"keep allowed", "<P>a<br/>b</P>", DefaultPolicy, "<p>a<br>b</p>"},
+		{"close unclosed", "<ul><li><b>x", DefaultPolicy, "<ul><li><b>x</b></li></ul>"},
+		{"stray closing", "</p></div>x", DefaultPolicy, "x"},
+		{"misnested", "<b><i>x</b>y</i>", DefaultPolicy, "<b><i>x</i></b>y"},
+		{"relative url", `<a href="/articles/1?a=1&amp;b=2#top">x</a>`, DefaultPolicy, `<a href="/articles/1?a=1&amp;b=2#top">x</a>`},
+		{"attribute not allowed", `<a href="/x" target="_blank">x</a>`, DefaultPolicy, `<a href="/x">x</a>`},
+		{"configured", `<p class="note">x</p><b>y</b>`, MustParsePolicy("p[class]"), `<p class="note">x</p>y`},
+	}
+	for _, tt := range tests {
+		if out := tt.policy.Sanitize(tt.in); out != tt.out {
+			t.Errorf("%s: unexpected html: %q, want: %q", tt.name, out, tt.out)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("a[href|title]", " IMG ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p["a"]["href"] || !p["a"]["title"] || p["img"] == nil {
+		t.Errorf("unexpected policy: %v", p)
+	}
+
+	for _, spec := range []string{"script", "a[href", "[href]"} {
+		if _, err := ParsePolicy(spec); err == nil {
+			t.Errorf("%s: error expected", spec)
+		}
+	}
+}
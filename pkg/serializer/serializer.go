@@ -0,0 +1,248 @@
+// Package serializer applies JSON field naming and time format policy to responses and requests.
+//
+// Models are always tagged in snake_case and timestamps are time.Time, which is the default policy.
+// Policy is applied to the encoded document: keys are renamed and values of timestamp fields
+// (which names end with _at) are converted. Timestamps are always written in UTC, and read
+// in any of the formats accepted by ParseTime.
+// Policy is selected by API version and can be overridden by Accept profile, e.g.
+//
+//	Accept: application/json; profile="camelCase epoch_millis"
+package serializer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+type Naming int
+
+const (
+	SnakeCase Naming = iota
+	CamelCase
+)
+
+type TimeFormat int
+
+const (
+	RFC3339 TimeFormat = iota
+	EpochMillis
+)
+
+// Policy describes how documents are serialized.
+type Policy struct {
+	Naming Naming
+	Time   TimeFormat
+}
+
+// Default policy matches models as they are tagged.
+var Default = Policy{Naming: SnakeCase, Time: RFC3339}
+
+var versionPolicies = map[string]Policy{}
+
+// SetVersionPolicy sets policy for API version, it is not safe for concurrent use and must be called on startup.
+func SetVersionPolicy(version string, p Policy) {
+	versionPolicies[version] = p
+}
+
+// PolicyFor resolves policy of request from API version and Accept profile.
+func PolicyFor(r *http.Request) Policy {
+	p, ok := versionPolicies[reqctx.GetAPIVersion(r.Context())]
+	if !ok {
+		p = Default
+	}
+
+	accept := strings.Split(r.Header.Get("Accept"), ",")[0]
+	_, params, err := mime.ParseMediaType(accept)
+	if err != nil {
+		return p
+	}
+	for _, token := range strings.Fields(strings.Replace(params["profile"], ",", " ", -1)) {
+		switch token {
+		case "snake_case":
+			p.Naming = SnakeCase
+		case "camelCase":
+			p.Naming = CamelCase
+		case "rfc3339":
+			p.Time = RFC3339
+		case "epoch_millis":
+			p.Time = EpochMillis
+		}
+	}
+	return p
+}
+
+// Respond is a drop-in replacement for render.DefaultResponder, set it to render.Respond.
+func Respond(w http.ResponseWriter, r *http.Request, v interface{}) {
+	p := PolicyFor(r)
+	if v == nil || render.GetAcceptedContentType(r) == render.ContentTypeXML {
+		render.DefaultResponder(w, r, v)
+		return
+	}
+
+	doc, err := toDocument(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, p.encode(doc, ""))
+}
+
+// Decode reads JSON request body into v, converting it from the request policy to the default one.
+// Returned errors are localizable.
+func Decode(r *http.Request, v interface{}) error {
+	var doc interface{}
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil && err != io.EOF {
+		return i18n.Errorf("request.invalid_body")
+	}
+	doc, err := PolicyFor(r).decode(doc, "")
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal request")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return i18n.Errorf("request.invalid_body")
+	}
+	return nil
+}
+
+func toDocument(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal response")
+	}
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "could not decode response")
+	}
+	return doc, nil
+}
+
+// encode converts document from the default policy, key is snake_case name of the value.
+func (p Policy) encode(v interface{}, key string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			name := k
+			if p.Naming == CamelCase {
+				name = toCamel(k)
+			}
+			res[name] = p.encode(val, k)
+		}
+		return res
+	case []interface{}:
+		for i := range v {
+			v[i] = p.encode(v[i], key)
+		}
+		return v
+	case string:
+		if !isTimeField(key) {
+			return v
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return v
+		}
+		if p.Time == EpochMillis {
+			return json.Number(strconv.FormatInt(toMillis(t), 10))
+		}
+		return FormatTime(t)
+	default:
+		return v
+	}
+}
+
+// decode converts document to the default policy, key is snake_case name of the value.
+func (p Policy) decode(v interface{}, key string) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			name := k
+			if p.Naming == CamelCase {
+				name = toSnake(k)
+			}
+			var err error
+			if res[name], err = p.decode(val, name); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	case []interface{}:
+		for i := range v {
+			var err error
+			if v[i], err = p.decode(v[i], key); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case json.Number:
+		if !isTimeField(key) {
+			return v, nil
+		}
+		ms, err := v.Int64()
+		if err != nil {
+			return nil, i18n.Errorf("request.invalid_time", v)
+		}
+		return FormatTime(fromMillis(ms)), nil
+	case string:
+		if !isTimeField(key) || v == "" {
+			return v, nil
+		}
+		t, err := ParseTime(v)
+		if err != nil {
+			return nil, err
+		}
+		return FormatTime(t), nil
+	default:
+		return v, nil
+	}
+}
+
+func isTimeField(key string) bool {
+	return strings.HasSuffix(key, "_at")
+}
+
+func toCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func toSnake(s string) string {
+	var b bytes.Buffer
+	for i, c := range s {
+		if c >= 'A' && c <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(c - 'A' + 'a')
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
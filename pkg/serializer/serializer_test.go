@@ -0,0 +1,116 @@
+package serializer
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+type model struct {
+	ArticleID string    `json:"article_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func TestRespond(t *testing.T) {
+	SetVersionPolicy("2.0", Policy{Naming: CamelCase, Time: EpochMillis})
+	defer delete(versionPolicies, "2.0")
+
+	v := &model{ArticleID: "1", CreatedAt: time.Date(2018, 1, 2, 6, 4, 5, 0, time.FixedZone("MSK", 3*60*60))}
+	tests := []struct {
+		version string
+		accept  string
+		body    string
+	}{
+		{"1.0", "", `{"article_id":"1","created_at":"2018-01-02T03:04:05Z"}`},
+		{"1.0", `application/json; profile="camelCase"`, `{"articleId":"1","createdAt":"2018-01-02T03:04:05Z"}`},
+		{"2.0", "", `{"articleId":"1","createdAt":1514862245000}`},
+		{"2.0", `application/json; profile="snake_case rfc3339"`, `{"article_id":"1","created_at":"2018-01-02T03:04:05Z"}`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r = r.WithContext(reqctx.WithAPIVersion(r.Context(), tt.version))
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		Respond(w, r, v)
+
+		if body := string(bytes.TrimSpace(w.Body.Bytes())); body != tt.body {
+			t.Errorf("%v %q: unexpected body: %v", tt.version, tt.accept, body)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	body := `{"articleId":"1","createdAt":1514862245000}`
+	r := httptest.NewRequest(http.MethodPut, "/", bytes.NewBufferString(body))
+	r.Header.Set("Accept", `application/json; profile="camelCase epoch_millis"`)
+
+	var v model
+	if err := Decode(r, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.ArticleID != "1" || !v.CreatedAt.Equal(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected model: %+v", v)
+	}
+
+	r = httptest.NewRequest(http.MethodPut, "/", bytes.NewBufferString(`{"created_at":"yesterday"}`))
+	if err := Decode(r, &v); err == nil {
+		t.Errorf("expected error for invalid timestamp")
+	}
+
+}
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		in  string
+		out time.Time
+	}{
+		{"2018-01-02T03:04:05Z", want},
+		{"2018-01-02T06:04:05+03:00", want},
+		{"2018-01-02T03:04:05", want},
+		{"2018-01-02 03:04:05", want},
+		{"1514862245000", want},
+		{"2018-01-02", time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.in, err)
+			continue
+		}
+		if !got.Equal(tt.out) || got.Location() != time.UTC {
+			t.Errorf("%q: unexpected time: %v", tt.in, got)
+		}
+	}
+
+	if _, err := ParseTime("02.01.2018"); err == nil {
+		t.Errorf("expected error for unsupported format")
+	}
+}
+
+func TestLocation(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/?tz=Europe/Moscow", nil)
+	loc, err := Location(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := Day(time.Date(2018, 1, 1, 22, 0, 0, 0, time.UTC), loc); d != "2018-01-02" {
+		t.Errorf("unexpected day: %v", d)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/?tz=Mars/Olympus", nil)
+	if _, err := Location(r); err == nil {
+		t.Errorf("expected error for unknown time zone")
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/?tz=Local", nil)
+	if _, err := Location(r); err == nil {
+		t.Errorf("expected error for local time zone")
+	}
+}
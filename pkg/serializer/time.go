@@ -0,0 +1,64 @@
+package serializer
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/i18n"
+)
+
+// inputLayouts are accepted for timestamps in requests, values without offset are treated as UTC.
+var inputLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// ParseTime parses timestamp in any of the accepted input formats or as epoch millis, result is in UTC.
+func ParseTime(s string) (time.Time, error) {
+	for _, layout := range inputLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return fromMillis(ms), nil
+	}
+	return time.Time{}, i18n.Errorf("request.invalid_time", s)
+}
+
+// FormatTime formats timestamp in canonical RFC 3339 UTC form.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Location returns time zone of ?tz= query parameter, which is used by endpoints aggregating by day.
+// UTC is returned when parameter is absent. Local is rejected, it is time zone of server, not client,
+// and database does not know it.
+func Location(r *http.Request) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil || loc == time.Local {
+		return nil, i18n.Errorf("request.invalid_tz", tz)
+	}
+	return loc, nil
+}
+
+// Day returns calendar day of timestamp in location as YYYY-MM-DD.
+func Day(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("2006-01-02")
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,169 @@
+// Package shadow mirrors a share of requests to another deployment, e.g. to test a new version
+// against production traffic. Mirroring is asynchronous and never affects primary responses:
+// requests are dropped when the queue is full, and responses of the target are discarded.
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/workerpool"
+)
+
+// Header marks mirrored requests, so target can tell them apart and e.g. skip side effects.
+const Header = "X-Shadow-Request"
+
+// metrics are exposed with expvar as shadow.{mirrored,dropped,failed}.
+var metrics = expvar.NewMap("shadow")
+
+type Config struct {
+	// Target is base URL requests are mirrored to.
+	Target string
+	// Rate is a share of requests to mirror, from 0 to 1.
+	Rate float64
+	// Timeout of mirrored requests.
+	Timeout time.Duration
+	// QueueSize is how many requests may wait to be mirrored, the rest are dropped.
+	QueueSize int
+	// Workers send mirrored requests concurrently.
+	Workers int
+	// MaxBodySize is the largest request body to mirror, requests with larger ones are not mirrored.
+	MaxBodySize int64
+}
+
+type Mirror struct {
+	cfg    Config
+	target *url.URL
+	client *http.Client
+
+	mu   sync.Mutex
+	rand *rand.Rand
+	// closed stops sampling, so requests are not copied only to be rejected by pool
+	closed bool
+
+	pool *workerpool.Pool
+	// logger is set by Run before workers start
+	logger log.Logger
+}
+
+func New(cfg Config) (*Mirror, error) {
+	target, err := url.Parse(cfg.Target)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return nil, errors.Errorf("invalid shadow target %q", cfg.Target)
+	}
+	return &Mirror{
+		cfg:    cfg,
+		target: target,
+		client: &http.Client{Timeout: cfg.Timeout},
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		pool:   workerpool.New("shadow", cfg.Workers, cfg.QueueSize),
+	}, nil
+}
+
+// Run sends mirrored requests with workers until Close is called.
+func (m *Mirror) Run(logger log.Logger) {
+	m.logger = logger
+	m.pool.Run(logger)
+}
+
+// Close stops accepting requests and waits until queued ones are sent, requests served meanwhile are
+// not mirrored.
+func (m *Mirror) Close() {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	m.pool.Close()
+}
+
+func (m *Mirror) send(req *http.Request) error {
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+func (m *Mirror) sampled() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.closed && m.rand.Float64() < m.cfg.Rate
+}
+
+// Middleware mirrors sampled requests, it must be used before request body is read.
+func (m *Mirror) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(Header) == "" && m.sampled() {
+			m.mirror(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// mirror queues a copy of request, body is buffered and replaced, so handler reads it as usual.
+func (m *Mirror) mirror(r *http.Request) {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > m.cfg.MaxBodySize {
+			return
+		}
+		buf, err := ioutil.ReadAll(io.LimitReader(r.Body, m.cfg.MaxBodySize+1))
+		// handler gets the whole body regardless of whether it is mirrored
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		if err != nil || int64(len(buf)) > m.cfg.MaxBodySize {
+			return
+		}
+		body = buf
+	}
+
+	u := *m.target
+	u.Path = singleJoiningSlash(m.target.Path, r.URL.Path)
+	u.RawQuery = r.URL.RawQuery
+	req, err := http.NewRequest(r.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	for k, v := range r.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set(Header, "true")
+	req.Header.Set("X-Forwarded-For", r.RemoteAddr)
+
+	err = m.pool.TrySubmit(func(ctx context.Context) {
+		if err := m.send(req.WithContext(ctx)); err != nil {
+			metrics.Add("failed", 1)
+			m.logger.WithError(err).Debug("could not mirror request")
+		}
+	})
+	if err != nil {
+		metrics.Add("dropped", 1)
+		return
+	}
+	metrics.Add("mirrored", 1)
+}
+
+func singleJoiningSlash(a, b string) string {
+	switch {
+	case a == "" || a == "/":
+		return b
+	case a[len(a)-1] == '/' && len(b) > 0 && b[0] == '/':
+		return a + b[1:]
+	case a[len(a)-1] != '/' && (len(b) == 0 || b[0] != '/'):
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,124 @@
+package shadow
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+func TestMiddleware(t *testing.T) {
+	type mirrored struct {
+		method, path, query, body, header string
+	}
+	got := make(chan mirrored, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		got <- mirrored{r.Method, r.URL.Path, r.URL.RawQuery, string(body), r.Header.Get(Header)}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer target.Close()
+
+	m, err := New(Config{Target: target.URL + "/v2", Rate: 1, Timeout: time.Second, QueueSize: 1, Workers: 1, MaxBodySize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go m.Run(log.New("", "", ioutil.Discard))
+	defer m.Close()
+
+	var primary string
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		primary = string(body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/1.0/articles?dry_run=1", strings.NewReader(`{"title":"a"}`)))
+	if w.Code != http.StatusCreated || primary != `{"title":"a"}` {
+		t.Errorf("primary response is affected: %d %q", w.Code, primary)
+	}
+	select {
+	case req := <-got:
+		want := mirrored{"POST", "/v2/1.0/articles", "dry_run=1", `{"title":"a"}`, "true"}
+		if req != want {
+			t.Errorf("unexpected mirrored request: %+v", req)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request is not mirrored")
+	}
+
+	// body too large to mirror is still read by handler as a whole
+	body := strings.Repeat("x", 100)
+	req := httptest.NewRequest("POST", "/1.0/articles", strings.NewReader(body))
+	req.ContentLength = -1
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if primary != body {
+		t.Errorf("unexpected body read by handler: %q", primary)
+	}
+	select {
+	case req := <-got:
+		t.Errorf("request with large body is mirrored: %+v", req)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMiddleware_Sampling(t *testing.T) {
+	m, err := New(Config{Target: "http://localhost", Rate: 0.3, QueueSize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 1000; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	if n := m.pool.Queued(); n < 200 || n > 400 {
+		t.Errorf("unexpected number of mirrored requests: %d", n)
+	}
+
+	// mirrored requests are not mirrored again, e.g. when target is the same deployment
+	m.cfg.Rate = 1
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(Header, "true")
+	n := m.pool.Queued()
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if m.pool.Queued() != n {
+		t.Error("mirrored request is mirrored again")
+	}
+}
+
+func TestMirror_Close(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	m, err := New(Config{Target: target.URL, Rate: 1, Timeout: time.Second, QueueSize: 10, Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go m.Run(log.New("", "", ioutil.Discard))
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// requests served while mirror closes must not panic
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			}
+		}()
+	}
+	m.Close()
+	wg.Wait()
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if n := m.pool.Queued(); n != 0 {
+		t.Errorf("request is mirrored after close: %d", n)
+	}
+}
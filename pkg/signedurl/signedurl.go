@@ -0,0 +1,131 @@
+// Package signedurl shares protected downloads, e.g. exports and attachments, via time-limited links
+// which are served without auth headers.
+//
+// Link is a path with query signed by HMAC-SHA256 together with its expiry and the user who shared it,
+// requests with valid signature are served as that user. Links carry user ID only, roles are not
+// granted, so resources of admins can not be shared.
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+// Query parameters of signed links.
+const (
+	ParamExpires   = "expires"
+	ParamUser      = "user"
+	ParamSignature = "signature"
+)
+
+var (
+	ErrInvalid = errors.New("signature of link is not valid")
+	ErrExpired = errors.New("link is expired")
+)
+
+type Signer struct {
+	secret []byte
+	clock  clock.Clock
+}
+
+func New(secret []byte) *Signer {
+	return &Signer{secret: secret, clock: clock.Real}
+}
+
+// Sign returns uri with expiry, user and signature appended to its query, user may be empty for links
+// to resources which need no user.
+func (s *Signer) Sign(uri, userID string, ttl time.Duration) (string, time.Time, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "could not parse uri")
+	}
+	expires := s.clock.Now().Add(ttl).Truncate(time.Second)
+
+	q := u.Query()
+	q.Del(ParamSignature)
+	q.Set(ParamExpires, strconv.FormatInt(expires.Unix(), 10))
+	if userID != "" {
+		q.Set(ParamUser, userID)
+	} else {
+		q.Del(ParamUser)
+	}
+	q.Set(ParamSignature, s.sign(u.Path, q))
+	u.RawQuery = q.Encode()
+	return u.RequestURI(), expires, nil
+}
+
+// Verify returns user of signed link, ErrInvalid if it is tampered with and ErrExpired when it is too late.
+func (s *Signer) Verify(u *url.URL) (string, error) {
+	q := u.Query()
+	signature := q.Get(ParamSignature)
+	q.Del(ParamSignature)
+	if !hmac.Equal([]byte(s.sign(u.Path, q)), []byte(signature)) {
+		return "", ErrInvalid
+	}
+	sec, err := strconv.ParseInt(q.Get(ParamExpires), 10, 64)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	if !s.clock.Now().Before(time.Unix(sec, 0)) {
+		return "", ErrExpired
+	}
+	return q.Get(ParamUser), nil
+}
+
+// sign returns hex encoded signature of path and query, query is encoded sorted by keys.
+func (s *Signer) sign(path string, q url.Values) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(path + "?" + q.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Middleware verifies requests with signature and sets user of link, requests without signature pass
+// through. Links are read-only, they are valid for GET and HEAD only.
+func (s *Signer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()[ParamSignature]; !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var userID string
+		err := ErrInvalid
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			userID, err = s.Verify(r.URL)
+		}
+		if err != nil {
+			key := "signedurl.invalid"
+			if err == ErrExpired {
+				key = "signedurl.expired"
+			}
+			locale := reqctx.GetLocale(r.Context())
+			log.GetLogEntry(r).WithField("context", "signedurl").Warn(i18n.Translate(i18n.DefaultLocale, key))
+			handler.WriteProblem(w, &handler.Problem{
+				Title:    i18n.StatusText(locale, http.StatusUnauthorized),
+				Status:   http.StatusUnauthorized,
+				Detail:   i18n.Translate(locale, key),
+				Instance: r.URL.Path,
+			})
+			return
+		}
+
+		ctx := r.Context()
+		if userID != "" {
+			ctx = reqctx.WithUser(ctx, &reqctx.User{ID: userID})
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,71 @@
+package signedurl
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/reqctx"
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(now)
+	s := New([]byte("secret"))
+	s.clock = fake
+
+	var user string
+	h := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	h.Use(s.Middleware)
+	h.HandleFunc("/*", func(w http.ResponseWriter, r *http.Request) {
+		if u := reqctx.GetUser(r.Context()); u != nil {
+			user = u.ID
+		}
+	})
+
+	link, expires, err := s.Sign("/1.0/privacy/requests/5/data?format=json", "u1", 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expires.Equal(now.Add(10 * time.Minute)) {
+		t.Errorf("unexpected expiry: %v", expires)
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		status int
+		user   string
+	}{
+		{http.MethodGet, link, http.StatusOK, "u1"},
+		{http.MethodHead, link, http.StatusOK, "u1"},
+		{http.MethodDelete, link, http.StatusUnauthorized, ""},
+		// link is bound to path, query and user
+		{http.MethodGet, strings.Replace(link, "/5/", "/6/", 1), http.StatusUnauthorized, ""},
+		{http.MethodGet, strings.Replace(link, "format=json", "format=csv", 1), http.StatusUnauthorized, ""},
+		{http.MethodGet, strings.Replace(link, "user=u1", "user=u2", 1), http.StatusUnauthorized, ""},
+		{http.MethodGet, "/1.0/privacy/requests/5/data?signature=", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/1.0/privacy/requests/5/data", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		user = ""
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, "http://example.com"+tt.url, nil))
+		if w.Code != tt.status || user != tt.user {
+			t.Errorf("%s %s: unexpected response: %v %q", tt.method, tt.url, w.Code, user)
+		}
+	}
+
+	fake.Add(10 * time.Minute)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com"+link, nil))
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "expired") {
+		t.Errorf("expired link is accepted: %v %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,67 @@
+// Package singleflight coalesces concurrent calls with the same key into one, e.g. identical
+// database queries on a cache miss during a traffic spike.
+package singleflight
+
+import (
+	"expvar"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// metrics are exposed with expvar as singleflight.<name>.{calls,coalesced}.
+var metrics = expvar.NewMap("singleflight")
+
+// ErrPanicked is returned to callers waiting for a call which panicked, the panic itself
+// goes on in the caller which made the call.
+var ErrPanicked = errors.New("coalesced call panicked")
+
+// Group keeps calls in flight by key.
+type Group struct {
+	name string
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	wg   sync.WaitGroup
+	val  interface{}
+	err  error
+	dups int
+}
+
+// New returns group, name is used in metrics.
+func New(name string) *Group {
+	return &Group{name: name, calls: make(map[string]*call)}
+}
+
+// Do calls fn unless a call with the same key is in flight, then it waits for that call and
+// returns its result. Shared reports whether result is returned to several callers, so a mutable
+// one must be copied before it is changed.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		metrics.Add(g.name+".coalesced", 1)
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+	c := &call{err: ErrPanicked}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	metrics.Add(g.name+".calls", 1)
+	defer func() {
+		// call is removed before shared is counted, so no one joins it after
+		g.mu.Lock()
+		delete(g.calls, key)
+		shared = c.dups > 0
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.val, c.err = fn()
+	return c.val, c.err, false
+}
@@ -0,0 +1,77 @@
+package singleflight
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroup_Do(t *testing.T) {
+	g := New("test")
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan bool, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := g.Do("key", fn)
+			if v != "value" || err != nil {
+				t.Errorf("unexpected result: %v %v", v, err)
+			}
+			results <- shared
+		}()
+	}
+	// callers join the call in flight
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if calls != 1 {
+		t.Errorf("fn is called %d times", calls)
+	}
+	for shared := range results {
+		if !shared {
+			t.Error("coalesced result is not reported as shared")
+		}
+	}
+
+	// finished call is not reused
+	if _, _, shared := g.Do("key", func() (interface{}, error) { return nil, nil }); shared || calls != 1 {
+		t.Errorf("unexpected call: %v %d", shared, calls)
+	}
+}
+
+func TestGroup_DoPanic(t *testing.T) {
+	g := New("test")
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		defer func() { recover() }()
+		g.Do("key", func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+	go func() {
+		_, err, _ := g.Do("key", func() (interface{}, error) { return nil, nil })
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	if err := <-done; err != ErrPanicked {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
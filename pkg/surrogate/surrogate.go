@@ -0,0 +1,356 @@
+// Package surrogate caches responses tagged with surrogate keys, e.g. article:42, and purges them
+// by key when the data they are built from changes.
+//
+// Handlers tag responses with Tag and allow caching with Control, Fastly-style Surrogate-Key and
+// Surrogate-Control headers are understood by a fronting CDN as well as by Cache, so the same
+// purge reaches both when Purgers are combined.
+package surrogate
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/cache"
+	"github.com/agalitsyn/goapi/pkg/clock"
+	"github.com/agalitsyn/goapi/pkg/handler"
+)
+
+const (
+	// KeyHeader lists space separated surrogate keys of response.
+	KeyHeader = "Surrogate-Key"
+	// ControlHeader tells caches how long they may keep response, e.g. max-age=60,
+	// it is not meant for browsers which honor Cache-Control instead.
+	ControlHeader = "Surrogate-Control"
+	// StatusHeader tells whether response is served from Cache, HIT or MISS.
+	StatusHeader = "X-Cache"
+)
+
+// metrics are exposed with expvar as surrogate.{purges,purge_errors}, hits and misses are counted by cache.
+var metrics = expvar.NewMap("surrogate")
+
+// Tag adds surrogate keys to response, it must be called before response is written.
+func Tag(w http.ResponseWriter, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	tagged := w.Header().Get(KeyHeader)
+	for _, k := range keys {
+		if tagged != "" {
+			tagged += " "
+		}
+		tagged += k
+	}
+	w.Header().Set(KeyHeader, tagged)
+}
+
+// Control allows caches to keep response for maxAge, it does nothing if maxAge is not positive.
+func Control(w http.ResponseWriter, maxAge time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+	w.Header().Set(ControlHeader, "max-age="+strconv.Itoa(int(maxAge.Seconds())))
+}
+
+// Keys returns surrogate keys of response headers.
+func Keys(h http.Header) []string {
+	return strings.Fields(h.Get(KeyHeader))
+}
+
+// maxAge parses max-age of Surrogate-Control, 0 is returned if it is absent or invalid.
+func maxAge(h http.Header) time.Duration {
+	for _, directive := range strings.Split(h.Get(ControlHeader), ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(directive, "max-age=") {
+			continue
+		}
+		sec, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+		if err != nil || sec < 0 {
+			return 0
+		}
+		return time.Duration(sec) * time.Second
+	}
+	return 0
+}
+
+// Purger invalidates cached responses tagged with any of keys.
+type Purger interface {
+	Purge(ctx context.Context, keys ...string) error
+}
+
+// Purgers purges keys with every purger, e.g. both in Cache and in CDN.
+type Purgers []Purger
+
+// Purge calls every purger even if some fail, and returns the first error.
+func (ps Purgers) Purge(ctx context.Context, keys ...string) error {
+	var first error
+	for _, p := range ps {
+		if err := p.Purge(ctx, keys...); err != nil {
+			metrics.Add("purge_errors", 1)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	metrics.Add("purges", 1)
+	return first
+}
+
+// Broadcast sends purged keys to other replicas with publish, e.g. to a pub/sub channel,
+// they purge their caches with Receive.
+type Broadcast func(ctx context.Context, payload string) error
+
+func (b Broadcast) Purge(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := b(ctx, strings.Join(keys, " ")); err != nil {
+		return errors.Wrap(err, "could not broadcast purge")
+	}
+	return nil
+}
+
+// Receive purges keys of broadcast payload from cache.
+func (c *Cache) Receive(payload string) {
+	c.Purge(context.Background(), strings.Fields(payload)...)
+}
+
+type Config struct {
+	// Size is the maximum number of cached responses.
+	Size int
+	// MaxAge caps Surrogate-Control of responses, 0 keeps them as long as they allow.
+	MaxAge time.Duration
+	// MaxBodySize is the largest response body in bytes to cache.
+	MaxBodySize int
+}
+
+// Cache keeps successful GET responses with surrogate keys and positive Surrogate-Control max-age
+// in memory. Requests with credentials are not cached, so responses are the same for all clients
+// which send the same Accept, Accept-Language and Origin.
+type Cache struct {
+	cfg     Config
+	clock   clock.Clock
+	entries *cache.Cache
+}
+
+type entry struct {
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+func New(cfg Config) *Cache {
+	return &Cache{
+		cfg:     cfg,
+		clock:   clock.Real,
+		entries: cache.New("surrogate", cache.Config{Size: cfg.Size, TTL: cfg.MaxAge}),
+	}
+}
+
+// Purge removes responses tagged with any of keys.
+func (c *Cache) Purge(ctx context.Context, keys ...string) error {
+	c.entries.Invalidate(keys...)
+	return nil
+}
+
+// Len returns number of cached responses.
+func (c *Cache) Len() int {
+	return c.entries.Len()
+}
+
+// Middleware serves cached responses and caches the cacheable ones. Cached responses are not sent
+// again to clients which have them, see handler.Fresh.
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cacheable(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := requestKey(r)
+		if v, ok := c.entries.Get(key); ok {
+			e := v.(*entry)
+			now := c.clock.Now()
+			if now.Before(e.expires) {
+				for name, values := range e.header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Age", strconv.Itoa(int(now.Sub(e.stored).Seconds())))
+				w.Header().Set(StatusHeader, "HIT")
+				// conditional requests are answered as handler would answer them
+				if handler.Fresh(r, e.header) {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.WriteHeader(e.status)
+				w.Write(e.body)
+				return
+			}
+			c.entries.Delete(key)
+		}
+
+		w.Header().Set(StatusHeader, "MISS")
+		rec := &recorder{ResponseWriter: w, upstream: clone(w.Header()), limit: c.cfg.MaxBodySize}
+		next.ServeHTTP(rec, r)
+		if rec.status != http.StatusOK || rec.overflow {
+			return
+		}
+		keys := Keys(rec.header)
+		ttl := maxAge(rec.header)
+		if c.cfg.MaxAge > 0 && ttl > c.cfg.MaxAge {
+			ttl = c.cfg.MaxAge
+		}
+		if len(keys) == 0 || ttl <= 0 || private(rec.header) {
+			return
+		}
+		now := c.clock.Now()
+		c.entries.Set(key, &entry{
+			status:  rec.status,
+			header:  rec.header,
+			body:    rec.body.Bytes(),
+			stored:  now,
+			expires: now.Add(ttl),
+		}, keys...)
+	})
+}
+
+// cacheable requests are reads without credentials which do not ask to bypass caches.
+func cacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+		return false
+	}
+	return !strings.Contains(r.Header.Get("Cache-Control"), "no-cache")
+}
+
+func private(h http.Header) bool {
+	cc := h.Get("Cache-Control")
+	return strings.Contains(cc, "private") || strings.Contains(cc, "no-store") || h.Get("Set-Cookie") != ""
+}
+
+// requestKey includes headers responses may vary by.
+func requestKey(r *http.Request) string {
+	return strings.Join([]string{
+		r.URL.RequestURI(),
+		r.Header.Get("Accept"),
+		r.Header.Get("Accept-Language"),
+		r.Header.Get("Origin"),
+	}, "\x00")
+}
+
+// recorder copies response, headers set by middlewares before Cache are not recorded,
+// since they are set again when cached response is served.
+type recorder struct {
+	http.ResponseWriter
+	upstream http.Header
+	limit    int
+
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status != 0 {
+		return
+	}
+	rec.status = status
+	rec.header = clone(rec.ResponseWriter.Header())
+	for name, values := range rec.header {
+		if name == StatusHeader || equal(values, rec.upstream[name]) {
+			delete(rec.header, name)
+		}
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.overflow {
+		if rec.body.Len()+len(b) > rec.limit {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func clone(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for name, values := range h {
+		c[name] = append([]string(nil), values...)
+	}
+	return c
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Fastly purges keys of a Fastly service.
+type Fastly struct {
+	ServiceID string
+	Token     string
+	// URL of API, https://api.fastly.com if empty.
+	URL    string
+	Client *http.Client
+}
+
+// Purge purges keys with a single batch request, Fastly accepts up to 256 keys per request.
+func (f *Fastly) Purge(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	base := f.URL
+	if base == "" {
+		base = "https://api.fastly.com"
+	}
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > 256 {
+			n = 256
+		}
+		req, err := http.NewRequest(http.MethodPost, base+"/service/"+f.ServiceID+"/purge", nil)
+		if err != nil {
+			return errors.Wrap(err, "could not build purge request")
+		}
+		req.Header.Set("Fastly-Key", f.Token)
+		req.Header.Set(KeyHeader, strings.Join(keys[:n], " "))
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return errors.Wrap(err, "could not purge CDN")
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return errors.Errorf("could not purge CDN: %s", resp.Status)
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package surrogate
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/clock"
+)
+
+func TestCache_Middleware(t *testing.T) {
+	now := time.Date(2018, 6, 15, 12, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(now)
+	c := New(Config{Size: 10, MaxBodySize: 1024})
+	c.clock = fake
+
+	calls := 0
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/articles/42":
+			Tag(w, "article:42")
+			Tag(w, "articles")
+			Control(w, time.Minute)
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Last-Modified", now.Add(-time.Hour).Format(http.TimeFormat))
+		case "/private":
+			Tag(w, "private")
+			Control(w, time.Minute)
+			w.Header().Set("Cache-Control", "private")
+		case "/untagged":
+			Control(w, time.Minute)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"42"}`))
+	}))
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		// set by middlewares before cache, it must not be replayed
+		w.Header().Set("X-Request-Id", "1")
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("/articles/42", nil); w.Header().Get(StatusHeader) != "MISS" || calls != 1 {
+		t.Errorf("unexpected response %v after %d calls", w.Header(), calls)
+	}
+	fake.Add(10 * time.Second)
+	w := get("/articles/42", nil)
+	if w.Header().Get(StatusHeader) != "HIT" || w.Header().Get("Age") != "10" || calls != 1 {
+		t.Errorf("unexpected response %v after %d calls", w.Header(), calls)
+	}
+	if w.Body.String() != `{"id":"42"}` || w.Header().Get("Content-Type") != "application/json" || w.Header().Get(KeyHeader) != "article:42 articles" {
+		t.Errorf("unexpected cached response %v %s", w.Header(), w.Body)
+	}
+
+	// cached response is validated against conditional headers
+	tests := []struct {
+		header http.Header
+		status int
+	}{
+		{http.Header{"If-None-Match": {`"v0", W/"v1"`}}, http.StatusNotModified},
+		{http.Header{"If-None-Match": {`"v0"`}}, http.StatusOK},
+		{http.Header{"If-Modified-Since": {now.Format(http.TimeFormat)}}, http.StatusNotModified},
+		{http.Header{"If-Modified-Since": {now.Add(-2 * time.Hour).Format(http.TimeFormat)}}, http.StatusOK},
+		// If-None-Match takes precedence
+		{http.Header{"If-None-Match": {`"v0"`}, "If-Modified-Since": {now.Format(http.TimeFormat)}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := get("/articles/42", tt.header)
+		if w.Code != tt.status || w.Header().Get(StatusHeader) != "HIT" || calls != 1 {
+			t.Errorf("%v: unexpected status %d of %v after %d calls", tt.header, w.Code, w.Header(), calls)
+		}
+		if tt.status == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("%v: unexpected body %s", tt.header, w.Body)
+		}
+	}
+
+	// responses differ by locale and credentials
+	get("/articles/42", http.Header{"Accept-Language": {"ru"}})
+	get("/articles/42", http.Header{"Authorization": {"Bearer token"}})
+	if calls != 3 || c.Len() != 2 {
+		t.Errorf("unexpected %d calls and %d cached responses", calls, c.Len())
+	}
+
+	get("/private", nil)
+	get("/untagged", nil)
+	if c.Len() != 2 {
+		t.Errorf("uncacheable responses are cached: %d", c.Len())
+	}
+
+	if err := c.Purge(context.Background(), "article:42"); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("/articles/42", nil); w.Header().Get(StatusHeader) != "MISS" || c.Len() != 1 {
+		t.Errorf("purged response is served: %v", w.Header())
+	}
+
+	fake.Add(time.Minute)
+	if w := get("/articles/42", nil); w.Header().Get(StatusHeader) != "MISS" {
+		t.Errorf("expired response is served: %v", w.Header())
+	}
+}
+
+type failingPurger struct{ purged []string }
+
+func (p *failingPurger) Purge(ctx context.Context, keys ...string) error {
+	p.purged = append(p.purged, keys...)
+	return errors.New("unavailable")
+}
+
+func TestFastly_Purge(t *testing.T) {
+	var keys []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/service/svc/purge" || r.Header.Get("Fastly-Key") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		keys = append(keys, r.Header.Get(KeyHeader))
+	}))
+	defer ts.Close()
+
+	failing := &failingPurger{}
+	p := Purgers{failing, &Fastly{ServiceID: "svc", Token: "secret", URL: ts.URL}}
+	if err := p.Purge(context.Background(), "article:42", "articles"); err == nil {
+		t.Error("error of purger is lost")
+	}
+	if len(keys) != 1 || keys[0] != "article:42 articles" || len(failing.purged) != 2 {
+		t.Errorf("unexpected purges %v %v", keys, failing.purged)
+	}
+
+	f := &Fastly{ServiceID: "svc", Token: "wrong", URL: ts.URL}
+	if err := f.Purge(context.Background(), "articles"); err == nil {
+		t.Error("rejected purge succeeded")
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	c := New(Config{Size: 10, MaxBodySize: 1024})
+	c.entries.Set("/articles/42", &entry{}, "article:42")
+	c.entries.Set("/articles", &entry{}, "articles")
+
+	// another replica receives what this one publishes
+	var b Broadcast = func(ctx context.Context, payload string) error {
+		c.Receive(payload)
+		return nil
+	}
+	if err := b.Purge(context.Background(), "article:42", "articles"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 0 {
+		t.Errorf("broadcast purge is not received: %d", c.Len())
+	}
+}
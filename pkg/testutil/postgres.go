@@ -0,0 +1,198 @@
+// Package testutil helps modules with end-to-end tests against a real database.
+//
+// Disposable Postgres is started in docker once per test binary, every test gets its own
+// migrated database in it. Set GAPI_TEST_POSTGRES_URL to use an existing server instead,
+// e.g. in CI with a service container. Tests are skipped when neither is available.
+//
+// Integration tests are built with integration tag, so they do not slow down unit tests:
+//
+//	// +build integration
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(testutil.Main(m))
+//	}
+//
+//	func TestArticles(t *testing.T) {
+//		db, cleanup := testutil.DB(t, article.Migrations())
+//		defer cleanup()
+//		...
+//	}
+package testutil
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/postgres"
+)
+
+// PostgresImage is run when GAPI_TEST_POSTGRES_URL is not set, it matches docker-compose.yml.
+const PostgresImage = "postgres:10"
+
+// Postgres is a server tests create databases in.
+type Postgres struct {
+	// URL of maintenance database.
+	URL string
+
+	container string
+	admin     *sql.DB
+
+	mu        sync.Mutex
+	databases int
+}
+
+var (
+	shared     *Postgres
+	sharedErr  error
+	sharedOnce sync.Once
+)
+
+// Main runs tests and stops Postgres if it was started, the result is an exit code for os.Exit.
+func Main(m *testing.M) int {
+	code := m.Run()
+	if shared != nil {
+		if err := shared.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	return code
+}
+
+// DB returns connection to a new database with migrations applied, cleanup drops it.
+// Test is skipped when Postgres is not available or in short mode.
+func DB(t testing.TB, migrations ...[]*migrate.Migration) (*sql.DB, func()) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("integration test is skipped in short mode")
+	}
+	sharedOnce.Do(func() {
+		shared, sharedErr = StartPostgres()
+	})
+	if sharedErr != nil {
+		t.Skipf("postgres is not available: %v", sharedErr)
+	}
+	db, cleanup, err := shared.Database(migrations...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, cleanup
+}
+
+// StartPostgres connects to GAPI_TEST_POSTGRES_URL or runs a container.
+func StartPostgres() (*Postgres, error) {
+	p := &Postgres{URL: os.Getenv("GAPI_TEST_POSTGRES_URL")}
+	if p.URL == "" {
+		if _, err := exec.LookPath("docker"); err != nil {
+			return nil, errors.New("docker is not found, set GAPI_TEST_POSTGRES_URL to use existing server")
+		}
+		id, err := docker("run", "-d", "--rm", "-e", "POSTGRES_PASSWORD=postgres", "-p", "127.0.0.1::5432", PostgresImage)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not run postgres container")
+		}
+		p.container = id
+		addr, err := docker("port", id, "5432/tcp")
+		if err != nil {
+			p.Close()
+			return nil, errors.Wrap(err, "could not get postgres container port")
+		}
+		p.URL = "postgres://postgres:postgres@" + addr + "/postgres?sslmode=disable"
+	}
+
+	var err error
+	p.admin, err = sql.Open("postgres", p.URL)
+	if err != nil {
+		p.Close()
+		return nil, errors.Wrap(err, "could not open database")
+	}
+	// container accepts connections a few seconds after start
+	for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(200 * time.Millisecond) {
+		if err = p.admin.Ping(); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			p.Close()
+			return nil, errors.Wrap(err, "could not connect to postgres")
+		}
+	}
+	return p, nil
+}
+
+// Database creates a new database with migrations applied, cleanup drops it.
+func (p *Postgres) Database(migrations ...[]*migrate.Migration) (*sql.DB, func(), error) {
+	p.mu.Lock()
+	p.databases++
+	name := fmt.Sprintf("test_%d_%d", os.Getpid(), p.databases)
+	p.mu.Unlock()
+
+	if _, err := p.admin.Exec("CREATE DATABASE " + name + ";"); err != nil {
+		return nil, nil, errors.Wrap(err, "could not create database")
+	}
+	drop := func() {
+		p.admin.Exec("DROP DATABASE IF EXISTS " + name + ";")
+	}
+
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		drop()
+		return nil, nil, errors.Wrap(err, "could not parse postgres url")
+	}
+	u.Path = "/" + name
+	db, err := postgres.New(u.String(), log.New("", "", ioutil.Discard), postgres.Config{MaxOpenConns: 10, MaxIdleConns: 2})
+	if err != nil {
+		drop()
+		return nil, nil, err
+	}
+	cleanup := func() {
+		db.Close()
+		drop()
+	}
+
+	var all []*migrate.Migration
+	for _, ms := range migrations {
+		all = append(all, ms...)
+	}
+	if err := db.Migrate(&migrate.MemoryMigrationSource{Migrations: all}); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return db.DB, cleanup, nil
+}
+
+// Close stops container if it was started.
+func (p *Postgres) Close() error {
+	if p.admin != nil {
+		p.admin.Close()
+	}
+	if p.container == "" {
+		return nil
+	}
+	if _, err := docker("rm", "-f", p.container); err != nil {
+		return errors.Wrap(err, "could not remove postgres container")
+	}
+	return nil
+}
+
+func docker(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Errorf("docker %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	// port command may list several addresses, e.g. for IPv4 and IPv6
+	return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0]), nil
+}
@@ -0,0 +1,71 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+
+	"github.com/agalitsyn/goapi/pkg/handler"
+	"github.com/agalitsyn/goapi/pkg/i18n"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/report"
+)
+
+// NewServer starts server with the same base middlewares as the service and routes mounted under /1.0,
+// e.g. {"/articles": article.Routes(...)}.
+func NewServer(routes map[string]http.Handler) *httptest.Server {
+	r := handler.New(handler.WithLogging(log.New("", "", ioutil.Discard)))
+	r.Use(
+		middleware.RequestID,
+		handler.Recoverer(report.Nop{}),
+		i18n.Middleware("en"),
+	)
+	r.Route("/1.0", func(r chi.Router) {
+		r.Use(handler.ApiVersion("1.0"))
+		for pattern, h := range routes {
+			r.Mount(pattern, h)
+		}
+	})
+	return httptest.NewServer(r)
+}
+
+// Do sends request with JSON body, unless it is nil, and decodes JSON response into v, unless it is nil.
+// Test fails when request could not be sent or response could not be decoded.
+func Do(t testing.TB, srv *httptest.Server, method, path string, body, v interface{}) *http.Response {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("could not encode request body: %v", err)
+		}
+	}
+	req, err := http.NewRequest(method, srv.URL+path, &buf)
+	if err != nil {
+		t.Fatalf("could not build request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: could not read response: %v", method, path, err)
+	}
+	if v != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatalf("%s %s: could not decode response %q: %v", method, path, data, err)
+		}
+	}
+	return resp
+}
@@ -0,0 +1,93 @@
+// Package totp implements time-based one-time passwords of RFC 6238 as authenticator apps generate them:
+// HMAC-SHA1 of 30 seconds steps truncated to 6 digits.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Period is duration of a step, a code is valid during its step.
+	Period = 30 * time.Second
+	Digits = 6
+	// Skew is how many steps before and after the current one are accepted, so clocks may drift.
+	Skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns random 160-bit secret in base32, as authenticator apps expect it.
+func NewSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "could not generate secret")
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns provisioning URI, which is encoded to QR code for authenticator apps to scan, see
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Step returns number of step at t.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns code of secret at step.
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", errors.Wrap(err, "invalid totp secret")
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, n%1000000), nil
+}
+
+// Validate returns step of code if it is valid at t within skew, 0 otherwise. Steps not after last
+// are not accepted, so callers keep the returned step to reject replayed codes.
+func Validate(secret, code string, t time.Time, last int64) (int64, error) {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != Digits {
+		return 0, nil
+	}
+	now := Step(t)
+	for step := now - Skew; step <= now+Skew; step++ {
+		if step <= last {
+			continue
+		}
+		c, err := Code(secret, step)
+		if err != nil {
+			return 0, err
+		}
+		if hmac.Equal([]byte(c), []byte(code)) {
+			return step, nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,74 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// secret is "12345678901234567890" of test vectors of RFC 6238, codes are the last 6 of their 8 digits.
+const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		code, err := Code(secret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("%d: expected %s, got %s", tt.unix, tt.code, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	prev, _ := Code(secret, step-1)
+	old, _ := Code(secret, step-2)
+
+	tests := []struct {
+		name string
+		code string
+		last int64
+		want int64
+	}{
+		{"current", "050471", 0, step},
+		{"spaced", "050 471", 0, step},
+		{"previous step", prev, 0, step - 1},
+		{"too old", old, 0, 0},
+		{"replayed", "050471", step, 0},
+		{"wrong", "123456", 0, 0},
+		{"too short", "50471", 0, 0},
+	}
+	for _, tt := range tests {
+		got, err := Validate(secret, tt.code, now, tt.last)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected step %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	s, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := URI("Acme Inc", "ann@example.com", s)
+	if !strings.HasPrefix(uri, "otpauth://totp/Acme%20Inc:ann@example.com?") || !strings.Contains(uri, "secret="+s) {
+		t.Errorf("unexpected uri %s", uri)
+	}
+}
@@ -0,0 +1,185 @@
+// Package unfurl fetches metadata of external pages, i.e. title, favicon and Open Graph image, so clients
+// can show rich links without fetching pages themselves. Pages are fetched only as egress policy allows.
+package unfurl
+
+import (
+	"context"
+	"expvar"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/cache"
+	"github.com/agalitsyn/goapi/pkg/egress"
+	"github.com/agalitsyn/goapi/pkg/singleflight"
+)
+
+// metrics are exposed with expvar as unfurl.{fetches,failures}.
+var metrics = expvar.NewMap("unfurl")
+
+// Meta is metadata of page, fields page has no value for are empty.
+type Meta struct {
+	URL     string `json:"url"`
+	Title   string `json:"title,omitempty"`
+	Favicon string `json:"favicon,omitempty"`
+	Image   string `json:"image,omitempty"`
+}
+
+type Config struct {
+	// Timeout of fetching a page including redirects.
+	Timeout time.Duration
+	// MaxBytes is how much of page is read, metadata is expected in head.
+	MaxBytes int64
+	// CacheSize is how many pages to keep metadata of, CacheTTL is how long.
+	CacheSize int
+	CacheTTL  time.Duration
+	UserAgent string
+	// Egress restricts pages which may be fetched, nil is the default policy.
+	Egress *egress.Policy
+}
+
+// Fetcher is safe for concurrent use.
+type Fetcher struct {
+	cfg      Config
+	client   *http.Client
+	cache    *cache.Cache
+	inflight *singleflight.Group
+}
+
+func New(cfg Config) *Fetcher {
+	return &Fetcher{
+		cfg:      cfg,
+		client:   cfg.Egress.Client(cfg.Timeout),
+		cache:    cache.New("unfurl", cache.Config{Size: cfg.CacheSize, TTL: cfg.CacheTTL}),
+		inflight: singleflight.New("unfurl"),
+	}
+}
+
+// Fetch returns metadata of page, it is cached, so repeated fetches do not hit the page.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Meta, error) {
+	v, err := f.cache.Load(rawURL, func() (interface{}, error) {
+		v, err, _ := f.inflight.Do(rawURL, func() (interface{}, error) {
+			return f.fetch(ctx, rawURL)
+		})
+		return v, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	m := *v.(*Meta)
+	return &m, nil
+}
+
+func (f *Fetcher) fetch(ctx context.Context, rawURL string) (*Meta, error) {
+	metrics.Add("fetches", 1)
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		metrics.Add("failures", 1)
+		return nil, errors.Errorf("unsupported url %q", rawURL)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		metrics.Add("failures", 1)
+		return nil, errors.Wrap(err, "could not build request")
+	}
+	req.Header.Set("Accept", "text/html")
+	if f.cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", f.cfg.UserAgent)
+	}
+	resp, err := f.client.Do(req.WithContext(ctx))
+	if err != nil {
+		metrics.Add("failures", 1)
+		return nil, errors.Wrapf(err, "could not fetch %s", rawURL)
+	}
+	defer resp.Body.Close()
+	defer io.Copy(ioutil.Discard, io.LimitReader(resp.Body, f.cfg.MaxBytes))
+
+	if resp.StatusCode != http.StatusOK {
+		metrics.Add("failures", 1)
+		return nil, errors.Errorf("%s responded with %s", rawURL, resp.Status)
+	}
+	m := &Meta{URL: rawURL}
+	if ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); ct != "text/html" && ct != "application/xhtml+xml" {
+		// not a page, e.g. image, which has nothing to unfurl
+		return m, nil
+	}
+	page, err := ioutil.ReadAll(io.LimitReader(resp.Body, f.cfg.MaxBytes))
+	if err != nil {
+		metrics.Add("failures", 1)
+		return nil, errors.Wrapf(err, "could not read %s", rawURL)
+	}
+	parse(m, resp.Request.URL, string(page))
+	return m, nil
+}
+
+var (
+	headEndRe = regexp.MustCompile(`(?i)</head>`)
+	titleRe   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	tagRe     = regexp.MustCompile(`(?is)<(meta|link)\s([^>]*)>`)
+	attrRe    = regexp.MustCompile(`(?s)([\w:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// parse fills meta from tags of page, relative URLs are resolved against base.
+func parse(m *Meta, base *url.URL, page string) {
+	if loc := headEndRe.FindStringIndex(page); loc != nil {
+		page = page[:loc[0]]
+	}
+	if match := titleRe.FindStringSubmatch(page); match != nil {
+		m.Title = strings.Join(strings.Fields(html.UnescapeString(match[1])), " ")
+	}
+	for _, tag := range tagRe.FindAllStringSubmatch(page, -1) {
+		attrs := make(map[string]string)
+		for _, a := range attrRe.FindAllStringSubmatch(tag[2], -1) {
+			attrs[strings.ToLower(a[1])] = html.UnescapeString(a[2] + a[3] + a[4])
+		}
+		switch strings.ToLower(tag[1]) {
+		case "meta":
+			name := attrs["property"]
+			if name == "" {
+				name = attrs["name"]
+			}
+			switch strings.ToLower(name) {
+			case "og:title":
+				if t := strings.TrimSpace(attrs["content"]); t != "" {
+					m.Title = t
+				}
+			case "og:image", "og:image:url":
+				if m.Image == "" {
+					m.Image = resolve(base, attrs["content"])
+				}
+			}
+		case "link":
+			for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+				if rel == "icon" && m.Favicon == "" {
+					m.Favicon = resolve(base, attrs["href"])
+				}
+			}
+		}
+	}
+	if m.Favicon == "" {
+		m.Favicon = resolve(base, "/favicon.ico")
+	}
+}
+
+// resolve returns absolute http(s) URL of ref, it is empty for other schemes.
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	u, err := url.Parse(ref)
+	if err != nil || ref == "" {
+		return ""
+	}
+	u = base.ResolveReference(u)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
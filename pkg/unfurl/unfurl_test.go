@@ -0,0 +1,68 @@
+package unfurl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/egress"
+)
+
+func TestFetcher_Fetch(t *testing.T) {
+	var fetches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head>
+<title>Fallback</title>
+<meta property="og:title" content="News &amp; views">
+<meta property='og:image' content='/cover.png'>
+<link rel="shortcut icon" href="https://cdn.example.com/icon.ico">
+</head><body><meta property="og:image" content="/body.png"></body></html>`))
+	}))
+	defer srv.Close()
+
+	policy, err := egress.New(egress.Config{AllowNetworks: []string{"127.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := New(Config{Timeout: time.Second, MaxBytes: 1 << 16, CacheSize: 10, Egress: policy})
+
+	pageURL := srv.URL + "/post"
+	for i := 0; i < 2; i++ {
+		m, err := f.Fetch(context.Background(), pageURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Meta{
+			URL:     pageURL,
+			Title:   "News & views",
+			Image:   srv.URL + "/cover.png",
+			Favicon: "https://cdn.example.com/icon.ico",
+		}
+		if *m != want {
+			t.Errorf("unexpected meta: %+v", m)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("page is fetched %d times, cached meta is expected", fetches)
+	}
+}
+
+func TestFetcher_Private(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("private address is fetched")
+	}))
+	defer srv.Close()
+
+	f := New(Config{Timeout: time.Second, MaxBytes: 1 << 16})
+	_, err := f.Fetch(context.Background(), srv.URL)
+	if uerr, ok := errors.Cause(err).(*url.Error); !ok || errors.Cause(uerr.Err) != egress.ErrBlocked {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
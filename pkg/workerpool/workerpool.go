@@ -0,0 +1,188 @@
+// Package workerpool runs tasks with a bounded number of goroutines instead of a goroutine per task.
+//
+// Pool is a job: Run starts workers and blocks until Close. A panicking task is recovered and logged
+// with its stack, so it takes neither the worker nor the service down. Tasks get context of pool,
+// which is cancelled when Shutdown runs out of time, so long tasks can give up.
+package workerpool
+
+import (
+	"context"
+	"expvar"
+	"runtime/debug"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+// metrics are exposed with expvar as workerpool.<name>.{submitted,rejected,completed,panicked,running}.
+var metrics = expvar.NewMap("workerpool")
+
+// Errors of submitting tasks.
+var (
+	ErrClosed = errors.New("pool is closed")
+	ErrFull   = errors.New("pool queue is full")
+)
+
+// Task is a unit of work, ctx is cancelled when pool is shut down forcibly.
+type Task func(ctx context.Context)
+
+// Pool runs tasks with Size workers, up to Queue tasks wait for a free worker.
+type Pool struct {
+	name  string
+	size  int
+	tasks chan Task
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	closed  bool
+	pending sync.WaitGroup
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns pool of size workers, it runs tasks once Run is called.
+func New(name string, size, queue int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pool{
+		name:   name,
+		size:   size,
+		tasks:  make(chan Task, queue),
+		ctx:    ctx,
+		cancel: cancel,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Submit queues task, it blocks while queue is full until ctx is done.
+func (p *Pool) Submit(ctx context.Context, t Task) error {
+	if err := p.add(); err != nil {
+		return err
+	}
+	select {
+	case p.tasks <- t:
+		metrics.Add(p.name+".submitted", 1)
+		return nil
+	case <-ctx.Done():
+		p.pending.Done()
+		metrics.Add(p.name+".rejected", 1)
+		return ctx.Err()
+	case <-p.ctx.Done():
+		p.pending.Done()
+		metrics.Add(p.name+".rejected", 1)
+		return ErrClosed
+	}
+}
+
+// TrySubmit queues task unless queue is full.
+func (p *Pool) TrySubmit(t Task) error {
+	if err := p.add(); err != nil {
+		return err
+	}
+	select {
+	case p.tasks <- t:
+		metrics.Add(p.name+".submitted", 1)
+		return nil
+	default:
+		p.pending.Done()
+		metrics.Add(p.name+".rejected", 1)
+		return ErrFull
+	}
+}
+
+func (p *Pool) add() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		metrics.Add(p.name+".rejected", 1)
+		return ErrClosed
+	}
+	p.pending.Add(1)
+	return nil
+}
+
+// Queued returns how many tasks wait for a free worker.
+func (p *Pool) Queued() int {
+	return len(p.tasks)
+}
+
+// Run runs tasks with workers until Close is called.
+func (p *Pool) Run(logger log.Logger) {
+	defer close(p.done)
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.size; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case t := <-p.tasks:
+					p.run(t, logger)
+				case <-p.stop:
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Pool) run(t Task, logger log.Logger) {
+	metrics.Add(p.name+".running", 1)
+	defer func() {
+		if r := recover(); r != nil {
+			metrics.Add(p.name+".panicked", 1)
+			logger.WithField("context", "workerpool").WithField("pool", p.name).Errorf("task panicked: %v\n%s", r, debug.Stack())
+		} else {
+			metrics.Add(p.name+".completed", 1)
+		}
+		metrics.Add(p.name+".running", -1)
+		p.pending.Done()
+	}()
+	t(p.ctx)
+}
+
+// Close stops accepting tasks and waits until queued ones are done, Run must be running.
+func (p *Pool) Close() error {
+	return p.Shutdown(context.Background())
+}
+
+// Shutdown stops accepting tasks and waits until queued ones are done. When ctx is done first,
+// context of tasks is cancelled, queued tasks are still run with it, and ctx error is returned.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.done
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		p.pending.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		p.cancel()
+		<-drained
+	}
+	p.cancel()
+	close(p.stop)
+	<-p.done
+	return err
+}
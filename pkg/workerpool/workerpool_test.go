@@ -0,0 +1,95 @@
+package workerpool
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agalitsyn/goapi/pkg/log"
+)
+
+func TestPool(t *testing.T) {
+	var buf bytes.Buffer
+	p := New("test", 2, 1)
+	go p.Run(log.New("text", "error", &buf))
+
+	var (
+		mu      sync.Mutex
+		running int
+		max     int
+		done    int
+	)
+	release := make(chan struct{})
+	task := func(ctx context.Context) {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		done++
+		mu.Unlock()
+	}
+	for i := 0; i < 3; i++ {
+		if err := p.Submit(context.Background(), task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// two tasks run and one is queued, so the queue is full
+	time.Sleep(10 * time.Millisecond)
+	if err := p.TrySubmit(task); err != ErrFull {
+		t.Errorf("unexpected error of full queue: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Submit(ctx, task); err != context.DeadlineExceeded {
+		t.Errorf("unexpected error of full queue: %v", err)
+	}
+
+	close(release)
+	if err := p.Submit(context.Background(), func(ctx context.Context) { panic("boom") }); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if max != 2 || done != 3 {
+		t.Errorf("unexpected tasks: max %d running, %d done", max, done)
+	}
+	if !strings.Contains(buf.String(), "task panicked: boom") {
+		t.Errorf("panic is not logged: %s", buf.String())
+	}
+	if err := p.TrySubmit(task); err != ErrClosed {
+		t.Errorf("task is accepted after close: %v", err)
+	}
+}
+
+func TestPool_Shutdown(t *testing.T) {
+	p := New("test", 1, 0)
+	go p.Run(log.New("", "", &bytes.Buffer{}))
+
+	cancelled := make(chan struct{})
+	if err := p.Submit(context.Background(), func(ctx context.Context) {
+		<-ctx.Done()
+		close(cancelled)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("unexpected error: %v", err)
+	}
+	select {
+	case <-cancelled:
+	default:
+		t.Error("task is not cancelled")
+	}
+}
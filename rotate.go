@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/agalitsyn/goapi/internal/app"
+	"github.com/agalitsyn/goapi/pkg/crypto"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/module"
+)
+
+// rotateKeysCommand re-encrypts columns of modules with the primary encryption key, e.g. after adding a new key:
+//
+//	goapi --encryption-key 2018-07:... --encryption-key 2018-06:... rotate-keys
+//
+// Old key may be removed from config when the command succeeds.
+type rotateKeysCommand struct {
+	// cfg is set before parsing, database and encryption options are shared with the service
+	cfg *cliFlags
+}
+
+func (c *rotateKeysCommand) Execute(args []string) error {
+	keys, err := app.NewKeyring(&c.cfg.Config)
+	if err != nil {
+		return err
+	}
+	if keys == nil {
+		return errors.New("no encryption keys configured")
+	}
+
+	logger := log.New(c.cfg.Log.Format, c.cfg.Log.Level, os.Stderr)
+	db, err := app.ConnectDatabase(&c.cfg.Config, logger, module.All())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, col := range module.EncryptedColumns(module.All()) {
+		n, err := crypto.RotateColumn(db.DB, keys, col)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s: %d values re-encrypted\n", col, n)
+	}
+	return nil
+}
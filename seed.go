@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/agalitsyn/goapi/internal/app"
+	"github.com/agalitsyn/goapi/internal/seed"
+	"github.com/agalitsyn/goapi/pkg/log"
+	"github.com/agalitsyn/goapi/pkg/module"
+)
+
+// seedCommand loads fixtures of environment into database configured with the service options, e.g.
+//
+//	goapi --postgres-url postgres://... seed --env development
+type seedCommand struct {
+	Path string `long:"path" env:"GAPI_FIXTURES_PATH" default:"fixtures" description:"Path to fixtures folder with a subfolder per environment."`
+	Env  string `long:"env" env:"GAPI_FIXTURES_ENV" default:"development" description:"Environment which fixtures to load."`
+
+	// cfg is set before parsing, database options are shared with the service
+	cfg *cliFlags
+}
+
+func (c *seedCommand) Execute(args []string) error {
+	fixtures, err := seed.Load(c.Path, c.Env)
+	if err != nil {
+		return err
+	}
+
+	logger := log.New(c.cfg.Log.Format, c.cfg.Log.Level, os.Stderr)
+	db, err := app.ConnectDatabase(&c.cfg.Config, logger, module.All())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	res, err := seed.Apply(db.DB, fixtures)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "loaded %s fixtures: %d saved, %d unchanged\n", c.Env, res.Saved, res.Unchanged)
+	return nil
+}